@@ -0,0 +1,291 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/infrmods/xbus/logging"
+)
+
+// Rule alert rule, fires when samples of Kind matching Match stay below
+// the threshold for For, the threshold of duration valued kinds like
+// cert_expiry is Within, e.g.
+//
+//	{kind: endpoints, match: "^payments\\.", below: 2, for: 5m}
+//	{kind: cert_expiry, within: 168h}
+type Rule struct {
+	Name     string
+	Kind     string
+	Match    string
+	Below    float64
+	Within   time.Duration
+	For      time.Duration
+	Severity string
+	matchR   *regexp.Regexp
+}
+
+func (rule *Rule) threshold() float64 {
+	if rule.Within > 0 {
+		return rule.Within.Seconds()
+	}
+	return rule.Below
+}
+
+// Route notification route, notifications of rules matching Rules
+// and of Severity (all if empty) are posted to URL
+type Route struct {
+	Rules    string
+	Severity string
+	URL      string
+	rulesR   *regexp.Regexp
+}
+
+func (route *Route) matches(rule *Rule) bool {
+	if route.rulesR != nil && !route.rulesR.MatchString(rule.Name) {
+		return false
+	}
+	return route.Severity == "" || route.Severity == rule.Severity
+}
+
+// Config alerting config
+type Config struct {
+	Interval time.Duration `default:"30s"`
+	Rules    []Rule
+	Routes   []Route
+}
+
+func (config *Config) prepare() error {
+	if config.Interval <= 0 {
+		config.Interval = 30 * time.Second
+	}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%s-%d", rule.Kind, i)
+		}
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+		r, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return fmt.Errorf("invalid alert rule(%s) match: %s", rule.Name, rule.Match)
+		}
+		rule.matchR = r
+	}
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if route.URL == "" {
+			return fmt.Errorf("missing url of alert route %d", i)
+		}
+		if route.Rules != "" {
+			r, err := regexp.Compile(route.Rules)
+			if err != nil {
+				return fmt.Errorf("invalid alert route rules: %s", route.Rules)
+			}
+			route.rulesR = r
+		}
+	}
+	return nil
+}
+
+// Sample value of a subject, e.g. endpoints count of a service zone
+type Sample struct {
+	Subject string
+	Value   float64
+}
+
+// Source samples of a rule kind
+type Source interface {
+	Samples(ctx context.Context) ([]Sample, error)
+}
+
+// SourceFunc func as Source
+type SourceFunc func(ctx context.Context) ([]Sample, error)
+
+// Samples impl Source
+func (f SourceFunc) Samples(ctx context.Context) ([]Sample, error) {
+	return f(ctx)
+}
+
+// Notification alert notification
+type Notification struct {
+	Status    string    `json:"status"`
+	Rule      string    `json:"rule"`
+	Kind      string    `json:"kind"`
+	Severity  string    `json:"severity"`
+	Subject   string    `json:"subject"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Since     time.Time `json:"since"`
+	Message   string    `json:"message"`
+}
+
+// notification statuses
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+type alertKey struct {
+	rule    string
+	subject string
+}
+
+type alertState struct {
+	since  time.Time
+	value  float64
+	firing bool
+}
+
+// Engine alerting rules engine
+type Engine struct {
+	config  Config
+	sources map[string]Source
+	states  map[alertKey]*alertState
+	client  http.Client
+}
+
+// NewEngine new alerting engine
+func NewEngine(config *Config) (*Engine, error) {
+	if err := config.prepare(); err != nil {
+		return nil, err
+	}
+	return &Engine{
+		config:  *config,
+		sources: make(map[string]Source),
+		states:  make(map[alertKey]*alertState),
+		client:  http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// RegisterSource register samples source of kind
+func (engine *Engine) RegisterSource(kind string, source Source) {
+	engine.sources[kind] = source
+}
+
+// Run evaluate rules every interval until ctx done
+func (engine *Engine) Run(ctx context.Context) {
+	if len(engine.config.Rules) == 0 {
+		return
+	}
+	for i := range engine.config.Rules {
+		if _, ok := engine.sources[engine.config.Rules[i].Kind]; !ok {
+			logging.Warningf("alert rule(%s) has unknown kind: %s", engine.config.Rules[i].Name, engine.config.Rules[i].Kind)
+		}
+	}
+	ticker := time.NewTicker(engine.config.Interval)
+	defer ticker.Stop()
+	for {
+		engine.evaluate(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (engine *Engine) hasRules(kind string) bool {
+	for i := range engine.config.Rules {
+		if engine.config.Rules[i].Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (engine *Engine) evaluate(ctx context.Context, now time.Time) {
+	samplesOf := make(map[string][]Sample)
+	for kind, source := range engine.sources {
+		if !engine.hasRules(kind) {
+			// sources may be costly, e.g. lease ttls
+			continue
+		}
+		samples, err := source.Samples(ctx)
+		if err != nil {
+			// keep states as is, a failed source shouldn't resolve alerts
+			logging.Warningf("get %s samples fail: %v", kind, err)
+			continue
+		}
+		samplesOf[kind] = samples
+	}
+	for i := range engine.config.Rules {
+		rule := &engine.config.Rules[i]
+		samples, ok := samplesOf[rule.Kind]
+		if !ok {
+			continue
+		}
+		active := make(map[alertKey]bool)
+		for _, sample := range samples {
+			if !rule.matchR.MatchString(sample.Subject) || sample.Value >= rule.threshold() {
+				continue
+			}
+			key := alertKey{rule: rule.Name, subject: sample.Subject}
+			active[key] = true
+			state := engine.states[key]
+			if state == nil {
+				state = &alertState{since: now}
+				engine.states[key] = state
+			}
+			state.value = sample.Value
+			if !state.firing && now.Sub(state.since) >= rule.For {
+				state.firing = true
+				engine.notify(rule, sample.Subject, state, StatusFiring)
+			}
+		}
+		for key, state := range engine.states {
+			if key.rule != rule.Name || active[key] {
+				continue
+			}
+			if state.firing {
+				engine.notify(rule, key.subject, state, StatusResolved)
+			}
+			delete(engine.states, key)
+		}
+	}
+}
+
+func (engine *Engine) notify(rule *Rule, subject string, state *alertState, status string) {
+	n := Notification{
+		Status:    status,
+		Rule:      rule.Name,
+		Kind:      rule.Kind,
+		Severity:  rule.Severity,
+		Subject:   subject,
+		Value:     state.value,
+		Threshold: rule.threshold(),
+		Since:     state.since,
+	}
+	if status == StatusResolved {
+		n.Message = fmt.Sprintf("%s %s recovered", rule.Kind, subject)
+	} else if rule.Within > 0 {
+		n.Message = fmt.Sprintf("%s %s: %v < %v", rule.Kind, subject,
+			time.Duration(state.value)*time.Second, rule.Within)
+	} else {
+		n.Message = fmt.Sprintf("%s %s: %v < %v", rule.Kind, subject, state.value, rule.Below)
+	}
+	logging.Infof("alert %s(%s) %s: %s", rule.Name, rule.Severity, status, n.Message)
+
+	data, err := json.Marshal(&n)
+	if err != nil {
+		logging.Errorf("marshal alert notification fail: %v", err)
+		return
+	}
+	for i := range engine.config.Routes {
+		route := &engine.config.Routes[i]
+		if !route.matches(rule) {
+			continue
+		}
+		resp, err := engine.client.Post(route.URL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			logging.Warningf("notify alert to %s fail: %v", route.URL, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package alerts
+
+import (
+	"context"
+	"time"
+
+	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/services"
+	"github.com/infrmods/xbus/utils"
+)
+
+// source kinds
+const (
+	KindEndpoints  = "endpoints"
+	KindCertExpiry = "cert_expiry"
+	KindLeaseTTL   = "lease_ttl"
+	KindFlapping   = "flapping"
+
+	KindServiceEndpoints = "service_endpoints"
+)
+
+// EndpointsSource endpoints counts of service zones, subject is `service/zone`
+func EndpointsSource(ctrl *services.ServiceCtrl) Source {
+	return SourceFunc(func(ctx context.Context) ([]Sample, error) {
+		counts, err := ctrl.EndpointCounts(ctx)
+		if err != nil {
+			return nil, err
+		}
+		samples := make([]Sample, 0, len(counts))
+		for _, count := range counts {
+			samples = append(samples, Sample{Subject: count.Service + "/" + count.Zone, Value: float64(count.Endpoints)})
+		}
+		return samples, nil
+	})
+}
+
+// ServiceEndpointsSource endpoints counts of services in all zones, subject is the service
+func ServiceEndpointsSource(ctrl *services.ServiceCtrl) Source {
+	return SourceFunc(func(ctx context.Context) ([]Sample, error) {
+		counts, err := ctrl.EndpointCounts(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var samples []Sample
+		index := make(map[string]int)
+		for _, count := range counts {
+			i, ok := index[count.Service]
+			if !ok {
+				i = len(samples)
+				index[count.Service] = i
+				samples = append(samples, Sample{Subject: count.Service})
+			}
+			samples[i].Value += float64(count.Endpoints)
+		}
+		return samples, nil
+	})
+}
+
+// CertExpirySource seconds until app certs expire, subject is the app name
+func CertExpirySource(ctrl *apps.AppCtrl) Source {
+	return SourceFunc(func(ctx context.Context) ([]Sample, error) {
+		const pageSize = 200
+		var samples []Sample
+		now := time.Now()
+		for skip := 0; ; skip += pageSize {
+			list, err := ctrl.ListApp(skip, pageSize)
+			if err != nil {
+				return nil, err
+			}
+			for i := range list {
+				app := &list[i]
+				if app.Status != utils.StatusOk {
+					continue
+				}
+				cert, err := app.Certificate()
+				if err != nil {
+					continue
+				}
+				samples = append(samples, Sample{Subject: app.Name, Value: cert.NotAfter.Sub(now).Seconds()})
+			}
+			if len(list) < pageSize {
+				return samples, nil
+			}
+		}
+	})
+}
+
+// LeaseTTLSource seconds until leases of service endpoints expire without refresh,
+// subject is `service/zone/address`
+func LeaseTTLSource(ctrl *services.ServiceCtrl) Source {
+	return SourceFunc(func(ctx context.Context) ([]Sample, error) {
+		leases, err := ctrl.LeaseTTLs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var samples []Sample
+		for _, lease := range leases {
+			for _, node := range lease.Nodes {
+				samples = append(samples, Sample{Subject: node.Service + "/" + node.Zone + "/" + node.Address,
+					Value: float64(lease.TTL)})
+			}
+		}
+		return samples, nil
+	})
+}
+
+// FlappingSource seconds since the last plug or unplug of flapping endpoints,
+// subject is `service/zone/address`, e.g. {kind: flapping, within: 1h} fires for all of them
+func FlappingSource(ctrl *services.ServiceCtrl) Source {
+	return SourceFunc(func(ctx context.Context) ([]Sample, error) {
+		now := time.Now()
+		var samples []Sample
+		for _, endpoint := range ctrl.FlappingEndpoints("") {
+			samples = append(samples, Sample{Subject: endpoint.Service + "/" + endpoint.Zone + "/" + endpoint.Address,
+				Value: now.Sub(endpoint.LastChange).Seconds()})
+		}
+		return samples, nil
+	})
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"time"
+
+	"github.com/infrmods/xbus/services"
+	"github.com/labstack/echo/v4"
+)
+
+// adminOnly middleware of admin apis
+func (server *Server) adminOnly(h echo.HandlerFunc) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		if ok, err := server.checkAdminPerm(c); !ok {
+			return err
+		}
+		return h(c)
+	})
+}
+
+func (server *Server) registerAdminAPIs(g *echo.Group) {
+	plug, query := server.newRateLimit(opPlug), server.newRateLimit(opQuery)
+	g.Use(server.adminOnly)
+	g.GET("/leases", echo.HandlerFunc(server.adminListLeases), query)
+	g.GET("/leases/:id", echo.HandlerFunc(server.adminGetLease), query)
+	g.DELETE("/leases/:id", echo.HandlerFunc(server.adminRevokeLease), plug)
+	g.GET("/service-keys/:service", echo.HandlerFunc(server.adminServiceKeys), query)
+	g.DELETE("/endpoints/:service/:zone/:addr", echo.HandlerFunc(server.adminDeleteEndpoint), plug)
+	g.GET("/orphans", echo.HandlerFunc(server.adminFindOrphans), query)
+	g.GET("/flapping-endpoints", echo.HandlerFunc(server.adminFlappingEndpoints), query)
+	g.GET("/maintenance", echo.HandlerFunc(server.adminListMaintenance), query)
+	g.PUT("/maintenance/:service", echo.HandlerFunc(server.adminSetMaintenance), plug)
+	g.DELETE("/maintenance/:service", echo.HandlerFunc(server.adminDeleteMaintenance), plug)
+	g.GET("/tombstones", echo.HandlerFunc(server.adminListTombstones), query)
+	g.POST("/tombstones/:service/undelete", echo.HandlerFunc(server.adminUndelete), plug)
+	g.POST("/secrets/rewrap", echo.HandlerFunc(server.adminRewrapSecrets), plug)
+	g.POST("/reload", echo.HandlerFunc(server.adminReload), plug)
+}
+
+// adminFlappingEndpoints endpoints of all services flagged as flapping by this server
+func (server *Server) adminFlappingEndpoints(c echo.Context) error {
+	return JSONResult(c, server.services.FlappingEndpoints(""))
+}
+
+// adminListLeases all active leases, their ttls and keys
+func (server *Server) adminListLeases(c echo.Context) error {
+	leases, err := server.services.Leases(server.ctx(c))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, leases)
+}
+
+func (server *Server) adminGetLease(c echo.Context) error {
+	leaseID, err := parseLeaseID(c.ParamValues()[0])
+	if err != nil {
+		return err
+	}
+	lease, err := server.services.Lease(server.ctx(c), leaseID)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, lease)
+}
+
+// adminRevokeLease force revoke lease whoever owns it, returning the lease and its deleted keys
+func (server *Server) adminRevokeLease(c echo.Context) error {
+	leaseID, err := parseLeaseID(c.ParamValues()[0])
+	if err != nil {
+		return err
+	}
+	lease, err := server.services.Lease(server.ctx(c), leaseID)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	ctx, dryRun := server.dryRunCtx(server.ctx(c), c)
+	if err := server.services.UnplugByLease(ctx, leaseID); err != nil {
+		return JSONError(c, err)
+	}
+	if dryRun != nil {
+		return JSONResult(c, dryRun)
+	}
+	server.logger(c).Infof("admin(%s) revoked lease(%d), keys: %v", server.appName(c), leaseID, lease.Keys)
+	return JSONResult(c, lease)
+}
+
+type serviceKeysResult struct {
+	Keys     []services.KeyInfo `json:"keys"`
+	Revision int64              `json:"revision"`
+}
+
+// adminServiceKeys etcd keys of a service with their leases and revisions
+func (server *Server) adminServiceKeys(c echo.Context) error {
+	keys, rev, err := server.services.ServiceKeys(server.ctx(c), c.ParamValues()[0], c.QueryParam("zone"))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, serviceKeysResult{Keys: keys, Revision: rev})
+}
+
+// adminDeleteEndpoint force delete an endpoint, e.g. a stale one whose owner keeps its lease alive
+func (server *Server) adminDeleteEndpoint(c echo.Context) error {
+	params := c.ParamValues()
+	ctx, dryRun := server.dryRunCtx(server.ctx(c), c)
+	if err := server.services.Unplug(ctx, params[0], params[1], params[2]); err != nil {
+		return JSONError(c, err)
+	}
+	if dryRun != nil {
+		return JSONResult(c, dryRun)
+	}
+	server.logger(c).Infof("admin(%s) deleted endpoint %s/%s/%s", server.appName(c), params[0], params[1], params[2])
+	return JSONOk(c)
+}
+
+// adminListTombstones tombstones of unplugged endpoints, of query service and zone if given
+func (server *Server) adminListTombstones(c echo.Context) error {
+	tombstones, err := server.services.ListTombstones(server.ctx(c), c.QueryParam("service"), c.QueryParam("zone"))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, tombstones)
+}
+
+// adminUndelete plug back tombstoned endpoints of service, of form zone and address if given,
+// endpoints whose leases are gone are bound to a new lease of form ttl
+func (server *Server) adminUndelete(c echo.Context) error {
+	ttl, ok, err := server.ttlParam(c)
+	if !ok {
+		return err
+	}
+	service := c.ParamValues()[0]
+	result, err := server.services.Undelete(server.ctx(c), service,
+		c.FormValue("zone"), c.FormValue("address"), time.Duration(ttl)*time.Second)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	server.logger(c).Infof("admin(%s) undeleted %d endpoints of %s", server.appName(c), len(result.Restored), service)
+	return JSONResult(c, result)
+}
+
+// adminFindOrphans endpoint keys without lease or with malformed values, see services.OrphanGCConfig
+func (server *Server) adminFindOrphans(c echo.Context) error {
+	orphans, err := server.services.FindOrphans(server.ctx(c))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, orphans)
+}
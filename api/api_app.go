@@ -2,9 +2,9 @@ package api
 
 import (
 	"context"
+	"strconv"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/infrmods/xbus/apps"
 	"github.com/infrmods/xbus/utils"
 	"github.com/labstack/echo/v4"
@@ -69,8 +69,8 @@ func (server *Server) newApp(c echo.Context) error {
 	}
 	privKey, err := utils.NewPrivateKey("", req.KeyBits)
 	if err != nil {
-		glog.Errorf("generate private key fail: %v", err)
-		return JSONErrorf(c, "SYSTEM_BUSY", "create private key fail")
+		server.logger(c).Errorf("generate private key fail: %v", err)
+		return JSONErrorf(c, utils.EcodeSystemBusy, "create private key fail")
 	}
 	app := apps.App{
 		Status:      utils.StatusOk,
@@ -78,7 +78,7 @@ func (server *Server) newApp(c echo.Context) error {
 		Description: req.Description}
 	_, err = server.apps.NewApp(&app, privKey, nil, nil, req.Days)
 	if err != nil {
-		glog.Errorf("create app fail: %v", err)
+		server.logger(c).Errorf("create app fail: %v", err)
 		return JSONError(c, err)
 	}
 	return JSONResult(c, app)
@@ -126,3 +126,85 @@ func (server *Server) isAppNodeOnline(c echo.Context) error {
 	}
 	return JSONResult(c, online)
 }
+
+// tokenApp the app of tokens apis, only the app itself or admins are permitted
+func (server *Server) tokenApp(c echo.Context) (*apps.App, error) {
+	name := c.ParamValues()[0]
+	if server.appName(c) != name {
+		if ok, err := server.checkAdminPerm(c); !ok {
+			return nil, err
+		}
+	}
+	app, err := server.apps.GetAppByName(name)
+	if err != nil {
+		return nil, JSONError(c, err)
+	}
+	if app == nil {
+		return nil, JSONErrorf(c, utils.EcodeNotFound, "no such app: %s", name)
+	}
+	return app, nil
+}
+
+type issueTokenResult struct {
+	Token *apps.AppToken `json:"token"`
+	Value string         `json:"value"`
+}
+
+// issueAppToken issue a token, requests authenticated by token must be admins' and get
+// tokens expiring no later than theirs
+func (server *Server) issueAppToken(c echo.Context) error {
+	parent := server.authToken(c)
+	if parent != nil {
+		if ok, err := server.checkPerm(c, apps.PermTypeApp, true, ""); err != nil {
+			return JSONError(c, err)
+		} else if !ok {
+			return JSONError(c, utils.NewNotPermittedError("tokens can't be issued with a token, use a cert", nil))
+		}
+	}
+	app, err := server.tokenApp(c)
+	if app == nil {
+		return err
+	}
+	ttl, ok, err := IntFormParamD(c, "ttl", 0)
+	if !ok {
+		return err
+	}
+	kind := c.FormValue("kind")
+	if kind == "" {
+		kind = apps.TokenKindKey
+	}
+	token, value, err := server.apps.IssueToken(app, kind, time.Duration(ttl)*time.Second, c.FormValue("description"), parent)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	server.logger(c).Infof("app(%s) issued %s token(%d) of %s", server.appName(c), kind, token.ID, app.Name)
+	return JSONResult(c, issueTokenResult{Token: token, Value: value})
+}
+
+func (server *Server) listAppTokens(c echo.Context) error {
+	app, err := server.tokenApp(c)
+	if app == nil {
+		return err
+	}
+	tokens, err := server.apps.ListTokens(app)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, tokens)
+}
+
+func (server *Server) revokeAppToken(c echo.Context) error {
+	app, err := server.tokenApp(c)
+	if app == nil {
+		return err
+	}
+	id, err := strconv.ParseInt(c.ParamValues()[1], 10, 64)
+	if err != nil {
+		return JSONErrorf(c, utils.EcodeInvalidParam, "invalid token id: %s", c.ParamValues()[1])
+	}
+	if err := server.apps.RevokeToken(app, id); err != nil {
+		return JSONError(c, err)
+	}
+	server.logger(c).Infof("app(%s) revoked token(%d) of %s", server.appName(c), id, app.Name)
+	return JSONOk(c)
+}
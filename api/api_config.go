@@ -125,6 +125,9 @@ func (server *Server) putConfig(c echo.Context) error {
 	}
 	remark := c.FormValue("remark")
 
+	if err := server.checkReserved(c, c.ParamValues()[0]); err != nil {
+		return JSONError(c, err)
+	}
 	rev, err := server.configs.Put(context.Background(), tag, c.ParamValues()[0], server.appID(c), remark, value, version)
 	if err != nil {
 		return JSONError(c, err)
@@ -141,7 +144,12 @@ func (server *Server) watch(c echo.Context) error {
 	if !ok {
 		return err
 	}
-	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	release, err := server.acquireWatch(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	defer release()
+	ctx, cancelFunc := context.WithTimeout(server.ctx(c), time.Duration(timeout)*time.Second)
 	defer cancelFunc()
 	node := c.Request().Header.Get("node")
 
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/locks"
+	"github.com/labstack/echo/v4"
+)
+
+type campaignResult struct {
+	*locks.Leader
+	TTL int64 `json:"ttl"`
+}
+
+// campaign campaign as the app with a new lease, the leader keeps the lease alive and resigns with it
+func (server *Server) campaign(c echo.Context) error {
+	name := c.ParamValues()[0]
+	appName := server.appName(c)
+	value := c.FormValue("value")
+	return server.waitWithLease(c, func(ctx context.Context, grant *clientv3.LeaseGrantResponse) (interface{}, error) {
+		leader, err := server.locks.Campaign(ctx, name, grant.ID, appName, value)
+		if err != nil {
+			return nil, err
+		}
+		return campaignResult{Leader: leader, TTL: grant.TTL}, nil
+	})
+}
+
+type leaderResult struct {
+	Leader   *locks.Leader `json:"leader"`
+	Revision int64         `json:"revision"`
+}
+
+// getLeader current leader, or wait until it's not the one of leader_revision if watch
+func (server *Server) getLeader(c echo.Context) error {
+	name := c.ParamValues()[0]
+	if c.QueryParam("watch") == "true" {
+		return server.observeLeader(c, name)
+	}
+	leader, revision, err := server.locks.Leader(server.ctx(c), name)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.hideLeaderLease(c, leader); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, leaderResult{Leader: leader, Revision: revision})
+}
+
+// hideLeaderLease clear lease id of leader unless the caller is the leader or admin
+func (server *Server) hideLeaderLease(c echo.Context, leader *locks.Leader) error {
+	if leader == nil {
+		return nil
+	}
+	owner, err := server.leaseOwner(c)
+	if err != nil {
+		return err
+	}
+	if owner != "" && owner != leader.App {
+		leader.LeaseID = 0
+	}
+	return nil
+}
+
+func (server *Server) observeLeader(c echo.Context, name string) error {
+	leaderRevision, ok, err := IntQueryParamD(c, "leader_revision", 0)
+	if !ok {
+		return err
+	}
+	timeout, ok, err := IntQueryParamD(c, "timeout", defaultWatchTimeout)
+	if !ok {
+		return err
+	}
+	release, err := server.acquireWatch(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(server.ctx(c), time.Duration(timeout)*time.Second)
+	defer cancel()
+	leader, err := server.locks.Observe(ctx, name, leaderRevision)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.hideLeaderLease(c, leader); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, leaderResult{Leader: leader})
+}
+
+// resign resign and revoke the lease, only by the app campaigning with it or admins
+func (server *Server) resign(c echo.Context) error {
+	name := c.ParamValues()[0]
+	leaseID, err := parseLeaseID(c.ParamValues()[1])
+	if err != nil {
+		return err
+	}
+	owner, err := server.leaseOwner(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.locks.Resign(server.ctx(c), name, leaseID, owner); err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.revokeGrantedLease(leaseID); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONOk(c)
+}
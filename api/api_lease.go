@@ -2,11 +2,13 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"strconv"
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/metrics"
 	"github.com/infrmods/xbus/utils"
 	"github.com/labstack/echo/v4"
 )
@@ -18,13 +20,10 @@ type leaseGrantResult struct {
 }
 
 func (server *Server) grantLease(c echo.Context) error {
-	ttl, ok, err := IntFormParamD(c, "ttl", 60)
+	ttl, ok, err := server.ttlParam(c)
 	if !ok {
 		return err
 	}
-	if ttl > 0 && ttl < minServiceTTL {
-		return JSONErrorf(c, utils.EcodeInvalidParam, "invalid ttl: %d", ttl)
-	}
 	app := server.app(c)
 	var appNode *apps.AppNode
 	if c.FormValue("app_node") != "" {
@@ -71,11 +70,95 @@ func (server *Server) keepAliveLease(c echo.Context) error {
 	}
 	_, err = server.etcdClient.KeepAliveOnce(context.Background(), leaseID)
 	if err != nil {
+		metrics.KeepAliveFailures.Inc()
 		return JSONError(c, utils.CleanErr(err, "keepalive fail", "keepalive(%d) fail: %v", leaseID, err))
 	}
 	return JSONOk(c)
 }
 
+// leaseEvent event of lease keepalive stream
+type leaseEvent struct {
+	Type    string           `json:"type"`
+	LeaseID clientv3.LeaseID `json:"lease_id"`
+	TTL     int64            `json:"ttl,omitempty"`
+}
+
+// lease event types
+const (
+	leaseEventKeepAlive = "keepalive"
+	leaseEventExpired   = "expired"
+	// leaseEventClosed the server is shutting down, the lease is still alive
+	leaseEventClosed = "closed"
+)
+
+// keepAliveLeaseStream keep lease alive as long as the request is open,
+// streaming a json line per refresh, and expired if the lease is lost
+func (server *Server) keepAliveLeaseStream(c echo.Context) error {
+	leaseID, err := parseLeaseID(c.ParamValues()[0])
+	if err != nil {
+		return err
+	}
+	release, err := server.acquireWatch(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(server.ctx(c))
+	defer cancel()
+	go func() {
+		select {
+		case <-c.Request().Context().Done():
+		case <-server.stopping:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	// checked first so that a missing lease is a normal error response
+	first, err := server.etcdClient.KeepAliveOnce(ctx, leaseID)
+	if err != nil {
+		metrics.KeepAliveFailures.Inc()
+		return JSONError(c, utils.CleanErr(err, "keepalive fail", "keepalive(%d) fail: %v", leaseID, err))
+	}
+	ch, err := server.etcdClient.KeepAlive(ctx, leaseID)
+	if err != nil {
+		metrics.KeepAliveFailures.Inc()
+		return JSONError(c, utils.CleanErr(err, "keepalive fail", "keepalive(%d) fail: %v", leaseID, err))
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	resp.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(resp)
+	send := func(event leaseEvent) bool {
+		if err := encoder.Encode(&event); err != nil {
+			return false
+		}
+		resp.Flush()
+		return true
+	}
+	if !send(leaseEvent{Type: leaseEventKeepAlive, LeaseID: leaseID, TTL: first.TTL}) {
+		return nil
+	}
+	for ka := range ch {
+		if !send(leaseEvent{Type: leaseEventKeepAlive, LeaseID: leaseID, TTL: ka.TTL}) {
+			return nil
+		}
+	}
+	select {
+	case <-server.stopping:
+		send(leaseEvent{Type: leaseEventClosed, LeaseID: leaseID})
+		return nil
+	default:
+	}
+	if ctx.Err() == nil {
+		// keepalive channel closed without cancel, the lease expired or was revoked
+		metrics.KeepAliveFailures.Inc()
+		send(leaseEvent{Type: leaseEventExpired, LeaseID: leaseID})
+	}
+	return nil
+}
+
 func (server *Server) revokeLease(c echo.Context) error {
 	leaseID, err := parseLeaseID(c.ParamValues()[0])
 	if err != nil {
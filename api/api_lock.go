@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/locks"
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+type lockResult struct {
+	*locks.Lock
+	TTL int64 `json:"ttl"`
+}
+
+// waitWithLease grant a lease of ttl and call wait with a ctx of timeout,
+// the lease is revoked if wait fails
+func (server *Server) waitWithLease(c echo.Context,
+	wait func(ctx context.Context, grant *clientv3.LeaseGrantResponse) (interface{}, error)) error {
+	ttl, ok, err := server.ttlParam(c)
+	if !ok {
+		return err
+	}
+	timeout, ok, err := IntFormParamD(c, "timeout", defaultWatchTimeout)
+	if !ok {
+		return err
+	}
+	if timeout <= 0 {
+		return JSONErrorf(c, utils.EcodeInvalidParam, "invalid timeout: %d", timeout)
+	}
+	release, err := server.acquireWatch(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	defer release()
+	grant, err := server.etcdClient.Grant(server.ctx(c), ttl)
+	if err != nil {
+		return JSONError(c, utils.CleanErr(err, "grant lease fail", "grant lease(ttl: %d) fail: %v", ttl, err))
+	}
+	ctx, cancel := context.WithTimeout(server.ctx(c), time.Duration(timeout)*time.Second)
+	defer cancel()
+	result, err := wait(ctx, grant)
+	if err != nil {
+		server.revokeGrantedLease(grant.ID)
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+// lock wait for the lock with a new lease, the holder keeps the lease alive and unlocks with it
+func (server *Server) lock(c echo.Context) error {
+	name := c.ParamValues()[0]
+	return server.waitWithLease(c, func(ctx context.Context, grant *clientv3.LeaseGrantResponse) (interface{}, error) {
+		lock, err := server.locks.Lock(ctx, name, grant.ID, server.appName(c))
+		if err != nil {
+			return nil, err
+		}
+		return lockResult{Lock: lock, TTL: grant.TTL}, nil
+	})
+}
+
+func (server *Server) revokeGrantedLease(leaseID clientv3.LeaseID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := server.etcdClient.Revoke(ctx, leaseID); err != nil && err != v3rpc.ErrLeaseNotFound {
+		return utils.CleanErr(err, "revoke fail", "revoke(%d) fail: %v", leaseID, err)
+	}
+	return nil
+}
+
+// leaseOwner app name whose leases the caller may see and revoke, empty for admins (any app)
+func (server *Server) leaseOwner(c echo.Context) (string, error) {
+	admin, err := server.checkPerm(c, apps.PermTypeApp, true, "")
+	if err != nil {
+		return "", err
+	}
+	if admin {
+		return "", nil
+	}
+	return server.appName(c), nil
+}
+
+// getLock holder of the lock, its lease id is only returned to the holder
+func (server *Server) getLock(c echo.Context) error {
+	lock, err := server.locks.Holder(server.ctx(c), c.ParamValues()[0])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	if lock != nil {
+		owner, err := server.leaseOwner(c)
+		if err != nil {
+			return JSONError(c, err)
+		}
+		if owner != "" && owner != lock.App {
+			lock.LeaseID = 0
+		}
+	}
+	return JSONResult(c, lock)
+}
+
+// unlock release the lock and revoke its lease, only by the app holding (or waiting) it or admins
+func (server *Server) unlock(c echo.Context) error {
+	name := c.ParamValues()[0]
+	leaseID, err := parseLeaseID(c.ParamValues()[1])
+	if err != nil {
+		return err
+	}
+	owner, err := server.leaseOwner(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.locks.Unlock(server.ctx(c), name, leaseID, owner); err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.revokeGrantedLease(leaseID); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONOk(c)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+
+	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/services"
+	"github.com/labstack/echo/v4"
+)
+
+// adminListMaintenance services in maintenance
+func (server *Server) adminListMaintenance(c echo.Context) error {
+	result, err := server.services.ListMaintenance(server.ctx(c))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+// adminSetMaintenance put service in maintenance, with form message and block_plug
+func (server *Server) adminSetMaintenance(c echo.Context) error {
+	maintenance := services.Maintenance{
+		Service:   c.ParamValues()[0],
+		Message:   c.FormValue("message"),
+		BlockPlug: c.FormValue("block_plug") == "true",
+		Operator:  server.appName(c),
+	}
+	if err := server.services.SetMaintenance(server.ctx(c), &maintenance); err != nil {
+		return JSONError(c, err)
+	}
+	server.logger(c).Infof("admin(%s) set %s in maintenance, block plug: %v",
+		maintenance.Operator, maintenance.Service, maintenance.BlockPlug)
+	return JSONResult(c, maintenance)
+}
+
+func (server *Server) adminDeleteMaintenance(c echo.Context) error {
+	if err := server.services.DeleteMaintenance(server.ctx(c), c.ParamValues()[0]); err != nil {
+		return JSONError(c, err)
+	}
+	server.logger(c).Infof("admin(%s) ended maintenance of %s", server.appName(c), c.ParamValues()[0])
+	return JSONOk(c)
+}
+
+// checkMaintenance reject plugs into services in maintenance, unless by admins
+func (server *Server) checkMaintenance(c echo.Context, descs []services.ServiceDescV1) error {
+	admin, err := server.checkPerm(c, apps.PermTypeApp, true, "")
+	if err != nil {
+		return err
+	}
+	if admin {
+		return nil
+	}
+	return server.services.CheckMaintenance(server.ctx(c), descs)
+}
+
+// queryResult result with the maintenance status of the service at revision (current if 0),
+// and metadata if with_metadata=true
+func (server *Server) queryResult(ctx context.Context, c echo.Context, result *serviceQueryResultV1, revision int64) error {
+	if result.Service != nil {
+		var maintenance *services.Maintenance
+		var err error
+		if revision > 0 {
+			maintenance, err = server.services.MaintenanceAt(ctx, result.Service.Service, revision)
+		} else {
+			maintenance, err = server.services.MaintenanceOf(ctx, result.Service.Service)
+		}
+		if err != nil {
+			return JSONError(c, err)
+		}
+		if maintenance != nil {
+			result.Status = services.ServiceStatusMaintenance
+			result.Maintenance = maintenance
+		}
+	}
+	return server.withMetadata(c, result)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/secrets"
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+func (server *Server) registerSecretAPIs(g *echo.Group) {
+	plug, query, watch := server.newRateLimit(opPlug), server.newRateLimit(opQuery), server.newRateLimit(opWatch)
+	g.GET("/:name", echo.HandlerFunc(server.getSecret), query,
+		server.newSecretPermChecker(secrets.OpRead, false))
+	g.GET("/:name/version", echo.HandlerFunc(server.watchSecret), watch,
+		server.newSecretPermChecker("", false))
+	g.PUT("/:name", echo.HandlerFunc(server.putSecret), plug,
+		server.newSecretPermChecker(secrets.OpWrite, true))
+	g.DELETE("/:name", echo.HandlerFunc(server.deleteSecret), plug,
+		server.newSecretPermChecker(secrets.OpDelete, true))
+}
+
+// newSecretPermChecker reject anonymous requests (secrets are never public) and apps without
+// the secret perm, denials are audited as op unless it's empty
+func (server *Server) newSecretPermChecker(op string, needWrite bool) echo.MiddlewareFunc {
+	return func(h echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			name := c.ParamValues()[0]
+			if server.app(c) == nil {
+				err := utils.NewError(utils.EcodeNotPermitted, "app cert required")
+				if op != "" {
+					server.secrets.Denied(server.secretAccessor(c), op, name, err)
+				}
+				return JSONError(c, err)
+			}
+			ok, err := server.checkPerm(c, apps.PermTypeSecret, needWrite, name)
+			if err != nil {
+				return JSONError(c, err)
+			}
+			if !ok {
+				if op != "" {
+					server.secrets.Denied(server.secretAccessor(c), op, name,
+						utils.NewError(utils.EcodeNotPermitted, "not permitted"))
+				}
+				return server.newNotPermittedResp(c, name)
+			}
+			return h(c)
+		}
+	}
+}
+
+func (server *Server) secretAccessor(c echo.Context) secrets.Accessor {
+	who := secrets.Accessor{AppID: server.appID(c), App: server.appName(c), RequestID: server.requestID(c)}
+	if ip := server.getRemoteIP(c); ip != nil {
+		who.RemoteIP = ip.String()
+	}
+	return who
+}
+
+func (server *Server) getSecret(c echo.Context) error {
+	secret, err := server.secrets.Get(server.ctx(c), server.secretAccessor(c), c.ParamValues()[0])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, secret)
+}
+
+// watchSecret rotation signal: the current version, or with watch=true the next version
+// since revision, without the value
+func (server *Server) watchSecret(c echo.Context) error {
+	if c.QueryParam("watch") != "true" {
+		version, err := server.secrets.Version(server.ctx(c), c.ParamValues()[0])
+		if err != nil {
+			return JSONError(c, err)
+		}
+		return JSONResult(c, version)
+	}
+	revision, ok, err := IntQueryParamD(c, "revision", 0)
+	if !ok {
+		return err
+	}
+	timeout, ok, err := IntQueryParamD(c, "timeout", defaultWatchTimeout)
+	if !ok {
+		return err
+	}
+	release, err := server.acquireWatch(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	defer release()
+	ctx, cancelFunc := context.WithTimeout(server.ctx(c), time.Duration(timeout)*time.Second)
+	defer cancelFunc()
+
+	version, err := server.secrets.Watch(ctx, c.ParamValues()[0], revision)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, version)
+}
+
+func (server *Server) putSecret(c echo.Context) error {
+	value := c.FormValue("value")
+	if value == "" {
+		return JSONErrorf(c, utils.EcodeMissingParam, "missing value")
+	}
+	version, err := server.secrets.Put(server.ctx(c), server.secretAccessor(c), c.ParamValues()[0], value)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, map[string]int64{"version": version})
+}
+
+func (server *Server) deleteSecret(c echo.Context) error {
+	if err := server.secrets.Delete(server.ctx(c), server.secretAccessor(c), c.ParamValues()[0]); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONOk(c)
+}
+
+// adminRewrapSecrets re-encrypt data keys by the current master key
+func (server *Server) adminRewrapSecrets(c echo.Context) error {
+	names, err := server.secrets.Rewrap(server.ctx(c), server.secretAccessor(c))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, map[string][]string{"rewrapped": names})
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+func (server *Server) v1ListServiceAliases(c echo.Context) error {
+	if !server.publicQuery(c.ParamValues()[0]) {
+		if ok, err := server.checkPerm(c, apps.PermTypeService, false, c.ParamValues()[0]); err == nil {
+			if !ok {
+				return server.newNotPermittedResp(c, c.ParamValues()[0])
+			}
+		} else {
+			return JSONError(c, err)
+		}
+	}
+	aliases, err := server.services.ListAliases(server.ctx(c), c.ParamValues()[0])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, aliases)
+}
+
+func (server *Server) v1SetServiceAlias(c echo.Context) error {
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return err
+	}
+	version := c.FormValue("version")
+	if version == "" {
+		return JSONErrorf(c, utils.EcodeMissingParam, "missing version")
+	}
+	params := c.ParamValues()
+	if err := server.checkReserved(c, params[0]); err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.services.SetAlias(server.ctx(c), params[0], params[1], version); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONOk(c)
+}
+
+func (server *Server) v1DeleteServiceAlias(c echo.Context) error {
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return err
+	}
+	params := c.ParamValues()
+	if err := server.services.DeleteAlias(server.ctx(c), params[0], params[1]); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONOk(c)
+}
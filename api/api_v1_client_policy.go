@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/infrmods/xbus/services"
+	"github.com/labstack/echo/v4"
+)
+
+type clientPolicyResult struct {
+	Policy   *services.ClientPolicy `json:"policy"`
+	Revision int64                  `json:"revision"`
+}
+
+func (server *Server) v1GetClientPolicy(c echo.Context) error {
+	if c.QueryParam("watch") == "true" {
+		return server.v1WatchClientPolicy(c)
+	}
+	policy, rev, err := server.services.GetClientPolicy(server.ctx(c), c.ParamValues()[0])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, clientPolicyResult{Policy: policy, Revision: rev})
+}
+
+func (server *Server) v1WatchClientPolicy(c echo.Context) error {
+	revision, ok, err := IntQueryParamD(c, "revision", 0)
+	if !ok {
+		return err
+	}
+	timeout, ok, err := IntQueryParamD(c, "timeout", defaultWatchTimeout)
+	if !ok {
+		return err
+	}
+	release, err := server.acquireWatch(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	defer release()
+	ctx, cancelFunc := context.WithTimeout(server.ctx(c), time.Duration(timeout)*time.Second)
+	defer cancelFunc()
+
+	policy, rev, err := server.services.WatchClientPolicy(ctx, c.ParamValues()[0], revision)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, clientPolicyResult{Policy: policy, Revision: rev})
+}
+
+func (server *Server) v1SetClientPolicy(c echo.Context) error {
+	policy := services.ClientPolicy{Service: c.ParamValues()[0]}
+	for name, field := range map[string]*int64{"timeout_ms": &policy.TimeoutMs, "retry_budget": &policy.RetryBudget, "eject_after": &policy.EjectAfter,
+		"ejection_ms": &policy.EjectionMs, "max_ejected_percent": &policy.MaxEjectedPercent} {
+		value, ok, err := IntFormParamD(c, name, 0)
+		if !ok {
+			return err
+		}
+		*field = value
+	}
+	// retries=0 disables retries, so only a missing retries is left to client configs
+	if c.FormValue("retries") != "" {
+		retries, ok, err := IntFormParam(c, "retries")
+		if !ok {
+			return err
+		}
+		policy.Retries = &retries
+	}
+	if err := server.checkReserved(c, policy.Service); err != nil {
+		return JSONError(c, err)
+	}
+	revision, err := server.services.SetClientPolicy(server.ctx(c), &policy)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, map[string]int64{"revision": revision})
+}
+
+func (server *Server) v1DeleteClientPolicy(c echo.Context) error {
+	if err := server.services.DeleteClientPolicy(server.ctx(c), c.ParamValues()[0]); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONOk(c)
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+func (server *Server) v1GetConfigSchema(c echo.Context) error {
+	schema, err := server.services.GetConfigSchema(server.ctx(c), c.ParamValues()[0])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, schema)
+}
+
+func (server *Server) v1SetConfigSchema(c echo.Context) error {
+	schema := c.FormValue("schema")
+	if schema == "" {
+		return JSONErrorf(c, utils.EcodeMissingParam, "missing schema")
+	}
+	if err := server.checkReserved(c, c.ParamValues()[0]); err != nil {
+		return JSONError(c, err)
+	}
+	revision, err := server.services.SetConfigSchema(server.ctx(c), c.ParamValues()[0], []byte(schema))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, map[string]int64{"revision": revision})
+}
+
+func (server *Server) v1DeleteConfigSchema(c echo.Context) error {
+	if err := server.services.DeleteConfigSchema(server.ctx(c), c.ParamValues()[0]); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONOk(c)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"github.com/infrmods/xbus/services"
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+const maxImpactDepth = 10
+
+// v1DeclareDependencies replace the services the requesting app consumes
+func (server *Server) v1DeclareDependencies(c echo.Context) error {
+	var services []string
+	if ok, err := JSONFormParam(c, "services", &services); !ok {
+		return err
+	}
+	if err := server.services.DeclareDependencies(server.appName(c), services); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONOk(c)
+}
+
+// v1AppDependencies services of app readable by the caller
+func (server *Server) v1AppDependencies(c echo.Context) error {
+	deps, err := server.services.DependenciesOf(c.ParamValues()[0])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	visible := server.serviceVisibility(c)
+	readable := make([]services.Dependency, 0, len(deps))
+	for _, dep := range deps {
+		if visible(dep.Service) {
+			readable = append(readable, dep)
+		}
+	}
+	return JSONResult(c, readable)
+}
+
+func (server *Server) v1ServiceConsumers(c echo.Context) error {
+	deps, err := server.services.ConsumersOf(c.ParamValues()[0])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, deps)
+}
+
+// v1ServiceImpact apps affected by maintenance of the service, up to max_depth hops
+func (server *Server) v1ServiceImpact(c echo.Context) error {
+	maxDepth, ok, err := IntQueryParamD(c, "max_depth", 3)
+	if !ok {
+		return err
+	}
+	if maxDepth <= 0 || maxDepth > maxImpactDepth {
+		return JSONErrorf(c, utils.EcodeInvalidParam, "max_depth should be in [1, %d]", maxImpactDepth)
+	}
+	impacted, err := server.services.Impact(server.ctx(c), c.ParamValues()[0], int(maxDepth))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	// apps only reached via services the caller can't read are left out
+	visible := server.serviceVisibility(c)
+	readable := make([]services.ImpactedApp, 0, len(impacted))
+	for _, item := range impacted {
+		via := item.Via[:0]
+		for _, service := range item.Via {
+			if visible(service) {
+				via = append(via, service)
+			}
+		}
+		if len(via) > 0 {
+			item.Via = via
+			readable = append(readable, item)
+		}
+	}
+	return JSONResult(c, readable)
+}
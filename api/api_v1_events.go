@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/infrmods/xbus/services"
+	"github.com/labstack/echo/v4"
+)
+
+// sse event types, besides the plug, unplug and update of endpoints
+const (
+	sseEventSnapshot = "snapshot"
+	sseEventError    = "error"
+)
+
+const sseHeartbeatInterval = 15 * time.Second
+
+// endpointEvent data of plug, unplug and update events, Endpoint is the one unplugged for unplugs
+type endpointEvent struct {
+	Service  string                   `json:"service"`
+	Zone     string                   `json:"zone"`
+	Endpoint services.ServiceEndpoint `json:"endpoint"`
+	Revision int64                    `json:"revision"`
+
+	typ string
+}
+
+// diffEndpoints endpoint changes from prev to service, ordered by zone and address
+func diffEndpoints(prev, service *services.ServiceV1, revision int64) []endpointEvent {
+	endpointsOf := func(service *services.ServiceV1) map[string]map[string]services.ServiceEndpoint {
+		result := make(map[string]map[string]services.ServiceEndpoint)
+		for zone, z := range service.Zones {
+			result[zone] = make(map[string]services.ServiceEndpoint, len(z.Endpoints))
+			for _, endpoint := range z.Endpoints {
+				result[zone][endpoint.Address] = endpoint
+			}
+		}
+		return result
+	}
+	before, after := endpointsOf(prev), endpointsOf(service)
+	var events []endpointEvent
+	for zone, endpoints := range after {
+		for addr, endpoint := range endpoints {
+			old, ok := before[zone][addr]
+			switch {
+			case !ok:
+				events = append(events, endpointEvent{typ: services.ChangePlug, Zone: zone, Endpoint: endpoint})
+			case !reflect.DeepEqual(old, endpoint):
+				events = append(events, endpointEvent{typ: services.ChangeUpdate, Zone: zone, Endpoint: endpoint})
+			}
+		}
+	}
+	for zone, endpoints := range before {
+		for addr, endpoint := range endpoints {
+			if _, ok := after[zone][addr]; !ok {
+				events = append(events, endpointEvent{typ: services.ChangeUnplug, Zone: zone, Endpoint: endpoint})
+			}
+		}
+	}
+	for i := range events {
+		events[i].Service, events[i].Revision = service.Service, revision
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Zone != events[j].Zone {
+			return events[i].Zone < events[j].Zone
+		}
+		return events[i].Endpoint.Address < events[j].Endpoint.Address
+	})
+	return events
+}
+
+// v1ServiceEvents stream changes of service as server-sent events: a snapshot of the
+// service first, then plug, unplug and update events of endpoints, with the revision as
+// event id; reconnects (e.g. by EventSource) start with a new snapshot
+func (server *Server) v1ServiceEvents(c echo.Context) error {
+	release, err := server.acquireWatch(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	defer release()
+	ctx := server.ctx(c)
+	name, clientIP := c.ParamValues()[0], server.getRemoteIP(c)
+	service, revision, err := server.services.Query(ctx, clientIP, name)
+	if err != nil {
+		return JSONError(c, err)
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	send := func(typ string, id int64, data interface{}) bool {
+		body, err := json.Marshal(data)
+		if err != nil {
+			return false
+		}
+		if id > 0 {
+			_, err = fmt.Fprintf(resp, "event: %s\nid: %d\ndata: %s\n\n", typ, id, body)
+		} else {
+			_, err = fmt.Fprintf(resp, "event: %s\ndata: %s\n\n", typ, body)
+		}
+		if err != nil {
+			return false
+		}
+		resp.Flush()
+		return true
+	}
+	if !send(sseEventSnapshot, revision, serviceQueryResultV1{Service: service, Revision: revision}) {
+		return nil
+	}
+	heartbeat := func() bool {
+		if _, err := fmt.Fprint(resp, ": heartbeat\n\n"); err != nil {
+			return false
+		}
+		resp.Flush()
+		return true
+	}
+	if err := server.followService(ctx, clientIP, name, service, revision, send, heartbeat); err != nil {
+		send(sseEventError, 0, formatError(err))
+	}
+	return nil
+}
+
+// followService watch service from revision, where it was prev, calling send with each plug,
+// unplug and update of its endpoints and heartbeat if no changes in sseHeartbeatInterval,
+// until ctx done or either of them fails; returns the error of watches
+func (server *Server) followService(ctx context.Context, clientIP net.IP, name string,
+	prev *services.ServiceV1, revision int64,
+	send func(typ string, id int64, data interface{}) bool, heartbeat func() bool) error {
+	for {
+		watchCtx, cancel := context.WithTimeout(ctx, sseHeartbeatInterval)
+		next, nextRevision, err := server.services.Watch(watchCtx, clientIP, name, revision+1)
+		timeout := watchCtx.Err() == context.DeadlineExceeded
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if !timeout {
+				return err
+			}
+			if !heartbeat() {
+				return nil
+			}
+			continue
+		}
+		for _, event := range diffEndpoints(prev, next, nextRevision) {
+			if !send(event.typ, nextRevision, event) {
+				return nil
+			}
+		}
+		prev, revision = next, nextRevision
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"github.com/infrmods/xbus/services"
+	"github.com/labstack/echo/v4"
+)
+
+// v1ListMetadata metadata of names readable by the caller
+func (server *Server) v1ListMetadata(c echo.Context) error {
+	skip, ok, err := IntQueryParamD(c, "skip", 0)
+	if !ok {
+		return err
+	}
+	limit, ok, err := IntQueryParamD(c, "limit", 200)
+	if !ok {
+		return err
+	}
+	result, err := server.services.ListMetadata(c.QueryParam("owner"), skip, limit)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	// filtered after paging, so pages may be shorter than limit
+	visible := server.serviceVisibility(c)
+	readable := make([]services.ServiceMetadata, 0, len(result))
+	for _, metadata := range result {
+		if visible(metadata.Name) {
+			readable = append(readable, metadata)
+		}
+	}
+	return JSONResult(c, readable)
+}
+
+func (server *Server) v1GetMetadata(c echo.Context) error {
+	metadata, err := server.services.GetMetadata(c.ParamValues()[0])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, metadata)
+}
+
+func (server *Server) v1PutMetadata(c echo.Context) error {
+	metadata := services.ServiceMetadata{Name: c.ParamValues()[0], AppID: server.appID(c),
+		Owner: c.FormValue("owner"), Description: c.FormValue("description"), Oncall: c.FormValue("oncall")}
+	if c.FormValue("links") != "" {
+		if ok, err := JSONFormParam(c, "links", &metadata.Links); !ok {
+			return err
+		}
+	}
+	if err := server.checkReserved(c, metadata.Name); err != nil {
+		return JSONError(c, err)
+	}
+	result, err := server.services.PutMetadata(&metadata)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+func (server *Server) v1DeleteMetadata(c echo.Context) error {
+	if err := server.services.DeleteMetadata(c.ParamValues()[0]); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONOk(c)
+}
+
+// withMetadata result with metadata of the service's name if with_metadata=true
+func (server *Server) withMetadata(c echo.Context, result *serviceQueryResultV1) error {
+	if c.QueryParam("with_metadata") != "true" || result.Service == nil {
+		return JSONResult(c, result)
+	}
+	metadata, err := server.services.ServiceMetadataOf(result.Service.Service)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	result.Metadata = metadata
+	return JSONResult(c, result)
+}
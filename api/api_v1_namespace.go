@@ -0,0 +1,20 @@
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// v1NamespaceUsage usage and quotas of namespace, for its apps and admins
+func (server *Server) v1NamespaceUsage(c echo.Context) error {
+	name := c.ParamValues()[0]
+	if !server.services.IsNamespaceIsolated(name) || !server.services.IsNamespaceMember(name, server.appName(c)) {
+		if ok, err := server.checkAdminPerm(c); !ok {
+			return err
+		}
+	}
+	usage, err := server.services.NamespaceUsage(server.ctx(c), name)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, usage)
+}
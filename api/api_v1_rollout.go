@@ -0,0 +1,97 @@
+package api
+
+import (
+	"github.com/infrmods/xbus/services"
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+func (server *Server) v1ListServiceRollouts(c echo.Context) error {
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return err
+	}
+	rollouts, err := server.services.ListRollouts(server.ctx(c))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, rollouts)
+}
+
+func (server *Server) v1GetServiceRollout(c echo.Context) error {
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return err
+	}
+	params := c.ParamValues()
+	rollout, err := server.services.GetRollout(server.ctx(c), params[0], params[1])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, rollout)
+}
+
+func (server *Server) v1StartServiceRollout(c echo.Context) error {
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return err
+	}
+	to := c.FormValue("to")
+	if to == "" {
+		return JSONErrorf(c, utils.EcodeMissingParam, "missing to")
+	}
+	rollout := services.Rollout{GateURL: c.FormValue("gate_url"),
+		AutoRollback: c.FormValue("auto_rollback") == "true"}
+	if c.FormValue("steps") != "" {
+		if ok, err := JSONFormParam(c, "steps", &rollout.Steps); !ok {
+			return err
+		}
+	}
+	var ok bool
+	var err error
+	if rollout.StepInterval, ok, err = IntFormParamD(c, "step_interval", 60); !ok {
+		return err
+	}
+	if rollout.MinInstances, ok, err = IntFormParamD(c, "min_instances", 1); !ok {
+		return err
+	}
+	params := c.ParamValues()
+	result, err := server.services.StartRollout(server.ctx(c), params[0], params[1], to, rollout)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+func (server *Server) v1PauseServiceRollout(c echo.Context) error {
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return err
+	}
+	params := c.ParamValues()
+	rollout, err := server.services.PauseRollout(server.ctx(c), params[0], params[1], c.FormValue("message"))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, rollout)
+}
+
+func (server *Server) v1ResumeServiceRollout(c echo.Context) error {
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return err
+	}
+	params := c.ParamValues()
+	rollout, err := server.services.ResumeRollout(server.ctx(c), params[0], params[1])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, rollout)
+}
+
+func (server *Server) v1RollbackServiceRollout(c echo.Context) error {
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return err
+	}
+	params := c.ParamValues()
+	rollout, err := server.services.RollbackRollout(server.ctx(c), params[0], params[1], c.FormValue("message"))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, rollout)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"github.com/infrmods/xbus/apps"
+	"github.com/labstack/echo/v4"
+)
+
+func (server *Server) v1ListSchemas(c echo.Context) error {
+	schemas, err := server.schemas.List(c.ParamValues()[0])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, schemas)
+}
+
+func (server *Server) v1GetSchema(c echo.Context) error {
+	revision, ok, err := IntQueryParamD(c, "revision", 0)
+	if !ok {
+		return err
+	}
+	schema, err := server.schemas.Get(c.ParamValues()[0], c.ParamValues()[1], revision)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, schema)
+}
+
+func (server *Server) v1PutSchema(c echo.Context) error {
+	if err := server.checkReserved(c, c.ParamValues()[0]); err != nil {
+		return JSONError(c, err)
+	}
+	schema, err := server.schemas.Put(c.ParamValues()[0], c.ParamValues()[1], server.appID(c),
+		c.FormValue("remark"), c.FormValue("content"))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	schema.Content = ""
+	return JSONResult(c, schema)
+}
+
+// v1DiffSchema diff from from_service (the same service by default) at from_revision to to_revision
+func (server *Server) v1DiffSchema(c echo.Context) error {
+	service, kind := c.ParamValues()[0], c.ParamValues()[1]
+	fromService := c.QueryParam("from_service")
+	if fromService == "" {
+		fromService = service
+	} else if !server.publicQuery(fromService) {
+		if ok, err := server.checkPerm(c, apps.PermTypeService, false, fromService); err != nil {
+			return JSONError(c, err)
+		} else if !ok {
+			return server.newNotPermittedResp(c, fromService)
+		}
+	}
+	fromRevision, ok, err := IntQueryParamD(c, "from_revision", 0)
+	if !ok {
+		return err
+	}
+	toRevision, ok, err := IntQueryParamD(c, "to_revision", 0)
+	if !ok {
+		return err
+	}
+	diff, err := server.schemas.Diff(kind, fromService, fromRevision, service, toRevision)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, diff)
+}
@@ -2,6 +2,9 @@ package api
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
@@ -12,8 +15,6 @@ import (
 )
 
 const (
-	minServiceTTL       = 10 // in seconds
-	defaultServiceTTL   = 60 // in seconds
 	defaultWatchTimeout = 60 // in seconds
 )
 
@@ -24,13 +25,10 @@ type ServicePlugResult struct {
 }
 
 func (server *Server) v1PlugService(c echo.Context) error {
-	ttl, ok, err := IntFormParamD(c, "ttl", 60)
+	ttl, ok, err := server.plugTTLParam(c)
 	if !ok {
 		return err
 	}
-	if ttl > 0 && ttl < minServiceTTL {
-		return JSONErrorf(c, utils.EcodeInvalidParam, "invalid ttl: %d", ttl)
-	}
 	leaseID, ok, err := IntFormParamD(c, "lease_id", 0)
 	if !ok {
 		return err
@@ -49,22 +47,39 @@ func (server *Server) v1PlugService(c echo.Context) error {
 		return err
 	}
 
-	if leaseID, err := server.services.PlugAll(context.Background(),
-		time.Duration(ttl)*time.Second, clientv3.LeaseID(leaseID),
-		[]services.ServiceDescV1{desc}, &endpoint); err == nil {
+	onConflict, err := services.ParseInstanceConflict(c.FormValue("on_conflict"))
+	if err != nil {
+		return JSONError(c, err)
+	}
+
+	descs := []services.ServiceDescV1{desc}
+	if err := server.checkReservedNames(c, descs); err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.checkMaintenance(c, descs); err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.services.CheckPlugNetwork(descs, &endpoint, server.getRemoteIP(c)); err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.verifyEndpoint(c, descs, &endpoint); err != nil {
+		return JSONError(c, err)
+	}
+	ctx, dryRun := server.dryRunCtx(server.plugCtx(c), c)
+	if leaseID, err := server.plugAll(ctx, ttl, clientv3.LeaseID(leaseID), descs, &endpoint, onConflict); err == nil {
+		if dryRun != nil {
+			return JSONResult(c, dryRun)
+		}
 		return JSONResult(c, ServicePlugResult{LeaseID: leaseID, TTL: ttl})
 	}
 	return JSONError(c, err)
 }
 
 func (server *Server) v1PlugAllService(c echo.Context) error {
-	ttl, ok, err := IntFormParamD(c, "ttl", 60)
+	ttl, ok, err := server.plugTTLParam(c)
 	if !ok {
 		return err
 	}
-	if ttl > 0 && ttl < minServiceTTL {
-		return JSONErrorf(c, utils.EcodeInvalidParam, "invalid ttl: %d", ttl)
-	}
 	leaseID, ok, err := IntFormParamD(c, "lease_id", 0)
 	if !ok {
 		return err
@@ -101,25 +116,154 @@ func (server *Server) v1PlugAllService(c echo.Context) error {
 	if ok, err := JSONFormParam(c, "endpoint", &endpoint); !ok {
 		return err
 	}
+	onConflict, err := services.ParseInstanceConflict(c.FormValue("on_conflict"))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.checkReservedNames(c, descs); err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.checkMaintenance(c, descs); err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.services.CheckPlugNetwork(descs, &endpoint, server.getRemoteIP(c)); err != nil {
+		return JSONError(c, err)
+	}
+	if err := server.verifyEndpoint(c, descs, &endpoint); err != nil {
+		return JSONError(c, err)
+	}
 
-	newLeaseID, err := server.services.PlugAll(context.Background(),
-		time.Duration(ttl)*time.Second, clientv3.LeaseID(leaseID),
-		descs, &endpoint)
+	ctx, dryRun := server.dryRunCtx(server.plugCtx(c), c)
+	newLeaseID, err := server.plugAll(ctx, ttl, clientv3.LeaseID(leaseID), descs, &endpoint, onConflict)
 	if err != nil {
 		return JSONError(c, err)
 	}
+	if dryRun != nil {
+		return JSONResult(c, dryRun)
+	}
 	return JSONResult(c, ServicePlugResult{LeaseID: newLeaseID, TTL: ttl})
 }
 
+// plugAll plug with a lease, or permanently without lease if ttl is 0 (admins only, see plugTTLParam)
+func (server *Server) plugAll(ctx context.Context, ttl int64, leaseID clientv3.LeaseID,
+	descs []services.ServiceDescV1, endpoint *services.ServiceEndpoint,
+	onConflict services.InstanceConflict) (clientv3.LeaseID, error) {
+	if ttl == 0 && leaseID == 0 {
+		return 0, server.services.PlugPermanent(ctx, descs, endpoint, onConflict)
+	}
+	return server.services.PlugAll(ctx, time.Duration(ttl)*time.Second, leaseID, descs, endpoint, onConflict)
+}
+
+// checkReservedNames reject plugs into reserved names, unless by admins
+func (server *Server) checkReservedNames(c echo.Context, descs []services.ServiceDescV1) error {
+	names := make([]string, 0, len(descs))
+	for _, desc := range descs {
+		names = append(names, desc.Service)
+	}
+	return server.checkReserved(c, names...)
+}
+
+// checkReserved reject names being created which are reserved, unless by admins
+func (server *Server) checkReserved(c echo.Context, names ...string) error {
+	if err := server.services.CheckReservedNames(names...); err == nil {
+		return nil
+	} else if admin, e := server.checkPerm(c, apps.PermTypeApp, true, ""); e != nil {
+		return e
+	} else if !admin {
+		return err
+	}
+	return nil
+}
+
+func (server *Server) verifyEndpoint(c echo.Context, descs []services.ServiceDescV1, endpoint *services.ServiceEndpoint) error {
+	var cert *x509.Certificate
+	if app := server.app(c); app != nil {
+		var err error
+		if cert, err = app.Certificate(); err != nil {
+			server.logger(c).Errorf("parse app(%s) cert fail: %v", app.Name, err)
+			return utils.NewSystemError("parse app cert fail")
+		}
+	}
+	return server.services.VerifyEndpoint(server.ctx(c), descs, endpoint, cert)
+}
+
 func (server *Server) v1UnplugService(c echo.Context) error {
 	params := c.ParamValues()
-	err := server.services.Unplug(context.Background(), params[0], params[1], params[2])
+	ctx, dryRun := server.dryRunCtx(server.ctx(c), c)
+	err := server.services.Unplug(ctx, params[0], params[1], params[2])
 	if err != nil {
 		return JSONError(c, err)
 	}
+	if dryRun != nil {
+		return JSONResult(c, dryRun)
+	}
 	return JSONOk(c)
 }
 
+// v1GetEndpoint endpoint with the mod revision to update it with
+func (server *Server) v1GetEndpoint(c echo.Context) error {
+	params := c.ParamValues()
+	result, err := server.services.GetEndpoint(server.ctx(c), params[0], params[1], params[2])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+type endpointUpdateResult struct {
+	ModRevision int64 `json:"mod_revision"`
+}
+
+// v1UpdateEndpoint update endpoint if unchanged since form mod_revision
+func (server *Server) v1UpdateEndpoint(c echo.Context) error {
+	params := c.ParamValues()
+	modRevision, ok, err := IntFormParamD(c, "mod_revision", 0)
+	if !ok {
+		return err
+	}
+	var endpoint services.ServiceEndpoint
+	if ok, err := JSONFormParam(c, "endpoint", &endpoint); !ok {
+		return err
+	}
+	if err := server.checkMaintenance(c, []services.ServiceDescV1{{Service: params[0], Zone: params[1]}}); err != nil {
+		return JSONError(c, err)
+	}
+	ctx, dryRun := server.dryRunCtx(server.plugCtx(c), c)
+	rev, err := server.services.UpdateIfVersion(ctx, params[0], params[1], params[2], modRevision, &endpoint)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	if dryRun != nil {
+		return JSONResult(c, dryRun)
+	}
+	return JSONResult(c, endpointUpdateResult{ModRevision: rev})
+}
+
+// v1PatchEndpoint update only fields of form patch, if unchanged since form mod_revision when given
+func (server *Server) v1PatchEndpoint(c echo.Context) error {
+	params := c.ParamValues()
+	modRevision, ok, err := IntFormParamD(c, "mod_revision", 0)
+	if !ok {
+		return err
+	}
+	var patch map[string]json.RawMessage
+	if ok, err := JSONFormParam(c, "patch", &patch); !ok {
+		return err
+	}
+	if err := server.checkMaintenance(c, []services.ServiceDescV1{{Service: params[0], Zone: params[1]}}); err != nil {
+		return JSONError(c, err)
+	}
+	ctx, dryRun := server.dryRunCtx(server.plugCtx(c), c)
+	result, err := server.services.PatchEndpoint(ctx, params[0], params[1], params[2], modRevision, patch)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	if dryRun != nil {
+		return JSONResult(c, dryRun)
+	}
+	return JSONResult(c, result)
+}
+
 func (server *Server) v1SearchService(c echo.Context) error {
 	skip, ok, err := IntQueryParamD(c, "skip", 0)
 	if !ok {
@@ -137,8 +281,11 @@ func (server *Server) v1SearchService(c echo.Context) error {
 }
 
 type serviceQueryResultV1 struct {
-	Service  *services.ServiceV1 `json:"service"`
-	Revision int64               `json:"revision"`
+	Service     *services.ServiceV1       `json:"service"`
+	Revision    int64                     `json:"revision"`
+	Status      string                    `json:"status,omitempty"`
+	Maintenance *services.Maintenance     `json:"maintenance,omitempty"`
+	Metadata    *services.ServiceMetadata `json:"metadata,omitempty"`
 }
 
 type serviceQueryRawZoneResultV1 struct {
@@ -152,23 +299,49 @@ func (server *Server) v1QueryService(c echo.Context) error {
 	}
 
 	if c.QueryParam("only_zone") == "true" {
-		service, rev, err := server.services.QueryZones(context.Background(), server.getRemoteIP(c), c.ParamValues()[0])
+		service, rev, err := server.services.QueryZones(server.ctx(c), server.getRemoteIP(c), c.ParamValues()[0])
 		if err != nil {
 			return JSONError(c, err)
 		}
 		return JSONResult(c, serviceQueryRawZoneResultV1{Service: service, Revision: rev})
 	}
 
-	service, rev, err := server.services.Query(context.Background(), server.getRemoteIP(c), c.ParamValues()[0])
+	if c.QueryParam("revision") != "" {
+		return server.v1QueryServiceAt(c)
+	}
+
+	service, rev, err := server.services.QueryFederated(server.queryCtx(c), server.getRemoteIP(c),
+		c.ParamValues()[0], c.QueryParam("federation"))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return server.queryResult(server.queryCtx(c), c, &serviceQueryResultV1{Service: service, Revision: rev}, 0)
+}
+
+// v1QueryServiceAt service as it was at query revision
+func (server *Server) v1QueryServiceAt(c echo.Context) error {
+	revision, ok, err := IntQueryParamD(c, "revision", 0)
+	if !ok {
+		return err
+	}
+	service, err := server.services.QueryAt(server.ctx(c), server.getRemoteIP(c), c.ParamValues()[0], revision)
 	if err != nil {
 		return JSONError(c, err)
 	}
-	return JSONResult(c, serviceQueryResultV1{Service: service, Revision: rev})
+	return server.queryResult(server.ctx(c), c, &serviceQueryResultV1{Service: service, Revision: revision}, revision)
+}
+
+// queryCtx ctx for queries, consistent=true bypasses the query cache
+func (server *Server) queryCtx(c echo.Context) context.Context {
+	if c.QueryParam("consistent") == "true" {
+		return services.WithConsistentQuery(server.ctx(c))
+	}
+	return server.ctx(c)
 }
 
 func (server *Server) v1QueryServiceZone(c echo.Context) error {
 	service, rev, err := server.services.QueryServiceZone(
-		context.Background(),
+		server.queryCtx(c),
 		server.getRemoteIP(c),
 		c.ParamValues()[0],
 		c.ParamValues()[1],
@@ -176,7 +349,7 @@ func (server *Server) v1QueryServiceZone(c echo.Context) error {
 	if err != nil {
 		return JSONError(c, err)
 	}
-	return JSONResult(c, serviceQueryResultV1{Service: service, Revision: rev})
+	return server.queryResult(server.queryCtx(c), c, &serviceQueryResultV1{Service: service, Revision: rev}, 0)
 }
 
 func (server *Server) v1WatchService(c echo.Context) error {
@@ -188,21 +361,32 @@ func (server *Server) v1WatchService(c echo.Context) error {
 	if !ok {
 		return err
 	}
-	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	release, err := server.acquireWatch(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	defer release()
+	ctx, cancelFunc := context.WithTimeout(server.ctx(c), time.Duration(timeout)*time.Second)
 	defer cancelFunc()
 
 	service, rev, err := server.services.Watch(ctx, server.getRemoteIP(c), c.ParamValues()[0], revision)
 	if err != nil {
 		return JSONError(c, err)
 	}
-	return JSONResult(c, serviceQueryResultV1{Service: service, Revision: rev})
+	// woken by maintenance changes the cache may not have seen yet
+	return server.queryResult(services.WithConsistentQuery(server.ctx(c)), c,
+		&serviceQueryResultV1{Service: service, Revision: rev}, 0)
 }
 
 func (server *Server) v1DeleteService(c echo.Context) error {
 	zone := c.QueryParam("zone")
-	if err := server.services.Delete(context.Background(), c.ParamValues()[0], zone); err != nil {
+	ctx, dryRun := server.dryRunCtx(server.ctx(c), c)
+	if err := server.services.Delete(ctx, c.ParamValues()[0], zone); err != nil {
 		return JSONError(c, err)
 	}
+	if dryRun != nil {
+		return JSONResult(c, dryRun)
+	}
 	return JSONOk(c)
 }
 
@@ -216,7 +400,12 @@ func (server *Server) v1WatchServiceDesc(c echo.Context) error {
 	if !ok {
 		return err
 	}
-	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	release, err := server.acquireWatch(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	defer release()
+	ctx, cancelFunc := context.WithTimeout(server.ctx(c), time.Duration(timeout)*time.Second)
 	defer cancelFunc()
 
 	result, err := server.services.WatchServiceDesc(ctx, zone, revision)
@@ -225,3 +414,372 @@ func (server *Server) v1WatchServiceDesc(c echo.Context) error {
 	}
 	return JSONResult(c, result)
 }
+
+func (server *Server) v1SyncServices(c echo.Context) error {
+	var states []services.SyncState
+	if ok, err := JSONFormParam(c, "states", &states); !ok {
+		return err
+	}
+	notPermitted := make([]string, 0)
+	for _, state := range states {
+		if server.publicQuery(state.Service) {
+			continue
+		}
+		if ok, err := server.checkPerm(c, apps.PermTypeService, false, state.Service); err == nil {
+			if !ok {
+				notPermitted = append(notPermitted, state.Service)
+			}
+		} else {
+			return JSONError(c, err)
+		}
+	}
+	if len(notPermitted) > 0 {
+		return server.newNotPermittedResp(c, notPermitted...)
+	}
+	result, err := server.services.Sync(server.ctx(c), server.getRemoteIP(c), states)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+func (server *Server) v1QueryServices(c echo.Context) error {
+	var refs []services.ServiceRef
+	if ok, err := JSONFormParam(c, "services", &refs); !ok {
+		return err
+	}
+	notPermitted := make([]string, 0)
+	for _, ref := range refs {
+		if server.publicQuery(ref.Service) {
+			continue
+		}
+		if ok, err := server.checkPerm(c, apps.PermTypeService, false, ref.Service); err == nil {
+			if !ok {
+				notPermitted = append(notPermitted, ref.Service)
+			}
+		} else {
+			return JSONError(c, err)
+		}
+	}
+	if len(notPermitted) > 0 {
+		return server.newNotPermittedResp(c, notPermitted...)
+	}
+	result, err := server.services.QueryMulti(server.ctx(c), server.getRemoteIP(c), refs)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+// v1WatchServices watch services of refs or names prefix in one request, revision 0
+// returns all of them at once
+func (server *Server) v1WatchServices(c echo.Context) error {
+	var refs []services.ServiceRef
+	if c.FormValue("services") != "" {
+		if ok, err := JSONFormParam(c, "services", &refs); !ok {
+			return err
+		}
+	}
+	revision, ok, err := IntFormParamD(c, "revision", 0)
+	if !ok {
+		return err
+	}
+	timeout, ok, err := IntFormParamD(c, "timeout", defaultWatchTimeout)
+	if !ok {
+		return err
+	}
+	notPermitted := make([]string, 0)
+	for _, ref := range refs {
+		if server.publicQuery(ref.Service) {
+			continue
+		}
+		if ok, err := server.checkPerm(c, apps.PermTypeService, false, ref.Service); err == nil {
+			if !ok {
+				notPermitted = append(notPermitted, ref.Service)
+			}
+		} else {
+			return JSONError(c, err)
+		}
+	}
+	if len(notPermitted) > 0 {
+		return server.newNotPermittedResp(c, notPermitted...)
+	}
+	visible := func(service string) bool {
+		if server.publicQuery(service) {
+			return true
+		}
+		ok, err := server.checkPerm(c, apps.PermTypeService, false, service)
+		return err == nil && ok
+	}
+
+	release, err := server.acquireWatch(c)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	defer release()
+	ctx, cancelFunc := context.WithTimeout(server.ctx(c), time.Duration(timeout)*time.Second)
+	defer cancelFunc()
+	result, err := server.services.WatchMulti(ctx, server.getRemoteIP(c), refs, c.FormValue("prefix"), revision, visible)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+// serviceVisibility whether services are readable by the caller, like queries, each checked once
+func (server *Server) serviceVisibility(c echo.Context) func(service string) bool {
+	checked := make(map[string]bool)
+	return func(service string) bool {
+		ok, seen := checked[service]
+		if !seen {
+			ok = server.publicQuery(service)
+			if !ok {
+				var err error
+				if ok, err = server.checkPerm(c, apps.PermTypeService, false, service); err != nil {
+					server.logger(c).Warningf("check perm of %s fail: %v", service, err)
+					ok = false
+				}
+			}
+			checked[service] = ok
+		}
+		return ok
+	}
+}
+
+// v1ServiceChecksums checksums of services readable by the caller
+func (server *Server) v1ServiceChecksums(c echo.Context) error {
+	result, err := server.services.Checksums(server.ctx(c), c.QueryParam("prefix"),
+		c.QueryParam("services") == "true", server.serviceVisibility(c))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+func (server *Server) v1ServiceZoneChecksums(c echo.Context) error {
+	result, err := server.services.ServiceZoneChecksums(server.ctx(c), c.ParamValues()[0])
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+// v1FlappingEndpoints endpoints of service flagged as flapping
+func (server *Server) v1FlappingEndpoints(c echo.Context) error {
+	return JSONResult(c, server.services.FlappingEndpoints(c.ParamValues()[0]))
+}
+
+type serviceCountResult struct {
+	Count    int64 `json:"count"`
+	Revision int64 `json:"revision"`
+}
+
+// v1ServiceCount endpoints count of service, in query zone if given
+func (server *Server) v1ServiceCount(c echo.Context) error {
+	count, rev, err := server.services.Count(server.ctx(c), c.ParamValues()[0], c.QueryParam("zone"))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, serviceCountResult{Count: count, Revision: rev})
+}
+
+// v1ServiceHistory changes of service name seen by this server since query since (unix seconds),
+// of query version if given
+func (server *Server) v1ServiceHistory(c echo.Context) error {
+	since, ok, err := IntQueryParamD(c, "since", 0)
+	if !ok {
+		return err
+	}
+	history, err := server.services.History(c.ParamValues()[0], c.QueryParam("version"), time.Unix(since, 0))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, history)
+}
+
+func (server *Server) v1CompareServiceChecksums(c echo.Context) error {
+	var checksums map[string]string
+	if ok, err := JSONFormParam(c, "checksums", &checksums); !ok {
+		return err
+	}
+	result, err := server.services.CompareChecksums(server.ctx(c), c.FormValue("prefix"), checksums,
+		server.serviceVisibility(c))
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+func (server *Server) v1UnplugServiceLease(c echo.Context) error {
+	leaseID, err := parseLeaseID(c.ParamValues()[0])
+	if err != nil {
+		return err
+	}
+	nodes, err := server.services.LeaseNodes(server.ctx(c), leaseID)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	notPermitted := make([]string, 0)
+	for _, node := range nodes {
+		if ok, err := server.checkPerm(c, apps.PermTypeService, true, node.Service); err == nil {
+			if !ok {
+				notPermitted = append(notPermitted, node.Service)
+			}
+		} else {
+			return JSONError(c, err)
+		}
+	}
+	if len(notPermitted) > 0 {
+		return server.newNotPermittedResp(c, notPermitted...)
+	}
+	ctx, dryRun := server.dryRunCtx(server.ctx(c), c)
+	if err := server.services.UnplugByLease(ctx, leaseID); err != nil {
+		return JSONError(c, err)
+	}
+	if dryRun != nil {
+		return JSONResult(c, dryRun)
+	}
+	return JSONResult(c, nodes)
+}
+
+func (server *Server) v1UnplugAllService(c echo.Context) error {
+	service, zone := c.ParamValues()[0], c.QueryParam("zone")
+	revokeLeases := c.QueryParam("revoke_leases") == "true"
+	if revokeLeases {
+		// revoking unplugs other keys bound to the leases too
+		leases, err := server.services.ServiceLeases(server.ctx(c), service, zone)
+		if err != nil {
+			return JSONError(c, err)
+		}
+		notPermitted := make([]string, 0)
+		checked := map[string]bool{service: true}
+		for _, leaseID := range leases {
+			nodes, err := server.services.LeaseNodes(server.ctx(c), leaseID)
+			if err != nil {
+				if errors.Is(err, utils.ErrNotFound) {
+					continue
+				}
+				return JSONError(c, err)
+			}
+			for _, node := range nodes {
+				if checked[node.Service] {
+					continue
+				}
+				checked[node.Service] = true
+				if ok, err := server.checkPerm(c, apps.PermTypeService, true, node.Service); err == nil {
+					if !ok {
+						notPermitted = append(notPermitted, node.Service)
+					}
+				} else {
+					return JSONError(c, err)
+				}
+			}
+		}
+		if len(notPermitted) > 0 {
+			return server.newNotPermittedResp(c, notPermitted...)
+		}
+	}
+	result, err := server.services.UnplugAll(server.ctx(c), service, zone, revokeLeases)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, result)
+}
+
+const (
+	defaultLeaseWarningWithin = 10 // in seconds
+	// lease ttls are shared by watchers, see services.CachedLeaseTTLs
+	leaseWarningPollInterval = 2 * time.Second
+)
+
+// v1LeaseWarnings leases of readable service endpoints expiring within seconds,
+// blocks until there are any or timeout if watch
+func (server *Server) v1LeaseWarnings(c echo.Context) error {
+	within, ok, err := IntQueryParamD(c, "within", defaultLeaseWarningWithin)
+	if !ok {
+		return err
+	}
+	timeout, ok, err := IntQueryParamD(c, "timeout", defaultWatchTimeout)
+	if !ok {
+		return err
+	}
+	watch := c.QueryParam("watch") == "true"
+	if watch {
+		release, err := server.acquireWatch(c)
+		if err != nil {
+			return JSONError(c, err)
+		}
+		defer release()
+	}
+	ctx, cancelFunc := context.WithTimeout(server.ctx(c), time.Duration(timeout)*time.Second)
+	defer cancelFunc()
+
+	permitted := make(map[string]bool)
+	for {
+		leases, err := server.services.CachedLeaseTTLs(ctx)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return JSONResult(c, []services.LeaseTTL{})
+			}
+			return JSONError(c, err)
+		}
+		warnings := make([]services.LeaseTTL, 0)
+		for _, lease := range leases {
+			if lease.TTL > within {
+				break
+			}
+			nodes := lease.Nodes[:0]
+			for _, node := range lease.Nodes {
+				ok, checked := permitted[node.Service]
+				if !checked {
+					if ok, err = server.checkPerm(c, apps.PermTypeService, false, node.Service); err != nil {
+						return JSONError(c, err)
+					}
+					permitted[node.Service] = ok
+				}
+				if ok {
+					nodes = append(nodes, node)
+				}
+			}
+			if len(nodes) > 0 {
+				lease.Nodes = nodes
+				warnings = append(warnings, lease)
+			}
+		}
+		if len(warnings) > 0 || !watch {
+			return JSONResult(c, warnings)
+		}
+		select {
+		case <-ctx.Done():
+			return JSONResult(c, warnings)
+		case <-time.After(leaseWarningPollInterval):
+		}
+	}
+}
+
+// v1QueryServiceVersion query the highest version of service name matching
+// version, e.g. `>=1.2 <2.0`, latest if empty
+func (server *Server) v1QueryServiceVersion(c echo.Context) error {
+	versionRange := c.QueryParam("version")
+	if versionRange == "" {
+		versionRange = services.VersionLatest
+	}
+	service, err := server.services.ResolveVersion(server.ctx(c), c.ParamValues()[0], versionRange)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	if !server.publicQuery(service) {
+		if ok, err := server.checkPerm(c, apps.PermTypeService, false, service); err == nil {
+			if !ok {
+				return server.newNotPermittedResp(c, service)
+			}
+		} else {
+			return JSONError(c, err)
+		}
+	}
+	result, rev, err := server.services.Query(server.queryCtx(c), server.getRemoteIP(c), service)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	return JSONResult(c, serviceQueryResultV1{Service: result, Revision: rev})
+}
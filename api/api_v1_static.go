@@ -0,0 +1,51 @@
+package api
+
+import (
+	"github.com/infrmods/xbus/services"
+	"github.com/labstack/echo/v4"
+)
+
+func (server *Server) v1PlugStaticService(c echo.Context) error {
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return err
+	}
+	var descs []services.ServiceDescV1
+	if ok, err := JSONFormParam(c, "descs", &descs); !ok {
+		return err
+	}
+	for i := range descs {
+		if descs[i].Zone == "" {
+			descs[i].Zone = services.DefaultZone
+		}
+	}
+	var endpoint services.ServiceEndpoint
+	if ok, err := JSONFormParam(c, "endpoint", &endpoint); !ok {
+		return err
+	}
+	if err := server.verifyEndpoint(c, descs, &endpoint); err != nil {
+		return JSONError(c, err)
+	}
+	ctx, dryRun := server.dryRunCtx(server.plugCtx(c), c)
+	if err := server.services.PlugStatic(ctx, descs, &endpoint); err != nil {
+		return JSONError(c, err)
+	}
+	if dryRun != nil {
+		return JSONResult(c, dryRun)
+	}
+	return JSONOk(c)
+}
+
+func (server *Server) v1UnplugStaticService(c echo.Context) error {
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return err
+	}
+	params := c.ParamValues()
+	ctx, dryRun := server.dryRunCtx(server.ctx(c), c)
+	if err := server.services.Unplug(ctx, params[0], params[1], params[2]); err != nil {
+		return JSONError(c, err)
+	}
+	if dryRun != nil {
+		return JSONResult(c, dryRun)
+	}
+	return JSONOk(c)
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// websocket message ops from clients
+const (
+	wsOpSubscribe   = "subscribe"
+	wsOpUnsubscribe = "unsubscribe"
+)
+
+// websocket message types to clients, besides the plug, unplug and update of endpoints
+const (
+	wsTypeSnapshot     = "snapshot"
+	wsTypeUnsubscribed = "unsubscribed"
+	wsTypeError        = "error"
+)
+
+const (
+	wsMaxSubscriptions = 100
+	wsMaxMessageSize   = 64 * 1024
+	wsPingInterval     = 20 * time.Second
+	wsPongWait         = 60 * time.Second
+	wsWriteWait        = 10 * time.Second
+)
+
+// wsRequest message from clients, ID chosen by the client identifies the subscription
+type wsRequest struct {
+	Op      string `json:"op"`
+	ID      string `json:"id"`
+	Service string `json:"service"`
+}
+
+// wsMessage message to clients, Data is a serviceQueryResultV1 for snapshots,
+// an endpointEvent for endpoint changes and an error for errors
+type wsMessage struct {
+	Type     string      `json:"type"`
+	ID       string      `json:"id,omitempty"`
+	Revision int64       `json:"revision,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// wsUpgrader checks Origin against Host, which only keeps browsers on other sites from
+// riding on their certs; any other client can send any Origin, so subscriptions are still
+// authorized by the app and query perms like watches
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+// wsConn websocket connection with serialized writes
+type wsConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (conn *wsConn) send(msg wsMessage) bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return conn.conn.WriteJSON(msg) == nil
+}
+
+func (conn *wsConn) ping() bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)) == nil
+}
+
+// v1ServiceWebSocket watch services over a websocket, clients subscribe and unsubscribe
+// services by messages, each subscription gets a snapshot of the service first, then plug,
+// unplug and update messages of its endpoints, all tagged with the subscription id;
+// each subscription counts as a watch of the client
+func (server *Server) v1ServiceWebSocket(c echo.Context) error {
+	if server.isStopping() {
+		return JSONError(c, utils.NewError(utils.EcodeServerStopping, "server is stopping"))
+	}
+	defer server.stopWithServer(c)()
+	ws, err := wsUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// upgrader has responded
+		return nil
+	}
+	defer ws.Close()
+	conn := &wsConn{conn: ws}
+	ctx, cancel := context.WithCancel(server.ctx(c))
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				// unblock ReadJSON when the server stops
+				ws.Close()
+				return
+			case <-ticker.C:
+				if !conn.ping() {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	ws.SetReadLimit(wsMaxMessageSize)
+	ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	subscriptions := make(map[string]context.CancelFunc)
+	defer func() {
+		for _, cancel := range subscriptions {
+			cancel()
+		}
+	}()
+	for {
+		var req wsRequest
+		if err := ws.ReadJSON(&req); err != nil {
+			if _, ok := err.(*websocket.CloseError); !ok && ctx.Err() == nil {
+				logging.Debugf("read websocket of %s fail: %v", server.getRemoteIP(c), err)
+			}
+			return nil
+		}
+		ws.SetReadDeadline(time.Now().Add(wsPongWait))
+		switch req.Op {
+		case wsOpSubscribe:
+			if err := server.checkWSSubscribe(c, &req, subscriptions); err != nil {
+				conn.send(wsMessage{Type: wsTypeError, ID: req.ID, Data: formatError(err)})
+				continue
+			}
+			release, err := server.acquireWatchSlot(c)
+			if err != nil {
+				conn.send(wsMessage{Type: wsTypeError, ID: req.ID, Data: formatError(err)})
+				continue
+			}
+			subCtx, subCancel := context.WithCancel(ctx)
+			subscriptions[req.ID] = subCancel
+			wg.Add(1)
+			go func(req wsRequest) {
+				defer wg.Done()
+				defer release()
+				server.runWSSubscription(subCtx, c, conn, &req)
+			}(req)
+		case wsOpUnsubscribe:
+			subCancel, ok := subscriptions[req.ID]
+			if !ok {
+				conn.send(wsMessage{Type: wsTypeError, ID: req.ID,
+					Data: utils.Errorf(utils.EcodeNotFound, "no subscription: %s", req.ID)})
+				continue
+			}
+			subCancel()
+			delete(subscriptions, req.ID)
+			conn.send(wsMessage{Type: wsTypeUnsubscribed, ID: req.ID})
+		default:
+			conn.send(wsMessage{Type: wsTypeError, ID: req.ID,
+				Data: utils.Errorf(utils.EcodeInvalidParam, "unknown op: %s", req.Op)})
+		}
+	}
+}
+
+func (server *Server) checkWSSubscribe(c echo.Context, req *wsRequest, subscriptions map[string]context.CancelFunc) error {
+	if req.ID == "" {
+		return utils.NewError(utils.EcodeMissingParam, "missing id")
+	}
+	if _, ok := subscriptions[req.ID]; ok {
+		return utils.Errorf(utils.EcodeInvalidParam, "duplicate subscription: %s", req.ID)
+	}
+	if len(subscriptions) >= wsMaxSubscriptions {
+		return utils.Errorf(utils.EcodeInvalidParam, "too many subscriptions, max: %d", wsMaxSubscriptions)
+	}
+	if req.Service == "" {
+		return utils.NewError(utils.EcodeMissingParam, "missing service")
+	}
+	if server.publicQuery(req.Service) {
+		return nil
+	}
+	ok, err := server.checkPerm(c, apps.PermTypeService, false, req.Service)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		msg := fmt.Sprintf("not permitted: [%s] %s", server.appName(c), req.Service)
+		return utils.NewNotPermittedError(msg, []string{req.Service})
+	}
+	return nil
+}
+
+func (server *Server) runWSSubscription(ctx context.Context, c echo.Context, conn *wsConn, req *wsRequest) {
+	clientIP := server.getRemoteIP(c)
+	service, revision, err := server.services.Query(ctx, clientIP, req.Service)
+	if err != nil {
+		if ctx.Err() == nil {
+			conn.send(wsMessage{Type: wsTypeError, ID: req.ID, Data: formatError(err)})
+		}
+		return
+	}
+	if !conn.send(wsMessage{Type: wsTypeSnapshot, ID: req.ID, Revision: revision,
+		Data: serviceQueryResultV1{Service: service, Revision: revision}}) {
+		return
+	}
+	send := func(typ string, revision int64, data interface{}) bool {
+		return conn.send(wsMessage{Type: typ, ID: req.ID, Revision: revision, Data: data})
+	}
+	// connection level pings keep it alive
+	heartbeat := func() bool { return true }
+	if err := server.followService(ctx, clientIP, req.Service, service, revision, send, heartbeat); err != nil {
+		conn.send(wsMessage{Type: wsTypeError, ID: req.ID, Data: formatError(err)})
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/services"
+	"github.com/labstack/echo/v4"
+)
+
+const dashboardTimeout = 10 * time.Second
+
+type dashboardResult struct {
+	Zones   []services.ZoneEndpoints `json:"zones"`
+	Leases  []services.LeaseTTL      `json:"leases"`
+	Changes []services.Change        `json:"changes"`
+}
+
+// dashboard GET /dashboard, the page polls v1Dashboard
+func (server *Server) dashboard(c echo.Context) error {
+	return c.HTML(http.StatusOK, dashboardHTML)
+}
+
+// v1Dashboard endpoint counts, lease ttls and recent changes of visible services
+func (server *Server) v1Dashboard(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(server.ctx(c), dashboardTimeout)
+	defer cancel()
+	visible := make(map[string]bool)
+	isVisible := func(service string) (bool, error) {
+		ok, checked := visible[service]
+		if !checked {
+			ok = server.publicQuery(service)
+			if !ok {
+				var err error
+				if ok, err = server.checkPerm(c, apps.PermTypeService, false, service); err != nil {
+					return false, err
+				}
+			}
+			visible[service] = ok
+		}
+		return ok, nil
+	}
+
+	result := dashboardResult{Zones: []services.ZoneEndpoints{}, Leases: []services.LeaseTTL{}, Changes: []services.Change{}}
+	counts, err := server.services.EndpointCounts(ctx)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	for _, count := range counts {
+		if ok, err := isVisible(count.Service); err != nil {
+			return JSONError(c, err)
+		} else if ok {
+			result.Zones = append(result.Zones, count)
+		}
+	}
+	leases, err := server.services.CachedLeaseTTLs(ctx)
+	if err != nil {
+		return JSONError(c, err)
+	}
+	for _, lease := range leases {
+		nodes := lease.Nodes[:0]
+		for _, node := range lease.Nodes {
+			if ok, err := isVisible(node.Service); err != nil {
+				return JSONError(c, err)
+			} else if ok {
+				nodes = append(nodes, node)
+			}
+		}
+		if len(nodes) > 0 {
+			lease.Nodes = nodes
+			result.Leases = append(result.Leases, lease)
+		}
+	}
+	for _, change := range server.services.RecentChanges() {
+		if ok, err := isVisible(change.Service); err != nil {
+			return JSONError(c, err)
+		} else if ok {
+			result.Changes = append(result.Changes, change)
+		}
+	}
+	return JSONResult(c, result)
+}
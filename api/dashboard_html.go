@@ -0,0 +1,161 @@
+package api
+
+// dashboardHTML the dashboard page, self-contained so the binary serves it without files
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>xbus dashboard</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 20px; color: #222; }
+h1 { font-size: 20px; }
+h2 { font-size: 16px; margin-top: 24px; }
+table { border-collapse: collapse; width: 100%; font-size: 13px; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #eee; }
+th { background: #f6f6f6; }
+tr.service { cursor: pointer; }
+tr.service:hover { background: #f0f6ff; }
+.ok { color: #1a7f37; }
+.expiring { color: #b35900; font-weight: bold; }
+.static { color: #666; }
+.zero { color: #cf222e; font-weight: bold; }
+#status { color: #666; font-size: 12px; }
+#filter { width: 300px; padding: 4px; }
+</style>
+</head>
+<body>
+<h1>xbus dashboard</h1>
+<div><input id="filter" placeholder="filter services"> <span id="status"></span></div>
+
+<h2>Services</h2>
+<table>
+<thead><tr><th>Service</th><th>Version</th><th>Zones</th><th>Instances</th><th>Health</th></tr></thead>
+<tbody id="services"></tbody>
+</table>
+
+<div id="detail"></div>
+
+<h2>Lowest lease TTLs</h2>
+<table>
+<thead><tr><th>Lease</th><th>TTL</th><th>Granted</th><th>Endpoints</th></tr></thead>
+<tbody id="leases"></tbody>
+</table>
+
+<h2>Recent changes</h2>
+<table>
+<thead><tr><th>Time</th><th>Type</th><th>Service</th><th>Zone</th><th>Address</th></tr></thead>
+<tbody id="changes"></tbody>
+</table>
+
+<script>
+var data = null, selected = null;
+
+function esc(s) {
+  return String(s == null ? "" : s).replace(/[&<>"]/g, function (c) {
+    return {"&": "&amp;", "<": "&lt;", ">": "&gt;", "\"": "&quot;"}[c];
+  });
+}
+
+function get(path) {
+  return fetch(path, {credentials: "same-origin"}).then(function (resp) { return resp.json(); }).then(function (r) {
+    if (!r.ok) { throw new Error(r.error ? r.error.code + ": " + (r.error.message || "") : "request fail"); }
+    return r.result;
+  });
+}
+
+function leaseIndex() {
+  var index = {};
+  data.leases.forEach(function (lease) {
+    lease.nodes.forEach(function (node) {
+      index[node.service + "/" + node.zone + "/" + node.address] = lease;
+    });
+  });
+  return index;
+}
+
+// expiring once below 2/3 of granted ttl, kept alive leases never are
+function health(lease) {
+  if (!lease) { return "static"; }
+  return lease.ttl * 3 < lease.granted_ttl * 2 ? "expiring" : "ok";
+}
+
+function render() {
+  var filter = document.getElementById("filter").value;
+  var services = {}, index = leaseIndex();
+  data.zones.forEach(function (z) {
+    var s = services[z.service] = services[z.service] || {zones: 0, endpoints: 0};
+    s.zones++;
+    s.endpoints += z.endpoints;
+  });
+  var expiring = {};
+  Object.keys(index).forEach(function (key) {
+    if (health(index[key]) === "expiring") {
+      var service = key.split("/")[0];
+      expiring[service] = (expiring[service] || 0) + 1;
+    }
+  });
+  var rows = Object.keys(services).sort().filter(function (name) { return name.indexOf(filter) >= 0; }).map(function (name) {
+    var s = services[name], i = name.indexOf(":");
+    var h = s.endpoints === 0 ? '<span class="zero">no instances</span>' :
+      expiring[name] ? '<span class="expiring">' + expiring[name] + " expiring</span>" : '<span class="ok">ok</span>';
+    return '<tr class="service" data-service="' + esc(name) + '"><td>' + esc(i >= 0 ? name.slice(0, i) : name) +
+      "</td><td>" + esc(i >= 0 ? name.slice(i + 1) : "") + "</td><td>" + s.zones + "</td><td>" + s.endpoints +
+      "</td><td>" + h + "</td></tr>";
+  });
+  document.getElementById("services").innerHTML = rows.join("");
+  Array.prototype.forEach.call(document.querySelectorAll("tr.service"), function (tr) {
+    tr.onclick = function () { selected = tr.getAttribute("data-service"); renderDetail(); };
+  });
+
+  document.getElementById("leases").innerHTML = data.leases.slice(0, 20).map(function (lease) {
+    return '<tr><td>' + lease.lease_id + '</td><td class="' + health(lease) + '">' + lease.ttl + "s</td><td>" +
+      lease.granted_ttl + "s</td><td>" + lease.nodes.map(function (n) {
+        return esc(n.service + " " + n.zone + " " + n.address);
+      }).join("<br>") + "</td></tr>";
+  }).join("");
+
+  document.getElementById("changes").innerHTML = data.changes.map(function (c) {
+    return "<tr><td>" + esc(new Date(c.time).toLocaleString()) + "</td><td>" + esc(c.type) + "</td><td>" +
+      esc(c.service) + "</td><td>" + esc(c.zone) + "</td><td>" + esc(c.address) + "</td></tr>";
+  }).join("");
+}
+
+function renderDetail() {
+  var detail = document.getElementById("detail");
+  if (!selected) { detail.innerHTML = ""; return; }
+  get("/api/v1/services/" + encodeURIComponent(selected)).then(function (result) {
+    var index = leaseIndex(), rows = [];
+    Object.keys(result.service.zones).sort().forEach(function (zone) {
+      result.service.zones[zone].endpoints.forEach(function (e) {
+        var lease = index[selected + "/" + zone + "/" + e.address], h = health(lease);
+        rows.push("<tr><td>" + esc(zone) + "</td><td>" + esc(e.address) + "</td><td>" + esc(e.locality) +
+          "</td><td>" + esc(e.origin) + '</td><td class="' + h + '">' + h + "</td><td>" +
+          (lease ? lease.ttl + "s / " + lease.granted_ttl + "s" : "") + "</td><td>" + esc(e.config) + "</td></tr>");
+      });
+    });
+    detail.innerHTML = "<h2>" + esc(selected) + " (revision " + result.revision + ")</h2>" +
+      "<table><thead><tr><th>Zone</th><th>Address</th><th>Locality</th><th>Origin</th><th>Health</th>" +
+      "<th>Lease TTL</th><th>Config</th></tr></thead><tbody>" + rows.join("") + "</tbody></table>";
+  }).catch(function (err) {
+    detail.innerHTML = "<h2>" + esc(selected) + "</h2><p>" + esc(err.message) + "</p>";
+  });
+}
+
+function refresh() {
+  get("/api/v1/dashboard").then(function (result) {
+    data = result;
+    render();
+    renderDetail();
+    document.getElementById("status").textContent = "updated " + new Date().toLocaleTimeString();
+  }).catch(function (err) {
+    document.getElementById("status").textContent = "refresh fail: " + err.message;
+  });
+}
+
+document.getElementById("filter").oninput = function () { if (data) { render(); } };
+refresh();
+setInterval(refresh, 10000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,17 @@
+package api
+
+import (
+	"context"
+
+	"github.com/infrmods/xbus/services"
+	"github.com/labstack/echo/v4"
+)
+
+// dryRunCtx ctx of mutating apis, with dry_run=true they are fully validated,
+// responding the changes they would make instead of committing them
+func (server *Server) dryRunCtx(ctx context.Context, c echo.Context) (context.Context, *services.DryRun) {
+	if c.FormValue("dry_run") != "true" {
+		return ctx, nil
+	}
+	return services.WithDryRun(ctx)
+}
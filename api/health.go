@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/infrmods/xbus/logging"
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthConfig grpc.health.v1.Health server on Listen (plain text), disabled if empty;
+// subsystems are checked every Interval, each within Timeout
+type HealthConfig struct {
+	Listen   string
+	Interval time.Duration `default:"5s"`
+	Timeout  time.Duration `default:"3s"`
+}
+
+var errServerDraining = errors.New("server is draining")
+
+// health service names, "" for xbus as a whole
+const (
+	healthServiceEtcd     = "etcd"
+	healthServiceDatabase = "database"
+)
+
+// healthServer grpc health of the server
+type healthServer struct {
+	config HealthConfig
+	health *health.Server
+	grpc   *grpc.Server
+}
+
+func (server *Server) newHealthServer() *healthServer {
+	h := &healthServer{config: server.config.Health, health: health.NewServer(), grpc: grpc.NewServer()}
+	healthpb.RegisterHealthServer(h.grpc, h.health)
+	for _, service := range []string{"", healthServiceEtcd, healthServiceDatabase} {
+		h.health.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	return h
+}
+
+func servingStatus(serving bool) healthpb.HealthCheckResponse_ServingStatus {
+	if serving {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// checkHealth update status of subsystems, xbus serves if all of them serve
+func (server *Server) checkHealth(h *healthServer) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	defer cancel()
+	etcdErr := server.services.CheckEtcd(ctx)
+	if etcdErr != nil {
+		logging.Warningf("health: check etcd fail: %v", etcdErr)
+	}
+	dbErr := server.services.PingDB(ctx)
+	if dbErr != nil {
+		logging.Warningf("health: ping db fail: %v", dbErr)
+	}
+	h.health.SetServingStatus(healthServiceEtcd, servingStatus(etcdErr == nil))
+	h.health.SetServingStatus(healthServiceDatabase, servingStatus(dbErr == nil))
+	h.health.SetServingStatus("", servingStatus(etcdErr == nil && dbErr == nil))
+}
+
+// runHealth serve grpc health until the server stops; all services turn NOT_SERVING
+// once draining starts, the listener is closed by stopHealth
+func (server *Server) runHealth(h *healthServer) {
+	listener, err := net.Listen("tcp", h.config.Listen)
+	if err != nil {
+		logging.Fatalf("listen health on %s fail: %v", h.config.Listen, err)
+	}
+	go func() {
+		if err := h.grpc.Serve(listener); err != nil {
+			logging.Errorf("serve health fail: %v", err)
+		}
+	}()
+	logging.Infof("health serving on %s", listener.Addr())
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+	for {
+		server.checkHealth(h)
+		select {
+		case <-server.stopping:
+			h.health.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (server *Server) stopHealth(h *healthServer) {
+	// Stop rather than GracefulStop, Watch streams of probes never end
+	h.grpc.Stop()
+}
+
+// probeCheck result of a subsystem check of /healthz and /readyz
+type probeCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"`
+
+	check func(ctx context.Context) error
+}
+
+type probeResult struct {
+	OK     bool         `json:"ok"`
+	Checks []probeCheck `json:"checks"`
+}
+
+// probe run checks, each within the health timeout, 503 with the failure reasons if any fails
+func (server *Server) probe(c echo.Context, checks ...probeCheck) error {
+	result := probeResult{OK: true, Checks: checks}
+	for i := range checks {
+		ctx, cancel := context.WithTimeout(server.ctx(c), server.config.Health.Timeout)
+		err := checks[i].check(ctx)
+		cancel()
+		checks[i].OK = err == nil
+		if err != nil {
+			result.OK, checks[i].Reason = false, err.Error()
+		}
+	}
+	if !result.OK {
+		return c.JSON(http.StatusServiceUnavailable, result)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// healthz liveness, fails only if the server is stuck, i.e. the watch hub is blocked
+func (server *Server) healthz(c echo.Context) error {
+	return server.probe(c, probeCheck{Name: "watch_hub", check: func(ctx context.Context) error {
+		return server.services.CheckWatchHub(ctx, false)
+	}})
+}
+
+// readyz readiness, fails if draining, etcd, the db or the lessor is unreachable,
+// or the watch hub is blocked or broke recently
+func (server *Server) readyz(c echo.Context) error {
+	return server.probe(c,
+		probeCheck{Name: "draining", check: func(context.Context) error {
+			if server.isStopping() {
+				return errServerDraining
+			}
+			return nil
+		}},
+		probeCheck{Name: healthServiceEtcd, check: server.services.CheckEtcd},
+		probeCheck{Name: healthServiceDatabase, check: server.services.PingDB},
+		probeCheck{Name: "leases", check: server.services.CheckLeases},
+		probeCheck{Name: "watch_hub", check: func(ctx context.Context) error {
+			return server.services.CheckWatchHub(ctx, true)
+		}})
+}
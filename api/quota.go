@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/infrmods/xbus/services"
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// clientID identity of clients, app name or remote ip of anonymous ones
+func (server *Server) clientID(c echo.Context) string {
+	if app := server.app(c); app != nil {
+		return app.Name
+	}
+	if ip := server.getRemoteIP(c); ip != nil {
+		return ip.String()
+	}
+	return c.Request().RemoteAddr
+}
+
+// acquireWatch count a long-lived watch of the client until release, fails with
+// QUOTA_EXCEEDED if the client already has MaxWatchesPerClient, or SERVER_STOPPING
+// on shutdown; ctx of c is canceled on shutdown so that the watch returns
+func (server *Server) acquireWatch(c echo.Context) (func(), error) {
+	if server.isStopping() {
+		return nil, utils.NewError(utils.EcodeServerStopping, "server is stopping")
+	}
+	release, err := server.acquireWatchSlot(c)
+	if err != nil {
+		return nil, err
+	}
+	stop := server.stopWithServer(c)
+	return func() {
+		stop()
+		release()
+	}, nil
+}
+
+// acquireWatchSlot count a watch of the client until release, without canceling on shutdown,
+// e.g. for each subscription of a websocket
+func (server *Server) acquireWatchSlot(c echo.Context) (func(), error) {
+	release := func() {}
+	if maxWatches := int(atomic.LoadInt64(&server.maxWatches)); maxWatches > 0 {
+		client := server.clientID(c)
+		server.watchesMu.Lock()
+		if server.watches[client] >= maxWatches {
+			server.watchesMu.Unlock()
+			return nil, utils.Errorf(utils.EcodeQuotaExceeded, "%s exceeds max watches: %d",
+				client, maxWatches)
+		}
+		server.watches[client]++
+		server.watchesMu.Unlock()
+		release = func() {
+			server.watchesMu.Lock()
+			defer server.watchesMu.Unlock()
+			if server.watches[client]--; server.watches[client] <= 0 {
+				delete(server.watches, client)
+			}
+		}
+	}
+	return release, nil
+}
+
+// stopWithServer cancel ctx of c on shutdown, until the returned stop is called
+func (server *Server) stopWithServer(c echo.Context) func() {
+	ctx, cancel := context.WithCancel(server.ctx(c))
+	c.Set("ctx", ctx)
+	go func() {
+		select {
+		case <-server.stopping:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return cancel
+}
+
+// plugCtx ctx of plugs, carrying the app for per app quotas and its cert for sealed endpoints
+func (server *Server) plugCtx(c echo.Context) context.Context {
+	if app := server.app(c); app != nil {
+		ctx := services.WithApp(server.ctx(c), app.Name)
+		if cert, err := app.Certificate(); err == nil && cert != nil {
+			ctx = services.WithSigner(ctx, cert)
+		}
+		return ctx
+	}
+	return server.ctx(c)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// rate limited operations
+const (
+	opPlug  = "plug"
+	opQuery = "query"
+	opWatch = "watch"
+)
+
+// RateLimit token bucket of Rate requests per second and Burst, unlimited if Rate is 0
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// RateLimitConfig rate limits per client (app, or remote ip of anonymous ones) and operation
+type RateLimitConfig struct {
+	Plug  RateLimit `yaml:"plug"`
+	Query RateLimit `yaml:"query"`
+	Watch RateLimit `yaml:"watch"`
+}
+
+func (config *RateLimitConfig) limitOf(op string) RateLimit {
+	switch op {
+	case opPlug:
+		return config.Plug
+	case opWatch:
+		return config.Watch
+	}
+	return config.Query
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take a token, or the wait until one is available
+func (b *tokenBucket) take(limit RateLimit, now time.Time) (bool, time.Duration) {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = math.Max(1, limit.Rate)
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+type bucketKey struct {
+	client string
+	op     string
+}
+
+type rateLimiter struct {
+	// config RateLimitConfig, replaced on reload
+	config  atomic.Value
+	mu      sync.Mutex
+	buckets map[bucketKey]*tokenBucket
+	swept   time.Time
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	limiter := &rateLimiter{buckets: make(map[bucketKey]*tokenBucket), swept: time.Now()}
+	limiter.setConfig(config)
+	return limiter
+}
+
+// setConfig replace the limits, buckets are kept
+func (limiter *rateLimiter) setConfig(config RateLimitConfig) {
+	limiter.config.Store(config)
+}
+
+const rateLimitSweepInterval = 10 * time.Minute
+
+func (limiter *rateLimiter) allow(client, op string, now time.Time) (bool, time.Duration) {
+	config := limiter.config.Load().(RateLimitConfig)
+	limit := config.limitOf(op)
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if now.Sub(limiter.swept) >= rateLimitSweepInterval {
+		// idle buckets are full, the same as new ones
+		for key, bucket := range limiter.buckets {
+			if now.Sub(bucket.last) >= rateLimitSweepInterval {
+				delete(limiter.buckets, key)
+			}
+		}
+		limiter.swept = now
+	}
+	key := bucketKey{client: client, op: op}
+	bucket := limiter.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: math.Inf(1), last: now}
+		limiter.buckets[key] = bucket
+	}
+	return bucket.take(limit, now)
+}
+
+// newRateLimit rate limit middleware of op, queries with watch=true are watches
+func (server *Server) newRateLimit(op string) echo.MiddlewareFunc {
+	return echo.MiddlewareFunc(func(h echo.HandlerFunc) echo.HandlerFunc {
+		return echo.HandlerFunc(func(c echo.Context) error {
+			op := op
+			if op == opQuery && c.QueryParam("watch") == "true" {
+				op = opWatch
+			}
+			client := server.clientID(c)
+			if ok, wait := server.limiter.allow(client, op, time.Now()); !ok {
+				metrics.RateLimited.WithLabelValues(op).Inc()
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				return JSONErrorC(c, http.StatusTooManyRequests,
+					utils.Errorf(utils.EcodeRateLimited, "%s of %s rate limited", op, client))
+			}
+			return h(c)
+		})
+	})
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"sync/atomic"
+
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// OnReload set the func reloading config, called on SIGHUP and by POST /api/admin/reload
+func (server *Server) OnReload(reload func() error) {
+	server.reloadMu.Lock()
+	defer server.reloadMu.Unlock()
+	server.reload = reload
+}
+
+// Reload reload config by the func set by OnReload, reloads are serialized
+func (server *Server) Reload() error {
+	server.reloadMu.Lock()
+	defer server.reloadMu.Unlock()
+	if server.reload == nil {
+		return utils.NewError(utils.EcodeNotPermitted, "reload not supported")
+	}
+	if err := server.reload(); err != nil {
+		logging.Errorf("reload config fail: %v", err)
+		return utils.NewSystemError("reload config fail: " + err.Error())
+	}
+	logging.Info("config reloaded")
+	return nil
+}
+
+// PrepareReload prepare reloading tls key pair, rate limits and max watches per client
+// of config, returns the func applying them, nothing is changed if it fails.
+// Other parts of config need restarting
+func (server *Server) PrepareReload(config *Config) (func(), error) {
+	var keyPair *utils.KeyPairReloader
+	if server.tls {
+		if config.CertFile == "" {
+			return nil, utils.NewError(utils.EcodeInvalidParam, "tls can't be disabled by reload")
+		}
+		var err error
+		if keyPair, err = utils.NewKeyPairReloader(config.CertFile, config.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if config.Listen != server.config.Listen {
+		logging.Warningf("listen changed to %s, ignored until restart", config.Listen)
+	}
+	return func() {
+		if keyPair != nil {
+			server.keyPair.Store(keyPair)
+		}
+		server.limiter.setConfig(config.RateLimits)
+		atomic.StoreInt64(&server.maxWatches, int64(config.MaxWatchesPerClient))
+	}, nil
+}
+
+func (server *Server) adminReload(c echo.Context) error {
+	if err := server.Reload(); err != nil {
+		return JSONError(c, err)
+	}
+	return JSONOk(c)
+}
@@ -1,8 +1,10 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/infrmods/xbus/services"
 	"github.com/infrmods/xbus/utils"
 	"github.com/labstack/echo/v4"
 )
@@ -27,22 +29,48 @@ func JSONResult(c echo.Context, result interface{}) error {
 	return c.JSON(http.StatusOK, Response{Ok: true, Result: result})
 }
 
+// formatError the coded error err is or wraps, SYSTEM_ERROR if none
 func formatError(err error) *utils.Error {
-	e, ok := err.(*utils.Error)
-	if ok {
+	var we *services.WatchError
+	if errors.As(err, &we) {
+		return &utils.Error{Code: we.Code, Message: we.Message}
+	}
+	var e *utils.Error
+	if errors.As(err, &e) {
 		return e
 	}
 	return &utils.Error{Code: utils.EcodeSystemError, Message: err.Error()}
 }
 
-// JSONError json error
+// retryHinted the coded error err is or wraps with the retryable hint of its code,
+// watch errors keep their revision
+func retryHinted(err error) error {
+	var we *services.WatchError
+	if errors.As(err, &we) {
+		hinted := *we
+		hinted.Retryable = hinted.Retryable || utils.IsRetryableCode(we.Code)
+		return &hinted
+	}
+	var e *utils.Error
+	if errors.As(err, &e) {
+		hinted := *e
+		hinted.Retryable = utils.IsRetryable(e)
+		return &hinted
+	}
+	return err
+}
+
+// JSONError json error, with the http status mapped from its code if api.error_http_status,
+// otherwise 200, or 503 for SYSTEM_ERROR
 func JSONError(c echo.Context, err error) error {
 	code := http.StatusOK
 	e := formatError(err)
-	if e.Code == utils.EcodeSystemError {
+	if mapped, _ := c.Get("errorHTTPStatus").(bool); mapped {
+		code = e.HTTPStatus()
+	} else if e.Code == utils.EcodeSystemError {
 		code = http.StatusServiceUnavailable
 	}
-	return c.JSON(code, Response{Ok: false, Error: err})
+	return c.JSON(code, Response{Ok: false, Error: retryHinted(err)})
 }
 
 // JSONErrorC json error with code
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"net"
+	"os"
+	"time"
+
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/services"
+)
+
+// SelfConfig registration of the server itself as Service in Zone, so that clients and
+// load balancers can discover xbus servers, Address is Listen with the hostname if empty
+type SelfConfig struct {
+	Enable  bool
+	Service string `default:"xbus.server:v1"`
+	Zone    string `default:"default"`
+	Address string
+	TTL     time.Duration `default:"10s"`
+}
+
+// selfAddress advertised address of the server
+func (server *Server) selfAddress() (string, error) {
+	if server.config.Self.Address != "" {
+		return server.config.Self.Address, nil
+	}
+	host, port, err := net.SplitHostPort(server.listenAddr())
+	if err != nil {
+		return "", err
+	}
+	if port == "https" {
+		port = "443"
+	} else if port == "http" {
+		port = "80"
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		if host, err = os.Hostname(); err != nil {
+			return "", err
+		}
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// runSelfRegistration keep the server registered until stopping
+func (server *Server) runSelfRegistration() {
+	defer close(server.selfDone)
+	config := &server.config.Self
+	addr, err := server.selfAddress()
+	if err != nil {
+		logging.Errorf("get address of self registration fail: %v", err)
+		return
+	}
+	scheme := "http"
+	if server.tls {
+		scheme = "https"
+	}
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = 10 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-server.stopping
+		cancel()
+	}()
+	server.services.RunRegistration(ctx, ttl,
+		[]services.ServiceDescV1{{Service: config.Service, Zone: config.Zone, Type: "http", Proto: "xbus",
+			Description: "xbus api servers"}},
+		&services.ServiceEndpoint{Address: addr, Config: `{"scheme":"` + scheme + `"}`})
+	logging.Infof("self registration %s of %s unplugged", addr, config.Service)
+}
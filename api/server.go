@@ -2,24 +2,35 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
-	"github.com/golang/glog"
 	"github.com/infrmods/xbus/apps"
 	"github.com/infrmods/xbus/configs"
+	"github.com/infrmods/xbus/locks"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/schemas"
+	"github.com/infrmods/xbus/secrets"
 	"github.com/infrmods/xbus/services"
+	"github.com/infrmods/xbus/tracing"
 	"github.com/infrmods/xbus/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/net/trace"
 )
 
 // IPNet ipnet
@@ -31,8 +42,21 @@ type Config struct {
 	CertFile    string        `default:"apicert.pem"`
 	KeyFile     string        `default:"apikey.pem"`
 	StopTimeout time.Duration `default:"60s" yaml:"stop_timeout"`
+	DrainDelay  time.Duration `yaml:"drain_delay"`
+	Self        SelfConfig    `yaml:"self"`
+	ServiceTTL  TTLPolicy     `yaml:"service_ttl"`
+	Health      HealthConfig  `yaml:"health"`
+
+	// MaxWatchesPerClient counted by each server, not across replicas
+	MaxWatchesPerClient int             `yaml:"max_watches_per_client"`
+	RateLimits          RateLimitConfig `yaml:"rate_limits"`
 
 	PermitPublicServiceQuery bool `default:"true"`
+	EnableMetrics            bool `default:"true" yaml:"enable_metrics"`
+	EnableTracing            bool `yaml:"enable_tracing"`
+	EnableDashboard          bool `yaml:"enable_dashboard"`
+	ErrorHTTPStatus          bool `yaml:"error_http_status"`
+	AccessLog                bool `yaml:"access_log"`
 	DevNets                  []IPNet
 }
 
@@ -64,62 +88,215 @@ type Server struct {
 	services   *services.ServiceCtrl
 	configs    *configs.ConfigCtrl
 	apps       *apps.AppCtrl
+	locks      *locks.LockCtrl
+	schemas    *schemas.SchemaCtrl
+	secrets    *secrets.SecretCtrl
 
 	e *echo.Echo
+	// stopping closed on shutdown, ending long-lived streams
+	stopping chan struct{}
+	stopOnce sync.Once
+	// selfDone closed when the self registration is unplugged
+	selfDone chan struct{}
+
+	watchesMu  sync.Mutex
+	watches    map[string]int
+	maxWatches int64
+	limiter    *rateLimiter
+	// keyPair *utils.KeyPairReloader of the tls server, replaced on reload
+	keyPair atomic.Value
+
+	reloadMu sync.Mutex
+	reload   func() error
+
+	health *healthServer
 }
 
 // NewServer new api server
 func NewServer(config *Config, etcdClient *clientv3.Client,
-	servs *services.ServiceCtrl, cfgs *configs.ConfigCtrl, apps *apps.AppCtrl) *Server {
+	servs *services.ServiceCtrl, cfgs *configs.ConfigCtrl, apps *apps.AppCtrl,
+	lcks *locks.LockCtrl, schms *schemas.SchemaCtrl, scrts *secrets.SecretCtrl) *Server {
 	server := &Server{config: *config, tls: config.CertFile != "",
 		etcdClient: etcdClient,
-		services:   servs, configs: cfgs, apps: apps, e: echo.New()}
+		services:   servs, configs: cfgs, apps: apps, locks: lcks, schemas: schms, secrets: scrts,
+		e:        echo.New(),
+		stopping: make(chan struct{}), watches: make(map[string]int),
+		maxWatches: int64(config.MaxWatchesPerClient), limiter: newRateLimiter(config.RateLimits)}
+	if server.config.Health.Interval <= 0 {
+		server.config.Health.Interval = 5 * time.Second
+	}
+	if server.config.Health.Timeout <= 0 {
+		server.config.Health.Timeout = 3 * time.Second
+	}
 	server.prepare()
 	return server
 }
 
 func (server *Server) prepare() {
 	server.e.Use(middleware.Recover())
-	if glog.V(1) {
+	if logging.DebugEnabled() {
 		server.e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 			Format: "[${time_rfc3339}] ${remote_ip} ${bytes_in} - ${user_agent} ${protocol} ${method} ${uri}: ${status} ${latency_human} ${bytes_out}\n",
 		}))
 	}
+	server.e.Use(echo.MiddlewareFunc(server.requestLogger))
 	server.e.GET("/api/ok", func(c echo.Context) error {
+		if server.isStopping() {
+			return c.JSON(http.StatusServiceUnavailable, map[string]bool{"ok": false, "draining": true})
+		}
 		return c.JSON(200, map[string]bool{"ok": true})
 	})
+	server.e.GET("/healthz", server.healthz)
+	server.e.GET("/readyz", server.readyz)
+	if server.config.EnableMetrics {
+		server.e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+	}
+	if server.config.EnableTracing {
+		tracing.Enable()
+		server.e.GET("/debug/requests", echo.WrapHandler(http.HandlerFunc(trace.Traces)))
+		server.e.Use(echo.MiddlewareFunc(server.traceRequest))
+	}
 	server.e.Use(echo.MiddlewareFunc(server.verifyApp))
+	plug, query, watch := server.newRateLimit(opPlug), server.newRateLimit(opQuery), server.newRateLimit(opWatch)
 	server.registerV1ServiceAPIs(server.e.Group("/api/v1/services"))
-	server.e.GET("/api/v1/service-descs", server.v1WatchServiceDesc)
+	server.registerV1SchemaAPIs(server.e.Group("/api/v1/service-schemas"))
+	server.e.GET("/api/v1/service-descs", server.v1WatchServiceDesc, watch)
+	server.e.POST("/api/v1/service-sync", server.v1SyncServices, query)
+	server.e.POST("/api/v1/service-query", server.v1QueryServices, query)
+	server.e.POST("/api/v1/service-watch", server.v1WatchServices, watch)
+	server.e.GET("/api/v1/service-versions/:name", server.v1QueryServiceVersion, query)
+	server.e.GET("/api/v1/service-aliases/:name", server.v1ListServiceAliases, query)
+	server.e.PUT("/api/v1/service-aliases/:name/:alias", server.v1SetServiceAlias, plug)
+	server.e.DELETE("/api/v1/service-aliases/:name/:alias", server.v1DeleteServiceAlias, plug)
+	server.e.GET("/api/v1/service-rollouts", server.v1ListServiceRollouts, query)
+	server.e.GET("/api/v1/service-rollouts/:name/:alias", server.v1GetServiceRollout, query)
+	server.e.PUT("/api/v1/service-rollouts/:name/:alias", server.v1StartServiceRollout, plug)
+	server.e.POST("/api/v1/service-rollouts/:name/:alias/pause", server.v1PauseServiceRollout, plug)
+	server.e.POST("/api/v1/service-rollouts/:name/:alias/resume", server.v1ResumeServiceRollout, plug)
+	server.e.POST("/api/v1/service-rollouts/:name/:alias/rollback", server.v1RollbackServiceRollout, plug)
+	server.e.GET("/api/v1/service-config-schemas/:service", server.v1GetConfigSchema,
+		query, server.newQueryPermChecker())
+	server.e.PUT("/api/v1/service-config-schemas/:service", server.v1SetConfigSchema,
+		plug, server.newPermChecker(apps.PermTypeService, true))
+	server.e.DELETE("/api/v1/service-config-schemas/:service", server.v1DeleteConfigSchema,
+		plug, server.newPermChecker(apps.PermTypeService, true))
+	server.e.GET("/api/v1/client-policies/:service", server.v1GetClientPolicy, watch, server.newQueryPermChecker())
+	server.e.PUT("/api/v1/client-policies/:service", server.v1SetClientPolicy,
+		plug, server.newPermChecker(apps.PermTypeService, true))
+	server.e.DELETE("/api/v1/client-policies/:service", server.v1DeleteClientPolicy,
+		plug, server.newPermChecker(apps.PermTypeService, true))
+	server.e.GET("/api/v1/service-metadata", server.v1ListMetadata, query)
+	server.e.GET("/api/v1/service-metadata/:name", server.v1GetMetadata, query, server.newQueryPermChecker())
+	server.e.PUT("/api/v1/service-metadata/:name", server.v1PutMetadata,
+		plug, server.newPermChecker(apps.PermTypeService, true))
+	server.e.DELETE("/api/v1/service-metadata/:name", server.v1DeleteMetadata,
+		plug, server.newPermChecker(apps.PermTypeService, true))
+	server.e.PUT("/api/v1/dependencies", server.v1DeclareDependencies, plug)
+	server.e.GET("/api/v1/dependencies/apps/:app", server.v1AppDependencies, query)
+	server.e.GET("/api/v1/dependencies/services/:service", server.v1ServiceConsumers, query,
+		server.newQueryPermChecker())
+	server.e.GET("/api/v1/dependencies/impact/:service", server.v1ServiceImpact, query,
+		server.newQueryPermChecker())
+	server.e.DELETE("/api/v1/service-leases/:id", server.v1UnplugServiceLease, plug)
+	server.e.GET("/api/v1/lease-warnings", server.v1LeaseWarnings, query)
+	server.e.GET("/api/v1/namespaces/:name", server.v1NamespaceUsage, query)
+	server.e.DELETE("/api/v1/service-endpoints/:service", server.v1UnplugAllService,
+		plug, server.newPermChecker(apps.PermTypeService, true))
+	server.e.POST("/api/v1/static-endpoints", server.v1PlugStaticService, plug)
+	server.e.DELETE("/api/v1/static-endpoints/:service/:zone/:addr", server.v1UnplugStaticService, plug)
+	server.e.GET("/api/v1/service-checksums", server.v1ServiceChecksums, query)
+	server.e.GET("/api/v1/service-checksums/:service", server.v1ServiceZoneChecksums, query,
+		server.newQueryPermChecker())
+	server.e.POST("/api/v1/service-checksums", server.v1CompareServiceChecksums, query)
+	server.e.GET("/api/v1/service-counts/:service", server.v1ServiceCount, query, server.newQueryPermChecker())
+	server.e.GET("/api/v1/flapping-endpoints/:service", server.v1FlappingEndpoints, query, server.newQueryPermChecker())
+	server.e.GET("/api/v1/service-history/:name", server.v1ServiceHistory, query, server.newQueryPermChecker())
+	server.e.GET("/api/v1/service-events/:service", server.v1ServiceEvents, watch, server.newQueryPermChecker())
+	server.e.GET("/api/v1/service-ws", server.v1ServiceWebSocket, watch)
+	if server.config.EnableDashboard {
+		server.e.GET("/dashboard", server.dashboard)
+		server.e.GET("/api/v1/dashboard", server.v1Dashboard, query)
+	}
 	server.registerConfigAPIs(server.e.Group("/api/configs"))
+	server.registerSecretAPIs(server.e.Group("/api/secrets"))
 	server.registerAppAPIs(server.e.Group("/api/apps"))
 	server.registerLeaseAPIs(server.e.Group("/api/leases"))
+	server.registerLockAPIs(server.e.Group("/api/locks"))
+	server.registerElectionAPIs(server.e.Group("/api/elections"))
+	server.registerAdminAPIs(server.e.Group("/api/admin"))
 }
 
-// Run run server
-func (server *Server) Run() error {
-	useTLS := server.config.CertFile != ""
+// listenAddr listen address with port, :https or :http if not given
+func (server *Server) listenAddr() string {
 	addr := server.config.Listen
 	if !strings.Contains(addr, ":") {
-		if useTLS {
+		if server.config.CertFile != "" {
 			addr += ":https"
 		} else {
 			addr += ":http"
 		}
 	}
+	return addr
+}
+
+// Run run server, reload config on signals of hup, which should be registered early
+// so that SIGHUPs during startup don't kill the process
+func (server *Server) Run(hup <-chan os.Signal) error {
 	go func() {
 		if err := server.start(); err == http.ErrServerClosed {
-			glog.Info("shutting down the server")
+			logging.Info("shutting down the server")
 		} else if err != nil {
-			glog.Fatal(err)
+			logging.Fatal(err)
 		}
 	}()
+	if server.config.Self.Enable {
+		server.selfDone = make(chan struct{})
+		go server.runSelfRegistration()
+	}
+	if server.config.Health.Listen != "" {
+		server.health = server.newHealthServer()
+		go server.runHealth(server.health)
+	}
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-	<-quit
+	for {
+		select {
+		case <-hup:
+			logging.Info("SIGHUP received, reloading config")
+			server.Reload()
+		case <-quit:
+			return server.Shutdown()
+		}
+	}
+}
+
+func (server *Server) isStopping() bool {
+	select {
+	case <-server.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown drain and shut down the server: new watches are rejected and those in flight
+// return, /api/ok (and grpc health) reports draining for DrainDelay, then other requests in flight are
+// waited for StopTimeout
+func (server *Server) Shutdown() error {
+	server.stopOnce.Do(func() { close(server.stopping) })
+	if server.selfDone != nil {
+		<-server.selfDone
+	}
+	if server.config.DrainDelay > 0 {
+		logging.Infof("draining for %v", server.config.DrainDelay)
+		time.Sleep(server.config.DrainDelay)
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), server.config.StopTimeout)
 	defer cancel()
+	if server.health != nil {
+		defer server.stopHealth(server.health)
+	}
 	return server.e.Shutdown(ctx)
 }
 
@@ -130,11 +307,14 @@ func (server *Server) start() (err error) {
 		s = server.e.TLSServer
 		s.TLSConfig = new(tls.Config)
 		s.TLSConfig.ClientCAs = server.apps.GetAppCertPool()
-		s.TLSConfig.Certificates = make([]tls.Certificate, 1)
 		s.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
-		s.TLSConfig.Certificates[0], err = tls.LoadX509KeyPair(server.config.CertFile, server.config.KeyFile)
+		reloader, err := utils.NewKeyPairReloader(server.config.CertFile, server.config.KeyFile)
 		if err != nil {
-			return
+			return err
+		}
+		server.keyPair.Store(reloader)
+		s.TLSConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return server.keyPair.Load().(*utils.KeyPairReloader).GetCertificate(hello)
 		}
 		if !server.e.DisableHTTP2 {
 			s.TLSConfig.NextProtos = append(s.TLSConfig.NextProtos, "h2")
@@ -142,14 +322,7 @@ func (server *Server) start() (err error) {
 	} else {
 		s = server.e.Server
 	}
-	s.Addr = server.config.Listen
-	if !strings.Contains(s.Addr, ":") {
-		if useTLS {
-			s.Addr += ":https"
-		} else {
-			s.Addr += ":http"
-		}
-	}
+	s.Addr = server.listenAddr()
 	return server.e.StartServer(s)
 }
 
@@ -160,9 +333,105 @@ func (server *Server) getRemoteIP(c echo.Context) net.IP {
 	return nil
 }
 
+// RequestIDHeader request id header, accepted from clients and returned in responses
+const RequestIDHeader = "X-Request-Id"
+
+const maxRequestIDLen = 64
+
+func newRequestID() string {
+	data := make([]byte, 8)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+// validRequestID request ids from clients are kept in logs, only accept simple ones
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID id of the request, see RequestIDHeader
+func (server *Server) requestID(c echo.Context) string {
+	id, _ := c.Get("requestID").(string)
+	return id
+}
+
+func (server *Server) requestLogger(h echo.HandlerFunc) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		req := c.Request()
+		requestID := req.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set("requestID", requestID)
+		c.Response().Header().Set(RequestIDHeader, requestID)
+		if server.config.ErrorHTTPStatus {
+			c.Set("errorHTTPStatus", true)
+		}
+		entry := logging.With("request_id", requestID, "method", req.Method, "path", c.Path())
+		c.Set("ctx", logging.NewContext(context.Background(), entry))
+		if !server.config.AccessLog {
+			return h(c)
+		}
+
+		start := time.Now()
+		err := h(c)
+		if err != nil {
+			c.Error(err)
+		}
+		app := "null"
+		if x, ok := c.Get("app").(*apps.App); ok && x != nil {
+			app = x.Name
+		}
+		entry.With("status", c.Response().Status, "latency_ms", time.Since(start).Nanoseconds()/1e6,
+			"app", app, "remote_ip", c.RealIP(), "bytes_out", c.Response().Size).Infof("request done")
+		return nil
+	})
+}
+
+// logger request logger
+func (server *Server) logger(c echo.Context) *logging.Entry {
+	return logging.FromContext(server.ctx(c))
+}
+
+func (server *Server) traceRequest(h echo.HandlerFunc) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		req := c.Request()
+		remote, _ := tracing.ParseTraceParent(req.Header.Get(tracing.TraceParentHeader))
+		ctx, span := tracing.StartSpanWithRemote(server.ctx(c), "http "+req.Method+" "+c.Path(), remote)
+		span.SetAttribute("remote_addr", req.RemoteAddr)
+		span.SetAttribute("request_id", server.requestID(c))
+		c.Set("ctx", ctx)
+		c.Response().Header().Set(tracing.TraceParentHeader, span.Context().TraceParent())
+
+		err := h(c)
+		span.SetAttribute("status", strconv.Itoa(c.Response().Status))
+		span.FinishWithError(err)
+		return err
+	})
+}
+
+// ctx request context carrying trace span
+func (server *Server) ctx(c echo.Context) context.Context {
+	if ctx, ok := c.Get("ctx").(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
 func (server *Server) verifyApp(h echo.HandlerFunc) echo.HandlerFunc {
 	return echo.HandlerFunc(func(c echo.Context) error {
 		var appName string
+		var token *apps.AppToken
 		req := c.Request()
 		if server.tls {
 			if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
@@ -178,23 +447,34 @@ func (server *Server) verifyApp(h echo.HandlerFunc) echo.HandlerFunc {
 							}
 						}
 					} else {
-						glog.Warningf("invalid remote addr: %v", req.RemoteAddr)
+						server.logger(c).Warningf("invalid remote addr: %v", req.RemoteAddr)
 					}
 				}
 			}
-		} else {
-			// TODO: http request sign verify
+		}
+		if appName == "" {
+			if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+				name, verified, err := server.apps.VerifyToken(strings.TrimSpace(auth[len("Bearer "):]))
+				if err != nil {
+					if utils.CodeOf(err) == utils.EcodeInvalidToken {
+						return JSONErrorC(c, http.StatusUnauthorized, err)
+					}
+					return JSONErrorC(c, http.StatusServiceUnavailable, err)
+				}
+				appName, token = name, verified
+			}
 		}
 
+		c.Set("token", token)
 		c.Set("app", (*apps.App)(nil))
 		c.Set("groupIds", []int64{})
 		if appName != "" {
 			if app, groupIds, err := server.apps.GetAppGroupByName(appName); err != nil {
-				glog.Errorf("get app(%s) fail: %v", appName, err)
+				server.logger(c).Errorf("get app(%s) fail: %v", appName, err)
 				return JSONErrorC(c, http.StatusServiceUnavailable,
 					utils.Errorf(utils.EcodeSystemError, "get app fail"))
 			} else if app == nil {
-				glog.V(1).Infof("no such app: %s", appName)
+				server.logger(c).Debugf("no such app: %s", appName)
 			} else {
 				c.Set("app", app)
 				c.Set("groupIds", groupIds)
@@ -222,6 +502,12 @@ func (server *Server) app(c echo.Context) *apps.App {
 	return c.Get("app").(*apps.App)
 }
 
+// authToken the token authenticating the request, nil if by cert or anonymous
+func (server *Server) authToken(c echo.Context) *apps.AppToken {
+	token, _ := c.Get("token").(*apps.AppToken)
+	return token
+}
+
 func (server *Server) newNotPermittedResp(c echo.Context, keys ...string) error {
 	msg := fmt.Sprintf("not permitted: [%s] %s", server.appName(c), strings.Join(keys, ", "))
 	return JSONError(c, utils.NewNotPermittedError(msg, keys))
@@ -229,6 +515,9 @@ func (server *Server) newNotPermittedResp(c echo.Context, keys ...string) error
 
 func (server *Server) checkPerm(c echo.Context, permType int, needWrite bool, name string) (bool, error) {
 	app := c.Get("app").(*apps.App)
+	if permType == apps.PermTypeService && !server.services.IsNamespaceMember(name, server.appName(c)) {
+		return false, nil
+	}
 	if app == nil {
 		if needWrite {
 			return false, nil
@@ -254,6 +543,37 @@ func (server *Server) checkPerm(c echo.Context, permType int, needWrite bool, na
 	return true, nil
 }
 
+// checkAdminPerm check app write perm, required by admin apis, e.g. static endpoints
+func (server *Server) checkAdminPerm(c echo.Context) (bool, error) {
+	if ok, err := server.checkPerm(c, apps.PermTypeApp, true, ""); err == nil {
+		if !ok {
+			return false, server.newNotPermittedResp(c, "app perm")
+		}
+	} else {
+		return false, JSONError(c, err)
+	}
+	return true, nil
+}
+
+// publicQuery whether service is queried without perm checks
+func (server *Server) publicQuery(service string) bool {
+	return server.config.PermitPublicServiceQuery && !server.services.IsNamespaceIsolated(service)
+}
+
+// newQueryPermChecker perm checker of service queries, skipped for public queries
+func (server *Server) newQueryPermChecker() echo.MiddlewareFunc {
+	checker := server.newPermChecker(apps.PermTypeService, false)
+	return echo.MiddlewareFunc(func(h echo.HandlerFunc) echo.HandlerFunc {
+		checked := checker(h)
+		return echo.HandlerFunc(func(c echo.Context) error {
+			if server.publicQuery(c.ParamValues()[0]) {
+				return h(c)
+			}
+			return checked(c)
+		})
+	})
+}
+
 func (server *Server) newPermChecker(permType int, needWrite bool) echo.MiddlewareFunc {
 	return echo.MiddlewareFunc(func(h echo.HandlerFunc) echo.HandlerFunc {
 		return echo.HandlerFunc(func(c echo.Context) error {
@@ -270,32 +590,59 @@ func (server *Server) newPermChecker(permType int, needWrite bool) echo.Middlewa
 }
 
 func (server *Server) registerV1ServiceAPIs(g *echo.Group) {
+	plug, query := server.newRateLimit(opPlug), server.newRateLimit(opQuery)
 	g.POST("/:service", echo.HandlerFunc(server.v1PlugService),
-		server.newPermChecker(apps.PermTypeService, true))
+		plug, server.newPermChecker(apps.PermTypeService, true))
 	g.DELETE("/:service", echo.HandlerFunc(server.v1DeleteService),
-		server.newPermChecker(apps.PermTypeService, true))
+		plug, server.newPermChecker(apps.PermTypeService, true))
 	g.DELETE("/:service/:zone/:addr", echo.HandlerFunc(server.v1UnplugService),
-		server.newPermChecker(apps.PermTypeService, true))
-	g.POST("", echo.HandlerFunc(server.v1PlugAllService))
-	g.GET("", echo.HandlerFunc(server.v1SearchService))
+		plug, server.newPermChecker(apps.PermTypeService, true))
+	g.PUT("/:service/:zone/:addr", echo.HandlerFunc(server.v1UpdateEndpoint),
+		plug, server.newPermChecker(apps.PermTypeService, true))
+	g.PATCH("/:service/:zone/:addr", echo.HandlerFunc(server.v1PatchEndpoint),
+		plug, server.newPermChecker(apps.PermTypeService, true))
+	g.POST("", echo.HandlerFunc(server.v1PlugAllService), plug)
+	g.GET("", echo.HandlerFunc(server.v1SearchService), query)
 
-	if server.config.PermitPublicServiceQuery {
-		g.GET("/:service", echo.HandlerFunc(server.v1QueryService))
-		g.GET("/:service/:zone", echo.HandlerFunc(server.v1QueryServiceZone))
-	} else {
-		g.GET("/:service", echo.HandlerFunc(server.v1QueryService),
-			server.newPermChecker(apps.PermTypeService, false))
-		g.GET("/:service/:zone", echo.HandlerFunc(server.v1QueryServiceZone),
-			server.newPermChecker(apps.PermTypeService, false))
-	}
+	g.GET("/:service", echo.HandlerFunc(server.v1QueryService), query, server.newQueryPermChecker())
+	g.GET("/:service/:zone", echo.HandlerFunc(server.v1QueryServiceZone), query, server.newQueryPermChecker())
+	g.GET("/:service/:zone/:addr", echo.HandlerFunc(server.v1GetEndpoint), query, server.newQueryPermChecker())
+}
+
+func (server *Server) registerV1SchemaAPIs(g *echo.Group) {
+	plug, query := server.newRateLimit(opPlug), server.newRateLimit(opQuery)
+	g.GET("/:service", echo.HandlerFunc(server.v1ListSchemas), query, server.newQueryPermChecker())
+	g.GET("/:service/:kind", echo.HandlerFunc(server.v1GetSchema), query, server.newQueryPermChecker())
+	g.GET("/:service/:kind/diff", echo.HandlerFunc(server.v1DiffSchema), query, server.newQueryPermChecker())
+	g.PUT("/:service/:kind", echo.HandlerFunc(server.v1PutSchema),
+		plug, server.newPermChecker(apps.PermTypeService, true))
 }
 
 func (server *Server) registerLeaseAPIs(g *echo.Group) {
-	g.POST("", echo.HandlerFunc(server.grantLease))
+	g.POST("", echo.HandlerFunc(server.grantLease), server.newRateLimit(opPlug))
 	g.POST("/:id", echo.HandlerFunc(server.keepAliveLease))
+	g.GET("/:id/keepalive", echo.HandlerFunc(server.keepAliveLeaseStream), server.newRateLimit(opWatch))
 	g.DELETE("/:id", echo.HandlerFunc(server.revokeLease))
 }
 
+func (server *Server) registerLockAPIs(g *echo.Group) {
+	g.POST("/:name", echo.HandlerFunc(server.lock), server.newRateLimit(opWatch),
+		server.newPermChecker(apps.PermTypeLock, true))
+	g.GET("/:name", echo.HandlerFunc(server.getLock), server.newRateLimit(opQuery),
+		server.newPermChecker(apps.PermTypeLock, false))
+	g.DELETE("/:name/:lease_id", echo.HandlerFunc(server.unlock),
+		server.newPermChecker(apps.PermTypeLock, true))
+}
+
+func (server *Server) registerElectionAPIs(g *echo.Group) {
+	g.POST("/:name", echo.HandlerFunc(server.campaign), server.newRateLimit(opWatch),
+		server.newPermChecker(apps.PermTypeElection, true))
+	g.GET("/:name", echo.HandlerFunc(server.getLeader), server.newRateLimit(opQuery),
+		server.newPermChecker(apps.PermTypeElection, false))
+	g.DELETE("/:name/:lease_id", echo.HandlerFunc(server.resign),
+		server.newPermChecker(apps.PermTypeElection, true))
+}
+
 func (server *Server) registerConfigAPIs(g *echo.Group) {
 	g.GET("/:name", echo.HandlerFunc(server.getConfig),
 		server.newPermChecker(apps.PermTypeConfig, false))
@@ -310,6 +657,9 @@ func (server *Server) registerAppAPIs(g *echo.Group) {
 	g.GET("/:name/cert", echo.HandlerFunc(server.getAppCert))
 	g.GET("/:name/nodes", echo.HandlerFunc(server.watchAppNodes))
 	g.GET("/:name/online", echo.HandlerFunc(server.isAppNodeOnline))
+	g.GET("/:name/tokens", echo.HandlerFunc(server.listAppTokens))
+	g.POST("/:name/tokens", echo.HandlerFunc(server.issueAppToken))
+	g.DELETE("/:name/tokens/:id", echo.HandlerFunc(server.revokeAppToken))
 	g.GET("", echo.HandlerFunc(server.listApp))
 	g.PUT("", echo.HandlerFunc(server.newApp))
 }
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"time"
+
+	"github.com/infrmods/xbus/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// ttl out of range policies
+const (
+	// TTLReject reject ttls out of range
+	TTLReject = "reject"
+	// TTLClamp clamp ttls out of range to min or max
+	TTLClamp = "clamp"
+)
+
+// TTLPolicy ttl policy of plugged endpoints and granted leases, no max if Max is 0
+type TTLPolicy struct {
+	Min        time.Duration `default:"10s"`
+	Max        time.Duration `default:"24h"`
+	Default    time.Duration `default:"60s"`
+	OutOfRange string        `default:"reject" yaml:"out_of_range"`
+}
+
+// apply ttl policy, in seconds
+func (policy *TTLPolicy) apply(ttl int64) (int64, error) {
+	min, max := int64(policy.Min.Seconds()), int64(policy.Max.Seconds())
+	if ttl >= min && (max <= 0 || ttl <= max) {
+		return ttl, nil
+	}
+	if policy.OutOfRange != TTLClamp {
+		if ttl < min {
+			return 0, utils.Errorf(utils.EcodeInvalidParam, "invalid ttl: %d, min: %d", ttl, min)
+		}
+		return 0, utils.Errorf(utils.EcodeInvalidParam, "invalid ttl: %d, max: %d", ttl, max)
+	}
+	if ttl < min {
+		return min, nil
+	}
+	return max, nil
+}
+
+// ttlParam ttl form param in seconds with ttl policy applied
+func (server *Server) ttlParam(c echo.Context) (int64, bool, error) {
+	policy := &server.config.ServiceTTL
+	ttl, ok, err := IntFormParamD(c, "ttl", int64(policy.Default.Seconds()))
+	if !ok {
+		return 0, false, err
+	}
+	if ttl, err = policy.apply(ttl); err != nil {
+		return 0, false, JSONError(c, err)
+	}
+	return ttl, true, nil
+}
+
+// plugTTLParam ttl of plugs, 0 plugs permanent endpoints without lease, by admins only
+func (server *Server) plugTTLParam(c echo.Context) (int64, bool, error) {
+	if c.FormValue("ttl") != "0" {
+		return server.ttlParam(c)
+	}
+	if ok, err := server.checkAdminPerm(c); !ok {
+		return 0, false, err
+	}
+	return 0, true, nil
+}
@@ -15,7 +15,8 @@ import (
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/gocomm/dbutil"
-	"github.com/golang/glog"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
 	"github.com/infrmods/xbus/utils"
 	"google.golang.org/grpc/codes"
 )
@@ -36,16 +37,16 @@ func (g *dbSerialGenerator) Generate() (*big.Int, error) {
 			item = &ConfigItem{Name: serialConfigItem, Ver: 1}
 			item.SetIntValue(2)
 			if new, err := InsertConfigItem(g.db, item); err != nil {
-				glog.Errorf("insert config item(%#v) fail: %v", *item, err)
+				logging.Errorf("insert config item(%#v) fail: %v", *item, err)
 				return nil, utils.NewSystemError("generate serial fail")
 			} else if new {
 				return big.NewInt(2), nil
 			} else if iter == 1 {
-				glog.Errorf("insert config item(%#v) deadloop", *item)
+				logging.Errorf("insert config item(%#v) deadloop", *item)
 				return nil, utils.NewSystemError("generate serial fail")
 			}
 		} else {
-			glog.Errorf("get serial config(%s) fail: %v", serialConfigItem, err)
+			logging.Errorf("get serial config(%s) fail: %v", serialConfigItem, err)
 			return nil, utils.NewSystemError("generate serial fail")
 		}
 	}
@@ -53,7 +54,7 @@ func (g *dbSerialGenerator) Generate() (*big.Int, error) {
 	for i := 0; i < 32; i++ {
 		n, err := item.GetIntValue()
 		if err != nil {
-			glog.Errorf("get serial(%s) value fail: %v", serialConfigItem, err)
+			logging.Errorf("get serial(%s) value fail: %v", serialConfigItem, err)
 			return nil, utils.NewSystemError("generate serial fail")
 		}
 		item.SetIntValue(n + 1)
@@ -62,7 +63,7 @@ func (g *dbSerialGenerator) Generate() (*big.Int, error) {
 		} else if err == dbutil.ZeroEffected {
 			continue
 		} else {
-			glog.Errorf("update serial(%s) fail: %v", serialConfigItem, err)
+			logging.Errorf("update serial(%s) fail: %v", serialConfigItem, err)
 			return nil, utils.NewSystemError("generate serial fail")
 		}
 	}
@@ -78,6 +79,7 @@ type Config struct {
 	KeyPrefix             string `default:"/apps" yaml:"key_prefix"`
 	DumpKeyCertDir        string `yaml:"dump_keycert_dir"`
 	DumpKeyCertWithAppDir bool   `default:"true" yaml:"dump_keycert_with_appdir"`
+	Tokens                TokenConfig
 }
 
 // AppCtrl app ctrl
@@ -86,6 +88,7 @@ type AppCtrl struct {
 	db           *sql.DB
 	CertsManager *CertsCtrl
 	etcdClient   *clientv3.Client
+	tokens       tokenCache
 }
 
 // NewAppCtrl new app ctrl
@@ -120,7 +123,7 @@ func (ctrl *AppCtrl) NewApp(app *App, key crypto.Signer, dnsNames []string, ips
 	if key == nil {
 		key, err = utils.NewPrivateKey(ctrl.config.EcdsaCruve, ctrl.config.RSABits)
 		if err != nil {
-			glog.Errorf("generate key fail: %v", err)
+			logging.Errorf("generate key fail: %v", err)
 			return nil, utils.NewSystemError("generate key fail")
 		}
 	}
@@ -130,13 +133,13 @@ func (ctrl *AppCtrl) NewApp(app *App, key crypto.Signer, dnsNames []string, ips
 	if certPem, err := ctrl.CertsManager.NewCert(key.Public(), name, dnsNames, ips, days); err == nil {
 		app.Cert = string(certPem)
 	} else {
-		glog.Errorf("generate cert fail: %v", err)
+		logging.Errorf("generate cert fail: %v", err)
 		return nil, utils.NewSystemError("generate cert fail")
 	}
 
 	data, err := utils.EncodePrivateKeyToPem(key)
 	if err != nil {
-		glog.Errorf("encode private key to pem fail: %v", err)
+		logging.Errorf("encode private key to pem fail: %v", err)
 		return nil, utils.NewSystemError("encode private key pem fail")
 	}
 	app.PrivateKey = data
@@ -146,7 +149,7 @@ func (ctrl *AppCtrl) NewApp(app *App, key crypto.Signer, dnsNames []string, ips
 			return nil, utils.NewError(utils.EcodeNameDuplicated, "name duplicated")
 		}
 
-		glog.Errorf("insert app(%s) fail: %v", app.Name, err)
+		logging.Errorf("insert app(%s) fail: %v", app.Name, err)
 		return nil, utils.NewSystemError("create app fail")
 	}
 	ctrl.dumpKeyCert(app, key)
@@ -161,15 +164,15 @@ func (ctrl *AppCtrl) dumpKeyCert(app *App, key crypto.Signer) {
 		}
 		if _, err := os.Stat(dir); os.IsNotExist(err) {
 			if err := os.MkdirAll(dir, 0755); err != nil {
-				glog.Errorf("create keycert dir(%s) fail: %v", dir, err)
+				logging.Errorf("create keycert dir(%s) fail: %v", dir, err)
 				return
 			}
 		}
 		if err := utils.WriteFile(path.Join(dir, app.Name+"key.pem"), 0600, []byte(app.PrivateKey)); err != nil {
-			glog.Warningf("dump key(%s) fail: %v", app.Name, err)
+			logging.Warningf("dump key(%s) fail: %v", app.Name, err)
 		}
 		if err := utils.WriteFile(path.Join(dir, app.Name+"cert.pem"), 0644, []byte(app.Cert)); err != nil {
-			glog.Warningf("dump cert(%s) fail: %v", app.Name, err)
+			logging.Warningf("dump cert(%s) fail: %v", app.Name, err)
 		}
 	}
 }
@@ -184,7 +187,7 @@ func (ctrl *AppCtrl) GetPerms(typ int, appName *string, groupName *string, canWr
 			targetType = &t
 			targetID = &app.ID
 		} else {
-			glog.Errorf("get app(%s) fail: %v", *appName, err)
+			logging.Errorf("get app(%s) fail: %v", *appName, err)
 			return nil, utils.NewSystemError("get app fail")
 		}
 	} else if groupName != nil {
@@ -193,14 +196,14 @@ func (ctrl *AppCtrl) GetPerms(typ int, appName *string, groupName *string, canWr
 			targetType = &t
 			targetID = &group.ID
 		} else {
-			glog.Errorf("get group(%s) fail: %v", *groupName, err)
+			logging.Errorf("get group(%s) fail: %v", *groupName, err)
 			return nil, utils.NewSystemError("get group fail")
 		}
 	}
 
 	perms, err := GetPerms(ctrl.db, typ, targetType, targetID, canWrite, prefix)
 	if err != nil {
-		glog.Errorf("get perms fail: %v", err)
+		logging.Errorf("get perms fail: %v", err)
 		return nil, utils.NewSystemError("get perms fail")
 	}
 	return perms, nil
@@ -210,7 +213,17 @@ func (ctrl *AppCtrl) GetPerms(typ int, appName *string, groupName *string, canWr
 func (ctrl *AppCtrl) GetAppByName(name string) (*App, error) {
 	app, err := GetAppByName(ctrl.db, name)
 	if err != nil {
-		glog.Errorf("get app(%s) fail: %v", name, err)
+		logging.Errorf("get app(%s) fail: %v", name, err)
+		return nil, utils.NewSystemError("get app fail")
+	}
+	return app, nil
+}
+
+// GetAppByID get app by id
+func (ctrl *AppCtrl) GetAppByID(id int64) (*App, error) {
+	app, err := GetAppByID(ctrl.db, id)
+	if err != nil {
+		logging.Errorf("get app(%d) fail: %v", id, err)
 		return nil, utils.NewSystemError("get app fail")
 	}
 	return app, nil
@@ -220,7 +233,7 @@ func (ctrl *AppCtrl) GetAppByName(name string) (*App, error) {
 func (ctrl *AppCtrl) ListApp(skip, limit int) ([]App, error) {
 	apps, err := ListApp(ctrl.db, skip, limit)
 	if err != nil {
-		glog.Errorf("list app fail: %v", err)
+		logging.Errorf("list app fail: %v", err)
 		return nil, utils.NewSystemError("list app fail")
 	}
 	return apps, nil
@@ -230,7 +243,7 @@ func (ctrl *AppCtrl) ListApp(skip, limit int) ([]App, error) {
 func (ctrl *AppCtrl) GetAppGroupByName(name string) (*App, []int64, error) {
 	app, groupIDs, err := GetAppGroupByName(ctrl.db, name)
 	if err != nil {
-		glog.Errorf("get app&group(%s) fail: %v", name, err)
+		logging.Errorf("get app&group(%s) fail: %v", name, err)
 		return nil, nil, utils.NewSystemError("get app&group fail")
 	}
 	return app, groupIDs, nil
@@ -243,7 +256,7 @@ func (ctrl *AppCtrl) NewGroup(group *Group) error {
 	} else if err == dbutil.ZeroEffected {
 		return utils.NewError(utils.EcodeNameDuplicated, "name duplicated")
 	} else {
-		glog.Errorf("insert group(%s) fail: %v", group.Name, err)
+		logging.Errorf("insert group(%s) fail: %v", group.Name, err)
 		return utils.NewSystemError("create group fail")
 	}
 }
@@ -252,7 +265,7 @@ func (ctrl *AppCtrl) NewGroup(group *Group) error {
 func (ctrl *AppCtrl) GetGroupByName(name string) (*Group, error) {
 	group, err := GetGroupByName(ctrl.db, name)
 	if err != nil {
-		glog.Errorf("get group(%s) fail: %v", name, err)
+		logging.Errorf("get group(%s) fail: %v", name, err)
 		return nil, utils.NewSystemError("get group fail")
 	}
 	return group, nil
@@ -261,7 +274,7 @@ func (ctrl *AppCtrl) GetGroupByName(name string) (*Group, error) {
 // AddGroupMember add group member
 func (ctrl *AppCtrl) AddGroupMember(groupID, appID int64) error {
 	if err := NewGroupMember(ctrl.db, groupID, appID); err != nil {
-		glog.Errorf("add group member(group: %d, app: %d) fail: %v", groupID, appID, err)
+		logging.Errorf("add group member(group: %d, app: %d) fail: %v", groupID, appID, err)
 		return utils.NewSystemError("add member fail")
 	}
 	return nil
@@ -271,7 +284,7 @@ func (ctrl *AppCtrl) AddGroupMember(groupID, appID int64) error {
 func (ctrl *AppCtrl) GetGroupMembers(groupID int64) ([]App, error) {
 	apps, err := GetGroupMembers(ctrl.db, groupID)
 	if err != nil {
-		glog.Errorf("get group(%d) members fail: %v", groupID, err)
+		logging.Errorf("get group(%d) members fail: %v", groupID, err)
 		return nil, utils.NewSystemError("get group members fail")
 	}
 	return apps, nil
@@ -283,7 +296,7 @@ func (ctrl *AppCtrl) NewGroupPerm(permType int, groupID int64, canWrite bool, co
 		TargetID: groupID, CanWrite: canWrite, Content: content}
 	err := InsertPerm(ctrl.db, &perm)
 	if err != nil {
-		glog.Errorf("new group perm fail: %v", err)
+		logging.Errorf("new group perm fail: %v", err)
 		return 0, utils.NewSystemError("new group perm fail")
 	}
 	return perm.ID, nil
@@ -294,7 +307,7 @@ func (ctrl *AppCtrl) NewAppPerm(permType int, appID int64, canWrite bool, conten
 	perm := Perm{PermType: permType, TargetType: PermTargetApp,
 		TargetID: appID, CanWrite: canWrite, Content: content}
 	if err := InsertPerm(ctrl.db, &perm); err != nil {
-		glog.Errorf("new app perm fail: %v", err)
+		logging.Errorf("new app perm fail: %v", err)
 		return 0, utils.NewSystemError("new app perm fail")
 	}
 	return perm.ID, nil
@@ -304,7 +317,7 @@ func (ctrl *AppCtrl) NewAppPerm(permType int, appID int64, canWrite bool, conten
 func (ctrl *AppCtrl) HasAnyPrefixPerm(typ int, appID int64, groupIDs []int64, needWrite bool, content string) (bool, error) {
 	has, err := HasAnyPrefixPerm(ctrl.db, typ, appID, groupIDs, needWrite, content)
 	if err != nil {
-		glog.Errorf("get hasAnyPrefixPerm(type:%d, app:%d, groups:%v, needWrite:%v, content:%v) fail: %v",
+		logging.Errorf("get hasAnyPrefixPerm(type:%d, app:%d, groups:%v, needWrite:%v, content:%v) fail: %v",
 			typ, appID, groupIDs, needWrite, content, err)
 		return false, utils.NewSystemError("get perm fail")
 	}
@@ -388,6 +401,7 @@ func (ctrl *AppCtrl) queryAppNodes(ctx context.Context, name, label string) (*Ap
 func (ctrl *AppCtrl) WatchAppNodes(ctx context.Context, name, label string, revision int64) (*AppNodes, error) {
 	prefix := ctrl.nodeKeyPrefix(name, label)
 	if revision > 0 {
+		defer metrics.WatchStarted("app_nodes")()
 		watcher := clientv3.NewWatcher(ctrl.etcdClient)
 		defer watcher.Close()
 		<-watcher.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(revision))
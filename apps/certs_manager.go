@@ -11,7 +11,7 @@ import (
 	"net"
 	"time"
 
-	"github.com/golang/glog"
+	"github.com/infrmods/xbus/logging"
 	"github.com/infrmods/xbus/utils"
 )
 
@@ -110,7 +110,7 @@ func (mgr *CertsCtrl) NewCert(pubkey crypto.PublicKey, subject pkix.Name,
 	data, err := x509.CreateCertificate(rand.Reader, &template, mgr.rootCert,
 		pubkey, mgr.rootKey)
 	if err != nil {
-		glog.Errorf("create cert fail: %v", err)
+		logging.Errorf("create cert fail: %v", err)
 		return nil, utils.NewSystemError("create cert fail")
 	}
 	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: data}), nil
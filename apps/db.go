@@ -29,7 +29,7 @@ type App struct {
 func (app *App) Certificate() (*x509.Certificate, error) {
 	if app.certificate == nil {
 		block, _ := pem.Decode([]byte(app.Cert))
-		if block == nil || block.Type != "Certificate" {
+		if block == nil || block.Type != "CERTIFICATE" {
 			return nil, fmt.Errorf("invalid pem cert")
 		}
 		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
@@ -82,6 +82,19 @@ func GetAppByName(db *sql.DB, name string) (*App, error) {
 	}
 }
 
+// GetAppByID get app by id
+func GetAppByID(db *sql.DB, id int64) (*App, error) {
+	var app App
+	if err := dbutil.Query(db, &app,
+		`select * from apps where id=?`, id); err == nil {
+		return &app, nil
+	} else if err == sql.ErrNoRows {
+		return nil, nil
+	} else {
+		return nil, err
+	}
+}
+
 // GetAppGroupByName get app group by name
 func GetAppGroupByName(db *sql.DB, name string) (*App, []int64, error) {
 	row := db.QueryRow(`select apps.id, apps.status, apps.name,
@@ -180,6 +193,12 @@ const (
 	PermTypeService = 1
 	// PermTypeApp perm type app
 	PermTypeApp = 2
+	// PermTypeSecret perm type secret
+	PermTypeSecret = 3
+	// PermTypeLock perm type lock
+	PermTypeLock = 4
+	// PermTypeElection perm type election
+	PermTypeElection = 5
 
 	// PermTargetApp perm target app
 	PermTargetApp = 0
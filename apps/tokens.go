@@ -0,0 +1,381 @@
+package apps
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocomm/dbutil"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+const (
+	// TokenKindKey opaque api key, only its hash is stored
+	TokenKindKey = "key"
+	// TokenKindJWT hs256 jwt signed by TokenConfig.JWTSecret
+	TokenKindJWT = "jwt"
+)
+
+// TokenConfig config of app tokens
+type TokenConfig struct {
+	// JWTSecret hmac secret of jwts, jwts are disabled if empty
+	JWTSecret string `yaml:"jwt_secret"`
+	// MaxTTL max ttl of tokens, no limit if 0
+	MaxTTL time.Duration `yaml:"max_ttl"`
+	// CacheTTL how long a verified token is cached, revocations take effect on other servers after it
+	CacheTTL time.Duration `default:"10s" yaml:"cache_ttl"`
+}
+
+// AppToken app_tokens table
+type AppToken struct {
+	ID          int64           `json:"id"`
+	AppID       int64           `json:"-"`
+	Kind        string          `json:"kind"`
+	Description string          `json:"description,omitempty"`
+	Hash        string          `json:"-"`
+	ExpireTime  dbutil.NullTime `json:"expire_time"`
+	Revoked     bool            `json:"revoked"`
+	CreateTime  time.Time       `json:"create_time"`
+}
+
+func (token *AppToken) expired(now time.Time) bool {
+	return token.ExpireTime.Valid && !now.Before(token.ExpireTime.Time)
+}
+
+// tokenCache verified tokens by hash of their values
+type tokenCache struct {
+	lock    sync.Mutex
+	entries map[string]tokenCacheEntry
+}
+
+type tokenCacheEntry struct {
+	app   string
+	token *AppToken
+	until time.Time
+}
+
+// max entries before expired ones are pruned
+const tokenCacheSize = 1024
+
+func (cache *tokenCache) get(key string, now time.Time) (string, *AppToken, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	entry, ok := cache.entries[key]
+	if !ok || !now.Before(entry.until) {
+		return "", nil, false
+	}
+	return entry.app, entry.token, true
+}
+
+func (cache *tokenCache) put(key, app string, token *AppToken, until time.Time) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.entries == nil {
+		cache.entries = make(map[string]tokenCacheEntry)
+	}
+	if len(cache.entries) >= tokenCacheSize {
+		now := time.Now()
+		for k, entry := range cache.entries {
+			if !now.Before(entry.until) {
+				delete(cache.entries, k)
+			}
+		}
+	}
+	cache.entries[key] = tokenCacheEntry{app: app, token: token, until: until}
+}
+
+// evict remove token id from the cache
+func (cache *tokenCache) evict(id int64) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	for k, entry := range cache.entries {
+		if entry.token.ID == id {
+			delete(cache.entries, k)
+		}
+	}
+}
+
+// InsertAppToken insert app token
+func InsertAppToken(db *sql.DB, token *AppToken) error {
+	id, err := dbutil.Insert(db,
+		`insert into app_tokens(app_id, kind, description, hash, expire_time)
+         values(?, ?, ?, ?, ?)`, token.AppID, token.Kind, token.Description, token.Hash, token.ExpireTime)
+	if err != nil {
+		return err
+	}
+	token.ID = id
+	return nil
+}
+
+// SetAppTokenHash set hash of app token
+func SetAppTokenHash(db *sql.DB, id int64, hash string) error {
+	_, err := db.Exec(`update app_tokens set hash=? where id=?`, hash, id)
+	return err
+}
+
+// GetAppToken get app token by id
+func GetAppToken(db *sql.DB, id int64) (*AppToken, error) {
+	var token AppToken
+	if err := dbutil.Query(db, &token,
+		`select * from app_tokens where id=?`, id); err == nil {
+		return &token, nil
+	} else if err == sql.ErrNoRows {
+		return nil, nil
+	} else {
+		return nil, err
+	}
+}
+
+// GetAppTokens get tokens of app
+func GetAppTokens(db *sql.DB, appID int64) (tokens []AppToken, err error) {
+	err = dbutil.Query(db, &tokens, `select * from app_tokens where app_id=? order by id`, appID)
+	return
+}
+
+// RevokeAppToken revoke app token
+func RevokeAppToken(db *sql.DB, appID, id int64) (bool, error) {
+	result, err := db.Exec(`update app_tokens set revoked=1 where id=? and app_id=?`, id, appID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+var invalidToken = utils.NewError(utils.EcodeInvalidToken, "invalid token")
+
+// opaque keys are xbus_<id>_<secret>
+const keyTokenPrefix = "xbus_"
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type jwtClaims struct {
+	Subject  string `json:"sub"`
+	ID       string `json:"jti"`
+	IssuedAt int64  `json:"iat"`
+	Expire   int64  `json:"exp,omitempty"`
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func (ctrl *AppCtrl) signJWT(payload string) string {
+	mac := hmac.New(sha256.New, []byte(ctrl.config.Tokens.JWTSecret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (ctrl *AppCtrl) newJWT(app *App, token *AppToken) (string, error) {
+	claims := jwtClaims{Subject: app.Name, ID: strconv.FormatInt(token.ID, 10), IssuedAt: time.Now().Unix()}
+	if token.ExpireTime.Valid {
+		claims.Expire = token.ExpireTime.Time.Unix()
+	}
+	data, err := json.Marshal(&claims)
+	if err != nil {
+		return "", err
+	}
+	payload := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + ctrl.signJWT(payload), nil
+}
+
+// parseJWT verify the signature of a jwt, returns its claims
+func (ctrl *AppCtrl) parseJWT(value string) (*jwtClaims, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, invalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(ctrl.signJWT(parts[0]+"."+parts[1]))) {
+		return nil, invalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, invalidToken
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, invalidToken
+	}
+	return &claims, nil
+}
+
+// capTTL cap ttl (0 for never expires) to the remaining time of parent
+func capTTL(ttl time.Duration, parent *AppToken, now time.Time) (time.Duration, error) {
+	if parent == nil || !parent.ExpireTime.Valid {
+		return ttl, nil
+	}
+	remaining := parent.ExpireTime.Time.Sub(now)
+	if remaining < time.Second {
+		return 0, utils.NewError(utils.EcodeInvalidToken, "token expired")
+	}
+	if ttl == 0 || ttl > remaining {
+		return remaining, nil
+	}
+	return ttl, nil
+}
+
+// IssueToken issue a token of kind for app, expires after ttl if > 0;
+// a token issued with a parent token (the one authenticating the request) expires no later than it;
+// the returned token value is not stored and can't be got again
+func (ctrl *AppCtrl) IssueToken(app *App, kind string, ttl time.Duration, description string, parent *AppToken) (*AppToken, string, error) {
+	switch kind {
+	case TokenKindKey:
+	case TokenKindJWT:
+		if ctrl.config.Tokens.JWTSecret == "" {
+			return nil, "", utils.NewError(utils.EcodeInvalidParam, "jwt not enabled")
+		}
+	default:
+		return nil, "", utils.Errorf(utils.EcodeInvalidParam, "invalid token kind: %s", kind)
+	}
+	if ttl < 0 {
+		return nil, "", utils.NewError(utils.EcodeInvalidParam, "negative ttl")
+	}
+	if maxTTL := ctrl.config.Tokens.MaxTTL; maxTTL > 0 && (ttl == 0 || ttl > maxTTL) {
+		return nil, "", utils.Errorf(utils.EcodeInvalidParam, "ttl should be in (0, %v]", maxTTL)
+	}
+	ttl, err := capTTL(ttl, parent, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := AppToken{AppID: app.ID, Kind: kind, Description: description}
+	if ttl > 0 {
+		token.ExpireTime = dbutil.NullTime{Time: time.Now().Add(ttl).UTC().Truncate(time.Second), Valid: true}
+	}
+	if err := InsertAppToken(ctrl.db, &token); err != nil {
+		logging.Errorf("insert token of app(%s) fail: %v", app.Name, err)
+		return nil, "", utils.NewSystemError("issue token fail")
+	}
+
+	var value string
+	if kind == TokenKindJWT {
+		if value, err = ctrl.newJWT(app, &token); err != nil {
+			logging.Errorf("new jwt of app(%s) fail: %v", app.Name, err)
+			return nil, "", utils.NewSystemError("issue token fail")
+		}
+	} else {
+		secret := make([]byte, 24)
+		if _, err := rand.Read(secret); err != nil {
+			logging.Errorf("generate token secret fail: %v", err)
+			return nil, "", utils.NewSystemError("issue token fail")
+		}
+		value = keyTokenPrefix + strconv.FormatInt(token.ID, 10) + "_" + base64.RawURLEncoding.EncodeToString(secret)
+		token.Hash = hashSecret(value)
+		if err := SetAppTokenHash(ctrl.db, token.ID, token.Hash); err != nil {
+			logging.Errorf("set hash of token(%d) fail: %v", token.ID, err)
+			return nil, "", utils.NewSystemError("issue token fail")
+		}
+	}
+	return &token, value, nil
+}
+
+// VerifyToken verify an api key or jwt, returns the name of its app and the token;
+// fails with INVALID_TOKEN if it's unknown, revoked or expired.
+// verified tokens are cached for Tokens.CacheTTL (expiration is still checked)
+func (ctrl *AppCtrl) VerifyToken(value string) (string, *AppToken, error) {
+	key := hashSecret(value)
+	now := time.Now()
+	if name, token, ok := ctrl.tokens.get(key, now); ok {
+		if token.expired(now) {
+			return "", nil, utils.NewError(utils.EcodeInvalidToken, "token expired")
+		}
+		return name, token, nil
+	}
+	name, token, err := ctrl.verifyToken(value)
+	if err != nil {
+		return "", nil, err
+	}
+	if ttl := ctrl.config.Tokens.CacheTTL; ttl > 0 {
+		ctrl.tokens.put(key, name, token, now.Add(ttl))
+	}
+	return name, token, nil
+}
+
+func (ctrl *AppCtrl) verifyToken(value string) (string, *AppToken, error) {
+	var id int64
+	var subject string
+	if strings.HasPrefix(value, keyTokenPrefix) {
+		parts := strings.SplitN(value[len(keyTokenPrefix):], "_", 2)
+		if len(parts) != 2 {
+			return "", nil, invalidToken
+		}
+		var err error
+		if id, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+			return "", nil, invalidToken
+		}
+	} else if ctrl.config.Tokens.JWTSecret != "" {
+		claims, err := ctrl.parseJWT(value)
+		if err != nil {
+			return "", nil, err
+		}
+		if id, err = strconv.ParseInt(claims.ID, 10, 64); err != nil {
+			return "", nil, invalidToken
+		}
+		subject = claims.Subject
+	} else {
+		return "", nil, invalidToken
+	}
+
+	token, err := GetAppToken(ctrl.db, id)
+	if err != nil {
+		logging.Errorf("get token(%d) fail: %v", id, err)
+		return "", nil, utils.NewSystemError("get token fail")
+	}
+	if token == nil || token.Revoked {
+		return "", nil, invalidToken
+	}
+	if token.Kind == TokenKindKey {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashSecret(value))) != 1 {
+			return "", nil, invalidToken
+		}
+	} else if subject == "" {
+		return "", nil, invalidToken
+	}
+	if token.expired(time.Now()) {
+		return "", nil, utils.NewError(utils.EcodeInvalidToken, "token expired")
+	}
+
+	app, err := ctrl.GetAppByID(token.AppID)
+	if err != nil {
+		return "", nil, err
+	}
+	if app == nil || (subject != "" && subject != app.Name) {
+		return "", nil, invalidToken
+	}
+	return app.Name, token, nil
+}
+
+// ListTokens list tokens of app
+func (ctrl *AppCtrl) ListTokens(app *App) ([]AppToken, error) {
+	tokens, err := GetAppTokens(ctrl.db, app.ID)
+	if err != nil {
+		logging.Errorf("get tokens of app(%s) fail: %v", app.Name, err)
+		return nil, utils.NewSystemError("list tokens fail")
+	}
+	return tokens, nil
+}
+
+// RevokeToken revoke token id of app
+func (ctrl *AppCtrl) RevokeToken(app *App, id int64) error {
+	ok, err := RevokeAppToken(ctrl.db, app.ID, id)
+	if err != nil {
+		logging.Errorf("revoke token(%d) of app(%s) fail: %v", id, app.Name, err)
+		return utils.NewSystemError("revoke token fail")
+	}
+	if !ok {
+		return utils.Errorf(utils.EcodeNotFound, "no active token %d of %s", id, app.Name)
+	}
+	ctrl.tokens.evict(id)
+	return nil
+}
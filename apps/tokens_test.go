@@ -0,0 +1,91 @@
+package apps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocomm/dbutil"
+	"github.com/infrmods/xbus/utils"
+)
+
+func errCode(err error) string {
+	if e, ok := err.(*utils.Error); ok {
+		return e.Code
+	}
+	return ""
+}
+
+func TestCapTTL(t *testing.T) {
+	now := time.Now()
+	parent := &AppToken{ID: 1, ExpireTime: dbutil.NullTime{Time: now.Add(time.Hour), Valid: true}}
+	for _, c := range []struct {
+		ttl    time.Duration
+		parent *AppToken
+		want   time.Duration
+	}{
+		{0, nil, 0},
+		{time.Minute, nil, time.Minute},
+		{0, &AppToken{ID: 2}, 0},
+		{time.Minute, parent, time.Minute},
+		{0, parent, time.Hour},
+		{24 * time.Hour, parent, time.Hour},
+	} {
+		if got, err := capTTL(c.ttl, c.parent, now); err != nil || got != c.want {
+			t.Errorf("capTTL(%v, %+v) = %v, %v; want %v", c.ttl, c.parent, got, err, c.want)
+		}
+	}
+	expired := &AppToken{ID: 3, ExpireTime: dbutil.NullTime{Time: now, Valid: true}}
+	if _, err := capTTL(time.Minute, expired, now); errCode(err) != utils.EcodeInvalidToken {
+		t.Errorf("capTTL by an expired parent: %v", err)
+	}
+}
+
+func TestVerifyTokenCache(t *testing.T) {
+	// no db, only cached tokens could be verified
+	ctrl := &AppCtrl{config: &Config{Tokens: TokenConfig{CacheTTL: time.Minute}}}
+	now := time.Now()
+	valid := &AppToken{ID: 1, Kind: TokenKindKey}
+	ctrl.tokens.put(hashSecret("xbus_1_a"), "app-a", valid, now.Add(time.Minute))
+	expiring := &AppToken{ID: 2, Kind: TokenKindKey, ExpireTime: dbutil.NullTime{Time: now, Valid: true}}
+	ctrl.tokens.put(hashSecret("xbus_2_b"), "app-b", expiring, now.Add(time.Minute))
+
+	if name, token, err := ctrl.VerifyToken("xbus_1_a"); err != nil || name != "app-a" || token != valid {
+		t.Errorf("verify cached token: %s, %+v, %v", name, token, err)
+	}
+	if _, _, err := ctrl.VerifyToken("xbus_2_b"); errCode(err) != utils.EcodeInvalidToken {
+		t.Errorf("verify cached expired token: %v", err)
+	}
+	if _, _, err := ctrl.VerifyToken("xbus_x"); errCode(err) != utils.EcodeInvalidToken {
+		t.Errorf("verify malformed token: %v", err)
+	}
+
+	ctrl.tokens.evict(1)
+	if _, _, ok := ctrl.tokens.get(hashSecret("xbus_1_a"), now); ok {
+		t.Error("revoked token still cached")
+	}
+	if _, _, ok := ctrl.tokens.get(hashSecret("xbus_2_b"), now); !ok {
+		t.Error("other token evicted")
+	}
+	if _, _, ok := ctrl.tokens.get(hashSecret("xbus_2_b"), now.Add(time.Minute)); ok {
+		t.Error("cache entry not expired")
+	}
+}
+
+func TestParseJWT(t *testing.T) {
+	ctrl := &AppCtrl{config: &Config{Tokens: TokenConfig{JWTSecret: "secret"}}}
+	value, err := ctrl.newJWT(&App{Name: "app-a"}, &AppToken{ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ctrl.parseJWT(value)
+	if err != nil || claims.Subject != "app-a" || claims.ID != "7" {
+		t.Fatalf("parse jwt: %+v, %v", claims, err)
+	}
+	other := &AppCtrl{config: &Config{Tokens: TokenConfig{JWTSecret: "other"}}}
+	if _, err := other.parseJWT(value); err != invalidToken {
+		t.Errorf("jwt of another secret: %v", err)
+	}
+	if _, err := ctrl.parseJWT(value[:len(value)-2]); err != invalidToken {
+		t.Errorf("tampered jwt: %v", err)
+	}
+}
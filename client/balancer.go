@@ -0,0 +1,255 @@
+package client
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SlowStart ramp up traffic to newly plugged endpoints over Window instead of sending
+// full load at once: the weight grows from MinWeight (0.1 by default) to 1 as
+// (age / Window) ^ (1 / Aggression) (1 by default, linear); disabled if Window is 0
+type SlowStart struct {
+	Window     time.Duration
+	MinWeight  float64
+	Aggression float64
+}
+
+// Weight weight of endpoint at now in [MinWeight, 1], 1 for endpoints without PlugTime
+// (plugged by older xbus) and past the window
+func (s SlowStart) Weight(endpoint ServiceEndpoint, now time.Time) float64 {
+	if s.Window <= 0 || endpoint.PlugTime == nil {
+		return 1
+	}
+	age := now.Sub(*endpoint.PlugTime)
+	if age >= s.Window {
+		return 1
+	}
+	minWeight := s.MinWeight
+	if minWeight <= 0 || minWeight > 1 {
+		minWeight = 0.1
+	}
+	aggression := s.Aggression
+	if aggression <= 0 {
+		aggression = 1
+	}
+	weight := math.Pow(math.Max(age.Seconds(), 0)/s.Window.Seconds(), 1/aggression)
+	return math.Max(weight, minWeight)
+}
+
+// OutlierDetection eject an endpoint from picks after ConsecutiveFailures failures reported
+// by callers, for Ejection (30s by default) doubled on every ejection since its last success
+// up to MaxEjection (5m by default); once re-admitted it's on probation, a failure ejects it
+// again at once. At most MaxEjectedPercent (50 by default) of endpoints are ejected;
+// disabled if ConsecutiveFailures is 0
+type OutlierDetection struct {
+	ConsecutiveFailures int
+	Ejection            time.Duration
+	MaxEjection         time.Duration
+	MaxEjectedPercent   int
+}
+
+type endpointHealth struct {
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// Balancer weighted random picker of endpoints, weighted by SlowStart, leaving out
+// endpoints ejected by OutlierDetection; safe for concurrent use, Update it with the
+// watched endpoints, e.g. by WatchBalancer
+type Balancer struct {
+	slowStart SlowStart
+	clock     Clock
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	endpoints []ServiceEndpoint
+	outlier   OutlierDetection
+	health    map[string]*endpointHealth
+}
+
+// NewBalancer new balancer, RealClock if clock is nil
+func NewBalancer(slowStart SlowStart, clock Clock) *Balancer {
+	if clock == nil {
+		clock = RealClock
+	}
+	return &Balancer{slowStart: slowStart, clock: clock,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())), health: make(map[string]*endpointHealth)}
+}
+
+// SetOutlierDetection eject endpoints failing as reported by ReportFailure
+func (b *Balancer) SetOutlierDetection(outlier OutlierDetection) {
+	if outlier.Ejection <= 0 {
+		outlier.Ejection = 30 * time.Second
+	}
+	if outlier.MaxEjection < outlier.Ejection {
+		outlier.MaxEjection = 10 * outlier.Ejection
+		if outlier.MaxEjection > 5*time.Minute {
+			outlier.MaxEjection = 5 * time.Minute
+		}
+	}
+	if outlier.MaxEjectedPercent <= 0 || outlier.MaxEjectedPercent > 100 {
+		outlier.MaxEjectedPercent = 50
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.outlier = outlier
+}
+
+// Update set endpoints of the balancer, failures of removed endpoints are forgotten
+func (b *Balancer) Update(endpoints []ServiceEndpoint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.endpoints = append([]ServiceEndpoint(nil), endpoints...)
+	current := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		current[endpoint.Address] = true
+	}
+	for address := range b.health {
+		if !current[address] {
+			delete(b.health, address)
+		}
+	}
+}
+
+func (b *Balancer) ejectedLocked(address string, now time.Time) bool {
+	h := b.health[address]
+	return h != nil && now.Before(h.ejectedUntil)
+}
+
+// ReportSuccess report a call to the endpoint at address succeeded
+func (b *Balancer) ReportSuccess(address string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h := b.health[address]; h != nil && !b.ejectedLocked(address, b.clock.Now()) {
+		delete(b.health, address)
+	}
+}
+
+// ReportFailure report a call to the endpoint at address failed, e.g. connecting or
+// by a 5xx response, returns whether the endpoint is ejected
+func (b *Balancer) ReportFailure(address string) bool {
+	now := b.clock.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.outlier.ConsecutiveFailures <= 0 {
+		return false
+	}
+	known := false
+	ejected := 0
+	for _, endpoint := range b.endpoints {
+		if endpoint.Address == address {
+			known = true
+		}
+		if b.ejectedLocked(endpoint.Address, now) {
+			ejected++
+		}
+	}
+	if !known {
+		return false
+	}
+	h := b.health[address]
+	if h == nil {
+		h = new(endpointHealth)
+		b.health[address] = h
+	}
+	if now.Before(h.ejectedUntil) {
+		return true
+	}
+	h.failures++
+	// on probation after an ejection
+	if h.failures < b.outlier.ConsecutiveFailures && h.ejections == 0 {
+		return false
+	}
+	if (ejected+1)*100 > len(b.endpoints)*b.outlier.MaxEjectedPercent {
+		return false
+	}
+	ejection := b.outlier.Ejection
+	for i := 0; i < h.ejections && ejection < b.outlier.MaxEjection; i++ {
+		ejection *= 2
+	}
+	if ejection > b.outlier.MaxEjection {
+		ejection = b.outlier.MaxEjection
+	}
+	h.failures = 0
+	h.ejections++
+	h.ejectedUntil = now.Add(ejection)
+	return true
+}
+
+// Ejected addresses of ejected endpoints
+func (b *Balancer) Ejected() []string {
+	now := b.clock.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ejected []string
+	for _, endpoint := range b.endpoints {
+		if b.ejectedLocked(endpoint.Address, now) {
+			ejected = append(ejected, endpoint.Address)
+		}
+	}
+	return ejected
+}
+
+// UpdateService update the balancer with endpoints of zone of service, none if the zone is absent
+func (b *Balancer) UpdateService(service *Service, zone string) {
+	var endpoints []ServiceEndpoint
+	if service != nil {
+		if z := service.Zones[zone]; z != nil {
+			endpoints = z.Endpoints
+		}
+	}
+	b.Update(endpoints)
+}
+
+// Endpoints endpoints of the balancer
+func (b *Balancer) Endpoints() []ServiceEndpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]ServiceEndpoint(nil), b.endpoints...)
+}
+
+// Pick pick an endpoint not ejected at random by weight, among all if all are ejected,
+// false if there are none
+func (b *Balancer) Pick() (ServiceEndpoint, bool) {
+	now := b.clock.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.endpoints) == 0 {
+		return ServiceEndpoint{}, false
+	}
+	weights := make([]float64, len(b.endpoints))
+	total := 0.0
+	for i, endpoint := range b.endpoints {
+		if !b.ejectedLocked(endpoint.Address, now) {
+			weights[i] = b.slowStart.Weight(endpoint, now)
+			total += weights[i]
+		}
+	}
+	if total == 0 {
+		// all ejected, e.g. MaxEjectedPercent 100, pick among them rather than none
+		for i, endpoint := range b.endpoints {
+			weights[i] = b.slowStart.Weight(endpoint, now)
+			total += weights[i]
+		}
+	}
+	r := b.rand.Float64() * total
+	for i, weight := range weights {
+		if r < weight {
+			return b.endpoints[i], true
+		}
+		r -= weight
+	}
+	return b.endpoints[len(b.endpoints)-1], true
+}
+
+// WatchBalancer keep b updated with endpoints of zone of service until ctx done, see WatchLoop
+func (client *Client) WatchBalancer(ctx context.Context, service, zone string, b *Balancer,
+	retryInterval time.Duration, opts ...QueryOption) {
+	client.WatchLoop(ctx, service, retryInterval, func(s *Service) {
+		b.UpdateService(s, zone)
+	}, opts...)
+}
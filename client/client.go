@@ -0,0 +1,455 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+)
+
+// Config client config
+type Config struct {
+	Endpoint     string
+	TLSConfig    *tls.Config
+	Timeout      time.Duration
+	WatchTimeout time.Duration
+	// DevApp app name sent as Dev-App header, accepted from dev nets only
+	DevApp string
+	// Token api key or jwt sent as bearer token, instead of a client cert
+	Token string
+	// KeepAliveStream keep registrations alive with a server side keepalive
+	// stream instead of refreshing on a timer
+	KeepAliveStream bool
+
+	// Transport overrides the http transport, e.g. xbustest.FakeTransport
+	Transport Transport
+	// Clock overrides the wall clock, e.g. xbustest.FakeClock
+	Clock Clock
+	// Snapshot saves queried and watched services, see QueryCached, OpenSnapshot
+	Snapshot *Snapshot
+}
+
+// Client xbus client
+type Client struct {
+	config    Config
+	transport Transport
+	clock     Clock
+}
+
+// NewClient new client
+func NewClient(config Config) *Client {
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.WatchTimeout <= 0 {
+		config.WatchTimeout = 60 * time.Second
+	}
+	client := &Client{config: config, transport: config.Transport, clock: config.Clock}
+	if client.transport == nil {
+		transport := NewHTTPTransport(config.Endpoint, config.TLSConfig)
+		if config.DevApp != "" {
+			transport.SetHeader("Dev-App", config.DevApp)
+		}
+		if config.Token != "" {
+			transport.SetHeader("Authorization", "Bearer "+config.Token)
+		}
+		client.transport = transport
+	}
+	if client.clock == nil {
+		client.clock = RealClock
+	}
+	return client
+}
+
+// Transport client's transport
+func (client *Client) Transport() Transport {
+	return client.transport
+}
+
+// Clock client's clock
+func (client *Client) Clock() Clock {
+	return client.clock
+}
+
+// Query query service, opts e.g. PreferZone
+func (client *Client) Query(ctx context.Context, service string, opts ...QueryOption) (*Service, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	result, err := client.transport.Query(ctx, service)
+	if err != nil {
+		return nil, 0, err
+	}
+	client.saveSnapshot(service, result.Service, result.Revision)
+	return applyQueryOptions(result.Service, opts), result.Revision, nil
+}
+
+// QueryAt query service as it was at revision, e.g. to reconstruct the registry during
+// an incident, fails with EcodeRevisionCompacted once revision is compacted by etcd
+func (client *Client) QueryAt(ctx context.Context, service string, revision int64, opts ...QueryOption) (*Service, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	result, err := client.transport.QueryAt(ctx, service, revision)
+	if err != nil {
+		return nil, err
+	}
+	return applyQueryOptions(result.Service, opts), nil
+}
+
+// QueryVersion query the highest version of service name with endpoints
+// in versionRange, e.g. `>=1.2 <2.0` or VersionLatest
+func (client *Client) QueryVersion(ctx context.Context, name, versionRange string, opts ...QueryOption) (*Service, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	result, err := client.transport.QueryVersion(ctx, name, versionRange)
+	if err != nil {
+		return nil, 0, err
+	}
+	return applyQueryOptions(result.Service, opts), result.Revision, nil
+}
+
+// QueryMulti query services of refs at one revision,
+// e.g. the whole dependency set at startup
+func (client *Client) QueryMulti(ctx context.Context, refs ...ServiceRef) (*MultiQueryResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.QueryMulti(ctx, refs)
+}
+
+// Count endpoints count of service in zone, or all zones if zone is empty,
+// without transferring endpoints, e.g. for autoscalers and alerting
+func (client *Client) Count(ctx context.Context, service, zone string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	result, err := client.transport.Count(ctx, service, zone)
+	if err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// Search search services containing q
+func (client *Client) Search(ctx context.Context, q string, skip, limit int64) (*SearchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.Search(ctx, q, skip, limit)
+}
+
+// GetSchema interface descriptor of kind of service at revision, the latest if revision is 0
+func (client *Client) GetSchema(ctx context.Context, service, kind string, revision int64) (*Schema, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.GetSchema(ctx, service, kind, revision)
+}
+
+// GetMetadata catalog metadata of service name, e.g. owner and oncall
+func (client *Client) GetMetadata(ctx context.Context, name string) (*ServiceMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.GetMetadata(ctx, name)
+}
+
+// DeclareDependencies declare services the app consumes, replacing the declared ones,
+// for dependency graphs and impact queries of planned maintenance
+func (client *Client) DeclareDependencies(ctx context.Context, services []string) error {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.DeclareDependencies(ctx, services)
+}
+
+// History recent changes of name:version (all versions if version is empty) since,
+// e.g. to find out why traffic shifted; kept in memory of each xbus server, so they are
+// the ones seen by the server serving the request
+func (client *Client) History(ctx context.Context, name, version string, since time.Time) ([]HistoryEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.History(ctx, name, version, since)
+}
+
+// FlappingEndpoints endpoints of service flagged as flapping by the xbus server serving the request
+func (client *Client) FlappingEndpoints(ctx context.Context, service string) ([]FlappingEndpoint, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.FlappingEndpoints(ctx, service)
+}
+
+// Unplug unplug endpoint of service zone
+func (client *Client) Unplug(ctx context.Context, service, zone, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.Unplug(ctx, service, zone, addr)
+}
+
+// GetEndpoint endpoint of service zone with its mod revision
+func (client *Client) GetEndpoint(ctx context.Context, service, zone, addr string) (*EndpointRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.GetEndpoint(ctx, service, zone, addr)
+}
+
+// UpdateIfVersion update endpoint of service zone, keeping its lease, if unchanged since
+// modRevision (see GetEndpoint), fails with EcodeEndpointChanged otherwise;
+// returns the new mod revision
+func (client *Client) UpdateIfVersion(ctx context.Context, service, zone, addr string,
+	modRevision int64, endpoint *ServiceEndpoint) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.UpdateIfVersion(ctx, service, zone, addr, modRevision, endpoint)
+}
+
+// PatchEndpoint update only fields of endpoint in patch, e.g. {"config": "..."}, null removes a
+// field; concurrent changes are retried server side unless modRevision is not 0
+func (client *Client) PatchEndpoint(ctx context.Context, service, zone, addr string,
+	modRevision int64, patch map[string]interface{}) (*EndpointRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	return client.transport.PatchEndpoint(ctx, service, zone, addr, modRevision, patch)
+}
+
+// Watch wait for changes of service since revision,
+// on failure the returned revision is where to resume from, see Error
+func (client *Client) Watch(ctx context.Context, service string, revision int64, opts ...QueryOption) (*Service, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.WatchTimeout+client.config.Timeout)
+	defer cancel()
+	result, err := client.transport.Watch(ctx, service, revision, client.config.WatchTimeout)
+	if err != nil {
+		if e, ok := err.(*Error); ok {
+			return nil, e.Revision, err
+		}
+		return nil, 0, err
+	}
+	client.saveSnapshot(service, result.Service, result.Revision)
+	return applyQueryOptions(result.Service, opts), result.Revision, nil
+}
+
+// WatchLoop query service and call fn on every change until ctx done,
+// failures are retried after retryInterval, if xbus is unreachable at start fn is
+// called with the service of Config.Snapshot first
+func (client *Client) WatchLoop(ctx context.Context, service string, retryInterval time.Duration, fn func(*Service), opts ...QueryOption) {
+	var revision int64
+	delivered := false
+	for ctx.Err() == nil {
+		var s *Service
+		var rev int64
+		var err error
+		if revision == 0 {
+			s, rev, err = client.Query(ctx, service, opts...)
+		} else {
+			s, rev, err = client.Watch(ctx, service, revision+1, opts...)
+		}
+		if err != nil {
+			if IsErrCode(err, EcodeDeadlineExceeded) && ctx.Err() == nil {
+				// nothing changed, resume
+				if rev > 0 {
+					revision = rev
+				}
+				continue
+			}
+			if IsErrCode(err, EcodeRevisionCompacted) {
+				// history lost, re-sync by query
+				revision = 0
+				continue
+			}
+			if !delivered && client.config.Snapshot != nil && isUnavailable(err) {
+				if entry := client.config.Snapshot.Get(service); entry != nil {
+					delivered = true
+					fn(applyQueryOptions(entry.Service, opts))
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-client.clock.After(retryInterval):
+			}
+			revision = 0
+			continue
+		}
+		revision = rev
+		if s != nil {
+			delivered = true
+			fn(s)
+		}
+	}
+}
+
+// WatchMulti wait for changes of services of refs, or named with prefix, since revision,
+// items are the changed services tagged by refs, all of them if revision is 0
+func (client *Client) WatchMulti(ctx context.Context, refs []ServiceRef, prefix string, revision int64) (*MultiQueryResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.WatchTimeout+client.config.Timeout)
+	defer cancel()
+	return client.transport.WatchMulti(ctx, refs, prefix, revision, client.config.WatchTimeout)
+}
+
+// WatchMultiLoop watch services of refs, or named with prefix, calling fn with all of
+// them first and then with changed ones until ctx done, failures are retried after retryInterval
+func (client *Client) WatchMultiLoop(ctx context.Context, refs []ServiceRef, prefix string, retryInterval time.Duration, fn func([]MultiQueryItem)) {
+	var revision int64
+	for ctx.Err() == nil {
+		var from int64
+		if revision > 0 {
+			from = revision + 1
+		}
+		result, err := client.WatchMulti(ctx, refs, prefix, from)
+		if err != nil {
+			if e, ok := err.(*Error); ok && e.Code == EcodeDeadlineExceeded && ctx.Err() == nil {
+				if e.Revision > 0 && revision > 0 {
+					revision = e.Revision
+				}
+				continue
+			}
+			if IsErrCode(err, EcodeRevisionCompacted) {
+				revision = 0
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-client.clock.After(retryInterval):
+			}
+			revision = 0
+			continue
+		}
+		revision = result.Revision
+		if len(result.Services) > 0 {
+			fn(result.Services)
+		}
+	}
+}
+
+// Registration plugged endpoint kept alive in background
+type Registration struct {
+	client   *Client
+	ttl      time.Duration
+	descs    []ServiceDesc
+	endpoint ServiceEndpoint
+
+	mu      sync.Mutex
+	leaseID int64
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	// OnReplug called after the lease expired and endpoint re-plugged
+	OnReplug func(leaseID int64)
+	// OnError called on keepalive/replug failures
+	OnError func(err error)
+}
+
+// Plug plug endpoint for descs and keep it alive until Close
+func (client *Client) Plug(ctx context.Context, ttl time.Duration, descs []ServiceDesc, endpoint ServiceEndpoint) (*Registration, error) {
+	reg := &Registration{client: client, ttl: ttl, descs: descs, endpoint: endpoint, done: make(chan struct{})}
+	if err := reg.plug(ctx); err != nil {
+		return nil, err
+	}
+	loopCtx, cancel := context.WithCancel(context.Background())
+	reg.cancel = cancel
+	go reg.keepAliveLoop(loopCtx)
+	return reg, nil
+}
+
+func (reg *Registration) plug(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, reg.client.config.Timeout)
+	defer cancel()
+	result, err := reg.client.transport.PlugAll(ctx, reg.ttl, 0, reg.descs, &reg.endpoint)
+	if err != nil {
+		return err
+	}
+	reg.mu.Lock()
+	reg.leaseID = result.LeaseID
+	reg.mu.Unlock()
+	return nil
+}
+
+// LeaseID current lease id
+func (reg *Registration) LeaseID() int64 {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.leaseID
+}
+
+func (reg *Registration) onError(err error) {
+	if reg.OnError != nil {
+		reg.OnError(err)
+	}
+}
+
+func (reg *Registration) keepAliveLoop(ctx context.Context) {
+	defer close(reg.done)
+	interval := reg.ttl / 3
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if reg.client.config.KeepAliveStream {
+		reg.keepAliveStream(ctx, interval)
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reg.client.clock.After(interval):
+		}
+
+		kaCtx, cancel := context.WithTimeout(ctx, reg.client.config.Timeout)
+		err := reg.client.transport.KeepAlive(kaCtx, reg.LeaseID())
+		cancel()
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		reg.onError(err)
+		if IsErrCode(err, EcodeNotFound) {
+			if err := reg.plug(ctx); err != nil {
+				reg.onError(err)
+			} else if reg.OnReplug != nil {
+				reg.OnReplug(reg.LeaseID())
+			}
+		}
+	}
+}
+
+func (reg *Registration) keepAliveStream(ctx context.Context, retryInterval time.Duration) {
+	for {
+		n, expired := 0, false
+		err := reg.client.transport.KeepAliveStream(ctx, reg.LeaseID(), func(event LeaseEvent) {
+			n++
+			expired = expired || event.Type == LeaseEventExpired
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && expired {
+			err = &Error{Code: EcodeNotFound, Message: "lease expired"}
+		} else if err == nil && n == 0 {
+			err = &Error{Code: EcodeSystemError, Message: "keepalive stream closed"}
+		}
+		if err == nil {
+			// closed by the server, e.g. shutting down
+			continue
+		}
+		reg.onError(err)
+		if IsErrCode(err, EcodeNotFound) {
+			if err := reg.plug(ctx); err == nil {
+				if reg.OnReplug != nil {
+					reg.OnReplug(reg.LeaseID())
+				}
+				continue
+			}
+			reg.onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-reg.client.clock.After(retryInterval):
+		}
+	}
+}
+
+// Close stop keepalive and revoke lease
+func (reg *Registration) Close(ctx context.Context) error {
+	reg.cancel()
+	<-reg.done
+	ctx, cancel := context.WithTimeout(ctx, reg.client.config.Timeout)
+	defer cancel()
+	return reg.client.transport.Revoke(ctx, reg.LeaseID())
+}
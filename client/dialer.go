@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostSuffix suffix of hosts resolved by Dialer, e.g. payments.core.1.0.xbus
+const HostSuffix = ".xbus"
+
+// ServiceOfHost service of host like payments.core.1.0.xbus (port ignored):
+// the version starts at the first label beginning with a digit, or is the last label,
+// e.g. payments.core.stable.xbus for the alias stable; false if host isn't of xbus
+func ServiceOfHost(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if !strings.HasSuffix(host, HostSuffix) {
+		return "", false
+	}
+	labels := strings.Split(strings.TrimSuffix(host, HostSuffix), ".")
+	if len(labels) < 2 {
+		return "", false
+	}
+	i := len(labels) - 1
+	for j := 1; j < len(labels)-1; j++ {
+		if labels[j] != "" && labels[j][0] >= '0' && labels[j][0] <= '9' {
+			i = j
+			break
+		}
+	}
+	return strings.Join(labels[:i], ".") + ":" + strings.Join(labels[i:], "."), true
+}
+
+// Dialer dial hosts of xbus (see ServiceOfHost) by endpoint addresses of their services
+// in Zone, picked by Balancer weights and failing over to up to Failover others, endpoints
+// failing to connect are ejected by Outlier; a service is queried on its first dial then
+// watched until ctx done. With Policies the client policy of a service is watched too, its
+// eject settings override Outlier, its retries Failover and failovers are within its retry
+// budget. Other hosts are dialed by Base
+type Dialer struct {
+	Base          *net.Dialer
+	Failover      int
+	SlowStart     SlowStart
+	Outlier       OutlierDetection
+	Policies      bool
+	RetryInterval time.Duration
+
+	ctx    context.Context
+	client *Client
+	zone   string
+	opts   []QueryOption
+
+	mu        sync.Mutex
+	balancers map[string]*dialBalancer
+}
+
+type dialBalancer struct {
+	balancer *Balancer
+	budget   *RetryBudget
+	ready    chan struct{}
+	err      error
+
+	mu     sync.Mutex
+	policy *ClientPolicy
+}
+
+func (b *dialBalancer) currentPolicy() *ClientPolicy {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.policy
+}
+
+// NewDialer new dialer of services in zone, with Failover 2
+func (client *Client) NewDialer(ctx context.Context, zone string, opts ...QueryOption) *Dialer {
+	return &Dialer{Base: &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second},
+		Failover: 2, RetryInterval: 5 * time.Second,
+		ctx: ctx, client: client, zone: zone, opts: opts, balancers: make(map[string]*dialBalancer)}
+}
+
+// balancer of service, queried at first then kept updated by watch
+func (d *Dialer) balancer(ctx context.Context, service string) (*dialBalancer, error) {
+	d.mu.Lock()
+	b, ok := d.balancers[service]
+	if !ok {
+		b = &dialBalancer{balancer: NewBalancer(d.SlowStart, d.client.clock),
+			budget: NewRetryBudget(0, d.client.clock), ready: make(chan struct{})}
+		b.balancer.SetOutlierDetection(d.Outlier)
+		d.balancers[service] = b
+	}
+	d.mu.Unlock()
+
+	if !ok {
+		s, _, _, err := d.client.QueryCached(ctx, service, d.opts...)
+		if err != nil {
+			b.err = err
+			d.mu.Lock()
+			delete(d.balancers, service)
+			d.mu.Unlock()
+		} else {
+			b.balancer.UpdateService(s, d.zone)
+			go d.client.WatchBalancer(d.ctx, service, d.zone, b.balancer, d.RetryInterval, d.opts...)
+			if d.Policies {
+				go d.client.WatchPolicyLoop(d.ctx, service, d.RetryInterval, func(policy *ClientPolicy) {
+					b.mu.Lock()
+					b.policy = policy
+					b.mu.Unlock()
+					b.balancer.SetOutlierDetection(policy.Outlier(d.Outlier))
+					budget := 0
+					if policy != nil {
+						budget = policy.RetryBudget
+					}
+					b.budget.SetPercent(budget)
+				})
+			}
+		}
+		close(b.ready)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-b.ready:
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b, nil
+}
+
+// Policy client policy of service last watched, nil if not set or not watched yet,
+// e.g. for request timeouts by TimeoutOr
+func (d *Dialer) Policy(service string) *ClientPolicy {
+	d.mu.Lock()
+	b := d.balancers[service]
+	d.mu.Unlock()
+	if b == nil {
+		return nil
+	}
+	return b.currentPolicy()
+}
+
+// DialContext dial addr, by endpoints of its service if its host is of xbus
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	service, ok := ServiceOfHost(addr)
+	if !ok {
+		return d.Base.DialContext(ctx, network, addr)
+	}
+	db, err := d.balancer(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	b := db.balancer
+	failover := db.currentPolicy().RetriesOr(d.Failover)
+	db.budget.Request()
+	tried := make(map[string]bool)
+	var lastErr error
+	for i := 0; i <= failover; i++ {
+		if i > 0 && !db.budget.Retry() {
+			break
+		}
+		endpoint, ok := b.Pick()
+		for j := 0; ok && tried[endpoint.Address] && j < 3; j++ {
+			endpoint, ok = b.Pick()
+		}
+		if !ok || tried[endpoint.Address] {
+			break
+		}
+		tried[endpoint.Address] = true
+		conn, err := d.Base.DialContext(ctx, network, endpoint.Address)
+		if err == nil {
+			b.ReportSuccess(endpoint.Address)
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		b.ReportFailure(endpoint.Address)
+	}
+	if lastErr == nil {
+		return nil, fmt.Errorf("no endpoints of %s in %s", service, d.zone)
+	}
+	return nil, lastErr
+}
+
+// RoundTripper http transport dialing hosts of xbus by a Dialer of zone, other hosts as usual,
+// e.g. http.Client{Transport: client.RoundTripper(ctx, "default")}; hosts of xbus bypass
+// proxies, and https to them verifies certs for the xbus host name
+func (client *Client) RoundTripper(ctx context.Context, zone string, opts ...QueryOption) *http.Transport {
+	return client.NewDialer(ctx, zone, opts...).Transport()
+}
+
+// Transport http transport dialing by d, see RoundTripper
+func (d *Dialer) Transport() *http.Transport {
+	return &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			if _, ok := ServiceOfHost(req.URL.Host); ok {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(req)
+		},
+		DialContext:           d.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
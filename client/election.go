@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// Leadership leadership of an election, its lease kept alive until Resign
+type Leadership struct {
+	*leaseKeeper
+	leader Leader
+}
+
+// Campaign wait until elected as leader of name with value or ctx done, the leadership
+// is lost ttl after this process stops keeping it alive, e.g. crashed
+func (client *Client) Campaign(ctx context.Context, name, value string, ttl time.Duration) (*Leadership, error) {
+	for {
+		timeout := client.waitTimeout(ctx)
+		campaignCtx, cancel := context.WithTimeout(ctx, timeout+client.config.Timeout)
+		leader, err := client.transport.Campaign(campaignCtx, name, value, ttl, timeout)
+		cancel()
+		if err != nil {
+			if IsErrCode(err, EcodeDeadlineExceeded) && ctx.Err() == nil {
+				continue
+			}
+			return nil, err
+		}
+		return &Leadership{leaseKeeper: client.keepLease(leader.LeaseID, ttl), leader: *leader}, nil
+	}
+}
+
+// Leader the elected leader
+func (leadership *Leadership) Leader() Leader {
+	return leadership.leader
+}
+
+// Resign stop keepalive and resign
+func (leadership *Leadership) Resign(ctx context.Context) error {
+	leadership.stop()
+	ctx, cancel := context.WithTimeout(ctx, leadership.client.config.Timeout)
+	defer cancel()
+	return leadership.client.transport.Resign(ctx, leadership.leader.Name, leadership.leader.LeaseID)
+}
+
+// GetLeader current leader of name, nil if none
+func (client *Client) GetLeader(ctx context.Context, name string) (*Leader, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	result, err := client.transport.GetLeader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return result.Leader, nil
+}
+
+// ObserveLeader call fn with the leader of name (nil if none) and on every change until ctx done,
+// failures are retried after retryInterval
+func (client *Client) ObserveLeader(ctx context.Context, name string, retryInterval time.Duration, fn func(*Leader)) {
+	synced, delivered, leaderRevision := false, false, int64(0)
+	for ctx.Err() == nil {
+		var leader *Leader
+		var err error
+		if !synced {
+			leader, err = client.GetLeader(ctx, name)
+		} else {
+			observeCtx, cancel := context.WithTimeout(ctx, client.config.WatchTimeout+client.config.Timeout)
+			var result *LeaderResult
+			result, err = client.transport.ObserveLeader(observeCtx, name, leaderRevision, client.config.WatchTimeout)
+			cancel()
+			if err == nil {
+				leader = result.Leader
+			} else if IsErrCode(err, EcodeDeadlineExceeded) && ctx.Err() == nil {
+				continue
+			}
+		}
+		if err != nil {
+			synced = false
+			select {
+			case <-ctx.Done():
+				return
+			case <-client.clock.After(retryInterval):
+			}
+			continue
+		}
+		revision := int64(0)
+		if leader != nil {
+			revision = leader.Revision
+		}
+		// re-synced after failures, unchanged
+		if delivered && revision == leaderRevision {
+			synced = true
+			continue
+		}
+		synced, delivered, leaderRevision = true, true, revision
+		fn(leader)
+	}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// environment variables describing an authenticated client,
+// set by xbusctl for its plugins
+const (
+	EnvEndpoint = "XBUS_ENDPOINT"
+	EnvCertFile = "XBUS_CERT_FILE"
+	EnvKeyFile  = "XBUS_KEY_FILE"
+	EnvCAFile   = "XBUS_CA_FILE"
+	EnvDevApp   = "XBUS_DEV_APP"
+)
+
+// LoadTLSConfig tls config with optional app cert and ca cert
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load cert fail: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca cert fail: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("invalid ca cert: %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// ConfigFromEnv client config from XBUS_* environment variables
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		Endpoint: os.Getenv(EnvEndpoint),
+		DevApp:   os.Getenv(EnvDevApp),
+	}
+	if config.Endpoint == "" {
+		return config, fmt.Errorf("missing %s", EnvEndpoint)
+	}
+	tlsConfig, err := LoadTLSConfig(os.Getenv(EnvCertFile), os.Getenv(EnvKeyFile), os.Getenv(EnvCAFile))
+	if err != nil {
+		return config, err
+	}
+	config.TLSConfig = tlsConfig
+	return config, nil
+}
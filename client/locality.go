@@ -0,0 +1,74 @@
+package client
+
+// QueryOption option applied to queried services
+type QueryOption func(*queryOptions)
+
+type queryOptions struct {
+	preferZone string
+	minLocal   int
+	subsetID   string
+	subsetSize int
+	byPriority bool
+}
+
+// PreferZone keep only endpoints with Locality zone, falling back to all
+// endpoints with the local ones first if fewer than MinLocal (1 by default)
+func PreferZone(zone string) QueryOption {
+	return func(opts *queryOptions) {
+		opts.preferZone = zone
+	}
+}
+
+// MinLocal min number of local endpoints of PreferZone, below which endpoints
+// of other localities are returned too
+func MinLocal(n int) QueryOption {
+	return func(opts *queryOptions) {
+		opts.minLocal = n
+	}
+}
+
+func applyQueryOptions(service *Service, options []QueryOption) *Service {
+	if service == nil || len(options) == 0 {
+		return service
+	}
+	opts := queryOptions{minLocal: 1}
+	for _, option := range options {
+		option(&opts)
+	}
+	if !opts.byPriority && opts.preferZone == "" && opts.subsetSize <= 0 {
+		return service
+	}
+	result := &Service{Service: service.Service, Zones: make(map[string]*ServiceZone, len(service.Zones))}
+	for name, zone := range service.Zones {
+		z := *zone
+		if opts.byPriority {
+			if tiers := Tiers(z.Endpoints); len(tiers) > 0 {
+				z.Endpoints = tiers[0]
+			}
+		}
+		if opts.preferZone != "" {
+			z.Endpoints = preferLocality(z.Endpoints, opts.preferZone, opts.minLocal)
+		}
+		if opts.subsetSize > 0 {
+			z.Endpoints = subset(z.Endpoints, opts.subsetID, opts.subsetSize)
+		}
+		result.Zones[name] = &z
+	}
+	return result
+}
+
+func preferLocality(endpoints []ServiceEndpoint, locality string, minLocal int) []ServiceEndpoint {
+	local := make([]ServiceEndpoint, 0, len(endpoints))
+	var others []ServiceEndpoint
+	for _, endpoint := range endpoints {
+		if endpoint.Locality == locality {
+			local = append(local, endpoint)
+		} else {
+			others = append(others, endpoint)
+		}
+	}
+	if len(local) > 0 && len(local) >= minLocal {
+		return local
+	}
+	return append(local, others...)
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// leaseKeeper keeps the lease of a lock or leadership alive until stopped
+type leaseKeeper struct {
+	client  *Client
+	leaseID int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	lost   chan struct{}
+
+	// OnError called on keepalive failures
+	OnError func(err error)
+}
+
+func (client *Client) keepLease(leaseID int64, ttl time.Duration) *leaseKeeper {
+	ctx, cancel := context.WithCancel(context.Background())
+	keeper := &leaseKeeper{client: client, leaseID: leaseID, cancel: cancel,
+		done: make(chan struct{}), lost: make(chan struct{})}
+	go keeper.keepAliveLoop(ctx, ttl)
+	return keeper
+}
+
+// Lost closed if the lease expired, the lock or leadership may be taken by others then
+func (keeper *leaseKeeper) Lost() <-chan struct{} {
+	return keeper.lost
+}
+
+func (keeper *leaseKeeper) keepAliveLoop(ctx context.Context, ttl time.Duration) {
+	defer close(keeper.done)
+	interval := ttl / 3
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keeper.client.clock.After(interval):
+		}
+		kaCtx, cancel := context.WithTimeout(ctx, keeper.client.config.Timeout)
+		err := keeper.client.transport.KeepAlive(kaCtx, keeper.leaseID)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		if keeper.OnError != nil {
+			keeper.OnError(err)
+		}
+		if IsErrCode(err, EcodeNotFound) {
+			close(keeper.lost)
+			return
+		}
+	}
+}
+
+func (keeper *leaseKeeper) stop() {
+	keeper.cancel()
+	<-keeper.done
+}
+
+// waitTimeout server side timeout of lock and campaign requests, no longer than ctx allows
+func (client *Client) waitTimeout(ctx context.Context) time.Duration {
+	timeout := client.config.WatchTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := deadline.Sub(client.clock.Now()); left < timeout {
+			timeout = left
+		}
+	}
+	if timeout < time.Second {
+		timeout = time.Second
+	}
+	return timeout
+}
+
+// Lock a held distributed lock, its lease kept alive until Unlock
+type Lock struct {
+	*leaseKeeper
+	result LockResult
+}
+
+// Lock wait until the lock of name is held or ctx done, the lock is released
+// ttl after this process stops keeping it alive, e.g. crashed
+func (client *Client) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	for {
+		timeout := client.waitTimeout(ctx)
+		lockCtx, cancel := context.WithTimeout(ctx, timeout+client.config.Timeout)
+		result, err := client.transport.Lock(lockCtx, name, ttl, timeout)
+		cancel()
+		if err != nil {
+			if IsErrCode(err, EcodeDeadlineExceeded) && ctx.Err() == nil {
+				continue
+			}
+			return nil, err
+		}
+		return &Lock{leaseKeeper: client.keepLease(result.LeaseID, ttl), result: *result}, nil
+	}
+}
+
+// Name lock name
+func (lock *Lock) Name() string {
+	return lock.result.Name
+}
+
+// Revision increasing with holders of the lock, pass it to guarded resources as a fencing token
+func (lock *Lock) Revision() int64 {
+	return lock.result.Revision
+}
+
+// Unlock stop keepalive and release the lock
+func (lock *Lock) Unlock(ctx context.Context) error {
+	lock.stop()
+	ctx, cancel := context.WithTimeout(ctx, lock.client.config.Timeout)
+	defer cancel()
+	return lock.client.transport.Unlock(ctx, lock.result.Name, lock.result.LeaseID)
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TimeoutOr timeout of policy, def if not set
+func (policy *ClientPolicy) TimeoutOr(def time.Duration) time.Duration {
+	if policy == nil || policy.TimeoutMs <= 0 {
+		return def
+	}
+	return time.Duration(policy.TimeoutMs) * time.Millisecond
+}
+
+// RetriesOr retries of policy, def if not set; 0 disables retries
+func (policy *ClientPolicy) RetriesOr(def int) int {
+	if policy == nil || policy.Retries == nil {
+		return def
+	}
+	return *policy.Retries
+}
+
+// Outlier outlier detection of policy, fields not set are of base
+func (policy *ClientPolicy) Outlier(base OutlierDetection) OutlierDetection {
+	if policy == nil {
+		return base
+	}
+	if policy.EjectAfter > 0 {
+		base.ConsecutiveFailures = policy.EjectAfter
+	}
+	if policy.EjectionMs > 0 {
+		base.Ejection = time.Duration(policy.EjectionMs) * time.Millisecond
+		if base.MaxEjection < base.Ejection {
+			base.MaxEjection = 0
+		}
+	}
+	if policy.MaxEjectedPercent > 0 {
+		base.MaxEjectedPercent = policy.MaxEjectedPercent
+	}
+	return base
+}
+
+// GetPolicy client policy of service, nil if not set
+func (client *Client) GetPolicy(ctx context.Context, service string) (*ClientPolicy, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.Timeout)
+	defer cancel()
+	result, err := client.transport.GetClientPolicy(ctx, service)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result.Policy, result.Revision, nil
+}
+
+// WatchPolicy wait for the change of the client policy of service since revision, nil if deleted,
+// on failure the returned revision is where to resume from, see Error
+func (client *Client) WatchPolicy(ctx context.Context, service string, revision int64) (*ClientPolicy, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.config.WatchTimeout+client.config.Timeout)
+	defer cancel()
+	result, err := client.transport.WatchClientPolicy(ctx, service, revision, client.config.WatchTimeout)
+	if err != nil {
+		if e, ok := err.(*Error); ok {
+			return nil, e.Revision, err
+		}
+		return nil, 0, err
+	}
+	return result.Policy, result.Revision, nil
+}
+
+// WatchPolicyLoop get the client policy of service and call fn with it on every change
+// until ctx done, nil if not set; failures are retried after retryInterval
+func (client *Client) WatchPolicyLoop(ctx context.Context, service string, retryInterval time.Duration, fn func(*ClientPolicy)) {
+	var revision int64
+	for ctx.Err() == nil {
+		var policy *ClientPolicy
+		var rev int64
+		var err error
+		if revision == 0 {
+			policy, rev, err = client.GetPolicy(ctx, service)
+		} else {
+			policy, rev, err = client.WatchPolicy(ctx, service, revision+1)
+		}
+		if err != nil {
+			if IsErrCode(err, EcodeDeadlineExceeded) && ctx.Err() == nil {
+				// nothing changed, resume
+				if rev > 0 {
+					revision = rev
+				}
+				continue
+			}
+			if IsErrCode(err, EcodeRevisionCompacted) {
+				revision = 0
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-client.clock.After(retryInterval):
+			}
+			revision = 0
+			continue
+		}
+		revision = rev
+		fn(policy)
+	}
+}
+
+// retryBudgetWindow window of requests a RetryBudget is counted over
+const retryBudgetWindow = 10 * time.Second
+
+// minRetriesPerWindow retries always allowed in a window, so low traffic can still retry
+const minRetriesPerWindow = 3
+
+// RetryBudget limit retries to Percent of requests over the last 10s, so retries don't
+// amplify overload; retries are unlimited if Percent is 0
+type RetryBudget struct {
+	clock Clock
+
+	mu          sync.Mutex
+	percent     int
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+// NewRetryBudget new retry budget of percent, RealClock if clock is nil
+func NewRetryBudget(percent int, clock Clock) *RetryBudget {
+	if clock == nil {
+		clock = RealClock
+	}
+	return &RetryBudget{clock: clock, percent: percent}
+}
+
+// SetPercent change the percent of the budget
+func (budget *RetryBudget) SetPercent(percent int) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.percent = percent
+}
+
+func (budget *RetryBudget) rollLocked() {
+	if now := budget.clock.Now(); now.Sub(budget.windowStart) >= retryBudgetWindow {
+		budget.windowStart = now
+		budget.requests, budget.retries = 0, 0
+	}
+}
+
+// Request count a request, not including its retries
+func (budget *RetryBudget) Request() {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.rollLocked()
+	budget.requests++
+}
+
+// Retry count a retry and return true if it's within the budget
+func (budget *RetryBudget) Retry() bool {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.rollLocked()
+	if budget.percent > 0 && budget.retries >= minRetriesPerWindow &&
+		budget.retries*100 >= budget.requests*budget.percent {
+		return false
+	}
+	budget.retries++
+	return true
+}
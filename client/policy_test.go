@@ -0,0 +1,18 @@
+package client
+
+import "testing"
+
+func TestRetriesOr(t *testing.T) {
+	var nilPolicy *ClientPolicy
+	if n := nilPolicy.RetriesOr(2); n != 2 {
+		t.Fatalf("retries of nil policy: %d", n)
+	}
+	if n := (&ClientPolicy{}).RetriesOr(2); n != 2 {
+		t.Fatalf("retries not set: %d", n)
+	}
+	// 0 disables retries instead of falling back
+	zero := 0
+	if n := (&ClientPolicy{Retries: &zero}).RetriesOr(2); n != 0 {
+		t.Fatalf("retries disabled: %d", n)
+	}
+}
@@ -0,0 +1,31 @@
+package client
+
+import "sort"
+
+// ByPriority keep only endpoints of the first failover tier present in each zone, i.e.
+// the primaries, or the standbys of the next tier once no primaries are left; applied
+// before PreferZone and Subset
+func ByPriority() QueryOption {
+	return func(opts *queryOptions) {
+		opts.byPriority = true
+	}
+}
+
+// Tiers endpoints grouped by Priority, primaries first, for balancers falling back to
+// the next tier when all endpoints of a tier fail
+func Tiers(endpoints []ServiceEndpoint) [][]ServiceEndpoint {
+	byPriority := make(map[int][]ServiceEndpoint)
+	for _, endpoint := range endpoints {
+		byPriority[endpoint.Priority] = append(byPriority[endpoint.Priority], endpoint)
+	}
+	priorities := make([]int, 0, len(byPriority))
+	for priority := range byPriority {
+		priorities = append(priorities, priority)
+	}
+	sort.Ints(priorities)
+	tiers := make([][]ServiceEndpoint, 0, len(priorities))
+	for _, priority := range priorities {
+		tiers = append(tiers, byPriority[priority])
+	}
+	return tiers
+}
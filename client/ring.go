@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HashFunc hash of ring points and keys
+type HashFunc func(data []byte) uint64
+
+// DefaultRingReplicas virtual nodes per endpoint if not specified
+const DefaultRingReplicas = 160
+
+// Ring consistent hash ring of endpoints by address, each with replicas virtual nodes,
+// safe for concurrent use; Update it with the watched endpoints, e.g. by WatchRing
+type Ring struct {
+	replicas int
+	hash     HashFunc
+
+	mu        sync.RWMutex
+	endpoints map[string]ServiceEndpoint
+	points    []ringPoint
+}
+
+type ringPoint struct {
+	hash    uint64
+	address string
+}
+
+// NewRing new ring, DefaultRingReplicas if replicas <= 0, FNV-1a (mixed) if hash is nil
+func NewRing(replicas int, hash HashFunc) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultRingReplicas
+	}
+	if hash == nil {
+		hash = fnvHash
+	}
+	return &Ring{replicas: replicas, hash: hash, endpoints: make(map[string]ServiceEndpoint)}
+}
+
+func fnvHash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return mix64(h.Sum64())
+}
+
+// mix64 splitmix64 finalizer, fnv alone is poorly mixed for similar inputs
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (ring *Ring) less(a, b ringPoint) bool {
+	if a.hash != b.hash {
+		return a.hash < b.hash
+	}
+	return a.address < b.address
+}
+
+// Update set endpoints of the ring, only virtual nodes of added and removed addresses
+// are changed, so keys move only from or to them; returns the added and removed addresses
+func (ring *Ring) Update(endpoints []ServiceEndpoint) (added, removed []string) {
+	current := make(map[string]ServiceEndpoint, len(endpoints))
+	for _, endpoint := range endpoints {
+		current[endpoint.Address] = endpoint
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	for address := range ring.endpoints {
+		if _, ok := current[address]; !ok {
+			removed = append(removed, address)
+		}
+	}
+	var newPoints []ringPoint
+	for address := range current {
+		if _, ok := ring.endpoints[address]; ok {
+			continue
+		}
+		added = append(added, address)
+		for i := 0; i < ring.replicas; i++ {
+			newPoints = append(newPoints, ringPoint{
+				hash: ring.hash([]byte(address + "#" + strconv.Itoa(i))), address: address})
+		}
+	}
+	ring.endpoints = current
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	sort.Slice(newPoints, func(i, j int) bool { return ring.less(newPoints[i], newPoints[j]) })
+	points := make([]ringPoint, 0, len(ring.points)-len(removed)*ring.replicas+len(newPoints))
+	i := 0
+	for _, point := range ring.points {
+		if _, ok := current[point.address]; !ok {
+			continue
+		}
+		for ; i < len(newPoints) && ring.less(newPoints[i], point); i++ {
+			points = append(points, newPoints[i])
+		}
+		points = append(points, point)
+	}
+	ring.points = append(points, newPoints[i:]...)
+	return added, removed
+}
+
+// UpdateService update the ring with endpoints of zone of service, none if the zone is absent
+func (ring *Ring) UpdateService(service *Service, zone string) (added, removed []string) {
+	var endpoints []ServiceEndpoint
+	if service != nil {
+		if z := service.Zones[zone]; z != nil {
+			endpoints = z.Endpoints
+		}
+	}
+	return ring.Update(endpoints)
+}
+
+// Len number of endpoints
+func (ring *Ring) Len() int {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	return len(ring.endpoints)
+}
+
+// Get endpoint owning key, false if the ring is empty
+func (ring *Ring) Get(key string) (ServiceEndpoint, bool) {
+	endpoints := ring.GetN(key, 1)
+	if len(endpoints) == 0 {
+		return ServiceEndpoint{}, false
+	}
+	return endpoints[0], true
+}
+
+// GetN up to n distinct endpoints for key, the owner first then the following ones
+// clockwise, e.g. for replicas or failover
+func (ring *Ring) GetN(key string, n int) []ServiceEndpoint {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	if n > len(ring.endpoints) {
+		n = len(ring.endpoints)
+	}
+	if n <= 0 {
+		return nil
+	}
+	h := ring.hash([]byte(key))
+	start := sort.Search(len(ring.points), func(i int) bool { return ring.points[i].hash >= h })
+	result := make([]ServiceEndpoint, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(ring.points) && len(result) < n; i++ {
+		point := ring.points[(start+i)%len(ring.points)]
+		if !seen[point.address] {
+			seen[point.address] = true
+			result = append(result, ring.endpoints[point.address])
+		}
+	}
+	return result
+}
+
+// WatchRing keep ring updated with endpoints of zone of service until ctx done, see WatchLoop
+func (client *Client) WatchRing(ctx context.Context, service, zone string, ring *Ring,
+	retryInterval time.Duration, opts ...QueryOption) {
+	client.WatchLoop(ctx, service, retryInterval, func(s *Service) {
+		ring.UpdateService(s, zone)
+	}, opts...)
+}
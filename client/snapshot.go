@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SnapshotEntry last known service saved in a snapshot
+type SnapshotEntry struct {
+	Service  *Service  `json:"service"`
+	Revision int64     `json:"revision"`
+	SavedAt  time.Time `json:"saved_at"`
+}
+
+// Snapshot last known services persisted to a local file, loaded at cold
+// start when xbus is unreachable, see Config.Snapshot and QueryCached
+type Snapshot struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]*SnapshotEntry
+	// saved entries encoded at Put, written to the file in background
+	saved   map[string]json.RawMessage
+	changed bool
+	dirty   chan struct{}
+	writeMu sync.Mutex
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type snapshotFile struct {
+	Services map[string]json.RawMessage `json:"services"`
+}
+
+// OpenSnapshot open snapshot of path, empty if the file doesn't exist,
+// Close it to write the last changes
+func OpenSnapshot(path string) (*Snapshot, error) {
+	snapshot := &Snapshot{path: path, entries: make(map[string]*SnapshotEntry),
+		saved: make(map[string]json.RawMessage), dirty: make(chan struct{}, 1), closed: make(chan struct{})}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var file snapshotFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		for name, raw := range file.Services {
+			var entry SnapshotEntry
+			if err := json.Unmarshal(raw, &entry); err == nil && entry.Service != nil {
+				snapshot.entries[name] = &entry
+				snapshot.saved[name] = raw
+			}
+		}
+	}
+	go snapshot.run()
+	return snapshot, nil
+}
+
+// Get last known service queried as name, nil if not saved
+func (snapshot *Snapshot) Get(name string) *SnapshotEntry {
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+	return snapshot.entries[name]
+}
+
+// Put save service queried as name (e.g. an alias or a range of versions, not the
+// resolved service) at revision, the file is rewritten atomically in background
+func (snapshot *Snapshot) Put(name string, service *Service, revision int64, savedAt time.Time) error {
+	entry := &SnapshotEntry{Service: service, Revision: revision, SavedAt: savedAt}
+	snapshot.mu.Lock()
+	if old := snapshot.entries[name]; old != nil && old.Revision == revision {
+		snapshot.mu.Unlock()
+		return nil
+	}
+	snapshot.mu.Unlock()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	snapshot.mu.Lock()
+	snapshot.entries[name] = entry
+	snapshot.saved[name] = data
+	snapshot.changed = true
+	snapshot.mu.Unlock()
+	select {
+	case snapshot.dirty <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (snapshot *Snapshot) run() {
+	for {
+		select {
+		case <-snapshot.closed:
+			return
+		case <-snapshot.dirty:
+			// best effort, failed writes are retried by the next Put or Flush
+			snapshot.Flush()
+		}
+	}
+}
+
+// Flush write the file now if changed since the last write
+func (snapshot *Snapshot) Flush() error {
+	snapshot.writeMu.Lock()
+	defer snapshot.writeMu.Unlock()
+	snapshot.mu.Lock()
+	if !snapshot.changed {
+		snapshot.mu.Unlock()
+		return nil
+	}
+	services := make(map[string]json.RawMessage, len(snapshot.saved))
+	for name, raw := range snapshot.saved {
+		services[name] = raw
+	}
+	snapshot.changed = false
+	snapshot.mu.Unlock()
+	if err := snapshot.write(services); err != nil {
+		snapshot.mu.Lock()
+		snapshot.changed = true
+		snapshot.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (snapshot *Snapshot) write(services map[string]json.RawMessage) error {
+	data, err := json.Marshal(snapshotFile{Services: services})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(snapshot.path), filepath.Base(snapshot.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), snapshot.path)
+}
+
+// Close stop writing in background and write the last changes
+func (snapshot *Snapshot) Close() error {
+	snapshot.once.Do(func() { close(snapshot.closed) })
+	return snapshot.Flush()
+}
+
+// Staleness of a service loaded from snapshot, Err is why xbus wasn't queried
+type Staleness struct {
+	SavedAt  time.Time
+	Revision int64
+	Err      error
+}
+
+// Age how long ago the service was saved
+func (s *Staleness) Age(now time.Time) time.Duration {
+	return now.Sub(s.SavedAt)
+}
+
+// isUnavailable whether err means xbus is unreachable or unable to answer for now
+// (hinted retryable), rather than rejected the query
+func isUnavailable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code == EcodeSystemError || IsRetryable(e)
+	}
+	return true
+}
+
+func (client *Client) saveSnapshot(name string, service *Service, revision int64) {
+	if client.config.Snapshot != nil && service != nil {
+		// best effort, the snapshot is only a fallback
+		client.config.Snapshot.Put(name, service, revision, client.clock.Now())
+	}
+}
+
+// QueryCached query service, falling back to Config.Snapshot if xbus is unreachable,
+// staleness is nil for fresh services, callers decide whether to trust stale ones
+func (client *Client) QueryCached(ctx context.Context, service string, opts ...QueryOption) (*Service, int64, *Staleness, error) {
+	s, rev, err := client.Query(ctx, service, opts...)
+	if err == nil || client.config.Snapshot == nil || !isUnavailable(err) {
+		return s, rev, nil, err
+	}
+	entry := client.config.Snapshot.Get(service)
+	if entry == nil {
+		return nil, 0, nil, err
+	}
+	return applyQueryOptions(entry.Service, opts), entry.Revision,
+		&Staleness{SavedAt: entry.SavedAt, Revision: entry.Revision, Err: err}, nil
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xbus-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.json")
+	snapshot, err := OpenSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// saved by the queried alias, not the resolved version
+	service := &Service{Service: "payments.core:2.0", Zones: map[string]*ServiceZone{}}
+	savedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := snapshot.Put("payments.core:beta", service, 10, savedAt); err != nil {
+		t.Fatal(err)
+	}
+	if entry := snapshot.Get("payments.core:beta"); entry == nil || entry.Revision != 10 {
+		t.Fatalf("entry of alias: %+v", entry)
+	}
+	if snapshot.Get("payments.core:2.0") != nil {
+		t.Fatal("entry saved by the resolved service")
+	}
+	if err := snapshot.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	entry := reopened.Get("payments.core:beta")
+	if entry == nil || entry.Revision != 10 || entry.Service.Service != "payments.core:2.0" || !entry.SavedAt.Equal(savedAt) {
+		t.Fatalf("reopened entry: %+v", entry)
+	}
+}
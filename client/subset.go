@@ -0,0 +1,55 @@
+package client
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// Subset keep only size endpoints of each zone, picked deterministically by id (e.g. the
+// instance id of the client), applied after PreferZone. Clients with different ids pick
+// different subsets, spreading connections of large services evenly; as endpoints come and
+// go, only the picks involving them change (rendezvous hashing), so watches rebalance
+// without reshuffling every client
+func Subset(id string, size int) QueryOption {
+	return func(opts *queryOptions) {
+		opts.subsetID = id
+		opts.subsetSize = size
+	}
+}
+
+// subsetScore rendezvous score of address for id
+func subsetScore(id, address string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	h.Write([]byte{0})
+	h.Write([]byte(address))
+	return mix64(h.Sum64())
+}
+
+// subset size endpoints with the highest scores for id, in their original order
+func subset(endpoints []ServiceEndpoint, id string, size int) []ServiceEndpoint {
+	if len(endpoints) <= size {
+		return endpoints
+	}
+	type scored struct {
+		index int
+		score uint64
+	}
+	scores := make([]scored, len(endpoints))
+	for i, endpoint := range endpoints {
+		scores[i] = scored{index: i, score: subsetScore(id, endpoint.Address)}
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].score != scores[j].score {
+			return scores[i].score > scores[j].score
+		}
+		return endpoints[scores[i].index].Address < endpoints[scores[j].index].Address
+	})
+	picked := scores[:size]
+	sort.Slice(picked, func(i, j int) bool { return picked[i].index < picked[j].index })
+	result := make([]ServiceEndpoint, 0, size)
+	for _, item := range picked {
+		result = append(result, endpoints[item.index])
+	}
+	return result
+}
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+)
+
+// SyncState synced state of a service, see Syncer
+type SyncState struct {
+	Service  string `json:"service"`
+	Revision int64  `json:"revision,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// ZoneDelta changes of a service zone
+type ZoneDelta struct {
+	Zone    string            `json:"zone"`
+	Desc    *ServiceDesc      `json:"desc,omitempty"`
+	Upserts []ServiceEndpoint `json:"upserts,omitempty"`
+	Removes []string          `json:"removes,omitempty"`
+	Deleted bool              `json:"deleted,omitempty"`
+}
+
+// ServiceDelta changes of a service since the synced state
+type ServiceDelta struct {
+	Service  string      `json:"service"`
+	Checksum string      `json:"checksum,omitempty"`
+	NotFound bool        `json:"not_found,omitempty"`
+	Full     *Service    `json:"full,omitempty"`
+	Zones    []ZoneDelta `json:"zones,omitempty"`
+}
+
+// SyncResult sync result, unchanged services are omitted
+type SyncResult struct {
+	Revision int64          `json:"revision"`
+	Deltas   []ServiceDelta `json:"deltas"`
+}
+
+// Checksum checksum of service, same as the server's
+func Checksum(service *Service) string {
+	h := sha256.New()
+	zones := make([]string, 0, len(service.Zones))
+	for zone := range service.Zones {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	for _, zone := range zones {
+		z := service.Zones[zone]
+		h.Write([]byte("zone\x00" + zone + "\x00" + z.Type + "\x00" + z.Proto + "\x00" + z.Description + "\x00"))
+		endpoints := make([]ServiceEndpoint, len(z.Endpoints))
+		copy(endpoints, z.Endpoints)
+		sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Address < endpoints[j].Address })
+		for _, endpoint := range endpoints {
+			h.Write([]byte("endpoint\x00" + endpoint.Address + "\x00" + endpoint.Config + "\x00"))
+			h.Write(endpoint.Sealed)
+			h.Write([]byte{0})
+			if endpoint.InstanceID != "" {
+				h.Write([]byte("instance\x00" + endpoint.InstanceID + "\x00"))
+			}
+			if endpoint.Static {
+				h.Write([]byte("static\x00"))
+			}
+			if endpoint.Locality != "" {
+				h.Write([]byte("locality\x00" + endpoint.Locality + "\x00"))
+			}
+			if endpoint.Origin != "" {
+				h.Write([]byte("origin\x00" + endpoint.Origin + "\x00"))
+			}
+			if endpoint.Priority != 0 {
+				h.Write([]byte("priority\x00" + strconv.Itoa(endpoint.Priority) + "\x00"))
+			}
+			if endpoint.PlugTime != nil {
+				h.Write([]byte("plug_time\x00" + strconv.FormatInt(endpoint.PlugTime.Unix(), 10) + "\x00"))
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ApplyDelta apply zone deltas to a copy of service
+func ApplyDelta(service *Service, deltas []ZoneDelta) *Service {
+	result := &Service{Service: service.Service, Zones: make(map[string]*ServiceZone, len(service.Zones))}
+	for zone, z := range service.Zones {
+		copied := *z
+		copied.Endpoints = append([]ServiceEndpoint(nil), z.Endpoints...)
+		result.Zones[zone] = &copied
+	}
+	for _, delta := range deltas {
+		if delta.Deleted {
+			delete(result.Zones, delta.Zone)
+			continue
+		}
+		z := result.Zones[delta.Zone]
+		if z == nil {
+			z = &ServiceZone{Endpoints: []ServiceEndpoint{}}
+			result.Zones[delta.Zone] = z
+		}
+		if delta.Desc != nil {
+			z.ServiceDesc = *delta.Desc
+		}
+		changed := make(map[string]bool, len(delta.Upserts)+len(delta.Removes))
+		for _, addr := range delta.Removes {
+			changed[addr] = true
+		}
+		for _, endpoint := range delta.Upserts {
+			changed[endpoint.Address] = true
+		}
+		endpoints := z.Endpoints[:0]
+		for _, endpoint := range z.Endpoints {
+			if !changed[endpoint.Address] {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		z.Endpoints = append(endpoints, delta.Upserts...)
+	}
+	return result
+}
+
+// Syncer keeps a set of services in sync with few bytes after reconnects,
+// only changed services are transferred, as deltas when possible
+type Syncer struct {
+	client   *Client
+	services map[string]*Service
+	states   map[string]SyncState
+}
+
+// NewSyncer new syncer of services
+func (client *Client) NewSyncer(services ...string) *Syncer {
+	syncer := &Syncer{client: client, services: make(map[string]*Service), states: make(map[string]SyncState)}
+	for _, service := range services {
+		syncer.states[service] = SyncState{Service: service}
+	}
+	return syncer
+}
+
+// Service synced service, nil if not found
+func (syncer *Syncer) Service(name string) *Service {
+	return syncer.services[name]
+}
+
+// Sync fetch changes, returns names of changed services
+func (syncer *Syncer) Sync(ctx context.Context) ([]string, error) {
+	states := make([]SyncState, 0, len(syncer.states))
+	for _, state := range syncer.states {
+		states = append(states, state)
+	}
+	ctx, cancel := context.WithTimeout(ctx, syncer.client.config.Timeout)
+	defer cancel()
+	result, err := syncer.client.transport.Sync(ctx, states)
+	if err != nil {
+		return nil, err
+	}
+	changed := make([]string, 0, len(result.Deltas))
+	for _, delta := range result.Deltas {
+		switch {
+		case delta.NotFound:
+			delete(syncer.services, delta.Service)
+		case delta.Full != nil:
+			syncer.services[delta.Service] = delta.Full
+		default:
+			old := syncer.services[delta.Service]
+			if old == nil {
+				old = &Service{Service: delta.Service}
+			}
+			syncer.services[delta.Service] = ApplyDelta(old, delta.Zones)
+		}
+		changed = append(changed, delta.Service)
+	}
+	for name := range syncer.states {
+		state := SyncState{Service: name, Revision: result.Revision}
+		if service := syncer.services[name]; service != nil {
+			state.Checksum = Checksum(service)
+		}
+		syncer.states[name] = state
+	}
+	return changed, nil
+}
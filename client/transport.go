@@ -0,0 +1,559 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport xbus api transport, replaceable in tests
+type Transport interface {
+	PlugAll(ctx context.Context, ttl time.Duration, leaseID int64, descs []ServiceDesc, endpoint *ServiceEndpoint) (*PlugResult, error)
+	Unplug(ctx context.Context, service, zone, addr string) error
+	GetEndpoint(ctx context.Context, service, zone, addr string) (*EndpointRevision, error)
+	// UpdateIfVersion update endpoint if unchanged since modRevision, returns the new mod revision
+	UpdateIfVersion(ctx context.Context, service, zone, addr string, modRevision int64, endpoint *ServiceEndpoint) (int64, error)
+	// PatchEndpoint update only fields in patch of endpoint, if unchanged since modRevision when not 0
+	PatchEndpoint(ctx context.Context, service, zone, addr string, modRevision int64, patch map[string]interface{}) (*EndpointRevision, error)
+	Query(ctx context.Context, service string) (*QueryResult, error)
+	// QueryAt query service as it was at revision
+	QueryAt(ctx context.Context, service string, revision int64) (*QueryResult, error)
+	QueryMulti(ctx context.Context, refs []ServiceRef) (*MultiQueryResult, error)
+	// Count endpoints count of service in zone, all zones if empty
+	Count(ctx context.Context, service, zone string) (*CountResult, error)
+	// QueryVersion query the highest version of name with endpoints in versionRange
+	QueryVersion(ctx context.Context, name, versionRange string) (*QueryResult, error)
+	Search(ctx context.Context, q string, skip, limit int64) (*SearchResult, error)
+	Sync(ctx context.Context, states []SyncState) (*SyncResult, error)
+	Watch(ctx context.Context, service string, revision int64, timeout time.Duration) (*QueryResult, error)
+	// WatchMulti watch services of refs, or named with prefix, in one request
+	WatchMulti(ctx context.Context, refs []ServiceRef, prefix string, revision int64, timeout time.Duration) (*MultiQueryResult, error)
+	KeepAlive(ctx context.Context, leaseID int64) error
+	// KeepAliveStream keep lease alive server side until ctx done or the stream ends,
+	// fn is called with every event
+	KeepAliveStream(ctx context.Context, leaseID int64, fn func(LeaseEvent)) error
+	Revoke(ctx context.Context, leaseID int64) error
+	// Lock wait up to timeout for the lock of name, held with a new lease of ttl
+	Lock(ctx context.Context, name string, ttl, timeout time.Duration) (*LockResult, error)
+	// Unlock release the lock of name and revoke its lease
+	Unlock(ctx context.Context, name string, leaseID int64) error
+	// Campaign wait up to timeout to be elected as leader of name, with a new lease of ttl
+	Campaign(ctx context.Context, name, value string, ttl, timeout time.Duration) (*Leader, error)
+	// Resign resign the leadership of name and revoke its lease
+	Resign(ctx context.Context, name string, leaseID int64) error
+	GetLeader(ctx context.Context, name string) (*LeaderResult, error)
+	// ObserveLeader wait up to timeout until the leader of name is not the one of leaderRevision
+	ObserveLeader(ctx context.Context, name string, leaderRevision int64, timeout time.Duration) (*LeaderResult, error)
+	// GetSchema schema of kind of service at revision, the latest if revision is 0
+	GetSchema(ctx context.Context, service, kind string, revision int64) (*Schema, error)
+	GetMetadata(ctx context.Context, name string) (*ServiceMetadata, error)
+	GetClientPolicy(ctx context.Context, service string) (*ClientPolicyResult, error)
+	// WatchClientPolicy wait up to timeout for the change of the client policy of service since revision
+	WatchClientPolicy(ctx context.Context, service string, revision int64, timeout time.Duration) (*ClientPolicyResult, error)
+	// DeclareDependencies replace the services the app consumes
+	DeclareDependencies(ctx context.Context, services []string) error
+	// History changes of name:version (all versions if version is empty) since
+	History(ctx context.Context, name, version string, since time.Time) ([]HistoryEntry, error)
+	// FlappingEndpoints endpoints of service flagged as flapping
+	FlappingEndpoints(ctx context.Context, service string) ([]FlappingEndpoint, error)
+}
+
+// HTTPTransport http api transport
+type HTTPTransport struct {
+	endpoint string
+	client   *http.Client
+	header   http.Header
+}
+
+// NewHTTPTransport new http transport, timeouts are controlled by ctx
+func NewHTTPTransport(endpoint string, tlsConfig *tls.Config) *HTTPTransport {
+	return &HTTPTransport{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		header: make(http.Header),
+	}
+}
+
+// SetHeader set header sent with every request, e.g. Dev-App
+func (t *HTTPTransport) SetHeader(key, value string) {
+	t.header.Set(key, value)
+}
+
+type response struct {
+	Ok     bool            `json:"ok"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// RequestIDHeader request id header, sent if set by WithRequestID and returned by xbus
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// WithRequestID ctx sending id as the request id of api calls, so they can be
+// correlated with server logs; xbus generates one otherwise, see Error.RequestID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func (t *HTTPTransport) do(ctx context.Context, method, path string, query, form url.Values, result interface{}) error {
+	u := t.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body *strings.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	} else {
+		body = strings.NewReader("")
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range t.header {
+		req.Header[k] = v
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var r response
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("invalid response(status: %d): %v", resp.StatusCode, err)
+	}
+	if !r.Ok {
+		if r.Error == nil {
+			r.Error = &Error{Code: EcodeSystemError, Message: "missing error"}
+		}
+		r.Error.RequestID = resp.Header.Get(RequestIDHeader)
+		return r.Error
+	}
+	if result != nil && len(r.Result) > 0 {
+		return json.Unmarshal(r.Result, result)
+	}
+	return nil
+}
+
+// PlugAll impl Transport
+func (t *HTTPTransport) PlugAll(ctx context.Context, ttl time.Duration, leaseID int64,
+	descs []ServiceDesc, endpoint *ServiceEndpoint) (*PlugResult, error) {
+	descsData, err := json.Marshal(descs)
+	if err != nil {
+		return nil, err
+	}
+	endpointData, err := json.Marshal(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("ttl", strconv.FormatInt(int64(ttl/time.Second), 10))
+	if leaseID != 0 {
+		form.Set("lease_id", strconv.FormatInt(leaseID, 10))
+	}
+	form.Set("descs", string(descsData))
+	form.Set("endpoint", string(endpointData))
+	var result PlugResult
+	if err := t.do(ctx, http.MethodPost, "/api/v1/services", nil, form, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Unplug impl Transport
+func (t *HTTPTransport) Unplug(ctx context.Context, service, zone, addr string) error {
+	return t.do(ctx, http.MethodDelete, endpointPath(service, zone, addr), nil, nil, nil)
+}
+
+func endpointPath(service, zone, addr string) string {
+	return fmt.Sprintf("/api/v1/services/%s/%s/%s",
+		url.PathEscape(service), url.PathEscape(zone), url.PathEscape(addr))
+}
+
+// GetEndpoint impl Transport
+func (t *HTTPTransport) GetEndpoint(ctx context.Context, service, zone, addr string) (*EndpointRevision, error) {
+	var result EndpointRevision
+	if err := t.do(ctx, http.MethodGet, endpointPath(service, zone, addr), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UpdateIfVersion impl Transport
+func (t *HTTPTransport) UpdateIfVersion(ctx context.Context, service, zone, addr string,
+	modRevision int64, endpoint *ServiceEndpoint) (int64, error) {
+	endpointData, err := json.Marshal(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	form := url.Values{}
+	form.Set("mod_revision", strconv.FormatInt(modRevision, 10))
+	form.Set("endpoint", string(endpointData))
+	var result struct {
+		ModRevision int64 `json:"mod_revision"`
+	}
+	if err := t.do(ctx, http.MethodPut, endpointPath(service, zone, addr), nil, form, &result); err != nil {
+		return 0, err
+	}
+	return result.ModRevision, nil
+}
+
+// PatchEndpoint impl Transport
+func (t *HTTPTransport) PatchEndpoint(ctx context.Context, service, zone, addr string,
+	modRevision int64, patch map[string]interface{}) (*EndpointRevision, error) {
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	if modRevision != 0 {
+		form.Set("mod_revision", strconv.FormatInt(modRevision, 10))
+	}
+	form.Set("patch", string(patchData))
+	var result EndpointRevision
+	if err := t.do(ctx, http.MethodPatch, endpointPath(service, zone, addr), nil, form, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Query impl Transport
+func (t *HTTPTransport) Query(ctx context.Context, service string) (*QueryResult, error) {
+	var result QueryResult
+	if err := t.do(ctx, http.MethodGet, "/api/v1/services/"+url.PathEscape(service), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// QueryAt impl Transport
+func (t *HTTPTransport) QueryAt(ctx context.Context, service string, revision int64) (*QueryResult, error) {
+	query := url.Values{}
+	query.Set("revision", strconv.FormatInt(revision, 10))
+	var result QueryResult
+	if err := t.do(ctx, http.MethodGet, "/api/v1/services/"+url.PathEscape(service), query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// QueryVersion impl Transport
+func (t *HTTPTransport) QueryVersion(ctx context.Context, name, versionRange string) (*QueryResult, error) {
+	query := url.Values{}
+	query.Set("version", versionRange)
+	var result QueryResult
+	if err := t.do(ctx, http.MethodGet, "/api/v1/service-versions/"+url.PathEscape(name), query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Count impl Transport
+func (t *HTTPTransport) Count(ctx context.Context, service, zone string) (*CountResult, error) {
+	query := url.Values{}
+	if zone != "" {
+		query.Set("zone", zone)
+	}
+	var result CountResult
+	if err := t.do(ctx, http.MethodGet, "/api/v1/service-counts/"+url.PathEscape(service), query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// QueryMulti impl Transport
+func (t *HTTPTransport) QueryMulti(ctx context.Context, refs []ServiceRef) (*MultiQueryResult, error) {
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("services", string(data))
+	var result MultiQueryResult
+	if err := t.do(ctx, http.MethodPost, "/api/v1/service-query", nil, form, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Search impl Transport
+func (t *HTTPTransport) Search(ctx context.Context, q string, skip, limit int64) (*SearchResult, error) {
+	query := url.Values{}
+	query.Set("q", q)
+	query.Set("skip", strconv.FormatInt(skip, 10))
+	query.Set("limit", strconv.FormatInt(limit, 10))
+	var result SearchResult
+	if err := t.do(ctx, http.MethodGet, "/api/v1/services", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Sync impl Transport
+func (t *HTTPTransport) Sync(ctx context.Context, states []SyncState) (*SyncResult, error) {
+	data, err := json.Marshal(states)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("states", string(data))
+	var result SyncResult
+	if err := t.do(ctx, http.MethodPost, "/api/v1/service-sync", nil, form, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Watch impl Transport
+func (t *HTTPTransport) Watch(ctx context.Context, service string, revision int64, timeout time.Duration) (*QueryResult, error) {
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("revision", strconv.FormatInt(revision, 10))
+	query.Set("timeout", strconv.FormatInt(int64(timeout/time.Second), 10))
+	var result QueryResult
+	if err := t.do(ctx, http.MethodGet, "/api/v1/services/"+url.PathEscape(service), query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// WatchMulti impl Transport
+func (t *HTTPTransport) WatchMulti(ctx context.Context, refs []ServiceRef, prefix string, revision int64, timeout time.Duration) (*MultiQueryResult, error) {
+	form := url.Values{}
+	if len(refs) > 0 {
+		data, err := json.Marshal(refs)
+		if err != nil {
+			return nil, err
+		}
+		form.Set("services", string(data))
+	}
+	if prefix != "" {
+		form.Set("prefix", prefix)
+	}
+	form.Set("revision", strconv.FormatInt(revision, 10))
+	form.Set("timeout", strconv.FormatInt(int64(timeout/time.Second), 10))
+	var result MultiQueryResult
+	if err := t.do(ctx, http.MethodPost, "/api/v1/service-watch", nil, form, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// KeepAlive impl Transport
+func (t *HTTPTransport) KeepAlive(ctx context.Context, leaseID int64) error {
+	return t.do(ctx, http.MethodPost, "/api/leases/"+strconv.FormatInt(leaseID, 10), nil, url.Values{}, nil)
+}
+
+// KeepAliveStream impl Transport
+func (t *HTTPTransport) KeepAliveStream(ctx context.Context, leaseID int64, fn func(LeaseEvent)) error {
+	req, err := http.NewRequest(http.MethodGet, t.endpoint+"/api/leases/"+strconv.FormatInt(leaseID, 10)+"/keepalive", nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range t.header {
+		req.Header[k] = v
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/x-ndjson") {
+		// errors are normal json responses
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		var r response
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("invalid response(status: %d): %v", resp.StatusCode, err)
+		}
+		if r.Error == nil {
+			return &Error{Code: EcodeSystemError, Message: "missing error"}
+		}
+		return r.Error
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event LeaseEvent
+		if err := decoder.Decode(&event); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fn(event)
+	}
+}
+
+// Revoke impl Transport
+func (t *HTTPTransport) Revoke(ctx context.Context, leaseID int64) error {
+	return t.do(ctx, http.MethodDelete, "/api/leases/"+strconv.FormatInt(leaseID, 10), nil, nil, nil)
+}
+
+// Lock impl Transport
+func (t *HTTPTransport) Lock(ctx context.Context, name string, ttl, timeout time.Duration) (*LockResult, error) {
+	form := url.Values{}
+	form.Set("ttl", strconv.FormatInt(int64(ttl/time.Second), 10))
+	form.Set("timeout", strconv.FormatInt(int64(timeout/time.Second), 10))
+	var result LockResult
+	if err := t.do(ctx, http.MethodPost, "/api/locks/"+url.PathEscape(name), nil, form, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Unlock impl Transport
+func (t *HTTPTransport) Unlock(ctx context.Context, name string, leaseID int64) error {
+	return t.do(ctx, http.MethodDelete, "/api/locks/"+url.PathEscape(name)+"/"+strconv.FormatInt(leaseID, 10), nil, nil, nil)
+}
+
+// Campaign impl Transport
+func (t *HTTPTransport) Campaign(ctx context.Context, name, value string, ttl, timeout time.Duration) (*Leader, error) {
+	form := url.Values{}
+	form.Set("value", value)
+	form.Set("ttl", strconv.FormatInt(int64(ttl/time.Second), 10))
+	form.Set("timeout", strconv.FormatInt(int64(timeout/time.Second), 10))
+	var result Leader
+	if err := t.do(ctx, http.MethodPost, "/api/elections/"+url.PathEscape(name), nil, form, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Resign impl Transport
+func (t *HTTPTransport) Resign(ctx context.Context, name string, leaseID int64) error {
+	return t.do(ctx, http.MethodDelete, "/api/elections/"+url.PathEscape(name)+"/"+strconv.FormatInt(leaseID, 10), nil, nil, nil)
+}
+
+// GetLeader impl Transport
+func (t *HTTPTransport) GetLeader(ctx context.Context, name string) (*LeaderResult, error) {
+	var result LeaderResult
+	if err := t.do(ctx, http.MethodGet, "/api/elections/"+url.PathEscape(name), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ObserveLeader impl Transport
+func (t *HTTPTransport) ObserveLeader(ctx context.Context, name string, leaderRevision int64, timeout time.Duration) (*LeaderResult, error) {
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("leader_revision", strconv.FormatInt(leaderRevision, 10))
+	query.Set("timeout", strconv.FormatInt(int64(timeout/time.Second), 10))
+	var result LeaderResult
+	if err := t.do(ctx, http.MethodGet, "/api/elections/"+url.PathEscape(name), query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetSchema impl Transport
+func (t *HTTPTransport) GetSchema(ctx context.Context, service, kind string, revision int64) (*Schema, error) {
+	query := url.Values{}
+	if revision > 0 {
+		query.Set("revision", strconv.FormatInt(revision, 10))
+	}
+	var result Schema
+	if err := t.do(ctx, http.MethodGet, "/api/v1/service-schemas/"+url.PathEscape(service)+"/"+url.PathEscape(kind), query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetMetadata impl Transport
+func (t *HTTPTransport) GetMetadata(ctx context.Context, name string) (*ServiceMetadata, error) {
+	var result ServiceMetadata
+	if err := t.do(ctx, http.MethodGet, "/api/v1/service-metadata/"+url.PathEscape(name), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetClientPolicy impl Transport
+func (t *HTTPTransport) GetClientPolicy(ctx context.Context, service string) (*ClientPolicyResult, error) {
+	var result ClientPolicyResult
+	if err := t.do(ctx, http.MethodGet, "/api/v1/client-policies/"+url.PathEscape(service), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// WatchClientPolicy impl Transport
+func (t *HTTPTransport) WatchClientPolicy(ctx context.Context, service string, revision int64, timeout time.Duration) (*ClientPolicyResult, error) {
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("revision", strconv.FormatInt(revision, 10))
+	query.Set("timeout", strconv.FormatInt(int64(timeout/time.Second), 10))
+	var result ClientPolicyResult
+	if err := t.do(ctx, http.MethodGet, "/api/v1/client-policies/"+url.PathEscape(service), query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DeclareDependencies impl Transport
+func (t *HTTPTransport) DeclareDependencies(ctx context.Context, services []string) error {
+	if services == nil {
+		services = []string{}
+	}
+	data, err := json.Marshal(services)
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	form.Set("services", string(data))
+	return t.do(ctx, http.MethodPut, "/api/v1/dependencies", nil, form, nil)
+}
+
+// FlappingEndpoints impl Transport
+func (t *HTTPTransport) FlappingEndpoints(ctx context.Context, service string) ([]FlappingEndpoint, error) {
+	var result []FlappingEndpoint
+	if err := t.do(ctx, http.MethodGet, "/api/v1/flapping-endpoints/"+url.PathEscape(service), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// History impl Transport
+func (t *HTTPTransport) History(ctx context.Context, name, version string, since time.Time) ([]HistoryEntry, error) {
+	query := url.Values{}
+	if version != "" {
+		query.Set("version", version)
+	}
+	if !since.IsZero() {
+		query.Set("since", strconv.FormatInt(since.Unix(), 10))
+	}
+	var result []HistoryEntry
+	if err := t.do(ctx, http.MethodGet, "/api/v1/service-history/"+url.PathEscape(name), query, nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
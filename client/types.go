@@ -0,0 +1,315 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// EcodeNotFound NOT_FOUND
+	EcodeNotFound = "NOT_FOUND"
+	// EcodeDeadlineExceeded DEADLINE_EXCEEDED
+	EcodeDeadlineExceeded = "DEADLINE_EXCEEDED"
+	// EcodeCanceled CANCELED
+	EcodeCanceled = "CANCELED"
+	// EcodeRevisionCompacted REVISION_COMPACTED
+	EcodeRevisionCompacted = "REVISION_COMPACTED"
+	// EcodeSystemError SYSTEM_ERROR
+	EcodeSystemError = "SYSTEM_ERROR"
+	// EcodeInvalidParam INVALID_PARAM
+	EcodeInvalidParam = "INVALID_PARAM"
+	// EcodeQuotaExceeded QUOTA_EXCEEDED, e.g. namespace quotas
+	EcodeQuotaExceeded = "QUOTA_EXCEEDED"
+	// EcodeRateLimited RATE_LIMITED, with http status 429
+	EcodeRateLimited = "RATE_LIMITED"
+	// EcodeEndpointChanged ENDPOINT_CHANGED, endpoint changed since the expected mod revision
+	EcodeEndpointChanged = "ENDPOINT_CHANGED"
+	// EcodeServerStopping SERVER_STOPPING, the server is shutting down, retry another one
+	EcodeServerStopping = "SERVER_STOPPING"
+	// EcodeInMaintenance IN_MAINTENANCE, plugs into services in maintenance are blocked
+	EcodeInMaintenance = "IN_MAINTENANCE"
+	// EcodeInvalidAddress INVALID_ADDRESS, plugged address rejected by address validation
+	EcodeInvalidAddress = "INVALID_ADDRESS"
+	// EcodeUnresolvableAddress UNRESOLVABLE_ADDRESS, host of the plugged address doesn't resolve
+	EcodeUnresolvableAddress = "UNRESOLVABLE_ADDRESS"
+	// EcodeUnreachableAddress UNREACHABLE_ADDRESS, the plugged address doesn't accept connections
+	EcodeUnreachableAddress = "UNREACHABLE_ADDRESS"
+	// EcodeEtcdWatchFailed ETCD_WATCH_FAILED, also config watches timed out without changes
+	EcodeEtcdWatchFailed = "ETCD_WATCH_FAILED"
+)
+
+// ServiceStatusMaintenance QueryResult.Status of services in maintenance
+const ServiceStatusMaintenance = "maintenance"
+
+// Error xbus api error
+//
+// Revision is set by failed watches: the last revision observed,
+// watch again with Revision+1 to resume
+type Error struct {
+	Code     string   `json:"code"`
+	Message  string   `json:"message,omitempty"`
+	Keys     []string `json:"keys,omitempty"`
+	Revision int64    `json:"revision,omitempty"`
+	// Retryable hint of the server the same request may succeed later
+	Retryable bool `json:"retryable,omitempty"`
+	// RequestID id of the failed request in server logs
+	RequestID string `json:"-"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Code
+	if e.Message != "" {
+		msg = fmt.Sprintf("[%s]: %s", e.Code, e.Message)
+	}
+	if e.RequestID != "" {
+		msg += " (request: " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// IsRetryable whether err is or wraps an api error the server hints retryable
+func IsRetryable(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Retryable
+}
+
+// IsErrCode check code of err, or the api error it wraps
+func IsErrCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// ServiceDesc service descriptor
+type ServiceDesc struct {
+	Service     string `json:"service"`
+	Zone        string `json:"zone,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Proto       string `json:"proto,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// ServiceEndpoint service endpoint, Locality is where it runs, e.g. us-east-1a,
+// unrelated to service zones, see PreferZone, Origin is the cluster of mirrored endpoints,
+// Priority the failover tier, 0 (primary) first, see ByPriority, PlugTime when it's
+// plugged (set by xbus), see SlowStart
+type ServiceEndpoint struct {
+	Address    string          `json:"address"`
+	Config     string          `json:"config,omitempty"`
+	Sealed     json.RawMessage `json:"sealed,omitempty"`
+	InstanceID string          `json:"instance_id,omitempty"`
+	Static     bool            `json:"static,omitempty"`
+	Locality   string          `json:"locality,omitempty"`
+	Origin     string          `json:"origin,omitempty"`
+	Priority   int             `json:"priority,omitempty"`
+	PlugTime   *time.Time      `json:"plug_time,omitempty"`
+}
+
+// ServiceZone service zone
+type ServiceZone struct {
+	Endpoints []ServiceEndpoint `json:"endpoints"`
+
+	ServiceDesc
+}
+
+// Service service
+type Service struct {
+	Service string                  `json:"service"`
+	Zones   map[string]*ServiceZone `json:"zones"`
+}
+
+// EndpointRevision endpoint with the mod revision of its key (see Client.UpdateIfVersion),
+// TTL is the remaining ttl of its lease in seconds, 0 for static endpoints
+type EndpointRevision struct {
+	Endpoint    ServiceEndpoint `json:"endpoint"`
+	ModRevision int64           `json:"mod_revision"`
+	LeaseID     int64           `json:"lease_id"`
+	TTL         int64           `json:"ttl,omitempty"`
+}
+
+// CountResult endpoints count of a service
+type CountResult struct {
+	Count    int64 `json:"count"`
+	Revision int64 `json:"revision"`
+}
+
+// PlugResult plug result
+type PlugResult struct {
+	LeaseID int64 `json:"lease_id"`
+	TTL     int64 `json:"ttl"`
+}
+
+// LockResult a held lock
+type LockResult struct {
+	Name    string `json:"name"`
+	LeaseID int64  `json:"lease_id"`
+	// Revision increasing with holders of the lock, usable as a fencing token
+	Revision int64 `json:"revision"`
+	TTL      int64 `json:"ttl"`
+}
+
+// Leader leader of an election
+type Leader struct {
+	Name string `json:"name"`
+	// App name of the app elected
+	App     string `json:"app"`
+	Value   string `json:"value"`
+	LeaseID int64  `json:"lease_id"`
+	// Revision increasing with leaders of the election
+	Revision int64 `json:"revision"`
+	// TTL lease ttl, of campaign results only
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// LeaderResult current leader, nil if none
+type LeaderResult struct {
+	Leader   *Leader `json:"leader"`
+	Revision int64   `json:"revision"`
+}
+
+// schema kinds
+const (
+	// SchemaProtobuf base64 encoded protobuf FileDescriptorSet
+	SchemaProtobuf = "protobuf"
+	// SchemaThrift thrift idl
+	SchemaThrift = "thrift"
+	// SchemaOpenAPI openapi document
+	SchemaOpenAPI = "openapi"
+)
+
+// Schema a revision of the interface descriptor of a service
+type Schema struct {
+	Service  string `json:"service"`
+	Kind     string `json:"kind"`
+	Revision int64  `json:"revision"`
+	Content  string `json:"content,omitempty"`
+	Checksum string `json:"checksum"`
+}
+
+// MetadataLink link of service metadata
+type MetadataLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// ServiceMetadata catalog metadata of a service name
+type ServiceMetadata struct {
+	Name        string         `json:"name"`
+	Owner       string         `json:"owner"`
+	Description string         `json:"description"`
+	Oncall      string         `json:"oncall"`
+	Links       []MetadataLink `json:"links"`
+}
+
+// ClientPolicy traffic policy of clients of a service stored in xbus, zero fields (nil Retries)
+// are left to client configs; see Client.WatchPolicy
+type ClientPolicy struct {
+	Service           string `json:"service"`
+	TimeoutMs         int64  `json:"timeout_ms,omitempty"`
+	Retries           *int   `json:"retries,omitempty"`
+	RetryBudget       int    `json:"retry_budget,omitempty"`
+	EjectAfter        int    `json:"eject_after,omitempty"`
+	EjectionMs        int64  `json:"ejection_ms,omitempty"`
+	MaxEjectedPercent int    `json:"max_ejected_percent,omitempty"`
+	Revision          int64  `json:"revision"`
+}
+
+// ClientPolicyResult client policy of a service, nil if not set
+type ClientPolicyResult struct {
+	Policy   *ClientPolicy `json:"policy"`
+	Revision int64         `json:"revision"`
+}
+
+// HistoryEntry a change of a service, Endpoint is the one after plug / update,
+// Prev the one before update / unplug
+type HistoryEntry struct {
+	Revision   int64            `json:"revision"`
+	Time       time.Time        `json:"time"`
+	Type       string           `json:"type"`
+	Service    string           `json:"service"`
+	Zone       string           `json:"zone"`
+	Address    string           `json:"address,omitempty"`
+	LeaseID    int64            `json:"lease_id,omitempty"`
+	InstanceID string           `json:"instance_id,omitempty"`
+	Endpoint   *ServiceEndpoint `json:"endpoint,omitempty"`
+	Prev       *ServiceEndpoint `json:"prev,omitempty"`
+}
+
+// FlappingEndpoint endpoint plugged / unplugged Changes times since Since, as seen by the
+// xbus server serving the request
+type FlappingEndpoint struct {
+	Service    string    `json:"service"`
+	Zone       string    `json:"zone"`
+	Address    string    `json:"address"`
+	Since      time.Time `json:"since"`
+	LastChange time.Time `json:"last_change"`
+	Changes    int       `json:"changes"`
+}
+
+// LeaseEvent event of lease keepalive stream
+type LeaseEvent struct {
+	Type    string `json:"type"`
+	LeaseID int64  `json:"lease_id"`
+	TTL     int64  `json:"ttl,omitempty"`
+}
+
+// lease event types
+const (
+	// LeaseEventKeepAlive lease refreshed, TTL is the new ttl
+	LeaseEventKeepAlive = "keepalive"
+	// LeaseEventExpired lease expired or revoked, the stream ends
+	LeaseEventExpired = "expired"
+	// LeaseEventClosed server shutting down, the stream ends with the lease alive
+	LeaseEventClosed = "closed"
+)
+
+// QueryResult query result
+type QueryResult struct {
+	Service  *Service `json:"service"`
+	Revision int64    `json:"revision"`
+	// Status ServiceStatusMaintenance with Maintenance set if in maintenance, empty if not
+	Status      string       `json:"status,omitempty"`
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+}
+
+// Maintenance planned downtime of a service, set by admins
+type Maintenance struct {
+	Service   string    `json:"service"`
+	Message   string    `json:"message"`
+	BlockPlug bool      `json:"block_plug"`
+	Operator  string    `json:"operator"`
+	Since     time.Time `json:"since"`
+}
+
+// ServiceRef reference of a service, optionally limited to a zone
+type ServiceRef struct {
+	Service string `json:"service"`
+	Zone    string `json:"zone,omitempty"`
+}
+
+// MultiQueryItem query result of a service ref
+type MultiQueryItem struct {
+	Ref      ServiceRef `json:"ref"`
+	NotFound bool       `json:"not_found,omitempty"`
+	Service  *Service   `json:"service,omitempty"`
+}
+
+// MultiQueryResult services of refs at one revision, in refs order
+type MultiQueryResult struct {
+	Revision int64            `json:"revision"`
+	Services []MultiQueryItem `json:"services"`
+}
+
+// ServiceItem service search item
+type ServiceItem struct {
+	Service string `json:"service"`
+	Zone    string `json:"zone"`
+	Type    string `json:"type"`
+}
+
+// SearchResult service search result
+type SearchResult struct {
+	Services []ServiceItem `json:"services"`
+	Total    int64         `json:"total"`
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VersionLatest version range matching every version, resolved to the highest
+const VersionLatest = "latest"
+
+// CompareVersions compare dot separated versions, numeric parts compare numerically,
+// the same ordering as the server's
+func CompareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseInt(as[i], 10, 64)
+		bn, berr := strconv.ParseInt(bs[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aerr == nil:
+			return 1
+		case berr == nil:
+			return -1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(as) - len(bs)
+}
+
+type versionConstraint struct {
+	op      string
+	version string
+}
+
+// VersionRange space separated constraints, e.g. `>=1.2 <2.0`, or latest
+type VersionRange struct {
+	constraints []versionConstraint
+}
+
+var versionOps = []string{">=", "<=", "==", ">", "<", "="}
+
+// ParseVersionRange parse version range
+func ParseVersionRange(s string) (*VersionRange, error) {
+	s = strings.TrimSpace(s)
+	if s == VersionLatest || s == "*" {
+		return &VersionRange{}, nil
+	}
+	r := &VersionRange{}
+	for _, part := range strings.Fields(strings.Replace(s, ",", " ", -1)) {
+		c := versionConstraint{op: "=", version: part}
+		for _, op := range versionOps {
+			if strings.HasPrefix(part, op) {
+				c.op, c.version = op, strings.TrimPrefix(part, op)
+				break
+			}
+		}
+		if c.version == "" {
+			return nil, fmt.Errorf("invalid version range: %s", s)
+		}
+		if c.op == "==" {
+			c.op = "="
+		}
+		r.constraints = append(r.constraints, c)
+	}
+	if len(r.constraints) == 0 {
+		return nil, fmt.Errorf("invalid version range: %s", s)
+	}
+	return r, nil
+}
+
+// Match whether version is in range
+func (r *VersionRange) Match(version string) bool {
+	for _, c := range r.constraints {
+		n := CompareVersions(version, c.version)
+		switch c.op {
+		case "=":
+			if n != 0 {
+				return false
+			}
+		case ">":
+			if n <= 0 {
+				return false
+			}
+		case ">=":
+			if n < 0 {
+				return false
+			}
+		case "<":
+			if n >= 0 {
+				return false
+			}
+		case "<=":
+			if n > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package xbustest
+
+import (
+	"sync"
+	"time"
+)
+
+type timer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// FakeClock manually advanced clock
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*timer
+	added  chan struct{}
+}
+
+// NewFakeClock new fake clock starting at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, added: make(chan struct{}, 1)}
+}
+
+// Now impl client.Clock
+func (clock *FakeClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+// After impl client.Clock, fired by Advance
+func (clock *FakeClock) After(d time.Duration) <-chan time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	t := &timer{at: clock.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- clock.now
+		return t.ch
+	}
+	clock.timers = append(clock.timers, t)
+	select {
+	case clock.added <- struct{}{}:
+	default:
+	}
+	return t.ch
+}
+
+// Advance move clock forward and fire expired timers
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.now = clock.now.Add(d)
+	timers := clock.timers[:0]
+	for _, t := range clock.timers {
+		if !t.at.After(clock.now) {
+			t.ch <- clock.now
+		} else {
+			timers = append(timers, t)
+		}
+	}
+	clock.timers = timers
+}
+
+// Pending number of timers not fired yet
+func (clock *FakeClock) Pending() int {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return len(clock.timers)
+}
+
+// BlockUntil wait until n timers are pending, e.g. the client's
+// background loop is sleeping, or timeout
+func (clock *FakeClock) BlockUntil(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for clock.Pending() < n {
+		select {
+		case <-clock.added:
+		case <-time.After(time.Millisecond):
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
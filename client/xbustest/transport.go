@@ -0,0 +1,1063 @@
+package xbustest
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infrmods/xbus/client"
+)
+
+// Op transport operation, used to inject faults
+type Op string
+
+const (
+	// OpPlugAll PlugAll
+	OpPlugAll Op = "PlugAll"
+	// OpUnplug Unplug
+	OpUnplug Op = "Unplug"
+	// OpGetEndpoint GetEndpoint
+	OpGetEndpoint Op = "GetEndpoint"
+	// OpUpdateIfVersion UpdateIfVersion
+	OpUpdateIfVersion Op = "UpdateIfVersion"
+	// OpPatchEndpoint PatchEndpoint
+	OpPatchEndpoint Op = "PatchEndpoint"
+	// OpQuery Query
+	OpQuery Op = "Query"
+	// OpQueryAt QueryAt
+	OpQueryAt Op = "QueryAt"
+	// OpQueryMulti QueryMulti
+	OpQueryMulti Op = "QueryMulti"
+	// OpQueryVersion QueryVersion
+	OpQueryVersion Op = "QueryVersion"
+	// OpCount Count
+	OpCount Op = "Count"
+	// OpSearch Search
+	OpSearch Op = "Search"
+	// OpSync Sync
+	OpSync Op = "Sync"
+	// OpWatch Watch
+	OpWatch Op = "Watch"
+	// OpWatchMulti WatchMulti
+	OpWatchMulti Op = "WatchMulti"
+	// OpKeepAlive KeepAlive
+	OpKeepAlive Op = "KeepAlive"
+	// OpKeepAliveStream KeepAliveStream
+	OpKeepAliveStream Op = "KeepAliveStream"
+	// OpRevoke Revoke
+	OpRevoke Op = "Revoke"
+	// OpLock Lock
+	OpLock Op = "Lock"
+	// OpUnlock Unlock
+	OpUnlock Op = "Unlock"
+	// OpCampaign Campaign
+	OpCampaign Op = "Campaign"
+	// OpResign Resign
+	OpResign Op = "Resign"
+	// OpGetLeader GetLeader
+	OpGetLeader Op = "GetLeader"
+	// OpObserveLeader ObserveLeader
+	OpObserveLeader Op = "ObserveLeader"
+	// OpGetSchema GetSchema
+	OpGetSchema Op = "GetSchema"
+	// OpGetMetadata GetMetadata
+	OpGetMetadata Op = "GetMetadata"
+	// OpGetClientPolicy GetClientPolicy
+	OpGetClientPolicy Op = "GetClientPolicy"
+	// OpWatchClientPolicy WatchClientPolicy
+	OpWatchClientPolicy Op = "WatchClientPolicy"
+	// OpDeclareDependencies DeclareDependencies
+	OpDeclareDependencies Op = "DeclareDependencies"
+	// OpHistory History
+	OpHistory Op = "History"
+	// OpFlappingEndpoints FlappingEndpoints
+	OpFlappingEndpoints Op = "FlappingEndpoints"
+)
+
+type node struct {
+	endpoint    client.ServiceEndpoint
+	leaseID     int64
+	modRevision int64
+}
+
+type zone struct {
+	desc  client.ServiceDesc
+	nodes map[string]*node
+}
+
+// FakeTransport in-memory xbus implementing client.Transport
+type FakeTransport struct {
+	mu       sync.Mutex
+	revision int64
+	leaseID  int64
+	leases   map[int64]time.Duration
+	services map[string]map[string]*zone
+	locks    map[string]*client.LockResult
+	leaders  map[string]*client.Leader
+	schemas  map[string][]client.Schema
+	metadata map[string]client.ServiceMetadata
+	policies map[string]client.ClientPolicy
+	// policyRevs revisions of the last changes of policies, set or deleted
+	policyRevs map[string]int64
+	maint      map[string]client.Maintenance
+	deps       []string
+	history    []client.HistoryEntry
+	flapping   map[string][]client.FlappingEndpoint
+	scripted   map[string][]watchStep
+	faults     map[Op][]func() error
+	changed    chan struct{}
+	calls      map[Op]int
+}
+
+type watchStep struct {
+	result *client.QueryResult
+	err    error
+}
+
+// NewFakeTransport new fake transport
+func NewFakeTransport() *FakeTransport {
+	return &FakeTransport{
+		revision:   1,
+		leases:     make(map[int64]time.Duration),
+		services:   make(map[string]map[string]*zone),
+		locks:      make(map[string]*client.LockResult),
+		leaders:    make(map[string]*client.Leader),
+		schemas:    make(map[string][]client.Schema),
+		metadata:   make(map[string]client.ServiceMetadata),
+		policies:   make(map[string]client.ClientPolicy),
+		policyRevs: make(map[string]int64),
+		maint:      make(map[string]client.Maintenance),
+		flapping:   make(map[string][]client.FlappingEndpoint),
+		scripted:   make(map[string][]watchStep),
+		faults:     make(map[Op][]func() error),
+		changed:    make(chan struct{}),
+		calls:      make(map[Op]int),
+	}
+}
+
+// FailNext make the next call of op fail with err
+func (t *FakeTransport) FailNext(op Op, err error) {
+	t.SetFault(op, func() error { return err })
+}
+
+// SetFault queue fn, called instead of the next call of op, nil result means pass through
+func (t *FakeTransport) SetFault(op Op, fn func() error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.faults[op] = append(t.faults[op], fn)
+}
+
+// Calls number of calls of op
+func (t *FakeTransport) Calls(op Op) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.calls[op]
+}
+
+// ScriptWatch queue a result returned by the next Watch of service,
+// instead of waiting for a real change
+func (t *FakeTransport) ScriptWatch(service string, result *client.QueryResult, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.scripted[service] = append(t.scripted[service], watchStep{result: result, err: err})
+	t.notifyLocked()
+}
+
+// Revision current revision
+func (t *FakeTransport) Revision() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.revision
+}
+
+// ExpireLease simulate lease expiry, endpoints plugged with it are removed
+// and further KeepAlive fails with NOT_FOUND
+func (t *FakeTransport) ExpireLease(leaseID int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.removeLeaseLocked(leaseID)
+}
+
+// Leases alive lease ids
+func (t *FakeTransport) Leases() []int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]int64, 0, len(t.leases))
+	for id := range t.leases {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (t *FakeTransport) removeLeaseLocked(leaseID int64) {
+	if _, ok := t.leases[leaseID]; !ok {
+		return
+	}
+	delete(t.leases, leaseID)
+	for name, lock := range t.locks {
+		if lock.LeaseID == leaseID {
+			delete(t.locks, name)
+		}
+	}
+	for name, leader := range t.leaders {
+		if leader.LeaseID == leaseID {
+			delete(t.leaders, name)
+		}
+	}
+	for _, zones := range t.services {
+		for _, z := range zones {
+			for addr, n := range z.nodes {
+				if n.leaseID == leaseID {
+					delete(z.nodes, addr)
+					t.recordLocked("unplug", &z.desc, addr, n, &n.endpoint)
+				}
+			}
+		}
+	}
+	t.notifyLocked()
+}
+
+const maxHistory = 1000
+
+// recordLocked record a change of the next revision, n is nil for unplugs
+func (t *FakeTransport) recordLocked(typ string, desc *client.ServiceDesc, addr string, n *node, prev *client.ServiceEndpoint) {
+	entry := client.HistoryEntry{Revision: t.revision + 1, Time: time.Now(), Type: typ,
+		Service: desc.Service, Zone: desc.Zone, Address: addr}
+	if typ != "unplug" {
+		endpoint := n.endpoint
+		entry.Endpoint, entry.LeaseID = &endpoint, n.leaseID
+	} else {
+		entry.LeaseID = n.leaseID
+	}
+	if prev != nil && typ != "plug" {
+		p := *prev
+		entry.Prev = &p
+	}
+	if entry.Endpoint != nil {
+		entry.InstanceID = entry.Endpoint.InstanceID
+	} else if entry.Prev != nil {
+		entry.InstanceID = entry.Prev.InstanceID
+	}
+	if len(t.history) >= maxHistory {
+		t.history = t.history[1:]
+	}
+	t.history = append(t.history, entry)
+}
+
+func (t *FakeTransport) notifyLocked() {
+	t.revision++
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+func (t *FakeTransport) fault(op Op) error {
+	t.mu.Lock()
+	t.calls[op]++
+	fns := t.faults[op]
+	if len(fns) == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	t.faults[op] = fns[1:]
+	t.mu.Unlock()
+	return fns[0]()
+}
+
+func notFound(msg string) error {
+	return &client.Error{Code: client.EcodeNotFound, Message: msg}
+}
+
+// PlugAll impl client.Transport
+func (t *FakeTransport) PlugAll(ctx context.Context, ttl time.Duration, leaseID int64,
+	descs []client.ServiceDesc, endpoint *client.ServiceEndpoint) (*client.PlugResult, error) {
+	if err := t.fault(OpPlugAll); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, desc := range descs {
+		if m, ok := t.maint[desc.Service]; ok && m.BlockPlug {
+			return nil, &client.Error{Code: client.EcodeInMaintenance, Message: desc.Service + " in maintenance"}
+		}
+	}
+	if leaseID == 0 {
+		t.leaseID++
+		leaseID = t.leaseID
+		t.leases[leaseID] = ttl
+	} else if _, ok := t.leases[leaseID]; !ok {
+		return nil, notFound("lease not found")
+	}
+	for _, desc := range descs {
+		if desc.Zone == "" {
+			desc.Zone = "default"
+		}
+		zones := t.services[desc.Service]
+		if zones == nil {
+			zones = make(map[string]*zone)
+			t.services[desc.Service] = zones
+		}
+		z := zones[desc.Zone]
+		if z == nil {
+			z = &zone{nodes: make(map[string]*node)}
+			zones[desc.Zone] = z
+		}
+		z.desc = desc
+		if endpoint.InstanceID != "" {
+			for addr, n := range z.nodes {
+				if n.endpoint.InstanceID == endpoint.InstanceID && addr != endpoint.Address {
+					delete(z.nodes, addr)
+					t.recordLocked("unplug", &z.desc, addr, n, &n.endpoint)
+				}
+			}
+		}
+		n := &node{endpoint: *endpoint, leaseID: leaseID, modRevision: t.revision + 1}
+		if n.endpoint.PlugTime == nil {
+			// kept for the same lease like xbus, or given by the caller to script slow starts
+			if prev := z.nodes[endpoint.Address]; prev != nil && prev.leaseID == leaseID {
+				n.endpoint.PlugTime = prev.endpoint.PlugTime
+			} else {
+				now := time.Now()
+				n.endpoint.PlugTime = &now
+			}
+		}
+		if prev := z.nodes[endpoint.Address]; prev != nil {
+			t.recordLocked("update", &z.desc, endpoint.Address, n, &prev.endpoint)
+		} else {
+			t.recordLocked("plug", &z.desc, endpoint.Address, n, nil)
+		}
+		z.nodes[endpoint.Address] = n
+	}
+	t.notifyLocked()
+	return &client.PlugResult{LeaseID: leaseID, TTL: int64(ttl / time.Second)}, nil
+}
+
+// Unplug impl client.Transport
+func (t *FakeTransport) Unplug(ctx context.Context, service, zoneName, addr string) error {
+	if err := t.fault(OpUnplug); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if z := t.services[service][zoneName]; z != nil {
+		if n, ok := z.nodes[addr]; ok {
+			delete(z.nodes, addr)
+			t.recordLocked("unplug", &z.desc, addr, n, &n.endpoint)
+			t.notifyLocked()
+		}
+	}
+	return nil
+}
+
+// GetEndpoint impl client.Transport
+func (t *FakeTransport) GetEndpoint(ctx context.Context, service, zoneName, addr string) (*client.EndpointRevision, error) {
+	if err := t.fault(OpGetEndpoint); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	z := t.services[service][zoneName]
+	if z == nil || z.nodes[addr] == nil {
+		return nil, notFound("endpoint not found")
+	}
+	n := z.nodes[addr]
+	return &client.EndpointRevision{Endpoint: n.endpoint, ModRevision: n.modRevision, LeaseID: n.leaseID,
+		TTL: int64(t.leases[n.leaseID] / time.Second)}, nil
+}
+
+// UpdateIfVersion impl client.Transport
+func (t *FakeTransport) UpdateIfVersion(ctx context.Context, service, zoneName, addr string,
+	modRevision int64, endpoint *client.ServiceEndpoint) (int64, error) {
+	if err := t.fault(OpUpdateIfVersion); err != nil {
+		return 0, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	z := t.services[service][zoneName]
+	if z == nil || z.nodes[addr] == nil {
+		return 0, notFound("endpoint not found")
+	}
+	n := z.nodes[addr]
+	if n.modRevision != modRevision {
+		return 0, &client.Error{Code: client.EcodeEndpointChanged, Message: "endpoint changed"}
+	}
+	updated := *endpoint
+	updated.Address = addr
+	updated.InstanceID = n.endpoint.InstanceID
+	updated.Static = n.endpoint.Static
+	updated.Origin = n.endpoint.Origin
+	updated.PlugTime = n.endpoint.PlugTime
+	prev := n.endpoint
+	n.endpoint = updated
+	t.recordLocked("update", &z.desc, addr, n, &prev)
+	t.notifyLocked()
+	n.modRevision = t.revision
+	return n.modRevision, nil
+}
+
+// PatchEndpoint impl client.Transport
+func (t *FakeTransport) PatchEndpoint(ctx context.Context, service, zoneName, addr string,
+	modRevision int64, patch map[string]interface{}) (*client.EndpointRevision, error) {
+	if err := t.fault(OpPatchEndpoint); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	z := t.services[service][zoneName]
+	if z == nil || z.nodes[addr] == nil {
+		return nil, notFound("endpoint not found")
+	}
+	n := z.nodes[addr]
+	if modRevision != 0 && n.modRevision != modRevision {
+		return nil, &client.Error{Code: client.EcodeEndpointChanged, Message: "endpoint changed"}
+	}
+	data, _ := json.Marshal(n.endpoint)
+	fields := make(map[string]interface{})
+	json.Unmarshal(data, &fields)
+	for name, value := range patch {
+		switch name {
+		case "address", "instance_id", "static", "origin", "plug_time":
+			return nil, &client.Error{Code: client.EcodeInvalidParam, Message: name + " can't be patched"}
+		}
+		if value == nil {
+			delete(fields, name)
+		} else {
+			fields[name] = value
+		}
+	}
+	data, _ = json.Marshal(fields)
+	var patched client.ServiceEndpoint
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return nil, &client.Error{Code: client.EcodeInvalidParam, Message: "invalid patch: " + err.Error()}
+	}
+	prev := n.endpoint
+	n.endpoint = patched
+	t.recordLocked("update", &z.desc, addr, n, &prev)
+	t.notifyLocked()
+	n.modRevision = t.revision
+	return &client.EndpointRevision{Endpoint: n.endpoint, ModRevision: n.modRevision, LeaseID: n.leaseID}, nil
+}
+
+func (t *FakeTransport) queryLocked(service string) (*client.QueryResult, error) {
+	zones := t.services[service]
+	if len(zones) == 0 {
+		return nil, notFound("service not found")
+	}
+	result := &client.Service{Service: service, Zones: make(map[string]*client.ServiceZone)}
+	for name, z := range zones {
+		endpoints := make([]client.ServiceEndpoint, 0, len(z.nodes))
+		for _, n := range z.nodes {
+			endpoints = append(endpoints, n.endpoint)
+		}
+		sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Address < endpoints[j].Address })
+		result.Zones[name] = &client.ServiceZone{Endpoints: endpoints, ServiceDesc: z.desc}
+	}
+	query := &client.QueryResult{Service: result, Revision: t.revision}
+	if m, ok := t.maint[service]; ok {
+		query.Status, query.Maintenance = client.ServiceStatusMaintenance, &m
+	}
+	return query, nil
+}
+
+// Query impl client.Transport
+func (t *FakeTransport) Query(ctx context.Context, service string) (*client.QueryResult, error) {
+	if err := t.fault(OpQuery); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.queryLocked(service)
+}
+
+// QueryAt impl client.Transport, no history is kept: revisions before
+// the current one are compacted
+func (t *FakeTransport) QueryAt(ctx context.Context, service string, revision int64) (*client.QueryResult, error) {
+	if err := t.fault(OpQueryAt); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if revision < t.revision {
+		return nil, &client.Error{Code: client.EcodeRevisionCompacted, Message: "revision compacted"}
+	}
+	if revision > t.revision {
+		return nil, &client.Error{Code: client.EcodeInvalidParam, Message: "future revision"}
+	}
+	return t.queryLocked(service)
+}
+
+// QueryVersion impl client.Transport
+func (t *FakeTransport) QueryVersion(ctx context.Context, name, versionRange string) (*client.QueryResult, error) {
+	if err := t.fault(OpQueryVersion); err != nil {
+		return nil, err
+	}
+	r, err := client.ParseVersionRange(versionRange)
+	if err != nil {
+		return nil, &client.Error{Code: client.EcodeInvalidParam, Message: err.Error()}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	best := ""
+	for service, zones := range t.services {
+		if !strings.HasPrefix(service, name+":") {
+			continue
+		}
+		version := strings.TrimPrefix(service, name+":")
+		hasNodes := false
+		for _, z := range zones {
+			hasNodes = hasNodes || len(z.nodes) > 0
+		}
+		if hasNodes && r.Match(version) && (best == "" || client.CompareVersions(version, best) > 0) {
+			best = version
+		}
+	}
+	if best == "" {
+		return nil, notFound("no matched version")
+	}
+	return t.queryLocked(name + ":" + best)
+}
+
+// QueryMulti impl client.Transport
+func (t *FakeTransport) QueryMulti(ctx context.Context, refs []client.ServiceRef) (*client.MultiQueryResult, error) {
+	if err := t.fault(OpQueryMulti); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.queryMultiLocked(refs), nil
+}
+
+func (t *FakeTransport) queryMultiLocked(refs []client.ServiceRef) *client.MultiQueryResult {
+	result := &client.MultiQueryResult{Revision: t.revision, Services: make([]client.MultiQueryItem, 0, len(refs))}
+	for _, ref := range refs {
+		item := client.MultiQueryItem{Ref: ref}
+		if r, err := t.queryLocked(ref.Service); err == nil {
+			if ref.Zone != "" {
+				if z := r.Service.Zones[ref.Zone]; z != nil {
+					r.Service.Zones = map[string]*client.ServiceZone{ref.Zone: z}
+				} else {
+					r = nil
+				}
+			}
+			if r != nil {
+				item.Service = r.Service
+			}
+		}
+		item.NotFound = item.Service == nil
+		result.Services = append(result.Services, item)
+	}
+	return result
+}
+
+// Count impl client.Transport
+func (t *FakeTransport) Count(ctx context.Context, service, zoneName string) (*client.CountResult, error) {
+	if err := t.fault(OpCount); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var count int64
+	for name, z := range t.services[service] {
+		if zoneName == "" || name == zoneName {
+			count += int64(len(z.nodes))
+		}
+	}
+	return &client.CountResult{Count: count, Revision: t.revision}, nil
+}
+
+// Search impl client.Transport
+func (t *FakeTransport) Search(ctx context.Context, q string, skip, limit int64) (*client.SearchResult, error) {
+	if err := t.fault(OpSearch); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var items []client.ServiceItem
+	for service, zones := range t.services {
+		if !strings.Contains(service, q) {
+			continue
+		}
+		for name, z := range zones {
+			items = append(items, client.ServiceItem{Service: service, Zone: name, Type: z.desc.Type})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Service != items[j].Service {
+			return items[i].Service < items[j].Service
+		}
+		return items[i].Zone < items[j].Zone
+	})
+	result := &client.SearchResult{Services: []client.ServiceItem{}, Total: int64(len(items))}
+	if skip < int64(len(items)) {
+		items = items[skip:]
+		if limit >= 0 && limit < int64(len(items)) {
+			items = items[:limit]
+		}
+		result.Services = items
+	}
+	return result, nil
+}
+
+// Sync impl client.Transport, changed services are always sent in full
+func (t *FakeTransport) Sync(ctx context.Context, states []client.SyncState) (*client.SyncResult, error) {
+	if err := t.fault(OpSync); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := &client.SyncResult{Revision: t.revision, Deltas: []client.ServiceDelta{}}
+	for _, state := range states {
+		r, err := t.queryLocked(state.Service)
+		if err != nil {
+			if state.Checksum != "" || state.Revision == 0 {
+				result.Deltas = append(result.Deltas, client.ServiceDelta{Service: state.Service, NotFound: true})
+			}
+			continue
+		}
+		if checksum := client.Checksum(r.Service); checksum != state.Checksum {
+			result.Deltas = append(result.Deltas, client.ServiceDelta{Service: state.Service, Checksum: checksum, Full: r.Service})
+		}
+	}
+	return result, nil
+}
+
+// Watch impl client.Transport, returns scripted results first,
+// otherwise blocks until revision reached or ctx done
+func (t *FakeTransport) Watch(ctx context.Context, service string, revision int64, timeout time.Duration) (*client.QueryResult, error) {
+	if err := t.fault(OpWatch); err != nil {
+		return nil, err
+	}
+	for {
+		t.mu.Lock()
+		if steps := t.scripted[service]; len(steps) > 0 {
+			t.scripted[service] = steps[1:]
+			t.mu.Unlock()
+			return steps[0].result, steps[0].err
+		}
+		if t.revision >= revision {
+			defer t.mu.Unlock()
+			return t.queryLocked(service)
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			code := client.EcodeCanceled
+			if ctx.Err() == context.DeadlineExceeded {
+				code = client.EcodeDeadlineExceeded
+			}
+			return nil, &client.Error{Code: code, Message: ctx.Err().Error(), Revision: revision - 1}
+		case <-changed:
+		}
+	}
+}
+
+// WatchMulti impl client.Transport, blocks until revision reached or ctx done,
+// then returns all watched services rather than only the changed ones
+func (t *FakeTransport) WatchMulti(ctx context.Context, refs []client.ServiceRef, prefix string, revision int64, timeout time.Duration) (*client.MultiQueryResult, error) {
+	if err := t.fault(OpWatchMulti); err != nil {
+		return nil, err
+	}
+	for {
+		t.mu.Lock()
+		if t.revision >= revision {
+			if prefix != "" {
+				refs = nil
+				for service := range t.services {
+					if strings.HasPrefix(service, prefix) {
+						refs = append(refs, client.ServiceRef{Service: service})
+					}
+				}
+				sort.Slice(refs, func(i, j int) bool { return refs[i].Service < refs[j].Service })
+			}
+			defer t.mu.Unlock()
+			return t.queryMultiLocked(refs), nil
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			code := client.EcodeCanceled
+			if ctx.Err() == context.DeadlineExceeded {
+				code = client.EcodeDeadlineExceeded
+			}
+			return nil, &client.Error{Code: code, Message: ctx.Err().Error(), Revision: revision - 1}
+		case <-changed:
+		}
+	}
+}
+
+// KeepAlive impl client.Transport
+func (t *FakeTransport) KeepAlive(ctx context.Context, leaseID int64) error {
+	if err := t.fault(OpKeepAlive); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.leases[leaseID]; !ok {
+		return notFound("lease not found")
+	}
+	return nil
+}
+
+// KeepAliveStream impl client.Transport, streams until the lease expires or ctx done
+func (t *FakeTransport) KeepAliveStream(ctx context.Context, leaseID int64, fn func(client.LeaseEvent)) error {
+	if err := t.fault(OpKeepAliveStream); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	ttl, ok := t.leases[leaseID]
+	t.mu.Unlock()
+	if !ok {
+		return notFound("lease not found")
+	}
+	fn(client.LeaseEvent{Type: client.LeaseEventKeepAlive, LeaseID: leaseID, TTL: int64(ttl / time.Second)})
+	for {
+		t.mu.Lock()
+		_, ok := t.leases[leaseID]
+		changed := t.changed
+		t.mu.Unlock()
+		if !ok {
+			fn(client.LeaseEvent{Type: client.LeaseEventExpired, LeaseID: leaseID})
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Revoke impl client.Transport
+func (t *FakeTransport) Revoke(ctx context.Context, leaseID int64) error {
+	if err := t.fault(OpRevoke); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.removeLeaseLocked(leaseID)
+	return nil
+}
+
+// Lock impl client.Transport, blocks until the lock is free or ctx done
+func (t *FakeTransport) Lock(ctx context.Context, name string, ttl, timeout time.Duration) (*client.LockResult, error) {
+	if err := t.fault(OpLock); err != nil {
+		return nil, err
+	}
+	for {
+		t.mu.Lock()
+		if _, ok := t.locks[name]; !ok {
+			defer t.mu.Unlock()
+			t.leaseID++
+			t.leases[t.leaseID] = ttl
+			lock := &client.LockResult{Name: name, LeaseID: t.leaseID, Revision: t.revision + 1, TTL: int64(ttl / time.Second)}
+			t.locks[name] = lock
+			t.notifyLocked()
+			result := *lock
+			return &result, nil
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctxError(ctx)
+		case <-changed:
+		}
+	}
+}
+
+func ctxError(ctx context.Context) error {
+	code := client.EcodeCanceled
+	if ctx.Err() == context.DeadlineExceeded {
+		code = client.EcodeDeadlineExceeded
+	}
+	return &client.Error{Code: code, Message: ctx.Err().Error()}
+}
+
+// Unlock impl client.Transport
+func (t *FakeTransport) Unlock(ctx context.Context, name string, leaseID int64) error {
+	if err := t.fault(OpUnlock); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if lock, ok := t.locks[name]; ok && lock.LeaseID == leaseID {
+		delete(t.locks, name)
+	}
+	t.removeLeaseLocked(leaseID)
+	return nil
+}
+
+// Campaign impl client.Transport, blocks until there is no leader or ctx done
+func (t *FakeTransport) Campaign(ctx context.Context, name, value string, ttl, timeout time.Duration) (*client.Leader, error) {
+	if err := t.fault(OpCampaign); err != nil {
+		return nil, err
+	}
+	for {
+		t.mu.Lock()
+		if _, ok := t.leaders[name]; !ok {
+			defer t.mu.Unlock()
+			t.leaseID++
+			t.leases[t.leaseID] = ttl
+			leader := &client.Leader{Name: name, Value: value, LeaseID: t.leaseID, Revision: t.revision + 1}
+			t.leaders[name] = leader
+			t.notifyLocked()
+			result := *leader
+			result.TTL = int64(ttl / time.Second)
+			return &result, nil
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctxError(ctx)
+		case <-changed:
+		}
+	}
+}
+
+// Resign impl client.Transport
+func (t *FakeTransport) Resign(ctx context.Context, name string, leaseID int64) error {
+	if err := t.fault(OpResign); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if leader, ok := t.leaders[name]; ok && leader.LeaseID == leaseID {
+		delete(t.leaders, name)
+	}
+	t.removeLeaseLocked(leaseID)
+	return nil
+}
+
+func (t *FakeTransport) leaderLocked(name string) *client.LeaderResult {
+	result := &client.LeaderResult{Revision: t.revision}
+	if leader, ok := t.leaders[name]; ok {
+		l := *leader
+		result.Leader = &l
+	}
+	return result
+}
+
+// GetLeader impl client.Transport
+func (t *FakeTransport) GetLeader(ctx context.Context, name string) (*client.LeaderResult, error) {
+	if err := t.fault(OpGetLeader); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.leaderLocked(name), nil
+}
+
+// ObserveLeader impl client.Transport, blocks until the leader changed or ctx done
+func (t *FakeTransport) ObserveLeader(ctx context.Context, name string, leaderRevision int64, timeout time.Duration) (*client.LeaderResult, error) {
+	if err := t.fault(OpObserveLeader); err != nil {
+		return nil, err
+	}
+	for {
+		t.mu.Lock()
+		current := int64(0)
+		if leader, ok := t.leaders[name]; ok {
+			current = leader.Revision
+		}
+		if current != leaderRevision {
+			defer t.mu.Unlock()
+			return t.leaderLocked(name), nil
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctxError(ctx)
+		case <-changed:
+		}
+	}
+}
+
+// PutSchema add content as the next revision of the schema of kind of service
+func (t *FakeTransport) PutSchema(service, kind, content string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := service + "/" + kind
+	revision := int64(len(t.schemas[key]) + 1)
+	t.schemas[key] = append(t.schemas[key], client.Schema{Service: service, Kind: kind, Revision: revision, Content: content})
+	return revision
+}
+
+// GetSchema impl client.Transport
+func (t *FakeTransport) GetSchema(ctx context.Context, service, kind string, revision int64) (*client.Schema, error) {
+	if err := t.fault(OpGetSchema); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	revisions := t.schemas[service+"/"+kind]
+	if revision == 0 {
+		revision = int64(len(revisions))
+	}
+	if revision <= 0 || revision > int64(len(revisions)) {
+		return nil, notFound("schema not found")
+	}
+	schema := revisions[revision-1]
+	return &schema, nil
+}
+
+// SetMaintenance put service in maintenance, or end it if maintenance is nil
+func (t *FakeTransport) SetMaintenance(service string, maintenance *client.Maintenance) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if maintenance == nil {
+		delete(t.maint, service)
+		return
+	}
+	t.maint[service] = *maintenance
+}
+
+// SetMetadata set metadata of service name
+func (t *FakeTransport) SetMetadata(metadata client.ServiceMetadata) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.metadata[metadata.Name] = metadata
+}
+
+// GetMetadata impl client.Transport
+func (t *FakeTransport) GetMetadata(ctx context.Context, name string) (*client.ServiceMetadata, error) {
+	if err := t.fault(OpGetMetadata); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	metadata, ok := t.metadata[name]
+	if !ok {
+		return nil, notFound("no metadata of " + name)
+	}
+	return &metadata, nil
+}
+
+// SetClientPolicy set the client policy of its service, or delete it if policy is only the service
+func (t *FakeTransport) SetClientPolicy(policy client.ClientPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.revision++
+	if policy == (client.ClientPolicy{Service: policy.Service}) {
+		delete(t.policies, policy.Service)
+	} else {
+		policy.Revision = t.revision
+		t.policies[policy.Service] = policy
+	}
+	t.policyRevs[policy.Service] = t.revision
+	t.notifyLocked()
+}
+
+func (t *FakeTransport) clientPolicyLocked(service string) *client.ClientPolicyResult {
+	result := &client.ClientPolicyResult{Revision: t.revision}
+	if policy, ok := t.policies[service]; ok {
+		result.Policy = &policy
+	}
+	return result
+}
+
+// GetClientPolicy impl client.Transport
+func (t *FakeTransport) GetClientPolicy(ctx context.Context, service string) (*client.ClientPolicyResult, error) {
+	if err := t.fault(OpGetClientPolicy); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.clientPolicyLocked(service), nil
+}
+
+// WatchClientPolicy impl client.Transport, blocks until the policy changed since revision or ctx done
+func (t *FakeTransport) WatchClientPolicy(ctx context.Context, service string, revision int64, timeout time.Duration) (*client.ClientPolicyResult, error) {
+	if err := t.fault(OpWatchClientPolicy); err != nil {
+		return nil, err
+	}
+	for {
+		t.mu.Lock()
+		if t.policyRevs[service] >= revision {
+			defer t.mu.Unlock()
+			return t.clientPolicyLocked(service), nil
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			code := client.EcodeCanceled
+			if ctx.Err() == context.DeadlineExceeded {
+				code = client.EcodeDeadlineExceeded
+			}
+			return nil, &client.Error{Code: code, Message: ctx.Err().Error(), Revision: revision - 1}
+		case <-changed:
+		}
+	}
+}
+
+// DeclareDependencies impl client.Transport
+func (t *FakeTransport) DeclareDependencies(ctx context.Context, services []string) error {
+	if err := t.fault(OpDeclareDependencies); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deps = append([]string{}, services...)
+	return nil
+}
+
+// Dependencies services last declared by DeclareDependencies
+func (t *FakeTransport) Dependencies() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.deps...)
+}
+
+// History impl client.Transport, changes made through the fake, at most the last 1000
+func (t *FakeTransport) History(ctx context.Context, name, version string, since time.Time) ([]client.HistoryEntry, error) {
+	if err := t.fault(OpHistory); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := make([]client.HistoryEntry, 0)
+	for _, entry := range t.history {
+		if version != "" && entry.Service != name+":"+version {
+			continue
+		} else if version == "" && !strings.HasPrefix(entry.Service, name+":") {
+			continue
+		}
+		if !entry.Time.Before(since) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// SetFlapping set the endpoints of service flagged as flapping, none if empty
+func (t *FakeTransport) SetFlapping(service string, endpoints ...client.FlappingEndpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(endpoints) == 0 {
+		delete(t.flapping, service)
+		return
+	}
+	t.flapping[service] = append([]client.FlappingEndpoint(nil), endpoints...)
+}
+
+// FlappingEndpoints impl client.Transport, the ones set by SetFlapping
+func (t *FakeTransport) FlappingEndpoints(ctx context.Context, service string) ([]client.FlappingEndpoint, error) {
+	if err := t.fault(OpFlappingEndpoints); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]client.FlappingEndpoint{}, t.flapping[service]...), nil
+}
@@ -0,0 +1,141 @@
+package xbustest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/infrmods/xbus/client"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := NewFakeClock(start)
+	select {
+	case <-clock.After(0):
+	default:
+		t.Fatal("timer of 0 not fired immediately")
+	}
+
+	short, long := clock.After(time.Second), clock.After(time.Minute)
+	if !clock.BlockUntil(2, time.Second) {
+		t.Fatalf("pending timers: %d", clock.Pending())
+	}
+	clock.Advance(time.Second)
+	select {
+	case now := <-short:
+		if !now.Equal(start.Add(time.Second)) {
+			t.Fatalf("fired at %v", now)
+		}
+	default:
+		t.Fatal("due timer not fired")
+	}
+	select {
+	case <-long:
+		t.Fatal("timer fired early")
+	default:
+	}
+	if clock.Pending() != 1 || !clock.Now().Equal(start.Add(time.Second)) {
+		t.Fatalf("pending: %d, now: %v", clock.Pending(), clock.Now())
+	}
+	if clock.BlockUntil(2, 10*time.Millisecond) {
+		t.Fatal("BlockUntil not timed out")
+	}
+}
+
+func TestFakeTransport(t *testing.T) {
+	transport := NewFakeTransport()
+	ctx := context.Background()
+	service := "payments.core:1.0"
+	if _, err := transport.Query(ctx, service); !client.IsErrCode(err, client.EcodeNotFound) {
+		t.Fatalf("query of missing service: %v", err)
+	}
+	result, err := transport.PlugAll(ctx, 10*time.Second, 0,
+		[]client.ServiceDesc{{Service: service}}, &client.ServiceEndpoint{Address: "10.0.0.1:80"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// watches wait for the next revision
+	rev := transport.Revision()
+	watched := make(chan *client.QueryResult, 1)
+	go func() {
+		result, err := transport.Watch(ctx, service, rev+1, time.Minute)
+		if err != nil {
+			t.Error(err)
+		}
+		watched <- result
+	}()
+	if err := transport.Unplug(ctx, service, "default", "10.0.0.1:80"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case result := <-watched:
+		if result == nil || len(result.Service.Zones["default"].Endpoints) != 0 {
+			t.Fatalf("watched: %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watch not woken by unplug")
+	}
+
+	// scripted results and faults come first
+	scripted := &client.QueryResult{Service: &client.Service{Service: service}, Revision: 100}
+	transport.ScriptWatch(service, scripted, nil)
+	if got, err := transport.Watch(ctx, service, transport.Revision()+10, time.Minute); err != nil || got != scripted {
+		t.Fatalf("scripted watch: %v, %v", got, err)
+	}
+	broken := errors.New("connection reset")
+	transport.FailNext(OpKeepAlive, broken)
+	if err := transport.KeepAlive(ctx, result.LeaseID); err != broken {
+		t.Fatalf("fault: %v", err)
+	}
+	if err := transport.KeepAlive(ctx, result.LeaseID); err != nil {
+		t.Fatalf("keepalive after fault: %v", err)
+	}
+	if n := transport.Calls(OpKeepAlive); n != 2 {
+		t.Fatalf("keepalive calls: %d", n)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	transport, clock := NewFakeTransport(), NewFakeClock(time.Now())
+	cli := client.NewClient(client.Config{Transport: transport, Clock: clock})
+	ctx := context.Background()
+	service := "payments.core:1.0"
+	reg, err := cli.Plug(ctx, 3*time.Second, []client.ServiceDesc{{Service: service}},
+		client.ServiceEndpoint{Address: "10.0.0.1:80"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close(ctx)
+	replugged := make(chan int64, 1)
+	reg.OnReplug = func(leaseID int64) { replugged <- leaseID }
+	oldLease := reg.LeaseID()
+
+	transport.ExpireLease(oldLease)
+	if s, _, err := cli.Query(ctx, service); err != nil || len(s.Zones["default"].Endpoints) != 0 {
+		t.Fatalf("endpoint of expired lease: %v, %v", s, err)
+	}
+	if err := transport.KeepAlive(ctx, oldLease); !client.IsErrCode(err, client.EcodeNotFound) {
+		t.Fatalf("keepalive of expired lease: %v", err)
+	}
+
+	// the registration replugs on its next keepalive
+	if !clock.BlockUntil(1, time.Second) {
+		t.Fatal("keepalive loop not waiting")
+	}
+	clock.Advance(time.Second)
+	select {
+	case leaseID := <-replugged:
+		if leaseID == oldLease || leaseID != reg.LeaseID() {
+			t.Fatalf("replugged with lease %d, old %d", leaseID, oldLease)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not replugged after lease expiry")
+	}
+	endpoint, err := cli.GetEndpoint(ctx, service, "default", "10.0.0.1:80")
+	if err != nil || endpoint.LeaseID != reg.LeaseID() || endpoint.TTL != 3 {
+		t.Fatalf("replugged endpoint: %+v, %v", endpoint, err)
+	}
+}
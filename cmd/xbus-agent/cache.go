@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/infrmods/xbus/client"
+	"github.com/infrmods/xbus/logging"
+)
+
+// cacheEntry cached service, Stale once its watch fails until re-synced
+type cacheEntry struct {
+	Service   *client.Service `json:"service"`
+	Revision  int64           `json:"revision"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Stale     bool            `json:"stale"`
+
+	// closed and replaced on every update
+	changed chan struct{}
+}
+
+type serviceCache struct {
+	ctx           context.Context
+	client        *client.Client
+	path          string
+	retryInterval time.Duration
+
+	mu         sync.Mutex
+	entries    map[string]*cacheEntry
+	subscribed map[string]bool
+	dirty      bool
+}
+
+func newServiceCache(ctx context.Context, cli *client.Client, path string, retryInterval time.Duration) *serviceCache {
+	return &serviceCache{ctx: ctx, client: cli, path: path, retryInterval: retryInterval,
+		entries: make(map[string]*cacheEntry), subscribed: make(map[string]bool)}
+}
+
+type cacheFile struct {
+	Services map[string]*cacheEntry `json:"services"`
+}
+
+// load cached services of a previous run, they're stale until re-synced
+func (cache *serviceCache) load() error {
+	data, err := ioutil.ReadFile(cache.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var file cacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for name, entry := range file.Services {
+		if entry.Service == nil {
+			continue
+		}
+		entry.Stale = true
+		entry.changed = make(chan struct{})
+		cache.entries[name] = entry
+	}
+	for name := range cache.entries {
+		cache.subscribeLocked(name)
+	}
+	return nil
+}
+
+// save write cached services atomically
+func (cache *serviceCache) save() error {
+	cache.mu.Lock()
+	data, err := json.Marshal(cacheFile{Services: cache.entries})
+	cache.dirty = false
+	cache.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(cache.path), filepath.Base(cache.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), cache.path)
+}
+
+const persistInterval = time.Second
+
+// persistLoop save changes at most once per persistInterval until ctx done
+func (cache *serviceCache) persistLoop() {
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cache.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cache.mu.Lock()
+		dirty := cache.dirty
+		cache.mu.Unlock()
+		if dirty {
+			if err := cache.save(); err != nil {
+				logging.Warningf("save cache(%s) fail: %v", cache.path, err)
+			}
+		}
+	}
+}
+
+// get cached entry of service, nil if not cached
+func (cache *serviceCache) get(service string) *cacheEntry {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.entries[service]
+}
+
+// fetch cached entry of service, queried and subscribed if not cached
+func (cache *serviceCache) fetch(ctx context.Context, service string) (*cacheEntry, error) {
+	if entry := cache.get(service); entry != nil {
+		return entry, nil
+	}
+	s, rev, err := cache.client.Query(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if entry := cache.entries[service]; entry != nil {
+		return entry, nil
+	}
+	entry := cache.updateLocked(service, s, rev)
+	cache.subscribeLocked(service)
+	return entry, nil
+}
+
+// changed channel closed on the next update of service
+func (cache *serviceCache) changed(service string) <-chan struct{} {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if entry := cache.entries[service]; entry != nil {
+		return entry.changed
+	}
+	return nil
+}
+
+func (cache *serviceCache) update(service string, s *client.Service, rev int64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.updateLocked(service, s, rev)
+}
+
+func (cache *serviceCache) updateLocked(service string, s *client.Service, rev int64) *cacheEntry {
+	entry := &cacheEntry{Service: s, Revision: rev, UpdatedAt: time.Now(), changed: make(chan struct{})}
+	if old := cache.entries[service]; old != nil {
+		close(old.changed)
+	}
+	cache.entries[service] = entry
+	cache.dirty = true
+	return entry
+}
+
+func (cache *serviceCache) markStale(service string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if entry := cache.entries[service]; entry != nil && !entry.Stale {
+		stale := *entry
+		stale.Stale = true
+		stale.changed = make(chan struct{})
+		close(entry.changed)
+		cache.entries[service] = &stale
+	}
+}
+
+// subscribe keep service in sync by watching in background
+func (cache *serviceCache) subscribe(service string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.subscribeLocked(service)
+}
+
+func (cache *serviceCache) subscribeLocked(service string) {
+	if cache.subscribed[service] {
+		return
+	}
+	cache.subscribed[service] = true
+	go cache.watchLoop(service)
+}
+
+// entries snapshot of cached entries
+func (cache *serviceCache) snapshot() map[string]*cacheEntry {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entries := make(map[string]*cacheEntry, len(cache.entries))
+	for name, entry := range cache.entries {
+		entries[name] = entry
+	}
+	return entries
+}
+
+// watchLoop like client.WatchLoop, keeping revisions for watches of agent clients
+func (cache *serviceCache) watchLoop(service string) {
+	ctx := cache.ctx
+	var revision int64
+	for ctx.Err() == nil {
+		var s *client.Service
+		var rev int64
+		var err error
+		if revision == 0 {
+			s, rev, err = cache.client.Query(ctx, service)
+		} else {
+			s, rev, err = cache.client.Watch(ctx, service, revision+1)
+		}
+		if err != nil {
+			if client.IsErrCode(err, client.EcodeDeadlineExceeded) && ctx.Err() == nil {
+				if rev > 0 {
+					revision = rev
+				}
+				continue
+			}
+			if client.IsErrCode(err, client.EcodeRevisionCompacted) {
+				revision = 0
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			logging.Warningf("watch %s fail, serving stale cache: %v", service, err)
+			cache.markStale(service)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(cache.retryInterval):
+			}
+			revision = 0
+			continue
+		}
+		revision = rev
+		if s != nil {
+			cache.update(service, s, rev)
+		}
+	}
+}
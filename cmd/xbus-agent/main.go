@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/infrmods/xbus/client"
+	"github.com/infrmods/xbus/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// AgentConfig xbus-agent config
+type AgentConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+	DevApp   string `yaml:"dev_app"`
+
+	// Listen host:port, or unix:path of a unix socket
+	Listen    string `yaml:"listen"`
+	CacheFile string `yaml:"cache_file"`
+	// Services subscribed at startup, others are subscribed on first query
+	Services      []string      `yaml:"services"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+var cfgPath = flag.String("config", "xbus-agent.yaml", "config file path")
+
+func loadConfig() (*AgentConfig, error) {
+	config := AgentConfig{
+		Listen:        "127.0.0.1:4480",
+		CacheFile:     "xbus-agent-cache.json",
+		RetryInterval: 5 * time.Second,
+	}
+	if data, err := ioutil.ReadFile(*cfgPath); err == nil {
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("invalid config file(%s): %v", *cfgPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, item := range []struct {
+		value *string
+		env   string
+	}{
+		{&config.Endpoint, client.EnvEndpoint},
+		{&config.CertFile, client.EnvCertFile},
+		{&config.KeyFile, client.EnvKeyFile},
+		{&config.CAFile, client.EnvCAFile},
+		{&config.DevApp, client.EnvDevApp},
+	} {
+		if v := os.Getenv(item.env); v != "" {
+			*item.value = v
+		}
+	}
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("missing endpoint")
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+	return &config, nil
+}
+
+func listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		// stale socket of a previous run
+		os.Remove(path)
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func main() {
+	flag.Parse()
+	config, err := loadConfig()
+	if err != nil {
+		logging.Errorf("load config fail: %v", err)
+		os.Exit(-1)
+	}
+	tlsConfig, err := client.LoadTLSConfig(config.CertFile, config.KeyFile, config.CAFile)
+	if err != nil {
+		logging.Errorf("load tls config fail: %v", err)
+		os.Exit(-1)
+	}
+	cli := client.NewClient(client.Config{Endpoint: config.Endpoint, TLSConfig: tlsConfig, DevApp: config.DevApp})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := newServiceCache(ctx, cli, config.CacheFile, config.RetryInterval)
+	if err := cache.load(); err != nil {
+		logging.Warningf("load cache(%s) fail: %v", config.CacheFile, err)
+	}
+	for _, service := range config.Services {
+		cache.subscribe(service)
+	}
+	go cache.persistLoop()
+
+	l, err := listen(config.Listen)
+	if err != nil {
+		logging.Errorf("listen %s fail: %v", config.Listen, err)
+		os.Exit(-1)
+	}
+	server := &http.Server{Handler: newAgentHandler(cache)}
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(err)
+		}
+	}()
+	logging.Infof("xbus-agent serving on %s", config.Listen)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	server.Shutdown(shutdownCtx)
+	if err := cache.save(); err != nil {
+		logging.Warningf("save cache(%s) fail: %v", config.CacheFile, err)
+	}
+}
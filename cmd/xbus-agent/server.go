@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/infrmods/xbus/client"
+)
+
+const servicesPath = "/api/v1/services/"
+
+// maxWatchTimeout max watch timeout of agent clients
+const maxWatchTimeout = 5 * time.Minute
+
+type agentHandler struct {
+	cache *serviceCache
+	mux   *http.ServeMux
+}
+
+// newAgentHandler handler serving cached services with the same api as xbus,
+// so clients can use the agent as their endpoint for queries and watches
+func newAgentHandler(cache *serviceCache) http.Handler {
+	h := &agentHandler{cache: cache, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/api/ok", func(w http.ResponseWriter, r *http.Request) {
+		writeResult(w, nil)
+	})
+	h.mux.HandleFunc(servicesPath, h.service)
+	h.mux.HandleFunc("/agent/status", h.status)
+	return h.mux
+}
+
+type response struct {
+	Ok     bool          `json:"ok"`
+	Result interface{}   `json:"result,omitempty"`
+	Error  *client.Error `json:"error,omitempty"`
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response{Ok: true, Result: result})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*client.Error)
+	if !ok {
+		e = &client.Error{Code: client.EcodeSystemError, Message: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if e.Code == client.EcodeSystemError {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response{Ok: false, Error: e})
+}
+
+// service GET /api/v1/services/:service[/:zone], watch=true&revision=N&timeout=S
+// waits until the cached revision reaches N
+func (h *agentHandler) service(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, servicesPath), "/", 2)
+	service, zone := parts[0], ""
+	if len(parts) == 2 {
+		zone = parts[1]
+	}
+	if service == "" {
+		writeError(w, &client.Error{Code: client.EcodeInvalidParam, Message: "missing service"})
+		return
+	}
+	entry, err := h.cache.fetch(r.Context(), service)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("watch") == "true" {
+		revision, err := strconv.ParseInt(q.Get("revision"), 10, 64)
+		if err != nil {
+			writeError(w, &client.Error{Code: client.EcodeInvalidParam, Message: "invalid revision"})
+			return
+		}
+		timeout := 60 * time.Second
+		if s := q.Get("timeout"); s != "" {
+			secs, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || secs <= 0 {
+				writeError(w, &client.Error{Code: client.EcodeInvalidParam, Message: "invalid timeout"})
+				return
+			}
+			timeout = time.Duration(secs) * time.Second
+		}
+		if timeout > maxWatchTimeout {
+			timeout = maxWatchTimeout
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		for entry.Revision < revision {
+			select {
+			case <-ctx.Done():
+				writeError(w, &client.Error{Code: client.EcodeDeadlineExceeded, Revision: entry.Revision})
+				return
+			case <-entry.changed:
+			}
+			entry = h.cache.get(service)
+		}
+	}
+
+	s := entry.Service
+	if zone != "" {
+		zones := make(map[string]*client.ServiceZone)
+		if z, ok := s.Zones[zone]; ok {
+			zones[zone] = z
+		}
+		s = &client.Service{Service: s.Service, Zones: zones}
+	}
+	w.Header().Set("X-Xbus-Agent-Updated", entry.UpdatedAt.Format(time.RFC3339))
+	w.Header().Set("X-Xbus-Agent-Stale", strconv.FormatBool(entry.Stale))
+	writeResult(w, client.QueryResult{Service: s, Revision: entry.Revision})
+}
+
+type entryStatus struct {
+	Service   string    `json:"service"`
+	Revision  int64     `json:"revision"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Stale     bool      `json:"stale"`
+}
+
+// status GET /agent/status cached services with staleness
+func (h *agentHandler) status(w http.ResponseWriter, r *http.Request) {
+	statuses := []entryStatus{}
+	for name, entry := range h.cache.snapshot() {
+		statuses = append(statuses, entryStatus{Service: name, Revision: entry.Revision,
+			UpdatedAt: entry.UpdatedAt, Stale: entry.Stale})
+	}
+	writeResult(w, map[string]interface{}{"services": statuses})
+}
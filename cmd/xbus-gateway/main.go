@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/infrmods/xbus/client"
+	"github.com/infrmods/xbus/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// RouteConfig requests with Host (port ignored), or under Path if Host is empty,
+// are proxied to endpoints of Service in Zone
+type RouteConfig struct {
+	Service string `yaml:"service"`
+	Zone    string `yaml:"zone"`
+	Host    string `yaml:"host"`
+	Path    string `yaml:"path"`
+	// StripPath remove Path from the proxied request path
+	StripPath bool `yaml:"strip_path"`
+	// Scheme of the endpoints, http by default
+	Scheme  string        `yaml:"scheme"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries on other endpoints if connecting fails, only for requests without body;
+	// the top level retries if not set, 0 to disable
+	Retries   *int          `yaml:"retries"`
+	SlowStart time.Duration `yaml:"slow_start"`
+	// EjectAfter consecutive failures (connecting, 502, 503 or 504) eject an endpoint
+	// for a while, see client.OutlierDetection
+	EjectAfter int `yaml:"eject_after"`
+}
+
+// TCPConfig connections to Listen are forwarded to endpoints of Service in Zone,
+// picked by Balance: weighted (by slow start) or least_conn
+type TCPConfig struct {
+	Listen      string        `yaml:"listen"`
+	Service     string        `yaml:"service"`
+	Zone        string        `yaml:"zone"`
+	Balance     string        `yaml:"balance"`
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+	// Retries on other endpoints if dialing fails, the top level retries if not set
+	Retries   *int          `yaml:"retries"`
+	SlowStart time.Duration `yaml:"slow_start"`
+	// EjectAfter consecutive dial failures eject an endpoint for a while
+	EjectAfter int `yaml:"eject_after"`
+}
+
+// balance policies of tcp proxies
+const (
+	BalanceWeighted  = "weighted"
+	BalanceLeastConn = "least_conn"
+)
+
+// GatewayConfig xbus-gateway config
+type GatewayConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+	DevApp   string `yaml:"dev_app"`
+
+	Listen        string        `yaml:"listen"`
+	Zone          string        `yaml:"zone"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Retries       int           `yaml:"retries"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	Routes        []RouteConfig `yaml:"routes"`
+	TCP           []TCPConfig   `yaml:"tcp"`
+}
+
+var cfgPath = flag.String("config", "xbus-gateway.yaml", "config file path")
+
+func loadConfig() (*GatewayConfig, error) {
+	config := GatewayConfig{
+		Listen:        "0.0.0.0:8080",
+		Zone:          "default",
+		Timeout:       30 * time.Second,
+		Retries:       1,
+		RetryInterval: 5 * time.Second,
+	}
+	data, err := ioutil.ReadFile(*cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid config file(%s): %v", *cfgPath, err)
+	}
+	for _, item := range []struct {
+		value *string
+		env   string
+	}{
+		{&config.Endpoint, client.EnvEndpoint},
+		{&config.CertFile, client.EnvCertFile},
+		{&config.KeyFile, client.EnvKeyFile},
+		{&config.CAFile, client.EnvCAFile},
+		{&config.DevApp, client.EnvDevApp},
+	} {
+		if v := os.Getenv(item.env); v != "" {
+			*item.value = v
+		}
+	}
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("missing endpoint")
+	}
+	if len(config.Routes) == 0 && len(config.TCP) == 0 {
+		return nil, fmt.Errorf("missing routes or tcp")
+	}
+	if config.Retries < 0 {
+		return nil, fmt.Errorf("negative retries")
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if route.Service == "" {
+			return nil, fmt.Errorf("route %d: missing service", i)
+		}
+		if route.Host == "" && route.Path == "" {
+			return nil, fmt.Errorf("route %d: missing host or path", i)
+		}
+		if route.Zone == "" {
+			route.Zone = config.Zone
+		}
+		if route.Scheme == "" {
+			route.Scheme = "http"
+		}
+		if route.Timeout <= 0 {
+			route.Timeout = config.Timeout
+		}
+		if route.Retries == nil {
+			route.Retries = &config.Retries
+		} else if *route.Retries < 0 {
+			return nil, fmt.Errorf("route %d: negative retries", i)
+		}
+	}
+	for i := range config.TCP {
+		tcp := &config.TCP[i]
+		if tcp.Listen == "" || tcp.Service == "" {
+			return nil, fmt.Errorf("tcp %d: missing listen or service", i)
+		}
+		if tcp.Zone == "" {
+			tcp.Zone = config.Zone
+		}
+		switch tcp.Balance {
+		case "":
+			tcp.Balance = BalanceWeighted
+		case BalanceWeighted, BalanceLeastConn:
+		default:
+			return nil, fmt.Errorf("tcp %d: invalid balance: %s", i, tcp.Balance)
+		}
+		if tcp.DialTimeout <= 0 {
+			tcp.DialTimeout = 5 * time.Second
+		}
+		if tcp.Retries == nil {
+			tcp.Retries = &config.Retries
+		} else if *tcp.Retries < 0 {
+			return nil, fmt.Errorf("tcp %d: negative retries", i)
+		}
+	}
+	return &config, nil
+}
+
+func main() {
+	flag.Parse()
+	config, err := loadConfig()
+	if err != nil {
+		logging.Errorf("load config fail: %v", err)
+		os.Exit(-1)
+	}
+	tlsConfig, err := client.LoadTLSConfig(config.CertFile, config.KeyFile, config.CAFile)
+	if err != nil {
+		logging.Errorf("load tls config fail: %v", err)
+		os.Exit(-1)
+	}
+	cli := client.NewClient(client.Config{Endpoint: config.Endpoint, TLSConfig: tlsConfig, DevApp: config.DevApp})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gateway := newGateway(config.Routes)
+	gateway.watch(ctx, cli, config.RetryInterval)
+
+	var proxies []*tcpProxy
+	for _, tcp := range config.TCP {
+		proxy, err := newTCPProxy(tcp)
+		if err != nil {
+			logging.Errorf("listen %s fail: %v", tcp.Listen, err)
+			os.Exit(-1)
+		}
+		go cli.WatchBalancer(ctx, tcp.Service, tcp.Zone, proxy.balancer, config.RetryInterval)
+		go proxy.serve()
+		proxies = append(proxies, proxy)
+		logging.Infof("xbus-gateway forwarding %s to %s", tcp.Listen, tcp.Service)
+	}
+
+	var server *http.Server
+	if len(config.Routes) > 0 {
+		l, err := net.Listen("tcp", config.Listen)
+		if err != nil {
+			logging.Errorf("listen %s fail: %v", config.Listen, err)
+			os.Exit(-1)
+		}
+		server = &http.Server{Handler: gateway}
+		go func() {
+			if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+				logging.Fatal(err)
+			}
+		}()
+		logging.Infof("xbus-gateway serving on %s", config.Listen)
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	for _, proxy := range proxies {
+		proxy.close()
+	}
+	if server != nil {
+		server.Shutdown(shutdownCtx)
+	}
+}
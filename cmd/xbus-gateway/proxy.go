@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/infrmods/xbus/client"
+	"github.com/infrmods/xbus/logging"
+)
+
+var errNoEndpoints = errors.New("no endpoints")
+
+type route struct {
+	config   RouteConfig
+	balancer *client.Balancer
+	proxy    *httputil.ReverseProxy
+}
+
+// gateway reverse proxy of routes, upstreams are kept updated by watching their services
+type gateway struct {
+	routes []*route
+}
+
+func newGateway(configs []RouteConfig) *gateway {
+	g := &gateway{}
+	for _, config := range configs {
+		config.Path = strings.TrimSuffix(config.Path, "/")
+		rt := &route{config: config,
+			balancer: client.NewBalancer(client.SlowStart{Window: config.SlowStart}, nil)}
+		rt.balancer.SetOutlierDetection(client.OutlierDetection{ConsecutiveFailures: config.EjectAfter})
+		rt.proxy = &httputil.ReverseProxy{
+			Director:     rt.direct,
+			Transport:    &retryTransport{route: rt, base: http.DefaultTransport},
+			ErrorHandler: rt.proxyError,
+		}
+		g.routes = append(g.routes, rt)
+	}
+	// host routes first, then the longest path
+	sort.SliceStable(g.routes, func(i, j int) bool {
+		a, b := g.routes[i].config, g.routes[j].config
+		if (a.Host != "") != (b.Host != "") {
+			return a.Host != ""
+		}
+		return len(a.Path) > len(b.Path)
+	})
+	return g
+}
+
+func (g *gateway) watch(ctx context.Context, cli *client.Client, retryInterval time.Duration) {
+	for _, rt := range g.routes {
+		go cli.WatchBalancer(ctx, rt.config.Service, rt.config.Zone, rt.balancer, retryInterval)
+	}
+}
+
+func (rt *route) match(host, path string) bool {
+	if rt.config.Host != "" && !strings.EqualFold(host, rt.config.Host) {
+		return false
+	}
+	return rt.config.Path == "" || path == rt.config.Path || strings.HasPrefix(path, rt.config.Path+"/")
+}
+
+func (g *gateway) route(r *http.Request) *route {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, rt := range g.routes {
+		if rt.match(host, r.URL.Path) {
+			return rt
+		}
+	}
+	return nil
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := g.route(r)
+	if rt == nil {
+		http.Error(w, "no route", http.StatusNotFound)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), rt.config.Timeout)
+	defer cancel()
+	rt.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// direct set scheme and path of proxied requests, the host is set per attempt by retryTransport
+func (rt *route) direct(req *http.Request) {
+	req.URL.Scheme = rt.config.Scheme
+	req.URL.Host = rt.config.Service
+	if rt.config.StripPath && rt.config.Path != "" {
+		req.URL.Path = strings.TrimPrefix(req.URL.Path, rt.config.Path)
+		if req.URL.Path == "" {
+			req.URL.Path = "/"
+		}
+		req.URL.RawPath = ""
+	}
+}
+
+func (rt *route) proxyError(w http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusBadGateway
+	switch {
+	case err == errNoEndpoints:
+		status = http.StatusServiceUnavailable
+	case req.Context().Err() == context.DeadlineExceeded:
+		status = http.StatusGatewayTimeout
+	case req.Context().Err() == context.Canceled:
+		// client gone
+		return
+	}
+	logging.Warningf("proxy %s %s to %s fail: %v", req.Method, req.URL.Path, rt.config.Service, err)
+	w.WriteHeader(status)
+}
+
+// retryTransport send requests to endpoints picked by the balancer of route,
+// retrying on other endpoints if the request fails before any response and has no body
+type retryTransport struct {
+	route *route
+	base  http.RoundTripper
+}
+
+// pick an endpoint not tried yet if possible
+func (t *retryTransport) pick(tried map[string]bool) (client.ServiceEndpoint, bool) {
+	var endpoint client.ServiceEndpoint
+	var ok bool
+	for i := 0; i < 3; i++ {
+		if endpoint, ok = t.route.balancer.Pick(); !ok || !tried[endpoint.Address] {
+			break
+		}
+	}
+	return endpoint, ok
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if req.Body == nil || req.Body == http.NoBody {
+		attempts += *t.route.config.Retries
+	}
+	tried := make(map[string]bool)
+	for i := 0; ; i++ {
+		endpoint, ok := t.pick(tried)
+		if !ok {
+			return nil, errNoEndpoints
+		}
+		tried[endpoint.Address] = true
+		u := *req.URL
+		u.Host = endpoint.Address
+		out := new(http.Request)
+		*out = *req
+		out.URL = &u
+		resp, err := t.base.RoundTrip(out)
+		if err == nil {
+			switch resp.StatusCode {
+			case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				t.route.balancer.ReportFailure(endpoint.Address)
+			default:
+				t.route.balancer.ReportSuccess(endpoint.Address)
+			}
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		t.route.balancer.ReportFailure(endpoint.Address)
+		if i+1 >= attempts {
+			return nil, err
+		}
+		logging.Warningf("proxy %s %s to %s(%s) fail, retrying: %v",
+			req.Method, req.URL.Path, t.route.config.Service, endpoint.Address, err)
+	}
+}
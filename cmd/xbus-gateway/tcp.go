@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+
+	"github.com/infrmods/xbus/client"
+	"github.com/infrmods/xbus/logging"
+)
+
+// tcpProxy forward connections of a listener to endpoints of a service
+type tcpProxy struct {
+	config   TCPConfig
+	listener net.Listener
+	balancer *client.Balancer
+
+	mu     sync.Mutex
+	active map[string]int
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+func newTCPProxy(config TCPConfig) (*tcpProxy, error) {
+	l, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return nil, err
+	}
+	p := &tcpProxy{config: config, listener: l,
+		balancer: client.NewBalancer(client.SlowStart{Window: config.SlowStart}, nil),
+		active:   make(map[string]int), conns: make(map[net.Conn]bool)}
+	p.balancer.SetOutlierDetection(client.OutlierDetection{ConsecutiveFailures: config.EjectAfter})
+	return p, nil
+}
+
+func (p *tcpProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			logging.Errorf("accept %s fail: %v", p.config.Listen, err)
+			return
+		}
+		go p.forward(conn)
+	}
+}
+
+// close stop listening and close active connections
+func (p *tcpProxy) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.listener.Close()
+	for conn := range p.conns {
+		conn.Close()
+	}
+}
+
+// pick an endpoint not tried yet, by weight or with the least active connections
+// preferring ones not ejected
+func (p *tcpProxy) pick(tried map[string]bool) (client.ServiceEndpoint, bool) {
+	if p.config.Balance != BalanceLeastConn {
+		var endpoint client.ServiceEndpoint
+		var ok bool
+		for i := 0; i < 3; i++ {
+			if endpoint, ok = p.balancer.Pick(); !ok || !tried[endpoint.Address] {
+				break
+			}
+		}
+		return endpoint, ok
+	}
+	endpoints := p.balancer.Endpoints()
+	if len(endpoints) == 0 {
+		return client.ServiceEndpoint{}, false
+	}
+	ejected := make(map[string]bool)
+	for _, address := range p.balancer.Ejected() {
+		ejected[address] = true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// start at random so ties are spread
+	start := rand.Intn(len(endpoints))
+	best := -1
+	for i := range endpoints {
+		j := (start + i) % len(endpoints)
+		if tried[endpoints[j].Address] {
+			continue
+		}
+		if best < 0 || ejected[endpoints[best].Address] && !ejected[endpoints[j].Address] ||
+			ejected[endpoints[best].Address] == ejected[endpoints[j].Address] &&
+				p.active[endpoints[j].Address] < p.active[endpoints[best].Address] {
+			best = j
+		}
+	}
+	if best < 0 {
+		best = start
+	}
+	return endpoints[best], true
+}
+
+func (p *tcpProxy) track(conn net.Conn, address string, delta int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if delta > 0 {
+		if p.closed {
+			return false
+		}
+		p.conns[conn] = true
+	} else {
+		delete(p.conns, conn)
+	}
+	if address != "" {
+		if p.active[address] += delta; p.active[address] <= 0 {
+			delete(p.active, address)
+		}
+	}
+	return true
+}
+
+func (p *tcpProxy) dial() (net.Conn, string, error) {
+	tried := make(map[string]bool)
+	for i := 0; ; i++ {
+		endpoint, ok := p.pick(tried)
+		if !ok {
+			return nil, "", errNoEndpoints
+		}
+		tried[endpoint.Address] = true
+		upstream, err := net.DialTimeout("tcp", endpoint.Address, p.config.DialTimeout)
+		if err == nil {
+			p.balancer.ReportSuccess(endpoint.Address)
+			return upstream, endpoint.Address, nil
+		}
+		p.balancer.ReportFailure(endpoint.Address)
+		if i >= *p.config.Retries {
+			return nil, "", err
+		}
+		logging.Warningf("dial %s(%s) fail, retrying: %v", p.config.Service, endpoint.Address, err)
+	}
+}
+
+func (p *tcpProxy) forward(conn net.Conn) {
+	defer conn.Close()
+	if !p.track(conn, "", 1) {
+		return
+	}
+	defer p.track(conn, "", -1)
+	upstream, address, err := p.dial()
+	if err != nil {
+		logging.Warningf("forward %s to %s fail: %v", conn.RemoteAddr(), p.config.Service, err)
+		return
+	}
+	defer upstream.Close()
+	if !p.track(upstream, address, 1) {
+		return
+	}
+	defer p.track(upstream, address, -1)
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		// half close so the other side sees eof, e.g. request then response protocols
+		if tc, ok := dst.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go pipe(upstream, conn)
+	go pipe(conn, upstream)
+	<-done
+	<-done
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/client"
+)
+
+// BrowseCmd registry browser cmd
+type BrowseCmd struct{}
+
+// Name cmd name
+func (cmd *BrowseCmd) Name() string {
+	return "browse"
+}
+
+// Synopsis cmd synopsis
+func (cmd *BrowseCmd) Synopsis() string {
+	return "browse the registry in a full-screen terminal ui"
+}
+
+// Usage cmd usage
+func (cmd *BrowseCmd) Usage() string {
+	return `browse:
+  navigate namespaces -> services -> versions -> endpoints
+  keys: up/down/j/k move, enter/right/l open, left/h/backspace back,
+        r refresh, q quit; on endpoints: enter/i lease and health,
+        d drain / undrain, u unplug (both with confirmation)
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *BrowseCmd) SetFlags(f *flag.FlagSet) {
+
+}
+
+// Execute cmd execute
+func (cmd *BrowseCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	cli, err := config.NewClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create client fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	b := &browser{ctx: ctx, client: cli, endpoint: config.Endpoint}
+	if err := b.run(); err != nil {
+		fmt.Fprintf(os.Stderr, "browse fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+type levelKind int
+
+const (
+	levelNamespaces levelKind = iota
+	levelServices
+	levelVersions
+	levelEndpoints
+)
+
+type browseItem struct {
+	label    string
+	detail   []string
+	key      string
+	service  string
+	zone     string
+	addr     string
+	priority int
+}
+
+// drainPriority the lowest failover tier of xbus, drained endpoints only get traffic
+// when no endpoints of other tiers are left
+const drainPriority = 100
+
+type browseLevel struct {
+	kind   levelKind
+	title  string
+	key    string
+	items  []browseItem
+	cursor int
+	offset int
+}
+
+type browser struct {
+	ctx      context.Context
+	client   *client.Client
+	endpoint string
+	screen   *screen
+	items    []client.ServiceItem
+	levels   []*browseLevel
+	status   string
+}
+
+// namespace of service name, the part before the first dot
+func namespace(name string) string {
+	if i := strings.Index(name, "."); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func splitServiceName(service string) (string, string) {
+	if i := strings.Index(service, ":"); i >= 0 {
+		return service[:i], service[i+1:]
+	}
+	return service, ""
+}
+
+func (b *browser) fetchAll() error {
+	const pageSize = 200
+	var items []client.ServiceItem
+	for {
+		result, err := b.client.Search(b.ctx, "", int64(len(items)), pageSize)
+		if err != nil {
+			return err
+		}
+		items = append(items, result.Services...)
+		if len(result.Services) == 0 || int64(len(items)) >= result.Total {
+			break
+		}
+	}
+	b.items = items
+	return nil
+}
+
+func sortedKeys(m map[string][]client.ServiceItem) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *browser) load(kind levelKind, key string) (*browseLevel, error) {
+	level := &browseLevel{kind: kind, key: key}
+	switch kind {
+	case levelNamespaces:
+		level.title = "namespaces"
+		groups := make(map[string][]client.ServiceItem)
+		for _, item := range b.items {
+			name, _ := splitServiceName(item.Service)
+			groups[namespace(name)] = append(groups[namespace(name)], item)
+		}
+		for _, ns := range sortedKeys(groups) {
+			level.items = append(level.items, browseItem{label: ns, key: ns,
+				detail: []string{fmt.Sprintf("namespace: %s", ns), fmt.Sprintf("service zones: %d", len(groups[ns]))}})
+		}
+	case levelServices:
+		level.title = key
+		groups := make(map[string][]client.ServiceItem)
+		for _, item := range b.items {
+			name, _ := splitServiceName(item.Service)
+			if namespace(name) == key {
+				groups[name] = append(groups[name], item)
+			}
+		}
+		for _, name := range sortedKeys(groups) {
+			versions := make(map[string]bool)
+			for _, item := range groups[name] {
+				_, version := splitServiceName(item.Service)
+				versions[version] = true
+			}
+			level.items = append(level.items, browseItem{label: name, key: name,
+				detail: []string{fmt.Sprintf("service: %s", name), fmt.Sprintf("versions: %d", len(versions))}})
+		}
+	case levelVersions:
+		level.title = key
+		groups := make(map[string][]client.ServiceItem)
+		for _, item := range b.items {
+			if name, _ := splitServiceName(item.Service); name == key {
+				groups[item.Service] = append(groups[item.Service], item)
+			}
+		}
+		for _, service := range sortedKeys(groups) {
+			_, version := splitServiceName(service)
+			detail := []string{fmt.Sprintf("service: %s", service)}
+			for _, item := range groups[service] {
+				detail = append(detail, fmt.Sprintf("zone: %s  type: %s", item.Zone, item.Type))
+			}
+			level.items = append(level.items, browseItem{label: version, key: service, service: service, detail: detail})
+		}
+	case levelEndpoints:
+		level.title = key
+		service, _, err := b.client.Query(b.ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		zones := make([]string, 0, len(service.Zones))
+		for zone := range service.Zones {
+			zones = append(zones, zone)
+		}
+		sort.Strings(zones)
+		for _, zone := range zones {
+			z := service.Zones[zone]
+			for _, endpoint := range z.Endpoints {
+				detail := []string{
+					fmt.Sprintf("address: %s", endpoint.Address),
+					fmt.Sprintf("zone: %s  type: %s  proto: %s  priority: %d", zone, z.Type, z.Proto, endpoint.Priority),
+				}
+				if z.Description != "" {
+					detail = append(detail, fmt.Sprintf("description: %s", z.Description))
+				}
+				if endpoint.Config != "" {
+					detail = append(detail, "config:")
+					for _, line := range strings.Split(endpoint.Config, "\n") {
+						detail = append(detail, "  "+line)
+					}
+				}
+				label := fmt.Sprintf("%-12s %s", zone, endpoint.Address)
+				if endpoint.Priority >= drainPriority {
+					label += "  (drained)"
+				}
+				level.items = append(level.items, browseItem{
+					label: label, key: endpoint.Address, service: key, zone: zone,
+					addr: endpoint.Address, priority: endpoint.Priority, detail: detail})
+			}
+		}
+	}
+	return level, nil
+}
+
+func (b *browser) current() *browseLevel {
+	return b.levels[len(b.levels)-1]
+}
+
+func (b *browser) push(kind levelKind, key string) {
+	level, err := b.load(kind, key)
+	if err != nil {
+		b.status = fmt.Sprintf("load %s fail: %v", key, err)
+		return
+	}
+	b.levels = append(b.levels, level)
+	b.status = ""
+}
+
+func (b *browser) refresh() {
+	b.status = ""
+	if err := b.fetchAll(); err != nil {
+		b.status = fmt.Sprintf("refresh fail: %v", err)
+		return
+	}
+	for i, old := range b.levels {
+		level, err := b.load(old.kind, old.key)
+		if err != nil {
+			b.status = fmt.Sprintf("refresh %s fail: %v", old.key, err)
+			b.levels = b.levels[:i]
+			break
+		}
+		level.cursor, level.offset = old.cursor, old.offset
+		if level.cursor >= len(level.items) {
+			level.cursor = len(level.items) - 1
+		}
+		if level.cursor < 0 {
+			level.cursor = 0
+		}
+		b.levels[i] = level
+	}
+	if len(b.levels) == 0 {
+		b.push(levelNamespaces, "")
+	}
+}
+
+const detailLines = 8
+
+func (b *browser) render(prompt string) {
+	width, height := b.screen.Size()
+	level := b.current()
+	path := make([]string, 0, len(b.levels))
+	for _, l := range b.levels {
+		path = append(path, l.title)
+	}
+	lines := []string{fmt.Sprintf("xbus %s  %s", b.endpoint, strings.Join(path, " > ")), strings.Repeat("-", width)}
+
+	listHeight := height - len(lines) - detailLines - 2
+	if listHeight < 1 {
+		listHeight = 1
+	}
+	if level.cursor < level.offset {
+		level.offset = level.cursor
+	} else if level.cursor >= level.offset+listHeight {
+		level.offset = level.cursor - listHeight + 1
+	}
+	highlight := -1
+	for i := 0; i < listHeight; i++ {
+		idx := level.offset + i
+		if idx < len(level.items) {
+			if idx == level.cursor {
+				highlight = len(lines)
+			}
+			lines = append(lines, "  "+level.items[idx].label)
+		} else if idx == 0 {
+			lines = append(lines, "  (empty)")
+		} else {
+			lines = append(lines, "")
+		}
+	}
+
+	lines = append(lines, strings.Repeat("-", width))
+	var detail []string
+	if level.cursor < len(level.items) {
+		detail = level.items[level.cursor].detail
+	}
+	for i := 0; i < detailLines; i++ {
+		if i < len(detail) {
+			lines = append(lines, detail[i])
+		} else {
+			lines = append(lines, "")
+		}
+	}
+
+	switch {
+	case prompt != "":
+		lines = append(lines, prompt)
+	case b.status != "":
+		lines = append(lines, b.status)
+	default:
+		help := "j/k move  enter open  h back  r refresh  q quit"
+		if level.kind == levelEndpoints {
+			help = "j/k move  enter/i lease & health  d drain  u unplug  h back  r refresh  q quit"
+		}
+		lines = append(lines, help)
+	}
+	b.screen.Draw(lines, highlight)
+}
+
+func (b *browser) unplug() {
+	level := b.current()
+	if level.kind != levelEndpoints || level.cursor >= len(level.items) {
+		return
+	}
+	item := level.items[level.cursor]
+	b.render(fmt.Sprintf("unplug %s from %s (%s)? [y/N]", item.addr, item.service, item.zone))
+	ev, err := b.screen.ReadKey()
+	if err != nil || ev.key != keyRune || (ev.char != 'y' && ev.char != 'Y') {
+		b.status = "canceled"
+		return
+	}
+	if err := b.client.Unplug(b.ctx, item.service, item.zone, item.addr); err != nil {
+		b.status = fmt.Sprintf("unplug %s fail: %v", item.addr, err)
+		return
+	}
+	b.refresh()
+	b.status = fmt.Sprintf("unplugged %s", item.addr)
+}
+
+// inspect show lease and health of the selected endpoint in its detail
+func (b *browser) inspect() {
+	level := b.current()
+	if level.kind != levelEndpoints || level.cursor >= len(level.items) {
+		return
+	}
+	item := &level.items[level.cursor]
+	result, err := b.client.GetEndpoint(b.ctx, item.service, item.zone, item.addr)
+	if err != nil {
+		b.status = fmt.Sprintf("get %s fail: %v", item.addr, err)
+		return
+	}
+	detail := []string{fmt.Sprintf("address: %s  zone: %s  mod revision: %d", item.addr, item.zone, result.ModRevision)}
+	if result.LeaseID == 0 {
+		detail = append(detail, "lease: none, permanent")
+	} else {
+		detail = append(detail, fmt.Sprintf("lease: %d  ttl: %ds", result.LeaseID, result.TTL))
+	}
+	if result.Endpoint.PlugTime != nil {
+		detail = append(detail, fmt.Sprintf("plugged: %s", result.Endpoint.PlugTime.Format(time.RFC3339)))
+	}
+	if result.Endpoint.InstanceID != "" {
+		detail = append(detail, fmt.Sprintf("instance: %s", result.Endpoint.InstanceID))
+	}
+	drained := ""
+	if result.Endpoint.Priority >= drainPriority {
+		drained = ", drained"
+	}
+	detail = append(detail, fmt.Sprintf("priority: %d%s", result.Endpoint.Priority, drained))
+	health := "health: stable"
+	if flapping, err := b.client.FlappingEndpoints(b.ctx, item.service); err != nil {
+		health = fmt.Sprintf("health: unknown, %v", err)
+	} else {
+		for _, f := range flapping {
+			if f.Zone == item.zone && f.Address == item.addr {
+				health = fmt.Sprintf("health: flapping, %d changes since %s", f.Changes, f.Since.Format(time.RFC3339))
+				break
+			}
+		}
+	}
+	item.detail = append(detail, health)
+	b.status = ""
+}
+
+// drain move the selected endpoint to the lowest failover tier, or back to the first one
+func (b *browser) drain() {
+	level := b.current()
+	if level.kind != levelEndpoints || level.cursor >= len(level.items) {
+		return
+	}
+	item := level.items[level.cursor]
+	action, patch := "drain", map[string]interface{}{"priority": drainPriority}
+	if item.priority >= drainPriority {
+		action, patch = "undrain", map[string]interface{}{"priority": nil}
+	}
+	b.render(fmt.Sprintf("%s %s of %s (%s)? [y/N]", action, item.addr, item.service, item.zone))
+	ev, err := b.screen.ReadKey()
+	if err != nil || ev.key != keyRune || (ev.char != 'y' && ev.char != 'Y') {
+		b.status = "canceled"
+		return
+	}
+	if _, err := b.client.PatchEndpoint(b.ctx, item.service, item.zone, item.addr, 0, patch); err != nil {
+		b.status = fmt.Sprintf("%s %s fail: %v", action, item.addr, err)
+		return
+	}
+	b.refresh()
+	b.status = fmt.Sprintf("%sed %s", action, item.addr)
+}
+
+func (b *browser) open() {
+	level := b.current()
+	if level.cursor >= len(level.items) {
+		return
+	}
+	item := level.items[level.cursor]
+	switch level.kind {
+	case levelNamespaces:
+		b.push(levelServices, item.key)
+	case levelServices:
+		b.push(levelVersions, item.key)
+	case levelVersions:
+		b.push(levelEndpoints, item.key)
+	case levelEndpoints:
+		b.inspect()
+	}
+}
+
+func (b *browser) run() error {
+	if err := b.fetchAll(); err != nil {
+		return err
+	}
+	b.push(levelNamespaces, "")
+	screen, err := openScreen()
+	if err != nil {
+		return err
+	}
+	b.screen = screen
+	defer screen.Close()
+
+	for {
+		b.render("")
+		ev, err := screen.ReadKey()
+		if err != nil {
+			return err
+		}
+		level := b.current()
+		_, height := screen.Size()
+		page := height - detailLines - 4
+		if ev.key == keyRune {
+			switch ev.char {
+			case 'q', 0x03:
+				return nil
+			case 'j':
+				ev.key = keyDown
+			case 'k':
+				ev.key = keyUp
+			case 'l':
+				ev.key = keyRight
+			case 'h':
+				ev.key = keyLeft
+			case 'r':
+				b.refresh()
+			case 'i':
+				b.inspect()
+			case 'd':
+				b.drain()
+			case 'u':
+				b.unplug()
+			}
+		}
+		switch ev.key {
+		case keyUp:
+			if level.cursor > 0 {
+				level.cursor--
+			}
+		case keyDown:
+			if level.cursor < len(level.items)-1 {
+				level.cursor++
+			}
+		case keyPageUp:
+			level.cursor -= page
+			if level.cursor < 0 {
+				level.cursor = 0
+			}
+		case keyPageDown:
+			level.cursor += page
+			if level.cursor > len(level.items)-1 {
+				level.cursor = len(level.items) - 1
+			}
+			if level.cursor < 0 {
+				level.cursor = 0
+			}
+		case keyEnter, keyRight:
+			b.open()
+		case keyLeft:
+			if len(b.levels) > 1 {
+				b.levels = b.levels[:len(b.levels)-1]
+				b.status = ""
+			}
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/client"
+	"gopkg.in/yaml.v2"
+)
+
+// SupportBundleCmd support bundle cmd
+type SupportBundleCmd struct {
+	service string
+	window  time.Duration
+	output  string
+	logs    string
+}
+
+// Name cmd name
+func (cmd *SupportBundleCmd) Name() string {
+	return "support-bundle"
+}
+
+// Synopsis cmd synopsis
+func (cmd *SupportBundleCmd) Synopsis() string {
+	return "collect registry state of a service into an archive for bug reports"
+}
+
+// Usage cmd usage
+func (cmd *SupportBundleCmd) Usage() string {
+	return `support-bundle -service <service> [-window 2h] [-logs a.log,b.log] [-o bundle.tar.gz]:
+  collect service zones and endpoints, zone checksums, server metrics,
+  xbusctl config and log lines of the service within window
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *SupportBundleCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.service, "service", "", "service name or prefix, e.g. payments.core")
+	f.DurationVar(&cmd.window, "window", 2*time.Hour, "time window of log lines")
+	f.StringVar(&cmd.output, "o", "", "output file, default xbus-support-<service>-<time>.tar.gz")
+	f.StringVar(&cmd.logs, "logs", "", "comma separated server log files to excerpt")
+}
+
+// bundleManifest manifest.json of the bundle
+type bundleManifest struct {
+	Service     string            `json:"service"`
+	Window      string            `json:"window"`
+	GeneratedAt time.Time         `json:"generated_at"`
+	Endpoint    string            `json:"endpoint"`
+	Files       []string          `json:"files"`
+	Errors      map[string]string `json:"errors,omitempty"`
+	Unavailable map[string]string `json:"unavailable"`
+}
+
+type bundleWriter struct {
+	tw       *tar.Writer
+	now      time.Time
+	manifest *bundleManifest
+}
+
+func (w *bundleWriter) add(name string, data []byte) error {
+	if err := w.tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: w.now}); err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return err
+	}
+	w.manifest.Files = append(w.manifest.Files, name)
+	return nil
+}
+
+func (w *bundleWriter) addJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return w.add(name, data)
+}
+
+// fail record a failed item, the bundle goes on without it
+func (w *bundleWriter) fail(item string, err error) {
+	w.manifest.Errors[item] = err.Error()
+}
+
+// Execute cmd execute
+func (cmd *SupportBundleCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	if cmd.service == "" {
+		fmt.Fprintln(os.Stderr, "missing -service")
+		return subcommands.ExitUsageError
+	}
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	cli, err := config.NewClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create client fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	now := time.Now()
+	output := cmd.output
+	if output == "" {
+		output = fmt.Sprintf("xbus-support-%s-%s.tar.gz", cmd.service, now.Format("20060102-150405"))
+	}
+	file, err := os.Create(output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create %s fail: %v\n", output, err)
+		return subcommands.ExitFailure
+	}
+	defer file.Close()
+	gw := gzip.NewWriter(file)
+	w := &bundleWriter{tw: tar.NewWriter(gw), now: now, manifest: &bundleManifest{
+		Service:     cmd.service,
+		Window:      cmd.window.String(),
+		GeneratedAt: now,
+		Endpoint:    config.Endpoint,
+		Errors:      make(map[string]string),
+		Unavailable: map[string]string{
+			"audit_history":      "not recorded by the server",
+			"health_transitions": "not recorded by the server",
+		},
+	}}
+
+	if err := cmd.collect(ctx, config, cli, w); err != nil {
+		fmt.Fprintf(os.Stderr, "write bundle fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	if err := w.addJSON("manifest.json", w.manifest); err != nil {
+		fmt.Fprintf(os.Stderr, "write bundle fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	if err := w.tw.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "write bundle fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	if err := gw.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "write bundle fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	for item, msg := range w.manifest.Errors {
+		fmt.Fprintf(os.Stderr, "skipped %s: %s\n", item, msg)
+	}
+	fmt.Println(output)
+	return subcommands.ExitSuccess
+}
+
+// collect write bundle items, only archive errors are returned
+func (cmd *SupportBundleCmd) collect(ctx context.Context, config *CtlConfig, cli *client.Client, w *bundleWriter) error {
+	// the config references key files, their contents are never bundled
+	configData, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := w.add("config.yaml", configData); err != nil {
+		return err
+	}
+
+	var items []client.ServiceItem
+	for skip := int64(0); ; {
+		result, err := cli.Search(ctx, cmd.service, skip, 200)
+		if err != nil {
+			w.fail("services.json", err)
+			items = nil
+			break
+		}
+		for _, item := range result.Services {
+			if strings.HasPrefix(item.Service, cmd.service) {
+				items = append(items, item)
+			}
+		}
+		skip += int64(len(result.Services))
+		if len(result.Services) == 0 || skip >= result.Total {
+			if err := w.addJSON("services.json", items); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if seen[item.Service] {
+			continue
+		}
+		seen[item.Service] = true
+		service, revision, err := cli.Query(ctx, item.Service)
+		if err != nil {
+			w.fail("query/"+item.Service, err)
+		} else if err := w.addJSON("query/"+item.Service+".json",
+			client.QueryResult{Service: service, Revision: revision}); err != nil {
+			return err
+		}
+		path := "/api/v1/service-checksums/" + url.PathEscape(item.Service)
+		if data, err := cmd.fetch(ctx, config, path); err != nil {
+			w.fail("checksums/"+item.Service, err)
+		} else if err := w.add("checksums/"+item.Service+".json", data); err != nil {
+			return err
+		}
+	}
+
+	if data, err := cmd.fetch(ctx, config, "/metrics"); err != nil {
+		w.fail("metrics.txt", err)
+	} else if err := w.add("metrics.txt", data); err != nil {
+		return err
+	}
+
+	if cmd.logs == "" {
+		w.manifest.Unavailable["server_logs"] = "no -logs given"
+	}
+	for _, path := range strings.Split(cmd.logs, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		name := "logs/" + strings.Replace(strings.TrimPrefix(path, "/"), "/", "_", -1)
+		if data, err := excerptLog(path, cmd.service, w.now.Add(-cmd.window)); err != nil {
+			w.fail(name, err)
+		} else if err := w.add(name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch raw response of path, e.g. apis the client doesn't wrap
+func (cmd *SupportBundleCmd) fetch(ctx context.Context, config *CtlConfig, path string) ([]byte, error) {
+	tlsConfig, err := client.LoadTLSConfig(config.CertFile, config.KeyFile, config.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(config.Endpoint, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if config.DevApp != "" {
+		req.Header.Set("Dev-App", config.DevApp)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	httpClient := http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+var logTimeFormats = []struct {
+	r     *regexp.Regexp
+	parse func(m []string) (time.Time, error)
+}{
+	{regexp.MustCompile(`(\d{4}-\d{2}-\d{2})[T ](\d{2}:\d{2}:\d{2})`), func(m []string) (time.Time, error) {
+		return time.ParseInLocation("2006-01-02T15:04:05", m[1]+"T"+m[2], time.Local)
+	}},
+	// glog, without year
+	{regexp.MustCompile(`^[IWEF](\d{4} \d{2}:\d{2}:\d{2})`), func(m []string) (time.Time, error) {
+		return time.ParseInLocation("0102 15:04:05", m[1], time.Local)
+	}},
+}
+
+// logLineTime time of a log line, zero if it has none
+func logLineTime(line string, now time.Time) time.Time {
+	if len(line) > 64 {
+		line = line[:64]
+	}
+	for _, format := range logTimeFormats {
+		m := format.r.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		t, err := format.parse(m)
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now) {
+				t = t.AddDate(-1, 0, 0)
+			}
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+const maxExcerptLines = 10000
+
+// excerptLog lines mentioning service since, lines without time follow the previous line
+func excerptLog(path, service string, since time.Time) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	now := time.Now()
+	var lines []string
+	inWindow := false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if t := logLineTime(line, now); !t.IsZero() {
+			inWindow = !t.Before(since)
+		}
+		if inWindow && strings.Contains(line, service) {
+			lines = append(lines, line)
+			if len(lines) > maxExcerptLines {
+				lines = lines[1:]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/subcommands"
+)
+
+// ConfigCmd config get/set cmd
+type ConfigCmd struct{}
+
+// Name cmd name
+func (cmd *ConfigCmd) Name() string {
+	return "config"
+}
+
+// Synopsis cmd synopsis
+func (cmd *ConfigCmd) Synopsis() string {
+	return "get or set a config"
+}
+
+// Usage cmd usage
+func (cmd *ConfigCmd) Usage() string {
+	return `config get <name>:
+  print value of config
+config set [-tag t] [-remark r] [-version n] <name> <value|@file|->:
+  set config to value, contents of file or stdin,
+  -version rejects the set if the config was changed since
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *ConfigCmd) SetFlags(f *flag.FlagSet) {
+
+}
+
+type configItem struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Version int64  `json:"version"`
+}
+
+type configResult struct {
+	Config   *configItem `json:"config"`
+	Revision int64       `json:"revision"`
+}
+
+// Execute cmd execute
+func (cmd *ConfigCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	switch f.Arg(0) {
+	case "get":
+		if f.NArg() == 2 {
+			return cmd.get(ctx, f.Arg(1))
+		}
+	case "set":
+		return cmd.set(ctx, f.Args()[1:])
+	}
+	fmt.Fprint(os.Stderr, cmd.Usage())
+	return subcommands.ExitUsageError
+}
+
+func (cmd *ConfigCmd) get(ctx context.Context, name string) subcommands.ExitStatus {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	var result configResult
+	if err := callAPI(ctx, config, http.MethodGet, "/api/configs/"+url.PathEscape(name), nil, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "get config fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	if jsonOutput() {
+		printJSON(result)
+		return subcommands.ExitSuccess
+	}
+	printTable([]string{"NAME", "VERSION", "REVISION"},
+		[][]string{{result.Config.Name, strconv.FormatInt(result.Config.Version, 10), strconv.FormatInt(result.Revision, 10)}})
+	fmt.Println(result.Config.Value)
+	return subcommands.ExitSuccess
+}
+
+// readValue value of arg, @file reads file, - reads stdin
+func readValue(arg string) (string, error) {
+	if arg == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		return string(data), err
+	}
+	if strings.HasPrefix(arg, "@") {
+		data, err := ioutil.ReadFile(arg[1:])
+		return string(data), err
+	}
+	return arg, nil
+}
+
+func (cmd *ConfigCmd) set(ctx context.Context, args []string) subcommands.ExitStatus {
+	f := flag.NewFlagSet("config set", flag.ContinueOnError)
+	tag := f.String("tag", "", "config tag")
+	remark := f.String("remark", "", "change remark")
+	version := f.Int64("version", 0, "expected current version, 0 is any")
+	if err := f.Parse(args); err != nil {
+		return subcommands.ExitUsageError
+	}
+	if f.NArg() != 2 {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	name, arg := f.Arg(0), f.Arg(1)
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	value, err := readValue(arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read value fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	form := url.Values{"value": {value}, "tag": {*tag}, "remark": {*remark}}
+	if *version > 0 {
+		form.Set("version", strconv.FormatInt(*version, 10))
+	}
+	var result struct {
+		Revision int64 `json:"revision"`
+	}
+	if err := callAPI(ctx, config, http.MethodPut, "/api/configs/"+url.PathEscape(name), form, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "set config fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	if jsonOutput() {
+		printJSON(result)
+	} else {
+		fmt.Printf("set %s, revision %d\n", name, result.Revision)
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/client"
+	"gopkg.in/yaml.v2"
+)
+
+// CtlConfig xbusctl config
+type CtlConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+	DevApp   string `yaml:"dev_app"`
+}
+
+func defaultConfigPath() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".xbusctl.yaml")
+	}
+	return ".xbusctl.yaml"
+}
+
+var (
+	cfgPath  = flag.String("config", defaultConfigPath(), "config file path")
+	endpoint = flag.String("endpoint", "", "xbus api endpoint, e.g. https://xbus:4433")
+	certFile = flag.String("cert", "", "app cert file")
+	keyFile  = flag.String("key", "", "app key file")
+	caFile   = flag.String("cacert", "", "ca cert file")
+	devApp   = flag.String("dev-app", "", "dev app name")
+)
+
+func absPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// loadConfig config file, overridden by XBUS_* env and flags
+func loadConfig() (*CtlConfig, error) {
+	var config CtlConfig
+	if data, err := ioutil.ReadFile(*cfgPath); err == nil {
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("invalid config file(%s): %v", *cfgPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, item := range []struct {
+		value *string
+		env   string
+		flag  string
+	}{
+		{&config.Endpoint, client.EnvEndpoint, *endpoint},
+		{&config.CertFile, client.EnvCertFile, *certFile},
+		{&config.KeyFile, client.EnvKeyFile, *keyFile},
+		{&config.CAFile, client.EnvCAFile, *caFile},
+		{&config.DevApp, client.EnvDevApp, *devApp},
+	} {
+		if v := os.Getenv(item.env); v != "" {
+			*item.value = v
+		}
+		if item.flag != "" {
+			*item.value = item.flag
+		}
+	}
+	config.CertFile = absPath(config.CertFile)
+	config.KeyFile = absPath(config.KeyFile)
+	config.CAFile = absPath(config.CAFile)
+	return &config, nil
+}
+
+// Env config as XBUS_* environment variables
+func (config *CtlConfig) Env() []string {
+	return []string{
+		client.EnvEndpoint + "=" + config.Endpoint,
+		client.EnvCertFile + "=" + config.CertFile,
+		client.EnvKeyFile + "=" + config.KeyFile,
+		client.EnvCAFile + "=" + config.CAFile,
+		client.EnvDevApp + "=" + config.DevApp,
+	}
+}
+
+// NewClient new authenticated client
+func (config *CtlConfig) NewClient() (*client.Client, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("missing endpoint")
+	}
+	tlsConfig, err := client.LoadTLSConfig(config.CertFile, config.KeyFile, config.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewClient(client.Config{Endpoint: config.Endpoint, TLSConfig: tlsConfig, DevApp: config.DevApp}), nil
+}
+
+var builtinCmds = make(map[string]bool)
+
+func register(cmd subcommands.Command, group string) {
+	builtinCmds[cmd.Name()] = true
+	subcommands.Register(cmd, group)
+}
+
+func main() {
+	register(subcommands.HelpCommand(), "")
+	register(subcommands.FlagsCommand(), "")
+	register(subcommands.CommandsCommand(), "")
+	register(&PluginsCmd{}, "")
+	register(&BrowseCmd{}, "")
+	register(&SupportBundleCmd{}, "")
+	register(&PlugCmd{}, "service")
+	register(&UnplugCmd{}, "service")
+	register(&QueryCmd{}, "service")
+	register(&EndpointCmd{}, "service")
+	register(&WatchCmd{}, "service")
+	register(&ListCmd{}, "service")
+	register(&UpstreamsCmd{}, "service")
+	register(&RenderCmd{}, "service")
+	register(&ConfigCmd{}, "config")
+
+	flag.Parse()
+	if name := flag.Arg(0); name != "" && !builtinCmds[name] {
+		if path, err := findPlugin(name); err == nil {
+			os.Exit(runPlugin(path, flag.Args()[1:]))
+		}
+	}
+	os.Exit(int(subcommands.Execute(context.Background())))
+}
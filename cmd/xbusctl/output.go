@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/client"
+)
+
+var outputFormat = flag.String("output", "table", "output format of commands: table or json")
+
+func jsonOutput() bool {
+	return *outputFormat == "json"
+}
+
+// printJSON print v as a json line
+func printJSON(v interface{}) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+// printTable print rows aligned under header
+func printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// newCtlClient load config and create client, failures are printed
+func newCtlClient() (*CtlConfig, *client.Client, subcommands.ExitStatus) {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config fail: %v\n", err)
+		return nil, nil, subcommands.ExitFailure
+	}
+	cli, err := config.NewClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create client fail: %v\n", err)
+		return nil, nil, subcommands.ExitFailure
+	}
+	return config, cli, subcommands.ExitSuccess
+}
+
+type apiResponse struct {
+	Ok     bool            `json:"ok"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *client.Error   `json:"error,omitempty"`
+}
+
+// callAPI call xbus api the client doesn't wrap, e.g. configs, decoding result
+func callAPI(ctx context.Context, config *CtlConfig, method, path string, form url.Values, result interface{}) error {
+	tlsConfig, err := client.LoadTLSConfig(config.CertFile, config.KeyFile, config.CAFile)
+	if err != nil {
+		return err
+	}
+	var body *strings.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	} else {
+		body = strings.NewReader("")
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(config.Endpoint, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if config.DevApp != "" {
+		req.Header.Set("Dev-App", config.DevApp)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	httpClient := http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var r apiResponse
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("invalid response(status: %d): %v", resp.StatusCode, err)
+	}
+	if !r.Ok {
+		if r.Error == nil {
+			return &client.Error{Code: client.EcodeSystemError, Message: "missing error"}
+		}
+		return r.Error
+	}
+	if result != nil && len(r.Result) > 0 {
+		return json.Unmarshal(r.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/subcommands"
+)
+
+const pluginPrefix = "xbusctl-"
+
+// findPlugin find xbusctl-<name> in PATH
+func findPlugin(name string) (string, error) {
+	if strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid plugin name: %s", name)
+	}
+	return exec.LookPath(pluginPrefix + name)
+}
+
+// listPlugins plugin name => path, first one in PATH wins
+func listPlugins() map[string]string {
+	plugins := make(map[string]string)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasPrefix(file.Name(), pluginPrefix) || file.Mode()&0111 == 0 {
+				continue
+			}
+			name := strings.TrimPrefix(file.Name(), pluginPrefix)
+			if _, ok := plugins[name]; !ok && !builtinCmds[name] {
+				plugins[name] = filepath.Join(dir, file.Name())
+			}
+		}
+	}
+	return plugins
+}
+
+// runPlugin run plugin with the resolved client config in XBUS_* env,
+// returns its exit code
+func runPlugin(path string, args []string) int {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config fail: %v\n", err)
+		return 1
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), config.Env()...)
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if code := exitErr.ExitCode(); code >= 0 {
+				return code
+			}
+		}
+		fmt.Fprintf(os.Stderr, "run plugin %s fail: %v\n", path, err)
+		return 1
+	}
+	return 0
+}
+
+// PluginsCmd list plugins cmd
+type PluginsCmd struct{}
+
+// Name cmd name
+func (cmd *PluginsCmd) Name() string {
+	return "plugins"
+}
+
+// Synopsis cmd synopsis
+func (cmd *PluginsCmd) Synopsis() string {
+	return "list xbusctl-* plugins in PATH"
+}
+
+// Usage cmd usage
+func (cmd *PluginsCmd) Usage() string {
+	return `plugins:
+  "xbusctl foo args..." runs "xbusctl-foo args..." found in PATH, with the
+  client config passed as XBUS_ENDPOINT, XBUS_CERT_FILE, XBUS_KEY_FILE,
+  XBUS_CA_FILE and XBUS_DEV_APP; Go plugins can use client.ConfigFromEnv.
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *PluginsCmd) SetFlags(f *flag.FlagSet) {
+
+}
+
+// Execute cmd execute
+func (cmd *PluginsCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	plugins := listPlugins()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s\t%s\n", name, plugins[name])
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/client"
+)
+
+const (
+	// renderWait changes within it are rendered at once
+	renderWait = 200 * time.Millisecond
+	// renderRetryInterval retry interval of failed watches
+	renderRetryInterval = 5 * time.Second
+	// configWatchTimeout seconds, within the timeout of callAPI
+	configWatchTimeout = 5
+)
+
+// renderer render tmpl with data to out, running hook after out changed; services and
+// configs referenced by tmpl are watched from the first render on, out is written once
+// all of them are fetched
+type renderer struct {
+	client *client.Client
+	config *CtlConfig
+	tmpl   *template.Template
+	data   interface{}
+	out    string
+	hook   string
+
+	// watch start watches of missing services and configs, or fetch them if nil
+	watch   context.Context
+	missing []func(ctx context.Context) error
+
+	mu       sync.Mutex
+	services map[string]*client.Service
+	configs  map[string]*string
+	changed  chan struct{}
+}
+
+// renderFuncs funcs of templates:
+// service "name" the service, endpoints "name" ["zone"] its endpoints of zone (default)
+// by address, config "name" value of config, name "s" s as an identifier, json "v"
+func renderFuncs(r *renderer) template.FuncMap {
+	return template.FuncMap{
+		"service": func(name string) *client.Service {
+			return r.service(name)
+		},
+		"endpoints": func(name string, zone ...string) []client.ServiceEndpoint {
+			z := "default"
+			if len(zone) > 0 {
+				z = zone[0]
+			}
+			return sortedEndpoints(r.service(name), z)
+		},
+		"config": func(name string) string {
+			return r.configValue(name)
+		},
+		"name": upstreamName,
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}
+}
+
+// parseRenderTemplate parse template text for a renderer, funcs are bound by newRenderer
+func parseRenderTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(renderFuncs(nil)).Parse(text)
+}
+
+func newRenderer(cli *client.Client, config *CtlConfig, tmpl *template.Template, data interface{}, out, hook string) *renderer {
+	r := &renderer{client: cli, config: config, data: data, out: out, hook: hook,
+		services: make(map[string]*client.Service), configs: make(map[string]*string),
+		changed: make(chan struct{}, 1)}
+	r.tmpl = tmpl.Funcs(renderFuncs(r))
+	return r
+}
+
+// sortedEndpoints endpoints of zone of service by address, so renders are stable
+func sortedEndpoints(service *client.Service, zone string) []client.ServiceEndpoint {
+	if service == nil || service.Zones[zone] == nil {
+		return nil
+	}
+	endpoints := append([]client.ServiceEndpoint(nil), service.Zones[zone].Endpoints...)
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Address < endpoints[j].Address })
+	return endpoints
+}
+
+func (r *renderer) notify() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+func emptyService(name string) *client.Service {
+	return &client.Service{Service: name, Zones: map[string]*client.ServiceZone{}}
+}
+
+func (r *renderer) setService(name string, service *client.Service) {
+	r.mu.Lock()
+	r.services[name] = service
+	r.mu.Unlock()
+}
+
+// watchService watch service, rendered as an empty one while not found
+func (r *renderer) watchService(ctx context.Context, name string) {
+	if _, _, err := r.client.Query(ctx, name); client.IsErrCode(err, client.EcodeNotFound) {
+		r.setService(name, emptyService(name))
+		r.notify()
+	}
+	r.client.WatchLoop(ctx, name, renderRetryInterval, func(service *client.Service) {
+		r.setService(name, service)
+		r.notify()
+	})
+}
+
+func (r *renderer) service(name string) *client.Service {
+	r.mu.Lock()
+	service, seen := r.services[name]
+	if !seen {
+		// placeholder until fetched, so it's fetched once
+		r.services[name] = nil
+	}
+	r.mu.Unlock()
+	if service != nil {
+		return service
+	}
+	r.missing = append(r.missing, func(ctx context.Context) error {
+		if seen {
+			return nil
+		}
+		if r.watch != nil {
+			go r.watchService(r.watch, name)
+			return nil
+		}
+		service, _, err := r.client.Query(ctx, name)
+		if client.IsErrCode(err, client.EcodeNotFound) {
+			service, err = emptyService(name), nil
+		}
+		if err != nil {
+			return fmt.Errorf("query %s fail: %v", name, err)
+		}
+		r.setService(name, service)
+		return nil
+	})
+	return nil
+}
+
+// getConfig config value and revision, empty if not found
+func (r *renderer) getConfig(ctx context.Context, name string, revision int64) (string, int64, error) {
+	path := "/api/configs/" + url.PathEscape(name)
+	if revision > 0 {
+		path += fmt.Sprintf("?watch=true&revision=%d&timeout=%d", revision, configWatchTimeout)
+	}
+	var result configResult
+	if err := callAPI(ctx, r.config, http.MethodGet, path, nil, &result); err != nil {
+		return "", 0, err
+	}
+	if result.Config == nil {
+		return "", result.Revision, nil
+	}
+	return result.Config.Value, result.Revision, nil
+}
+
+// watchConfig watch config, rendered as empty while not found
+func (r *renderer) watchConfig(ctx context.Context, name string) {
+	var revision int64
+	for ctx.Err() == nil {
+		value, rev, err := r.getConfig(ctx, name, revision)
+		if err == nil {
+			revision = rev + 1
+			r.setConfig(name, value)
+			r.notify()
+			continue
+		}
+		if revision > 0 && (client.IsErrCode(err, client.EcodeEtcdWatchFailed) ||
+			client.IsErrCode(err, client.EcodeDeadlineExceeded)) {
+			// nothing changed, resume
+			continue
+		}
+		if client.IsErrCode(err, client.EcodeNotFound) {
+			if r.setConfig(name, "") {
+				r.notify()
+			}
+		} else if ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "watch config %s fail, retry: %v\n", name, err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(renderRetryInterval):
+		}
+		revision = 0
+	}
+}
+
+// setConfig set value of config, false if unchanged
+func (r *renderer) setConfig(name, value string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev := r.configs[name]; prev != nil && *prev == value {
+		return false
+	}
+	r.configs[name] = &value
+	return true
+}
+
+func (r *renderer) configValue(name string) string {
+	r.mu.Lock()
+	value, seen := r.configs[name]
+	if !seen {
+		r.configs[name] = nil
+	}
+	r.mu.Unlock()
+	if value != nil {
+		return *value
+	}
+	r.missing = append(r.missing, func(ctx context.Context) error {
+		if seen {
+			return nil
+		}
+		if r.watch != nil {
+			go r.watchConfig(r.watch, name)
+			return nil
+		}
+		value, _, err := r.getConfig(ctx, name, 0)
+		if client.IsErrCode(err, client.EcodeNotFound) {
+			value, err = "", nil
+		}
+		if err != nil {
+			return fmt.Errorf("get config %s fail: %v", name, err)
+		}
+		r.setConfig(name, value)
+		return nil
+	})
+	return ""
+}
+
+// render execute the template, false if services or configs referenced are missing
+func (r *renderer) render(ctx context.Context) ([]byte, bool, error) {
+	r.missing = nil
+	var buf bytes.Buffer
+	err := r.tmpl.Execute(&buf, r.data)
+	missing := r.missing
+	r.missing = nil
+	for _, fetch := range missing {
+		if err := fetch(ctx); err != nil {
+			return nil, false, err
+		}
+	}
+	if len(missing) > 0 {
+		// errors of missing ones are expected, e.g. of nil services
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// write out atomically and run hook if its content changed, stdout if out is empty
+func (r *renderer) write(content []byte) error {
+	if r.out == "" {
+		_, err := os.Stdout.Write(content)
+		return err
+	}
+	if prev, err := ioutil.ReadFile(r.out); err == nil && bytes.Equal(prev, content) {
+		return nil
+	}
+	tmp := filepath.Join(filepath.Dir(r.out), "."+filepath.Base(r.out)+".tmp")
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.out); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s rendered %s\n", time.Now().Format(time.RFC3339), r.out)
+	if r.hook == "" {
+		return nil
+	}
+	cmd := exec.Command("sh", "-c", r.hook)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("run %s fail: %v", r.hook, err)
+	}
+	return nil
+}
+
+// maxRenderPasses renders of once to fetch services and configs referenced
+// depending on others, e.g. a config naming a service
+const maxRenderPasses = 10
+
+// once fetch what tmpl references and render once
+func (r *renderer) once(ctx context.Context) error {
+	for i := 0; i < maxRenderPasses; i++ {
+		content, ok, err := r.render(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return r.write(content)
+		}
+	}
+	return fmt.Errorf("render %s: too many nested references", r.tmpl.Name())
+}
+
+// run render and watch what tmpl references, rendering again on changes until ctx done
+func (r *renderer) run(ctx context.Context) {
+	r.watch = ctx
+	r.notify()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.changed:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(renderWait):
+		}
+		content, ok, err := r.render(ctx)
+		if err == nil && ok {
+			err = r.write(content)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "render %s fail: %v\n", r.tmpl.Name(), err)
+		}
+	}
+}
+
+// RenderCmd render cmd
+type RenderCmd struct {
+	once bool
+}
+
+// Name cmd name
+func (cmd *RenderCmd) Name() string {
+	return "render"
+}
+
+// Synopsis cmd synopsis
+func (cmd *RenderCmd) Synopsis() string {
+	return "render files from templates of services and configs"
+}
+
+// Usage cmd usage
+func (cmd *RenderCmd) Usage() string {
+	return `render [-once] <template>:<out>[:<hook>]...:
+  render each go template to out (stdout if empty), then again whenever the services
+  or configs it references change until interrupted, running hook by sh after out changed;
+  funcs: service "name", endpoints "name" ["zone"], config "name", name "s", json v,
+  -once renders once and exits
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *RenderCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&cmd.once, "once", false, "render once and exit")
+}
+
+// Execute cmd execute
+func (cmd *RenderCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	if f.NArg() == 0 {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	config, cli, status := newCtlClient()
+	if status != subcommands.ExitSuccess {
+		return status
+	}
+	var renderers []*renderer
+	for _, arg := range f.Args() {
+		parts := strings.SplitN(arg, ":", 3)
+		for len(parts) < 3 {
+			parts = append(parts, "")
+		}
+		text, err := ioutil.ReadFile(parts[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read template fail: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		tmpl, err := parseRenderTemplate(parts[0], string(text))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "parse template fail: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		renderers = append(renderers, newRenderer(cli, config, tmpl, nil, parts[1], parts[2]))
+	}
+	if cmd.once {
+		for _, r := range renderers {
+			if err := r.once(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "render %s fail: %v\n", r.tmpl.Name(), err)
+				return subcommands.ExitFailure
+			}
+		}
+		return subcommands.ExitSuccess
+	}
+	runRenderers(ctx, renderers...)
+	return subcommands.ExitSuccess
+}
+
+// runRenderers run renderers until interrupted
+func runRenderers(ctx context.Context, renderers ...*renderer) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		cancel()
+	}()
+	var wg sync.WaitGroup
+	for _, r := range renderers {
+		wg.Add(1)
+		go func(r *renderer) {
+			defer wg.Done()
+			r.run(ctx)
+		}(r)
+	}
+	wg.Wait()
+}
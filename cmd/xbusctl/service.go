@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/client"
+)
+
+// PlugCmd plug cmd
+type PlugCmd struct {
+	zone     string
+	typ      string
+	proto    string
+	config   string
+	ttl      time.Duration
+	static   bool
+	dryRun   bool
+	priority int
+}
+
+// Name cmd name
+func (cmd *PlugCmd) Name() string {
+	return "plug"
+}
+
+// Synopsis cmd synopsis
+func (cmd *PlugCmd) Synopsis() string {
+	return "plug an endpoint into a service"
+}
+
+// Usage cmd usage
+func (cmd *PlugCmd) Usage() string {
+	return `plug [-zone default] [-ttl 60s] [-static] [-priority 0] <service> <address>:
+  plug address into service, kept alive until interrupted then unplugged,
+  -static plugs a static endpoint without lease (admin only),
+  -dry-run prints the changes it would make without plugging
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *PlugCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.zone, "zone", "default", "service zone")
+	f.StringVar(&cmd.typ, "type", "", "service type")
+	f.StringVar(&cmd.proto, "proto", "", "service proto")
+	f.StringVar(&cmd.config, "endpoint-config", "", "endpoint config")
+	f.DurationVar(&cmd.ttl, "ttl", 60*time.Second, "lease ttl")
+	f.BoolVar(&cmd.static, "static", false, "plug a static endpoint")
+	f.IntVar(&cmd.priority, "priority", 0, "failover tier, 0 for primaries")
+	f.BoolVar(&cmd.dryRun, "dry-run", false, "only print the changes")
+}
+
+// Execute cmd execute
+func (cmd *PlugCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 2 {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	config, cli, status := newCtlClient()
+	if status != subcommands.ExitSuccess {
+		return status
+	}
+	descs := []client.ServiceDesc{{Service: f.Arg(0), Zone: cmd.zone, Type: cmd.typ, Proto: cmd.proto}}
+	endpoint := client.ServiceEndpoint{Address: f.Arg(1), Config: cmd.config, Priority: cmd.priority}
+
+	if cmd.static || cmd.dryRun {
+		descsData, _ := json.Marshal(descs)
+		endpointData, _ := json.Marshal(endpoint)
+		form := url.Values{"descs": {string(descsData)}, "endpoint": {string(endpointData)}}
+		path := "/api/v1/static-endpoints"
+		if !cmd.static {
+			path = "/api/v1/services"
+			form.Set("ttl", strconv.FormatInt(int64(cmd.ttl.Seconds()), 10))
+		}
+		if !cmd.dryRun {
+			if err := callAPI(ctx, config, http.MethodPost, path, form, nil); err != nil {
+				fmt.Fprintf(os.Stderr, "plug fail: %v\n", err)
+				return subcommands.ExitFailure
+			}
+			return subcommands.ExitSuccess
+		}
+		form.Set("dry_run", "true")
+		var dryRun dryRunResult
+		if err := callAPI(ctx, config, http.MethodPost, path, form, &dryRun); err != nil {
+			fmt.Fprintf(os.Stderr, "plug fail: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		printDryRun(&dryRun)
+		return subcommands.ExitSuccess
+	}
+
+	reg, err := cli.Plug(ctx, cmd.ttl, descs, endpoint)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "plug fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	reg.OnError = func(err error) {
+		fmt.Fprintf(os.Stderr, "keepalive fail: %v\n", err)
+	}
+	if jsonOutput() {
+		printJSON(map[string]interface{}{"service": f.Arg(0), "zone": cmd.zone, "address": f.Arg(1), "lease_id": reg.LeaseID()})
+	} else {
+		fmt.Printf("plugged %s into %s (%s), lease %d, ctrl-c to unplug\n", f.Arg(1), f.Arg(0), cmd.zone, reg.LeaseID())
+	}
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	if err := reg.Close(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "unplug fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// UnplugCmd unplug cmd
+type UnplugCmd struct {
+	zone   string
+	dryRun bool
+}
+
+// Name cmd name
+func (cmd *UnplugCmd) Name() string {
+	return "unplug"
+}
+
+// Synopsis cmd synopsis
+func (cmd *UnplugCmd) Synopsis() string {
+	return "unplug an endpoint from a service"
+}
+
+// Usage cmd usage
+func (cmd *UnplugCmd) Usage() string {
+	return `unplug [-zone default] [-dry-run] <service> <address>:
+  unplug address from service zone,
+  -dry-run prints the changes it would make without unplugging
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *UnplugCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.zone, "zone", "default", "service zone")
+	f.BoolVar(&cmd.dryRun, "dry-run", false, "only print the changes")
+}
+
+// Execute cmd execute
+func (cmd *UnplugCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 2 {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	config, cli, status := newCtlClient()
+	if status != subcommands.ExitSuccess {
+		return status
+	}
+	if cmd.dryRun {
+		path := fmt.Sprintf("/api/v1/services/%s/%s/%s?dry_run=true",
+			url.PathEscape(f.Arg(0)), url.PathEscape(cmd.zone), url.PathEscape(f.Arg(1)))
+		var dryRun dryRunResult
+		if err := callAPI(ctx, config, http.MethodDelete, path, nil, &dryRun); err != nil {
+			fmt.Fprintf(os.Stderr, "unplug fail: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		printDryRun(&dryRun)
+		return subcommands.ExitSuccess
+	}
+	if err := cli.Unplug(ctx, f.Arg(0), cmd.zone, f.Arg(1)); err != nil {
+		fmt.Fprintf(os.Stderr, "unplug fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// EndpointCmd endpoint cmd
+type EndpointCmd struct {
+	zone string
+}
+
+// Name cmd name
+func (cmd *EndpointCmd) Name() string {
+	return "endpoint"
+}
+
+// Synopsis cmd synopsis
+func (cmd *EndpointCmd) Synopsis() string {
+	return "get an endpoint of a service"
+}
+
+// Usage cmd usage
+func (cmd *EndpointCmd) Usage() string {
+	return `endpoint [-zone default] <service> <address>:
+  print endpoint of address in service zone, with its lease, lease ttl and mod revision
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *EndpointCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.zone, "zone", "default", "service zone")
+}
+
+// Execute cmd execute
+func (cmd *EndpointCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 2 {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	_, cli, status := newCtlClient()
+	if status != subcommands.ExitSuccess {
+		return status
+	}
+	result, err := cli.GetEndpoint(ctx, f.Arg(0), cmd.zone, f.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get endpoint fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	if jsonOutput() {
+		printJSON(result)
+		return subcommands.ExitSuccess
+	}
+	endpoint := result.Endpoint
+	printTable([]string{"ADDRESS", "INSTANCE", "LOCALITY", "STATIC", "LEASE", "TTL", "MOD_REVISION", "CONFIG"},
+		[][]string{{endpoint.Address, endpoint.InstanceID, endpoint.Locality, strconv.FormatBool(endpoint.Static),
+			strconv.FormatInt(result.LeaseID, 10), strconv.FormatInt(result.TTL, 10),
+			strconv.FormatInt(result.ModRevision, 10), endpoint.Config}})
+	return subcommands.ExitSuccess
+}
+
+// printService print endpoints of service as table, or json with revision
+func printService(service *client.Service, revision int64) error {
+	if jsonOutput() {
+		return printJSON(client.QueryResult{Service: service, Revision: revision})
+	}
+	zones := make([]string, 0, len(service.Zones))
+	for zone := range service.Zones {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	var rows [][]string
+	for _, zone := range zones {
+		for _, endpoint := range service.Zones[zone].Endpoints {
+			rows = append(rows, []string{zone, endpoint.Address, endpoint.Locality, endpoint.Origin,
+				strconv.Itoa(endpoint.Priority), strconv.FormatBool(endpoint.Static), endpoint.Config})
+		}
+	}
+	fmt.Printf("%s revision %d\n", service.Service, revision)
+	return printTable([]string{"ZONE", "ADDRESS", "LOCALITY", "ORIGIN", "PRIORITY", "STATIC", "CONFIG"}, rows)
+}
+
+// QueryCmd query cmd
+type QueryCmd struct{}
+
+// Name cmd name
+func (cmd *QueryCmd) Name() string {
+	return "query"
+}
+
+// Synopsis cmd synopsis
+func (cmd *QueryCmd) Synopsis() string {
+	return "query endpoints of a service"
+}
+
+// Usage cmd usage
+func (cmd *QueryCmd) Usage() string {
+	return `query <service>:
+  print endpoints of service by zone
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *QueryCmd) SetFlags(f *flag.FlagSet) {
+
+}
+
+// Execute cmd execute
+func (cmd *QueryCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	_, cli, status := newCtlClient()
+	if status != subcommands.ExitSuccess {
+		return status
+	}
+	service, rev, err := cli.Query(ctx, f.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "query fail: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	if err := printService(service, rev); err != nil {
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// WatchCmd watch cmd
+type WatchCmd struct{}
+
+// Name cmd name
+func (cmd *WatchCmd) Name() string {
+	return "watch"
+}
+
+// Synopsis cmd synopsis
+func (cmd *WatchCmd) Synopsis() string {
+	return "watch endpoint changes of a service"
+}
+
+// Usage cmd usage
+func (cmd *WatchCmd) Usage() string {
+	return `watch <service>:
+  print endpoints of service, then again on every change until interrupted,
+  -output json prints one line per change
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *WatchCmd) SetFlags(f *flag.FlagSet) {
+
+}
+
+// Execute cmd execute
+func (cmd *WatchCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	_, cli, status := newCtlClient()
+	if status != subcommands.ExitSuccess {
+		return status
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		cancel()
+	}()
+	service := f.Arg(0)
+	var revision int64
+	for ctx.Err() == nil {
+		var s *client.Service
+		var rev int64
+		var err error
+		if revision == 0 {
+			s, rev, err = cli.Query(ctx, service)
+		} else {
+			s, rev, err = cli.Watch(ctx, service, revision+1)
+		}
+		if err != nil {
+			if client.IsErrCode(err, client.EcodeDeadlineExceeded) && ctx.Err() == nil {
+				if rev > 0 {
+					revision = rev
+				}
+				continue
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			if !client.IsErrCode(err, client.EcodeRevisionCompacted) {
+				fmt.Fprintf(os.Stderr, "watch fail, retry: %v\n", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(5 * time.Second):
+				}
+			}
+			revision = 0
+			continue
+		}
+		revision = rev
+		if !jsonOutput() {
+			fmt.Println(time.Now().Format(time.RFC3339))
+		}
+		printService(s, rev)
+	}
+	return subcommands.ExitSuccess
+}
+
+// ListCmd list cmd
+type ListCmd struct {
+	query string
+}
+
+// Name cmd name
+func (cmd *ListCmd) Name() string {
+	return "list"
+}
+
+// Synopsis cmd synopsis
+func (cmd *ListCmd) Synopsis() string {
+	return "list services"
+}
+
+// Usage cmd usage
+func (cmd *ListCmd) Usage() string {
+	return `list [-q payments.]:
+  list services and zones containing q, all if empty
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *ListCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.query, "q", "", "search query")
+}
+
+// Execute cmd execute
+func (cmd *ListCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	_, cli, status := newCtlClient()
+	if status != subcommands.ExitSuccess {
+		return status
+	}
+	const pageSize = 200
+	items := []client.ServiceItem{}
+	for {
+		result, err := cli.Search(ctx, cmd.query, int64(len(items)), pageSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "list fail: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		items = append(items, result.Services...)
+		if len(result.Services) == 0 || int64(len(items)) >= result.Total {
+			break
+		}
+	}
+	if jsonOutput() {
+		printJSON(items)
+		return subcommands.ExitSuccess
+	}
+	rows := make([][]string, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, []string{item.Service, item.Zone, item.Type})
+	}
+	printTable([]string{"SERVICE", "ZONE", "TYPE"}, rows)
+	return subcommands.ExitSuccess
+}
+
+type dryRunResult struct {
+	Changes []struct {
+		Op        string `json:"op"`
+		Key       string `json:"key"`
+		Value     string `json:"value,omitempty"`
+		PrevValue string `json:"prev_value,omitempty"`
+	} `json:"changes"`
+	Notes    []string `json:"notes,omitempty"`
+	Revision int64    `json:"revision"`
+}
+
+// printDryRun print changes of a dry run
+func printDryRun(dryRun *dryRunResult) error {
+	if jsonOutput() {
+		return printJSON(dryRun)
+	}
+	rows := make([][]string, 0, len(dryRun.Changes))
+	for _, change := range dryRun.Changes {
+		rows = append(rows, []string{change.Op, change.Key, change.PrevValue, change.Value})
+	}
+	printTable([]string{"OP", "KEY", "FROM", "TO"}, rows)
+	for _, note := range dryRun.Notes {
+		fmt.Println("note:", note)
+	}
+	if len(dryRun.Changes) == 0 {
+		fmt.Println("no changes")
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+type key int
+
+const (
+	keyNone key = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyEnter
+	keyPageUp
+	keyPageDown
+	keyRune
+)
+
+type keyEvent struct {
+	key  key
+	char rune
+}
+
+// screen full screen terminal in raw mode
+type screen struct {
+	in    *bufio.Reader
+	out   *bufio.Writer
+	fd    int
+	state *terminal.State
+}
+
+func openScreen() (*screen, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("stdin is not a terminal")
+	}
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	s := &screen{in: bufio.NewReader(os.Stdin), out: bufio.NewWriter(os.Stdout), fd: fd, state: state}
+	// alternate screen, hide cursor
+	s.out.WriteString("\x1b[?1049h\x1b[?25l")
+	s.out.Flush()
+	return s, nil
+}
+
+func (s *screen) Close() {
+	s.out.WriteString("\x1b[0m\x1b[?25h\x1b[?1049l")
+	s.out.Flush()
+	terminal.Restore(s.fd, s.state)
+}
+
+func (s *screen) Size() (int, int) {
+	width, height, err := terminal.GetSize(s.fd)
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+func (s *screen) ReadKey() (keyEvent, error) {
+	r, _, err := s.in.ReadRune()
+	if err != nil {
+		return keyEvent{}, err
+	}
+	switch r {
+	case '\r', '\n':
+		return keyEvent{key: keyEnter}, nil
+	case 0x7f, 0x08:
+		return keyEvent{key: keyLeft}, nil
+	case 0x1b:
+		if s.in.Buffered() == 0 {
+			return keyEvent{key: keyRune, char: r}, nil
+		}
+		if b, _ := s.in.ReadByte(); b != '[' && b != 'O' {
+			return keyEvent{}, nil
+		}
+		b, _ := s.in.ReadByte()
+		switch b {
+		case 'A':
+			return keyEvent{key: keyUp}, nil
+		case 'B':
+			return keyEvent{key: keyDown}, nil
+		case 'C':
+			return keyEvent{key: keyRight}, nil
+		case 'D':
+			return keyEvent{key: keyLeft}, nil
+		case '5', '6':
+			s.in.ReadByte() // ~
+			if b == '5' {
+				return keyEvent{key: keyPageUp}, nil
+			}
+			return keyEvent{key: keyPageDown}, nil
+		}
+		return keyEvent{}, nil
+	}
+	return keyEvent{key: keyRune, char: r}, nil
+}
+
+// fit pad or truncate text to width
+func fit(text string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	n := utf8.RuneCountInString(text)
+	if n > width {
+		runes := []rune(text)
+		if width == 1 {
+			return string(runes[:1])
+		}
+		return string(runes[:width-1]) + "~"
+	}
+	return text + strings.Repeat(" ", width-n)
+}
+
+// Draw draw lines, the line at highlight is shown reversed
+func (s *screen) Draw(lines []string, highlight int) {
+	width, height := s.Size()
+	s.out.WriteString("\x1b[H")
+	for i := 0; i < height; i++ {
+		var line string
+		if i < len(lines) {
+			line = lines[i]
+		}
+		if i == highlight {
+			s.out.WriteString("\x1b[7m")
+		}
+		s.out.WriteString(fit(line, width))
+		if i == highlight {
+			s.out.WriteString("\x1b[0m")
+		}
+		if i < height-1 {
+			s.out.WriteString("\r\n")
+		}
+	}
+	s.out.Flush()
+}
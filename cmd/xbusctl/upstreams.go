@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/google/subcommands"
+)
+
+// upstream formats
+const (
+	upstreamsNginx   = "nginx"
+	upstreamsHAProxy = "haproxy"
+)
+
+// endpoints of priority > 0 are backups
+var upstreamTemplates = map[string]string{
+	upstreamsNginx: `# generated by xbusctl upstreams, do not edit
+{{- range .Services}}
+
+upstream {{name .}} {
+{{- range endpoints . $.Zone}}
+    server {{.Address}}{{if .Priority}} backup{{end}};
+{{- else}}
+    server 127.0.0.1:1 down;
+{{- end}}
+}
+{{- end}}
+`,
+	upstreamsHAProxy: `# generated by xbusctl upstreams, do not edit
+{{- range .Services}}
+
+backend {{name .}}
+    balance roundrobin
+{{- range endpoints . $.Zone}}
+    server {{name .Address}} {{.Address}} check{{if .Priority}} backup{{end}}
+{{- end}}
+{{- end}}
+`,
+}
+
+// upstreamName service or address as an identifier of lb configs, e.g. payments_core_1_0
+func upstreamName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+type upstreamsData struct {
+	Services []string
+	Zone     string
+}
+
+// UpstreamsCmd upstreams cmd
+type UpstreamsCmd struct {
+	format string
+	zone   string
+	out    string
+	reload string
+	once   bool
+}
+
+// Name cmd name
+func (cmd *UpstreamsCmd) Name() string {
+	return "upstreams"
+}
+
+// Synopsis cmd synopsis
+func (cmd *UpstreamsCmd) Synopsis() string {
+	return "render nginx or haproxy upstreams of services"
+}
+
+// Usage cmd usage
+func (cmd *UpstreamsCmd) Usage() string {
+	return `upstreams [-format nginx|haproxy] [-zone default] [-out file] [-reload cmd] [-once] <service>...:
+  render an nginx upstream or haproxy backend per service to out (stdout if empty),
+  then again on every change until interrupted, running reload after out changed,
+  -once renders once and exits
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *UpstreamsCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.format, "format", upstreamsNginx, "nginx or haproxy")
+	f.StringVar(&cmd.zone, "zone", "default", "service zone")
+	f.StringVar(&cmd.out, "out", "", "output file")
+	f.StringVar(&cmd.reload, "reload", "", "command run after out changed, e.g. nginx -s reload")
+	f.BoolVar(&cmd.once, "once", false, "render once and exit")
+}
+
+// Execute cmd execute
+func (cmd *UpstreamsCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	text, ok := upstreamTemplates[cmd.format]
+	if f.NArg() == 0 || !ok {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	config, cli, status := newCtlClient()
+	if status != subcommands.ExitSuccess {
+		return status
+	}
+	tmpl := template.Must(parseRenderTemplate(cmd.format, text))
+	r := newRenderer(cli, config, tmpl, upstreamsData{Services: f.Args(), Zone: cmd.zone}, cmd.out, cmd.reload)
+	if cmd.once {
+		if err := r.once(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "render fail: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+	runRenderers(ctx, r)
+	return subcommands.ExitSuccess
+}
@@ -9,8 +9,8 @@ import (
 	"math/big"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/logging"
 	"github.com/infrmods/xbus/utils"
 )
 
@@ -56,12 +56,12 @@ func (cmd *GenRootCmd) SetFlags(f *flag.FlagSet) {
 func (cmd *GenRootCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
 	privKey, err := utils.NewPrivateKey(cmd.EcdsaCruve, cmd.RSABits)
 	if err != nil {
-		glog.Errorf("generate rsa private key fail: %v", err)
+		logging.Errorf("generate rsa private key fail: %v", err)
 		return subcommands.ExitFailure
 	}
 
 	if cmd.Days < 1 {
-		glog.Errorf("invalid days: %d", cmd.Days)
+		logging.Errorf("invalid days: %d", cmd.Days)
 		return subcommands.ExitFailure
 	}
 
@@ -81,17 +81,17 @@ func (cmd *GenRootCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interfac
 	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template,
 		privKey.Public(), privKey)
 	if err != nil {
-		glog.Errorf("create cert fail: %v", err)
+		logging.Errorf("create cert fail: %v", err)
 		return subcommands.ExitFailure
 	}
 
 	if err := utils.WriteCert(cmd.CertFile, 0644, derBytes); err != nil {
-		glog.Errorf("write cert fail: %v", err)
+		logging.Errorf("write cert fail: %v", err)
 		return subcommands.ExitFailure
 	}
 
 	if err := utils.WritePrivateKey(cmd.KeyFile, 0600, privKey); err != nil {
-		glog.Errorf("write key fail: %v", err)
+		logging.Errorf("write key fail: %v", err)
 		return subcommands.ExitFailure
 	}
 
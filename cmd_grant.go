@@ -5,19 +5,22 @@ import (
 	"flag"
 	"fmt"
 
-	"github.com/golang/glog"
 	"github.com/google/subcommands"
 	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/logging"
 )
 
 // GrantCmd grant cmd
 type GrantCmd struct {
-	isConfigs  bool
-	isServices bool
-	isApps     bool
-	isApp      bool
-	isGroup    bool
-	canWrite   bool
+	isConfigs   bool
+	isServices  bool
+	isApps      bool
+	isSecrets   bool
+	isLocks     bool
+	isElections bool
+	isApp       bool
+	isGroup     bool
+	canWrite    bool
 }
 
 // Name cmd name
@@ -40,6 +43,9 @@ func (cmd *GrantCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&cmd.isConfigs, "configs", false, "list config perms")
 	f.BoolVar(&cmd.isServices, "services", false, "list services perms")
 	f.BoolVar(&cmd.isApps, "apps", false, "list app perms")
+	f.BoolVar(&cmd.isSecrets, "secrets", false, "list secret perms")
+	f.BoolVar(&cmd.isLocks, "locks", false, "list lock perms")
+	f.BoolVar(&cmd.isElections, "elections", false, "list election perms")
 	f.BoolVar(&cmd.isApp, "app", false, "target is app")
 	f.BoolVar(&cmd.isGroup, "group", false, "target is group")
 	f.BoolVar(&cmd.canWrite, "write", false, "need write")
@@ -60,6 +66,12 @@ func (cmd *GrantCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interface{
 		perm.PermType = apps.PermTypeApp
 	} else if cmd.isServices {
 		perm.PermType = apps.PermTypeService
+	} else if cmd.isSecrets {
+		perm.PermType = apps.PermTypeSecret
+	} else if cmd.isLocks {
+		perm.PermType = apps.PermTypeLock
+	} else if cmd.isElections {
+		perm.PermType = apps.PermTypeElection
 	} else {
 		perm.PermType = apps.PermTypeConfig
 	}
@@ -72,7 +84,7 @@ func (cmd *GrantCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interface{
 			}
 			perm.TargetID = group.ID
 		} else {
-			glog.Errorf("get group(%v) fail: %v", args[0], err)
+			logging.Errorf("get group(%v) fail: %v", args[0], err)
 			return subcommands.ExitFailure
 		}
 	} else {
@@ -87,7 +99,7 @@ func (cmd *GrantCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interface{
 				}
 				perm.TargetID = app.ID
 			} else {
-				glog.Errorf("get app(%v), fail: %v", args[0], err)
+				logging.Errorf("get app(%v), fail: %v", args[0], err)
 				return subcommands.ExitFailure
 			}
 		}
@@ -96,7 +108,7 @@ func (cmd *GrantCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interface{
 	perm.Content = args[1]
 
 	if err := apps.InsertPerm(db, &perm); err != nil {
-		glog.Errorf("new perm fail: %v", err)
+		logging.Errorf("new perm fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	return subcommands.ExitSuccess
@@ -4,9 +4,9 @@ import (
 	"context"
 	"flag"
 
-	"github.com/golang/glog"
 	"github.com/google/subcommands"
 	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/logging"
 	"github.com/infrmods/xbus/utils"
 )
 
@@ -41,15 +41,15 @@ func (cmd *KeyCertCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interfac
 
 	app, err := apps.GetAppByName(db, appName)
 	if err != nil {
-		glog.Errorf("get app fail: %v", err)
+		logging.Errorf("get app fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	if err := utils.WriteFile(appName+"cert.pem", 0644, []byte(app.Cert)); err != nil {
-		glog.Errorf("write cert fail: %v", err)
+		logging.Errorf("write cert fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	if err := utils.WriteFile(appName+"key.pem", 0600, []byte(app.PrivateKey)); err != nil {
-		glog.Errorf("write key fail: %v", err)
+		logging.Errorf("write key fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	return subcommands.ExitSuccess
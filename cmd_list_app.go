@@ -7,9 +7,9 @@ import (
 	"os"
 	"text/tabwriter"
 
-	"github.com/golang/glog"
 	"github.com/google/subcommands"
 	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/logging"
 )
 
 // ListAppCmd list app cmd
@@ -43,7 +43,7 @@ func (cmd *ListAppCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interfac
 	db := x.NewDB()
 	apps, err := apps.GetAppList(db)
 	if err != nil {
-		glog.Errorf("get app list fail: %v", err)
+		logging.Errorf("get app list fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
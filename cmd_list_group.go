@@ -7,9 +7,9 @@ import (
 	"os"
 	"text/tabwriter"
 
-	"github.com/golang/glog"
 	"github.com/google/subcommands"
 	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/logging"
 )
 
 // ListGroupCmd list group cmd
@@ -41,7 +41,7 @@ func (cmd *ListGroupCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interf
 	db := x.NewDB()
 	groups, err := apps.GetGroupList(db)
 	if err != nil {
-		glog.Errorf("get app list fail: %v", err)
+		logging.Errorf("get app list fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
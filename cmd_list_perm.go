@@ -7,20 +7,23 @@ import (
 	"os"
 	"text/tabwriter"
 
-	"github.com/golang/glog"
 	"github.com/google/subcommands"
 	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/logging"
 )
 
 // ListPermCmd cmd list perm
 type ListPermCmd struct {
-	isConfigs  bool
-	isServices bool
-	isApps     bool
-	appName    string
-	groupName  string
-	canWrite   bool
-	prefix     string
+	isConfigs   bool
+	isServices  bool
+	isApps      bool
+	isSecrets   bool
+	isLocks     bool
+	isElections bool
+	appName     string
+	groupName   string
+	canWrite    bool
+	prefix      string
 }
 
 // Name cmd name
@@ -43,6 +46,9 @@ func (cmd *ListPermCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&cmd.isConfigs, "configs", false, "list config perms")
 	f.BoolVar(&cmd.isServices, "services", false, "list services perms")
 	f.BoolVar(&cmd.isApps, "apps", false, "list app perms")
+	f.BoolVar(&cmd.isSecrets, "secrets", false, "list secret perms")
+	f.BoolVar(&cmd.isLocks, "locks", false, "list lock perms")
+	f.BoolVar(&cmd.isElections, "elections", false, "list election perms")
 	f.StringVar(&cmd.appName, "app", "", "app name")
 	f.StringVar(&cmd.groupName, "group", "", "group name")
 	f.BoolVar(&cmd.canWrite, "write", false, "need write")
@@ -57,7 +63,7 @@ func (cmd *ListPermCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interfa
 
 	appList, err := apps.GetAppList(db)
 	if err != nil {
-		glog.Errorf("get apps fail: %v", err)
+		logging.Errorf("get apps fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	appMap := make(map[int64]apps.App)
@@ -66,7 +72,7 @@ func (cmd *ListPermCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interfa
 	}
 	groupList, err := apps.GetGroupList(db)
 	if err != nil {
-		glog.Errorf("get groups fail: %v", err)
+		logging.Errorf("get groups fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	groupMap := make(map[int64]*apps.Group)
@@ -85,6 +91,12 @@ func (cmd *ListPermCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interfa
 		typ = apps.PermTypeService
 	} else if cmd.isApps {
 		typ = apps.PermTypeApp
+	} else if cmd.isSecrets {
+		typ = apps.PermTypeSecret
+	} else if cmd.isLocks {
+		typ = apps.PermTypeLock
+	} else if cmd.isElections {
+		typ = apps.PermTypeElection
 	} else {
 		typ = apps.PermTypeConfig
 	}
@@ -115,6 +127,12 @@ func (cmd *ListPermCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interfa
 				typeName = "service"
 			case apps.PermTypeApp:
 				typeName = "app"
+			case apps.PermTypeSecret:
+				typeName = "secret"
+			case apps.PermTypeLock:
+				typeName = "lock"
+			case apps.PermTypeElection:
+				typeName = "election"
 			}
 			switch perm.TargetType {
 			case apps.PermTargetApp:
@@ -145,7 +163,7 @@ func (cmd *ListPermCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interfa
 		}
 		w.Flush()
 	} else {
-		glog.Errorf("get perms fail: %v", err)
+		logging.Errorf("get perms fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	return subcommands.ExitSuccess
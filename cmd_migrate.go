@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/migrate"
+	"github.com/infrmods/xbus/services"
+)
+
+// MigrateCmd migrate cmd
+type MigrateCmd struct {
+	module       string
+	from         string
+	to           string
+	rewrite      bool
+	overwrite    bool
+	deleteSource bool
+	dryRun       bool
+}
+
+// Name cmd name
+func (cmd *MigrateCmd) Name() string {
+	return "migrate"
+}
+
+// Synopsis cmd synopsis
+func (cmd *MigrateCmd) Synopsis() string {
+	return "copy data of a key prefix to another"
+}
+
+// Usage cmd usage
+func (cmd *MigrateCmd) Usage() string {
+	return `migrate [-module services] -from /old-prefix [-to prefix] [-rewrite] [-overwrite] [-delete-source] [-dry-run]:
+  copy keys of -from to -to, the key_prefix of module in config by default, with the same leases;
+  set legacy_key_prefix of module so that old keys are read too until all clients moved,
+  then run again with -delete-source
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *MigrateCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.module, "module", "services", "services, configs or apps")
+	f.StringVar(&cmd.from, "from", "", "old key prefix")
+	f.StringVar(&cmd.to, "to", "", "new key prefix, key_prefix of module if empty")
+	f.BoolVar(&cmd.rewrite, "rewrite", false, "rewrite service values to the current schema")
+	f.BoolVar(&cmd.overwrite, "overwrite", false, "overwrite existing keys of the new prefix")
+	f.BoolVar(&cmd.deleteSource, "delete-source", false, "delete keys of the old prefix after copied")
+	f.BoolVar(&cmd.dryRun, "dry-run", false, "count keys only")
+}
+
+// Execute cmd execute
+func (cmd *MigrateCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	if cmd.from == "" {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	x := NewXBus()
+	to := cmd.to
+	if to == "" {
+		switch cmd.module {
+		case "services":
+			to = x.Config.Services.KeyPrefix
+		case "configs":
+			to = x.Config.Configs.KeyPrefix
+		case "apps":
+			to = x.Config.Apps.KeyPrefix
+		default:
+			logging.Errorf("unknown module: %s", cmd.module)
+			return subcommands.ExitUsageError
+		}
+	}
+	options := migrate.Options{Overwrite: cmd.overwrite, DeleteSource: cmd.deleteSource, DryRun: cmd.dryRun}
+	if cmd.rewrite {
+		if cmd.module != "services" {
+			logging.Errorf("only values of services can be rewritten")
+			return subcommands.ExitUsageError
+		}
+		options.Rewrite = services.ValueRewriter(cmd.from)
+	}
+	stats, err := migrate.Prefix(ctx, x.NewEtcdClient(), cmd.from, to, &options)
+	if stats != nil {
+		logging.Infof("migrate %s to %s: %d copied, %d existing, %d skipped, %d deleted",
+			cmd.from, to, stats.Copied, stats.Existing, stats.Skipped, stats.Deleted)
+	}
+	if err != nil {
+		logging.Errorf("migrate fail: %v", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
@@ -6,9 +6,9 @@ import (
 	"net"
 	"strings"
 
-	"github.com/golang/glog"
 	"github.com/google/subcommands"
 	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/logging"
 	"github.com/infrmods/xbus/utils"
 )
 
@@ -73,7 +73,7 @@ func (cmd *NewAppCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interface
 		for _, ipStr := range ipStrs {
 			ip := net.ParseIP(ipStr)
 			if ip == nil {
-				glog.Errorf("invalid ip: %s", ipStr)
+				logging.Errorf("invalid ip: %s", ipStr)
 				return subcommands.ExitUsageError
 			}
 			ips = append(ips, ip)
@@ -82,7 +82,7 @@ func (cmd *NewAppCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interface
 
 	privKey, err := utils.NewPrivateKey(cmd.EcdsaCruve, cmd.RSABits)
 	if err != nil {
-		glog.Errorf("generate private key fail: %v", err)
+		logging.Errorf("generate private key fail: %v", err)
 		return subcommands.ExitFailure
 	}
 
@@ -91,15 +91,15 @@ func (cmd *NewAppCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interface
 	app := apps.App{Status: utils.StatusOk, Name: appName,
 		Description: cmd.Description}
 	if _, err := appCtrl.NewApp(&app, privKey, strings.Split(cmd.DNSNames, ","), ips, cmd.Days); err != nil {
-		glog.Errorf("create app fail: %v", err)
+		logging.Errorf("create app fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	if err := utils.WriteFile(cmd.CertFile, 0644, []byte(app.Cert)); err != nil {
-		glog.Errorf("write cert fail: %v", err)
+		logging.Errorf("write cert fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	if err := utils.WritePrivateKey(cmd.KeyFile, 0600, privKey); err != nil {
-		glog.Errorf("write key fail: %v", err)
+		logging.Errorf("write key fail: %v", err)
 		return subcommands.ExitFailure
 	}
 	return subcommands.ExitSuccess
@@ -3,14 +3,24 @@ package main
 import (
 	"flag"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"context"
 
-	"github.com/golang/glog"
 	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/alerts"
 	"github.com/infrmods/xbus/api"
 	"github.com/infrmods/xbus/configs"
+	"github.com/infrmods/xbus/election"
+	"github.com/infrmods/xbus/locks"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/schemas"
+	"github.com/infrmods/xbus/secrets"
 	"github.com/infrmods/xbus/services"
+	"github.com/infrmods/xbus/snapshots"
+	"github.com/infrmods/xbus/streams"
+	"github.com/infrmods/xbus/webhooks"
 )
 
 // RunCmd run cmd
@@ -38,19 +48,96 @@ func (cmd *RunCmd) Usage() string {
 
 // Execute cmd execute
 func (cmd *RunCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	// caught before anything starts, handled once the api server runs
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	x := NewXBus()
+	if e := x.StartEmbedEtcd(); e != nil {
+		defer e.Close()
+	}
 	db := x.NewDB()
 	etcdClient := x.NewEtcdClient()
 	services, err := services.NewServiceCtrl(&x.Config.Services, db, etcdClient)
 	if err != nil {
-		glog.Errorf("create service fail: %v", err)
+		logging.Errorf("create service fail: %v", err)
 		os.Exit(-1)
 	}
+	x.NewRemoteEtcdClients(services)
 	configs := configs.NewConfigCtrl(&x.Config.Configs, db, etcdClient)
-	apiServer := api.NewServer(&x.Config.API, etcdClient, services, configs, x.NewAppCtrl(db, etcdClient))
-	if err := apiServer.Run(); err != nil {
-		glog.Errorf("start api_sersver fail: %v", err)
+	dispatcher, err := webhooks.NewDispatcher(&x.Config.Webhooks)
+	if err != nil {
+		logging.Errorf("create webhooks fail: %v", err)
+		os.Exit(-1)
+	}
+	// webhooks may be enabled by reloads, changes are handled while running on the leader only
+	dispatcher.WatchServices(services)
+	dispatcher.WatchConfigs(configs)
+	exporter, err := streams.NewExporter(&x.Config.Streams, x.Config.Services.Federation.Name)
+	if err != nil {
+		logging.Errorf("create change streams fail: %v", err)
+		os.Exit(-1)
+	}
+	// published by the leader only, the listener must be added before RunChangeLog
+	if exporter.Enabled() {
+		exporter.WatchServices(services)
+	}
+	go services.RunChangeLog(context.Background())
+	backupStore, err := x.Config.Backup.NewStore()
+	if err != nil {
+		logging.Errorf("create backup store fail: %v", err)
+		os.Exit(-1)
+	}
+	appCtrl := x.NewAppCtrl(db, etcdClient)
+	alertEngine, err := alerts.NewEngine(&x.Config.Alerts)
+	if err != nil {
+		logging.Errorf("create alerts fail: %v", err)
+		os.Exit(-1)
+	}
+	alertEngine.RegisterSource(alerts.KindEndpoints, alerts.EndpointsSource(services))
+	alertEngine.RegisterSource(alerts.KindServiceEndpoints, alerts.ServiceEndpointsSource(services))
+	alertEngine.RegisterSource(alerts.KindCertExpiry, alerts.CertExpirySource(appCtrl))
+	alertEngine.RegisterSource(alerts.KindLeaseTTL, alerts.LeaseTTLSource(services))
+	alertEngine.RegisterSource(alerts.KindFlapping, alerts.FlappingSource(services))
+	leaderCtx, resign := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		election.NewElector(&x.Config.Election, etcdClient).Run(leaderCtx, func(ctx context.Context) {
+			go services.RunGC(ctx)
+			go services.RunOrphanGC(ctx)
+			go services.RunRollouts(ctx)
+			services.RunMirrors(ctx)
+			dispatcher.Run(ctx)
+			if backupStore != nil {
+				go snapshots.NewSnapshotter(services, configs).RunBackups(ctx, &x.Config.Backup, backupStore)
+			}
+			go alertEngine.Run(ctx)
+			if exporter.Enabled() {
+				go exporter.Run(ctx)
+			}
+		})
+	}()
+	secretCtrl, err := secrets.NewSecretCtrl(&x.Config.Secrets, etcdClient)
+	if err != nil {
+		logging.Errorf("create secrets fail: %v", err)
+		os.Exit(-1)
+	}
+	apiServer := api.NewServer(&x.Config.API, etcdClient, services, configs, appCtrl,
+		locks.NewLockCtrl(&x.Config.Locks, etcdClient), schemas.NewSchemaCtrl(&x.Config.Schemas, db), secretCtrl)
+	apiServer.OnReload(func() error {
+		return x.Reload(
+			func(config *Config) (func(), error) { return services.PrepareReload(&config.Services) },
+			func(config *Config) (func(), error) { return dispatcher.PrepareReload(&config.Webhooks) },
+			func(config *Config) (func(), error) { return apiServer.PrepareReload(&config.API) })
+	})
+	if err := apiServer.Run(hup); err != nil {
+		logging.Errorf("start api_sersver fail: %v", err)
 		os.Exit(-1)
 	}
+	logging.Info("server stopped")
+	resign()
+	<-leaderDone
+	exporter.Close()
+	x.Close()
 	return subcommands.ExitSuccess
 }
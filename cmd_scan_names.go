@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/services"
+)
+
+// ScanNamesCmd scan names cmd
+type ScanNamesCmd struct{}
+
+// Name cmd name
+func (cmd *ScanNamesCmd) Name() string {
+	return "scan-names"
+}
+
+// Synopsis cmd synopsis
+func (cmd *ScanNamesCmd) Synopsis() string {
+	return "find services not conforming to strict name rules"
+}
+
+// Usage cmd usage
+func (cmd *ScanNamesCmd) Usage() string {
+	return "scan-names\n"
+}
+
+// SetFlags cmd set flags
+func (cmd *ScanNamesCmd) SetFlags(f *flag.FlagSet) {
+}
+
+// Execute cmd execute, exits with failure if any found
+func (cmd *ScanNamesCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	x := NewXBus()
+	servs, err := services.NewServiceCtrl(&x.Config.Services, x.NewDB(), x.NewEtcdClient())
+	if err != nil {
+		logging.Errorf("create service fail: %v", err)
+		return subcommands.ExitFailure
+	}
+	issues, err := servs.ScanNames(ctx)
+	if err != nil {
+		logging.Errorf("scan names fail: %v", err)
+		return subcommands.ExitFailure
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "service\tcanonical\tinvalid\tconflicts\n")
+	for _, issue := range issues {
+		fmt.Fprintf(w, "%s\t%s\t%v\t%s\n",
+			issue.Service, issue.Canonical, issue.Invalid, strings.Join(issue.Conflicts, ","))
+	}
+	w.Flush()
+	if len(issues) > 0 {
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
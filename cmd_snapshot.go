@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/configs"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/services"
+	"github.com/infrmods/xbus/snapshots"
+)
+
+// NewSnapshotter new snapshotter of the configured registry
+func (x *XBus) NewSnapshotter() *snapshots.Snapshotter {
+	db := x.NewDB()
+	etcdClient := x.NewEtcdClient()
+	servs, err := services.NewServiceCtrl(&x.Config.Services, db, etcdClient)
+	if err != nil {
+		logging.Errorf("create service fail: %v", err)
+		os.Exit(-1)
+	}
+	return snapshots.NewSnapshotter(servs, configs.NewConfigCtrl(&x.Config.Configs, db, etcdClient))
+}
+
+// ExportCmd export cmd
+type ExportCmd struct {
+	output string
+}
+
+// Name cmd name
+func (cmd *ExportCmd) Name() string {
+	return "export"
+}
+
+// Synopsis cmd synopsis
+func (cmd *ExportCmd) Synopsis() string {
+	return "export services and configs as a snapshot"
+}
+
+// Usage cmd usage
+func (cmd *ExportCmd) Usage() string {
+	return "export [-o snapshot.json]\n"
+}
+
+// SetFlags cmd set flags
+func (cmd *ExportCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.output, "o", "-", "output file, - for stdout")
+}
+
+// Execute cmd execute
+func (cmd *ExportCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	x := NewXBus()
+	var w io.Writer = os.Stdout
+	if cmd.output != "-" {
+		file, err := os.Create(cmd.output)
+		if err != nil {
+			logging.Errorf("create %s fail: %v", cmd.output, err)
+			return subcommands.ExitFailure
+		}
+		defer file.Close()
+		w = file
+	}
+	snapshot, err := x.NewSnapshotter().Export(ctx, w)
+	if err != nil {
+		logging.Errorf("export fail: %v", err)
+		return subcommands.ExitFailure
+	}
+	logging.Infof("exported %d service zones, %d configs at revision %d",
+		len(snapshot.Services), len(snapshot.Configs), snapshot.Revision)
+	return subcommands.ExitSuccess
+}
+
+// ImportCmd import cmd
+type ImportCmd struct {
+	leaseTTL    time.Duration
+	skipConfigs bool
+	configTag   string
+}
+
+// Name cmd name
+func (cmd *ImportCmd) Name() string {
+	return "import"
+}
+
+// Synopsis cmd synopsis
+func (cmd *ImportCmd) Synopsis() string {
+	return "import a snapshot of services and configs"
+}
+
+// Usage cmd usage
+func (cmd *ImportCmd) Usage() string {
+	return `import [-lease-ttl 0] [-skip-configs] [-config-tag tag] <snapshot.json|->:
+  put service zones, static endpoints and configs of snapshot, existing ones are overwritten,
+  other endpoints are skipped unless -lease-ttl is given, then bound to a new lease of it
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *ImportCmd) SetFlags(f *flag.FlagSet) {
+	f.DurationVar(&cmd.leaseTTL, "lease-ttl", 0, "import non-static endpoints with a lease of ttl")
+	f.BoolVar(&cmd.skipConfigs, "skip-configs", false, "don't import configs")
+	f.StringVar(&cmd.configTag, "config-tag", "", "tag of imported configs")
+}
+
+// Execute cmd execute
+func (cmd *ImportCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		fmt.Fprint(os.Stderr, cmd.Usage())
+		return subcommands.ExitUsageError
+	}
+	x := NewXBus()
+	var r io.Reader = os.Stdin
+	if f.Arg(0) != "-" {
+		file, err := os.Open(f.Arg(0))
+		if err != nil {
+			logging.Errorf("open %s fail: %v", f.Arg(0), err)
+			return subcommands.ExitFailure
+		}
+		defer file.Close()
+		r = file
+	}
+	result, err := x.NewSnapshotter().Import(ctx, r, &snapshots.ImportOptions{
+		LeaseTTL: cmd.leaseTTL, SkipConfigs: cmd.skipConfigs, ConfigTag: cmd.configTag})
+	if err != nil {
+		logging.Errorf("import fail: %v", err)
+		return subcommands.ExitFailure
+	}
+	logging.Infof("imported %d service zones, %d endpoints (%d skipped), %d configs",
+		result.Zones, result.Endpoints, result.Skipped, result.Configs)
+	return subcommands.ExitSuccess
+}
+
+// RestoreCmd restore cmd
+type RestoreCmd struct {
+	list        bool
+	name        string
+	leaseTTL    time.Duration
+	skipConfigs bool
+	configTag   string
+}
+
+// Name cmd name
+func (cmd *RestoreCmd) Name() string {
+	return "restore"
+}
+
+// Synopsis cmd synopsis
+func (cmd *RestoreCmd) Synopsis() string {
+	return "restore a backup of the configured backup store"
+}
+
+// Usage cmd usage
+func (cmd *RestoreCmd) Usage() string {
+	return `restore [-list] [-name backup] [-lease-ttl 0] [-skip-configs] [-config-tag tag]:
+  import backup name, the latest if not given, of the backup store in config, see import
+`
+}
+
+// SetFlags cmd set flags
+func (cmd *RestoreCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&cmd.list, "list", false, "list backups only")
+	f.StringVar(&cmd.name, "name", "", "backup name, the latest if empty")
+	f.DurationVar(&cmd.leaseTTL, "lease-ttl", 0, "import non-static endpoints with a lease of ttl")
+	f.BoolVar(&cmd.skipConfigs, "skip-configs", false, "don't import configs")
+	f.StringVar(&cmd.configTag, "config-tag", "", "tag of imported configs")
+}
+
+// Execute cmd execute
+func (cmd *RestoreCmd) Execute(ctx context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	x := NewXBus()
+	store, err := x.Config.Backup.NewStore()
+	if err != nil {
+		logging.Errorf("create backup store fail: %v", err)
+		return subcommands.ExitFailure
+	}
+	if store == nil {
+		logging.Errorf("no backup store configured")
+		return subcommands.ExitFailure
+	}
+	if cmd.list {
+		backups, err := snapshots.Backups(ctx, &x.Config.Backup, store)
+		if err != nil {
+			logging.Errorf("list backups fail: %v", err)
+			return subcommands.ExitFailure
+		}
+		for _, name := range backups {
+			fmt.Println(name)
+		}
+		return subcommands.ExitSuccess
+	}
+	snapshot, err := snapshots.ReadBackup(ctx, &x.Config.Backup, store, cmd.name)
+	if err != nil {
+		logging.Errorf("read backup fail: %v", err)
+		return subcommands.ExitFailure
+	}
+	logging.Infof("restore backup of %s at revision %d", snapshot.Time.Format(time.RFC3339), snapshot.Revision)
+	result, err := x.NewSnapshotter().Restore(ctx, snapshot, &snapshots.ImportOptions{
+		LeaseTTL: cmd.leaseTTL, SkipConfigs: cmd.skipConfigs, ConfigTag: cmd.configTag})
+	if err != nil {
+		logging.Errorf("restore fail: %v", err)
+		return subcommands.ExitFailure
+	}
+	logging.Infof("restored %d service zones, %d endpoints (%d skipped), %d configs",
+		result.Zones, result.Endpoints, result.Skipped, result.Configs)
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/apps"
+	"github.com/infrmods/xbus/logging"
+)
+
+// TokenCmd issue, list or revoke api tokens of an app
+type TokenCmd struct {
+	JWT         bool
+	TTL         time.Duration
+	Description string
+	List        bool
+	Revoke      int64
+}
+
+// Name cmd name
+func (cmd *TokenCmd) Name() string {
+	return "token"
+}
+
+// Synopsis cmd synopsis
+func (cmd *TokenCmd) Synopsis() string {
+	return "issue/list/revoke api tokens of app"
+}
+
+// Usage cmd usage
+func (cmd *TokenCmd) Usage() string {
+	return "token [OPTIONS] app\n"
+}
+
+// SetFlags cmd set flags
+func (cmd *TokenCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&cmd.JWT, "jwt", false, "issue jwt instead of api key")
+	f.DurationVar(&cmd.TTL, "ttl", 0, "token expires after ttl, never if 0")
+	f.StringVar(&cmd.Description, "desc", "", "token description")
+	f.BoolVar(&cmd.List, "list", false, "list tokens")
+	f.Int64Var(&cmd.Revoke, "revoke", 0, "revoke token of id")
+}
+
+// Execute cmd execute
+func (cmd *TokenCmd) Execute(_ context.Context, f *flag.FlagSet, v ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+	x := NewXBus()
+	appCtrl := x.NewAppCtrl(x.NewDB(), x.NewEtcdClient())
+	app, err := appCtrl.GetAppByName(f.Arg(0))
+	if err != nil {
+		logging.Errorf("get app fail: %v", err)
+		return subcommands.ExitFailure
+	}
+	if app == nil {
+		logging.Errorf("app not found: %s", f.Arg(0))
+		return subcommands.ExitFailure
+	}
+
+	if cmd.List {
+		tokens, err := appCtrl.ListTokens(app)
+		if err != nil {
+			logging.Errorf("list tokens fail: %v", err)
+			return subcommands.ExitFailure
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+		fmt.Fprintf(w, "id\tkind\trevoked\texpire time\tcreate time\tdescription\n")
+		for _, token := range tokens {
+			expire := "-"
+			if token.ExpireTime.Valid {
+				expire = token.ExpireTime.Time.Format(timeFmt)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%v\t%s\t%s\t%s\n",
+				token.ID, token.Kind, token.Revoked, expire,
+				token.CreateTime.Format(timeFmt), token.Description)
+		}
+		w.Flush()
+		return subcommands.ExitSuccess
+	}
+	if cmd.Revoke > 0 {
+		if err := appCtrl.RevokeToken(app, cmd.Revoke); err != nil {
+			logging.Errorf("revoke token fail: %v", err)
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+
+	kind := apps.TokenKindKey
+	if cmd.JWT {
+		kind = apps.TokenKindJWT
+	}
+	token, value, err := appCtrl.IssueToken(app, kind, cmd.TTL, cmd.Description, nil)
+	if err != nil {
+		logging.Errorf("issue token fail: %v", err)
+		return subcommands.ExitFailure
+	}
+	fmt.Fprintf(os.Stderr, "token id: %d\n", token.ID)
+	fmt.Println(value)
+	return subcommands.ExitSuccess
+}
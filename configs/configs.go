@@ -4,13 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"strings"
+	"time"
 
 	"fmt"
 
 	"github.com/coreos/etcd/clientv3"
 	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
 	"github.com/coreos/etcd/mvcc/mvccpb"
-	"github.com/golang/glog"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
 	"github.com/infrmods/xbus/utils"
 )
 
@@ -23,7 +25,8 @@ type ConfigItem struct {
 
 // Config module config
 type Config struct {
-	KeyPrefix string `default:"/configs" yaml:"key_prefix"`
+	KeyPrefix       string `default:"/configs" yaml:"key_prefix"`
+	LegacyKeyPrefix string `yaml:"legacy_key_prefix"`
 }
 
 // ConfigCtrl config ctrl
@@ -39,6 +42,7 @@ func NewConfigCtrl(config *Config, db *sql.DB, etcdClient *clientv3.Client) *Con
 	if strings.HasSuffix(configs.config.KeyPrefix, "/") {
 		configs.config.KeyPrefix = configs.config.KeyPrefix[:len(configs.config.KeyPrefix)-1]
 	}
+	configs.config.LegacyKeyPrefix = strings.TrimSuffix(configs.config.LegacyKeyPrefix, "/")
 	return configs
 }
 
@@ -72,7 +76,7 @@ func (ctrl *ConfigCtrl) Range(ctx context.Context, from, end string, sortOption
 			name := key[len(ctrl.config.KeyPrefix)+1:]
 			cfgs = append(cfgs, configFromKv(name, kv))
 		} else {
-			glog.Errorf("invalid key from range(%s, %s): %s", from, end, key)
+			logging.Errorf("invalid key from range(%s, %s): %s", from, end, key)
 		}
 	}
 	return cfgs, resp.More, nil
@@ -83,12 +87,12 @@ func (ctrl *ConfigCtrl) ListDBConfigs(ctx context.Context,
 	tag, prefix string, skip, limit int) (int64, []ConfigInfo, error) {
 	count, err := GetDBConfigCount(ctrl.db, tag, prefix)
 	if err != nil {
-		glog.Errorf("get db configs(prefix: %s) fail: %v", prefix, err)
+		logging.Errorf("get db configs(prefix: %s) fail: %v", prefix, err)
 		return 0, nil, utils.NewSystemError("get configs count fail")
 	}
 	items, err := ListDBConfigs(ctrl.db, tag, prefix, skip, limit)
 	if err != nil {
-		glog.Errorf("get db configs fail: %v", err)
+		logging.Errorf("get db configs fail: %v", err)
 		return 0, nil, utils.NewSystemError("get configs fail")
 	}
 	if items == nil {
@@ -104,6 +108,9 @@ func (ctrl *ConfigCtrl) Get(ctx context.Context, appID int64, node, name string)
 	}
 
 	resp, err := ctrl.etcdClient.Get(ctx, ctrl.configKey(name))
+	if err == nil && resp.Kvs == nil && ctrl.config.LegacyKeyPrefix != "" {
+		resp, err = ctrl.etcdClient.Get(ctx, ctrl.config.LegacyKeyPrefix+"/"+name)
+	}
 	if err != nil {
 		return nil, 0, utils.CleanErr(err, "", "get config key(%s) fail: %v", name, err)
 	}
@@ -170,6 +177,7 @@ func (ctrl *ConfigCtrl) Watch(ctx context.Context, appID int64, node, name strin
 	if err := checkName(name); err != nil {
 		return nil, 0, err
 	}
+	defer metrics.WatchStarted("config")()
 	watcher := clientv3.NewWatcher(ctrl.etcdClient)
 	defer watcher.Close()
 
@@ -184,7 +192,7 @@ func (ctrl *ConfigCtrl) Watch(ctx context.Context, appID int64, node, name strin
 	if err := resp.Err(); err != nil {
 		// if revision is compacted, return latest revision
 		if err == v3rpc.ErrCompacted {
-			glog.Warningf("key [%s] with revision [%d] is compacted, call get instead", key, revision)
+			logging.Warningf("key [%s] with revision [%d] is compacted, call get instead", key, revision)
 			return ctrl.Get(ctx, appID, node, name)
 		}
 		return nil, 0, utils.CleanErr(err, "", "watch key(%s) with revision(%d) fail: %v", name, revision, err)
@@ -206,3 +214,54 @@ func (ctrl *ConfigCtrl) Watch(ctx context.Context, appID int64, node, name strin
 	}
 	return nil, 0, utils.NewSystemError("unexpected event")
 }
+
+// ConfigChange change of a config, Value is empty if deleted
+type ConfigChange struct {
+	Name     string
+	Value    string
+	Version  int64
+	Revision int64
+	Deleted  bool
+}
+
+const changesRetryInterval = 5 * time.Second
+
+// WatchChanges call fn with changes of all configs until ctx done
+func (ctrl *ConfigCtrl) WatchChanges(ctx context.Context, fn func(ConfigChange)) {
+	prefix := ctrl.config.KeyPrefix + "/"
+	var revision int64
+	for ctx.Err() == nil {
+		if revision == 0 {
+			resp, err := ctrl.etcdClient.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+			if err != nil {
+				logging.Warningf("get configs revision fail, retry: %v", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(changesRetryInterval):
+				}
+				continue
+			}
+			revision = resp.Header.Revision
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		for resp := range ctrl.etcdClient.Watch(watchCtx, prefix, clientv3.WithPrefix(), clientv3.WithRev(revision+1)) {
+			if err := resp.Err(); err != nil {
+				logging.Warningf("watch configs changes fail: %v", err)
+				if resp.CompactRevision > 0 {
+					revision = 0
+				}
+				break
+			}
+			for _, event := range resp.Events {
+				change := ConfigChange{Name: strings.TrimPrefix(string(event.Kv.Key), prefix),
+					Revision: event.Kv.ModRevision, Deleted: event.Type == mvccpb.DELETE}
+				if !change.Deleted {
+					change.Value, change.Version = string(event.Kv.Value), event.Kv.Version
+				}
+				fn(change)
+			}
+			revision = resp.Header.Revision
+		}
+		cancel()
+	}
+}
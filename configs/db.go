@@ -5,7 +5,7 @@ import (
 	"time"
 
 	"github.com/gocomm/dbutil"
-	"github.com/golang/glog"
+	"github.com/infrmods/xbus/logging"
 	"github.com/infrmods/xbus/utils"
 )
 
@@ -109,14 +109,14 @@ type ConfigHistory struct {
 func (ctrl *ConfigCtrl) setDBConfig(tag, name string, appID int64, remark, value string) (rerr error) {
 	tx, err := ctrl.db.Begin()
 	if err != nil {
-		glog.Errorf("new db tx fail: %v", err)
+		logging.Errorf("new db tx fail: %v", err)
 		return utils.NewError(utils.EcodeSystemError, "new db tx fail")
 	}
 
 	defer func() {
 		if rerr != nil {
 			if err := tx.Rollback(); err != nil {
-				glog.Warningf("tx roolback fail: %v", err)
+				logging.Warningf("tx roolback fail: %v", err)
 			}
 		}
 	}()
@@ -131,18 +131,18 @@ func (ctrl *ConfigCtrl) setDBConfig(tag, name string, appID int64, remark, value
                           values(?,?,?,?,now(),now())
                           on duplicate key update status=?, tag=?, value=?, modify_time=now()`,
 		ConfigStatusOk, tagV, name, value, ConfigStatusOk, tagV, value); err != nil {
-		glog.Errorf("insert db config(%s) fail: %v", name, err)
+		logging.Errorf("insert db config(%s) fail: %v", name, err)
 		return utils.NewError(utils.EcodeSystemError, "update db config fail")
 	}
 	if _, err := tx.Exec(`insert into config_histories(tag, name,app_id,remark,value,create_time)
                           values(?,?,?,?,?,now())`, tagV, name, appID, remark, value); err != nil {
-		glog.Errorf("insert db config history fail: %v", err)
+		logging.Errorf("insert db config history fail: %v", err)
 		return utils.NewError(utils.EcodeSystemError, "insert db config history fail")
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		glog.Errorf("set db config(%s), commit fail: %v", name, err)
+		logging.Errorf("set db config(%s), commit fail: %v", name, err)
 		return utils.NewError(utils.EcodeSystemError, "commit db fail")
 	}
 	return nil
@@ -175,7 +175,7 @@ func (ctrl *ConfigCtrl) changeAppConfigState(appID int64, appNode, configName st
                             on duplicate key update version=?`,
 		appID, appNode, configName, version, version)
 	if err != nil {
-		glog.Errorf("change app(%d - %s) config(%s) state(ver: %d) fail: %v", appID, appNode, configName, version, err)
+		logging.Errorf("change app(%d - %s) config(%s) state(ver: %d) fail: %v", appID, appNode, configName, version, err)
 		return utils.NewError(utils.EcodeSystemError, "change app config state fail")
 	}
 	return nil
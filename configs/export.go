@@ -0,0 +1,40 @@
+package configs
+
+import (
+	"context"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+// Export all configs, at revision if not 0
+func (ctrl *ConfigCtrl) Export(ctx context.Context, revision int64) ([]ConfigItem, int64, error) {
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+	resp, err := ctrl.etcdClient.Get(ctx, ctrl.config.KeyPrefix+"/", opts...)
+	if err != nil {
+		return nil, 0, utils.CleanErr(err, "export configs fail", "get configs fail: %v", err)
+	}
+	cfgs := make([]ConfigItem, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		cfgs = append(cfgs, configFromKv(string(kv.Key)[len(ctrl.config.KeyPrefix)+1:], kv))
+	}
+	return cfgs, resp.Header.Revision, nil
+}
+
+// Import put configs, existing ones are overwritten, returns the number imported
+func (ctrl *ConfigCtrl) Import(ctx context.Context, tag string, cfgs []ConfigItem) (int, error) {
+	for _, cfg := range cfgs {
+		if err := checkName(cfg.Name); err != nil {
+			return 0, utils.Errorf(utils.EcodeInvalidName, "invalid config name: %s", cfg.Name)
+		}
+	}
+	for i, cfg := range cfgs {
+		if _, err := ctrl.Put(ctx, tag, cfg.Name, 0, "imported", cfg.Value, -1); err != nil {
+			return i, err
+		}
+	}
+	return len(cfgs), nil
+}
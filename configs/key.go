@@ -4,13 +4,12 @@ import (
 	"fmt"
 	"regexp"
 
+	"github.com/infrmods/xbus/services"
 	"github.com/infrmods/xbus/utils"
 )
 
-var rValidName = regexp.MustCompile(`(?i)^[a-z][a-z0-9_.-]{5,}$`)
-
 func checkName(name string) error {
-	if !rValidName.MatchString(name) {
+	if !services.ValidName(name) {
 		return utils.NewError(utils.EcodeInvalidName, "")
 	}
 	return nil
@@ -0,0 +1,108 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
+)
+
+// Config leader election among replicas, only the leader runs singleton duties
+// (gc, mirrors, webhooks, backups, alerts), all replicas serve requests
+type Config struct {
+	Enable bool
+	Key    string        `default:"/xbus-leader"`
+	TTL    time.Duration `default:"10s"`
+}
+
+const retryInterval = 5 * time.Second
+
+// Elector campaigns for leadership by etcd election of Key
+type Elector struct {
+	config Config
+	client *clientv3.Client
+	id     string
+	leader int32
+}
+
+// NewElector new elector, identified by hostname and pid
+func NewElector(config *Config, client *clientv3.Client) *Elector {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return &Elector{config: *config, client: client, id: fmt.Sprintf("%s-%d", hostname, os.Getpid())}
+}
+
+// IsLeader whether the duties are running, always true if not enabled
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+func (e *Elector) setLeader(leader bool) {
+	if leader {
+		atomic.StoreInt32(&e.leader, 1)
+		metrics.Leader.Set(1)
+	} else {
+		atomic.StoreInt32(&e.leader, 0)
+		metrics.Leader.Set(0)
+	}
+}
+
+// Run run duties with a ctx canceled when the leadership is lost and campaign again, until ctx done;
+// duties should return once background jobs of ctx started, run directly if not enabled
+func (e *Elector) Run(ctx context.Context, duties func(ctx context.Context)) {
+	if !e.config.Enable {
+		e.setLeader(true)
+		duties(ctx)
+		return
+	}
+	for ctx.Err() == nil {
+		err := e.lead(ctx, duties)
+		if ctx.Err() != nil {
+			return
+		}
+		logging.Warningf("leader election(%s) fail, retry in %v: %v", e.config.Key, retryInterval, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (e *Elector) lead(ctx context.Context, duties func(ctx context.Context)) error {
+	ttl := int(e.config.TTL / time.Second)
+	if ttl <= 0 {
+		ttl = 10
+	}
+	// the session outlives ctx so that its lease is revoked on close, letting others take over at once
+	session, err := concurrency.NewSession(e.client, concurrency.WithTTL(ttl))
+	if err != nil {
+		return fmt.Errorf("create session fail: %v", err)
+	}
+	defer session.Close()
+	election := concurrency.NewElection(session, e.config.Key)
+	if err := election.Campaign(ctx, e.id); err != nil {
+		return fmt.Errorf("campaign fail: %v", err)
+	}
+	logging.Infof("%s became leader of %s", e.id, e.config.Key)
+	e.setLeader(true)
+	defer e.setLeader(false)
+	dutiesCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go duties(dutiesCtx)
+	select {
+	case <-ctx.Done():
+		logging.Infof("%s resign leader of %s", e.id, e.config.Key)
+		return nil
+	case <-session.Done():
+		return fmt.Errorf("session lease expired, lost the leadership")
+	}
+}
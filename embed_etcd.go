@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/coreos/etcd/compactor"
+	"github.com/coreos/etcd/embed"
+	"github.com/infrmods/xbus/logging"
+)
+
+// EmbedEtcdConfig etcd server run in the xbus process for small or dev environments,
+// the etcd client of xbus and other commands connect to ClientURL instead of etcd.endpoints
+type EmbedEtcdConfig struct {
+	Enable                  bool
+	Name                    string        `default:"xbus"`
+	Dir                     string        `default:"xbus.etcd"`
+	ClientURL               string        `default:"http://127.0.0.1:2379" yaml:"client_url"`
+	PeerURL                 string        `default:"http://127.0.0.1:2380" yaml:"peer_url"`
+	AutoCompactionRetention string        `default:"1h" yaml:"auto_compaction_retention"`
+	StartTimeout            time.Duration `default:"1m" yaml:"start_timeout"`
+}
+
+func (config *EmbedEtcdConfig) newEmbedConfig() (*embed.Config, error) {
+	cfg := embed.NewConfig()
+	cfg.Name = config.Name
+	cfg.Dir = config.Dir
+	clientURL, err := url.Parse(config.ClientURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client_url: %v", err)
+	}
+	peerURL, err := url.Parse(config.PeerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer_url: %v", err)
+	}
+	cfg.LCUrls, cfg.ACUrls = []url.URL{*clientURL}, []url.URL{*clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{*peerURL}, []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	cfg.AutoCompactionMode = compactor.ModePeriodic
+	cfg.AutoCompactionRetention = config.AutoCompactionRetention
+	return cfg, nil
+}
+
+// StartEmbedEtcd start embedded etcd server if enabled, nil if not
+func (x *XBus) StartEmbedEtcd() *embed.Etcd {
+	config := &x.Config.EmbedEtcd
+	if !config.Enable {
+		return nil
+	}
+	cfg, err := config.newEmbedConfig()
+	if err != nil {
+		logging.Errorf("invalid embed etcd config: %v", err)
+		os.Exit(-1)
+	}
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		logging.Errorf("start embed etcd fail: %v", err)
+		os.Exit(-1)
+	}
+	timeout := config.StartTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+		logging.Infof("embed etcd(%s) started at %s", config.Dir, config.ClientURL)
+	case err := <-e.Err():
+		logging.Errorf("embed etcd fail: %v", err)
+		os.Exit(-1)
+	case <-time.After(timeout):
+		e.Close()
+		logging.Errorf("embed etcd not ready in %v", timeout)
+		os.Exit(-1)
+	}
+	return e
+}
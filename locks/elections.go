@@ -0,0 +1,119 @@
+package locks
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+// Leader leader of an election
+type Leader struct {
+	Name string `json:"name"`
+	// App name of the app elected
+	App     string           `json:"app"`
+	Value   string           `json:"value"`
+	LeaseID clientv3.LeaseID `json:"lease_id,omitempty"`
+	// Revision create revision of the leader's key, increasing with leaders
+	Revision int64 `json:"revision"`
+}
+
+type candidate struct {
+	App   string `json:"app"`
+	Value string `json:"value"`
+}
+
+func (ctrl *LockCtrl) electionPrefix(name string) string {
+	return ctrl.config.ElectionKeyPrefix + "/" + name + "/"
+}
+
+// Campaign wait until leaseID of app is elected as leader of name or ctx done,
+// the candidate is removed if not elected
+func (ctrl *LockCtrl) Campaign(ctx context.Context, name string, leaseID clientv3.LeaseID, app, value string) (*Leader, error) {
+	if err := checkName("election", name); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(candidate{App: app, Value: value})
+	if err != nil {
+		return nil, utils.Errorf(utils.EcodeSystemError, "marshal candidate fail: %v", err)
+	}
+	revision, err := ctrl.enqueue(ctx, "election("+name+")", ctrl.electionPrefix(name), leaseID, string(data))
+	if err != nil {
+		return nil, err
+	}
+	return &Leader{Name: name, App: app, Value: value, LeaseID: leaseID, Revision: revision}, nil
+}
+
+func candidateOwner(value []byte) string {
+	var c candidate
+	if err := json.Unmarshal(value, &c); err != nil {
+		return ""
+	}
+	return c.App
+}
+
+// Resign resign the leadership or stop campaigning of leaseID by app (any app if empty),
+// fails like Unlock
+func (ctrl *LockCtrl) Resign(ctx context.Context, name string, leaseID clientv3.LeaseID, app string) error {
+	if err := checkName("election", name); err != nil {
+		return err
+	}
+	return ctrl.dequeue(ctx, "election("+name+")", ctrl.electionPrefix(name), leaseID, app, candidateOwner)
+}
+
+// Leader current leader of name, nil if none, with the revision
+func (ctrl *LockCtrl) Leader(ctx context.Context, name string) (*Leader, int64, error) {
+	if err := checkName("election", name); err != nil {
+		return nil, 0, err
+	}
+	resp, err := ctrl.etcdClient.Get(ctx, ctrl.electionPrefix(name), clientv3.WithFirstCreate()...)
+	if err != nil {
+		return nil, 0, utils.CleanErr(err, "get leader fail", "get leader of %s fail: %v", name, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, resp.Header.Revision, nil
+	}
+	kv := resp.Kvs[0]
+	var c candidate
+	if err := json.Unmarshal(kv.Value, &c); err != nil {
+		c.Value = string(kv.Value)
+	}
+	return &Leader{Name: name, App: c.App, Value: c.Value,
+		LeaseID: clientv3.LeaseID(kv.Lease), Revision: kv.CreateRevision}, resp.Header.Revision, nil
+}
+
+// Observe wait until the leader of name is not the one elected at leaderRevision
+// (0 for no leader) or ctx done, returns the new leader
+func (ctrl *LockCtrl) Observe(ctx context.Context, name string, leaderRevision int64) (*Leader, error) {
+	for {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return nil, utils.Errorf(utils.EcodeDeadlineExceeded, "leader of %s unchanged", name)
+		case context.Canceled:
+			return nil, utils.Errorf(utils.EcodeCanceled, "observe election(%s) canceled", name)
+		}
+		leader, revision, err := ctrl.Leader(ctx, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return nil, err
+		}
+		current := int64(0)
+		if leader != nil {
+			current = leader.Revision
+		}
+		if current != leaderRevision {
+			return leader, nil
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		for wresp := range ctrl.etcdClient.Watch(watchCtx, ctrl.electionPrefix(name),
+			clientv3.WithPrefix(), clientv3.WithRev(revision+1)) {
+			if len(wresp.Events) > 0 || wresp.Err() != nil {
+				break
+			}
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,47 @@
+package locks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/infrmods/xbus/utils"
+)
+
+func TestResignOwnership(t *testing.T) {
+	ctrl, etcdClient, stop := newTestCtrl(t)
+	defer stop()
+	ctx := context.Background()
+	leaseID := grant(t, etcdClient)
+	if _, err := ctrl.Campaign(ctx, "master", leaseID, "app-a", "node-1"); err != nil {
+		t.Fatal(err)
+	}
+	leader, _, err := ctrl.Leader(ctx, "master")
+	if err != nil || leader == nil || leader.App != "app-a" || leader.Value != "node-1" {
+		t.Fatalf("unexpected leader: %+v, %v", leader, err)
+	}
+
+	if err := ctrl.Resign(ctx, "master", leaseID, "app-b"); errCode(err) != utils.EcodeNotPermitted {
+		t.Errorf("resign by another app: %v", err)
+	}
+	if leader, _, _ := ctrl.Leader(ctx, "master"); leader == nil {
+		t.Fatal("leader resigned by another app")
+	}
+	if err := ctrl.Resign(ctx, "master", grant(t, etcdClient), "app-a"); errCode(err) != utils.EcodeNotFound {
+		t.Errorf("resign by an unrelated lease: %v", err)
+	}
+
+	if err := ctrl.Resign(ctx, "master", leaseID, "app-a"); err != nil {
+		t.Fatalf("resign by leader: %v", err)
+	}
+	if leader, _, _ := ctrl.Leader(ctx, "master"); leader != nil {
+		t.Fatalf("leader not resigned: %+v", leader)
+	}
+
+	leaseID = grant(t, etcdClient)
+	if _, err := ctrl.Campaign(ctx, "master", leaseID, "app-a", "node-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.Resign(ctx, "master", leaseID, ""); err != nil {
+		t.Errorf("resign by admin: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package locks
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/utils"
+)
+
+// Config module config
+type Config struct {
+	KeyPrefix         string `default:"/locks" yaml:"key_prefix"`
+	ElectionKeyPrefix string `default:"/elections" yaml:"election_key_prefix"`
+}
+
+// LockCtrl distributed locks and elections, each waiter puts a key under <KeyPrefix>/<name>/
+// bound to its lease, and the lock is held by the waiter of the lowest create revision;
+// candidates of elections are queued the same under <ElectionKeyPrefix>/<name>/
+type LockCtrl struct {
+	config     Config
+	etcdClient *clientv3.Client
+}
+
+// NewLockCtrl new lock ctrl
+func NewLockCtrl(config *Config, etcdClient *clientv3.Client) *LockCtrl {
+	ctrl := &LockCtrl{config: *config, etcdClient: etcdClient}
+	ctrl.config.KeyPrefix = strings.TrimSuffix(ctrl.config.KeyPrefix, "/")
+	ctrl.config.ElectionKeyPrefix = strings.TrimSuffix(ctrl.config.ElectionKeyPrefix, "/")
+	return ctrl
+}
+
+// Lock holder of a lock
+type Lock struct {
+	Name string `json:"name"`
+	// App name of the app holding the lock
+	App string `json:"app"`
+	// LeaseID lease of the holder, only returned to the holder (and admins)
+	LeaseID clientv3.LeaseID `json:"lease_id,omitempty"`
+	// Revision create revision of the holder's key, increasing with holders, usable as a fencing token
+	Revision int64 `json:"revision"`
+}
+
+var rValidName = regexp.MustCompile(`(?i)^[a-z][a-z0-9_.-]{0,127}$`)
+
+func checkName(kind, name string) error {
+	if !rValidName.MatchString(name) {
+		return utils.Errorf(utils.EcodeInvalidName, "invalid %s name: %s", kind, name)
+	}
+	return nil
+}
+
+func (ctrl *LockCtrl) prefix(name string) string {
+	return ctrl.config.KeyPrefix + "/" + name + "/"
+}
+
+func leaseKey(prefix string, leaseID clientv3.LeaseID) string {
+	return fmt.Sprintf("%s%x", prefix, int64(leaseID))
+}
+
+const deleteTimeout = 10 * time.Second
+
+// Lock wait until the lock of name is held by leaseID of app or ctx done,
+// the waiting key is deleted if not acquired
+func (ctrl *LockCtrl) Lock(ctx context.Context, name string, leaseID clientv3.LeaseID, app string) (*Lock, error) {
+	if err := checkName("lock", name); err != nil {
+		return nil, err
+	}
+	revision, err := ctrl.enqueue(ctx, "lock("+name+")", ctrl.prefix(name), leaseID, app)
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{Name: name, App: app, LeaseID: leaseID, Revision: revision}, nil
+}
+
+// lockOwner app owning a lock key, its value
+func lockOwner(value []byte) string {
+	return string(value)
+}
+
+// enqueue put the key of leaseID under prefix and wait until it's the first one,
+// the key is deleted if failed, returns its create revision
+func (ctrl *LockCtrl) enqueue(ctx context.Context, what, prefix string, leaseID clientv3.LeaseID, value string) (int64, error) {
+	key := leaseKey(prefix, leaseID)
+	resp, err := ctrl.etcdClient.Txn(ctx).If(
+		clientv3.Compare(clientv3.CreateRevision(key), "=", 0),
+	).Then(clientv3.OpPut(key, value, clientv3.WithLease(leaseID))).Else(clientv3.OpGet(key)).Commit()
+	if err != nil {
+		return 0, utils.CleanErr(err, what+" fail", "put %s key(%s) fail: %v", what, key, err)
+	}
+	revision := resp.Header.Revision
+	if !resp.Succeeded {
+		// queued by the lease already, e.g. a retried request
+		revision = resp.Responses[0].GetResponseRange().Kvs[0].CreateRevision
+	}
+	if err := ctrl.waitPrevious(ctx, what, prefix, revision); err != nil {
+		deleteCtx, cancel := context.WithTimeout(context.Background(), deleteTimeout)
+		defer cancel()
+		ctrl.etcdClient.Delete(deleteCtx, key)
+		return 0, err
+	}
+	return revision, nil
+}
+
+// waitPrevious wait until all keys under prefix created before revision deleted,
+// fails if the key of revision is deleted too (lease expired)
+func (ctrl *LockCtrl) waitPrevious(ctx context.Context, what, prefix string, revision int64) error {
+	for {
+		resp, err := ctrl.etcdClient.Get(ctx, prefix, clientv3.WithFirstCreate()...)
+		if err != nil {
+			return waitErr(ctx, what, err)
+		}
+		if len(resp.Kvs) == 0 || resp.Kvs[0].CreateRevision > revision {
+			return utils.Errorf(utils.EcodeNotFound, "%s key deleted, lease expired", what)
+		}
+		if resp.Kvs[0].CreateRevision == revision {
+			return nil
+		}
+		// watch the last waiter before us only, avoiding herds
+		last, err := ctrl.etcdClient.Get(ctx, prefix,
+			append(clientv3.WithLastCreate(), clientv3.WithMaxCreateRev(revision-1))...)
+		if err != nil {
+			return waitErr(ctx, what, err)
+		}
+		if len(last.Kvs) == 0 {
+			continue
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		deleted := false
+		for wresp := range ctrl.etcdClient.Watch(watchCtx, string(last.Kvs[0].Key), clientv3.WithRev(last.Header.Revision+1)) {
+			for _, event := range wresp.Events {
+				deleted = deleted || event.Type == mvccpb.DELETE
+			}
+			if deleted || wresp.Err() != nil {
+				break
+			}
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return waitErr(ctx, what, ctx.Err())
+		}
+	}
+}
+
+func waitErr(ctx context.Context, what string, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return utils.Errorf(utils.EcodeDeadlineExceeded, "%s not acquired in time", what)
+	case context.Canceled:
+		return utils.Errorf(utils.EcodeCanceled, "%s canceled", what)
+	}
+	return utils.CleanErr(err, what+" fail", "wait %s fail: %v", what, err)
+}
+
+// Unlock release or stop waiting the lock of name by leaseID of app (any app if empty),
+// fails with NOT_FOUND if leaseID is not queued for the lock, or NOT_PERMITTED if it's
+// another app's, then its lease should not be revoked
+func (ctrl *LockCtrl) Unlock(ctx context.Context, name string, leaseID clientv3.LeaseID, app string) error {
+	if err := checkName("lock", name); err != nil {
+		return err
+	}
+	return ctrl.dequeue(ctx, "lock("+name+")", ctrl.prefix(name), leaseID, app, lockOwner)
+}
+
+// dequeue delete the key of leaseID under prefix if it's bound to leaseID and owned by app
+// (any app if empty) by owner of its value
+func (ctrl *LockCtrl) dequeue(ctx context.Context, what, prefix string, leaseID clientv3.LeaseID,
+	app string, owner func(value []byte) string) error {
+	key := leaseKey(prefix, leaseID)
+	resp, err := ctrl.etcdClient.Get(ctx, key)
+	if err != nil {
+		return utils.CleanErr(err, what+" fail", "get %s key(%s) fail: %v", what, key, err)
+	}
+	if len(resp.Kvs) == 0 || clientv3.LeaseID(resp.Kvs[0].Lease) != leaseID {
+		return utils.Errorf(utils.EcodeNotFound, "%s of lease %x not found", what, int64(leaseID))
+	}
+	if app != "" && owner(resp.Kvs[0].Value) != app {
+		return utils.NewNotPermittedError(fmt.Sprintf("%s of lease %x not owned by %s", what, int64(leaseID), app), nil)
+	}
+	txnResp, err := ctrl.etcdClient.Txn(ctx).If(
+		clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision),
+	).Then(clientv3.OpDelete(key)).Commit()
+	if err != nil {
+		return utils.CleanErr(err, what+" fail", "delete %s key(%s) fail: %v", what, key, err)
+	}
+	if !txnResp.Succeeded {
+		return utils.Errorf(utils.EcodeNotFound, "%s of lease %x not found", what, int64(leaseID))
+	}
+	return nil
+}
+
+// Holder current holder of the lock of name, nil if not locked
+func (ctrl *LockCtrl) Holder(ctx context.Context, name string) (*Lock, error) {
+	if err := checkName("lock", name); err != nil {
+		return nil, err
+	}
+	resp, err := ctrl.etcdClient.Get(ctx, ctrl.prefix(name), clientv3.WithFirstCreate()...)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get lock fail", "get lock(%s) fail: %v", name, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	kv := resp.Kvs[0]
+	return &Lock{Name: name, App: lockOwner(kv.Value), LeaseID: clientv3.LeaseID(kv.Lease), Revision: kv.CreateRevision}, nil
+}
@@ -0,0 +1,109 @@
+package locks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+	"github.com/infrmods/xbus/utils/etcdtest"
+)
+
+func newTestCtrl(t *testing.T) (*LockCtrl, *clientv3.Client, func()) {
+	etcdClient, stop := etcdtest.Start(t)
+	return NewLockCtrl(&Config{KeyPrefix: "/locks", ElectionKeyPrefix: "/elections"}, etcdClient), etcdClient, stop
+}
+
+func grant(t *testing.T, etcdClient *clientv3.Client) clientv3.LeaseID {
+	resp, err := etcdClient.Grant(context.Background(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.ID
+}
+
+func errCode(err error) string {
+	if e, ok := err.(*utils.Error); ok {
+		return e.Code
+	}
+	return ""
+}
+
+func TestLockOwnership(t *testing.T) {
+	ctrl, etcdClient, stop := newTestCtrl(t)
+	defer stop()
+	ctx := context.Background()
+	leaseID := grant(t, etcdClient)
+	lock, err := ctrl.Lock(ctx, "jobs", leaseID, "app-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.App != "app-a" || lock.LeaseID != leaseID {
+		t.Fatalf("unexpected lock: %+v", lock)
+	}
+	holder, err := ctrl.Holder(ctx, "jobs")
+	if err != nil || holder.App != "app-a" || holder.Revision != lock.Revision {
+		t.Fatalf("unexpected holder: %+v, %v", holder, err)
+	}
+
+	if err := ctrl.Unlock(ctx, "jobs", leaseID, "app-b"); errCode(err) != utils.EcodeNotPermitted {
+		t.Errorf("unlock by another app: %v", err)
+	}
+	if holder, _ := ctrl.Holder(ctx, "jobs"); holder == nil {
+		t.Fatal("lock released by another app")
+	}
+	// a lease of something else, e.g. an endpoint
+	other := grant(t, etcdClient)
+	if err := ctrl.Unlock(ctx, "jobs", other, "app-b"); errCode(err) != utils.EcodeNotFound {
+		t.Errorf("unlock by an unrelated lease: %v", err)
+	}
+
+	if err := ctrl.Unlock(ctx, "jobs", leaseID, "app-a"); err != nil {
+		t.Fatalf("unlock by holder: %v", err)
+	}
+	if holder, _ := ctrl.Holder(ctx, "jobs"); holder != nil {
+		t.Fatalf("lock not released: %+v", holder)
+	}
+
+	leaseID = grant(t, etcdClient)
+	if _, err := ctrl.Lock(ctx, "jobs", leaseID, "app-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.Unlock(ctx, "jobs", leaseID, ""); err != nil {
+		t.Errorf("unlock by admin: %v", err)
+	}
+}
+
+func TestLockQueue(t *testing.T) {
+	ctrl, etcdClient, stop := newTestCtrl(t)
+	defer stop()
+	ctx := context.Background()
+	first, second := grant(t, etcdClient), grant(t, etcdClient)
+	if _, err := ctrl.Lock(ctx, "jobs", first, "app-a"); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := ctrl.Lock(waitCtx, "jobs", second, "app-b"); errCode(err) != utils.EcodeDeadlineExceeded {
+		t.Fatalf("lock held by another app acquired: %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := ctrl.Lock(ctx, "jobs", second, "app-b")
+		acquired <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := ctrl.Unlock(ctx, "jobs", first, "app-a"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter not acquired after unlock")
+	}
+}
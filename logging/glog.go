@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"github.com/golang/glog"
+)
+
+// GlogLogger glog backend, debug level maps to -v=1
+type GlogLogger struct{}
+
+// Enabled impl Logger
+func (GlogLogger) Enabled(level Level) bool {
+	if level == LevelDebug {
+		return bool(glog.V(1))
+	}
+	return true
+}
+
+// Log impl Logger
+func (GlogLogger) Log(depth int, level Level, msg string, keyvals []interface{}) {
+	if len(keyvals) > 0 {
+		msg = msg + " " + FormatKeyvals(keyvals)
+	}
+	switch level {
+	case LevelDebug, LevelInfo:
+		glog.InfoDepth(depth, msg)
+	case LevelWarning:
+		glog.WarningDepth(depth, msg)
+	default:
+		glog.ErrorDepth(depth, msg)
+	}
+}
+
+// Flush impl Flusher
+func (GlogLogger) Flush() {
+	glog.Flush()
+}
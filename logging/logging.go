@@ -0,0 +1,230 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Level log level
+type Level int
+
+const (
+	// LevelDebug debug
+	LevelDebug Level = iota
+	// LevelInfo info
+	LevelInfo
+	// LevelWarning warning
+	LevelWarning
+	// LevelError error
+	LevelError
+)
+
+func (level Level) String() string {
+	switch level {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarning:
+		return "warning"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+}
+
+// Logger pluggable logger backend
+//
+// depth is the number of stack frames between the caller and Log,
+// keyvals are alternating keys and values
+type Logger interface {
+	Enabled(level Level) bool
+	Log(depth int, level Level, msg string, keyvals []interface{})
+}
+
+var (
+	logger Logger = GlogLogger{}
+	lock   sync.RWMutex
+)
+
+// SetLogger set logger backend
+func SetLogger(l Logger) {
+	lock.Lock()
+	defer lock.Unlock()
+	logger = l
+}
+
+func getLogger() Logger {
+	lock.RLock()
+	defer lock.RUnlock()
+	return logger
+}
+
+// Flusher logger backend buffering logs, flushed before fatal exits
+type Flusher interface {
+	Flush()
+}
+
+// Flush flush the logger backend if it's a Flusher
+func Flush() {
+	if flusher, ok := getLogger().(Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func exit() {
+	Flush()
+	os.Exit(255)
+}
+
+// FormatKeyvals format keyvals as `k=v k=v`
+func FormatKeyvals(keyvals []interface{}) string {
+	parts := make([]string, 0, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			parts = append(parts, fmt.Sprintf("%v=%v", keyvals[i], keyvals[i+1]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%v=", keyvals[i]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Entry logger with fields
+type Entry struct {
+	keyvals []interface{}
+}
+
+var std = &Entry{}
+
+// With new entry with extra fields
+func (entry *Entry) With(keyvals ...interface{}) *Entry {
+	kvs := make([]interface{}, 0, len(entry.keyvals)+len(keyvals))
+	kvs = append(kvs, entry.keyvals...)
+	kvs = append(kvs, keyvals...)
+	return &Entry{keyvals: kvs}
+}
+
+func (entry *Entry) logf(level Level, format string, args ...interface{}) {
+	l := getLogger()
+	if !l.Enabled(level) {
+		return
+	}
+	l.Log(3, level, fmt.Sprintf(format, args...), entry.keyvals)
+}
+
+func (entry *Entry) log(level Level, args ...interface{}) {
+	l := getLogger()
+	if !l.Enabled(level) {
+		return
+	}
+	l.Log(3, level, fmt.Sprint(args...), entry.keyvals)
+}
+
+// DebugEnabled is debug enabled
+func (entry *Entry) DebugEnabled() bool {
+	return getLogger().Enabled(LevelDebug)
+}
+
+// Debugf debugf
+func (entry *Entry) Debugf(format string, args ...interface{}) {
+	entry.logf(LevelDebug, format, args...)
+}
+
+// Infof infof
+func (entry *Entry) Infof(format string, args ...interface{}) {
+	entry.logf(LevelInfo, format, args...)
+}
+
+// Info info
+func (entry *Entry) Info(args ...interface{}) {
+	entry.log(LevelInfo, args...)
+}
+
+// Warningf warningf
+func (entry *Entry) Warningf(format string, args ...interface{}) {
+	entry.logf(LevelWarning, format, args...)
+}
+
+// Errorf errorf
+func (entry *Entry) Errorf(format string, args ...interface{}) {
+	entry.logf(LevelError, format, args...)
+}
+
+// Fatalf log error and exit
+func (entry *Entry) Fatalf(format string, args ...interface{}) {
+	entry.logf(LevelError, format, args...)
+	exit()
+}
+
+// Fatal log error and exit
+func (entry *Entry) Fatal(args ...interface{}) {
+	entry.log(LevelError, args...)
+	exit()
+}
+
+type entryKey struct{}
+
+// NewContext new context carrying entry
+func NewContext(ctx context.Context, entry *Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// FromContext entry from context, or the default one
+func FromContext(ctx context.Context) *Entry {
+	if entry, ok := ctx.Value(entryKey{}).(*Entry); ok {
+		return entry
+	}
+	return std
+}
+
+// With new entry with fields
+func With(keyvals ...interface{}) *Entry {
+	return std.With(keyvals...)
+}
+
+// DebugEnabled is debug enabled
+func DebugEnabled() bool {
+	return getLogger().Enabled(LevelDebug)
+}
+
+// Debugf debugf
+func Debugf(format string, args ...interface{}) {
+	std.logf(LevelDebug, format, args...)
+}
+
+// Infof infof
+func Infof(format string, args ...interface{}) {
+	std.logf(LevelInfo, format, args...)
+}
+
+// Info info
+func Info(args ...interface{}) {
+	std.log(LevelInfo, args...)
+}
+
+// Warningf warningf
+func Warningf(format string, args ...interface{}) {
+	std.logf(LevelWarning, format, args...)
+}
+
+// Errorf errorf
+func Errorf(format string, args ...interface{}) {
+	std.logf(LevelError, format, args...)
+}
+
+// Fatalf log error and exit
+func Fatalf(format string, args ...interface{}) {
+	std.logf(LevelError, format, args...)
+	exit()
+}
+
+// Fatal log error and exit
+func Fatal(args ...interface{}) {
+	std.log(LevelError, args...)
+	exit()
+}
@@ -0,0 +1,60 @@
+package logruslog
+
+import (
+	"fmt"
+
+	"github.com/infrmods/xbus/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// Logger logrus backend
+type Logger struct {
+	logger *logrus.Logger
+}
+
+// New new logrus backend
+func New(logger *logrus.Logger) *Logger {
+	return &Logger{logger: logger}
+}
+
+func logrusLevel(level logging.Level) logrus.Level {
+	switch level {
+	case logging.LevelDebug:
+		return logrus.DebugLevel
+	case logging.LevelInfo:
+		return logrus.InfoLevel
+	case logging.LevelWarning:
+		return logrus.WarnLevel
+	default:
+		return logrus.ErrorLevel
+	}
+}
+
+// Enabled impl logging.Logger
+func (l *Logger) Enabled(level logging.Level) bool {
+	return l.logger.IsLevelEnabled(logrusLevel(level))
+}
+
+// Log impl logging.Logger
+func (l *Logger) Log(depth int, level logging.Level, msg string, keyvals []interface{}) {
+	fields := make(logrus.Fields, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		if i+1 < len(keyvals) {
+			fields[key] = keyvals[i+1]
+		} else {
+			fields[key] = nil
+		}
+	}
+	entry := l.logger.WithFields(fields)
+	switch level {
+	case logging.LevelDebug:
+		entry.Debug(msg)
+	case logging.LevelInfo:
+		entry.Info(msg)
+	case logging.LevelWarning:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
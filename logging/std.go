@@ -0,0 +1,26 @@
+package logging
+
+import (
+	"log"
+	"strings"
+)
+
+// StdLogger standard library log backend
+type StdLogger struct {
+	Logger *log.Logger
+	Level  Level
+}
+
+// Enabled impl Logger
+func (l *StdLogger) Enabled(level Level) bool {
+	return level >= l.Level
+}
+
+// Log impl Logger
+func (l *StdLogger) Log(depth int, level Level, msg string, keyvals []interface{}) {
+	line := strings.ToUpper(level.String()) + " " + msg
+	if len(keyvals) > 0 {
+		line += " " + FormatKeyvals(keyvals)
+	}
+	l.Logger.Output(depth+1, line)
+}
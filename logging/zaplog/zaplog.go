@@ -0,0 +1,55 @@
+package zaplog
+
+import (
+	"github.com/infrmods/xbus/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Logger zap backend
+type Logger struct {
+	logger *zap.Logger
+}
+
+// New new zap backend
+func New(logger *zap.Logger) *Logger {
+	return &Logger{logger: logger}
+}
+
+func zapLevel(level logging.Level) zapcore.Level {
+	switch level {
+	case logging.LevelDebug:
+		return zapcore.DebugLevel
+	case logging.LevelInfo:
+		return zapcore.InfoLevel
+	case logging.LevelWarning:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.ErrorLevel
+	}
+}
+
+// Enabled impl logging.Logger
+func (l *Logger) Enabled(level logging.Level) bool {
+	return l.logger.Core().Enabled(zapLevel(level))
+}
+
+// Log impl logging.Logger
+func (l *Logger) Log(depth int, level logging.Level, msg string, keyvals []interface{}) {
+	sugar := l.logger.WithOptions(zap.AddCallerSkip(depth)).Sugar()
+	switch level {
+	case logging.LevelDebug:
+		sugar.Debugw(msg, keyvals...)
+	case logging.LevelInfo:
+		sugar.Infow(msg, keyvals...)
+	case logging.LevelWarning:
+		sugar.Warnw(msg, keyvals...)
+	default:
+		sugar.Errorw(msg, keyvals...)
+	}
+}
+
+// Flush impl logging.Flusher
+func (l *Logger) Flush() {
+	l.logger.Sync()
+}
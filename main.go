@@ -2,21 +2,31 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"database/sql"
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/gocomm/config"
-	"github.com/golang/glog"
 	"github.com/google/subcommands"
+	"github.com/infrmods/xbus/alerts"
 	"github.com/infrmods/xbus/api"
 	"github.com/infrmods/xbus/apps"
 	"github.com/infrmods/xbus/configs"
+	"github.com/infrmods/xbus/election"
+	"github.com/infrmods/xbus/locks"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/schemas"
+	"github.com/infrmods/xbus/secrets"
 	"github.com/infrmods/xbus/services"
+	"github.com/infrmods/xbus/snapshots"
+	"github.com/infrmods/xbus/streams"
 	"github.com/infrmods/xbus/utils"
+	"github.com/infrmods/xbus/webhooks"
 	"gopkg.in/yaml.v2"
 
 	_ "github.com/gocomm/dbutil/dialects/mysql"
@@ -24,11 +34,20 @@ import (
 
 // Config xbus config
 type Config struct {
-	Etcd     utils.ETCDConfig
-	Services services.Config
-	Configs  configs.Config
-	Apps     apps.Config
-	API      api.Config
+	Etcd      utils.ETCDConfig
+	EmbedEtcd EmbedEtcdConfig `yaml:"embed_etcd"`
+	Services  services.Config
+	Configs   configs.Config
+	Apps      apps.Config
+	Locks     locks.Config
+	Schemas   schemas.Config
+	Secrets   secrets.Config
+	API       api.Config
+	Alerts    alerts.Config
+	Webhooks  webhooks.Config
+	Streams   streams.Config
+	Backup    snapshots.BackupConfig
+	Election  election.Config
 
 	DB struct {
 		Driver  string `default:"mysql"`
@@ -42,66 +61,208 @@ var cfgPath = flag.String("config", "config.yaml", "config file path")
 // XBus xbus
 type XBus struct {
 	Config Config
+
+	closers []io.Closer
+}
+
+// loadConfig load config from the config file, the default config if none
+func loadConfig(cfg *Config) error {
+	if *cfgPath == "" {
+		if err := config.DefaultConfig(cfg); err != nil {
+			return fmt.Errorf("set default config file fail: %v", err)
+		}
+	} else if err := config.LoadFromFileF(*cfgPath, cfg, yaml.Unmarshal); err != nil {
+		return fmt.Errorf("load config file fail: %v", err)
+	}
+	return nil
 }
 
 // NewXBus new xbus
 func NewXBus() *XBus {
 	var x XBus
-	if *cfgPath == "" {
-		if err := config.DefaultConfig(&x.Config); err != nil {
-			glog.Errorf("set default config file fail: %v", err)
-			os.Exit(-1)
-		}
-	} else if err := config.LoadFromFileF(*cfgPath, &x.Config, yaml.Unmarshal); err != nil {
-		glog.Errorf("load config file fail: %v", err)
+	if err := loadConfig(&x.Config); err != nil {
+		logging.Errorf("%v", err)
 		os.Exit(-1)
 	}
 
 	return &x
 }
 
+// reloader prepare reloading part of config, returns the func applying it
+type reloader func(config *Config) (func(), error)
+
+// Reload load the config file again, then apply its reloadable parts if all reloaders
+// prepared them, nothing is changed otherwise; x.Config is kept as loaded at start
+func (x *XBus) Reload(reloaders ...reloader) error {
+	var cfg Config
+	if err := loadConfig(&cfg); err != nil {
+		return err
+	}
+	applies := make([]func(), 0, len(reloaders))
+	for _, reloader := range reloaders {
+		apply, err := reloader(&cfg)
+		if err != nil {
+			return err
+		}
+		applies = append(applies, apply)
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	return nil
+}
+
 // NewDB new db
 func (x *XBus) NewDB() *sql.DB {
 	db, err := sql.Open(x.Config.DB.Driver, x.Config.DB.Source)
 	if err != nil {
-		glog.Errorf("open database fail: %v", err)
+		logging.Errorf("open database fail: %v", err)
 		os.Exit(-1)
 	}
 	db.SetMaxOpenConns(x.Config.DB.MaxConn)
+	x.closers = append(x.closers, db)
 	return db
 }
 
+// Close close etcd clients and dbs created by x
+func (x *XBus) Close() {
+	for i := len(x.closers) - 1; i >= 0; i-- {
+		if err := x.closers[i].Close(); err != nil {
+			logging.Warningf("close %T fail: %v", x.closers[i], err)
+		}
+	}
+	x.closers = nil
+}
+
 // NewEtcdClient new etcd client
 func (x *XBus) NewEtcdClient() *clientv3.Client {
-	var tlsConfig *tls.Config
-	if x.Config.Etcd.CACert != "" {
-		cert, err := utils.ReadPEMCertificate(x.Config.Etcd.CACert)
-		if err != nil {
-			glog.Errorf("read etcd's cacertfail: %v", err)
-			os.Exit(-1)
+	if x.Config.EmbedEtcd.Enable {
+		config := x.Config.Etcd
+		config.Endpoints = []string{x.Config.EmbedEtcd.ClientURL}
+		config.DiscoverySRV = ""
+		return x.addEtcdClient(newEtcdClient(&config, x.Config.Services.KeyPrefix))
+	}
+	return x.addEtcdClient(newEtcdClient(&x.Config.Etcd, x.Config.Services.KeyPrefix))
+}
+
+func (x *XBus) addEtcdClient(etcdClient *clientv3.Client) *clientv3.Client {
+	x.closers = append(x.closers, etcdClient)
+	return etcdClient
+}
+
+// NewRemoteEtcdClients add etcd clients of federation clusters to services
+func (x *XBus) NewRemoteEtcdClients(services *services.ServiceCtrl) {
+	for i := range x.Config.Services.Federation.Clusters {
+		cluster := &x.Config.Services.Federation.Clusters[i]
+		if cluster.Etcd.DiscoverySRV != "" {
+			logging.Infof("federation cluster(%s): srv %s", cluster.Name, cluster.Etcd.DiscoverySRV)
+		} else {
+			logging.Infof("federation cluster(%s): %v", cluster.Name, cluster.Etcd.Endpoints)
 		}
+		services.AddRemote(cluster.Name, x.addEtcdClient(newEtcdClient(&cluster.Etcd, x.Config.Services.KeyPrefix)))
+	}
+}
 
-		pool := x509.NewCertPool()
-		pool.AddCert(cert)
-		tlsConfig = &tls.Config{RootCAs: pool}
+// newEtcdClient new etcd client, with auth the token is refreshed by reading authKey,
+// which should be readable by the role of user
+func newEtcdClient(config *utils.ETCDConfig, authKey string) *clientv3.Client {
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		logging.Errorf("load etcd tls config fail: %v", err)
+		os.Exit(-1)
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	if (config.Username == "") != (config.Password == "") {
+		logging.Errorf("etcd username and password should be both set")
+		os.Exit(-1)
+	}
+	endpoints, err := config.ResolveEndpoints()
+	if err != nil {
+		logging.Errorf("resolve etcd endpoints fail: %v", err)
+		os.Exit(-1)
 	}
 	etcdConfig := clientv3.Config{
-		Endpoints:   x.Config.Etcd.Endpoints,
-		DialTimeout: x.Config.Etcd.Timeout,
-		TLS:         tlsConfig}
+		Endpoints:   endpoints,
+		DialTimeout: timeout,
+		TLS:         tlsConfig,
+		Username:    config.Username,
+		Password:    config.Password}
 	etcdClient, err := clientv3.New(etcdConfig)
 	if err != nil {
-		glog.Errorf("create etcd clientv3 fail: %v", err)
+		logging.Errorf("create etcd clientv3 fail: %v", err)
 		os.Exit(-1)
 	}
+	utils.WrapRetry(etcdClient, &config.Retry)
+	if config.Username != "" {
+		go refreshEtcdAuth(etcdClient, config, authKey)
+	}
+	if config.DiscoverySRV != "" {
+		logging.Infof("etcd endpoints of srv %s: %v", config.DiscoverySRV, endpoints)
+		go resolveEtcdEndpoints(etcdClient, config, endpoints)
+	}
 	return etcdClient
 }
 
+// resolveEtcdEndpoints re-resolve endpoints every DiscoveryInterval, the client
+// switches to the new ones if changed, failed resolutions keep the current ones
+func resolveEtcdEndpoints(etcdClient *clientv3.Client, config *utils.ETCDConfig, endpoints []string) {
+	interval := config.DiscoveryInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-etcdClient.Ctx().Done():
+			return
+		case <-ticker.C:
+		}
+		resolved, err := config.ResolveEndpoints()
+		if err != nil {
+			logging.Warningf("resolve etcd endpoints fail: %v", err)
+			continue
+		}
+		if strings.Join(resolved, ",") != strings.Join(endpoints, ",") {
+			logging.Infof("etcd endpoints of srv %s changed: %v -> %v", config.DiscoverySRV, endpoints, resolved)
+			etcdClient.SetEndpoints(resolved...)
+			endpoints = resolved
+		}
+	}
+}
+
+// refreshEtcdAuth read authKey every AuthRefresh, keeping simple tokens from expiring,
+// expired tokens are renewed by the client on unary requests, so reconnected watches get valid ones
+func refreshEtcdAuth(etcdClient *clientv3.Client, config *utils.ETCDConfig, authKey string) {
+	interval := config.AuthRefresh
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-etcdClient.Ctx().Done():
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(etcdClient.Ctx(), interval)
+		_, err := etcdClient.Get(ctx, authKey, clientv3.WithCountOnly())
+		cancel()
+		if err != nil && etcdClient.Ctx().Err() == nil {
+			logging.Warningf("refresh etcd auth of %s fail: %v", config.Username, err)
+		}
+	}
+}
+
 // NewAppCtrl new app ctrl
 func (x *XBus) NewAppCtrl(db *sql.DB, etcdClient *clientv3.Client) *apps.AppCtrl {
 	appCtrl, err := apps.NewAppCtrl(&x.Config.Apps, db, etcdClient)
 	if err != nil {
-		glog.Errorf("create appsCtrl fail: %v", err)
+		logging.Errorf("create appsCtrl fail: %v", err)
 		os.Exit(-1)
 	}
 	return appCtrl
@@ -119,6 +280,12 @@ func main() {
 	subcommands.Register(&ListPermCmd{}, "")
 	subcommands.Register(&GrantCmd{}, "")
 	subcommands.Register(&KeyCertCmd{}, "")
+	subcommands.Register(&TokenCmd{}, "")
+	subcommands.Register(&ExportCmd{}, "")
+	subcommands.Register(&ImportCmd{}, "")
+	subcommands.Register(&RestoreCmd{}, "")
+	subcommands.Register(&MigrateCmd{}, "")
+	subcommands.Register(&ScanNamesCmd{}, "")
 
 	flag.Set("logtostderr", "true")
 	flag.Parse()
@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "xbus"
+
+var (
+	// ServicePlugs service plug counter
+	ServicePlugs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_plugs_total",
+		Help:      "Number of service plug requests.",
+	}, []string{"result"})
+
+	// ServiceUnplugs service unplug counter
+	ServiceUnplugs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_unplugs_total",
+		Help:      "Number of service unplug requests.",
+	}, []string{"result"})
+
+	// ServiceUpdates endpoint update counter
+	ServiceUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_updates_total",
+		Help:      "Number of endpoint update requests.",
+	}, []string{"result"})
+
+	// KeepAliveFailures lease keepalive failure counter
+	KeepAliveFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lease_keepalive_failures_total",
+		Help:      "Number of failed lease keepalive requests.",
+	})
+
+	// QueryDuration service query latency
+	QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "service_query_duration_seconds",
+		Help:      "Latency of service queries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op"})
+
+	// Watches in-flight watch gauge
+	Watches = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "watches",
+		Help:      "Number of in-flight watch requests.",
+	}, []string{"kind"})
+
+	// WatchesTotal watch counter
+	WatchesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watches_total",
+		Help:      "Number of watch requests.",
+	}, []string{"kind"})
+
+	// HubWatches shared etcd watch gauge
+	HubWatches = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "watch_hub_prefixes",
+		Help:      "Number of etcd watches shared by watch requests.",
+	})
+
+	// HubWaiters watch requests waiting on shared watches
+	HubWaiters = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "watch_hub_waiters",
+		Help:      "Number of watch requests waiting on shared etcd watches.",
+	})
+
+	// QueryCache query cache lookup counter
+	QueryCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "query_cache_lookups_total",
+		Help:      "Number of query cache lookups.",
+	}, []string{"result"})
+
+	// SharedGets counter of queries whose etcd get was shared
+	SharedGets = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shared_gets_total",
+		Help:      "Number of queries whose etcd get was shared with concurrent identical queries.",
+	})
+
+	// EtcdErrors etcd error counter
+	EtcdErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "etcd_errors_total",
+		Help:      "Number of errors returned by etcd.",
+	}, []string{"code"})
+
+	// EtcdRetries retried etcd request counter
+	EtcdRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "etcd_retries_total",
+		Help:      "Number of retried etcd requests.",
+	}, []string{"op"})
+
+	// RateLimited rate limited request counter
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Number of requests rejected by rate limits.",
+	}, []string{"op"})
+
+	// OrphanedKeys orphaned endpoint keys found by the last sweep
+	OrphanedKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "orphaned_endpoint_keys",
+		Help:      "Number of endpoint keys without lease or with malformed values found by the last sweep.",
+	}, []string{"reason"})
+
+	// OrphanedKeysDeleted deleted orphaned endpoint keys counter
+	OrphanedKeysDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orphaned_endpoint_keys_deleted_total",
+		Help:      "Number of orphaned endpoint keys deleted by sweeps.",
+	})
+
+	// Leader whether the server is the leader running singleton duties
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "1 if the server is the leader running singleton duties, 0 if not.",
+	})
+
+	// FlappingEndpoints endpoints currently flagged as flapping
+	FlappingEndpoints = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "flapping_endpoints",
+		Help:      "Number of endpoints currently flagged as flapping.",
+	})
+
+	// EndpointFlaps endpoints started flapping counter
+	EndpointFlaps = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "endpoint_flaps_total",
+		Help:      "Number of times endpoints started flapping.",
+	})
+
+	// InstancesLow zones without endpoints and services below minimum instances, by scope
+	InstancesLow = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "instances_low",
+		Help:      "Number of zones without endpoints (scope zone) and services below minimum instances (scope service).",
+	}, []string{"scope"})
+)
+
+func init() {
+	prometheus.MustRegister(ServicePlugs, ServiceUnplugs, ServiceUpdates, KeepAliveFailures,
+		QueryDuration, Watches, WatchesTotal, HubWatches, HubWaiters, QueryCache,
+		SharedGets, EtcdErrors, EtcdRetries, RateLimited, OrphanedKeys, OrphanedKeysDeleted,
+		Leader, FlappingEndpoints, EndpointFlaps, InstancesLow)
+}
+
+// Result result label of err
+func Result(err error) string {
+	if err != nil {
+		return "fail"
+	}
+	return "ok"
+}
+
+// WatchStarted track watch start, returns func to call when it's done
+func WatchStarted(kind string) func() {
+	WatchesTotal.WithLabelValues(kind).Inc()
+	Watches.WithLabelValues(kind).Inc()
+	return func() {
+		Watches.WithLabelValues(kind).Dec()
+	}
+}
+
+// ObserveSince observe seconds elapsed since start
+func ObserveSince(observer prometheus.Observer, start time.Time) {
+	observer.Observe(time.Since(start).Seconds())
+}
+
+// Handler metrics http handler
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
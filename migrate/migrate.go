@@ -0,0 +1,134 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+// Rewriter rewrite value of key to the new schema
+type Rewriter func(key string, value []byte) ([]byte, error)
+
+// Options options of migrations, keys existing in the new prefix are kept
+// unless Overwrite, source keys are deleted after copied if DeleteSource
+type Options struct {
+	Rewrite      Rewriter
+	Overwrite    bool
+	DeleteSource bool
+	DryRun       bool
+	BatchSize    int64
+}
+
+// Stats counts of a migration
+type Stats struct {
+	Copied   int `json:"copied"`
+	Existing int `json:"existing"`
+	Skipped  int `json:"skipped"`
+	Deleted  int `json:"deleted"`
+}
+
+const defaultBatchSize = 500
+
+// Prefix copy keys of prefix from, i.e. from/... and from-..., to prefix to at one revision,
+// keys are put with the same leases so that keepalives of clients keep both copies alive
+func Prefix(ctx context.Context, client *clientv3.Client, from, to string, options *Options) (*Stats, error) {
+	from, to = strings.TrimSuffix(from, "/"), strings.TrimSuffix(to, "/")
+	if from == "" || to == "" {
+		return nil, fmt.Errorf("empty key prefix")
+	}
+	if from == to || strings.HasPrefix(to, from+"/") || strings.HasPrefix(to, from+"-") ||
+		strings.HasPrefix(from, to+"/") || strings.HasPrefix(from, to+"-") {
+		return nil, fmt.Errorf("overlapped key prefixes: %s, %s", from, to)
+	}
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	var stats Stats
+	var revision int64
+	for _, prefix := range []string{from + "-", from + "/"} {
+		key, end := prefix, utils.RangeEndKey(prefix)
+		for {
+			opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(batchSize)}
+			if revision > 0 {
+				opts = append(opts, clientv3.WithRev(revision))
+			}
+			resp, err := client.Get(ctx, key, opts...)
+			if err != nil {
+				return &stats, fmt.Errorf("get %s fail: %v", key, err)
+			}
+			if revision == 0 {
+				revision = resp.Header.Revision
+			}
+			for _, kv := range resp.Kvs {
+				if err := migrateKey(ctx, client, to+strings.TrimPrefix(string(kv.Key), from), kv.Key, kv.Value,
+					clientv3.LeaseID(kv.Lease), kv.ModRevision, options, &stats); err != nil {
+					return &stats, err
+				}
+			}
+			if !resp.More || len(resp.Kvs) == 0 {
+				break
+			}
+			key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+		}
+	}
+	return &stats, nil
+}
+
+func migrateKey(ctx context.Context, client *clientv3.Client, newKey string, key, value []byte,
+	leaseID clientv3.LeaseID, modRevision int64, options *Options, stats *Stats) error {
+	if options.Rewrite != nil {
+		rewritten, err := options.Rewrite(string(key), value)
+		if err != nil {
+			logging.Warningf("rewrite %s fail, skipped: %v", key, err)
+			stats.Skipped++
+			return nil
+		}
+		value = rewritten
+	}
+	if options.DryRun {
+		stats.Copied++
+		return nil
+	}
+
+	var opts []clientv3.OpOption
+	if leaseID != clientv3.NoLease {
+		opts = append(opts, clientv3.WithLease(leaseID))
+	}
+	txn := client.Txn(ctx)
+	if !options.Overwrite {
+		txn = txn.If(clientv3.Compare(clientv3.CreateRevision(newKey), "=", 0))
+	}
+	resp, err := txn.Then(clientv3.OpPut(newKey, string(value), opts...)).Commit()
+	if err == v3rpc.ErrLeaseNotFound {
+		logging.Infof("lease of %s expired, skipped", key)
+		stats.Skipped++
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("put %s fail: %v", newKey, err)
+	}
+	if !resp.Succeeded {
+		stats.Existing++
+	} else {
+		stats.Copied++
+	}
+
+	if options.DeleteSource {
+		delResp, err := client.Txn(ctx).If(clientv3.Compare(clientv3.ModRevision(string(key)), "=", modRevision)).
+			Then(clientv3.OpDelete(string(key))).Commit()
+		if err != nil {
+			return fmt.Errorf("delete %s fail: %v", key, err)
+		}
+		if delResp.Succeeded {
+			stats.Deleted++
+		} else {
+			logging.Warningf("%s changed since copied, not deleted", key)
+		}
+	}
+	return nil
+}
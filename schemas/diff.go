@@ -0,0 +1,177 @@
+package schemas
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// DiffLine a removed (-) or added (+) line, Line is the line number in the old or new content
+type DiffLine struct {
+	Op   string `json:"op"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// Diff changed lines between two schemas of the same kind, protobuf descriptors
+// are compared by their messages, enums and services rendered one definition per line
+type Diff struct {
+	From    *Schema    `json:"from"`
+	To      *Schema    `json:"to"`
+	Changes []DiffLine `json:"changes"`
+}
+
+// maxDiffCells max size of the lcs table, larger contents are diffed as all lines changed
+const maxDiffCells = 4 << 20
+
+// Diff diff schema of fromService at fromRevision to toService at toRevision, latest ones if revision is 0
+func (ctrl *SchemaCtrl) Diff(kind, fromService string, fromRevision int64, toService string, toRevision int64) (*Diff, error) {
+	from, err := ctrl.Get(fromService, kind, fromRevision)
+	if err != nil {
+		return nil, err
+	}
+	to, err := ctrl.Get(toService, kind, toRevision)
+	if err != nil {
+		return nil, err
+	}
+	fromLines, err := lines(from)
+	if err != nil {
+		return nil, err
+	}
+	toLines, err := lines(to)
+	if err != nil {
+		return nil, err
+	}
+	from.Content, to.Content = "", ""
+	return &Diff{From: from, To: to, Changes: diffLines(fromLines, toLines)}, nil
+}
+
+func lines(schema *Schema) ([]string, error) {
+	if schema.Kind != KindProtobuf {
+		return strings.Split(strings.TrimSuffix(schema.Content, "\n"), "\n"), nil
+	}
+	set, err := parseFileDescriptorSet(schema.Content)
+	if err != nil {
+		return nil, err
+	}
+	return describeFileSet(set), nil
+}
+
+// describeFileSet render definitions of set sorted, so that reordering is not a change
+func describeFileSet(set *descriptor.FileDescriptorSet) []string {
+	var result []string
+	for _, file := range set.File {
+		prefix := ""
+		if file.GetPackage() != "" {
+			prefix = file.GetPackage() + "."
+		}
+		for _, msg := range file.MessageType {
+			result = describeMessage(result, prefix, msg)
+		}
+		for _, enum := range file.EnumType {
+			result = describeEnum(result, prefix, enum)
+		}
+		for _, service := range file.Service {
+			for _, method := range service.Method {
+				result = append(result, fmt.Sprintf("rpc %s%s.%s(%s%s) returns (%s%s)",
+					prefix, service.GetName(), method.GetName(), streamPrefix(method.GetClientStreaming()),
+					method.GetInputType(), streamPrefix(method.GetServerStreaming()), method.GetOutputType()))
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func streamPrefix(streaming bool) string {
+	if streaming {
+		return "stream "
+	}
+	return ""
+}
+
+func describeMessage(result []string, prefix string, msg *descriptor.DescriptorProto) []string {
+	name := prefix + msg.GetName()
+	result = append(result, "message "+name)
+	for _, field := range msg.Field {
+		typ := strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+		if field.GetTypeName() != "" {
+			typ = field.GetTypeName()
+		}
+		label := strings.ToLower(strings.TrimPrefix(field.GetLabel().String(), "LABEL_"))
+		result = append(result, fmt.Sprintf("field %s.%s = %d %s %s", name, field.GetName(), field.GetNumber(), label, typ))
+	}
+	for _, nested := range msg.NestedType {
+		result = describeMessage(result, name+".", nested)
+	}
+	for _, enum := range msg.EnumType {
+		result = describeEnum(result, name+".", enum)
+	}
+	return result
+}
+
+func describeEnum(result []string, prefix string, enum *descriptor.EnumDescriptorProto) []string {
+	name := prefix + enum.GetName()
+	result = append(result, "enum "+name)
+	for _, value := range enum.Value {
+		result = append(result, fmt.Sprintf("value %s.%s = %d", name, value.GetName(), value.GetNumber()))
+	}
+	return result
+}
+
+// diffLines changed lines of a longest common subsequence
+func diffLines(a, b []string) []DiffLine {
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+	}
+	a, b = a[start:endA], b[start:endB]
+	changes := make([]DiffLine, 0)
+	if len(a)*len(b) > maxDiffCells {
+		for i, line := range a {
+			changes = append(changes, DiffLine{Op: "-", Line: start + i + 1, Text: line})
+		}
+		for j, line := range b {
+			changes = append(changes, DiffLine{Op: "+", Line: start + j + 1, Text: line})
+		}
+		return changes
+	}
+	// lcs[i][j] of a[i:], b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j >= len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			changes = append(changes, DiffLine{Op: "-", Line: start + i + 1, Text: a[i]})
+			i++
+		default:
+			changes = append(changes, DiffLine{Op: "+", Line: start + j + 1, Text: b[j]})
+			j++
+		}
+	}
+	return changes
+}
@@ -0,0 +1,192 @@
+package schemas
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/gocomm/dbutil"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/services"
+	"github.com/infrmods/xbus/utils"
+)
+
+// schema kinds
+const (
+	// KindProtobuf base64 encoded protobuf FileDescriptorSet
+	KindProtobuf = "protobuf"
+	// KindThrift thrift idl
+	KindThrift = "thrift"
+	// KindOpenAPI openapi document, json or yaml
+	KindOpenAPI = "openapi"
+)
+
+// Config module config
+type Config struct {
+	MaxSize int `default:"4194304" yaml:"max_size"`
+}
+
+// SchemaCtrl versioned interface descriptors of services, each put of a changed
+// descriptor of a service and kind is a new revision
+type SchemaCtrl struct {
+	config Config
+	db     *sql.DB
+}
+
+// NewSchemaCtrl new schema ctrl
+func NewSchemaCtrl(config *Config, db *sql.DB) *SchemaCtrl {
+	return &SchemaCtrl{config: *config, db: db}
+}
+
+// Schema a revision of the descriptor of a service
+type Schema struct {
+	Service    string    `json:"service"`
+	Kind       string    `json:"kind"`
+	Revision   int64     `json:"revision"`
+	Content    string    `json:"content,omitempty"`
+	Checksum   string    `json:"checksum"`
+	AppID      int64     `json:"modified_by"`
+	Remark     string    `json:"remark"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+func checkServiceKind(service, kind string) error {
+	if !services.ValidService(service) {
+		return utils.NewError(utils.EcodeInvalidService, "")
+	}
+	switch kind {
+	case KindProtobuf, KindThrift, KindOpenAPI:
+		return nil
+	}
+	return utils.Errorf(utils.EcodeInvalidParam, "invalid schema kind: %s", kind)
+}
+
+func parseFileDescriptorSet(content string) (*descriptor.FileDescriptorSet, error) {
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, utils.Errorf(utils.EcodeInvalidValue, "invalid base64 content: %v", err)
+	}
+	var set descriptor.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, utils.Errorf(utils.EcodeInvalidValue, "invalid FileDescriptorSet: %v", err)
+	}
+	return &set, nil
+}
+
+func checksumOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+const (
+	maxPutAttempts = 3
+	// maxRemark size of the remark column, checked since inserts ignore errors of duplicated revisions
+	maxRemark = 128
+)
+
+const schemaColumns = `service, kind, revision, content, checksum, app_id, remark, create_time`
+
+// Put save content as a new revision of the schema of service, the latest
+// revision is returned if content unchanged
+func (ctrl *SchemaCtrl) Put(service, kind string, appID int64, remark, content string) (*Schema, error) {
+	if err := checkServiceKind(service, kind); err != nil {
+		return nil, err
+	}
+	if content == "" {
+		return nil, utils.NewError(utils.EcodeMissingParam, "missing content")
+	}
+	if len(remark) > maxRemark {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "remark too long, max: %d", maxRemark)
+	}
+	if ctrl.config.MaxSize > 0 && len(content) > ctrl.config.MaxSize {
+		return nil, utils.Errorf(utils.EcodeInvalidValue, "content too large: %d, max: %d", len(content), ctrl.config.MaxSize)
+	}
+	if kind == KindProtobuf {
+		if _, err := parseFileDescriptorSet(content); err != nil {
+			return nil, err
+		}
+	}
+	checksum := checksumOf(content)
+	for attempt := 1; ; attempt++ {
+		latest, err := ctrl.get(service, kind, 0)
+		if err != nil {
+			return nil, err
+		}
+		if latest != nil && latest.Checksum == checksum {
+			return latest, nil
+		}
+		revision := int64(1)
+		if latest != nil {
+			revision = latest.Revision + 1
+		}
+		// the revision may be put concurrently, then retried on the new latest one
+		_, err = dbutil.Insert(ctrl.db, `insert ignore into service_schemas(`+schemaColumns+`)
+                                         values(?,?,?,?,?,?,?,now())`,
+			service, kind, revision, content, checksum, appID, remark)
+		if err == nil {
+			return ctrl.Get(service, kind, revision)
+		}
+		if err != dbutil.ZeroEffected {
+			logging.Errorf("insert schema(%s, %s, %d) fail: %v", service, kind, revision, err)
+			return nil, utils.NewError(utils.EcodeSystemError, "insert schema fail")
+		}
+		if attempt >= maxPutAttempts {
+			return nil, utils.Errorf(utils.EcodeNameDuplicated, "revision %d of %s schema put concurrently", revision, service)
+		}
+	}
+}
+
+// Get schema of service at revision, the latest if revision is 0
+func (ctrl *SchemaCtrl) Get(service, kind string, revision int64) (*Schema, error) {
+	if err := checkServiceKind(service, kind); err != nil {
+		return nil, err
+	}
+	schema, err := ctrl.get(service, kind, revision)
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		return nil, utils.Errorf(utils.EcodeNotFound, "schema(%s, %s) not found", service, kind)
+	}
+	return schema, nil
+}
+
+func (ctrl *SchemaCtrl) get(service, kind string, revision int64) (*Schema, error) {
+	var schema Schema
+	var err error
+	if revision > 0 {
+		err = dbutil.Query(ctrl.db, &schema, `select `+schemaColumns+` from service_schemas
+                where service=? and kind=? and revision=?`, service, kind, revision)
+	} else {
+		err = dbutil.Query(ctrl.db, &schema, `select `+schemaColumns+` from service_schemas
+                where service=? and kind=? order by revision desc limit 1`, service, kind)
+	}
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		logging.Errorf("query schema(%s, %s, %d) fail: %v", service, kind, revision, err)
+		return nil, utils.NewError(utils.EcodeSystemError, "query schema fail")
+	}
+	return &schema, nil
+}
+
+// List latest revisions of all kinds of service, without content
+func (ctrl *SchemaCtrl) List(service string) ([]Schema, error) {
+	if !services.ValidService(service) {
+		return nil, utils.NewError(utils.EcodeInvalidService, "")
+	}
+	var schemas []Schema
+	if err := dbutil.Query(ctrl.db, &schemas, `select s.service, s.kind, s.revision, s.checksum,
+                s.app_id, s.remark, s.create_time from service_schemas s
+                join (select kind, max(revision) as revision from service_schemas where service=? group by kind) l
+                on s.kind=l.kind and s.revision=l.revision where s.service=? order by s.kind`,
+		service, service); err != nil {
+		logging.Errorf("list schemas(%s) fail: %v", service, err)
+		return nil, utils.NewError(utils.EcodeSystemError, "list schemas fail")
+	}
+	return schemas, nil
+}
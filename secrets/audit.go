@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/infrmods/xbus/logging"
+)
+
+// audit ops, see SecretCtrl.Denied
+const (
+	OpRead   = "read"
+	OpWrite  = "write"
+	OpDelete = "delete"
+	OpRewrap = "rewrap"
+)
+
+// Accessor identity of the app accessing secrets, for audit
+type Accessor struct {
+	AppID    int64  `json:"app_id"`
+	App      string `json:"app"`
+	RemoteIP string `json:"remote_ip"`
+	// RequestID id of the api request, to correlate with server logs
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type auditEntry struct {
+	Time time.Time `json:"time"`
+	Accessor
+	Op      string `json:"op"`
+	Name    string `json:"name"`
+	Version int64  `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// auditLog append entries as json lines to a file, or to the log
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return &auditLog{}, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file}, nil
+}
+
+func (l *auditLog) record(who Accessor, op, name string, version int64, err error) {
+	entry := auditEntry{Time: time.Now().UTC(), Accessor: who, Op: op, Name: name, Version: version}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	data, e := json.Marshal(&entry)
+	if e != nil {
+		return
+	}
+	if l.file == nil {
+		logging.Infof("secret audit: %s", data)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, e := l.file.Write(append(data, '\n')); e != nil {
+		logging.Errorf("write secret audit fail: %v, %s", e, data)
+	}
+}
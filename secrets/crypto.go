@@ -0,0 +1,153 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// dataKeySize aes-256 keys
+const dataKeySize = 32
+
+// KeyWrapper encrypt data keys of secrets, e.g. by master keys or a kms
+type KeyWrapper interface {
+	// Wrap encrypt dataKey, returns the id of the key encrypting it
+	Wrap(ctx context.Context, dataKey []byte) (string, []byte, error)
+	// Unwrap decrypt a data key wrapped by the key of keyID
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// KMSFactory new KeyWrapper of a kms by params of KMSConfig
+type KMSFactory func(params map[string]string) (KeyWrapper, error)
+
+var (
+	kmsMu        sync.Mutex
+	kmsFactories = make(map[string]KMSFactory)
+)
+
+// RegisterKMS register a kms plug-in as name of KMSConfig, e.g. in init of a plug-in package
+func RegisterKMS(name string, factory KMSFactory) {
+	kmsMu.Lock()
+	defer kmsMu.Unlock()
+	kmsFactories[name] = factory
+}
+
+func newKMS(config KMSConfig) (KeyWrapper, error) {
+	kmsMu.Lock()
+	factory, ok := kmsFactories[config.Name]
+	kmsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown kms: %s", config.Name)
+	}
+	return factory(config.Params)
+}
+
+// masterKeyWrapper wrap data keys by aes-gcm of local master keys,
+// the first one wraps, all of them unwrap
+type masterKeyWrapper struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+func newMasterKeyWrapper(keys []MasterKey) (*masterKeyWrapper, error) {
+	w := &masterKeyWrapper{keys: make(map[string]cipher.AEAD)}
+	for i, key := range keys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("missing id of master key %d", i)
+		}
+		if _, ok := w.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicated master key: %s", key.ID)
+		}
+		data, err := base64.StdEncoding.DecodeString(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %v", key.ID, err)
+		}
+		if len(data) != dataKeySize {
+			return nil, fmt.Errorf("master key %s should be %d bytes", key.ID, dataKeySize)
+		}
+		aead, err := newAEAD(data)
+		if err != nil {
+			return nil, err
+		}
+		w.keys[key.ID] = aead
+		if i == 0 {
+			w.current = key.ID
+		}
+	}
+	return w, nil
+}
+
+func (w *masterKeyWrapper) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := seal(w.keys[w.current], dataKey, []byte(w.current))
+	return w.current, wrapped, err
+}
+
+func (w *masterKeyWrapper) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := w.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key: %s", keyID)
+	}
+	return open(aead, wrapped, []byte(keyID))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypt plaintext, the nonce is prepended
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additional)
+}
+
+// encrypt value by a new data key wrapped by wrapper, bound to name
+func encrypt(ctx context.Context, wrapper KeyWrapper, name string, value []byte) (*sealedSecret, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(aead, value, []byte(name))
+	if err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := wrapper.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key fail: %v", err)
+	}
+	return &sealedSecret{KeyID: keyID, WrappedKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+func decrypt(ctx context.Context, wrapper KeyWrapper, name string, sealed *sealedSecret) ([]byte, error) {
+	dataKey, err := wrapper.Unwrap(ctx, sealed.KeyID, sealed.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key fail: %v", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, sealed.Ciphertext, []byte(name))
+}
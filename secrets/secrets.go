@@ -0,0 +1,361 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/services"
+	"github.com/infrmods/xbus/utils"
+)
+
+// MasterKey local master key, Key is base64 of 32 bytes
+type MasterKey struct {
+	ID  string
+	Key string
+}
+
+// KMSConfig kms plug-in registered by RegisterKMS
+type KMSConfig struct {
+	Name   string
+	Params map[string]string
+}
+
+// Config module config, disabled without master keys or kms;
+// the first of MasterKeys encrypts new data keys, others decrypt old ones until rewrapped
+type Config struct {
+	KeyPrefix  string      `default:"/secrets" yaml:"key_prefix"`
+	MasterKeys []MasterKey `yaml:"master_keys"`
+	KMS        KMSConfig
+	// AuditLog file audit entries are appended to, the log if empty
+	AuditLog string `yaml:"audit_log"`
+}
+
+// Secret decrypted secret, Version increases with every put, not with rewraps
+type Secret struct {
+	Name       string    `json:"name"`
+	Value      string    `json:"value"`
+	Version    int64     `json:"version"`
+	Revision   int64     `json:"revision"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+// SecretVersion rotation signal of a secret, without its value
+type SecretVersion struct {
+	Name     string `json:"name"`
+	Version  int64  `json:"version"`
+	Revision int64  `json:"revision"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+// sealedSecret stored value, the value encrypted by a data key wrapped by KeyID
+type sealedSecret struct {
+	KeyID      string    `json:"key_id"`
+	WrappedKey []byte    `json:"wrapped_key"`
+	Ciphertext []byte    `json:"ciphertext"`
+	AppID      int64     `json:"app_id"`
+	CreateTime time.Time `json:"create_time"`
+	// Version kept by rewraps, the etcd version of the key if not set
+	Version int64 `json:"version,omitempty"`
+}
+
+// versionOf version of a stored secret
+func versionOf(kv *mvccpb.KeyValue) int64 {
+	var sealed sealedSecret
+	if err := json.Unmarshal(kv.Value, &sealed); err != nil || sealed.Version <= 0 {
+		return kv.Version
+	}
+	return sealed.Version
+}
+
+// SecretCtrl secrets encrypted at rest by envelope encryption: every version is encrypted
+// by aes-gcm of a new data key, wrapped by a master key or kms
+type SecretCtrl struct {
+	config     Config
+	etcdClient *clientv3.Client
+	wrapper    KeyWrapper
+	audit      *auditLog
+}
+
+// NewSecretCtrl new secret ctrl
+func NewSecretCtrl(config *Config, etcdClient *clientv3.Client) (*SecretCtrl, error) {
+	ctrl := &SecretCtrl{config: *config, etcdClient: etcdClient}
+	ctrl.config.KeyPrefix = strings.TrimSuffix(ctrl.config.KeyPrefix, "/")
+	var err error
+	if config.KMS.Name != "" {
+		ctrl.wrapper, err = newKMS(config.KMS)
+	} else if len(config.MasterKeys) > 0 {
+		ctrl.wrapper, err = newMasterKeyWrapper(config.MasterKeys)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ctrl.audit, err = newAuditLog(config.AuditLog); err != nil {
+		return nil, err
+	}
+	return ctrl, nil
+}
+
+// Enabled whether master keys or kms configured
+func (ctrl *SecretCtrl) Enabled() bool {
+	return ctrl.wrapper != nil
+}
+
+func (ctrl *SecretCtrl) check(name string) error {
+	if ctrl.wrapper == nil {
+		return utils.NewError(utils.EcodeNotPermitted, "secrets not enabled")
+	}
+	if !services.ValidName(name) {
+		return utils.NewError(utils.EcodeInvalidName, "")
+	}
+	return nil
+}
+
+func (ctrl *SecretCtrl) secretKey(name string) string {
+	return ctrl.config.KeyPrefix + "/" + name
+}
+
+const maxPutAttempts = 3
+
+// Put encrypt and store value as the next version of name, returns the version
+func (ctrl *SecretCtrl) Put(ctx context.Context, who Accessor, name, value string) (int64, error) {
+	if err := ctrl.check(name); err != nil {
+		return 0, err
+	}
+	sealed, err := encrypt(ctx, ctrl.wrapper, name, []byte(value))
+	if err != nil {
+		logging.Errorf("encrypt secret(%s) fail: %v", name, err)
+		return 0, utils.NewSystemError("encrypt secret fail")
+	}
+	sealed.AppID, sealed.CreateTime = who.AppID, time.Now().UTC()
+	key := ctrl.secretKey(name)
+	for i := 0; i < maxPutAttempts; i++ {
+		version, err := ctrl.put(ctx, key, sealed)
+		if err != nil {
+			ctrl.audit.record(who, OpWrite, name, 0, err)
+			return 0, err
+		}
+		if version > 0 {
+			ctrl.audit.record(who, OpWrite, name, version, nil)
+			return version, nil
+		}
+	}
+	err = utils.NewError(utils.EcodeTooManyAttempts, "secret put concurrently")
+	ctrl.audit.record(who, OpWrite, name, 0, err)
+	return 0, err
+}
+
+// put store sealed as the next version of the current one, 0 if put concurrently
+func (ctrl *SecretCtrl) put(ctx context.Context, key string, sealed *sealedSecret) (int64, error) {
+	resp, err := ctrl.etcdClient.Get(ctx, key)
+	if err != nil {
+		return 0, utils.CleanErr(err, "put secret fail", "get secret(%s) fail: %v", key, err)
+	}
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	sealed.Version = 1
+	if len(resp.Kvs) > 0 {
+		kv := resp.Kvs[0]
+		cmp = clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)
+		sealed.Version = versionOf(kv) + 1
+	}
+	data, err := json.Marshal(sealed)
+	if err != nil {
+		return 0, utils.NewSystemError("marshal secret fail")
+	}
+	txn, err := ctrl.etcdClient.Txn(ctx).If(cmp).Then(clientv3.OpPut(key, string(data))).Commit()
+	if err != nil {
+		return 0, utils.CleanErr(err, "put secret fail", "put secret(%s) fail: %v", key, err)
+	}
+	if !txn.Succeeded {
+		return 0, nil
+	}
+	return sealed.Version, nil
+}
+
+// Get decrypt the current version of name, every read is audited
+func (ctrl *SecretCtrl) Get(ctx context.Context, who Accessor, name string) (*Secret, error) {
+	if err := ctrl.check(name); err != nil {
+		ctrl.audit.record(who, OpRead, name, 0, err)
+		return nil, err
+	}
+	secret, err := ctrl.get(ctx, name)
+	var version int64
+	if secret != nil {
+		version = secret.Version
+	}
+	ctrl.audit.record(who, OpRead, name, version, err)
+	return secret, err
+}
+
+func (ctrl *SecretCtrl) get(ctx context.Context, name string) (*Secret, error) {
+	key := ctrl.secretKey(name)
+	resp, err := ctrl.etcdClient.Get(ctx, key)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get secret fail", "get secret(%s) fail: %v", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no secret of %s", name)
+	}
+	kv := resp.Kvs[0]
+	var sealed sealedSecret
+	if err := json.Unmarshal(kv.Value, &sealed); err != nil {
+		return nil, utils.Errorf(utils.EcodeSystemError, "damaged secret of %s", name)
+	}
+	value, err := decrypt(ctx, ctrl.wrapper, name, &sealed)
+	if err != nil {
+		logging.Errorf("decrypt secret(%s) fail: %v", name, err)
+		return nil, utils.NewSystemError("decrypt secret fail")
+	}
+	return &Secret{Name: name, Value: string(value), Version: versionOf(kv),
+		Revision: kv.ModRevision, CreateTime: sealed.CreateTime}, nil
+}
+
+// Delete delete name
+func (ctrl *SecretCtrl) Delete(ctx context.Context, who Accessor, name string) error {
+	if err := ctrl.check(name); err != nil {
+		return err
+	}
+	key := ctrl.secretKey(name)
+	resp, err := ctrl.etcdClient.Delete(ctx, key)
+	if err != nil {
+		ctrl.audit.record(who, OpDelete, name, 0, err)
+		return utils.CleanErr(err, "delete secret fail", "delete secret(%s) fail: %v", key, err)
+	}
+	if resp.Deleted == 0 {
+		return utils.Errorf(utils.EcodeNotFound, "no secret of %s", name)
+	}
+	ctrl.audit.record(who, OpDelete, name, 0, nil)
+	return nil
+}
+
+// Denied audit an access to name rejected before reaching the ctrl, e.g. by permissions
+func (ctrl *SecretCtrl) Denied(who Accessor, op, name string, err error) {
+	ctrl.audit.record(who, op, name, 0, err)
+}
+
+// Watch wait for the next version or deletion of name since revision, without decrypting it;
+// rewraps are not versions
+func (ctrl *SecretCtrl) Watch(ctx context.Context, name string, revision int64) (*SecretVersion, error) {
+	if err := ctrl.check(name); err != nil {
+		return nil, err
+	}
+	key := ctrl.secretKey(name)
+	opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for resp := range ctrl.etcdClient.Watch(ctx, key, opts...) {
+		if err := resp.Err(); err != nil {
+			if err == v3rpc.ErrCompacted {
+				// missed versions, the current one is the signal
+				return ctrl.version(ctx, name)
+			}
+			return nil, utils.CleanErr(err, "watch secret fail", "watch secret(%s) fail: %v", key, err)
+		}
+		var changed *SecretVersion
+		for _, event := range resp.Events {
+			if event.Type == mvccpb.DELETE {
+				changed = &SecretVersion{Name: name, Revision: event.Kv.ModRevision, Deleted: true}
+				continue
+			}
+			version := versionOf(event.Kv)
+			if event.PrevKv != nil && versionOf(event.PrevKv) == version {
+				// rewrapped, the value is unchanged
+				continue
+			}
+			changed = &SecretVersion{Name: name, Version: version, Revision: event.Kv.ModRevision}
+		}
+		if changed != nil {
+			return changed, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, utils.NewError(utils.EcodeDeadlineExceeded, "watch timeout")
+	}
+	return nil, utils.NewError(utils.EcodeEtcdWatchFailed, "watch secret fail, no events")
+}
+
+// Version current version of name, deleted if not found
+func (ctrl *SecretCtrl) Version(ctx context.Context, name string) (*SecretVersion, error) {
+	if err := ctrl.check(name); err != nil {
+		return nil, err
+	}
+	return ctrl.version(ctx, name)
+}
+
+func (ctrl *SecretCtrl) version(ctx context.Context, name string) (*SecretVersion, error) {
+	key := ctrl.secretKey(name)
+	resp, err := ctrl.etcdClient.Get(ctx, key)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get secret fail", "get secret(%s) fail: %v", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return &SecretVersion{Name: name, Revision: resp.Header.Revision, Deleted: true}, nil
+	}
+	kv := resp.Kvs[0]
+	return &SecretVersion{Name: name, Version: versionOf(kv), Revision: kv.ModRevision}, nil
+}
+
+// Rewrap re-encrypt data keys not wrapped by the current master key, e.g. after adding a new
+// master key in front, so older ones can be removed; values and versions are unchanged,
+// returns names rewrapped
+func (ctrl *SecretCtrl) Rewrap(ctx context.Context, who Accessor) ([]string, error) {
+	if ctrl.wrapper == nil {
+		return nil, utils.NewError(utils.EcodeNotPermitted, "secrets not enabled")
+	}
+	current, ok := ctrl.wrapper.(*masterKeyWrapper)
+	if !ok {
+		return nil, utils.NewError(utils.EcodeInvalidParam, "rewrap is for master keys, rotate keys by the kms")
+	}
+	prefix := ctrl.config.KeyPrefix + "/"
+	resp, err := ctrl.etcdClient.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, utils.CleanErr(err, "list secrets fail", "list secrets(%s) fail: %v", prefix, err)
+	}
+	names := make([]string, 0)
+	for _, kv := range resp.Kvs {
+		name := strings.TrimPrefix(string(kv.Key), prefix)
+		var sealed sealedSecret
+		if err := json.Unmarshal(kv.Value, &sealed); err != nil {
+			logging.Warningf("damaged secret(%s), skipped rewrap", name)
+			continue
+		}
+		if sealed.KeyID == current.current {
+			continue
+		}
+		dataKey, err := ctrl.wrapper.Unwrap(ctx, sealed.KeyID, sealed.WrappedKey)
+		if err != nil {
+			logging.Errorf("unwrap data key of secret(%s) fail: %v", name, err)
+			return names, utils.NewSystemError("unwrap data key fail")
+		}
+		if sealed.KeyID, sealed.WrappedKey, err = ctrl.wrapper.Wrap(ctx, dataKey); err != nil {
+			return names, utils.NewSystemError("wrap data key fail")
+		}
+		sealed.Version = versionOf(kv)
+		data, err := json.Marshal(&sealed)
+		if err != nil {
+			return names, utils.NewSystemError("marshal secret fail")
+		}
+		txn, err := ctrl.etcdClient.Txn(ctx).If(
+			clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision),
+		).Then(clientv3.OpPut(string(kv.Key), string(data))).Commit()
+		if err != nil {
+			return names, utils.CleanErr(err, "rewrap secret fail", "rewrap secret(%s) fail: %v", name, err)
+		}
+		if txn.Succeeded {
+			// put concurrently otherwise, by the current key already
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		ctrl.audit.record(who, OpRewrap, strings.Join(names, ","), 0, nil)
+	}
+	return names, nil
+}
@@ -0,0 +1,67 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infrmods/xbus/utils/etcdtest"
+)
+
+func masterKey(id string) MasterKey {
+	return MasterKey{ID: id, Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id, 32)[:32]))}
+}
+
+func TestRewrapKeepsVersion(t *testing.T) {
+	etcdClient, stop := etcdtest.Start(t)
+	defer stop()
+	ctx := context.Background()
+	who := Accessor{AppID: 1, App: "payments"}
+	old, err := NewSecretCtrl(&Config{KeyPrefix: "/secrets", MasterKeys: []MasterKey{masterKey("1")}}, etcdClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, value := range []string{"v1", "v2"} {
+		if version, err := old.Put(ctx, who, "payments.db", value); err != nil || version != int64(i+1) {
+			t.Fatalf("put version: %d, %v", version, err)
+		}
+	}
+	current, err := old.Version(ctx, "payments.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl, err := NewSecretCtrl(&Config{KeyPrefix: "/secrets",
+		MasterKeys: []MasterKey{masterKey("2"), masterKey("1")}}, etcdClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, err := ctrl.Rewrap(ctx, who); err != nil || len(names) != 1 {
+		t.Fatalf("rewrapped: %v, %v", names, err)
+	}
+	secret, err := ctrl.Get(ctx, who, "payments.db")
+	if err != nil || secret.Value != "v2" || secret.Version != 2 {
+		t.Fatalf("rewrapped secret: %+v, %v", secret, err)
+	}
+
+	// rewraps are not rotations, watches wait for the next put
+	watched := make(chan *SecretVersion, 1)
+	go func() {
+		wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		version, err := ctrl.Watch(wctx, "payments.db", current.Revision+1)
+		if err != nil {
+			t.Error(err)
+		}
+		watched <- version
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if version, err := ctrl.Put(ctx, who, "payments.db", "v3"); err != nil || version != 3 {
+		t.Fatalf("put after rewrap: %d, %v", version, err)
+	}
+	if version := <-watched; version == nil || version.Version != 3 {
+		t.Fatalf("watched: %+v", version)
+	}
+}
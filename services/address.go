@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+// AddressValidationConfig validation of plugged addresses, disabled by default
+// as addresses are not required to be host:port
+type AddressValidationConfig struct {
+	// Enable addresses should be host:port with port in 1-65535, unspecified ips are rejected
+	Enable          bool `yaml:"enable"`
+	RejectLoopback  bool `yaml:"reject_loopback"`
+	RejectLinkLocal bool `yaml:"reject_link_local"`
+	// ResolveDNS host names should resolve
+	ResolveDNS bool `yaml:"resolve_dns"`
+	// Probe addresses of new endpoints should accept tcp connections,
+	// at most ProbeRate probes per second with bursts of ProbeBurst by each server
+	Probe      bool          `yaml:"probe"`
+	ProbeRate  float64       `default:"10" yaml:"probe_rate"`
+	ProbeBurst int           `default:"20" yaml:"probe_burst"`
+	Timeout    time.Duration `default:"2s"`
+}
+
+// probeLimiter token bucket of address probes, so that plugs can't make a server scan ports
+type probeLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (limiter *probeLimiter) take(rate float64, burst int, now time.Time) bool {
+	if rate <= 0 {
+		return true
+	}
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	max := math.Max(1, float64(burst))
+	if limiter.last.IsZero() {
+		limiter.tokens = max
+	} else {
+		limiter.tokens = math.Min(max, limiter.tokens+now.Sub(limiter.last).Seconds()*rate)
+	}
+	limiter.last = now
+	if limiter.tokens < 1 {
+		return false
+	}
+	limiter.tokens--
+	return true
+}
+
+// plugged whether address is plugged into any of descs already
+func (ctrl *ServiceCtrl) plugged(ctx context.Context, descs []ServiceDescV1, address string) (bool, error) {
+	ops := make([]clientv3.Op, 0, len(descs))
+	for _, desc := range descs {
+		ops = append(ops, clientv3.OpGet(ctrl.serviceNodeKey(desc.Service, desc.Zone, address), clientv3.WithCountOnly()))
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Txn", address)
+	resp, err := ctrl.etcdClient.Txn(etcdCtx).Then(ops...).Commit()
+	span.FinishWithError(err)
+	if err != nil {
+		return false, utils.CleanErr(err, "plug service fail", "get endpoints of %s fail: %v", address, err)
+	}
+	for _, r := range resp.Responses {
+		if r.GetResponseRange().Count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// validateAddress validate address of an endpoint plugged into descs by address_validation
+func (ctrl *ServiceCtrl) validateAddress(ctx context.Context, descs []ServiceDescV1, address string) error {
+	config := &ctrl.policies().AddressValidation
+	if !config.Enable {
+		return nil
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return utils.Errorf(utils.EcodeInvalidAddress, "address %s should be host:port", address)
+	}
+	if host == "" {
+		return utils.Errorf(utils.EcodeInvalidAddress, "missing host of %s", address)
+	}
+	if port, err := strconv.Atoi(portStr); err != nil || port < 1 || port > 65535 {
+		return utils.Errorf(utils.EcodeInvalidAddress, "invalid port of %s", address)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if config.ResolveDNS {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			logging.FromContext(ctx).Warningf("resolve address(%s) fail: %v", address, err)
+			return utils.Errorf(utils.EcodeUnresolvableAddress, "resolve %s fail", host)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if ip.IsUnspecified() {
+			return utils.Errorf(utils.EcodeInvalidAddress, "unspecified ip of %s", address)
+		}
+		if config.RejectLoopback && ip.IsLoopback() {
+			return utils.Errorf(utils.EcodeInvalidAddress, "loopback ip of %s", address)
+		}
+		if config.RejectLinkLocal && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()) {
+			return utils.Errorf(utils.EcodeInvalidAddress, "link-local ip of %s", address)
+		}
+	}
+
+	if !config.Probe {
+		return nil
+	}
+	// renewals and re-plugs of endpoints were probed once plugged
+	if plugged, err := ctrl.plugged(ctx, descs, address); err != nil || plugged {
+		return err
+	}
+	if !ctrl.probes.take(config.ProbeRate, config.ProbeBurst, time.Now()) {
+		return utils.NewError(utils.EcodeRateLimited, "too many address probes, retry later")
+	}
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		// the reason is logged only, not to tell callers about the network of servers
+		logging.FromContext(ctx).Warningf("probe address(%s) fail: %v", address, err)
+		return utils.Errorf(utils.EcodeUnreachableAddress, "%s unreachable", address)
+	}
+	conn.Close()
+	return nil
+}
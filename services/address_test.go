@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/infrmods/xbus/utils"
+)
+
+func TestProbeLimiter(t *testing.T) {
+	var limiter probeLimiter
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if !limiter.take(1, 2, now) {
+			t.Fatalf("probe %d of burst limited", i)
+		}
+	}
+	if limiter.take(1, 2, now) {
+		t.Fatal("probe over burst not limited")
+	}
+	if !limiter.take(1, 2, now.Add(time.Second)) {
+		t.Fatal("probe not allowed after refill")
+	}
+}
+
+func TestValidateAddressProbe(t *testing.T) {
+	ctrl, etcdClient, stop := newEtcdTestCtrl(t, func(cfg *Config) {
+		cfg.AddressValidation.Enable = true
+		cfg.AddressValidation.Probe = true
+		cfg.AddressValidation.ProbeRate = 1
+		cfg.AddressValidation.ProbeBurst = 1
+	})
+	defer stop()
+	ctx := context.Background()
+	// a port nothing listens at
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+	descs := []ServiceDescV1{{Service: "payments.core:1.0", Zone: "default"}}
+
+	err = ctrl.validateAddress(ctx, descs, address)
+	if errCode(err) != utils.EcodeUnreachableAddress {
+		t.Fatalf("unreachable address: %v", err)
+	}
+	err = ctrl.validateAddress(ctx, descs, "127.0.0.1:1")
+	if errCode(err) != utils.EcodeRateLimited {
+		t.Fatalf("probe over limit: %v", err)
+	}
+
+	// plugged endpoints are not probed again
+	if _, err := etcdClient.Put(ctx, ctrl.serviceNodeKey(descs[0].Service, descs[0].Zone, address),
+		`{"address":"`+address+`"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.validateAddress(ctx, descs, address); err != nil {
+		t.Fatalf("plugged address probed: %v", err)
+	}
+}
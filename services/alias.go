@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+// aliases start with a letter, e.g. stable, canary, versions rarely do
+// so other versions are never looked up as aliases
+var rValidAlias = regexp.MustCompile(`(?i)^[a-z][a-z0-9_-]*$`)
+
+// ServiceAlias version alias of service name, e.g. stable -> 2.3.1; during rollouts
+// Weight percent of clients (by ip) get Canary instead
+type ServiceAlias struct {
+	Name    string `json:"name"`
+	Alias   string `json:"alias"`
+	Version string `json:"version"`
+	Canary  string `json:"canary,omitempty"`
+	Weight  int    `json:"weight,omitempty"`
+}
+
+// aliasTarget value of alias keys, the plain version unless split to a canary
+type aliasTarget struct {
+	Version string `json:"version"`
+	Canary  string `json:"canary,omitempty"`
+	Weight  int    `json:"weight,omitempty"`
+}
+
+func parseAliasTarget(value []byte) aliasTarget {
+	var target aliasTarget
+	if len(value) > 0 && value[0] == '{' {
+		if err := json.Unmarshal(value, &target); err == nil {
+			return target
+		}
+	}
+	return aliasTarget{Version: string(value)}
+}
+
+func (target aliasTarget) value() string {
+	if target.Canary == "" || target.Weight <= 0 {
+		return target.Version
+	}
+	data, _ := json.Marshal(target)
+	return string(data)
+}
+
+// versionFor version clientIP gets, each ip sticks to one side of the split
+func (target aliasTarget) versionFor(clientIP net.IP) string {
+	if target.Canary == "" || target.Weight <= 0 {
+		return target.Version
+	}
+	h := fnv.New32a()
+	h.Write(clientIP)
+	if int(h.Sum32()%100) < target.Weight {
+		return target.Canary
+	}
+	return target.Version
+}
+
+func (ctrl *ServiceCtrl) serviceAliasKeyPrefix(name string) string {
+	return fmt.Sprintf("%s-aliases/%s/", ctrl.config.KeyPrefix, name)
+}
+
+func (ctrl *ServiceCtrl) serviceAliasKey(name, alias string) string {
+	return ctrl.serviceAliasKeyPrefix(name) + alias
+}
+
+// resolveAlias service with aliased version replaced by the concrete version for clientIP,
+// and the alias key to watch, empty if the version can't be an alias
+func (ctrl *ServiceCtrl) resolveAlias(ctx context.Context, clientIP net.IP, service string) (string, string, error) {
+	name, version := splitService(service)
+	if !rValidAlias.MatchString(version) {
+		return service, "", nil
+	}
+	key := ctrl.serviceAliasKey(name, version)
+	var value []byte
+	var cached bool
+	if !isConsistentQuery(ctx) {
+		value, cached = ctrl.aliases.get(key)
+	}
+	if !cached {
+		etcdCtx, span := startEtcdSpan(ctx, "Get", key)
+		resp, err := ctrl.etcdClient.Get(etcdCtx, key)
+		span.FinishWithError(err)
+		if err != nil {
+			return "", "", utils.CleanErr(err, "resolve alias fail", "get alias(%s) fail: %v", key, err)
+		}
+		if len(resp.Kvs) > 0 {
+			value = resp.Kvs[0].Value
+		}
+	}
+	if value == nil {
+		return service, key, nil
+	}
+	return name + ":" + parseAliasTarget(value).versionFor(clientIP), key, nil
+}
+
+// SetAlias point alias of service name to version, the version must exist
+func (ctrl *ServiceCtrl) SetAlias(ctx context.Context, name, alias, version string) error {
+	if err := checkNewName(name); err != nil {
+		return err
+	}
+	if !rValidAlias.MatchString(alias) {
+		return utils.Errorf(utils.EcodeInvalidParam, "invalid alias: %s", alias)
+	}
+	if !validNames().version.MatchString(version) {
+		return utils.Errorf(utils.EcodeInvalidParam, "invalid alias version: %s", version)
+	}
+	prefix := ctrl.serviceEntryPrefix(name + ":" + version)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return utils.CleanErr(err, "set alias fail", "get service(%s) fail: %v", prefix, err)
+	}
+	if resp.Count == 0 {
+		return utils.Errorf(utils.EcodeNotFound, "no such service: %s:%s", name, version)
+	}
+	// the alias would hide the version of the same name
+	prefix = ctrl.serviceEntryPrefix(name + ":" + alias)
+	etcdCtx, span = startEtcdSpan(ctx, "Get", prefix)
+	resp, err = ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return utils.CleanErr(err, "set alias fail", "get service(%s) fail: %v", prefix, err)
+	}
+	if resp.Count > 0 {
+		return utils.Errorf(utils.EcodeNameDuplicated, "%s:%s is a version, not an alias", name, alias)
+	}
+	key := ctrl.serviceAliasKey(name, alias)
+	etcdCtx, span = startEtcdSpan(ctx, "Put", key)
+	_, err = ctrl.etcdClient.Put(etcdCtx, key, version)
+	span.FinishWithError(err)
+	if err != nil {
+		return utils.CleanErr(err, "set alias fail", "put alias(%s) fail: %v", key, err)
+	}
+	return nil
+}
+
+// DeleteAlias delete alias of service name
+func (ctrl *ServiceCtrl) DeleteAlias(ctx context.Context, name, alias string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	key := ctrl.serviceAliasKey(name, alias)
+	etcdCtx, span := startEtcdSpan(ctx, "Delete", key)
+	resp, err := ctrl.etcdClient.Delete(etcdCtx, key)
+	span.FinishWithError(err)
+	if err != nil {
+		return utils.CleanErr(err, "delete alias fail", "delete alias(%s) fail: %v", key, err)
+	}
+	if resp.Deleted == 0 {
+		return utils.Errorf(utils.EcodeNotFound, "no such alias: %s:%s", name, alias)
+	}
+	return nil
+}
+
+// ListAliases aliases of service name
+func (ctrl *ServiceCtrl) ListAliases(ctx context.Context, name string) ([]ServiceAlias, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	prefix := ctrl.serviceAliasKeyPrefix(name)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "list aliases fail", "get aliases(%s) fail: %v", prefix, err)
+	}
+	aliases := make([]ServiceAlias, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		target := parseAliasTarget(kv.Value)
+		aliases = append(aliases, ServiceAlias{Name: name, Alias: strings.TrimPrefix(string(kv.Key), prefix),
+			Version: target.Version, Canary: target.Canary, Weight: target.Weight})
+	}
+	return aliases, nil
+}
+
+// watchEither wait for the first change of prefixes since revision,
+// e.g. an aliased service and its alias
+func (ctrl *ServiceCtrl) watchEither(ctx context.Context, revision int64, prefixes ...string) (clientv3.WatchResponse, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		resp clientv3.WatchResponse
+		ok   bool
+	}
+	results := make(chan result, len(prefixes))
+	for _, prefix := range prefixes {
+		go func(prefix string) {
+			resp, ok := ctrl.hub.Watch(ctx, prefix, revision)
+			results <- result{resp: resp, ok: ok}
+		}(prefix)
+	}
+	r := <-results
+	return r.resp, r.ok
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/infrmods/xbus/utils"
+)
+
+func TestAliases(t *testing.T) {
+	ctrl, etcdClient, stop := newEtcdTestCtrl(t, nil)
+	defer stop()
+	ctx := context.Background()
+	name := "payments.core"
+	for _, version := range []string{"1.0", "2.0", "beta"} {
+		if _, err := etcdClient.Put(ctx, ctrl.serviceDescKey(name+":"+version, DefaultZone), `{"type":"http"}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ctrl.SetAlias(ctx, name, "beta", "2.0"); errCode(err) != utils.EcodeNameDuplicated {
+		t.Fatalf("alias of an existing version: %v", err)
+	}
+	if err := ctrl.SetAlias(ctx, name, "stable", "1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if resolved, _, err := ctrl.resolveAlias(WithConsistentQuery(ctx), nil, name+":stable"); err != nil || resolved != name+":1.0" {
+		t.Fatalf("resolve alias: %s, %v", resolved, err)
+	}
+
+	// resolved from the cache once synced
+	if err := ctrl.SetAlias(ctx, name, "stable", "2.0"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		value, synced := ctrl.aliases.get(ctrl.serviceAliasKey(name, "stable"))
+		if synced && string(value) == "2.0" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("alias cache not synced: %q", value)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resolved, _, err := ctrl.resolveAlias(ctx, nil, name+":stable"); err != nil || resolved != name+":2.0" {
+		t.Fatalf("resolve cached alias: %s, %v", resolved, err)
+	}
+}
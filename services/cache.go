@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
+)
+
+// QueryCacheConfig query result cache config
+//
+// cached results are invalidated by a background watch of key_prefix,
+// TTL bounds the staleness if the watch lags
+type QueryCacheConfig struct {
+	Enable bool
+	TTL    time.Duration `default:"30s"`
+	Size   int           `default:"10000"`
+}
+
+type cacheEntry struct {
+	kvs       []*mvccpb.KeyValue
+	revision  int64
+	expiresAt time.Time
+}
+
+type queryCache struct {
+	config QueryCacheConfig
+	ctrl   *ServiceCtrl
+	once   sync.Once
+
+	mu       sync.RWMutex
+	entries  map[string]*cacheEntry
+	synced   bool
+	revision int64
+}
+
+func newQueryCache(config QueryCacheConfig, ctrl *ServiceCtrl) *queryCache {
+	if config.TTL <= 0 {
+		config.TTL = 30 * time.Second
+	}
+	if config.Size <= 0 {
+		config.Size = 10000
+	}
+	return &queryCache{config: config, ctrl: ctrl, entries: make(map[string]*cacheEntry)}
+}
+
+type consistentKey struct{}
+
+// WithConsistentQuery ctx for queries bypassing the query cache
+func WithConsistentQuery(ctx context.Context) context.Context {
+	return context.WithValue(ctx, consistentKey{}, true)
+}
+
+func isConsistentQuery(ctx context.Context) bool {
+	v, _ := ctx.Value(consistentKey{}).(bool)
+	return v
+}
+
+func (cache *queryCache) get(key string) ([]*mvccpb.KeyValue, int64, bool) {
+	cache.once.Do(func() { go cache.run() })
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	if !cache.synced {
+		return nil, 0, false
+	}
+	entry := cache.entries[key]
+	if entry == nil || time.Now().After(entry.expiresAt) {
+		metrics.QueryCache.WithLabelValues("miss").Inc()
+		return nil, 0, false
+	}
+	metrics.QueryCache.WithLabelValues("hit").Inc()
+	return entry.kvs, entry.revision, true
+}
+
+func (cache *queryCache) put(key string, kvs []*mvccpb.KeyValue, revision int64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	// events after revision may have been handled already
+	if !cache.synced || revision < cache.revision {
+		return
+	}
+	if _, ok := cache.entries[key]; !ok && len(cache.entries) >= cache.config.Size {
+		for k := range cache.entries {
+			delete(cache.entries, k)
+			break
+		}
+	}
+	cache.entries[key] = &cacheEntry{kvs: kvs, revision: revision, expiresAt: time.Now().Add(cache.config.TTL)}
+}
+
+func (cache *queryCache) reset(synced bool, revision int64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries = make(map[string]*cacheEntry)
+	cache.synced = synced
+	cache.revision = revision
+}
+
+// invalidate entries of the service and zone the changed key belongs to
+func (cache *queryCache) invalidate(keys []string, revision int64) {
+	prefix := cache.ctrl.config.KeyPrefix + "/"
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for _, key := range keys {
+		parts := strings.SplitN(strings.TrimPrefix(key, prefix), "/", 3)
+		delete(cache.entries, prefix+parts[0]+"/")
+		if len(parts) > 1 {
+			delete(cache.entries, prefix+parts[0]+"/"+parts[1]+"/")
+		}
+	}
+	if revision > cache.revision {
+		cache.revision = revision
+	}
+}
+
+func (cache *queryCache) run() {
+	prefix := cache.ctrl.config.KeyPrefix + "/"
+	for {
+		if err := cache.watch(prefix); err != nil {
+			logging.Warningf("query cache watch fail, retry later: %v", err)
+		}
+		cache.reset(false, 0)
+		time.Sleep(time.Second)
+	}
+}
+
+func (cache *queryCache) watch(prefix string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp, err := cache.ctrl.etcdClient.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return err
+	}
+	revision := resp.Header.Revision
+	watchCh := cache.ctrl.etcdClient.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+	cache.reset(true, revision)
+	for resp := range watchCh {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(resp.Events))
+		for _, event := range resp.Events {
+			keys = append(keys, string(event.Kv.Key))
+		}
+		cache.invalidate(keys, resp.Header.Revision)
+	}
+	return nil
+}
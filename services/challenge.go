@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+const challengePrefix = "XBUS-CHALLENGE "
+
+func challengeDigest(service, address, nonce string) []byte {
+	h := sha256.Sum256([]byte("xbus-challenge\x00" + service + "\x00" + address + "\x00" + nonce))
+	return h[:]
+}
+
+// AnswerEndpointChallenge answer a plug verification challenge read from rw,
+// the registering app should call it on connections to the plugged address
+// starting with "XBUS-CHALLENGE "
+func AnswerEndpointChallenge(rw io.ReadWriter, address string, signer crypto.Signer) error {
+	line, err := bufio.NewReader(rw).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, challengePrefix) {
+		return fmt.Errorf("not a challenge: %q", line)
+	}
+	parts := strings.Fields(line[len(challengePrefix):])
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid challenge: %q", line)
+	}
+	sig, err := signer.Sign(rand.Reader, challengeDigest(parts[0], address, parts[1]), crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(rw, base64.StdEncoding.EncodeToString(sig)+"\n")
+	return err
+}
+
+func (ctrl *ServiceCtrl) challengeEndpoint(ctx context.Context, service, address string, cert *x509.Certificate) error {
+	nonceData := make([]byte, 16)
+	if _, err := rand.Read(nonceData); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(nonceData)
+
+	timeout := ctrl.policies().VerifyAddressTimeout
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := fmt.Fprintf(conn, "%s%s %s\n", challengePrefix, service, nonce); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		return fmt.Errorf("invalid challenge response: %v", err)
+	}
+	return verifySignature(cert.PublicKey, challengeDigest(service, address, nonce), sig)
+}
+
+// VerifyEndpoint check the plugging app controls endpoint's address
+// for services configured by verify_address_services
+func (ctrl *ServiceCtrl) VerifyEndpoint(ctx context.Context, descs []ServiceDescV1,
+	endpoint *ServiceEndpoint, cert *x509.Certificate) error {
+	// sealed endpoint's address is an opaque node id, verified by its signature instead
+	if endpoint.Sealed != nil {
+		for i := range descs {
+			if err := ctrl.checkSealed(WithSigner(ctx, cert), &descs[i], endpoint, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, desc := range descs {
+		if !ctrl.policies().isAddressVerifyRequired(desc.Service) {
+			continue
+		}
+		if cert == nil {
+			return utils.Errorf(utils.EcodeEndpointUnverified, "%s requires app cert to verify address", desc.Service)
+		}
+		if err := ctrl.challengeEndpoint(ctx, desc.Service, endpoint.Address, cert); err != nil {
+			logging.FromContext(ctx).Warningf("verify endpoint(%s - %s) fail: %v", desc.Service, endpoint.Address, err)
+			return utils.Errorf(utils.EcodeEndpointUnverified, "verify %s fail", endpoint.Address)
+		}
+	}
+	return nil
+}
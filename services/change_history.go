@@ -0,0 +1,128 @@
+package services
+
+import (
+	"container/list"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// HistoryEntry a change of a service with who and what of it: the lease and instance of
+// the endpoint, and the endpoint after (plug, update) and before (update, unplug) the change
+type HistoryEntry struct {
+	Change
+	LeaseID    int64            `json:"lease_id,omitempty"`
+	InstanceID string           `json:"instance_id,omitempty"`
+	Endpoint   *ServiceEndpoint `json:"endpoint,omitempty"`
+	Prev       *ServiceEndpoint `json:"prev,omitempty"`
+}
+
+// serviceHistory ring of the latest entries of service
+type serviceHistory struct {
+	service string
+	entries []HistoryEntry
+	next    int
+}
+
+func (history *serviceHistory) add(entry HistoryEntry, size int) {
+	if len(history.entries) < size {
+		history.entries = append(history.entries, entry)
+		return
+	}
+	history.entries[history.next] = entry
+	history.next = (history.next + 1) % len(history.entries)
+}
+
+func decodeHistoryEndpoint(data []byte) *ServiceEndpoint {
+	if len(data) == 0 {
+		return nil
+	}
+	var endpoint ServiceEndpoint
+	if err := json.Unmarshal(data, &endpoint); err != nil {
+		return nil
+	}
+	return &endpoint
+}
+
+func historyEntryOf(change Change, event *clientv3.Event) HistoryEntry {
+	entry := HistoryEntry{Change: change}
+	if change.Address == "" {
+		return entry
+	}
+	if change.Type != ChangeUnplug {
+		entry.Endpoint = decodeHistoryEndpoint(event.Kv.Value)
+		entry.LeaseID = event.Kv.Lease
+	}
+	if event.PrevKv != nil {
+		entry.Prev = decodeHistoryEndpoint(event.PrevKv.Value)
+		if entry.LeaseID == 0 {
+			entry.LeaseID = event.PrevKv.Lease
+		}
+	}
+	if entry.Endpoint != nil {
+		entry.InstanceID = entry.Endpoint.InstanceID
+	} else if entry.Prev != nil {
+		entry.InstanceID = entry.Prev.InstanceID
+	}
+	return entry
+}
+
+// record keep entry in the history of its service, at most size entries per service and
+// the histories of maxServices services, the ones changed least recently are dropped
+func (log *changeLog) record(entry HistoryEntry, size, maxServices int) {
+	if size <= 0 {
+		return
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.history == nil {
+		log.history = make(map[string]*list.Element)
+		log.historyLRU = list.New()
+	}
+	elem := log.history[entry.Service]
+	if elem == nil {
+		if maxServices > 0 && len(log.history) >= maxServices {
+			oldest := log.historyLRU.Back()
+			log.historyLRU.Remove(oldest)
+			delete(log.history, oldest.Value.(*serviceHistory).service)
+		}
+		elem = log.historyLRU.PushFront(&serviceHistory{service: entry.Service})
+		log.history[entry.Service] = elem
+	} else {
+		log.historyLRU.MoveToFront(elem)
+	}
+	elem.Value.(*serviceHistory).add(entry, size)
+}
+
+// History changes of service name:version (all versions if version is empty) since,
+// seen by RunChangeLog of this server (other replicas may have seen others), in revision order
+func (ctrl *ServiceCtrl) History(name, version string, since time.Time) ([]HistoryEntry, error) {
+	if version != "" {
+		if err := checkService(name + ":" + version); err != nil {
+			return nil, err
+		}
+	} else if err := checkName(name); err != nil {
+		return nil, err
+	}
+	log := &ctrl.changes
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	result := make([]HistoryEntry, 0)
+	for service, elem := range log.history {
+		if version != "" && service != name+":"+version {
+			continue
+		} else if version == "" && !strings.HasPrefix(service, name+":") {
+			continue
+		}
+		for _, entry := range elem.Value.(*serviceHistory).entries {
+			if !entry.Time.Before(since) {
+				result = append(result, entry)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Revision < result[j].Revision })
+	return result, nil
+}
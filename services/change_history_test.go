@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryRing(t *testing.T) {
+	var ctrl ServiceCtrl
+	start := time.Now()
+	record := func(service string, revision int64) {
+		ctrl.changes.record(HistoryEntry{Change: Change{Revision: revision, Time: start,
+			Type: ChangePlug, Service: service}}, 3, 2)
+	}
+	for rev := int64(1); rev <= 5; rev++ {
+		record("payments.core:1.0", rev)
+	}
+	entries, err := ctrl.History("payments.core", "1.0", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Revision != 3 || entries[2].Revision != 5 {
+		t.Fatalf("latest entries not kept: %+v", entries)
+	}
+
+	// the service changed least recently is dropped
+	record("payments.refund:1.0", 6)
+	record("payments.core:1.0", 7)
+	record("payments.query:1.0", 8)
+	if entries, _ := ctrl.History("payments.refund", "", start); len(entries) != 0 {
+		t.Fatalf("history of the least recently changed service kept: %+v", entries)
+	}
+	if entries, _ := ctrl.History("payments.core", "", start); len(entries) != 3 || entries[2].Revision != 7 {
+		t.Fatalf("history of recently changed service: %+v", entries)
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/logging"
+)
+
+// change types
+const (
+	ChangePlug   = "plug"
+	ChangeUnplug = "unplug"
+	ChangeUpdate = "update"
+	// ChangeCreate desc of a service zone created
+	ChangeCreate = "create"
+	ChangeDesc   = "desc"
+	// ChangeDelete desc of a service zone deleted
+	ChangeDelete = "delete"
+)
+
+// ChangeLogConfig recent changes kept in memory, e.g. for the dashboard, and the
+// last HistorySize changes of each service, of at most HistoryServices services
+type ChangeLogConfig struct {
+	Size            int `default:"200"`
+	HistorySize     int `default:"100" yaml:"history_size"`
+	HistoryServices int `default:"10000" yaml:"history_services"`
+}
+
+// Change a change of service endpoints or descs
+type Change struct {
+	Revision int64     `json:"revision"`
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Service  string    `json:"service"`
+	Zone     string    `json:"zone"`
+	Address  string    `json:"address,omitempty"`
+}
+
+type changeLog struct {
+	mu        sync.Mutex
+	changes   []Change
+	next      int
+	full      bool
+	listeners []func(Change)
+	// history of services, historyLRU of *serviceHistory, the latest changed first
+	history    map[string]*list.Element
+	historyLRU *list.List
+}
+
+func (log *changeLog) append(change Change, size int) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if size <= 0 {
+		return
+	}
+	if log.changes == nil {
+		log.changes = make([]Change, size)
+	}
+	log.changes[log.next] = change
+	log.next = (log.next + 1) % size
+	if log.next == 0 {
+		log.full = true
+	}
+}
+
+// OnChange call fn with every change seen by RunChangeLog, in revision order,
+// called before RunChangeLog
+func (ctrl *ServiceCtrl) OnChange(fn func(Change)) {
+	ctrl.changes.mu.Lock()
+	defer ctrl.changes.mu.Unlock()
+	ctrl.changes.listeners = append(ctrl.changes.listeners, fn)
+}
+
+// RecentChanges recent changes since RunChangeLog, the latest first
+func (ctrl *ServiceCtrl) RecentChanges() []Change {
+	log := &ctrl.changes
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	n := log.next
+	if log.full {
+		n = len(log.changes)
+	}
+	changes := make([]Change, 0, n)
+	for i := 1; i <= n; i++ {
+		changes = append(changes, log.changes[(log.next-i+len(log.changes))%len(log.changes)])
+	}
+	return changes
+}
+
+const changeLogRetryInterval = 5 * time.Second
+
+// RunChangeLog record changes of all services until ctx done
+func (ctrl *ServiceCtrl) RunChangeLog(ctx context.Context) {
+	size, historySize := ctrl.config.ChangeLog.Size, ctrl.config.ChangeLog.HistorySize
+	ctrl.changes.mu.Lock()
+	listeners := ctrl.changes.listeners
+	ctrl.changes.mu.Unlock()
+	if size <= 0 && historySize <= 0 && len(listeners) == 0 {
+		return
+	}
+	if ctrl.flaps != nil {
+		go ctrl.flaps.run(ctx)
+	}
+	prefix := ctrl.config.KeyPrefix + "/"
+	var revision int64
+	for ctx.Err() == nil {
+		if revision == 0 {
+			resp, err := ctrl.etcdClient.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+			if err != nil {
+				logging.Warningf("get services revision fail, retry: %v", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(changeLogRetryInterval):
+				}
+				continue
+			}
+			revision = resp.Header.Revision
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		for resp := range ctrl.etcdClient.Watch(watchCtx, prefix,
+			clientv3.WithPrefix(), clientv3.WithRev(revision+1), clientv3.WithPrevKV()) {
+			if err := resp.Err(); err != nil {
+				logging.Warningf("watch services changes fail: %v", err)
+				if resp.CompactRevision > 0 {
+					revision = 0
+				}
+				break
+			}
+			for _, event := range resp.Events {
+				if change, ok := ctrl.changeOf(event); ok {
+					ctrl.changes.append(change, size)
+					ctrl.changes.record(historyEntryOf(change, event), historySize, ctrl.config.ChangeLog.HistoryServices)
+					for _, fn := range listeners {
+						fn(change)
+					}
+				}
+			}
+			revision = resp.Header.Revision
+		}
+		cancel()
+	}
+}
+
+func (ctrl *ServiceCtrl) changeOf(event *clientv3.Event) (Change, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(string(event.Kv.Key), ctrl.config.KeyPrefix+"/"), "/", 3)
+	if len(parts) != 3 {
+		return Change{}, false
+	}
+	change := Change{Revision: event.Kv.ModRevision, Time: time.Now(), Service: parts[0], Zone: parts[1]}
+	switch {
+	case parts[2] == serviceDescNodeKey:
+		if event.Type == mvccpb.DELETE {
+			change.Type = ChangeDelete
+		} else if event.IsCreate() {
+			change.Type = ChangeCreate
+		} else {
+			change.Type = ChangeDesc
+		}
+	case strings.HasPrefix(parts[2], serviceKeyNodePrefix):
+		change.Address = strings.TrimPrefix(parts[2], serviceKeyNodePrefix)
+		if event.Type == mvccpb.DELETE {
+			change.Type = ChangeUnplug
+		} else if event.IsCreate() {
+			change.Type = ChangePlug
+		} else {
+			change.Type = ChangeUpdate
+		}
+	default:
+		return Change{}, false
+	}
+	return change, true
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+// ClientPolicy traffic policy of clients of a service, managed centrally and
+// hot-reloaded by sdks; zero fields (nil retries) are left to client configs
+type ClientPolicy struct {
+	Service string `json:"service"`
+	// TimeoutMs timeout of a request
+	TimeoutMs int64 `json:"timeout_ms,omitempty"`
+	// Retries retries of a failed request, nil to leave to client configs, 0 to disable
+	Retries *int64 `json:"retries,omitempty"`
+	// RetryBudget retries at most percent of requests
+	RetryBudget int64 `json:"retry_budget,omitempty"`
+	// EjectAfter consecutive failures to eject an endpoint
+	EjectAfter int64 `json:"eject_after,omitempty"`
+	// EjectionMs base ejection time
+	EjectionMs int64 `json:"ejection_ms,omitempty"`
+	// MaxEjectedPercent endpoints ejected at most
+	MaxEjectedPercent int64 `json:"max_ejected_percent,omitempty"`
+	Revision          int64 `json:"revision"`
+}
+
+const maxPolicyRetries = 10
+
+func checkClientPolicy(policy *ClientPolicy) error {
+	if err := checkService(policy.Service); err != nil {
+		return err
+	}
+	if policy.TimeoutMs < 0 || policy.RetryBudget < 0 || policy.EjectAfter < 0 ||
+		policy.EjectionMs < 0 || policy.MaxEjectedPercent < 0 || (policy.Retries != nil && *policy.Retries < 0) {
+		return utils.NewError(utils.EcodeInvalidParam, "negative client policy")
+	}
+	if policy.Retries != nil && *policy.Retries > maxPolicyRetries {
+		return utils.Errorf(utils.EcodeInvalidParam, "too many retries, max: %d", maxPolicyRetries)
+	}
+	if policy.RetryBudget > 100 || policy.MaxEjectedPercent > 100 {
+		return utils.NewError(utils.EcodeInvalidParam, "percent should be <= 100")
+	}
+	return nil
+}
+
+func (ctrl *ServiceCtrl) clientPolicyKey(service string) string {
+	return ctrl.config.KeyPrefix + "-client-policies/" + service
+}
+
+func clientPolicyFromKv(service string, value []byte, modRevision int64) (*ClientPolicy, error) {
+	var policy ClientPolicy
+	if err := json.Unmarshal(value, &policy); err != nil {
+		return nil, utils.Errorf(utils.EcodeSystemError, "damaged client policy of %s: %v", service, err)
+	}
+	policy.Service = service
+	policy.Revision = modRevision
+	return &policy, nil
+}
+
+// SetClientPolicy set the client policy of service, returns its revision
+func (ctrl *ServiceCtrl) SetClientPolicy(ctx context.Context, policy *ClientPolicy) (int64, error) {
+	if err := checkClientPolicy(policy); err != nil {
+		return 0, err
+	}
+	stored := *policy
+	stored.Revision = 0
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return 0, utils.NewSystemError("marshal client policy fail")
+	}
+	key := ctrl.clientPolicyKey(policy.Service)
+	etcdCtx, span := startEtcdSpan(ctx, "Put", key)
+	resp, err := ctrl.etcdClient.Put(etcdCtx, key, string(data))
+	span.FinishWithError(err)
+	if err != nil {
+		return 0, utils.CleanErr(err, "set client policy fail", "put client policy(%s) fail: %v", key, err)
+	}
+	return resp.Header.Revision, nil
+}
+
+// GetClientPolicy the client policy of service, nil if not set, with the revision read at
+func (ctrl *ServiceCtrl) GetClientPolicy(ctx context.Context, service string) (*ClientPolicy, int64, error) {
+	if err := checkService(service); err != nil {
+		return nil, 0, err
+	}
+	key := ctrl.clientPolicyKey(service)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", key)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, key)
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, 0, utils.CleanErr(err, "get client policy fail", "get client policy(%s) fail: %v", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, resp.Header.Revision, nil
+	}
+	policy, err := clientPolicyFromKv(service, resp.Kvs[0].Value, resp.Kvs[0].ModRevision)
+	return policy, resp.Header.Revision, err
+}
+
+// DeleteClientPolicy delete the client policy of service
+func (ctrl *ServiceCtrl) DeleteClientPolicy(ctx context.Context, service string) error {
+	if err := checkService(service); err != nil {
+		return err
+	}
+	key := ctrl.clientPolicyKey(service)
+	etcdCtx, span := startEtcdSpan(ctx, "Delete", key)
+	resp, err := ctrl.etcdClient.Delete(etcdCtx, key)
+	span.FinishWithError(err)
+	if err != nil {
+		return utils.CleanErr(err, "delete client policy fail", "delete client policy(%s) fail: %v", key, err)
+	}
+	if resp.Deleted == 0 {
+		return utils.Errorf(utils.EcodeNotFound, "no client policy of %s", service)
+	}
+	return nil
+}
+
+// WatchClientPolicy wait for the change of the client policy of service since revision,
+// nil if deleted; fails with *WatchError on timeout, cancel or compaction
+func (ctrl *ServiceCtrl) WatchClientPolicy(ctx context.Context, service string, revision int64) (*ClientPolicy, int64, error) {
+	if err := checkService(service); err != nil {
+		return nil, 0, err
+	}
+	key := ctrl.clientPolicyKey(service)
+	revision, err := ctrl.watchStartRevision(ctx, key, revision)
+	if err != nil {
+		if e, ok := err.(*WatchError); ok {
+			return nil, e.Revision, e
+		}
+		return nil, 0, err
+	}
+	for {
+		resp, ok := ctrl.hub.Watch(ctx, key, revision)
+		if err := checkWatchResponse(ctx, resp, ok, revision-1); err != nil {
+			if e, ok := err.(*WatchError); ok {
+				return nil, e.Revision, e
+			}
+			return nil, 0, err
+		}
+		// the prefix matches policies of longer versions too
+		for i := len(resp.Events) - 1; i >= 0; i-- {
+			event := resp.Events[i]
+			if string(event.Kv.Key) != key {
+				continue
+			}
+			if event.Type == clientv3.EventTypeDelete {
+				return nil, resp.Header.Revision, nil
+			}
+			policy, err := clientPolicyFromKv(service, event.Kv.Value, event.Kv.ModRevision)
+			return policy, resp.Header.Revision, err
+		}
+		revision = resp.Header.Revision + 1
+	}
+}
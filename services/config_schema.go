@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/infrmods/xbus/utils"
+)
+
+// ConfigSchema json schema of the config of service's endpoints
+type ConfigSchema struct {
+	Service  string          `json:"service"`
+	Schema   json.RawMessage `json:"schema"`
+	Revision int64           `json:"revision"`
+}
+
+// configSchemaCache compiled schemas by key, reused until the key's mod revision changes
+type configSchemaCache struct {
+	mu      sync.Mutex
+	schemas map[string]compiledConfigSchema
+}
+
+type compiledConfigSchema struct {
+	revision int64
+	schema   *jsonSchema
+}
+
+func (cache *configSchemaCache) get(key string, revision int64) *jsonSchema {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if item, ok := cache.schemas[key]; ok && item.revision == revision {
+		return item.schema
+	}
+	return nil
+}
+
+func (cache *configSchemaCache) put(key string, revision int64, schema *jsonSchema) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.schemas == nil {
+		cache.schemas = make(map[string]compiledConfigSchema)
+	}
+	cache.schemas[key] = compiledConfigSchema{revision: revision, schema: schema}
+}
+
+func (ctrl *ServiceCtrl) configSchemaKey(service string) string {
+	return ctrl.config.KeyPrefix + "-config-schemas/" + service
+}
+
+// SetConfigSchema set json schema of the config of service's endpoints,
+// endpoints plugged or updated later are rejected if their config doesn't match
+func (ctrl *ServiceCtrl) SetConfigSchema(ctx context.Context, service string, schema []byte) (int64, error) {
+	if err := checkService(service); err != nil {
+		return 0, err
+	}
+	if _, err := compileJSONSchema(schema); err != nil {
+		return 0, utils.Errorf(utils.EcodeInvalidValue, "invalid config schema: %v", err)
+	}
+	key := ctrl.configSchemaKey(service)
+	etcdCtx, span := startEtcdSpan(ctx, "Put", key)
+	resp, err := ctrl.etcdClient.Put(etcdCtx, key, string(schema))
+	span.FinishWithError(err)
+	if err != nil {
+		return 0, utils.CleanErr(err, "set config schema fail", "put config schema(%s) fail: %v", key, err)
+	}
+	return resp.Header.Revision, nil
+}
+
+// GetConfigSchema json schema of the config of service's endpoints
+func (ctrl *ServiceCtrl) GetConfigSchema(ctx context.Context, service string) (*ConfigSchema, error) {
+	if err := checkService(service); err != nil {
+		return nil, err
+	}
+	key := ctrl.configSchemaKey(service)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", key)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, key)
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get config schema fail", "get config schema(%s) fail: %v", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no config schema of %s", service)
+	}
+	return &ConfigSchema{Service: service, Schema: resp.Kvs[0].Value, Revision: resp.Kvs[0].ModRevision}, nil
+}
+
+// DeleteConfigSchema delete json schema of the config of service's endpoints
+func (ctrl *ServiceCtrl) DeleteConfigSchema(ctx context.Context, service string) error {
+	if err := checkService(service); err != nil {
+		return err
+	}
+	key := ctrl.configSchemaKey(service)
+	etcdCtx, span := startEtcdSpan(ctx, "Delete", key)
+	resp, err := ctrl.etcdClient.Delete(etcdCtx, key)
+	span.FinishWithError(err)
+	if err != nil {
+		return utils.CleanErr(err, "delete config schema fail", "delete config schema(%s) fail: %v", key, err)
+	}
+	if resp.Deleted == 0 {
+		return utils.Errorf(utils.EcodeNotFound, "no config schema of %s", service)
+	}
+	return nil
+}
+
+// checkConfigSchema check config of endpoint against the config schema of service if any,
+// an empty config is checked as null, sealed endpoints are not checked
+func (ctrl *ServiceCtrl) checkConfigSchema(ctx context.Context, service string, endpoint *ServiceEndpoint) error {
+	if endpoint.Sealed != nil {
+		return nil
+	}
+	key := ctrl.configSchemaKey(service)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", key)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, key)
+	span.FinishWithError(err)
+	if err != nil {
+		return utils.CleanErr(err, "get config schema fail", "get config schema(%s) fail: %v", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil
+	}
+	kv := resp.Kvs[0]
+	schema := ctrl.configSchemas.get(key, kv.ModRevision)
+	if schema == nil {
+		if schema, err = compileJSONSchema(kv.Value); err != nil {
+			return utils.Errorf(utils.EcodeSystemError, "damaged config schema of %s: %v", service, err)
+		}
+		ctrl.configSchemas.put(key, kv.ModRevision, schema)
+	}
+	var config interface{}
+	if endpoint.Config != "" {
+		if err := json.Unmarshal([]byte(endpoint.Config), &config); err != nil {
+			return utils.Errorf(utils.EcodeInvalidEndpoint, "config of %s should be json: %v", service, err)
+		}
+	}
+	if err := schema.validate("config", config); err != nil {
+		return utils.Errorf(utils.EcodeInvalidEndpoint, "config doesn't match the schema of %s: %v", service, err)
+	}
+	return nil
+}
@@ -6,7 +6,7 @@ import (
 	"time"
 
 	"github.com/gocomm/dbutil"
-	"github.com/golang/glog"
+	"github.com/infrmods/xbus/logging"
 	"github.com/infrmods/xbus/utils"
 )
 
@@ -65,7 +65,7 @@ func (ctrl *ServiceCtrl) SearchService(service string, skip int64, limit int64)
 	like := "%" + service + "%"
 	var total int64
 	if err := dbutil.Query(ctrl.db, &total, `select count(*) from services where status=? and service like ?`, serviceStatusOk, like); err != nil {
-		glog.Errorf("query db services(%s) fail: %v", service, err)
+		logging.Errorf("query db services(%s) fail: %v", service, err)
 		return nil, utils.NewError(utils.EcodeSystemError, "query db services fail")
 	}
 
@@ -77,14 +77,14 @@ func (ctrl *ServiceCtrl) SearchService(service string, skip int64, limit int64)
 			for rows.Next() {
 				var service, zone, typ string
 				if err := rows.Scan(&service, &zone, &typ); err != nil {
-					glog.Errorf("query db services(%s) fail: %v", service, err)
+					logging.Errorf("query db services(%s) fail: %v", service, err)
 					return nil, utils.NewError(utils.EcodeSystemError, "query db services fail")
 				}
 				result.Services = append(result.Services,
 					ServiceItemV1{Service: service, Zone: zone, Type: typ})
 			}
 		} else {
-			glog.Errorf("query db services(%s) fail: %v", service, err)
+			logging.Errorf("query db services(%s) fail: %v", service, err)
 			return nil, utils.NewError(utils.EcodeSystemError, "query db services fail")
 		}
 	}
@@ -99,3 +99,17 @@ func (ctrl *ServiceCtrl) deleteServiceDBItems(service, zone string) (err error)
 	}
 	return
 }
+
+type serviceZoneItem struct {
+	Service    string
+	Zone       string
+	ModifyTime time.Time
+}
+
+func (ctrl *ServiceCtrl) listServiceZoneDBItems() ([]serviceZoneItem, error) {
+	var items []serviceZoneItem
+	if err := dbutil.Query(ctrl.db, &items, `select service, zone, modify_time from services where status=?`, serviceStatusOk); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
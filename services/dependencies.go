@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gocomm/dbutil"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+const maxDeclaredDependencies = 1000
+
+// Dependency edge from app to a service it consumes
+type Dependency struct {
+	App        string    `json:"app"`
+	Service    string    `json:"service"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+// ImpactedApp app affected by maintenance of a service, Depth 1 for direct consumers,
+// Via the consumed services of the previous depth
+type ImpactedApp struct {
+	App   string   `json:"app"`
+	Depth int      `json:"depth"`
+	Via   []string `json:"via"`
+}
+
+// DeclareDependencies replace the services app consumes with services, create time of
+// unchanged edges is kept
+func (ctrl *ServiceCtrl) DeclareDependencies(app string, services []string) (rerr error) {
+	if app == "" {
+		return utils.NewError(utils.EcodeNotPermitted, "dependencies are declared by apps")
+	}
+	if len(services) > maxDeclaredDependencies {
+		return utils.Errorf(utils.EcodeInvalidParam, "too many dependencies, max: %d", maxDeclaredDependencies)
+	}
+	unique := make(map[string]bool, len(services))
+	for _, service := range services {
+		if err := checkService(service); err != nil {
+			return utils.Errorf(utils.EcodeInvalidService, "invalid service: %s", service)
+		}
+		unique[service] = true
+	}
+
+	tx, err := ctrl.db.Begin()
+	if err != nil {
+		logging.Errorf("new db tx fail: %v", err)
+		return utils.NewError(utils.EcodeSystemError, "new db tx fail")
+	}
+	defer func() {
+		if rerr != nil {
+			if err := tx.Rollback(); err != nil {
+				logging.Warningf("tx rollback fail: %v", err)
+			}
+		}
+	}()
+	sqlValues := make([]string, 0, len(unique))
+	placeholders := make([]string, 0, len(unique))
+	values := make([]interface{}, 0, len(unique)*2)
+	kept := []interface{}{app}
+	for service := range unique {
+		sqlValues = append(sqlValues, "(?,?)")
+		placeholders = append(placeholders, "?")
+		values = append(values, app, service)
+		kept = append(kept, service)
+	}
+	deleteSQL := `delete from service_dependencies where app=?`
+	if len(unique) > 0 {
+		deleteSQL += ` and service not in (` + strings.Join(placeholders, ",") + `)`
+	}
+	if _, err := tx.Exec(deleteSQL, kept...); err != nil {
+		logging.Errorf("delete dependencies of %s fail: %v", app, err)
+		return utils.NewError(utils.EcodeSystemError, "update dependencies fail")
+	}
+	if len(unique) > 0 {
+		if _, err := tx.Exec(`insert ignore into service_dependencies(app, service) values `+
+			strings.Join(sqlValues, ","), values...); err != nil {
+			logging.Errorf("insert dependencies of %s fail: %v", app, err)
+			return utils.NewError(utils.EcodeSystemError, "update dependencies fail")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logging.Errorf("update dependencies of %s, commit fail: %v", app, err)
+		return utils.NewError(utils.EcodeSystemError, "commit db fail")
+	}
+	return nil
+}
+
+// DependenciesOf services app declared to consume
+func (ctrl *ServiceCtrl) DependenciesOf(app string) ([]Dependency, error) {
+	deps := make([]Dependency, 0)
+	if err := dbutil.Query(ctrl.db, &deps, `select app, service, create_time from service_dependencies
+            where app=? order by service`, app); err != nil {
+		logging.Errorf("query dependencies of %s fail: %v", app, err)
+		return nil, utils.NewError(utils.EcodeSystemError, "query dependencies fail")
+	}
+	return deps, nil
+}
+
+// consumersOf dependencies on service, all versions if service has no version
+func (ctrl *ServiceCtrl) consumersOf(service string) ([]Dependency, error) {
+	deps := make([]Dependency, 0)
+	var err error
+	if strings.Contains(service, ":") {
+		err = dbutil.Query(ctrl.db, &deps, `select app, service, create_time from service_dependencies
+                where service=? order by app`, service)
+	} else {
+		err = dbutil.Query(ctrl.db, &deps, `select app, service, create_time from service_dependencies
+                where service like ? order by app`, escapeLike(service)+":%")
+	}
+	if err != nil {
+		logging.Errorf("query consumers of %s fail: %v", service, err)
+		return nil, utils.NewError(utils.EcodeSystemError, "query consumers fail")
+	}
+	return deps, nil
+}
+
+// consumersOfServices dependencies on any of services
+func (ctrl *ServiceCtrl) consumersOfServices(services []string) ([]Dependency, error) {
+	deps := make([]Dependency, 0)
+	if len(services) == 0 {
+		return deps, nil
+	}
+	placeholders := make([]string, 0, len(services))
+	values := make([]interface{}, 0, len(services))
+	for _, service := range services {
+		placeholders = append(placeholders, "?")
+		values = append(values, service)
+	}
+	if err := dbutil.Query(ctrl.db, &deps, `select app, service, create_time from service_dependencies
+            where service in (`+strings.Join(placeholders, ",")+`) order by app`, values...); err != nil {
+		logging.Errorf("query consumers of %d services fail: %v", len(services), err)
+		return nil, utils.NewError(utils.EcodeSystemError, "query consumers fail")
+	}
+	return deps, nil
+}
+
+// ConsumersOf apps depending on service, all versions if service is a name
+func (ctrl *ServiceCtrl) ConsumersOf(service string) ([]Dependency, error) {
+	if checkService(service) != nil && checkName(service) != nil {
+		return nil, utils.NewError(utils.EcodeInvalidService, "")
+	}
+	return ctrl.consumersOf(service)
+}
+
+// Impact apps affected if service (or all versions of a name) is down, up to maxDepth hops:
+// consumers of service, then consumers of the services those apps plugged (by owner keys), and so on
+func (ctrl *ServiceCtrl) Impact(ctx context.Context, service string, maxDepth int) ([]ImpactedApp, error) {
+	if checkService(service) != nil && checkName(service) != nil {
+		return nil, utils.NewError(utils.EcodeInvalidService, "")
+	}
+	impacted := make(map[string]*ImpactedApp)
+	deps, err := ctrl.consumersOf(service)
+	if err != nil {
+		return nil, err
+	}
+	for depth := 1; depth <= maxDepth && len(deps) > 0; depth++ {
+		var next []string
+		for _, dep := range deps {
+			if item := impacted[dep.App]; item != nil {
+				if item.Depth == depth {
+					item.Via = append(item.Via, dep.Service)
+				}
+				continue
+			}
+			impacted[dep.App] = &ImpactedApp{App: dep.App, Depth: depth, Via: []string{dep.Service}}
+			next = append(next, dep.App)
+		}
+		if depth == maxDepth {
+			break
+		}
+		var owned []string
+		for _, app := range next {
+			services, err := ctrl.ownedServices(ctx, app)
+			if err != nil {
+				return nil, err
+			}
+			owned = append(owned, services...)
+		}
+		if deps, err = ctrl.consumersOfServices(owned); err != nil {
+			return nil, err
+		}
+	}
+	result := make([]ImpactedApp, 0, len(impacted))
+	for _, item := range impacted {
+		sort.Strings(item.Via)
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Depth != result[j].Depth {
+			return result[i].Depth < result[j].Depth
+		}
+		return result[i].App < result[j].App
+	})
+	return result, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
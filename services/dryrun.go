@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/coreos/etcd/clientv3"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+)
+
+// DryRunChange a key a mutating op would change
+type DryRunChange struct {
+	Op        string `json:"op"`
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	PrevValue string `json:"prev_value,omitempty"`
+}
+
+// dry run ops
+const (
+	DryRunPut    = "put"
+	DryRunDelete = "delete"
+)
+
+// DryRun changes recorded instead of committed, with notes of side effects
+// not shown as key changes, e.g. lease grants
+type DryRun struct {
+	mu       sync.Mutex
+	Changes  []DryRunChange `json:"changes"`
+	Notes    []string       `json:"notes,omitempty"`
+	Revision int64          `json:"revision"`
+}
+
+type dryRunKey struct{}
+
+// WithDryRun ctx of mutating ops that are fully validated, but only recorded to the returned DryRun
+func WithDryRun(ctx context.Context) (context.Context, *DryRun) {
+	dryRun := &DryRun{Changes: make([]DryRunChange, 0)}
+	return context.WithValue(ctx, dryRunKey{}, dryRun), dryRun
+}
+
+func dryRunOf(ctx context.Context) *DryRun {
+	dryRun, _ := ctx.Value(dryRunKey{}).(*DryRun)
+	return dryRun
+}
+
+func (dryRun *DryRun) note(format string, args ...interface{}) {
+	dryRun.mu.Lock()
+	defer dryRun.mu.Unlock()
+	dryRun.Notes = append(dryRun.Notes, fmt.Sprintf(format, args...))
+}
+
+// readOps ops reading the keys written by ops, nested txns keep their compares
+func readOps(ops []clientv3.Op) []clientv3.Op {
+	reads := make([]clientv3.Op, 0, len(ops))
+	for _, op := range ops {
+		switch {
+		case op.IsTxn():
+			cmps, thenOps, elseOps := op.Txn()
+			reads = append(reads, clientv3.OpTxn(cmps, readOps(thenOps), readOps(elseOps)))
+		case op.IsPut():
+			reads = append(reads, clientv3.OpGet(string(op.KeyBytes())))
+		case op.IsDelete():
+			if end := op.RangeBytes(); len(end) > 0 {
+				reads = append(reads, clientv3.OpGet(string(op.KeyBytes()), clientv3.WithRange(string(end))))
+			} else {
+				reads = append(reads, clientv3.OpGet(string(op.KeyBytes())))
+			}
+		default:
+			reads = append(reads, op)
+		}
+	}
+	return reads
+}
+
+// record changes of ops taken, by responses of their readOps
+func (dryRun *DryRun) record(ops []clientv3.Op, resps []*pb.ResponseOp) {
+	for i, op := range ops {
+		switch {
+		case op.IsTxn():
+			txnResp := resps[i].GetResponseTxn()
+			_, thenOps, elseOps := op.Txn()
+			if txnResp.Succeeded {
+				dryRun.record(thenOps, txnResp.Responses)
+			} else {
+				dryRun.record(elseOps, txnResp.Responses)
+			}
+		case op.IsPut():
+			change := DryRunChange{Op: DryRunPut, Key: string(op.KeyBytes()), Value: string(op.ValueBytes())}
+			if kvs := resps[i].GetResponseRange().Kvs; len(kvs) > 0 {
+				change.PrevValue = string(kvs[0].Value)
+			}
+			dryRun.Changes = append(dryRun.Changes, change)
+		case op.IsDelete():
+			for _, kv := range resps[i].GetResponseRange().Kvs {
+				dryRun.Changes = append(dryRun.Changes,
+					DryRunChange{Op: DryRunDelete, Key: string(kv.Key), PrevValue: string(kv.Value)})
+			}
+		}
+	}
+}
+
+// txn commit txn, or record what it would change if dry run
+func (ctrl *ServiceCtrl) txn(ctx context.Context, cmps []clientv3.Cmp, ops []clientv3.Op) (bool, error) {
+	dryRun := dryRunOf(ctx)
+	if dryRun == nil {
+		resp, err := ctrl.etcdClient.Txn(ctx).If(cmps...).Then(ops...).Commit()
+		if err != nil {
+			return false, err
+		}
+		return resp.Succeeded, nil
+	}
+	resp, err := ctrl.etcdClient.Txn(ctx).If(cmps...).Then(readOps(ops)...).Commit()
+	if err != nil {
+		return false, err
+	}
+	if resp.Succeeded {
+		dryRun.mu.Lock()
+		dryRun.record(ops, resp.Responses)
+		dryRun.Revision = resp.Header.Revision
+		dryRun.mu.Unlock()
+	}
+	return resp.Succeeded, nil
+}
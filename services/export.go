@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+// ExportZones all service zones with their descs and raw endpoints, at revision if not 0
+func (ctrl *ServiceCtrl) ExportZones(ctx context.Context, revision int64) ([]ServiceZoneV1, int64, error) {
+	prefix := ctrl.config.KeyPrefix + "/"
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, opts...)
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, 0, utils.CleanErr(err, "export services fail", "get services fail: %v", err)
+	}
+	zones := make([]ServiceZoneV1, 0)
+	index := make(map[string]int)
+	for _, kv := range resp.Kvs {
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		key := parts[0] + "/" + parts[1]
+		i, ok := index[key]
+		if !ok {
+			i = len(zones)
+			index[key] = i
+			zones = append(zones, ServiceZoneV1{Endpoints: make([]ServiceEndpoint, 0),
+				ServiceDescV1: ServiceDescV1{Service: parts[0], Zone: parts[1]}})
+		}
+		zone := &zones[i]
+		switch {
+		case parts[2] == serviceDescNodeKey:
+			if err := json.Unmarshal(kv.Value, &zone.ServiceDescV1); err != nil {
+				logging.Warningf("export invalid service desc(%s), skip", kv.Key)
+			}
+			zone.Service, zone.Zone = parts[0], parts[1]
+		case strings.HasPrefix(parts[2], serviceKeyNodePrefix):
+			var endpoint ServiceEndpoint
+			if err := json.Unmarshal(kv.Value, &endpoint); err != nil {
+				logging.Warningf("export invalid endpoint(%s), skip", kv.Key)
+				continue
+			}
+			zone.Endpoints = append(zone.Endpoints, endpoint)
+		}
+	}
+	return zones, resp.Header.Revision, nil
+}
+
+// ImportStats counts of imported service zones and endpoints, and skipped endpoints
+type ImportStats struct {
+	Zones     int `json:"zones"`
+	Endpoints int `json:"endpoints"`
+	Skipped   int `json:"skipped"`
+}
+
+// ops per import txn, below etcd's default max-txn-ops 128
+const importTxnOps = 100
+
+// ImportZones put descs and endpoints of zones, existing ones are overwritten; static
+// endpoints are permanent, the others are bound to a new lease of leaseTTL, or skipped
+// if leaseTTL is 0 as their owners plug them again
+func (ctrl *ServiceCtrl) ImportZones(ctx context.Context, zones []ServiceZoneV1, leaseTTL time.Duration) (*ImportStats, error) {
+	for i := range zones {
+		if err := checkDesc(&zones[i].ServiceDescV1); err != nil {
+			return nil, err
+		}
+		for _, endpoint := range zones[i].Endpoints {
+			if endpoint.Address == "" || !rValidAddress.MatchString(endpoint.Address) {
+				return nil, utils.Errorf(utils.EcodeInvalidAddress, "%s/%s: %s",
+					zones[i].Service, zones[i].Zone, endpoint.Address)
+			}
+		}
+	}
+	var leaseID clientv3.LeaseID
+	if leaseTTL > 0 {
+		resp, err := ctrl.etcdClient.Grant(ctx, int64(leaseTTL.Seconds()))
+		if err != nil {
+			return nil, utils.CleanErr(err, "create lease fail", "create lease fail: %v", err)
+		}
+		leaseID = resp.ID
+	}
+
+	var stats ImportStats
+	descs := make([]ServiceDescV1, 0, len(zones))
+	ops := make([]clientv3.Op, 0, importTxnOps)
+	flush := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		if _, err := ctrl.etcdClient.Txn(ctx).Then(ops...).Commit(); err != nil {
+			return utils.CleanErr(err, "import services fail", "import services fail: %v", err)
+		}
+		ops = ops[:0]
+		return nil
+	}
+	for i := range zones {
+		zone := &zones[i]
+		descData, err := zone.ServiceDescV1.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		if len(ops)+2 > importTxnOps {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		ops = append(ops,
+			clientv3.OpPut(ctrl.serviceDescKey(zone.Service, zone.Zone), string(descData)),
+			clientv3.OpPut(ctrl.serviceDescNotifyKey(zone.Service, zone.Zone), string(descData)))
+		descs = append(descs, zone.ServiceDescV1)
+		stats.Zones++
+		for j := range zone.Endpoints {
+			endpoint := &zone.Endpoints[j]
+			if !endpoint.Static && leaseID == 0 {
+				stats.Skipped++
+				continue
+			}
+			data, err := endpoint.Marshal()
+			if err != nil {
+				return nil, err
+			}
+			nodeKey := ctrl.serviceNodeKey(zone.Service, zone.Zone, endpoint.Address)
+			if endpoint.Static {
+				ops = append(ops, clientv3.OpPut(nodeKey, string(data)))
+			} else {
+				ops = append(ops, clientv3.OpPut(nodeKey, string(data), clientv3.WithLease(leaseID)))
+			}
+			stats.Endpoints++
+			if len(ops) >= importTxnOps {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	for len(descs) > 0 {
+		n := len(descs)
+		if n > importTxnOps {
+			n = importTxnOps
+		}
+		if err := ctrl.updateServiceDBItems(descs[:n]); err != nil {
+			logging.FromContext(ctx).Errorf("update service db items fail: %v", err)
+			return nil, utils.NewError(utils.EcodeSystemError, "update db fail")
+		}
+		descs = descs[n:]
+	}
+	return &stats, nil
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/tracing"
+	"github.com/infrmods/xbus/utils"
+)
+
+// federation query modes
+const (
+	// FederationLocal query the local cluster only
+	FederationLocal = "local"
+	// FederationFailover query remote clusters in order if the local query fails or finds nothing
+	FederationFailover = "failover"
+	// FederationAggregate merge endpoints of the local and all remote clusters
+	FederationAggregate = "aggregate"
+)
+
+// RemoteCluster remote etcd cluster, e.g. of another datacenter, sharing the key prefix
+type RemoteCluster struct {
+	Name string           `yaml:"name"`
+	Etcd utils.ETCDConfig `yaml:"etcd"`
+}
+
+// FederationConfig federation config, Name is of the local cluster, writes always go to it
+type FederationConfig struct {
+	Name     string          `default:"local" yaml:"name"`
+	Clusters []RemoteCluster `yaml:"clusters"`
+	Mode     string          `default:"local" yaml:"mode"`
+	Timeout  time.Duration   `default:"3s" yaml:"timeout"`
+}
+
+func (config *FederationConfig) prepare() error {
+	if _, err := config.mode(""); err != nil {
+		return fmt.Errorf("invalid federation mode: %s", config.Mode)
+	}
+	if config.Name == "" {
+		config.Name = "local"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 3 * time.Second
+	}
+	names := map[string]bool{config.Name: true}
+	for i, cluster := range config.Clusters {
+		if cluster.Name == "" {
+			return fmt.Errorf("missing name of federation cluster %d", i)
+		}
+		if names[cluster.Name] {
+			return fmt.Errorf("duplicate federation cluster: %s", cluster.Name)
+		}
+		names[cluster.Name] = true
+	}
+	return nil
+}
+
+// mode query mode, the configured one if empty
+func (config *FederationConfig) mode(mode string) (string, error) {
+	if mode == "" {
+		mode = config.Mode
+	}
+	switch mode {
+	case "", FederationLocal:
+		return FederationLocal, nil
+	case FederationFailover, FederationAggregate:
+		return mode, nil
+	}
+	return "", utils.Errorf(utils.EcodeInvalidParam, "invalid federation mode: %s", mode)
+}
+
+type remoteCluster struct {
+	name       string
+	etcdClient *clientv3.Client
+}
+
+// AddRemote add remote cluster queried by federated queries
+func (ctrl *ServiceCtrl) AddRemote(name string, etcdClient *clientv3.Client) {
+	ctrl.remotes = append(ctrl.remotes, remoteCluster{name: name, etcdClient: etcdClient})
+}
+
+// QueryFederated query service with federation mode, the configured one if empty,
+// the revision is of the local cluster. Net mappings aren't applied to remote endpoints
+func (ctrl *ServiceCtrl) QueryFederated(ctx context.Context, clientIP net.IP, service, mode string) (*ServiceV1, int64, error) {
+	mode, err := ctrl.config.Federation.mode(mode)
+	if err != nil {
+		return nil, 0, err
+	}
+	if mode == FederationLocal || len(ctrl.remotes) == 0 {
+		return ctrl.Query(ctx, clientIP, service)
+	}
+	if err := checkService(service); err != nil {
+		return nil, 0, err
+	}
+
+	ctx, span := tracing.StartSpan(ctx, "services.QueryFederated")
+	span.SetAttribute("service", service)
+	span.SetAttribute("mode", mode)
+	result, rev, err := ctrl.Query(ctx, clientIP, service)
+	if mode == FederationFailover {
+		if err != nil || !hasEndpoints(result) {
+			if err != nil {
+				logging.FromContext(ctx).Infof("query %s locally fail, failover: %v", service, err)
+			} else {
+				logging.FromContext(ctx).Infof("no endpoints of %s locally, failover", service)
+			}
+			for _, remote := range ctrl.remotes {
+				remoteResult, remoteErr := ctrl.queryRemote(ctx, remote, service)
+				if remoteErr != nil {
+					logging.FromContext(ctx).Warningf("query %s from cluster(%s) fail: %v", service, remote.name, remoteErr)
+					continue
+				}
+				if hasEndpoints(remoteResult) {
+					result, rev, err = remoteResult, 0, nil
+					break
+				}
+				if result == nil {
+					// keep the descs found remotely if none locally
+					result, rev, err = remoteResult, 0, nil
+				}
+			}
+		}
+		span.FinishWithError(err)
+		return result, rev, err
+	}
+
+	if err != nil && !isNotFound(err) {
+		span.FinishWithError(err)
+		return nil, 0, err
+	}
+	for _, remote := range ctrl.remotes {
+		remoteResult, remoteErr := ctrl.queryRemote(ctx, remote, service)
+		if remoteErr != nil {
+			if !isNotFound(remoteErr) {
+				logging.FromContext(ctx).Warningf("query %s from cluster(%s) fail: %v", service, remote.name, remoteErr)
+			}
+			continue
+		}
+		if result == nil {
+			result, err = remoteResult, nil
+			continue
+		}
+		mergeService(result, remoteResult)
+	}
+	span.FinishWithError(err)
+	return result, rev, err
+}
+
+func (ctrl *ServiceCtrl) queryRemote(ctx context.Context, remote remoteCluster, service string) (*ServiceV1, error) {
+	service, _, err := ctrl.resolveAlias(ctx, nil, service)
+	if err != nil {
+		return nil, err
+	}
+	prefix := ctrl.serviceEntryPrefix(service)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	span.SetAttribute("cluster", remote.name)
+	etcdCtx, cancel := context.WithTimeout(etcdCtx, ctrl.config.Federation.Timeout)
+	defer cancel()
+	resp, err := remote.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "query fail", "Query(%s) fail: %v", prefix, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no such service: %s", service)
+	}
+	return ctrl.makeService(nil, service, resp.Kvs)
+}
+
+// mergeService merge zones and endpoints of other into service, endpoints
+// with plugged addresses and descs of existing zones are kept
+func mergeService(service, other *ServiceV1) {
+	for zone, otherZone := range other.Zones {
+		serviceZone := service.Zones[zone]
+		if serviceZone == nil {
+			service.Zones[zone] = otherZone
+			continue
+		}
+		addrs := make(map[string]bool, len(serviceZone.Endpoints))
+		for _, endpoint := range serviceZone.Endpoints {
+			addrs[endpoint.Address] = true
+		}
+		for _, endpoint := range otherZone.Endpoints {
+			if !addrs[endpoint.Address] {
+				addrs[endpoint.Address] = true
+				serviceZone.Endpoints = append(serviceZone.Endpoints, endpoint)
+			}
+		}
+	}
+}
+
+func hasEndpoints(service *ServiceV1) bool {
+	if service == nil {
+		return false
+	}
+	for _, zone := range service.Zones {
+		if len(zone.Endpoints) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, utils.ErrNotFound)
+}
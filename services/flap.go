@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
+)
+
+// FlapConfig an endpoint plugged or unplugged Threshold times within Window is flapping,
+// until it's stable for Hold; flapping endpoints are only reported, query results aren't
+// changed by the state of each replica
+type FlapConfig struct {
+	Enable    bool
+	Threshold int           `default:"4"`
+	Window    time.Duration `default:"5m"`
+	Hold      time.Duration `default:"10m"`
+}
+
+// FlappingEndpoint endpoint flagged as flapping, Changes is the plugs and unplugs within the window
+type FlappingEndpoint struct {
+	Service    string    `json:"service"`
+	Zone       string    `json:"zone"`
+	Address    string    `json:"address"`
+	Since      time.Time `json:"since"`
+	LastChange time.Time `json:"last_change"`
+	Changes    int       `json:"changes"`
+}
+
+type flapTrack struct {
+	changes []time.Time
+	since   time.Time
+	last    time.Time
+}
+
+type flapKey struct {
+	service, zone, address string
+}
+
+// flapDetector plug/unplug churn of endpoints seen by the change log
+type flapDetector struct {
+	config FlapConfig
+
+	mu     sync.Mutex
+	tracks map[flapKey]*flapTrack
+}
+
+const flapSweepInterval = 10 * time.Second
+
+func newFlapDetector(config FlapConfig) *flapDetector {
+	if config.Threshold <= 1 {
+		config.Threshold = 4
+	}
+	if config.Window <= 0 {
+		config.Window = 5 * time.Minute
+	}
+	if config.Hold <= 0 {
+		config.Hold = config.Window
+	}
+	return &flapDetector{config: config, tracks: make(map[flapKey]*flapTrack)}
+}
+
+func (d *flapDetector) flapping(track *flapTrack, now time.Time) bool {
+	return !track.since.IsZero() && now.Sub(track.last) < d.config.Hold
+}
+
+func (d *flapDetector) observe(change Change) {
+	if change.Type != ChangePlug && change.Type != ChangeUnplug {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := flapKey{service: change.Service, zone: change.Zone, address: change.Address}
+	track := d.tracks[key]
+	if track == nil {
+		track = new(flapTrack)
+		d.tracks[key] = track
+	}
+	now := change.Time
+	if !track.since.IsZero() && !d.flapping(track, now) {
+		logging.Infof("endpoint %s/%s/%s stopped flapping", change.Service, change.Zone, change.Address)
+		track.since = time.Time{}
+	}
+	changes := track.changes[:0]
+	for _, t := range track.changes {
+		if now.Sub(t) < d.config.Window {
+			changes = append(changes, t)
+		}
+	}
+	track.changes, track.last = append(changes, now), now
+	if track.since.IsZero() && len(track.changes) >= d.config.Threshold {
+		track.since = now
+		metrics.EndpointFlaps.Inc()
+		logging.Warningf("endpoint %s/%s/%s flapping, plugged/unplugged %d times in %v",
+			change.Service, change.Zone, change.Address, len(track.changes), d.config.Window)
+		d.updateGauge(now)
+	}
+}
+
+func (d *flapDetector) updateGauge(now time.Time) {
+	n := 0
+	for _, track := range d.tracks {
+		if d.flapping(track, now) {
+			n++
+		}
+	}
+	metrics.FlappingEndpoints.Set(float64(n))
+}
+
+// sweep drop tracks of endpoints stable for both window and hold
+func (d *flapDetector) sweep(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, track := range d.tracks {
+		if !track.since.IsZero() && !d.flapping(track, now) {
+			logging.Infof("endpoint %s/%s/%s stopped flapping", key.service, key.zone, key.address)
+			track.since = time.Time{}
+		}
+		if now.Sub(track.last) >= d.config.Window && track.since.IsZero() {
+			delete(d.tracks, key)
+		}
+	}
+	d.updateGauge(now)
+}
+
+func (d *flapDetector) run(ctx context.Context) {
+	ticker := time.NewTicker(flapSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.sweep(now)
+		}
+	}
+}
+
+func (d *flapDetector) list(now time.Time) []FlappingEndpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := make([]FlappingEndpoint, 0)
+	for key, track := range d.tracks {
+		if !d.flapping(track, now) {
+			continue
+		}
+		changes := 0
+		for _, t := range track.changes {
+			if now.Sub(t) < d.config.Window {
+				changes++
+			}
+		}
+		result = append(result, FlappingEndpoint{Service: key.service, Zone: key.zone, Address: key.address,
+			Since: track.since, LastChange: track.last, Changes: changes})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Service != result[j].Service {
+			return result[i].Service < result[j].Service
+		}
+		if result[i].Zone != result[j].Zone {
+			return result[i].Zone < result[j].Zone
+		}
+		return result[i].Address < result[j].Address
+	})
+	return result
+}
+
+// FlappingEndpoints endpoints flagged as flapping, of service if not empty
+func (ctrl *ServiceCtrl) FlappingEndpoints(service string) []FlappingEndpoint {
+	if ctrl.flaps == nil {
+		return []FlappingEndpoint{}
+	}
+	endpoints := ctrl.flaps.list(time.Now())
+	if service == "" {
+		return endpoints
+	}
+	result := make([]FlappingEndpoint, 0)
+	for _, endpoint := range endpoints {
+		if endpoint.Service == service {
+			result = append(result, endpoint)
+		}
+	}
+	return result
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/logging"
+)
+
+// RetentionPolicy versions retention policy of matched services
+type RetentionPolicy struct {
+	Services     string        `yaml:"services"`
+	KeepVersions int           `yaml:"keep_versions"`
+	MaxIdle      time.Duration `yaml:"max_idle"`
+	servicesR    *regexp.Regexp
+}
+
+// GCConfig expired versions gc config
+type GCConfig struct {
+	Interval    time.Duration `default:"1h"`
+	NoticeAhead time.Duration `default:"24h" yaml:"notice_ahead"`
+	NotifyURL   string        `yaml:"notify_url"`
+	Policies    []RetentionPolicy
+}
+
+func (config *GCConfig) prepare() error {
+	for i := range config.Policies {
+		policy := &config.Policies[i]
+		r, err := regexp.Compile(policy.Services)
+		if err != nil {
+			return fmt.Errorf("invalid gc policy services: %s", policy.Services)
+		}
+		policy.servicesR = r
+	}
+	return nil
+}
+
+func (config *GCConfig) policyOf(name string) *RetentionPolicy {
+	for i := range config.Policies {
+		if config.Policies[i].servicesR.MatchString(name) {
+			return &config.Policies[i]
+		}
+	}
+	return nil
+}
+
+type gcState struct {
+	EmptySince time.Time `json:"empty_since"`
+	NotifiedAt time.Time `json:"notified_at,omitempty"`
+}
+
+// GCEvent gc notification
+type GCEvent struct {
+	Event       string    `json:"event"`
+	Service     string    `json:"service"`
+	Zone        string    `json:"zone"`
+	Reason      string    `json:"reason"`
+	DeleteAfter time.Time `json:"delete_after"`
+}
+
+func (ctrl *ServiceCtrl) gcStateKey(service, zone string) string {
+	return fmt.Sprintf("%s-gc/%s/%s", ctrl.config.KeyPrefix, service, zone)
+}
+
+// RunGC run expired versions gc until ctx done
+func (ctrl *ServiceCtrl) RunGC(ctx context.Context) {
+	if len(ctrl.config.GC.Policies) == 0 {
+		return
+	}
+	ticker := time.NewTicker(ctrl.config.GC.Interval)
+	defer ticker.Stop()
+	for {
+		if err := ctrl.gcOnce(ctx); err != nil {
+			logging.Errorf("gc services fail: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ctrl *ServiceCtrl) gcOnce(ctx context.Context) error {
+	items, err := ctrl.listServiceZoneDBItems()
+	if err != nil {
+		return fmt.Errorf("list db services fail: %v", err)
+	}
+	groups := make(map[string][]serviceZoneItem)
+	for _, item := range items {
+		name, _ := splitService(item.Service)
+		groupKey := name + "/" + item.Zone
+		groups[groupKey] = append(groups[groupKey], item)
+	}
+
+	now := time.Now()
+	for _, versions := range groups {
+		name, _ := splitService(versions[0].Service)
+		policy := ctrl.config.GC.policyOf(name)
+		if policy == nil {
+			continue
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			_, vi := splitService(versions[i].Service)
+			_, vj := splitService(versions[j].Service)
+			return compareVersion(vi, vj) > 0
+		})
+		for i, item := range versions {
+			if err := ctrl.gcVersion(ctx, policy, i, item, now); err != nil {
+				logging.Warningf("gc %s:%s fail: %v", item.Service, item.Zone, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (ctrl *ServiceCtrl) hasEndpoints(ctx context.Context, service, zone string) (bool, error) {
+	resp, err := ctrl.etcdClient.Get(ctx, ctrl.serviceEntryPrefix(service)+zone+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return false, err
+	}
+	for _, kv := range resp.Kvs {
+		if !ctrl.isServiceDescKey(string(kv.Key)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ctrl *ServiceCtrl) gcVersion(ctx context.Context, policy *RetentionPolicy, rank int, item serviceZoneItem, now time.Time) error {
+	stateKey := ctrl.gcStateKey(item.Service, item.Zone)
+	has, err := ctrl.hasEndpoints(ctx, item.Service, item.Zone)
+	if err != nil {
+		return err
+	}
+	if has {
+		_, err := ctrl.etcdClient.Delete(ctx, stateKey)
+		return err
+	}
+
+	var state gcState
+	resp, err := ctrl.etcdClient.Get(ctx, stateKey)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) > 0 {
+		if err := json.Unmarshal(resp.Kvs[0].Value, &state); err != nil {
+			logging.Warningf("invalid gc state(%s), reset: %v", stateKey, err)
+			state = gcState{}
+		}
+	}
+	if state.EmptySince.IsZero() {
+		state.EmptySince = now
+	}
+
+	var reason string
+	if policy.KeepVersions > 0 && rank >= policy.KeepVersions {
+		reason = fmt.Sprintf("exceeds %d newest versions", policy.KeepVersions)
+	} else if policy.MaxIdle > 0 && now.Sub(state.EmptySince) > policy.MaxIdle {
+		reason = fmt.Sprintf("no endpoints for %v", policy.MaxIdle)
+	}
+
+	if reason == "" {
+		state.NotifiedAt = time.Time{}
+	} else if state.NotifiedAt.IsZero() {
+		state.NotifiedAt = now
+		ctrl.notifyGC(&GCEvent{Event: "gc_pending", Service: item.Service, Zone: item.Zone,
+			Reason: reason, DeleteAfter: now.Add(ctrl.config.GC.NoticeAhead)})
+	} else if now.Sub(state.NotifiedAt) >= ctrl.config.GC.NoticeAhead {
+		if err := ctrl.Delete(ctx, item.Service, item.Zone); err != nil {
+			return err
+		}
+		logging.Infof("gc deleted %s:%s (%s)", item.Service, item.Zone, reason)
+		ctrl.notifyGC(&GCEvent{Event: "gc_deleted", Service: item.Service, Zone: item.Zone, Reason: reason})
+		_, err := ctrl.etcdClient.Delete(ctx, stateKey)
+		return err
+	}
+
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 || !bytes.Equal(resp.Kvs[0].Value, data) {
+		_, err = ctrl.etcdClient.Put(ctx, stateKey, string(data))
+	}
+	return err
+}
+
+func (ctrl *ServiceCtrl) notifyGC(event *GCEvent) {
+	logging.Infof("gc %s: %s:%s, %s", event.Event, event.Service, event.Zone, event.Reason)
+	if ctrl.config.GC.NotifyURL == "" {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		logging.Errorf("marshal gc event fail: %v", err)
+		return
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(ctrl.config.GC.NotifyURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		logging.Warningf("notify gc event fail: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
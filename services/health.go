@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// PingDB check the db connection
+func (ctrl *ServiceCtrl) PingDB(ctx context.Context) error {
+	return ctrl.db.PingContext(ctx)
+}
+
+// CheckEtcd check etcd is reachable by a cheap ranged get (one key, keys only) of services
+func (ctrl *ServiceCtrl) CheckEtcd(ctx context.Context) error {
+	_, err := ctrl.etcdClient.Get(ctx, ctrl.config.KeyPrefix+"/",
+		clientv3.WithPrefix(), clientv3.WithLimit(1), clientv3.WithKeysOnly())
+	return err
+}
+
+// CheckLeases check the lessor (on the etcd leader) answers, by time to live of no lease
+func (ctrl *ServiceCtrl) CheckLeases(ctx context.Context) error {
+	_, err := ctrl.etcdClient.TimeToLive(ctx, clientv3.NoLease)
+	return err
+}
+
+// CheckWatchHub check the watch hub isn't blocked, and if recent, it's not down: shared
+// watches broke and none is established again since
+func (ctrl *ServiceCtrl) CheckWatchHub(ctx context.Context, recent bool) error {
+	probe := ctrl.hub.probe()
+	select {
+	case <-probe.done:
+		if recent {
+			return probe.err
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("watch hub blocked")
+	}
+}
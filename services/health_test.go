@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckWatchHub(t *testing.T) {
+	ctrl := newTestCtrl(t, nil)
+	check := func(recent bool) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		return ctrl.CheckWatchHub(ctx, recent)
+	}
+	if err := check(true); err != nil {
+		t.Fatalf("idle hub: %v", err)
+	}
+
+	ctrl.hub.mu.Lock()
+	ctrl.hub.lastErr, ctrl.hub.lastErrTime = errors.New("etcd down"), time.Now().Add(-time.Hour)
+	ctrl.hub.mu.Unlock()
+	if err := check(true); err != nil {
+		t.Errorf("hub without shared watches: %v", err)
+	}
+	ctrl.hub.mu.Lock()
+	ctrl.hub.prefixes["/services/foo/"] = &prefixWatch{}
+	ctrl.hub.mu.Unlock()
+	if err := check(true); err == nil {
+		t.Error("hub broken an hour ago and not recovered")
+	}
+	if err := check(false); err != nil {
+		t.Errorf("liveness of a down hub: %v", err)
+	}
+	ctrl.hub.mu.Lock()
+	ctrl.hub.lastErr = nil
+	ctrl.hub.mu.Unlock()
+	if err := check(true); err != nil {
+		t.Errorf("recovered hub: %v", err)
+	}
+
+	ctrl.hub.mu.Lock()
+	if err := check(false); err == nil {
+		t.Error("blocked hub")
+	}
+	probe := ctrl.hub.inflight
+	for i := 0; i < 3; i++ {
+		check(false)
+	}
+	if ctrl.hub.inflight != probe {
+		t.Error("probes of a blocked hub not shared")
+	}
+	ctrl.hub.mu.Unlock()
+	<-probe.done
+	if err := check(false); err != nil {
+		t.Errorf("unblocked hub: %v", err)
+	}
+}
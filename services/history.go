@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/tracing"
+	"github.com/infrmods/xbus/utils"
+)
+
+// QueryAt query service as it was at revision, fails with REVISION_COMPACTED
+// if revision is compacted; aliases are not resolved
+func (ctrl *ServiceCtrl) QueryAt(ctx context.Context, clientIP net.IP, service string, revision int64) (*ServiceV1, error) {
+	if err := checkService(service); err != nil {
+		return nil, err
+	}
+	if revision <= 0 {
+		return nil, utils.NewError(utils.EcodeInvalidParam, "invalid revision")
+	}
+
+	defer metrics.ObserveSince(metrics.QueryDuration.WithLabelValues("query_at"), time.Now())
+	ctx, span := tracing.StartSpan(ctx, "services.QueryAt")
+	span.SetAttribute("service", service)
+	prefix := ctrl.serviceEntryPrefix(service)
+	etcdCtx, etcdSpan := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithRev(revision))
+	etcdSpan.FinishWithError(err)
+	switch {
+	case err == v3rpc.ErrCompacted:
+		err = utils.Errorf(utils.EcodeRevisionCompacted, "revision %d compacted", revision)
+	case err == v3rpc.ErrFutureRev:
+		err = utils.Errorf(utils.EcodeInvalidParam, "future revision %d", revision)
+	case err != nil:
+		err = utils.CleanErr(err, "query fail", "QueryAt(%s, %d) fail: %v", service, revision, err)
+	case len(resp.Kvs) == 0:
+		err = utils.Errorf(utils.EcodeNotFound, "no such service at revision %d: %s", revision, service)
+	}
+	if err != nil {
+		span.FinishWithError(err)
+		return nil, err
+	}
+	result, err := ctrl.makeService(clientIP, service, resp.Kvs)
+	span.FinishWithError(err)
+	return result, err
+}
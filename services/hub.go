@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/metrics"
+)
+
+// WatchHubConfig shared watch config
+type WatchHubConfig struct {
+	History     int           `default:"256"`
+	IdleTimeout time.Duration `default:"1m" yaml:"idle_timeout"`
+}
+
+var errHubWatchClosed = errors.New("watch closed")
+
+// watchHub multiplex watches of a prefix over one etcd watch
+type watchHub struct {
+	config   WatchHubConfig
+	client   *clientv3.Client
+	mu       sync.Mutex
+	prefixes map[string]*prefixWatch
+	// lastErr of shared watches broken by errors other than compaction,
+	// cleared once a shared watch is established again
+	lastErr     error
+	lastErrTime time.Time
+
+	probeMu  sync.Mutex
+	inflight *hubProbe
+}
+
+// hubProbe a check of the hub lock, done once got
+type hubProbe struct {
+	done chan struct{}
+	// err if down
+	err error
+}
+
+// probe check the hub in background, sharing the one in flight so that
+// probes of a blocked hub don't pile up
+func (hub *watchHub) probe() *hubProbe {
+	hub.probeMu.Lock()
+	defer hub.probeMu.Unlock()
+	if hub.inflight != nil {
+		return hub.inflight
+	}
+	probe := &hubProbe{done: make(chan struct{})}
+	hub.inflight = probe
+	go func() {
+		hub.mu.Lock()
+		if hub.lastErr != nil && len(hub.prefixes) > 0 {
+			probe.err = fmt.Errorf("watch broke at %s: %v", hub.lastErrTime.Format(time.RFC3339), hub.lastErr)
+		}
+		hub.mu.Unlock()
+		hub.probeMu.Lock()
+		hub.inflight = nil
+		hub.probeMu.Unlock()
+		close(probe.done)
+	}()
+	return probe
+}
+
+type hubWaiter struct {
+	revision int64
+	ch       chan clientv3.WatchResponse
+	retry    chan struct{}
+}
+
+// prefixWatch events of prefix since start, kept for History responses
+type prefixWatch struct {
+	prefix    string
+	start     int64
+	revision  int64
+	history   []clientv3.WatchResponse
+	waiters   map[*hubWaiter]struct{}
+	cancel    context.CancelFunc
+	idleTimer *time.Timer
+}
+
+func newWatchHub(config WatchHubConfig, client *clientv3.Client) *watchHub {
+	if config.History <= 0 {
+		config.History = 256
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = time.Minute
+	}
+	return &watchHub{config: config, client: client, prefixes: make(map[string]*prefixWatch)}
+}
+
+// Watch wait for events of prefix with mod revision >= revision,
+// ok is false if ctx done before events
+func (hub *watchHub) Watch(ctx context.Context, prefix string, revision int64) (clientv3.WatchResponse, bool) {
+	for {
+		resp, ok, retry := hub.wait(ctx, prefix, revision)
+		if !retry {
+			return resp, ok
+		}
+	}
+}
+
+func (hub *watchHub) wait(ctx context.Context, prefix string, revision int64) (clientv3.WatchResponse, bool, bool) {
+	hub.mu.Lock()
+	pw := hub.prefixes[prefix]
+	if pw == nil {
+		pw = hub.startPrefixWatch(prefix, revision)
+	}
+	if revision < pw.start {
+		// too old for the shared history
+		hub.mu.Unlock()
+		resp, ok := hub.watchDirect(ctx, prefix, revision)
+		return resp, ok, false
+	}
+	if resp, ok := pw.since(revision); ok {
+		hub.mu.Unlock()
+		return resp, true, false
+	}
+	w := &hubWaiter{revision: revision, ch: make(chan clientv3.WatchResponse, 1), retry: make(chan struct{})}
+	pw.addWaiter(w)
+	hub.mu.Unlock()
+
+	select {
+	case resp := <-w.ch:
+		return resp, true, false
+	case <-w.retry:
+		return clientv3.WatchResponse{}, false, true
+	case <-ctx.Done():
+		hub.mu.Lock()
+		hub.removeWaiter(pw, w)
+		hub.mu.Unlock()
+		// delivered concurrently
+		select {
+		case resp := <-w.ch:
+			return resp, true, false
+		default:
+		}
+		return clientv3.WatchResponse{}, false, false
+	}
+}
+
+// pending events of prefix in the shared history with mod revision >= revision, without waiting
+func (hub *watchHub) pending(prefix string, revision int64) (clientv3.WatchResponse, bool) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	pw := hub.prefixes[prefix]
+	if pw == nil || revision < pw.start {
+		return clientv3.WatchResponse{}, false
+	}
+	return pw.since(revision)
+}
+
+func (hub *watchHub) watchDirect(ctx context.Context, prefix string, revision int64) (clientv3.WatchResponse, bool) {
+	watcher := clientv3.NewWatcher(hub.client)
+	defer watcher.Close()
+	resp, ok := <-watcher.Watch(ctx, prefix, clientv3.WithRev(revision), clientv3.WithPrefix())
+	return resp, ok
+}
+
+// must be called with hub.mu held
+func (hub *watchHub) startPrefixWatch(prefix string, revision int64) *prefixWatch {
+	ctx, cancel := context.WithCancel(context.Background())
+	pw := &prefixWatch{
+		prefix:   prefix,
+		start:    revision,
+		revision: revision - 1,
+		waiters:  make(map[*hubWaiter]struct{}),
+		cancel:   cancel,
+	}
+	hub.prefixes[prefix] = pw
+	metrics.HubWatches.Inc()
+	watchCh := hub.client.Watch(ctx, prefix, clientv3.WithRev(revision), clientv3.WithPrefix(),
+		clientv3.WithCreatedNotify())
+	go hub.run(pw, watchCh)
+	return pw
+}
+
+func (hub *watchHub) run(pw *prefixWatch, watchCh clientv3.WatchChan) {
+	for {
+		resp, ok := <-watchCh
+		if !ok {
+			resp = clientv3.WatchResponse{Canceled: true}
+		}
+		hub.mu.Lock()
+		if hub.prefixes[pw.prefix] != pw {
+			// stopped
+			hub.mu.Unlock()
+			return
+		}
+		if !ok || resp.Err() != nil || resp.Canceled || resp.CompactRevision != 0 {
+			if resp.CompactRevision == 0 {
+				hub.lastErr, hub.lastErrTime = resp.Err(), time.Now()
+				if hub.lastErr == nil {
+					hub.lastErr = errHubWatchClosed
+				}
+			}
+			hub.stopPrefixWatch(pw)
+			for w := range pw.waiters {
+				delete(pw.waiters, w)
+				metrics.HubWaiters.Dec()
+				if resp.CompactRevision != 0 && w.revision > resp.CompactRevision {
+					// not compacted for this waiter, start over
+					close(w.retry)
+				} else {
+					w.ch <- resp
+				}
+			}
+			hub.mu.Unlock()
+			return
+		}
+		// established, or got events
+		hub.lastErr = nil
+		if resp.Created {
+			// its header revision is the current one, not of events delivered
+			hub.mu.Unlock()
+			continue
+		}
+		pw.append(resp, hub.config.History)
+		for w := range pw.waiters {
+			if r, ok := pw.since(w.revision); ok {
+				w.ch <- r
+				hub.removeWaiter(pw, w)
+			}
+		}
+		hub.mu.Unlock()
+	}
+}
+
+// must be called with hub.mu held
+func (hub *watchHub) stopPrefixWatch(pw *prefixWatch) {
+	if hub.prefixes[pw.prefix] != pw {
+		return
+	}
+	delete(hub.prefixes, pw.prefix)
+	metrics.HubWatches.Dec()
+	if pw.idleTimer != nil {
+		pw.idleTimer.Stop()
+	}
+	pw.cancel()
+}
+
+// must be called with hub.mu held
+func (hub *watchHub) removeWaiter(pw *prefixWatch, w *hubWaiter) {
+	if _, ok := pw.waiters[w]; !ok {
+		return
+	}
+	delete(pw.waiters, w)
+	metrics.HubWaiters.Dec()
+	if len(pw.waiters) == 0 {
+		pw.idleTimer = time.AfterFunc(hub.config.IdleTimeout, func() {
+			hub.mu.Lock()
+			defer hub.mu.Unlock()
+			if len(pw.waiters) == 0 {
+				hub.stopPrefixWatch(pw)
+			}
+		})
+	}
+}
+
+func (pw *prefixWatch) addWaiter(w *hubWaiter) {
+	if pw.idleTimer != nil {
+		pw.idleTimer.Stop()
+		pw.idleTimer = nil
+	}
+	pw.waiters[w] = struct{}{}
+	metrics.HubWaiters.Inc()
+}
+
+func (pw *prefixWatch) append(resp clientv3.WatchResponse, size int) {
+	if resp.Header.Revision > pw.revision {
+		pw.revision = resp.Header.Revision
+	}
+	if len(resp.Events) == 0 {
+		return
+	}
+	pw.history = append(pw.history, resp)
+	for len(pw.history) > size {
+		dropped := pw.history[0]
+		pw.history = pw.history[1:]
+		pw.start = dropped.Events[len(dropped.Events)-1].Kv.ModRevision + 1
+	}
+}
+
+// since events in history with mod revision >= revision
+func (pw *prefixWatch) since(revision int64) (clientv3.WatchResponse, bool) {
+	i := len(pw.history)
+	for i > 0 && pw.history[i-1].Events[len(pw.history[i-1].Events)-1].Kv.ModRevision >= revision {
+		i--
+	}
+	var events []*clientv3.Event
+	for _, resp := range pw.history[i:] {
+		for _, event := range resp.Events {
+			if event.Kv.ModRevision >= revision {
+				events = append(events, event)
+			}
+		}
+	}
+	if len(events) == 0 {
+		return clientv3.WatchResponse{}, false
+	}
+	resp := clientv3.WatchResponse{Events: events}
+	resp.Header.Revision = pw.revision
+	return resp, true
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+// InstanceConflict policy of plugging an instance id which is
+// already plugged with another address, e.g. restarted with a new ip
+type InstanceConflict string
+
+// instance conflict policies
+const (
+	// InstanceConflictReplace replace the old endpoints of the instance
+	InstanceConflictReplace InstanceConflict = "replace"
+	// InstanceConflictError fail with INSTANCE_CONFLICT
+	InstanceConflictError InstanceConflict = "error"
+)
+
+// ParseInstanceConflict parse instance conflict policy, replace if empty
+func ParseInstanceConflict(policy string) (InstanceConflict, error) {
+	switch InstanceConflict(policy) {
+	case "", InstanceConflictReplace:
+		return InstanceConflictReplace, nil
+	case InstanceConflictError:
+		return InstanceConflictError, nil
+	}
+	return "", utils.Errorf(utils.EcodeInvalidParam, "invalid instance conflict policy: %s", policy)
+}
+
+var rValidInstanceID = regexp.MustCompile(`(?i)^[a-z0-9:_.-]{1,128}$`)
+
+const maxPlugAttempts = 3
+
+// instanceReplaceOps deletes of the endpoints with the same instance id but other
+// addresses, guarded by cmps of their mod revisions
+func (ctrl *ServiceCtrl) instanceReplaceOps(ctx context.Context, descs []ServiceDescV1,
+	endpoint *ServiceEndpoint, onConflict InstanceConflict) ([]clientv3.Cmp, []clientv3.Op, error) {
+	if endpoint.InstanceID == "" {
+		return nil, nil, nil
+	}
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	for _, desc := range descs {
+		prefix := ctrl.serviceEntryPrefix(desc.Service) + desc.Zone + "/" + serviceKeyNodePrefix
+		nodeKey := ctrl.serviceNodeKey(desc.Service, desc.Zone, endpoint.Address)
+		etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+		resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix())
+		span.FinishWithError(err)
+		if err != nil {
+			return nil, nil, utils.CleanErr(err, "plug service fail", "get nodes(%s) fail: %v", prefix, err)
+		}
+		for _, kv := range resp.Kvs {
+			key := string(kv.Key)
+			if key == nodeKey {
+				continue
+			}
+			var old ServiceEndpoint
+			if err := json.Unmarshal(kv.Value, &old); err != nil || old.InstanceID != endpoint.InstanceID {
+				continue
+			}
+			if onConflict == InstanceConflictError {
+				return nil, nil, utils.Errorf(utils.EcodeInstanceConflict, "instance %s plugged with %s",
+					endpoint.InstanceID, strings.TrimPrefix(key, prefix))
+			}
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision))
+			ops = append(ops, clientv3.OpDelete(key))
+		}
+	}
+	return cmps, ops, nil
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema compiled subset of json schema (draft 7) for endpoint configs:
+// type, enum, const, properties, required, additionalProperties, items,
+// min/maxItems, min/maxLength, min/maxProperties, pattern, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, allOf, anyOf, oneOf and not;
+// descriptive keywords are ignored, others (like $ref) rejected
+type jsonSchema struct {
+	reject     bool
+	types      []string
+	enum       []interface{}
+	constValue *interface{}
+
+	properties    map[string]*jsonSchema
+	required      []string
+	additional    *jsonSchema
+	minProperties *int
+	maxProperties *int
+	items         *jsonSchema
+	minItems      *int
+	maxItems      *int
+	minLength     *int
+	maxLength     *int
+	pattern       *regexp.Regexp
+	minimum       *float64
+	maximum       *float64
+	exclusiveMin  *float64
+	exclusiveMax  *float64
+	allOf         []*jsonSchema
+	anyOf         []*jsonSchema
+	oneOf         []*jsonSchema
+	not           *jsonSchema
+}
+
+var jsonSchemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+var ignoredSchemaKeywords = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "format": true, "readOnly": true, "writeOnly": true,
+}
+
+func compileJSONSchema(data []byte) (*jsonSchema, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+	return compileSchemaValue("#", v)
+}
+
+func schemaInt(path, key string, v interface{}) (*int, error) {
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return nil, fmt.Errorf("%s/%s should be a non-negative integer", path, key)
+	}
+	n := int(f)
+	return &n, nil
+}
+
+func schemaNumber(path, key string, v interface{}) (*float64, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("%s/%s should be a number", path, key)
+	}
+	return &f, nil
+}
+
+func schemaList(path, key string, v interface{}) ([]*jsonSchema, error) {
+	items, ok := v.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("%s/%s should be a non-empty array", path, key)
+	}
+	schemas := make([]*jsonSchema, 0, len(items))
+	for i, item := range items {
+		s, err := compileSchemaValue(fmt.Sprintf("%s/%s/%d", path, key, i), item)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, nil
+}
+
+func compileSchemaValue(path string, v interface{}) (*jsonSchema, error) {
+	switch v := v.(type) {
+	case bool:
+		return &jsonSchema{reject: !v}, nil
+	case map[string]interface{}:
+		return compileSchemaObject(path, v)
+	default:
+		return nil, fmt.Errorf("%s should be an object or boolean", path)
+	}
+}
+
+func compileSchemaObject(path string, obj map[string]interface{}) (*jsonSchema, error) {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s := new(jsonSchema)
+	var err error
+	for _, key := range keys {
+		v := obj[key]
+		switch key {
+		case "type":
+			switch t := v.(type) {
+			case string:
+				s.types = []string{t}
+			case []interface{}:
+				for _, item := range t {
+					name, _ := item.(string)
+					s.types = append(s.types, name)
+				}
+			}
+			if len(s.types) == 0 {
+				return nil, fmt.Errorf("%s/type should be a type name or an array of them", path)
+			}
+			for _, t := range s.types {
+				if !jsonSchemaTypes[t] {
+					return nil, fmt.Errorf("%s/type: unknown type %q", path, t)
+				}
+			}
+		case "enum":
+			items, ok := v.([]interface{})
+			if !ok || len(items) == 0 {
+				return nil, fmt.Errorf("%s/enum should be a non-empty array", path)
+			}
+			s.enum = items
+		case "const":
+			value := v
+			s.constValue = &value
+		case "properties":
+			props, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s/properties should be an object", path)
+			}
+			s.properties = make(map[string]*jsonSchema, len(props))
+			for name, prop := range props {
+				if s.properties[name], err = compileSchemaValue(path+"/properties/"+name, prop); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			items, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s/required should be an array of strings", path)
+			}
+			for _, item := range items {
+				name, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s/required should be an array of strings", path)
+				}
+				s.required = append(s.required, name)
+			}
+		case "additionalProperties":
+			s.additional, err = compileSchemaValue(path+"/additionalProperties", v)
+		case "items":
+			s.items, err = compileSchemaValue(path+"/items", v)
+		case "minProperties":
+			s.minProperties, err = schemaInt(path, key, v)
+		case "maxProperties":
+			s.maxProperties, err = schemaInt(path, key, v)
+		case "minItems":
+			s.minItems, err = schemaInt(path, key, v)
+		case "maxItems":
+			s.maxItems, err = schemaInt(path, key, v)
+		case "minLength":
+			s.minLength, err = schemaInt(path, key, v)
+		case "maxLength":
+			s.maxLength, err = schemaInt(path, key, v)
+		case "pattern":
+			pattern, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/pattern should be a string", path)
+			}
+			if s.pattern, err = regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("%s/pattern: %v", path, err)
+			}
+		case "minimum":
+			s.minimum, err = schemaNumber(path, key, v)
+		case "maximum":
+			s.maximum, err = schemaNumber(path, key, v)
+		case "exclusiveMinimum":
+			s.exclusiveMin, err = schemaNumber(path, key, v)
+		case "exclusiveMaximum":
+			s.exclusiveMax, err = schemaNumber(path, key, v)
+		case "allOf":
+			s.allOf, err = schemaList(path, key, v)
+		case "anyOf":
+			s.anyOf, err = schemaList(path, key, v)
+		case "oneOf":
+			s.oneOf, err = schemaList(path, key, v)
+		case "not":
+			s.not, err = compileSchemaValue(path+"/not", v)
+		default:
+			if !ignoredSchemaKeywords[key] {
+				return nil, fmt.Errorf("%s: unsupported keyword %q", path, key)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (s *jsonSchema) matchType(v interface{}) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	actual := jsonTypeOf(v)
+	for _, t := range s.types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// validate check v (decoded by encoding/json) at path, the error tells the first mismatch
+func (s *jsonSchema) validate(path string, v interface{}) error {
+	if s.reject {
+		return fmt.Errorf("%s: not allowed", path)
+	}
+	if !s.matchType(v) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.types, " or "), jsonTypeOf(v))
+	}
+	if s.enum != nil {
+		found := false
+		for _, item := range s.enum {
+			if reflect.DeepEqual(item, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: not one of the enum values", path)
+		}
+	}
+	if s.constValue != nil && !reflect.DeepEqual(*s.constValue, v) {
+		return fmt.Errorf("%s: not the const value", path)
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if err := s.validateObject(path, v); err != nil {
+			return err
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return fmt.Errorf("%s: expected at least %d items, got %d", path, *s.minItems, len(v))
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return fmt.Errorf("%s: expected at most %d items, got %d", path, *s.maxItems, len(v))
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			return fmt.Errorf("%s: expected at least %d characters, got %d", path, *s.minLength, n)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return fmt.Errorf("%s: expected at most %d characters, got %d", path, *s.maxLength, n)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s: %q doesn't match pattern %s", path, v, s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			return fmt.Errorf("%s: %v is less than minimum %v", path, v, *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			return fmt.Errorf("%s: %v is greater than maximum %v", path, v, *s.maximum)
+		}
+		if s.exclusiveMin != nil && v <= *s.exclusiveMin {
+			return fmt.Errorf("%s: %v should be greater than %v", path, v, *s.exclusiveMin)
+		}
+		if s.exclusiveMax != nil && v >= *s.exclusiveMax {
+			return fmt.Errorf("%s: %v should be less than %v", path, v, *s.exclusiveMax)
+		}
+	}
+	for _, sub := range s.allOf {
+		if err := sub.validate(path, v); err != nil {
+			return err
+		}
+	}
+	if s.anyOf != nil {
+		var firstErr error
+		for _, sub := range s.anyOf {
+			if err := sub.validate(path, v); err == nil {
+				firstErr = nil
+				break
+			} else if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr != nil {
+			return fmt.Errorf("%s: matches none of anyOf, first: %v", path, firstErr)
+		}
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(path, v) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: expected to match exactly one of oneOf, matched %d", path, matched)
+		}
+	}
+	if s.not != nil && s.not.validate(path, v) == nil {
+		return fmt.Errorf("%s: should not match the not schema", path)
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateObject(path string, obj map[string]interface{}) error {
+	if s.minProperties != nil && len(obj) < *s.minProperties {
+		return fmt.Errorf("%s: expected at least %d properties, got %d", path, *s.minProperties, len(obj))
+	}
+	if s.maxProperties != nil && len(obj) > *s.maxProperties {
+		return fmt.Errorf("%s: expected at most %d properties, got %d", path, *s.maxProperties, len(obj))
+	}
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sub := s.properties[name]
+		if sub == nil {
+			sub = s.additional
+		}
+		if sub == nil {
+			continue
+		}
+		if sub.reject && s.properties[name] == nil {
+			return fmt.Errorf("%s: unexpected property %q", path, name)
+		}
+		if err := sub.validate(path+"."+name, obj[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -8,28 +8,45 @@ import (
 	"github.com/infrmods/xbus/utils"
 )
 
-var rValidName = regexp.MustCompile(`(?i)^[a-z][a-z0-9_.-]{5,}$`)
-var rValidService = regexp.MustCompile(`(?i)^[a-z][a-z0-9_.-]{5,}:[a-z0-9][a-z0-9_.-]*$`)
+var defaultNames = nameRegexps{
+	name:    regexp.MustCompile(`(?i)^[a-z][a-z0-9_.-]{5,}$`),
+	service: regexp.MustCompile(`(?i)^[a-z][a-z0-9_.-]{5,}:[a-z0-9][a-z0-9_.-]*$`),
+	version: regexp.MustCompile(`(?i)^[a-z0-9][a-z0-9_.-]*$`),
+}
 var rValidZone = regexp.MustCompile(`(?i)^[a-z0-9][a-z0-9_-]{3,}$`)
 var rValidExt = regexp.MustCompile(`(?i)^[a-z0-9][a-z0-9_-]{3,16}$`)
 
+// checkName check name by name rules, lenient of non-canonical names so that
+// existing ones can still be read and deleted in strict mode, see checkNewName
 func checkName(name string) error {
-	if !rValidName.MatchString(name) {
+	if !validNames().name.MatchString(name) {
 		return utils.NewError(utils.EcodeInvalidName, "")
 	}
 	return nil
 }
 
+// checkService check name:version by name rules, lenient like checkName, see checkNewService
 func checkService(service string) error {
-	if !rValidService.MatchString(service) {
+	if !validNames().service.MatchString(service) {
 		return utils.NewError(utils.EcodeInvalidService, "")
 	}
 	return nil
 }
 
+// ValidName whether name conforms to the running name rules, shared by names of configs,
+// secrets etc. so that they are validated the same as services
+func ValidName(name string) bool {
+	return validNames().name.MatchString(name)
+}
+
+// ValidService whether name:version conforms to the running name rules
+func ValidService(service string) bool {
+	return validNames().service.MatchString(service)
+}
+
 func checkServiceZone(service, zone string) error {
-	if !rValidService.MatchString(service) {
-		return utils.NewError(utils.EcodeInvalidService, "")
+	if err := checkService(service); err != nil {
+		return err
 	}
 	if !rValidZone.MatchString(zone) {
 		return utils.NewError(utils.EcodeInvalidZone, "")
@@ -46,7 +63,7 @@ func (ctrl *ServiceCtrl) checkAddress(addr string) error {
 	if !rValidAddress.MatchString(addr) {
 		return utils.NewError(utils.EcodeInvalidAddress, "")
 	}
-	if ctrl.config.isAddressBanned(addr) {
+	if ctrl.policies().isAddressBanned(addr) {
 		return utils.NewError(utils.EcodeInvalidAddress, "banned")
 	}
 	return nil
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/logging"
+)
+
+// kvCache values of keys under prefix, kept by a background watch started on first use,
+// for small prefixes read by every query such as maintenance and aliases
+type kvCache struct {
+	name       string
+	etcdClient *clientv3.Client
+	prefix     string
+	once       sync.Once
+
+	mu     sync.RWMutex
+	synced bool
+	values map[string][]byte
+}
+
+func newKVCache(name string, etcdClient *clientv3.Client, prefix string) *kvCache {
+	return &kvCache{name: name, etcdClient: etcdClient, prefix: prefix}
+}
+
+// get value of key, nil if not exists; false if not synced yet, the caller should get from etcd
+func (cache *kvCache) get(key string) ([]byte, bool) {
+	cache.once.Do(func() { go cache.run() })
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	if !cache.synced {
+		return nil, false
+	}
+	return cache.values[key], true
+}
+
+func (cache *kvCache) reset(synced bool, values map[string][]byte) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.synced = synced
+	cache.values = values
+}
+
+func (cache *kvCache) run() {
+	for {
+		if err := cache.watch(); err != nil {
+			logging.Warningf("%s cache watch fail, retry later: %v", cache.name, err)
+		}
+		cache.reset(false, nil)
+		time.Sleep(time.Second)
+	}
+}
+
+func (cache *kvCache) watch() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp, err := cache.etcdClient.Get(ctx, cache.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	values := make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		values[string(kv.Key)] = kv.Value
+	}
+	watchCh := cache.etcdClient.Watch(ctx, cache.prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	cache.reset(true, values)
+	for resp := range watchCh {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		cache.mu.Lock()
+		for _, event := range resp.Events {
+			if event.Type == mvccpb.DELETE {
+				delete(cache.values, string(event.Kv.Key))
+			} else {
+				cache.values[string(event.Kv.Key)] = event.Kv.Value
+			}
+		}
+		cache.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,412 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/tracing"
+	"github.com/infrmods/xbus/utils"
+)
+
+// LeaseNode service endpoint bound to a lease
+type LeaseNode struct {
+	Service string `json:"service"`
+	Zone    string `json:"zone"`
+	Address string `json:"address"`
+}
+
+// revokeIfUnused revoke lease if no keys are bound to it anymore,
+// e.g. after unplugging its last endpoint
+func (ctrl *ServiceCtrl) revokeIfUnused(ctx context.Context, leaseID clientv3.LeaseID) {
+	etcdCtx, span := startEtcdSpan(ctx, "TimeToLive", "")
+	resp, err := ctrl.etcdClient.TimeToLive(etcdCtx, leaseID, clientv3.WithAttachedKeys())
+	span.FinishWithError(err)
+	if err != nil {
+		if err != v3rpc.ErrLeaseNotFound {
+			logging.FromContext(ctx).Warningf("get lease(%d) fail: %v", leaseID, err)
+		}
+		return
+	}
+	if resp.TTL <= 0 || len(resp.Keys) > 0 {
+		return
+	}
+	etcdCtx, span = startEtcdSpan(ctx, "Revoke", "")
+	_, err = ctrl.etcdClient.Revoke(etcdCtx, leaseID)
+	span.FinishWithError(err)
+	if err != nil && err != v3rpc.ErrLeaseNotFound {
+		logging.FromContext(ctx).Warningf("revoke unused lease(%d) fail: %v", leaseID, err)
+	}
+}
+
+// LeaseNodes service endpoints bound to lease
+func (ctrl *ServiceCtrl) LeaseNodes(ctx context.Context, leaseID clientv3.LeaseID) ([]LeaseNode, error) {
+	etcdCtx, span := startEtcdSpan(ctx, "TimeToLive", "")
+	resp, err := ctrl.etcdClient.TimeToLive(etcdCtx, leaseID, clientv3.WithAttachedKeys())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get lease fail", "get lease(%d) fail: %v", leaseID, err)
+	}
+	if resp.TTL <= 0 {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no such lease: %d", leaseID)
+	}
+	prefix := ctrl.config.KeyPrefix + "/"
+	nodes := make([]LeaseNode, 0, len(resp.Keys))
+	for _, key := range resp.Keys {
+		parts := strings.SplitN(strings.TrimPrefix(string(key), prefix), "/", 3)
+		if !strings.HasPrefix(string(key), prefix) || len(parts) != 3 ||
+			!strings.HasPrefix(parts[2], serviceKeyNodePrefix) {
+			continue
+		}
+		nodes = append(nodes, LeaseNode{Service: parts[0], Zone: parts[1],
+			Address: strings.TrimPrefix(parts[2], serviceKeyNodePrefix)})
+	}
+	return nodes, nil
+}
+
+// UnplugByLease revoke lease, unplugging all endpoints and other keys bound to it
+func (ctrl *ServiceCtrl) UnplugByLease(ctx context.Context, leaseID clientv3.LeaseID) error {
+	if dryRun := dryRunOf(ctx); dryRun != nil {
+		lease, err := ctrl.Lease(ctx, leaseID)
+		if err != nil {
+			return err
+		}
+		ops := make([]clientv3.Op, 0, len(lease.Keys))
+		for _, key := range lease.Keys {
+			ops = append(ops, clientv3.OpDelete(key))
+		}
+		if _, err := ctrl.txn(ctx, nil, ops); err != nil {
+			return utils.CleanErr(err, "revoke fail", "dry run revoke(%d) fail: %v", leaseID, err)
+		}
+		dryRun.note("lease %d is revoked", leaseID)
+		return nil
+	}
+	ctx, span := tracing.StartSpan(ctx, "services.UnplugByLease")
+	etcdCtx, etcdSpan := startEtcdSpan(ctx, "Revoke", "")
+	_, err := ctrl.etcdClient.Revoke(etcdCtx, leaseID)
+	etcdSpan.FinishWithError(err)
+	if err != nil {
+		err = utils.CleanErr(err, "revoke fail", "revoke(%d) fail: %v", leaseID, err)
+	}
+	span.FinishWithError(err)
+	metrics.ServiceUnplugs.WithLabelValues(metrics.Result(err)).Inc()
+	return err
+}
+
+// UnplugAllResult unplugged endpoints and their leases
+type UnplugAllResult struct {
+	Unplugged []LeaseNode        `json:"unplugged"`
+	Leases    []clientv3.LeaseID `json:"leases"`
+	Revoked   []clientv3.LeaseID `json:"revoked"`
+}
+
+// ServiceLeases leases of endpoints of service, all zones if zone is empty
+func (ctrl *ServiceCtrl) ServiceLeases(ctx context.Context, service, zone string) ([]clientv3.LeaseID, error) {
+	prefix := ctrl.serviceEntryPrefix(service)
+	if zone != "" {
+		prefix += zone + "/"
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get service keys fail", "get service keys(%s) fail: %v", prefix, err)
+	}
+	seen := make(map[int64]bool)
+	var leases []clientv3.LeaseID
+	for _, kv := range resp.Kvs {
+		if kv.Lease != 0 && !seen[kv.Lease] {
+			seen[kv.Lease] = true
+			leases = append(leases, clientv3.LeaseID(kv.Lease))
+		}
+	}
+	return leases, nil
+}
+
+// UnplugAll unplug all endpoints of service in one txn, all zones if zone is empty,
+// e.g. decommissioning a version; leases of the endpoints are revoked if revokeLeases,
+// unplugging other keys bound to them, otherwise only the unused ones are revoked
+func (ctrl *ServiceCtrl) UnplugAll(ctx context.Context, service, zone string, revokeLeases bool) (*UnplugAllResult, error) {
+	if zone != "" {
+		if err := checkServiceZone(service, zone); err != nil {
+			return nil, err
+		}
+	} else if err := checkService(service); err != nil {
+		return nil, err
+	}
+	ctx, span := tracing.StartSpan(ctx, "services.UnplugAll")
+	span.SetAttribute("service", service)
+	result, err := ctrl.unplugAll(ctx, service, zone, revokeLeases)
+	span.FinishWithError(err)
+	metrics.ServiceUnplugs.WithLabelValues(metrics.Result(err)).Inc()
+	return result, err
+}
+
+func (ctrl *ServiceCtrl) unplugAll(ctx context.Context, service, zone string, revokeLeases bool) (*UnplugAllResult, error) {
+	prefix := ctrl.serviceEntryPrefix(service)
+	var zones []string
+	if zone != "" {
+		zones = []string{zone}
+	} else {
+		etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+		resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+		span.FinishWithError(err)
+		if err != nil {
+			return nil, utils.CleanErr(err, "get service keys fail", "get service keys(%s) fail: %v", prefix, err)
+		}
+		seen := make(map[string]bool)
+		for _, kv := range resp.Kvs {
+			parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 2)
+			if len(parts) == 2 && !seen[parts[0]] {
+				seen[parts[0]] = true
+				zones = append(zones, parts[0])
+			}
+		}
+	}
+	result := &UnplugAllResult{Unplugged: []LeaseNode{}, Leases: []clientv3.LeaseID{}, Revoked: []clientv3.LeaseID{}}
+	if len(zones) == 0 {
+		return result, nil
+	}
+
+	ops := make([]clientv3.Op, 0, len(zones))
+	for _, z := range zones {
+		ops = append(ops, clientv3.OpDelete(prefix+z+"/"+serviceKeyNodePrefix, clientv3.WithPrefix(), clientv3.WithPrevKV()))
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Txn", prefix)
+	resp, err := ctrl.etcdClient.Txn(etcdCtx).Then(ops...).Commit()
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "unplug service fail", "delete service nodes(%s) fail: %v", prefix, err)
+	}
+	seen := make(map[int64]bool)
+	var deleted []*mvccpb.KeyValue
+	for i, r := range resp.Responses {
+		deleted = append(deleted, r.GetResponseDeleteRange().PrevKvs...)
+		for _, kv := range r.GetResponseDeleteRange().PrevKvs {
+			addr := strings.TrimPrefix(string(kv.Key), prefix+zones[i]+"/"+serviceKeyNodePrefix)
+			result.Unplugged = append(result.Unplugged, LeaseNode{Service: service, Zone: zones[i], Address: addr})
+			if kv.Lease != 0 && !seen[kv.Lease] {
+				seen[kv.Lease] = true
+				result.Leases = append(result.Leases, clientv3.LeaseID(kv.Lease))
+			}
+		}
+	}
+	ctrl.writeTombstones(ctx, deleted)
+	for _, leaseID := range result.Leases {
+		if !revokeLeases {
+			if !ctrl.config.Tombstones.Enable {
+				ctrl.revokeIfUnused(ctx, leaseID)
+			}
+			continue
+		}
+		etcdCtx, span := startEtcdSpan(ctx, "Revoke", "")
+		_, err := ctrl.etcdClient.Revoke(etcdCtx, leaseID)
+		span.FinishWithError(err)
+		if err == nil {
+			result.Revoked = append(result.Revoked, leaseID)
+		} else if err != v3rpc.ErrLeaseNotFound {
+			logging.FromContext(ctx).Warningf("revoke lease(%d) fail: %v", leaseID, err)
+		}
+	}
+	logging.FromContext(ctx).Infof("unplugged all %d endpoints of %s, revoked %d leases",
+		len(result.Unplugged), prefix, len(result.Revoked))
+	return result, nil
+}
+
+// LeaseTTL remaining ttl of a lease bound to service endpoints
+type LeaseTTL struct {
+	LeaseID    clientv3.LeaseID `json:"lease_id"`
+	GrantedTTL int64            `json:"granted_ttl"`
+	TTL        int64            `json:"ttl"`
+	Nodes      []LeaseNode      `json:"nodes"`
+}
+
+// LeaseTTLs remaining ttls of leases bound to service endpoints, by ttl. Leases kept
+// alive never fall much below 2/3 of granted ttls, lower ones are likely not
+// refreshed anymore, e.g. keepalive path broken, and their endpoints vanish soon
+func (ctrl *ServiceCtrl) LeaseTTLs(ctx context.Context) ([]LeaseTTL, error) {
+	prefix := ctrl.config.KeyPrefix + "/"
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get leases fail", "get service keys fail: %v", err)
+	}
+	var leases []LeaseTTL
+	index := make(map[int64]int)
+	for _, kv := range resp.Kvs {
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 3)
+		if kv.Lease == 0 || len(parts) != 3 || !strings.HasPrefix(parts[2], serviceKeyNodePrefix) {
+			continue
+		}
+		i, ok := index[kv.Lease]
+		if !ok {
+			i = len(leases)
+			index[kv.Lease] = i
+			leases = append(leases, LeaseTTL{LeaseID: clientv3.LeaseID(kv.Lease)})
+		}
+		leases[i].Nodes = append(leases[i].Nodes, LeaseNode{Service: parts[0], Zone: parts[1],
+			Address: strings.TrimPrefix(parts[2], serviceKeyNodePrefix)})
+	}
+
+	result := leases[:0]
+	for _, lease := range leases {
+		etcdCtx, span := startEtcdSpan(ctx, "TimeToLive", "")
+		resp, err := ctrl.etcdClient.TimeToLive(etcdCtx, lease.LeaseID)
+		span.FinishWithError(err)
+		if err == v3rpc.ErrLeaseNotFound {
+			continue
+		} else if err != nil {
+			return nil, utils.CleanErr(err, "get leases fail", "get lease(%d) fail: %v", lease.LeaseID, err)
+		}
+		if resp.TTL <= 0 {
+			continue
+		}
+		lease.GrantedTTL, lease.TTL = resp.GrantedTTL, resp.TTL
+		result = append(result, lease)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].TTL < result[j].TTL })
+	return result, nil
+}
+
+// leaseTTLsMaxAge max age of lease ttls shared by CachedLeaseTTLs
+const leaseTTLsMaxAge = 2 * time.Second
+
+// leaseTTLCache lease ttls fetched at most once per leaseTTLsMaxAge, shared by all callers
+type leaseTTLCache struct {
+	mu     sync.Mutex
+	at     time.Time
+	leases []LeaseTTL
+}
+
+// CachedLeaseTTLs LeaseTTLs fetched at most once per leaseTTLsMaxAge by this server,
+// ttls are reduced by the time since fetched; for pollers, e.g. lease warning watchers
+func (ctrl *ServiceCtrl) CachedLeaseTTLs(ctx context.Context) ([]LeaseTTL, error) {
+	cache := &ctrl.leaseTTLs
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	now := time.Now()
+	if cache.leases == nil || now.Sub(cache.at) >= leaseTTLsMaxAge {
+		leases, err := ctrl.LeaseTTLs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if leases == nil {
+			leases = []LeaseTTL{}
+		}
+		cache.leases, cache.at = leases, now
+	}
+	elapsed := int64(now.Sub(cache.at) / time.Second)
+	result := make([]LeaseTTL, 0, len(cache.leases))
+	for _, lease := range cache.leases {
+		if lease.TTL -= elapsed; lease.TTL <= 0 {
+			continue
+		}
+		lease.Nodes = append([]LeaseNode(nil), lease.Nodes...)
+		result = append(result, lease)
+	}
+	return result, nil
+}
+
+// LeaseInfo a lease and all keys bound to it, not only service endpoints
+type LeaseInfo struct {
+	LeaseID    clientv3.LeaseID `json:"lease_id"`
+	GrantedTTL int64            `json:"granted_ttl"`
+	TTL        int64            `json:"ttl"`
+	Keys       []string         `json:"keys"`
+	Nodes      []LeaseNode      `json:"nodes"`
+}
+
+// Lease lease and keys bound to it
+func (ctrl *ServiceCtrl) Lease(ctx context.Context, leaseID clientv3.LeaseID) (*LeaseInfo, error) {
+	etcdCtx, span := startEtcdSpan(ctx, "TimeToLive", "")
+	resp, err := ctrl.etcdClient.TimeToLive(etcdCtx, leaseID, clientv3.WithAttachedKeys())
+	span.FinishWithError(err)
+	if err == v3rpc.ErrLeaseNotFound || (err == nil && resp.TTL <= 0) {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no such lease: %d", leaseID)
+	} else if err != nil {
+		return nil, utils.CleanErr(err, "get lease fail", "get lease(%d) fail: %v", leaseID, err)
+	}
+	info := &LeaseInfo{LeaseID: leaseID, GrantedTTL: resp.GrantedTTL, TTL: resp.TTL,
+		Keys: make([]string, 0, len(resp.Keys)), Nodes: make([]LeaseNode, 0)}
+	prefix := ctrl.config.KeyPrefix + "/"
+	for _, key := range resp.Keys {
+		info.Keys = append(info.Keys, string(key))
+		parts := strings.SplitN(strings.TrimPrefix(string(key), prefix), "/", 3)
+		if strings.HasPrefix(string(key), prefix) && len(parts) == 3 && strings.HasPrefix(parts[2], serviceKeyNodePrefix) {
+			info.Nodes = append(info.Nodes, LeaseNode{Service: parts[0], Zone: parts[1],
+				Address: strings.TrimPrefix(parts[2], serviceKeyNodePrefix)})
+		}
+	}
+	sort.Strings(info.Keys)
+	return info, nil
+}
+
+// Leases all active leases of the etcd cluster with their keys, by ttl,
+// including leases of app nodes and leases without keys
+func (ctrl *ServiceCtrl) Leases(ctx context.Context) ([]LeaseInfo, error) {
+	etcdCtx, span := startEtcdSpan(ctx, "Leases", "")
+	resp, err := ctrl.etcdClient.Leases(etcdCtx)
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "list leases fail", "list leases fail: %v", err)
+	}
+	leases := make([]LeaseInfo, 0, len(resp.Leases))
+	for _, lease := range resp.Leases {
+		info, err := ctrl.Lease(ctx, lease.ID)
+		if err != nil {
+			if errors.Is(err, utils.ErrNotFound) {
+				// expired meanwhile
+				continue
+			}
+			return nil, err
+		}
+		leases = append(leases, *info)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].TTL < leases[j].TTL })
+	return leases, nil
+}
+
+// KeyInfo an etcd key of a service
+type KeyInfo struct {
+	Key            string           `json:"key"`
+	Lease          clientv3.LeaseID `json:"lease"`
+	CreateRevision int64            `json:"create_revision"`
+	ModRevision    int64            `json:"mod_revision"`
+	Version        int64            `json:"version"`
+}
+
+// ServiceKeys etcd keys of service, all zones if zone is empty
+func (ctrl *ServiceCtrl) ServiceKeys(ctx context.Context, service, zone string) ([]KeyInfo, int64, error) {
+	if zone != "" {
+		if err := checkServiceZone(service, zone); err != nil {
+			return nil, 0, err
+		}
+	} else if err := checkService(service); err != nil {
+		return nil, 0, err
+	}
+	prefix := ctrl.serviceEntryPrefix(service)
+	if zone != "" {
+		prefix += zone + "/"
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, 0, utils.CleanErr(err, "get service keys fail", "get service keys(%s) fail: %v", prefix, err)
+	}
+	keys := make([]KeyInfo, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		keys = append(keys, KeyInfo{Key: string(kv.Key), Lease: clientv3.LeaseID(kv.Lease),
+			CreateRevision: kv.CreateRevision, ModRevision: kv.ModRevision, Version: kv.Version})
+	}
+	return keys, resp.Header.Revision, nil
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+// ServiceStatusMaintenance query status of services in maintenance
+const ServiceStatusMaintenance = "maintenance"
+
+// Maintenance planned downtime of a service version, while set queries carry it
+// and plugs of non-admin apps are rejected if BlockPlug
+type Maintenance struct {
+	Service   string    `json:"service"`
+	Message   string    `json:"message"`
+	BlockPlug bool      `json:"block_plug"`
+	Operator  string    `json:"operator"`
+	Since     time.Time `json:"since"`
+}
+
+const maxMaintenanceMessage = 1024
+
+func (ctrl *ServiceCtrl) maintenanceKeyPrefix() string {
+	return ctrl.config.KeyPrefix + "-maintenance/"
+}
+
+func (ctrl *ServiceCtrl) maintenanceKey(service string) string {
+	return ctrl.maintenanceKeyPrefix() + service
+}
+
+// SetMaintenance put service in maintenance, or update the message and block_plug of it
+func (ctrl *ServiceCtrl) SetMaintenance(ctx context.Context, maintenance *Maintenance) error {
+	if err := checkService(maintenance.Service); err != nil {
+		return err
+	}
+	if len(maintenance.Message) > maxMaintenanceMessage {
+		return utils.Errorf(utils.EcodeInvalidParam, "message too long, max: %d", maxMaintenanceMessage)
+	}
+	if maintenance.Since.IsZero() {
+		maintenance.Since = time.Now()
+	}
+	data, err := json.Marshal(maintenance)
+	if err != nil {
+		return utils.NewSystemError("marshal maintenance fail")
+	}
+	key := ctrl.maintenanceKey(maintenance.Service)
+	etcdCtx, span := startEtcdSpan(ctx, "Put", key)
+	_, err = ctrl.etcdClient.Put(etcdCtx, key, string(data))
+	span.FinishWithError(err)
+	if err != nil {
+		return utils.CleanErr(err, "set maintenance fail", "put maintenance(%s) fail: %v", key, err)
+	}
+	return nil
+}
+
+// MaintenanceOf maintenance of service, nil if not in maintenance; from the maintenance cache
+// unless ctx is WithConsistentQuery
+func (ctrl *ServiceCtrl) MaintenanceOf(ctx context.Context, service string) (*Maintenance, error) {
+	if !isConsistentQuery(ctx) {
+		if maintenance, ok := ctrl.cachedMaintenance(service); ok {
+			return maintenance, nil
+		}
+	}
+	return ctrl.MaintenanceAt(ctx, service, 0)
+}
+
+// MaintenanceAt maintenance of service as it was at revision, current if revision is 0
+func (ctrl *ServiceCtrl) MaintenanceAt(ctx context.Context, service string, revision int64) (*Maintenance, error) {
+	key := ctrl.maintenanceKey(service)
+	var opts []clientv3.OpOption
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Get", key)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, key, opts...)
+	span.FinishWithError(err)
+	switch {
+	case err == v3rpc.ErrCompacted:
+		return nil, utils.Errorf(utils.EcodeRevisionCompacted, "revision %d compacted", revision)
+	case err == v3rpc.ErrFutureRev:
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "future revision %d", revision)
+	case err != nil:
+		return nil, utils.CleanErr(err, "get maintenance fail", "get maintenance(%s) fail: %v", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	maintenance, err := decodeMaintenance(resp.Kvs[0].Value)
+	if err != nil {
+		logging.Errorf("invalid maintenance(%s): %v", key, err)
+		return nil, utils.NewSystemError("invalid maintenance")
+	}
+	return maintenance, nil
+}
+
+func decodeMaintenance(data []byte) (*Maintenance, error) {
+	var maintenance Maintenance
+	if err := json.Unmarshal(data, &maintenance); err != nil {
+		return nil, err
+	}
+	return &maintenance, nil
+}
+
+// cachedMaintenance maintenance of service from the maintenance cache, false if not synced yet
+func (ctrl *ServiceCtrl) cachedMaintenance(service string) (*Maintenance, bool) {
+	key := ctrl.maintenanceKey(service)
+	value, ok := ctrl.maintenances.get(key)
+	if !ok || value == nil {
+		return nil, ok
+	}
+	maintenance, err := decodeMaintenance(value)
+	if err != nil {
+		logging.Warningf("invalid maintenance(%s): %v", key, err)
+		return nil, true
+	}
+	return maintenance, true
+}
+
+// DeleteMaintenance end maintenance of service
+func (ctrl *ServiceCtrl) DeleteMaintenance(ctx context.Context, service string) error {
+	if err := checkService(service); err != nil {
+		return err
+	}
+	key := ctrl.maintenanceKey(service)
+	etcdCtx, span := startEtcdSpan(ctx, "Delete", key)
+	resp, err := ctrl.etcdClient.Delete(etcdCtx, key)
+	span.FinishWithError(err)
+	if err != nil {
+		return utils.CleanErr(err, "delete maintenance fail", "delete maintenance(%s) fail: %v", key, err)
+	}
+	if resp.Deleted == 0 {
+		return utils.Errorf(utils.EcodeNotFound, "%s not in maintenance", service)
+	}
+	return nil
+}
+
+// ListMaintenance services in maintenance
+func (ctrl *ServiceCtrl) ListMaintenance(ctx context.Context) ([]Maintenance, error) {
+	prefix := ctrl.maintenanceKeyPrefix()
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix,
+		clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "list maintenance fail", "get maintenance(%s) fail: %v", prefix, err)
+	}
+	result := make([]Maintenance, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		maintenance, err := decodeMaintenance(kv.Value)
+		if err != nil {
+			logging.Warningf("invalid maintenance(%s): %v", kv.Key, err)
+			continue
+		}
+		result = append(result, *maintenance)
+	}
+	return result, nil
+}
+
+// CheckMaintenance reject plugs of descs whose services are in maintenance with BlockPlug
+func (ctrl *ServiceCtrl) CheckMaintenance(ctx context.Context, descs []ServiceDescV1) error {
+	var blocked []string
+	var messages []string
+	for _, desc := range descs {
+		maintenance, err := ctrl.MaintenanceOf(ctx, desc.Service)
+		if err != nil {
+			return err
+		}
+		if maintenance != nil && maintenance.BlockPlug {
+			blocked = append(blocked, desc.Service)
+			if maintenance.Message != "" {
+				messages = append(messages, maintenance.Message)
+			}
+		}
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+	msg := strings.Join(blocked, ",") + " in maintenance"
+	if len(messages) > 0 {
+		msg += ": " + strings.Join(messages, "; ")
+	}
+	return &utils.Error{Code: utils.EcodeInMaintenance, Message: msg, Keys: blocked}
+}
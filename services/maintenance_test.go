@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/infrmods/xbus/utils"
+)
+
+func TestMaintenance(t *testing.T) {
+	ctrl, etcdClient, stop := newEtcdTestCtrl(t, nil)
+	defer stop()
+	ctx := context.Background()
+	service, zone := "payments.core:1.0", "default"
+	resp, err := etcdClient.Put(ctx, ctrl.serviceDescKey(service, zone), `{"type":"http"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := resp.Header.Revision
+
+	watched := make(chan error, 1)
+	go func() {
+		wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		_, _, err := ctrl.Watch(wctx, net.ParseIP("127.0.0.1"), service, before+1)
+		watched <- err
+	}()
+	if err := ctrl.SetMaintenance(ctx, &Maintenance{Service: service, BlockPlug: true}); err != nil {
+		t.Fatal(err)
+	}
+	// watches of the service are woken by maintenance changes
+	if err := <-watched; err != nil {
+		t.Fatalf("watch not woken by maintenance: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if m, ok := ctrl.cachedMaintenance(service); ok && m != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("maintenance cache not synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = ctrl.CheckMaintenance(ctx, []ServiceDescV1{{Service: service, Zone: zone}})
+	if errCode(err) != utils.EcodeInMaintenance {
+		t.Fatalf("plug into service in maintenance: %v", err)
+	}
+	if m, err := ctrl.MaintenanceAt(ctx, service, before); err != nil || m != nil {
+		t.Fatalf("maintenance before it was set: %v, %v", m, err)
+	}
+
+	if err := ctrl.DeleteMaintenance(ctx, service); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if m, ok := ctrl.cachedMaintenance(service); ok && m == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("maintenance end not cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := ctrl.CheckMaintenance(ctx, []ServiceDescV1{{Service: service, Zone: zone}}); err != nil {
+		t.Fatalf("plug after maintenance: %v", err)
+	}
+}
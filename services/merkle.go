@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+// registry state checksums, a merkle tree of
+//   root -> service -> zone -> node (raw key/value)
+// maintained incrementally from a watch of key_prefix,
+// strings are length prefixed in big endian so checksums
+// are stable across platforms and comparable between replicas
+
+type digest [sha256.Size]byte
+
+func (d digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+func writeField(h interface{ Write([]byte) (int, error) }, data []byte) {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	h.Write(size[:])
+	h.Write(data)
+}
+
+func leafDigest(key, value []byte) digest {
+	h := sha256.New()
+	writeField(h, key)
+	writeField(h, value)
+	var d digest
+	copy(d[:], h.Sum(nil))
+	return d
+}
+
+// namedDigest digest over sorted (name, digest) children
+func namedDigest(children map[string]digest) digest {
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		d := children[name]
+		writeField(h, []byte(name))
+		h.Write(d[:])
+	}
+	var d digest
+	copy(d[:], h.Sum(nil))
+	return d
+}
+
+type zoneNode struct {
+	leaves map[string]digest
+	digest digest
+}
+
+type serviceNode struct {
+	zones  map[string]*zoneNode
+	dirty  bool
+	digest digest
+}
+
+func (node *serviceNode) update() {
+	if !node.dirty {
+		return
+	}
+	children := make(map[string]digest, len(node.zones))
+	for name, zone := range node.zones {
+		zone.digest = namedDigest(zone.leaves)
+		children[name] = zone.digest
+	}
+	node.digest = namedDigest(children)
+	node.dirty = false
+}
+
+type checksumTree struct {
+	ctrl *ServiceCtrl
+	once sync.Once
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	synced   bool
+	revision int64
+	services map[string]*serviceNode
+}
+
+func newChecksumTree(ctrl *ServiceCtrl) *checksumTree {
+	tree := &checksumTree{ctrl: ctrl, services: make(map[string]*serviceNode)}
+	tree.cond = sync.NewCond(&tree.mu)
+	return tree
+}
+
+// splitNodeKey service, zone of key under key_prefix
+func (tree *checksumTree) splitNodeKey(key string) (string, string, bool) {
+	prefix := tree.ctrl.config.KeyPrefix + "/"
+	if !strings.HasPrefix(key, prefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(key[len(prefix):], "/", 3)
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func (tree *checksumTree) setLocked(key, value []byte, deleted bool) {
+	service, zone, ok := tree.splitNodeKey(string(key))
+	if !ok {
+		return
+	}
+	node := tree.services[service]
+	if node == nil {
+		if deleted {
+			return
+		}
+		node = &serviceNode{zones: make(map[string]*zoneNode)}
+		tree.services[service] = node
+	}
+	z := node.zones[zone]
+	if z == nil {
+		if deleted {
+			return
+		}
+		z = &zoneNode{leaves: make(map[string]digest)}
+		node.zones[zone] = z
+	}
+	if deleted {
+		delete(z.leaves, string(key))
+		if len(z.leaves) == 0 {
+			delete(node.zones, zone)
+		}
+		if len(node.zones) == 0 {
+			delete(tree.services, service)
+			return
+		}
+	} else {
+		z.leaves[string(key)] = leafDigest(key, value)
+	}
+	node.dirty = true
+}
+
+func (tree *checksumTree) reset(kvs []*mvccpb.KeyValue, revision int64, synced bool) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	tree.services = make(map[string]*serviceNode)
+	for _, kv := range kvs {
+		tree.setLocked(kv.Key, kv.Value, false)
+	}
+	tree.revision = revision
+	tree.synced = synced
+	tree.cond.Broadcast()
+}
+
+func (tree *checksumTree) apply(events []*clientv3.Event, revision int64) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	for _, event := range events {
+		tree.setLocked(event.Kv.Key, event.Kv.Value, event.Type == mvccpb.DELETE)
+	}
+	tree.revision = revision
+}
+
+func (tree *checksumTree) run() {
+	prefix := tree.ctrl.config.KeyPrefix + "/"
+	for {
+		if err := tree.watch(prefix); err != nil {
+			logging.Warningf("checksum tree watch fail, retry later: %v", err)
+		}
+		tree.reset(nil, 0, false)
+		time.Sleep(time.Second)
+	}
+}
+
+func (tree *checksumTree) watch(prefix string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp, err := tree.ctrl.etcdClient.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	watchCh := tree.ctrl.etcdClient.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	tree.reset(resp.Kvs, resp.Header.Revision, true)
+	for resp := range watchCh {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		tree.apply(resp.Events, resp.Header.Revision)
+	}
+	return nil
+}
+
+// waitSynced lock tree once synced, starts the watch on first use
+func (tree *checksumTree) waitSynced(ctx context.Context) error {
+	tree.once.Do(func() { go tree.run() })
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			tree.mu.Lock()
+			tree.cond.Broadcast()
+			tree.mu.Unlock()
+		case <-done:
+		}
+	}()
+	tree.mu.Lock()
+	for !tree.synced {
+		if err := ctx.Err(); err != nil {
+			tree.mu.Unlock()
+			return utils.CleanErr(err, "", "")
+		}
+		tree.cond.Wait()
+	}
+	return nil
+}
+
+// ChecksumResult registry state checksums at revision,
+// Root covers all visible services, not only the listed ones
+type ChecksumResult struct {
+	Revision int64             `json:"revision"`
+	Root     string            `json:"root"`
+	Services map[string]string `json:"services,omitempty"`
+}
+
+// ZoneChecksumResult zone checksums of a service at revision
+type ZoneChecksumResult struct {
+	Revision int64             `json:"revision"`
+	Service  string            `json:"service"`
+	Checksum string            `json:"checksum"`
+	Zones    map[string]string `json:"zones"`
+}
+
+// Checksums registry state checksums of services visible (all if nil), with checksums of
+// services prefixed by prefix if listServices, e.g. "" for all and "foo." for services of namespace foo
+func (ctrl *ServiceCtrl) Checksums(ctx context.Context, prefix string, listServices bool,
+	visible func(service string) bool) (*ChecksumResult, error) {
+	if err := ctrl.checksums.waitSynced(ctx); err != nil {
+		return nil, err
+	}
+	tree := ctrl.checksums
+	children := make(map[string]digest, len(tree.services))
+	for name, node := range tree.services {
+		node.update()
+		children[name] = node.digest
+	}
+	result := &ChecksumResult{Revision: tree.revision}
+	tree.mu.Unlock()
+
+	if visible != nil {
+		// perm checks may be slow, out of the lock
+		for name := range children {
+			if !visible(name) {
+				delete(children, name)
+			}
+		}
+	}
+	if listServices {
+		result.Services = make(map[string]string)
+		for name, d := range children {
+			if strings.HasPrefix(name, prefix) {
+				result.Services[name] = d.String()
+			}
+		}
+	}
+	result.Root = namedDigest(children).String()
+	return result, nil
+}
+
+// ServiceZoneChecksums zone checksums of service
+func (ctrl *ServiceCtrl) ServiceZoneChecksums(ctx context.Context, service string) (*ZoneChecksumResult, error) {
+	if err := checkService(service); err != nil {
+		return nil, err
+	}
+	if err := ctrl.checksums.waitSynced(ctx); err != nil {
+		return nil, err
+	}
+	tree := ctrl.checksums
+	defer tree.mu.Unlock()
+	node := tree.services[service]
+	if node == nil {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no such service: %s", service)
+	}
+	node.update()
+	result := &ZoneChecksumResult{Revision: tree.revision, Service: service,
+		Checksum: node.digest.String(), Zones: make(map[string]string, len(node.zones))}
+	for name, zone := range node.zones {
+		result.Zones[name] = zone.digest.String()
+	}
+	return result, nil
+}
+
+// ChecksumDiff services diverged from the compared checksums
+type ChecksumDiff struct {
+	Revision int64    `json:"revision"`
+	Root     string   `json:"root"`
+	Equal    bool     `json:"equal"`
+	Changed  []string `json:"changed,omitempty"`
+	Missing  []string `json:"missing,omitempty"`
+	Extra    []string `json:"extra,omitempty"`
+}
+
+// CompareChecksums compare local checksums of services prefixed by prefix with
+// the remote ones, e.g. from another replica: Missing are only remote, Extra only local;
+// services not visible (all are if nil) are ignored
+func (ctrl *ServiceCtrl) CompareChecksums(ctx context.Context, prefix string, remote map[string]string,
+	visible func(service string) bool) (*ChecksumDiff, error) {
+	local, err := ctrl.Checksums(ctx, prefix, true, visible)
+	if err != nil {
+		return nil, err
+	}
+	diff := &ChecksumDiff{Revision: local.Revision, Root: local.Root}
+	for name, checksum := range local.Services {
+		if r, ok := remote[name]; !ok {
+			diff.Extra = append(diff.Extra, name)
+		} else if r != checksum {
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range remote {
+		if _, ok := local.Services[name]; !ok && strings.HasPrefix(name, prefix) && (visible == nil || visible(name)) {
+			diff.Missing = append(diff.Missing, name)
+		}
+	}
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Missing)
+	sort.Strings(diff.Extra)
+	diff.Equal = len(diff.Changed) == 0 && len(diff.Missing) == 0 && len(diff.Extra) == 0
+	return diff, nil
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/gocomm/dbutil"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+// MetadataLink link of service metadata, e.g. docs, dashboards, repo
+type MetadataLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// ServiceMetadata catalog record of service name (all versions), stored in db apart from endpoints
+type ServiceMetadata struct {
+	Name        string         `json:"name"`
+	Owner       string         `json:"owner"`
+	Description string         `json:"description"`
+	Oncall      string         `json:"oncall"`
+	Links       []MetadataLink `json:"links"`
+	AppID       int64          `json:"modified_by"`
+	CreateTime  time.Time      `json:"create_time"`
+	ModifyTime  time.Time      `json:"modify_time"`
+}
+
+type dbServiceMetadata struct {
+	Name        string
+	Owner       string
+	Description string
+	Oncall      string
+	Links       string
+	AppID       int64
+	CreateTime  time.Time
+	ModifyTime  time.Time
+}
+
+func (item *dbServiceMetadata) metadata() ServiceMetadata {
+	metadata := ServiceMetadata{Name: item.Name, Owner: item.Owner, Description: item.Description,
+		Oncall: item.Oncall, AppID: item.AppID, CreateTime: item.CreateTime, ModifyTime: item.ModifyTime}
+	if err := json.Unmarshal([]byte(item.Links), &metadata.Links); err != nil {
+		logging.Warningf("invalid links of metadata(%s): %v", item.Name, err)
+	}
+	if metadata.Links == nil {
+		metadata.Links = []MetadataLink{}
+	}
+	return metadata
+}
+
+const metadataColumns = `name, owner, description, oncall, links, app_id, create_time, modify_time`
+
+const (
+	maxMetadataField       = 255
+	maxMetadataDescription = 4096
+	maxMetadataLinks       = 32
+)
+
+func checkMetadata(metadata *ServiceMetadata) error {
+	if err := checkNewName(metadata.Name); err != nil {
+		return err
+	}
+	for field, value := range map[string]string{"owner": metadata.Owner, "oncall": metadata.Oncall} {
+		if len(value) > maxMetadataField {
+			return utils.Errorf(utils.EcodeInvalidParam, "%s too long, max: %d", field, maxMetadataField)
+		}
+	}
+	if len(metadata.Description) > maxMetadataDescription {
+		return utils.Errorf(utils.EcodeInvalidParam, "description too long, max: %d", maxMetadataDescription)
+	}
+	if len(metadata.Links) > maxMetadataLinks {
+		return utils.Errorf(utils.EcodeInvalidParam, "too many links, max: %d", maxMetadataLinks)
+	}
+	for _, link := range metadata.Links {
+		if link.URL == "" {
+			return utils.NewError(utils.EcodeInvalidParam, "missing url of link")
+		}
+	}
+	return nil
+}
+
+// PutMetadata create or replace metadata of service name
+func (ctrl *ServiceCtrl) PutMetadata(metadata *ServiceMetadata) (*ServiceMetadata, error) {
+	if err := checkMetadata(metadata); err != nil {
+		return nil, err
+	}
+	links := metadata.Links
+	if links == nil {
+		links = []MetadataLink{}
+	}
+	linksData, err := json.Marshal(links)
+	if err != nil {
+		return nil, utils.NewSystemError("marshal links fail")
+	}
+	if _, err := ctrl.db.Exec(`insert into service_metadata(name, owner, description, oncall, links, app_id)
+                               values(?,?,?,?,?,?) on duplicate key update owner=values(owner),
+                               description=values(description), oncall=values(oncall),
+                               links=values(links), app_id=values(app_id)`,
+		metadata.Name, metadata.Owner, metadata.Description, metadata.Oncall,
+		string(linksData), metadata.AppID); err != nil {
+		logging.Errorf("put metadata(%s) fail: %v", metadata.Name, err)
+		return nil, utils.NewError(utils.EcodeSystemError, "put metadata fail")
+	}
+	return ctrl.GetMetadata(metadata.Name)
+}
+
+// GetMetadata metadata of service name
+func (ctrl *ServiceCtrl) GetMetadata(name string) (*ServiceMetadata, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	metadata, err := ctrl.getMetadata(name)
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no metadata of %s", name)
+	}
+	return metadata, nil
+}
+
+func (ctrl *ServiceCtrl) getMetadata(name string) (*ServiceMetadata, error) {
+	var item dbServiceMetadata
+	err := dbutil.Query(ctrl.db, &item, `select `+metadataColumns+` from service_metadata where name=?`, name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		logging.Errorf("query metadata(%s) fail: %v", name, err)
+		return nil, utils.NewError(utils.EcodeSystemError, "query metadata fail")
+	}
+	metadata := item.metadata()
+	return &metadata, nil
+}
+
+// ServiceMetadataOf metadata of the name of service (with version), nil if not set
+func (ctrl *ServiceCtrl) ServiceMetadataOf(service string) (*ServiceMetadata, error) {
+	name, _ := splitService(service)
+	return ctrl.getMetadata(name)
+}
+
+// DeleteMetadata delete metadata of service name
+func (ctrl *ServiceCtrl) DeleteMetadata(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	result, err := ctrl.db.Exec(`delete from service_metadata where name=?`, name)
+	if err != nil {
+		logging.Errorf("delete metadata(%s) fail: %v", name, err)
+		return utils.NewError(utils.EcodeSystemError, "delete metadata fail")
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return utils.Errorf(utils.EcodeNotFound, "no metadata of %s", name)
+	}
+	return nil
+}
+
+// ListMetadata metadata of service names, filtered by owner if not empty
+func (ctrl *ServiceCtrl) ListMetadata(owner string, skip, limit int64) ([]ServiceMetadata, error) {
+	items := make([]dbServiceMetadata, 0)
+	var err error
+	if owner != "" {
+		err = dbutil.Query(ctrl.db, &items, `select `+metadataColumns+` from service_metadata
+                where owner=? order by name limit ?,?`,
+			owner, skip, limit)
+	} else {
+		err = dbutil.Query(ctrl.db, &items, `select `+metadataColumns+` from service_metadata
+                order by name limit ?,?`, skip, limit)
+	}
+	if err != nil {
+		logging.Errorf("list metadata(%s) fail: %v", owner, err)
+		return nil, utils.NewError(utils.EcodeSystemError, "list metadata fail")
+	}
+	result := make([]ServiceMetadata, 0, len(items))
+	for i := range items {
+		result = append(result, items[i].metadata())
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+// ValueRewriter rewriter of desc and endpoint values under key prefix to the current schema,
+// unknown fields are dropped and service/zone of descs are taken from keys, other values are kept
+func ValueRewriter(prefix string) func(key string, value []byte) ([]byte, error) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	return func(key string, value []byte) ([]byte, error) {
+		if !strings.HasPrefix(key, prefix) {
+			return value, nil
+		}
+		return rewriteValue(strings.Split(key[len(prefix):], "/"), value)
+	}
+}
+
+func rewriteValue(parts []string, value []byte) ([]byte, error) {
+	if len(parts) != 3 {
+		return value, nil
+	}
+	service, zone, suffix := parts[0], parts[1], parts[2]
+	if suffix == serviceDescNodeKey {
+		var desc ServiceDescV1
+		if err := json.Unmarshal(value, &desc); err != nil {
+			return nil, err
+		}
+		desc.Service, desc.Zone = service, zone
+		return desc.Marshal()
+	} else if strings.HasPrefix(suffix, serviceKeyNodePrefix) {
+		var endpoint ServiceEndpoint
+		if err := json.Unmarshal(value, &endpoint); err != nil {
+			return nil, err
+		}
+		if endpoint.Address == "" {
+			endpoint.Address = suffix[len(serviceKeyNodePrefix):]
+		}
+		return endpoint.Marshal()
+	}
+	return value, nil
+}
+
+func (ctrl *ServiceCtrl) legacyEntryPrefix(name string) string {
+	return ctrl.config.LegacyKeyPrefix + "/" + name + "/"
+}
+
+// queryLegacy merge endpoints under LegacyKeyPrefix into result during prefix migrations,
+// those of the current prefix take precedence
+func (ctrl *ServiceCtrl) queryLegacy(ctx context.Context, clientIP net.IP, serviceKey string, result *ServiceV1) (*ServiceV1, error) {
+	prefix := ctrl.legacyEntryPrefix(serviceKey)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "query fail", "Query(%s) fail: %v", prefix, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return result, nil
+	}
+	legacy, err := ctrl.makeService(clientIP, serviceKey, resp.Kvs)
+	if err != nil {
+		logging.Warningf("ignore legacy %s: %v", prefix, err)
+		return result, nil
+	}
+	if result == nil {
+		return legacy, nil
+	}
+	mergeService(result, legacy)
+	return result, nil
+}
+
+// queryLegacyZones merge zones of service under LegacyKeyPrefix into zones during prefix migrations
+func (ctrl *ServiceCtrl) queryLegacyZones(ctx context.Context, service string, zones []string) ([]string, error) {
+	prefix := ctrl.legacyEntryPrefix(service)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "query fail", "Query(%s) fail: %v", prefix, err)
+	}
+	legacy, _ := ctrl.makeServiceWithRawZone(prefix, resp.Kvs)
+	seen := make(map[string]bool, len(zones))
+	for _, zone := range zones {
+		seen[zone] = true
+	}
+	for _, zone := range legacy {
+		if !seen[zone] {
+			seen[zone] = true
+			zones = append(zones, zone)
+		}
+	}
+	return zones, nil
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLegacyDualRead(t *testing.T) {
+	ctrl, etcdClient, stop := newEtcdTestCtrl(t, func(cfg *Config) {
+		cfg.LegacyKeyPrefix = "/old-services"
+	})
+	defer stop()
+	ctx := context.Background()
+	service := "payments.core:1.0"
+	legacy := ctrl.legacyEntryPrefix(service)
+	// only under the legacy prefix, not migrated yet
+	resp, err := etcdClient.Put(ctx, legacy+"backup/"+serviceDescNodeKey, `{"type":"http"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones, _, err := ctrl.QueryZones(ctx, nil, service)
+	if err != nil || len(zones.Zones) != 1 || zones.Zones[0] != "backup" {
+		t.Fatalf("zones of legacy service: %+v, %v", zones, err)
+	}
+
+	watched := make(chan error, 1)
+	go func() {
+		wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		_, _, err := ctrl.Watch(wctx, net.ParseIP("127.0.0.1"), service, resp.Header.Revision+1)
+		watched <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := etcdClient.Put(ctx, legacy+"backup/"+serviceKeyNodePrefix+"10.0.0.1:80",
+		`{"address":"10.0.0.1:80"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-watched; err != nil {
+		t.Fatalf("watch not woken by legacy change: %v", err)
+	}
+}
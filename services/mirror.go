@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/logging"
+)
+
+// MirrorConfig mirror of services with Prefixes, e.g. "payments.", from cluster From to
+// cluster To, by names of federation clusters, the local cluster if empty. Mirrored
+// endpoints are labeled with their origin cluster and bound to a lease of the mirror,
+// they vanish within TTL once the mirror stops
+type MirrorConfig struct {
+	Name     string        `yaml:"name"`
+	From     string        `yaml:"from"`
+	To       string        `yaml:"to"`
+	Prefixes []string      `yaml:"prefixes"`
+	TTL      time.Duration `default:"30s" yaml:"ttl"`
+}
+
+func prepareMirrors(mirrors []MirrorConfig, federation *FederationConfig) error {
+	clusters := make(map[string]bool)
+	for _, cluster := range federation.Clusters {
+		clusters[cluster.Name] = true
+	}
+	for i := range mirrors {
+		mirror := &mirrors[i]
+		if mirror.Name == "" {
+			mirror.Name = fmt.Sprintf("mirror-%d", i)
+		}
+		if mirror.From == mirror.To {
+			return fmt.Errorf("mirror(%s) from and to the same cluster", mirror.Name)
+		}
+		for _, cluster := range []string{mirror.From, mirror.To} {
+			if cluster != "" && !clusters[cluster] {
+				return fmt.Errorf("mirror(%s) has unknown cluster: %s", mirror.Name, cluster)
+			}
+		}
+		if len(mirror.Prefixes) == 0 {
+			return fmt.Errorf("mirror(%s) missing prefixes", mirror.Name)
+		}
+		if mirror.TTL <= 0 {
+			mirror.TTL = 30 * time.Second
+		}
+	}
+	return nil
+}
+
+const mirrorRetryInterval = 5 * time.Second
+
+// RunMirrors run configured mirrors until ctx done
+func (ctrl *ServiceCtrl) RunMirrors(ctx context.Context) {
+	for i := range ctrl.config.Mirrors {
+		mirror := &ctrl.config.Mirrors[i]
+		for _, prefix := range mirror.Prefixes {
+			go ctrl.runMirror(ctx, mirror, prefix)
+		}
+	}
+}
+
+func (ctrl *ServiceCtrl) clusterClient(name string) *clientv3.Client {
+	if name == "" {
+		return ctrl.etcdClient
+	}
+	for _, remote := range ctrl.remotes {
+		if remote.name == name {
+			return remote.etcdClient
+		}
+	}
+	return nil
+}
+
+func (ctrl *ServiceCtrl) runMirror(ctx context.Context, mirror *MirrorConfig, prefix string) {
+	from, to := ctrl.clusterClient(mirror.From), ctrl.clusterClient(mirror.To)
+	if from == nil || to == nil {
+		logging.Errorf("mirror(%s) missing client of %s or %s", mirror.Name, mirror.From, mirror.To)
+		return
+	}
+	origin := mirror.From
+	if origin == "" {
+		origin = ctrl.config.Federation.Name
+	}
+	for {
+		m := &mirrorSession{ctrl: ctrl, config: mirror, from: from, to: to, origin: origin,
+			prefix: ctrl.config.KeyPrefix + "/" + prefix, mirrored: make(map[string]int64)}
+		if err := m.run(ctx); err != nil {
+			logging.Warningf("mirror(%s) %s fail, retry: %v", mirror.Name, prefix, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(mirrorRetryInterval):
+		}
+	}
+}
+
+type mirrorSession struct {
+	ctrl     *ServiceCtrl
+	config   *MirrorConfig
+	from     *clientv3.Client
+	to       *clientv3.Client
+	origin   string
+	prefix   string
+	leaseID  clientv3.LeaseID
+	mirrored map[string]int64 // mirrored nodes to their mod revisions in the target
+}
+
+func (m *mirrorSession) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lease, err := m.to.Grant(ctx, int64(m.config.TTL.Seconds()))
+	if err != nil {
+		return fmt.Errorf("grant lease fail: %v", err)
+	}
+	m.leaseID = lease.ID
+	defer func() {
+		// unplug this session's endpoints now rather than after ttl
+		revokeCtx, cancel := context.WithTimeout(context.Background(), sharedGetTimeout)
+		defer cancel()
+		m.to.Revoke(revokeCtx, m.leaseID)
+	}()
+	keepAlive, err := m.to.KeepAlive(ctx, m.leaseID)
+	if err != nil {
+		return fmt.Errorf("keepalive lease fail: %v", err)
+	}
+
+	resp, err := m.from.Get(ctx, m.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return fmt.Errorf("get %s fail: %v", m.prefix, err)
+	}
+	for _, kv := range resp.Kvs {
+		if err := m.put(ctx, kv); err != nil {
+			return err
+		}
+	}
+	logging.Infof("mirror(%s) %s synced %d nodes, watching", m.config.Name, m.prefix, len(m.mirrored))
+
+	watchCh := m.from.Watch(ctx, m.prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-keepAlive:
+			if !ok {
+				return fmt.Errorf("lease(%d) expired", m.leaseID)
+			}
+		case watchResp, ok := <-watchCh:
+			if !ok {
+				return nil
+			}
+			if err := watchResp.Err(); err != nil {
+				return fmt.Errorf("watch %s fail: %v", m.prefix, err)
+			}
+			for _, event := range watchResp.Events {
+				if event.Type == mvccpb.DELETE {
+					err = m.delete(ctx, string(event.Kv.Key))
+				} else {
+					err = m.put(ctx, event.Kv)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// put mirror desc or node kv, descs are put permanently as plugging does, nodes
+// are put if not plugged in the target cluster, mirrored nodes aren't mirrored again
+func (m *mirrorSession) put(ctx context.Context, kv *mvccpb.KeyValue) error {
+	key := string(kv.Key)
+	parts := strings.Split(strings.TrimPrefix(key, m.ctrl.config.KeyPrefix+"/"), "/")
+	if len(parts) != 3 {
+		return nil
+	}
+	if parts[2] == serviceDescNodeKey {
+		var desc ServiceDescV1
+		if err := json.Unmarshal(kv.Value, &desc); err != nil {
+			logging.Warningf("mirror(%s) skip invalid desc(%s): %v", m.config.Name, key, err)
+			return nil
+		}
+		value := string(kv.Value)
+		_, err := m.to.Txn(ctx).If(clientv3.Compare(clientv3.Value(key), "=", value)).Else(
+			clientv3.OpPut(key, value),
+			clientv3.OpPut(m.ctrl.serviceDescNotifyKey(desc.Service, desc.Zone), value),
+		).Commit()
+		if err != nil {
+			return fmt.Errorf("put desc(%s) fail: %v", key, err)
+		}
+		if m.to == m.ctrl.etcdClient {
+			if err := m.ctrl.updateServiceDBItems([]ServiceDescV1{desc}); err != nil {
+				logging.Errorf("update service db items fail: %v", err)
+			}
+		}
+		return nil
+	}
+	if !strings.HasPrefix(parts[2], serviceKeyNodePrefix) {
+		return nil
+	}
+
+	var endpoint ServiceEndpoint
+	if err := json.Unmarshal(kv.Value, &endpoint); err != nil {
+		logging.Warningf("mirror(%s) skip invalid endpoint(%s): %v", m.config.Name, key, err)
+		return nil
+	}
+	if endpoint.Origin != "" {
+		return nil
+	}
+	endpoint.Origin = m.origin
+	data, err := endpoint.Marshal()
+	if err != nil {
+		return err
+	}
+	cmp := clientv3.Compare(clientv3.Version(key), "=", 0)
+	if rev, ok := m.mirrored[key]; ok {
+		cmp = clientv3.Compare(clientv3.ModRevision(key), "=", rev)
+	}
+	resp, err := m.to.Txn(ctx).If(cmp).Then(clientv3.OpPut(key, string(data), clientv3.WithLease(m.leaseID))).Commit()
+	if err != nil {
+		return fmt.Errorf("put node(%s) fail: %v", key, err)
+	}
+	if resp.Succeeded {
+		m.mirrored[key] = resp.Header.Revision
+	} else {
+		// plugged in the target cluster, or replaced since
+		delete(m.mirrored, key)
+	}
+	return nil
+}
+
+// delete unplug mirrored node
+func (m *mirrorSession) delete(ctx context.Context, key string) error {
+	rev, ok := m.mirrored[key]
+	if !ok {
+		return nil
+	}
+	delete(m.mirrored, key)
+	_, err := m.to.Txn(ctx).If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).Then(clientv3.OpDelete(key)).Commit()
+	if err != nil {
+		return fmt.Errorf("delete node(%s) fail: %v", key, err)
+	}
+	return nil
+}
@@ -7,7 +7,7 @@ import (
 	"strings"
 
 	"github.com/coreos/etcd/mvcc/mvccpb"
-	"github.com/golang/glog"
+	"github.com/infrmods/xbus/logging"
 	"github.com/infrmods/xbus/utils"
 )
 
@@ -18,7 +18,7 @@ func (ctrl *ServiceCtrl) makeServiceWithRawZone(serviceKey string, kvs []*mvccpb
 	for _, kv := range kvs {
 		matches := rServiceSplit.FindAllStringSubmatch(string(kv.Key), -1)
 		if len(matches) != 1 {
-			glog.Warningf("got unexpected service node: %s", string(kv.Key))
+			logging.Warningf("got unexpected service node: %s", string(kv.Key))
 			continue
 		}
 
@@ -40,7 +40,7 @@ func (ctrl *ServiceCtrl) makeService(clientIP net.IP, serviceKey string, kvs []*
 	for _, kv := range kvs {
 		matches := rServiceSplit.FindAllStringSubmatch(string(kv.Key), -1)
 		if len(matches) != 1 {
-			glog.Warningf("got unexpected service node: %s", string(kv.Key))
+			logging.Warningf("got unexpected service node: %s", string(kv.Key))
 			continue
 		}
 		_, zone, suffix := matches[0][1], matches[0][2], matches[0][3]
@@ -51,7 +51,7 @@ func (ctrl *ServiceCtrl) makeService(clientIP net.IP, serviceKey string, kvs []*
 		}
 		if suffix == serviceDescNodeKey {
 			if err := json.Unmarshal(kv.Value, &serviceZone.ServiceDescV1); err != nil {
-				glog.Errorf("invalid desc(%s), unmarshal fail: %v", string(kv.Key), string(kv.Value))
+				logging.Errorf("invalid desc(%s), unmarshal fail: %v", string(kv.Key), string(kv.Value))
 				return nil, utils.NewSystemError("service-data damanged")
 			}
 			serviceZone.Service = serviceKey
@@ -59,13 +59,15 @@ func (ctrl *ServiceCtrl) makeService(clientIP net.IP, serviceKey string, kvs []*
 		} else if strings.HasPrefix(suffix, serviceKeyNodePrefix) {
 			var endpoint ServiceEndpoint
 			if err := json.Unmarshal(kv.Value, &endpoint); err != nil {
-				glog.Errorf("unmarshal endpoint fail(%#v): %v", string(kv.Value), err)
+				logging.Errorf("unmarshal endpoint fail(%#v): %v", string(kv.Value), err)
 				return nil, utils.NewError(utils.EcodeDamagedEndpointValue, "")
 			}
-			endpoint.Address = ctrl.config.mapAddress(endpoint.Address, clientIP)
+			if endpoint.Sealed == nil {
+				endpoint.Address = ctrl.config.mapAddress(endpoint.Address, clientIP)
+			}
 			serviceZone.Endpoints = append(serviceZone.Endpoints, endpoint)
 		} else {
-			glog.Warningf("got unexpected service node: %s", string(kv.Key))
+			logging.Warningf("got unexpected service node: %s", string(kv.Key))
 		}
 	}
 	return &ServiceV1{Service: serviceKey, Zones: zones}, nil
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+const (
+	defaultNameCharset    = "a-z0-9_.-"
+	defaultVersionCharset = "a-z0-9_.-"
+)
+
+// NameRules validation rules of service names and versions, process wide as
+// names are checked everywhere; rules apply case insensitively
+type NameRules struct {
+	// MinLength min length of names, names start with a letter
+	MinLength int `default:"6" yaml:"min_length"`
+	// MaxLength max length of names, no limit if 0
+	MaxLength int `yaml:"max_length"`
+	// Charset regexp character class of the rest of names, without '[', ']', '\'
+	// and not matching '/', ':' or whitespace
+	Charset string `yaml:"charset"`
+	// VersionMaxLength max length of versions, no limit if 0
+	VersionMaxLength int `yaml:"version_max_length"`
+	// VersionCharset regexp character class of versions, which start with a letter or digit,
+	// restricted as Charset
+	VersionCharset string `yaml:"version_charset"`
+	// ReservedNames names and namespaces only created by admins, none by default
+	ReservedNames []string `yaml:"reserved_names"`
+	// ReservedPrefixes name prefixes only created by admins
+	ReservedPrefixes []string `yaml:"reserved_prefixes"`
+	// Strict names and versions should be canonical: lowercase, without leading,
+	// trailing or repeated separators(._-), see CanonicalName
+	Strict bool `yaml:"strict"`
+	// StrictCompat only log non-canonical names in strict mode, to find existing
+	// ones before enforcing, see ScanNames
+	StrictCompat bool `yaml:"strict_compat"`
+}
+
+func lengthQuantifier(min, max int) string {
+	if max <= 0 {
+		return fmt.Sprintf("{%d,}", min)
+	}
+	return fmt.Sprintf("{%d,%d}", min, max)
+}
+
+// nameRegexps regexps and strict mode compiled from NameRules
+type nameRegexps struct {
+	name         *regexp.Regexp
+	version      *regexp.Regexp
+	service      *regexp.Regexp
+	strict       bool
+	strictCompat bool
+}
+
+// currentNames *nameRegexps of the running name rules, replaced on reload
+var currentNames atomic.Value
+
+// validNames regexps of the running name rules, the default ones before any ServiceCtrl
+func validNames() *nameRegexps {
+	if names, ok := currentNames.Load().(*nameRegexps); ok {
+		return names
+	}
+	return &defaultNames
+}
+
+// compile rules into the name, version and service regexps
+func (rules *NameRules) compile() (*nameRegexps, error) {
+	minLength := rules.MinLength
+	if minLength < 1 {
+		minLength = 1
+	}
+	if rules.MaxLength > 0 && rules.MaxLength < minLength {
+		return nil, fmt.Errorf("invalid name rules: max_length < min_length")
+	}
+	charset := rules.Charset
+	if charset == "" {
+		charset = defaultNameCharset
+	}
+	versionCharset := rules.VersionCharset
+	if versionCharset == "" {
+		versionCharset = defaultVersionCharset
+	}
+	if err := checkCharset("name", charset); err != nil {
+		return nil, err
+	}
+	if err := checkCharset("version", versionCharset); err != nil {
+		return nil, err
+	}
+	maxLength := rules.MaxLength
+	if maxLength > 0 {
+		maxLength--
+	}
+	versionMaxLength := rules.VersionMaxLength
+	if versionMaxLength > 0 {
+		versionMaxLength--
+	}
+	name := `[a-z][` + charset + `]` + lengthQuantifier(minLength-1, maxLength)
+	version := `[a-z0-9][` + versionCharset + `]` + lengthQuantifier(0, versionMaxLength)
+	nameR, err := regexp.Compile(`(?i)^` + name + `$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name charset: %s", charset)
+	}
+	versionR, err := regexp.Compile(`(?i)^` + version + `$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version charset: %s", versionCharset)
+	}
+	return &nameRegexps{name: nameR, version: versionR,
+		service: regexp.MustCompile(`(?i)^` + name + `:` + version + `$`),
+		strict:  rules.Strict, strictCompat: rules.StrictCompat}, nil
+}
+
+// checkCharset charset should stay one character class without key separators,
+// names with '/' or ':' would break parsing keys and service:version
+func checkCharset(kind, charset string) error {
+	if strings.ContainsAny(charset, `[]\`) {
+		return fmt.Errorf("invalid %s charset: %s, '[', ']' and '\\' not allowed", kind, charset)
+	}
+	class, err := regexp.Compile(`(?i)^[` + charset + `]$`)
+	if err != nil {
+		return fmt.Errorf("invalid %s charset: %s", kind, charset)
+	}
+	for _, c := range "/:" {
+		if class.MatchString(string(c)) {
+			return fmt.Errorf("invalid %s charset: %s, matches %q", kind, charset, c)
+		}
+	}
+	for _, r := range unicode.White_Space.R16 {
+		for c := r.Lo; c <= r.Hi; c += r.Stride {
+			if class.MatchString(string(rune(c))) {
+				return fmt.Errorf("invalid %s charset: %s, matches whitespace %q", kind, charset, rune(c))
+			}
+		}
+	}
+	return nil
+}
+
+// compatWarned non-canonical names logged in compat mode, logged once
+var compatWarned sync.Map
+
+func isNameSep(c byte) bool {
+	return c == '.' || c == '_' || c == '-'
+}
+
+// CanonicalName canonical form of a name or version: lowercase, with leading
+// and trailing separators trimmed and repeated ones collapsed
+func CanonicalName(name string) string {
+	name = strings.ToLower(name)
+	buf := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		if isNameSep(name[i]) && (len(buf) == 0 || isNameSep(buf[len(buf)-1])) {
+			continue
+		}
+		buf = append(buf, name[i])
+	}
+	for len(buf) > 0 && isNameSep(buf[len(buf)-1]) {
+		buf = buf[:len(buf)-1]
+	}
+	return string(buf)
+}
+
+// checkStrict check value is canonical, in strict mode, part names it in errors
+func checkStrict(names *nameRegexps, code, part, value, canonical string) error {
+	if canonical == value {
+		return nil
+	}
+	if names.strictCompat {
+		if _, warned := compatWarned.LoadOrStore(value, true); !warned {
+			logging.Warningf("non-canonical %s: %q, canonical: %q", part, value, canonical)
+		}
+		return nil
+	}
+	return utils.Errorf(code, "non-canonical %s %q, use %q", part, value, canonical)
+}
+
+// checkNewName check name being created (metadata, aliases), canonical in strict mode
+func checkNewName(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	names := validNames()
+	if !names.strict {
+		return nil
+	}
+	return checkStrict(names, utils.EcodeInvalidName, "name", name, CanonicalName(name))
+}
+
+// checkNewService check name:version being created by plugs, canonical in strict mode
+func checkNewService(service string) error {
+	if err := checkService(service); err != nil {
+		return err
+	}
+	names := validNames()
+	if !names.strict {
+		return nil
+	}
+	return checkStrict(names, utils.EcodeInvalidService, "service", service, canonicalService(service))
+}
+
+// canonicalService canonical form of name:version
+func canonicalService(service string) string {
+	name, version := splitService(service)
+	return CanonicalName(name) + ":" + CanonicalName(version)
+}
+
+// isReserved whether name, or its namespace, is reserved
+func (rules *NameRules) isReserved(name string) bool {
+	name = strings.ToLower(name)
+	namespace := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		namespace = name[:i]
+	}
+	for _, reserved := range rules.ReservedNames {
+		if reserved = strings.ToLower(reserved); name == reserved || namespace == reserved {
+			return true
+		}
+	}
+	for _, prefix := range rules.ReservedPrefixes {
+		if strings.HasPrefix(name, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckReservedNames reject names being created (services of plugs, metadata, client policies,
+// configs, schemas etc.) which are reserved, for non-admin apps; names may be name:version
+func (ctrl *ServiceCtrl) CheckReservedNames(names ...string) error {
+	var reserved []string
+	for _, name := range names {
+		if n, _ := splitService(name); ctrl.policies().NameRules.isReserved(n) {
+			reserved = append(reserved, name)
+		}
+	}
+	if len(reserved) == 0 {
+		return nil
+	}
+	return &utils.Error{Code: utils.EcodeInvalidName,
+		Message: "reserved name: " + strings.Join(reserved, ","), Keys: reserved}
+}
+
+// NameIssue a service name in the registry not conforming to strict name rules
+type NameIssue struct {
+	Service   string `json:"service"`
+	Canonical string `json:"canonical"`
+	// Invalid fails name_rules besides being non-canonical
+	Invalid bool `json:"invalid,omitempty"`
+	// Conflicts other services of the same canonical name
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// ScanNames services in the registry whose names or versions are not canonical or invalid
+func (ctrl *ServiceCtrl) ScanNames(ctx context.Context) ([]NameIssue, error) {
+	prefix := ctrl.config.KeyPrefix + "/"
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "scan names fail", "get service keys fail: %v", err)
+	}
+	byCanonical := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, kv := range resp.Kvs {
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 2)
+		if len(parts) != 2 || seen[parts[0]] {
+			continue
+		}
+		service := parts[0]
+		seen[service] = true
+		canonical := canonicalService(service)
+		byCanonical[canonical] = append(byCanonical[canonical], service)
+	}
+	names := validNames()
+	issues := make([]NameIssue, 0)
+	for canonical, services := range byCanonical {
+		for _, service := range services {
+			invalid := !names.service.MatchString(service)
+			if service == canonical && !invalid {
+				continue
+			}
+			issue := NameIssue{Service: service, Canonical: canonical, Invalid: invalid}
+			for _, other := range services {
+				if other != service {
+					issue.Conflicts = append(issue.Conflicts, other)
+				}
+			}
+			issues = append(issues, issue)
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Service < issues[j].Service })
+	return issues, nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/infrmods/xbus/utils"
+)
+
+func TestCanonicalName(t *testing.T) {
+	for name, canonical := range map[string]string{
+		"payments.core":   "payments.core",
+		"Payments..Core":  "payments.core",
+		"-payments_core.": "payments_core",
+		"pay-_-ments":     "pay-ments",
+	} {
+		if got := CanonicalName(name); got != canonical {
+			t.Errorf("CanonicalName(%q): %q, expected: %q", name, got, canonical)
+		}
+	}
+}
+
+func TestNameRulesAnchored(t *testing.T) {
+	newTestCtrl(t, nil)
+	// the whole name should match, not any part of it
+	for _, service := range []string{"payments.core:1.0/zone", "/payments.core:1.0", "payments.core:1.0 x", "pay:1.0"} {
+		if errCode(checkService(service)) != utils.EcodeInvalidService {
+			t.Errorf("invalid service %q passed", service)
+		}
+	}
+	if err := checkService("payments.core:1.0"); err != nil {
+		t.Errorf("valid service: %v", err)
+	}
+}
+
+func TestStrictNames(t *testing.T) {
+	newTestCtrl(t, func(cfg *Config) { cfg.NameRules.Strict = true })
+	defer newTestCtrl(t, nil)
+	service := "Payments..Core:1.0"
+
+	// created names should be canonical
+	desc := ServiceDescV1{Service: service, Zone: DefaultZone, Type: "http"}
+	if errCode(checkDesc(&desc)) != utils.EcodeInvalidService {
+		t.Fatalf("non-canonical service plugged")
+	}
+	if errCode(checkMetadata(&ServiceMetadata{Name: "Payments..Core"})) != utils.EcodeInvalidName {
+		t.Fatalf("metadata of non-canonical name put")
+	}
+	desc.Service = "payments.core:1.0"
+	if err := checkDesc(&desc); err != nil {
+		t.Fatalf("canonical service: %v", err)
+	}
+
+	// existing names are still read and deleted
+	if err := checkService(service); err != nil {
+		t.Fatalf("non-canonical service not readable: %v", err)
+	}
+	if err := checkServiceZone(service, DefaultZone); err != nil {
+		t.Fatalf("non-canonical service not deletable: %v", err)
+	}
+	if err := checkName("Payments..Core"); err != nil {
+		t.Fatalf("non-canonical name not readable: %v", err)
+	}
+}
+
+func TestStrictCompatNames(t *testing.T) {
+	newTestCtrl(t, func(cfg *Config) {
+		cfg.NameRules.Strict = true
+		cfg.NameRules.StrictCompat = true
+	})
+	defer newTestCtrl(t, nil)
+	desc := ServiceDescV1{Service: "Payments..Core:1.0", Zone: DefaultZone, Type: "http"}
+	if err := checkDesc(&desc); err != nil {
+		t.Fatalf("non-canonical service rejected in compat mode: %v", err)
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	ctrl := newTestCtrl(t, nil)
+	if err := ctrl.CheckReservedNames("xbus.server:v1", "admin.tools"); err != nil {
+		t.Fatalf("names reserved by default: %v", err)
+	}
+
+	ctrl = newTestCtrl(t, func(cfg *Config) {
+		cfg.NameRules.ReservedNames = []string{"xbus"}
+		cfg.NameRules.ReservedPrefixes = []string{"internal-"}
+	})
+	err := ctrl.CheckReservedNames("xbus.server:v1", "payments.core:1.0", "Internal-billing", "xbusters.app")
+	if errCode(err) != utils.EcodeInvalidName {
+		t.Fatalf("reserved names: %v", err)
+	}
+	keys := err.(*utils.Error).Keys
+	if len(keys) != 2 || keys[0] != "xbus.server:v1" || keys[1] != "Internal-billing" {
+		t.Fatalf("reserved: %v", keys)
+	}
+}
+
+func TestNameRulesCharset(t *testing.T) {
+	for _, c := range []struct {
+		charset, versionCharset string
+		valid                   bool
+	}{
+		{"", "", true},
+		{"a-z0-9_.-", "a-z0-9_.+-", true},
+		{"a-z]|.*[", "", false},
+		{"a-z[:alpha:]", "", false},
+		{`a-z\d`, "", false},
+		{"a-z/", "", false},
+		{"a-z0-9:", "", false},
+		{"+-;", "", false},
+		{"^a-z", "", false},
+		{"a-z ", "", false},
+		{"a-z ", "", false},
+		{"", "0-9:", false},
+		{"", "a-z]|.*[", false},
+	} {
+		rules := NameRules{MinLength: 6, Charset: c.charset, VersionCharset: c.versionCharset}
+		if _, err := rules.compile(); (err == nil) != c.valid {
+			t.Errorf("charset %q, version charset %q: %v", c.charset, c.versionCharset, err)
+		}
+	}
+}
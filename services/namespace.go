@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+// NamespaceConfig namespace (tenant) config, a namespace is the first label of service
+// names, e.g. payments of payments.core:1.0, so it's the leading part of the keys too.
+// Services of the namespace are only accessible to Apps if any, Max* are quotas, 0 is unlimited
+type NamespaceConfig struct {
+	Name         string   `yaml:"name"`
+	Apps         []string `yaml:"apps"`
+	MaxServices  int      `yaml:"max_services"`
+	MaxEndpoints int      `yaml:"max_endpoints"`
+}
+
+var rValidNamespace = regexp.MustCompile(`(?i)^[a-z][a-z0-9_-]*$`)
+
+func prepareNamespaces(namespaces []NamespaceConfig) error {
+	names := make(map[string]bool)
+	for _, ns := range namespaces {
+		if !rValidNamespace.MatchString(ns.Name) {
+			return fmt.Errorf("invalid namespace: %s", ns.Name)
+		}
+		if names[ns.Name] {
+			return fmt.Errorf("duplicate namespace: %s", ns.Name)
+		}
+		names[ns.Name] = true
+	}
+	return nil
+}
+
+// NamespaceOf namespace name of service
+func NamespaceOf(service string) string {
+	if i := strings.IndexAny(service, ".:"); i >= 0 {
+		return service[:i]
+	}
+	return service
+}
+
+// Namespace config of namespace, nil if not configured
+func (ctrl *ServiceCtrl) Namespace(name string) *NamespaceConfig {
+	for i := range ctrl.config.Namespaces {
+		if ctrl.config.Namespaces[i].Name == name {
+			return &ctrl.config.Namespaces[i]
+		}
+	}
+	return nil
+}
+
+// IsNamespaceMember whether app can access services of namespace of service,
+// always true for unconfigured namespaces and namespaces without apps
+func (ctrl *ServiceCtrl) IsNamespaceMember(service, app string) bool {
+	ns := ctrl.Namespace(NamespaceOf(service))
+	if ns == nil || len(ns.Apps) == 0 {
+		return true
+	}
+	for _, member := range ns.Apps {
+		if member == app {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNamespaceIsolated whether namespace of service is only accessible to its apps,
+// its services are never public
+func (ctrl *ServiceCtrl) IsNamespaceIsolated(service string) bool {
+	ns := ctrl.Namespace(NamespaceOf(service))
+	return ns != nil && len(ns.Apps) > 0
+}
+
+// NamespaceUsage usage and quotas of namespace
+type NamespaceUsage struct {
+	Name         string   `json:"name"`
+	Apps         []string `json:"apps"`
+	MaxServices  int      `json:"max_services"`
+	MaxEndpoints int      `json:"max_endpoints"`
+	Services     int      `json:"services"`
+	Endpoints    int      `json:"endpoints"`
+}
+
+// namespaceNodes endpoint node keys of namespace by service
+func (ctrl *ServiceCtrl) namespaceNodes(ctx context.Context, name string) (map[string][]string, error) {
+	prefix := ctrl.config.KeyPrefix + "/" + name
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get namespace fail", "get namespace(%s) keys fail: %v", name, err)
+	}
+	nodes := make(map[string][]string)
+	for _, kv := range resp.Kvs {
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), ctrl.config.KeyPrefix+"/"), "/", 3)
+		if len(parts) != 3 || NamespaceOf(parts[0]) != name || !strings.HasPrefix(parts[2], serviceKeyNodePrefix) {
+			continue
+		}
+		nodes[parts[0]] = append(nodes[parts[0]], string(kv.Key))
+	}
+	return nodes, nil
+}
+
+// NamespaceUsage usage of namespace, services are the ones with endpoints
+func (ctrl *ServiceCtrl) NamespaceUsage(ctx context.Context, name string) (*NamespaceUsage, error) {
+	if !rValidNamespace.MatchString(name) {
+		return nil, utils.Errorf(utils.EcodeInvalidName, "invalid namespace: %s", name)
+	}
+	nodes, err := ctrl.namespaceNodes(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	usage := NamespaceUsage{Name: name, Apps: []string{}, Services: len(nodes)}
+	if ns := ctrl.Namespace(name); ns != nil {
+		if ns.Apps != nil {
+			usage.Apps = ns.Apps
+		}
+		usage.MaxServices, usage.MaxEndpoints = ns.MaxServices, ns.MaxEndpoints
+	}
+	for _, keys := range nodes {
+		usage.Endpoints += len(keys)
+	}
+	return &usage, nil
+}
+
+// checkNamespaceQuotas check plugging endpoint into descs won't exceed namespace quotas
+func (ctrl *ServiceCtrl) checkNamespaceQuotas(ctx context.Context, descs []ServiceDescV1, endpoint *ServiceEndpoint) error {
+	checked := make(map[string]bool)
+	for _, desc := range descs {
+		name := NamespaceOf(desc.Service)
+		ns := ctrl.Namespace(name)
+		if checked[name] || ns == nil || (ns.MaxServices <= 0 && ns.MaxEndpoints <= 0) {
+			continue
+		}
+		checked[name] = true
+		nodes, err := ctrl.namespaceNodes(ctx, name)
+		if err != nil {
+			return err
+		}
+		plugged := make(map[string]bool)
+		endpoints := 0
+		for _, keys := range nodes {
+			for _, key := range keys {
+				plugged[key] = true
+			}
+			endpoints += len(keys)
+		}
+		services := len(nodes)
+		// only growing is rejected, e.g. namespaces over lowered quotas still replug
+		var newServices, newEndpoints int
+		for _, d := range descs {
+			if NamespaceOf(d.Service) != name {
+				continue
+			}
+			if key := ctrl.serviceNodeKey(d.Service, d.Zone, endpoint.Address); !plugged[key] {
+				plugged[key] = true
+				newEndpoints++
+			}
+			if _, ok := nodes[d.Service]; !ok {
+				nodes[d.Service] = nil
+				newServices++
+			}
+		}
+		if ns.MaxServices > 0 && newServices > 0 && services+newServices > ns.MaxServices {
+			return utils.Errorf(utils.EcodeQuotaExceeded, "namespace %s exceeds max services: %d", name, ns.MaxServices)
+		}
+		if ns.MaxEndpoints > 0 && newEndpoints > 0 && endpoints+newEndpoints > ns.MaxEndpoints {
+			return utils.Errorf(utils.EcodeQuotaExceeded, "namespace %s exceeds max endpoints: %d", name, ns.MaxEndpoints)
+		}
+	}
+	return nil
+}
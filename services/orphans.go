@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/utils"
+)
+
+// OrphanGCConfig sweeper of orphaned endpoint keys, reported every Interval and
+// deleted if Delete, after being found by two sweeps in a row unchanged;
+// leaseless ones are only reported, they may be plugged permanently (ttl 0) by admins
+type OrphanGCConfig struct {
+	Enable   bool
+	Interval time.Duration `default:"10m"`
+	Delete   bool
+}
+
+// orphan reasons
+const (
+	OrphanNoLease         = "no_lease"
+	OrphanInvalidValue    = "invalid_value"
+	OrphanAddressMismatch = "address_mismatch"
+)
+
+// OrphanKey an endpoint key not bound to a lease without being static, or with a malformed value
+type OrphanKey struct {
+	Key         string `json:"key"`
+	Service     string `json:"service"`
+	Zone        string `json:"zone"`
+	Address     string `json:"address"`
+	Reason      string `json:"reason"`
+	ModRevision int64  `json:"mod_revision"`
+}
+
+// deletable whether the gc may delete orphan, permanent endpoints are leaseless too
+func (orphan *OrphanKey) deletable() bool {
+	return orphan.Reason != OrphanNoLease
+}
+
+func orphanOf(addr string, lease int64, value []byte) string {
+	var endpoint ServiceEndpoint
+	if err := json.Unmarshal(value, &endpoint); err != nil {
+		return OrphanInvalidValue
+	}
+	if endpoint.Address != addr {
+		return OrphanAddressMismatch
+	}
+	if lease == 0 && !endpoint.Static {
+		return OrphanNoLease
+	}
+	return ""
+}
+
+// FindOrphans endpoint keys of all services without lease or with malformed values
+func (ctrl *ServiceCtrl) FindOrphans(ctx context.Context) ([]OrphanKey, error) {
+	prefix := ctrl.config.KeyPrefix + "/"
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "find orphans fail", "get service keys fail: %v", err)
+	}
+	orphans := make([]OrphanKey, 0)
+	for _, kv := range resp.Kvs {
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[2], serviceKeyNodePrefix) {
+			continue
+		}
+		addr := strings.TrimPrefix(parts[2], serviceKeyNodePrefix)
+		if reason := orphanOf(addr, kv.Lease, kv.Value); reason != "" {
+			orphans = append(orphans, OrphanKey{Key: string(kv.Key), Service: parts[0], Zone: parts[1],
+				Address: addr, Reason: reason, ModRevision: kv.ModRevision})
+		}
+	}
+	return orphans, nil
+}
+
+// deleteOrphan delete orphan if unchanged since found
+func (ctrl *ServiceCtrl) deleteOrphan(ctx context.Context, orphan *OrphanKey) (bool, error) {
+	resp, err := ctrl.etcdClient.Txn(ctx).If(
+		clientv3.Compare(clientv3.ModRevision(orphan.Key), "=", orphan.ModRevision),
+	).Then(clientv3.OpDelete(orphan.Key)).Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// RunOrphanGC sweep orphaned endpoint keys every interval until ctx done
+func (ctrl *ServiceCtrl) RunOrphanGC(ctx context.Context) {
+	config := ctrl.config.OrphanGC
+	if !config.Enable {
+		return
+	}
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	// orphans of the last sweep, by key
+	var last map[string]int64
+	for {
+		orphans, err := ctrl.FindOrphans(ctx)
+		if err != nil {
+			logging.Errorf("sweep orphaned keys fail: %v", err)
+		} else {
+			counts := map[string]float64{OrphanNoLease: 0, OrphanInvalidValue: 0, OrphanAddressMismatch: 0}
+			found := make(map[string]int64, len(orphans))
+			for i := range orphans {
+				orphan := &orphans[i]
+				counts[orphan.Reason]++
+				found[orphan.Key] = orphan.ModRevision
+				if !config.Delete || !orphan.deletable() || last[orphan.Key] != orphan.ModRevision {
+					logging.Warningf("orphaned endpoint key(%s): %s", orphan.Key, orphan.Reason)
+					continue
+				}
+				if deleted, err := ctrl.deleteOrphan(ctx, orphan); err != nil {
+					logging.Warningf("delete orphaned endpoint key(%s) fail: %v", orphan.Key, err)
+				} else if deleted {
+					logging.Infof("deleted orphaned endpoint key(%s): %s", orphan.Key, orphan.Reason)
+					metrics.OrphanedKeysDeleted.Inc()
+				}
+			}
+			for reason, count := range counts {
+				metrics.OrphanedKeys.WithLabelValues(reason).Set(count)
+			}
+			last = found
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
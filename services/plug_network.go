@@ -0,0 +1,116 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+
+	"github.com/infrmods/xbus/utils"
+)
+
+// PlugNetworkPolicy source networks allowed to plug endpoints of matched services,
+// the first matched policy applies; services defaults to all
+type PlugNetworkPolicy struct {
+	Services string   `yaml:"services"`
+	Nets     []string `yaml:"nets"`
+	// MatchAddress the plugged address should be in the same net as the caller,
+	// or be the caller's ip if no nets
+	MatchAddress bool `yaml:"match_address"`
+	servicesR    *regexp.Regexp
+	nets         []*net.IPNet
+}
+
+func preparePlugNetworkPolicies(policies []PlugNetworkPolicy) error {
+	for i := range policies {
+		policy := &policies[i]
+		r, err := regexp.Compile(policy.Services)
+		if err != nil {
+			return fmt.Errorf("invalid plug network services: %s", policy.Services)
+		}
+		policy.servicesR = r
+		policy.nets = make([]*net.IPNet, 0, len(policy.Nets))
+		for _, cidr := range policy.Nets {
+			_, ipnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("invalid plug network net: %s", cidr)
+			}
+			policy.nets = append(policy.nets, ipnet)
+		}
+		if len(policy.nets) == 0 && !policy.MatchAddress {
+			return fmt.Errorf("plug network policy of %q allows nothing", policy.Services)
+		}
+	}
+	return nil
+}
+
+func (config *Config) plugNetworkPolicyOf(service string) *PlugNetworkPolicy {
+	for i := range config.PlugNetworks {
+		if config.PlugNetworks[i].servicesR.MatchString(service) {
+			return &config.PlugNetworks[i]
+		}
+	}
+	return nil
+}
+
+// check clientIP plugging address is permitted, address is empty for sealed endpoints
+func (policy *PlugNetworkPolicy) check(clientIP net.IP, address string) error {
+	var callerNet *net.IPNet
+	if len(policy.nets) > 0 {
+		for _, ipnet := range policy.nets {
+			if ipnet.Contains(clientIP) {
+				callerNet = ipnet
+				break
+			}
+		}
+		if callerNet == nil {
+			return utils.Errorf(utils.EcodeNotPermitted, "plugging from %s not permitted", clientIP)
+		}
+	}
+	if !policy.MatchAddress || address == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return utils.Errorf(utils.EcodeInvalidAddress, "address %s should be an ip to match the caller's network", address)
+	}
+	if callerNet != nil && !callerNet.Contains(ip) {
+		return utils.Errorf(utils.EcodeInvalidAddress, "address %s not in the caller's network %s", address, callerNet)
+	}
+	if callerNet == nil && !ip.Equal(clientIP) {
+		return utils.Errorf(utils.EcodeInvalidAddress, "address %s is not the caller's ip %s", address, clientIP)
+	}
+	return nil
+}
+
+// CheckPlugNetwork check clientIP is permitted to plug endpoint into descs by plug_networks
+func (ctrl *ServiceCtrl) CheckPlugNetwork(descs []ServiceDescV1, endpoint *ServiceEndpoint, clientIP net.IP) error {
+	policies := ctrl.policies()
+	if len(policies.PlugNetworks) == 0 {
+		return nil
+	}
+	address := endpoint.Address
+	// sealed endpoint's address is an opaque node id
+	if endpoint.Sealed != nil {
+		address = ""
+	}
+	for _, desc := range descs {
+		policy := policies.plugNetworkPolicyOf(desc.Service)
+		if policy == nil {
+			continue
+		}
+		if clientIP == nil {
+			return utils.Errorf(utils.EcodeNotPermitted, "unknown caller ip plugging %s", desc.Service)
+		}
+		if err := policy.check(clientIP, address); err != nil {
+			if e, ok := err.(*utils.Error); ok {
+				e.Keys = []string{desc.Service}
+			}
+			return err
+		}
+	}
+	return nil
+}
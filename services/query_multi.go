@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/tracing"
+)
+
+// ServiceRef reference of a service, optionally limited to a zone
+type ServiceRef struct {
+	Service string `json:"service"`
+	Zone    string `json:"zone,omitempty"`
+}
+
+func (ref *ServiceRef) key() string {
+	if ref.Zone != "" {
+		return ref.Service + "/" + ref.Zone
+	}
+	return ref.Service
+}
+
+// MultiQueryItem query result of a service ref
+type MultiQueryItem struct {
+	Ref      ServiceRef `json:"ref"`
+	NotFound bool       `json:"not_found,omitempty"`
+	Service  *ServiceV1 `json:"service,omitempty"`
+}
+
+// MultiQueryResult services of refs at one revision, in refs order
+type MultiQueryResult struct {
+	Revision int64            `json:"revision"`
+	Services []MultiQueryItem `json:"services"`
+}
+
+// QueryMulti query services of refs as a consistent snapshot
+func (ctrl *ServiceCtrl) QueryMulti(ctx context.Context, clientIP net.IP, refs []ServiceRef) (*MultiQueryResult, error) {
+	keys := make([]string, 0, len(refs))
+	for i := range refs {
+		if refs[i].Zone != "" {
+			if err := checkServiceZone(refs[i].Service, refs[i].Zone); err != nil {
+				return nil, err
+			}
+		} else if err := checkService(refs[i].Service); err != nil {
+			return nil, err
+		}
+		keys = append(keys, refs[i].key())
+	}
+	result := &MultiQueryResult{Services: make([]MultiQueryItem, 0, len(refs))}
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	defer metrics.ObserveSince(metrics.QueryDuration.WithLabelValues("query_multi"), time.Now())
+	ctx, span := tracing.StartSpan(ctx, "services.QueryMulti")
+	defer span.Finish()
+	kvsMap, revision, err := ctrl.snapshot(ctx, keys)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	result.Revision = revision
+	for i, ref := range refs {
+		item := MultiQueryItem{Ref: ref}
+		if kvs := kvsMap[keys[i]]; len(kvs) > 0 {
+			service, err := ctrl.makeService(clientIP, keys[i], kvs)
+			if err != nil {
+				return nil, err
+			}
+			item.Service = service
+		} else {
+			item.NotFound = true
+		}
+		result.Services = append(result.Services, item)
+	}
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+// QuotaConfig registration quotas protecting etcd from runaway registrants, 0 is unlimited.
+// Services of an app are the ones it plugged into since the quota is enabled, until deleted
+type QuotaConfig struct {
+	MaxEndpointsPerService int `yaml:"max_endpoints_per_service"`
+	MaxServicesPerApp      int `yaml:"max_services_per_app"`
+}
+
+type appKey struct{}
+
+// WithApp ctx of plugs by app, checked by per app quotas
+func WithApp(ctx context.Context, app string) context.Context {
+	return context.WithValue(ctx, appKey{}, app)
+}
+
+func appOf(ctx context.Context) string {
+	app, _ := ctx.Value(appKey{}).(string)
+	return app
+}
+
+func (ctrl *ServiceCtrl) serviceOwnerKey(app, service string) string {
+	return fmt.Sprintf("%s-owners/%s/%s", ctrl.config.KeyPrefix, app, service)
+}
+
+// ownedServices services plugged by app, by the recorded owner keys
+func (ctrl *ServiceCtrl) ownedServices(ctx context.Context, app string) ([]string, error) {
+	prefix := ctrl.serviceOwnerKeyPrefix(app)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get owned services fail", "get owned services(%s) fail: %v", prefix, err)
+	}
+	services := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		services = append(services, strings.TrimPrefix(string(kv.Key), prefix))
+	}
+	return services, nil
+}
+
+func (ctrl *ServiceCtrl) serviceOwnerKeyPrefix(app string) string {
+	if app != "" {
+		return fmt.Sprintf("%s-owners/%s/", ctrl.config.KeyPrefix, app)
+	}
+	return fmt.Sprintf("%s-owners/", ctrl.config.KeyPrefix)
+}
+
+// checkServiceQuotas check plugging endpoint into descs won't exceed service and app
+// quotas, returns puts of owner keys of services new to the app (recorded for impact
+// analysis without quotas too)
+func (ctrl *ServiceCtrl) checkServiceQuotas(ctx context.Context, descs []ServiceDescV1, endpoint *ServiceEndpoint) ([]clientv3.Op, error) {
+	quotas := ctrl.policies().Quotas
+	if quotas.MaxEndpointsPerService > 0 {
+		zonesOf := make(map[string]map[string]bool)
+		for _, desc := range descs {
+			if zonesOf[desc.Service] == nil {
+				zonesOf[desc.Service] = make(map[string]bool)
+			}
+			zonesOf[desc.Service][desc.Zone] = true
+		}
+		for service, zones := range zonesOf {
+			prefix := ctrl.serviceEntryPrefix(service)
+			etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+			resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+			span.FinishWithError(err)
+			if err != nil {
+				return nil, utils.CleanErr(err, "plug service fail", "get nodes(%s) fail: %v", prefix, err)
+			}
+			endpoints := 0
+			for _, kv := range resp.Kvs {
+				parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 2)
+				if len(parts) != 2 || !strings.HasPrefix(parts[1], serviceKeyNodePrefix) {
+					continue
+				}
+				endpoints++
+				if parts[1] == serviceKeyNodePrefix+endpoint.Address {
+					// replugging
+					delete(zones, parts[0])
+				}
+			}
+			if len(zones) > 0 && endpoints+len(zones) > quotas.MaxEndpointsPerService {
+				return nil, utils.Errorf(utils.EcodeQuotaExceeded, "%s exceeds max endpoints: %d",
+					service, quotas.MaxEndpointsPerService)
+			}
+		}
+	}
+
+	app := appOf(ctx)
+	if app == "" {
+		return nil, nil
+	}
+	if quotas.MaxServicesPerApp <= 0 {
+		// owner keys are recorded for impact analysis, put only if not exists
+		var ops []clientv3.Op
+		recorded := make(map[string]bool, len(descs))
+		for _, desc := range descs {
+			if !recorded[desc.Service] {
+				recorded[desc.Service] = true
+				key := ctrl.serviceOwnerKey(app, desc.Service)
+				ops = append(ops, clientv3.OpTxn(
+					[]clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(key), "=", 0)},
+					[]clientv3.Op{clientv3.OpPut(key, "")}, nil))
+			}
+		}
+		return ops, nil
+	}
+	prefix := ctrl.serviceOwnerKeyPrefix(app)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "plug service fail", "get owned services(%s) fail: %v", prefix, err)
+	}
+	owned := make(map[string]bool, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		owned[strings.TrimPrefix(string(kv.Key), prefix)] = true
+	}
+	services := len(owned)
+	var ops []clientv3.Op
+	for _, desc := range descs {
+		if !owned[desc.Service] {
+			owned[desc.Service] = true
+			ops = append(ops, clientv3.OpPut(ctrl.serviceOwnerKey(app, desc.Service), ""))
+		}
+	}
+	if len(ops) > 0 && services+len(ops) > quotas.MaxServicesPerApp {
+		return nil, utils.Errorf(utils.EcodeQuotaExceeded, "app %s exceeds max services: %d", app, quotas.MaxServicesPerApp)
+	}
+	return ops, nil
+}
+
+// deleteServiceOwners delete owner keys of service once it's deleted in all zones
+func (ctrl *ServiceCtrl) deleteServiceOwners(ctx context.Context, service string) error {
+	resp, err := ctrl.etcdClient.Get(ctx, ctrl.serviceEntryPrefix(service), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return utils.CleanErr(err, "get service keys fail", "get service keys(%s) fail: %v", service, err)
+	}
+	if resp.Count > 0 {
+		return nil
+	}
+	prefix := ctrl.serviceOwnerKeyPrefix("")
+	resp, err = ctrl.etcdClient.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return utils.CleanErr(err, "get service owners fail", "get service owners fail: %v", err)
+	}
+	var ops []clientv3.Op
+	for _, kv := range resp.Kvs {
+		if strings.HasSuffix(string(kv.Key), "/"+service) {
+			ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if _, err := ctrl.etcdClient.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return utils.CleanErr(err, "delete service owners fail", "delete service owners(%s) fail: %v", service, err)
+	}
+	return nil
+}
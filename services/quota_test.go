@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOwnerKeys(t *testing.T) {
+	ctrl, etcdClient, stop := newEtcdTestCtrl(t, nil)
+	defer stop()
+	ctx := WithApp(context.Background(), "payments")
+	descs := []ServiceDescV1{
+		{Service: "payments.core:1.0", Zone: "default"},
+		{Service: "payments.core:1.0", Zone: "backup"},
+		{Service: "payments.refund:1.0", Zone: "default"},
+	}
+	// owner keys are recorded without quotas, and replugs don't rewrite them
+	for i := 0; i < 2; i++ {
+		ops, err := ctrl.checkServiceQuotas(ctx, descs, &ServiceEndpoint{Address: "10.0.0.1:80"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ops) != 2 {
+			t.Fatalf("owner ops: %d", len(ops))
+		}
+		if _, err := etcdClient.Txn(ctx).Then(ops...).Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := etcdClient.Get(ctx, ctrl.serviceOwnerKey("payments", "payments.core:1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) != 1 || resp.Kvs[0].Version != 1 {
+		t.Fatalf("owner key rewritten: %v", resp.Kvs)
+	}
+
+	services, err := ctrl.ownedServices(ctx, "payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 || services[0] != "payments.core:1.0" || services[1] != "payments.refund:1.0" {
+		t.Fatalf("owned services: %v", services)
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/infrmods/xbus/logging"
+)
+
+// RunRegistration keep endpoint of descs plugged with a lease of ttl until ctx done,
+// re-plugged if the lease is lost, the lease is revoked when ctx done
+func (ctrl *ServiceCtrl) RunRegistration(ctx context.Context, ttl time.Duration, descs []ServiceDescV1, endpoint *ServiceEndpoint) {
+	retryInterval := ttl / 3
+	if retryInterval < time.Second {
+		retryInterval = time.Second
+	}
+	for ctx.Err() == nil {
+		err := ctrl.runRegistration(ctx, ttl, descs, endpoint)
+		if ctx.Err() != nil {
+			return
+		}
+		logging.Warningf("registration of %s fail, retry in %v: %v", endpoint.Address, retryInterval, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (ctrl *ServiceCtrl) runRegistration(ctx context.Context, ttl time.Duration, descs []ServiceDescV1, endpoint *ServiceEndpoint) error {
+	leaseID, err := ctrl.PlugAll(ctx, ttl, 0, descs, endpoint, InstanceConflictReplace)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		revokeCtx, cancel := context.WithTimeout(context.Background(), sharedGetTimeout)
+		defer cancel()
+		if _, err := ctrl.etcdClient.Revoke(revokeCtx, leaseID); err != nil && err != v3rpc.ErrLeaseNotFound {
+			logging.Warningf("revoke lease(%d) of %s fail: %v", leaseID, endpoint.Address, err)
+		}
+	}()
+	keepAlive, err := ctrl.etcdClient.KeepAlive(ctx, leaseID)
+	if err != nil {
+		return fmt.Errorf("keepalive lease(%d) fail: %v", leaseID, err)
+	}
+	logging.Infof("registered %s with lease(%d)", endpoint.Address, leaseID)
+	for range keepAlive {
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return fmt.Errorf("lease(%d) expired", leaseID)
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"github.com/infrmods/xbus/logging"
+)
+
+// policies config of the running reloadable policies, the same as ctrl.config
+// for the rest
+func (ctrl *ServiceCtrl) policies() *Config {
+	return ctrl.live.Load().(*Config)
+}
+
+// PrepareReload prepare reloading the policies of config: name rules, banned and
+// verify address services, address validation, unique address, plug networks and
+// quotas; returns the func applying them, nothing is changed if it fails.
+// Other parts of config, and watches, are not affected
+func (ctrl *ServiceCtrl) PrepareReload(config *Config) (func(), error) {
+	live := *ctrl.policies()
+	live.NameRules = config.NameRules
+	live.BannedEndpointAddresses = config.BannedEndpointAddresses
+	live.VerifyAddressServices = config.VerifyAddressServices
+	live.VerifyAddressTimeout = config.VerifyAddressTimeout
+	live.AddressValidation = config.AddressValidation
+	live.UniqueAddress = append([]UniqueAddressPolicy(nil), config.UniqueAddress...)
+	live.PlugNetworks = append([]PlugNetworkPolicy(nil), config.PlugNetworks...)
+	live.Quotas = config.Quotas
+	if err := live.preparePolicies(); err != nil {
+		return nil, err
+	}
+	return func() {
+		currentNames.Store(live.names)
+		ctrl.live.Store(&live)
+		logging.Infof("service policies reloaded")
+	}, nil
+}
@@ -0,0 +1,434 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+// RolloutConfig running rollouts are checked every Interval by the leader,
+// gate callbacks time out after GateTimeout
+type RolloutConfig struct {
+	Interval    time.Duration `default:"5s"`
+	GateTimeout time.Duration `default:"10s" yaml:"gate_timeout"`
+}
+
+// rollout states
+const (
+	RolloutRunning    = "running"
+	RolloutPaused     = "paused"
+	RolloutSucceeded  = "succeeded"
+	RolloutRolledBack = "rolled_back"
+	RolloutAborted    = "aborted"
+)
+
+// DefaultRolloutSteps weights of rollouts without steps
+var DefaultRolloutSteps = []int{10, 50, 100}
+
+// Rollout shift an alias of service Name from version From to To by splitting it:
+// Weight percent of clients get To, raised to each of Steps every StepInterval seconds
+// while the gates pass, at least MinInstances endpoints of To and a 2xx response
+// to the rollout POSTed to GateURL if set; the alias points to To once the weight reaches 100.
+// A failed gate rolls back if AutoRollback, pauses otherwise
+type Rollout struct {
+	Name         string    `json:"name"`
+	Alias        string    `json:"alias"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	Steps        []int     `json:"steps"`
+	StepInterval int64     `json:"step_interval"`
+	MinInstances int64     `json:"min_instances"`
+	GateURL      string    `json:"gate_url,omitempty"`
+	AutoRollback bool      `json:"auto_rollback"`
+	State        string    `json:"state"`
+	Step         int       `json:"step"`
+	Weight       int       `json:"weight"`
+	Message      string    `json:"message,omitempty"`
+	CreateTime   time.Time `json:"create_time"`
+	UpdateTime   time.Time `json:"update_time"`
+	NextTime     time.Time `json:"next_time"`
+	Revision     int64     `json:"revision"`
+}
+
+func (rollout *Rollout) active() bool {
+	return rollout.State == RolloutRunning || rollout.State == RolloutPaused
+}
+
+// aliasValue value of the alias at the current weight
+func (rollout *Rollout) aliasValue() string {
+	if rollout.State == RolloutSucceeded {
+		return rollout.To
+	}
+	return aliasTarget{Version: rollout.From, Canary: rollout.To, Weight: rollout.Weight}.value()
+}
+
+func (ctrl *ServiceCtrl) rolloutKeyPrefix() string {
+	return ctrl.config.KeyPrefix + "-rollouts/"
+}
+
+func (ctrl *ServiceCtrl) rolloutKey(name, alias string) string {
+	return fmt.Sprintf("%s%s/%s", ctrl.rolloutKeyPrefix(), name, alias)
+}
+
+func checkRolloutSteps(steps []int) error {
+	last := 0
+	for _, step := range steps {
+		if step <= last || step > 100 {
+			return utils.Errorf(utils.EcodeInvalidParam, "invalid rollout steps: %v, must ascend in (0, 100]", steps)
+		}
+		last = step
+	}
+	if last != 100 {
+		return utils.Errorf(utils.EcodeInvalidParam, "invalid rollout steps: %v, must end with 100", steps)
+	}
+	return nil
+}
+
+func (ctrl *ServiceCtrl) getRollout(ctx context.Context, name, alias string) (*Rollout, error) {
+	key := ctrl.rolloutKey(name, alias)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", key)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, key)
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get rollout fail", "get rollout(%s) fail: %v", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	var rollout Rollout
+	if err := json.Unmarshal(resp.Kvs[0].Value, &rollout); err != nil {
+		logging.Errorf("unmarshal rollout(%s) fail: %v", key, err)
+		return nil, utils.NewSystemError("invalid rollout data")
+	}
+	rollout.Revision = resp.Kvs[0].ModRevision
+	return &rollout, nil
+}
+
+func (ctrl *ServiceCtrl) getAliasValue(ctx context.Context, name, alias string) (string, bool, error) {
+	key := ctrl.serviceAliasKey(name, alias)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", key)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, key)
+	span.FinishWithError(err)
+	if err != nil {
+		return "", false, utils.CleanErr(err, "get alias fail", "get alias(%s) fail: %v", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return "", false, nil
+	}
+	return string(resp.Kvs[0].Value), true, nil
+}
+
+// saveRollout save rollout if unchanged since read, and move its alias from aliasValue
+// to the value of the rollout if aliasValue isn't empty; false if either changed
+func (ctrl *ServiceCtrl) saveRollout(ctx context.Context, rollout *Rollout, aliasValue string) (bool, error) {
+	rollout.UpdateTime = time.Now()
+	data, err := json.Marshal(rollout)
+	if err != nil {
+		logging.Errorf("marshal rollout fail: %v", err)
+		return false, utils.NewSystemError("marshal rollout fail")
+	}
+	key := ctrl.rolloutKey(rollout.Name, rollout.Alias)
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(key), "=", rollout.Revision)}
+	ops := []clientv3.Op{clientv3.OpPut(key, string(data))}
+	if aliasValue != "" {
+		aliasKey := ctrl.serviceAliasKey(rollout.Name, rollout.Alias)
+		cmps = append(cmps, clientv3.Compare(clientv3.Value(aliasKey), "=", aliasValue))
+		ops = append(ops, clientv3.OpPut(aliasKey, rollout.aliasValue()))
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Txn", key)
+	ok, err := ctrl.txn(etcdCtx, cmps, ops)
+	span.FinishWithError(err)
+	if err != nil {
+		return false, utils.CleanErr(err, "save rollout fail", "save rollout(%s) fail: %v", key, err)
+	}
+	return ok, nil
+}
+
+// StartRollout start rolling out alias of service name from its current version to to,
+// steps and interval of rollout are defaulted if empty
+func (ctrl *ServiceCtrl) StartRollout(ctx context.Context, name, alias, to string, rollout Rollout) (*Rollout, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	if !rValidAlias.MatchString(alias) {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "invalid alias: %s", alias)
+	}
+	if !validNames().version.MatchString(to) {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "invalid rollout version: %s", to)
+	}
+	if len(rollout.Steps) == 0 {
+		rollout.Steps = DefaultRolloutSteps
+	}
+	if err := checkRolloutSteps(rollout.Steps); err != nil {
+		return nil, err
+	}
+	if rollout.StepInterval < 0 || rollout.MinInstances < 0 {
+		return nil, utils.NewError(utils.EcodeInvalidParam, "invalid rollout step_interval or min_instances")
+	}
+	if rollout.MinInstances == 0 {
+		rollout.MinInstances = 1
+	}
+	if rollout.GateURL != "" && !strings.HasPrefix(rollout.GateURL, "http://") &&
+		!strings.HasPrefix(rollout.GateURL, "https://") {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "invalid rollout gate_url: %s", rollout.GateURL)
+	}
+
+	prev, err := ctrl.getRollout(ctx, name, alias)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil && prev.active() {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "rollout of %s:%s is %s", name, alias, prev.State)
+	}
+	from, ok, err := ctrl.getAliasValue(ctx, name, alias)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no such alias: %s:%s", name, alias)
+	}
+	if target := parseAliasTarget([]byte(from)); target.Canary != "" {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "alias %s:%s is split", name, alias)
+	}
+	if from == to {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "alias %s:%s is %s already", name, alias, to)
+	}
+	if count, _, err := ctrl.Count(ctx, name+":"+to, ""); err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no such service: %s:%s", name, to)
+	}
+
+	now := time.Now()
+	rollout.Name, rollout.Alias, rollout.From, rollout.To = name, alias, from, to
+	rollout.State, rollout.Step, rollout.Weight, rollout.Message = RolloutRunning, 0, 0, ""
+	rollout.CreateTime, rollout.NextTime = now, now
+	rollout.Revision = 0
+	if prev != nil {
+		rollout.Revision = prev.Revision
+	}
+	if ok, err := ctrl.saveRollout(ctx, &rollout, from); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "alias %s:%s changed, retry", name, alias)
+	}
+	logging.Infof("rollout %s:%s started, %s -> %s", name, alias, from, to)
+	return &rollout, nil
+}
+
+// GetRollout rollout of alias of service name
+func (ctrl *ServiceCtrl) GetRollout(ctx context.Context, name, alias string) (*Rollout, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	rollout, err := ctrl.getRollout(ctx, name, alias)
+	if err != nil {
+		return nil, err
+	}
+	if rollout == nil {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no rollout of %s:%s", name, alias)
+	}
+	return rollout, nil
+}
+
+// ListRollouts rollouts of all services
+func (ctrl *ServiceCtrl) ListRollouts(ctx context.Context) ([]Rollout, error) {
+	prefix := ctrl.rolloutKeyPrefix()
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "list rollouts fail", "get rollouts(%s) fail: %v", prefix, err)
+	}
+	rollouts := make([]Rollout, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var rollout Rollout
+		if err := json.Unmarshal(kv.Value, &rollout); err != nil {
+			logging.Errorf("unmarshal rollout(%s) fail: %v", kv.Key, err)
+			continue
+		}
+		rollout.Revision = kv.ModRevision
+		rollouts = append(rollouts, rollout)
+	}
+	return rollouts, nil
+}
+
+// PauseRollout pause running rollout of alias of service name at its current weight
+func (ctrl *ServiceCtrl) PauseRollout(ctx context.Context, name, alias, message string) (*Rollout, error) {
+	return ctrl.changeRollout(ctx, name, alias, func(rollout *Rollout) (bool, error) {
+		if rollout.State != RolloutRunning {
+			return false, utils.Errorf(utils.EcodeInvalidParam, "rollout of %s:%s is %s", name, alias, rollout.State)
+		}
+		rollout.State, rollout.Message = RolloutPaused, message
+		return false, nil
+	})
+}
+
+// ResumeRollout resume paused rollout of alias of service name, gates are checked at once
+func (ctrl *ServiceCtrl) ResumeRollout(ctx context.Context, name, alias string) (*Rollout, error) {
+	return ctrl.changeRollout(ctx, name, alias, func(rollout *Rollout) (bool, error) {
+		if rollout.State != RolloutPaused {
+			return false, utils.Errorf(utils.EcodeInvalidParam, "rollout of %s:%s is %s", name, alias, rollout.State)
+		}
+		rollout.State, rollout.Message, rollout.NextTime = RolloutRunning, "", time.Now()
+		return false, nil
+	})
+}
+
+// RollbackRollout point alias of service name back to the version before rollout,
+// also after the rollout succeeded
+func (ctrl *ServiceCtrl) RollbackRollout(ctx context.Context, name, alias, message string) (*Rollout, error) {
+	return ctrl.changeRollout(ctx, name, alias, func(rollout *Rollout) (bool, error) {
+		if !rollout.active() && rollout.State != RolloutSucceeded {
+			return false, utils.Errorf(utils.EcodeInvalidParam, "rollout of %s:%s is %s", name, alias, rollout.State)
+		}
+		rollout.State, rollout.Weight, rollout.Message = RolloutRolledBack, 0, message
+		return true, nil
+	})
+}
+
+// changeRollout apply change to rollout and save it, the alias is also moved if change says so
+func (ctrl *ServiceCtrl) changeRollout(ctx context.Context, name, alias string,
+	change func(rollout *Rollout) (bool, error)) (*Rollout, error) {
+	rollout, err := ctrl.GetRollout(ctx, name, alias)
+	if err != nil {
+		return nil, err
+	}
+	aliasValue := rollout.aliasValue()
+	moveAlias, err := change(rollout)
+	if err != nil {
+		return nil, err
+	}
+	if !moveAlias {
+		aliasValue = ""
+	}
+	if ok, err := ctrl.saveRollout(ctx, rollout, aliasValue); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "rollout of %s:%s changed, retry", name, alias)
+	}
+	logging.Infof("rollout %s:%s %s at weight %d", name, alias, rollout.State, rollout.Weight)
+	return rollout, nil
+}
+
+// checkRolloutGates error of the first failed gate of rollout, and whether to wait
+// for it instead: too few instances are waited for until traffic is shifted
+func (ctrl *ServiceCtrl) checkRolloutGates(ctx context.Context, rollout *Rollout) (bool, error) {
+	count, _, err := ctrl.Count(ctx, rollout.Name+":"+rollout.To, "")
+	if err != nil {
+		return true, err
+	}
+	if count < rollout.MinInstances {
+		err := fmt.Errorf("instances of %s: %d < %d", rollout.To, count, rollout.MinInstances)
+		return rollout.Weight == 0, err
+	}
+	if rollout.GateURL == "" {
+		return false, nil
+	}
+	data, err := json.Marshal(rollout)
+	if err != nil {
+		return true, err
+	}
+	client := http.Client{Timeout: ctrl.config.Rollouts.GateTimeout}
+	resp, err := client.Post(rollout.GateURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("gate callback fail: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, fmt.Errorf("gate callback: %s", resp.Status)
+	}
+	return false, nil
+}
+
+// stepRollout move running rollout on to its next step if the gates pass
+func (ctrl *ServiceCtrl) stepRollout(ctx context.Context, rollout *Rollout) error {
+	aliasValue, ok, err := ctrl.getAliasValue(ctx, rollout.Name, rollout.Alias)
+	if err != nil {
+		return err
+	}
+	expected := rollout.aliasValue()
+	if !ok || aliasValue != expected {
+		// changed out of the rollout, leave it
+		rollout.State, rollout.Message = RolloutAborted, "alias changed"
+		_, err := ctrl.saveRollout(ctx, rollout, "")
+		logging.Warningf("rollout %s:%s aborted, alias changed", rollout.Name, rollout.Alias)
+		return err
+	}
+
+	wait, gateErr := ctrl.checkRolloutGates(ctx, rollout)
+	if gateErr != nil {
+		if wait {
+			if rollout.Message == gateErr.Error() {
+				return nil
+			}
+			rollout.Message = gateErr.Error()
+			_, err := ctrl.saveRollout(ctx, rollout, "")
+			return err
+		}
+		logging.Warningf("rollout %s:%s gate failed at weight %d: %v",
+			rollout.Name, rollout.Alias, rollout.Weight, gateErr)
+		rollout.Message = gateErr.Error()
+		if !rollout.AutoRollback {
+			rollout.State = RolloutPaused
+			_, err := ctrl.saveRollout(ctx, rollout, "")
+			return err
+		}
+		rollout.State, rollout.Weight = RolloutRolledBack, 0
+		_, err := ctrl.saveRollout(ctx, rollout, expected)
+		return err
+	}
+
+	rollout.Weight, rollout.Message = rollout.Steps[rollout.Step], ""
+	rollout.Step++
+	if rollout.Weight >= 100 {
+		rollout.State = RolloutSucceeded
+	}
+	rollout.NextTime = time.Now().Add(time.Duration(rollout.StepInterval) * time.Second)
+	if ok, err := ctrl.saveRollout(ctx, rollout, expected); err != nil {
+		return err
+	} else if ok {
+		logging.Infof("rollout %s:%s %s -> %s at weight %d",
+			rollout.Name, rollout.Alias, rollout.From, rollout.To, rollout.Weight)
+	}
+	return nil
+}
+
+// RunRollouts step running rollouts until ctx done, should only be run by the leader
+func (ctrl *ServiceCtrl) RunRollouts(ctx context.Context) {
+	interval := ctrl.config.Rollouts.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			rollouts, err := ctrl.ListRollouts(ctx)
+			if err != nil {
+				logging.Errorf("list rollouts fail: %v", err)
+				continue
+			}
+			for i := range rollouts {
+				rollout := &rollouts[i]
+				if rollout.State != RolloutRunning || now.Before(rollout.NextTime) {
+					continue
+				}
+				if err := ctrl.stepRollout(ctx, rollout); err != nil {
+					logging.Errorf("step rollout %s:%s fail: %v", rollout.Name, rollout.Alias, err)
+				}
+			}
+		}
+	}
+}
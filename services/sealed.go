@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+// SealedRecipient content key wrapped for one consumer app
+type SealedRecipient struct {
+	App string `json:"app"`
+	Key []byte `json:"key"`
+}
+
+// SealedEndpoint multi-recipient envelope of a service endpoint,
+// the xbus server stores it as is and never sees the plain endpoint
+type SealedEndpoint struct {
+	Recipients []SealedRecipient `json:"recipients"`
+	Nonce      []byte            `json:"nonce"`
+	Ciphertext []byte            `json:"ciphertext"`
+	Signer     string            `json:"signer"`
+	Signature  []byte            `json:"signature"`
+}
+
+func sealedAAD(service, zone string) []byte {
+	return []byte(service + "/" + zone)
+}
+
+func (sealed *SealedEndpoint) digest(service, zone string) []byte {
+	h := sha256.New()
+	h.Write(sealedAAD(service, zone))
+	h.Write([]byte{0})
+	h.Write([]byte(sealed.Signer))
+	h.Write([]byte{0})
+	for _, recipient := range sealed.Recipients {
+		h.Write([]byte(recipient.App))
+		h.Write([]byte{0})
+		h.Write(recipient.Key)
+	}
+	h.Write(sealed.Nonce)
+	h.Write(sealed.Ciphertext)
+	return h.Sum(nil)
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func verifySignature(pubKey crypto.PublicKey, digest, sig []byte) error {
+	switch k := pubKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig)
+	case *ecdsa.PublicKey:
+		var esig ecdsaSignature
+		if _, err := asn1.Unmarshal(sig, &esig); err != nil {
+			return err
+		}
+		if !ecdsa.Verify(k, digest, esig.R, esig.S) {
+			return fmt.Errorf("ecdsa verify fail")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key: %T", pubKey)
+	}
+}
+
+// SealEndpoint seal endpoint's address & config for recipient apps,
+// endpoint.Address of the result is replaced by nodeID
+func SealEndpoint(service, zone, nodeID string, endpoint *ServiceEndpoint,
+	signerName string, signer crypto.Signer, recipients map[string]*x509.Certificate) (*ServiceEndpoint, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients")
+	}
+	plain, err := json.Marshal(ServiceEndpoint{Address: endpoint.Address, Config: endpoint.Config})
+	if err != nil {
+		return nil, err
+	}
+	contentKey := make([]byte, 32)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sealed := SealedEndpoint{Nonce: make([]byte, gcm.NonceSize()), Signer: signerName}
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Ciphertext = gcm.Seal(nil, sealed.Nonce, plain, sealedAAD(service, zone))
+
+	apps := make([]string, 0, len(recipients))
+	for app := range recipients {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	for _, app := range apps {
+		pubKey, ok := recipients[app].PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported recipient(%s) key: %T", app, recipients[app].PublicKey)
+		}
+		key, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pubKey, contentKey, sealedAAD(service, zone))
+		if err != nil {
+			return nil, fmt.Errorf("wrap key for %s fail: %v", app, err)
+		}
+		sealed.Recipients = append(sealed.Recipients, SealedRecipient{App: app, Key: key})
+	}
+
+	if sealed.Signature, err = signer.Sign(rand.Reader, sealed.digest(service, zone), crypto.SHA256); err != nil {
+		return nil, fmt.Errorf("sign sealed endpoint fail: %v", err)
+	}
+	return &ServiceEndpoint{Address: nodeID, Sealed: &sealed}, nil
+}
+
+// Open verify & decrypt sealed endpoint as app, signerCert should be the
+// pinned cert of the provider app, not one fetched from xbus
+func (sealed *SealedEndpoint) Open(service, zone, app string,
+	key crypto.Decrypter, signerCert *x509.Certificate) (*ServiceEndpoint, error) {
+	if signerCert.Subject.CommonName != sealed.Signer {
+		return nil, fmt.Errorf("unexpected signer: %s", sealed.Signer)
+	}
+	if err := verifySignature(signerCert.PublicKey, sealed.digest(service, zone), sealed.Signature); err != nil {
+		return nil, fmt.Errorf("verify sealed endpoint fail: %v", err)
+	}
+
+	var wrapped []byte
+	for _, recipient := range sealed.Recipients {
+		if recipient.App == app {
+			wrapped = recipient.Key
+			break
+		}
+	}
+	if wrapped == nil {
+		return nil, fmt.Errorf("not a recipient: %s", app)
+	}
+	contentKey, err := key.Decrypt(rand.Reader, wrapped,
+		&rsa.OAEPOptions{Hash: crypto.SHA256, Label: sealedAAD(service, zone)})
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key fail: %v", err)
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, sealedAAD(service, zone))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt sealed endpoint fail: %v", err)
+	}
+	var endpoint ServiceEndpoint
+	if err := json.Unmarshal(plain, &endpoint); err != nil {
+		return nil, fmt.Errorf("invalid sealed endpoint: %v", err)
+	}
+	return &endpoint, nil
+}
+
+type signerKey struct{}
+
+// WithSigner ctx of plugs by the app of cert, sealed endpoints plugged should be signed by it
+func WithSigner(ctx context.Context, cert *x509.Certificate) context.Context {
+	return context.WithValue(ctx, signerKey{}, cert)
+}
+
+func signerOf(ctx context.Context) *x509.Certificate {
+	cert, _ := ctx.Value(signerKey{}).(*x509.Certificate)
+	return cert
+}
+
+// checkSealed check endpoint is sealed iff desc's service is a sealed service, and a sealed
+// one is signed by the plugging app (of ctx), the signature covers the recipients' keys;
+// prev the envelope already stored, which is not verified again if unchanged
+func (ctrl *ServiceCtrl) checkSealed(ctx context.Context, desc *ServiceDescV1, endpoint *ServiceEndpoint,
+	prev *SealedEndpoint) error {
+	if endpoint.Sealed == nil {
+		if ctrl.config.isServiceSealed(desc.Service) {
+			return utils.Errorf(utils.EcodeInvalidEndpoint, "%s requires sealed endpoint", desc.Service)
+		}
+		return nil
+	}
+	sealed := endpoint.Sealed
+	if !ctrl.config.isServiceSealed(desc.Service) {
+		return utils.Errorf(utils.EcodeInvalidEndpoint, "%s doesn't accept sealed endpoints", desc.Service)
+	}
+	if endpoint.Config != "" {
+		return utils.Errorf(utils.EcodeInvalidEndpoint, "sealed endpoint with plain config")
+	}
+	if len(sealed.Recipients) == 0 || len(sealed.Ciphertext) == 0 || len(sealed.Signature) == 0 {
+		return utils.Errorf(utils.EcodeInvalidEndpoint, "incomplete sealed endpoint")
+	}
+	for _, recipient := range sealed.Recipients {
+		if recipient.App == "" || len(recipient.Key) == 0 {
+			return utils.Errorf(utils.EcodeInvalidEndpoint, "incomplete sealed endpoint recipient")
+		}
+	}
+	if prev != nil && endpointEqual(&ServiceEndpoint{Sealed: prev}, &ServiceEndpoint{Sealed: sealed}) {
+		return nil
+	}
+	cert := signerOf(ctx)
+	if cert == nil {
+		return utils.NewError(utils.EcodeEndpointUnverified, "sealed endpoint requires the signer's app cert")
+	}
+	if cert.Subject.CommonName != sealed.Signer {
+		return utils.Errorf(utils.EcodeEndpointUnverified, "sealed endpoint not signed by %s", cert.Subject.CommonName)
+	}
+	if err := verifySignature(cert.PublicKey, sealed.digest(desc.Service, desc.Zone), sealed.Signature); err != nil {
+		logging.FromContext(ctx).Warningf("verify sealed endpoint of %s fail: %v", desc.Service, err)
+		return utils.NewError(utils.EcodeEndpointUnverified, "invalid signature of sealed endpoint")
+	}
+	return nil
+}
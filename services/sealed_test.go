@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/gocomm/config"
+	"github.com/infrmods/xbus/utils"
+)
+
+func newTestCtrl(t *testing.T, setup func(*Config)) *ServiceCtrl {
+	var cfg Config
+	if err := config.DefaultConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if setup != nil {
+		setup(&cfg)
+	}
+	ctrl, err := NewServiceCtrl(&cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctrl
+}
+
+func newTestCert(t *testing.T, name string) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: name},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func errCode(err error) string {
+	if e, ok := err.(*utils.Error); ok {
+		return e.Code
+	}
+	return ""
+}
+
+func TestCheckSealed(t *testing.T) {
+	ctrl := newTestCtrl(t, func(cfg *Config) { cfg.SealedServices = []string{`^sealed\.`} })
+	providerKey, providerCert := newTestCert(t, "provider")
+	_, otherCert := newTestCert(t, "other")
+	consumerKey, consumerCert := newTestCert(t, "consumer")
+	desc := ServiceDescV1{Service: "sealed.app:1.0", Zone: "default"}
+	sealed, err := SealEndpoint(desc.Service, desc.Zone, "node1", &ServiceEndpoint{Address: "10.0.0.1:80"},
+		"provider", providerKey, map[string]*x509.Certificate{"consumer": consumerCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithSigner(context.Background(), providerCert)
+
+	if err := ctrl.checkSealed(ctx, &desc, sealed, nil); err != nil {
+		t.Fatalf("signed envelope rejected: %v", err)
+	}
+	if opened, err := sealed.Sealed.Open(desc.Service, desc.Zone, "consumer", consumerKey, providerCert); err != nil || opened.Address != "10.0.0.1:80" {
+		t.Fatalf("open fail: %v", err)
+	}
+
+	if err := ctrl.checkSealed(context.Background(), &desc, sealed, nil); errCode(err) != utils.EcodeEndpointUnverified {
+		t.Errorf("envelope without signer cert: %v", err)
+	}
+	if err := ctrl.checkSealed(WithSigner(context.Background(), otherCert), &desc, sealed, nil); errCode(err) != utils.EcodeEndpointUnverified {
+		t.Errorf("envelope checked against other app: %v", err)
+	}
+
+	forged := *sealed.Sealed
+	forged.Signer = "other"
+	if err := ctrl.checkSealed(WithSigner(context.Background(), otherCert), &desc,
+		&ServiceEndpoint{Address: "node1", Sealed: &forged}, nil); errCode(err) != utils.EcodeEndpointUnverified {
+		t.Errorf("forged envelope accepted: %v", err)
+	}
+	tampered := *sealed.Sealed
+	tampered.Recipients = append([]SealedRecipient{{App: "attacker", Key: []byte("key")}}, tampered.Recipients...)
+	if err := ctrl.checkSealed(ctx, &desc, &ServiceEndpoint{Address: "node1", Sealed: &tampered}, nil); errCode(err) != utils.EcodeEndpointUnverified {
+		t.Errorf("tampered recipients accepted: %v", err)
+	}
+	if err := ctrl.checkSealed(ctx, &ServiceDescV1{Service: desc.Service, Zone: "other"}, sealed, nil); errCode(err) != utils.EcodeEndpointUnverified {
+		t.Errorf("envelope of another zone accepted: %v", err)
+	}
+	// unchanged stored envelopes are not verified again, e.g. on patches by admins
+	if err := ctrl.checkSealed(context.Background(), &desc, sealed, sealed.Sealed); err != nil {
+		t.Errorf("unchanged envelope rejected: %v", err)
+	}
+
+	plain := ServiceDescV1{Service: "plain.app:1.0", Zone: "default"}
+	if err := ctrl.checkSealed(ctx, &plain, sealed, nil); errCode(err) != utils.EcodeInvalidEndpoint {
+		t.Errorf("sealed endpoint of unsealed service accepted: %v", err)
+	}
+	if err := ctrl.checkSealed(ctx, &desc, &ServiceEndpoint{Address: "10.0.0.1:80"}, nil); errCode(err) != utils.EcodeInvalidEndpoint {
+		t.Errorf("plain endpoint of sealed service accepted: %v", err)
+	}
+}
+
+func TestVerifyEndpointSealed(t *testing.T) {
+	ctrl := newTestCtrl(t, func(cfg *Config) {
+		cfg.SealedServices = []string{`^sealed\.`}
+		cfg.VerifyAddressServices = []string{`.*`}
+	})
+	providerKey, providerCert := newTestCert(t, "provider")
+	_, consumerCert := newTestCert(t, "consumer")
+	descs := []ServiceDescV1{{Service: "sealed.app:1.0", Zone: "default"}}
+	sealed, err := SealEndpoint(descs[0].Service, descs[0].Zone, "node1", &ServiceEndpoint{Address: "10.0.0.1:80"},
+		"provider", providerKey, map[string]*x509.Certificate{"consumer": consumerCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.VerifyEndpoint(context.Background(), descs, sealed, providerCert); err != nil {
+		t.Errorf("signed envelope not verified: %v", err)
+	}
+	fake := &ServiceEndpoint{Address: "node1", Sealed: &SealedEndpoint{Signer: "provider",
+		Recipients: []SealedRecipient{{App: "consumer", Key: []byte("k")}}, Ciphertext: []byte("c"), Signature: []byte("s")}}
+	if err := ctrl.VerifyEndpoint(context.Background(), descs, fake, providerCert); errCode(err) != utils.EcodeEndpointUnverified {
+		t.Errorf("fake envelope bypassed verification: %v", err)
+	}
+}
@@ -8,11 +8,16 @@ import (
 	"net"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
-	"github.com/golang/glog"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/tracing"
 	"github.com/infrmods/xbus/utils"
+	"golang.org/x/sync/singleflight"
 )
 
 // DefaultZone default service zone
@@ -31,23 +36,43 @@ type ServiceDescV1 struct {
 func (desc *ServiceDescV1) Marshal() ([]byte, error) {
 	data, err := json.Marshal(desc)
 	if err != nil {
-		glog.Errorf("marshal service-desc(%#v) fail: %v", desc, err)
+		logging.Errorf("marshal service-desc(%#v) fail: %v", desc, err)
 		return nil, utils.NewSystemError("marshal service-desc fail")
 	}
 	return data, nil
 }
 
-// ServiceEndpoint service endpoint
+// ServiceEndpoint service endpoint, Locality is where it runs, e.g. us-east-1a,
+// unrelated to service zones, Origin is the cluster of mirrored endpoints,
+// Priority the failover tier, 0 (primary) first, PlugTime when it's plugged with its lease
+// (set by xbus, for slow start)
 type ServiceEndpoint struct {
-	Address string `json:"address"`
-	Config  string `json:"config,omitempty"`
+	Address    string          `json:"address"`
+	Config     string          `json:"config,omitempty"`
+	Sealed     *SealedEndpoint `json:"sealed,omitempty"`
+	InstanceID string          `json:"instance_id,omitempty"`
+	Static     bool            `json:"static,omitempty"`
+	Locality   string          `json:"locality,omitempty"`
+	Origin     string          `json:"origin,omitempty"`
+	Priority   int             `json:"priority,omitempty"`
+	PlugTime   *time.Time      `json:"plug_time,omitempty"`
+}
+
+// maxEndpointPriority lowest failover tier
+const maxEndpointPriority = 100
+
+func checkPriority(endpoint *ServiceEndpoint) error {
+	if endpoint.Priority < 0 || endpoint.Priority > maxEndpointPriority {
+		return utils.Errorf(utils.EcodeInvalidEndpoint, "invalid priority, should be 0 ~ %d", maxEndpointPriority)
+	}
+	return nil
 }
 
 // Marshal marshal impl
 func (endpoint *ServiceEndpoint) Marshal() ([]byte, error) {
 	data, err := json.Marshal(endpoint)
 	if err != nil {
-		glog.Errorf("marshal endpoint(%#v) fail: %v", endpoint, err)
+		logging.Errorf("marshal endpoint(%#v) fail: %v", endpoint, err)
 		return nil, utils.NewSystemError("marshal endpoint fail")
 	}
 	return data, nil
@@ -81,19 +106,58 @@ type NetMapping struct {
 
 // Config service module config
 type Config struct {
-	KeyPrefix               string       `default:"/services" yaml:"key_prefix"`
-	NetMappings             []NetMapping `yaml:"net_mappings"`
-	BannedEndpointAddresses []string     `yaml:"banned_endpoint_addresses"`
+	KeyPrefix               string                  `default:"/services" yaml:"key_prefix"`
+	NameRules               NameRules               `yaml:"name_rules"`
+	LegacyKeyPrefix         string                  `yaml:"legacy_key_prefix"`
+	NetMappings             []NetMapping            `yaml:"net_mappings"`
+	BannedEndpointAddresses []string                `yaml:"banned_endpoint_addresses"`
+	SealedServices          []string                `yaml:"sealed_services"`
+	VerifyAddressServices   []string                `yaml:"verify_address_services"`
+	VerifyAddressTimeout    time.Duration           `default:"3s" yaml:"verify_address_timeout"`
+	AddressValidation       AddressValidationConfig `yaml:"address_validation"`
+	GC                      GCConfig                `yaml:"gc"`
+	WatchHub                WatchHubConfig          `yaml:"watch_hub"`
+	QueryCache              QueryCacheConfig        `yaml:"query_cache"`
+	UniqueAddress           []UniqueAddressPolicy   `yaml:"unique_address"`
+	PlugNetworks            []PlugNetworkPolicy     `yaml:"plug_networks"`
+	Federation              FederationConfig        `yaml:"federation"`
+	Mirrors                 []MirrorConfig          `yaml:"mirrors"`
+	Namespaces              []NamespaceConfig       `yaml:"namespaces"`
+	Quotas                  QuotaConfig             `yaml:"quotas"`
+	ChangeLog               ChangeLogConfig         `yaml:"change_log"`
+	OrphanGC                OrphanGCConfig          `yaml:"orphan_gc"`
+	Flapping                FlapConfig              `yaml:"flapping"`
+	Tombstones              TombstoneConfig         `yaml:"tombstones"`
+	WatchCoalesce           time.Duration           `yaml:"watch_coalesce"`
+	Rollouts                RolloutConfig           `yaml:"rollouts"`
 	bannedAddrRs            []*regexp.Regexp
+	sealedServiceRs         []*regexp.Regexp
+	verifyAddressRs         []*regexp.Regexp
+	names                   *nameRegexps
 }
 
 func (config *Config) prepare() error {
-	config.bannedAddrRs = make([]*regexp.Regexp, 0, len(config.BannedEndpointAddresses))
-	for _, addr := range config.BannedEndpointAddresses {
-		if r, err := regexp.Compile(addr); err == nil {
-			config.bannedAddrRs = append(config.bannedAddrRs, r)
+	if err := config.preparePolicies(); err != nil {
+		return err
+	}
+	if err := config.GC.prepare(); err != nil {
+		return err
+	}
+	if err := config.Federation.prepare(); err != nil {
+		return err
+	}
+	if err := prepareMirrors(config.Mirrors, &config.Federation); err != nil {
+		return err
+	}
+	if err := prepareNamespaces(config.Namespaces); err != nil {
+		return err
+	}
+	config.sealedServiceRs = make([]*regexp.Regexp, 0, len(config.SealedServices))
+	for _, service := range config.SealedServices {
+		if r, err := regexp.Compile(service); err == nil {
+			config.sealedServiceRs = append(config.sealedServiceRs, r)
 		} else {
-			return fmt.Errorf("invalid banned address: %s", addr)
+			return fmt.Errorf("invalid sealed service: %s", service)
 		}
 	}
 	for i := range config.NetMappings {
@@ -110,6 +174,38 @@ func (config *Config) prepare() error {
 	return nil
 }
 
+// preparePolicies prepare the parts of config reloadable by PrepareReload
+func (config *Config) preparePolicies() error {
+	names, err := config.NameRules.compile()
+	if err != nil {
+		return err
+	}
+	config.names = names
+	if err := prepareUniqueAddressPolicies(config.UniqueAddress); err != nil {
+		return err
+	}
+	if err := preparePlugNetworkPolicies(config.PlugNetworks); err != nil {
+		return err
+	}
+	config.bannedAddrRs = make([]*regexp.Regexp, 0, len(config.BannedEndpointAddresses))
+	for _, addr := range config.BannedEndpointAddresses {
+		if r, err := regexp.Compile(addr); err == nil {
+			config.bannedAddrRs = append(config.bannedAddrRs, r)
+		} else {
+			return fmt.Errorf("invalid banned address: %s", addr)
+		}
+	}
+	config.verifyAddressRs = make([]*regexp.Regexp, 0, len(config.VerifyAddressServices))
+	for _, service := range config.VerifyAddressServices {
+		if r, err := regexp.Compile(service); err == nil {
+			config.verifyAddressRs = append(config.verifyAddressRs, r)
+		} else {
+			return fmt.Errorf("invalid verify address service: %s", service)
+		}
+	}
+	return nil
+}
+
 func (config *Config) isAddressBanned(addr string) bool {
 	for _, r := range config.bannedAddrRs {
 		if r.MatchString(addr) {
@@ -119,6 +215,24 @@ func (config *Config) isAddressBanned(addr string) bool {
 	return false
 }
 
+func (config *Config) isServiceSealed(service string) bool {
+	for _, r := range config.sealedServiceRs {
+		if r.MatchString(service) {
+			return true
+		}
+	}
+	return false
+}
+
+func (config *Config) isAddressVerifyRequired(service string) bool {
+	for _, r := range config.verifyAddressRs {
+		if r.MatchString(service) {
+			return true
+		}
+	}
+	return false
+}
+
 func (config *Config) mapAddress(addr string, clientIP net.IP) string {
 	if clientIP != nil {
 		if host, port, err := net.SplitHostPort(addr); err == nil {
@@ -139,6 +253,23 @@ type ServiceCtrl struct {
 	config     Config
 	db         *sql.DB
 	etcdClient *clientv3.Client
+	hub        *watchHub
+	cache      *queryCache
+	gets       singleflight.Group
+	checksums  *checksumTree
+	remotes    []remoteCluster
+	changes    changeLog
+	leaseTTLs  leaseTTLCache
+
+	tombstoneLease tombstoneLease
+
+	configSchemas configSchemaCache
+	maintenances  *kvCache
+	aliases       *kvCache
+	probes        probeLimiter
+	flaps         *flapDetector
+	// live *Config of the running reloadable policies, see PrepareReload
+	live atomic.Value
 }
 
 // NewServiceCtrl new service ctrl
@@ -146,15 +277,32 @@ func NewServiceCtrl(config *Config, db *sql.DB, etcdClient *clientv3.Client) (*S
 	if err := config.prepare(); err != nil {
 		return nil, err
 	}
-	glog.Infof("%#v", *config)
-	services := &ServiceCtrl{config: *config, db: db, etcdClient: etcdClient}
+	logging.Infof("%#v", *config)
+	services := &ServiceCtrl{config: *config, db: db, etcdClient: etcdClient,
+		hub: newWatchHub(config.WatchHub, etcdClient)}
 	if strings.HasSuffix(services.config.KeyPrefix, "/") {
 		services.config.KeyPrefix = services.config.KeyPrefix[:len(services.config.KeyPrefix)-1]
 	}
+	services.config.LegacyKeyPrefix = strings.TrimSuffix(services.config.LegacyKeyPrefix, "/")
+	services.live.Store(&services.config)
+	currentNames.Store(config.names)
+	if config.QueryCache.Enable {
+		services.cache = newQueryCache(config.QueryCache, services)
+	}
+	services.checksums = newChecksumTree(services)
+	services.maintenances = newKVCache("maintenance", etcdClient, services.maintenanceKeyPrefix())
+	services.aliases = newKVCache("alias", etcdClient, services.config.KeyPrefix+"-aliases/")
+	if config.Flapping.Enable {
+		services.flaps = newFlapDetector(config.Flapping)
+		services.OnChange(services.flaps.observe)
+	}
 	return services, nil
 }
 
 func checkDesc(desc *ServiceDescV1) error {
+	if err := checkNewService(desc.Service); err != nil {
+		return err
+	}
 	if err := checkServiceZone(desc.Service, desc.Zone); err != nil {
 		return err
 	}
@@ -164,10 +312,60 @@ func checkDesc(desc *ServiceDescV1) error {
 	return nil
 }
 
-// PlugAll plug services
+// PlugAll plug services, endpoints are bound to a lease, see PlugStatic for permanent ones
 func (ctrl *ServiceCtrl) PlugAll(ctx context.Context,
 	ttl time.Duration, leaseID clientv3.LeaseID,
-	descs []ServiceDescV1, endpoint *ServiceEndpoint) (clientv3.LeaseID, error) {
+	descs []ServiceDescV1, endpoint *ServiceEndpoint, onConflict InstanceConflict) (clientv3.LeaseID, error) {
+	ctx, span := tracing.StartSpan(ctx, "services.PlugAll")
+	span.SetAttribute("address", endpoint.Address)
+	var newLeaseID clientv3.LeaseID
+	var err error
+	if ttl <= 0 && leaseID == 0 {
+		err = utils.NewError(utils.EcodeInvalidParam, "missing ttl, permanent endpoints are plugged by admins")
+	} else {
+		dynamic := *endpoint
+		dynamic.Static = false
+		newLeaseID, err = ctrl.plugAll(ctx, ttl, leaseID, descs, &dynamic, onConflict)
+	}
+	span.FinishWithError(err)
+	metrics.ServicePlugs.WithLabelValues(metrics.Result(err)).Inc()
+	return newLeaseID, err
+}
+
+// plugTimeOf plug time of address plugged with leaseID (0 for static endpoints): kept from
+// the endpoint already plugged with the same lease, so plugs stay idempotent, now otherwise
+func (ctrl *ServiceCtrl) plugTimeOf(ctx context.Context, ttl time.Duration, leaseID clientv3.LeaseID,
+	descs []ServiceDescV1, address string) (*time.Time, error) {
+	if (ttl <= 0 || leaseID != 0) && len(descs) > 0 {
+		// endpoints of all descs are got in one txn
+		ops := make([]clientv3.Op, 0, len(descs))
+		for _, desc := range descs {
+			ops = append(ops, clientv3.OpGet(ctrl.serviceNodeKey(desc.Service, desc.Zone, address)))
+		}
+		etcdCtx, span := startEtcdSpan(ctx, "Txn", address)
+		resp, err := ctrl.etcdClient.Txn(etcdCtx).Then(ops...).Commit()
+		span.FinishWithError(err)
+		if err != nil {
+			return nil, utils.CleanErr(err, "plug service fail", "get endpoints of %s fail: %v", address, err)
+		}
+		for _, r := range resp.Responses {
+			kvs := r.GetResponseRange().Kvs
+			if len(kvs) == 0 || clientv3.LeaseID(kvs[0].Lease) != leaseID {
+				continue
+			}
+			var prev ServiceEndpoint
+			if err := json.Unmarshal(kvs[0].Value, &prev); err == nil {
+				return prev.PlugTime, nil
+			}
+		}
+	}
+	now := time.Now().Truncate(time.Second)
+	return &now, nil
+}
+
+func (ctrl *ServiceCtrl) plugAll(ctx context.Context,
+	ttl time.Duration, leaseID clientv3.LeaseID,
+	descs []ServiceDescV1, endpoint *ServiceEndpoint, onConflict InstanceConflict) (clientv3.LeaseID, error) {
 	if err := ctrl.checkAddress(endpoint.Address); err != nil {
 		return 0, err
 	}
@@ -175,14 +373,59 @@ func (ctrl *ServiceCtrl) PlugAll(ctx context.Context,
 		if err := checkDesc(&desc); err != nil {
 			return 0, err
 		}
+		if err := ctrl.checkSealed(ctx, &desc, endpoint, nil); err != nil {
+			return 0, err
+		}
+	}
+	if endpoint.Sealed == nil {
+		// sealed endpoint's address is an opaque node id
+		if err := ctrl.validateAddress(ctx, descs, endpoint.Address); err != nil {
+			return 0, err
+		}
 	}
+	if endpoint.InstanceID != "" && !rValidInstanceID.MatchString(endpoint.InstanceID) {
+		return 0, utils.NewError(utils.EcodeInvalidEndpoint, "invalid instance id")
+	}
+	if endpoint.Locality != "" && !rValidZone.MatchString(endpoint.Locality) {
+		return 0, utils.NewError(utils.EcodeInvalidEndpoint, "invalid locality")
+	}
+	if endpoint.Origin != "" {
+		// set by mirrors only, a plugged one would pass off as mirrored
+		return 0, utils.NewError(utils.EcodeInvalidEndpoint, "origin is set by mirrors")
+	}
+	if err := checkPriority(endpoint); err != nil {
+		return 0, err
+	}
+	for _, desc := range descs {
+		if err := ctrl.checkConfigSchema(ctx, desc.Service, endpoint); err != nil {
+			return 0, err
+		}
+	}
+	if err := ctrl.checkNamespaceQuotas(ctx, descs, endpoint); err != nil {
+		return 0, err
+	}
+	ownerOps, err := ctrl.checkServiceQuotas(ctx, descs, endpoint)
+	if err != nil {
+		return 0, err
+	}
+	plugged := *endpoint
+	if plugged.PlugTime, err = ctrl.plugTimeOf(ctx, ttl, leaseID, descs, endpoint.Address); err != nil {
+		return 0, err
+	}
+	endpoint = &plugged
 	endpointData, err := endpoint.Marshal()
 	if err != nil {
 		return 0, err
 	}
 	endpointValue := string(endpointData)
-	if ttl > 0 && leaseID == 0 {
-		if resp, err := ctrl.etcdClient.Lease.Grant(ctx, int64(ttl.Seconds())); err == nil {
+	dryRun := dryRunOf(ctx)
+	if ttl > 0 && leaseID == 0 && dryRun != nil {
+		dryRun.note("grant lease of ttl %v", ttl)
+	} else if ttl > 0 && leaseID == 0 {
+		etcdCtx, span := startEtcdSpan(ctx, "Grant", "")
+		resp, err := ctrl.etcdClient.Lease.Grant(etcdCtx, int64(ttl.Seconds()))
+		span.FinishWithError(err)
+		if err == nil {
 			leaseID = clientv3.LeaseID(resp.ID)
 		} else {
 			return 0, utils.CleanErr(err, "create lease fail", "create lease fail: %v", err)
@@ -224,13 +467,41 @@ func (ctrl *ServiceCtrl) PlugAll(ctx context.Context,
 				[]clientv3.Op{opPut},
 			))
 	}
-	if _, err := ctrl.etcdClient.Txn(ctx).Then(updateOps...).Commit(); err != nil {
-		return 0, utils.CleanErr(err, "plug service fail",
-			"put services node fail: %v", err)
+	updateOps = append(updateOps, ownerOps...)
+	for attempt := 1; ; attempt++ {
+		// endpoints of the same instance with other addresses, and duplicated addresses
+		// of services with a uniqueness policy, are checked and replaced in the same txn
+		cmps, replaceOps, err := ctrl.instanceReplaceOps(ctx, descs, endpoint, onConflict)
+		if err != nil {
+			return 0, err
+		}
+		uniqueCmps, uniqueOps, err := ctrl.uniqueAddressOps(ctx, descs, endpoint, leaseID)
+		if err != nil {
+			return 0, err
+		}
+		cmps = append(cmps, uniqueCmps...)
+		ops := make([]clientv3.Op, 0, len(updateOps)+len(replaceOps)+len(uniqueOps))
+		ops = append(append(append(ops, updateOps...), replaceOps...), uniqueOps...)
+		etcdCtx, span := startEtcdSpan(ctx, "Txn", endpoint.Address)
+		succeeded, err := ctrl.txn(etcdCtx, cmps, ops)
+		span.FinishWithError(err)
+		if err != nil {
+			return 0, utils.CleanErr(err, "plug service fail",
+				"put services node fail: %v", err)
+		}
+		if succeeded {
+			break
+		}
+		if attempt >= maxPlugAttempts {
+			return 0, utils.NewError(utils.EcodeTooManyAttempts, "endpoints changed concurrently")
+		}
 	}
 
+	if dryRun != nil {
+		return leaseID, nil
+	}
 	if err := ctrl.updateServiceDBItems(descs); err != nil {
-		glog.Errorf("update service db items fail: %v", err)
+		logging.FromContext(ctx).Errorf("update service db items fail: %v", err)
 		return 0, utils.NewError(utils.EcodeSystemError, "update db fail")
 	}
 	return leaseID, nil
@@ -238,47 +509,92 @@ func (ctrl *ServiceCtrl) PlugAll(ctx context.Context,
 
 // Unplug unplug service
 func (ctrl *ServiceCtrl) Unplug(ctx context.Context, service, zone, addr string) error {
+	ctx, span := tracing.StartSpan(ctx, "services.Unplug")
+	span.SetAttribute("service", service)
+	err := ctrl.unplug(ctx, service, zone, addr)
+	span.FinishWithError(err)
+	metrics.ServiceUnplugs.WithLabelValues(metrics.Result(err)).Inc()
+	return err
+}
+
+func (ctrl *ServiceCtrl) unplug(ctx context.Context, service, zone, addr string) error {
 	if err := checkServiceZone(service, zone); err != nil {
 		return err
 	}
 	if err := ctrl.checkAddress(addr); err != nil {
 		return err
 	}
-	if _, err := ctrl.etcdClient.Delete(ctx, ctrl.serviceNodeKey(service, zone, addr)); err != nil {
-		glog.Errorf("delete key(%s) fail: %v", ctrl.serviceNodeKey(service, zone, addr), err)
+	nodeKey := ctrl.serviceNodeKey(service, zone, addr)
+	if dryRun := dryRunOf(ctx); dryRun != nil {
+		etcdCtx, span := startEtcdSpan(ctx, "Txn", nodeKey)
+		_, err := ctrl.txn(etcdCtx, nil, []clientv3.Op{clientv3.OpDelete(nodeKey)})
+		span.FinishWithError(err)
+		if err != nil {
+			return utils.CleanErr(err, "delete key fail", "dry run delete key(%s) fail: %v", nodeKey, err)
+		}
+		dryRun.note("lease of the endpoint is revoked if no other keys are bound")
+		return nil
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Delete", nodeKey)
+	resp, err := ctrl.etcdClient.Delete(etcdCtx, nodeKey, clientv3.WithPrevKV())
+	span.FinishWithError(err)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("delete key(%s) fail: %v", nodeKey, err)
 		return utils.NewSystemError("delete key fail")
 	}
+	if ctrl.config.Tombstones.Enable {
+		ctrl.writeTombstones(ctx, resp.PrevKvs)
+	} else if len(resp.PrevKvs) > 0 && resp.PrevKvs[0].Lease != 0 {
+		ctrl.revokeIfUnused(ctx, clientv3.LeaseID(resp.PrevKvs[0].Lease))
+	}
 	return nil
 }
 
-// Query query service
+// Query query service, aliased versions are resolved to the concrete ones
 func (ctrl *ServiceCtrl) Query(ctx context.Context, clientIP net.IP, service string) (*ServiceV1, int64, error) {
 	if err := checkService(service); err != nil {
 		return nil, 0, err
 	}
 
-	return ctrl._query(ctx, clientIP, service)
+	defer metrics.ObserveSince(metrics.QueryDuration.WithLabelValues("query"), time.Now())
+	ctx, span := tracing.StartSpan(ctx, "services.Query")
+	span.SetAttribute("service", service)
+	service, _, err := ctrl.resolveAlias(ctx, clientIP, service)
+	if err != nil {
+		span.FinishWithError(err)
+		return nil, 0, err
+	}
+	result, rev, err := ctrl._query(ctx, clientIP, service)
+	span.FinishWithError(err)
+	return result, rev, err
 }
 
-// QueryZones query services with raw zone
+// QueryZones query services with raw zone, and zones under LegacyKeyPrefix during prefix migrations
 func (ctrl *ServiceCtrl) QueryZones(ctx context.Context, clientIP net.IP, service string) (*ServiceWithRawZone, int64, error) {
 	if err := checkService(service); err != nil {
 		return nil, 0, err
 	}
 
+	defer metrics.ObserveSince(metrics.QueryDuration.WithLabelValues("query_zones"), time.Now())
 	serviceKey := ctrl.serviceEntryPrefix(service)
-	resp, err := ctrl.etcdClient.Get(ctx, serviceKey, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	etcdCtx, span := startEtcdSpan(ctx, "Get", serviceKey)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, serviceKey, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
 
 	if err != nil {
 		return nil, 0, utils.CleanErr(err, "query fail", "Query(%s) fail: %v", service, err)
 	}
 
-	if len(resp.Kvs) == 0 {
+	zones, err := ctrl.makeServiceWithRawZone(serviceKey, resp.Kvs)
+	if ctrl.config.LegacyKeyPrefix != "" {
+		if zones, err = ctrl.queryLegacyZones(ctx, service, zones); err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(zones) == 0 {
 		return nil, 0, utils.Errorf(utils.EcodeNotFound, "no such service: %s", service)
 	}
 
-	zones, err := ctrl.makeServiceWithRawZone(serviceKey, resp.Kvs)
-
 	return &ServiceWithRawZone{
 		Service: service,
 		Zones:   zones,
@@ -288,44 +604,162 @@ func (ctrl *ServiceCtrl) QueryZones(ctx context.Context, clientIP net.IP, servic
 // QueryServiceZone query service zone with service key and zone
 func (ctrl *ServiceCtrl) QueryServiceZone(ctx context.Context, clientIP net.IP, service string, zone string) (*ServiceV1, int64, error) {
 	key := ctrl.serviceZoneKey(service, zone)
-	return ctrl._query(ctx, clientIP, key) // key 为 `service/zone`
+	defer metrics.ObserveSince(metrics.QueryDuration.WithLabelValues("query_zone"), time.Now())
+	ctx, span := tracing.StartSpan(ctx, "services.QueryServiceZone")
+	span.SetAttribute("service", key)
+	result, rev, err := ctrl._query(ctx, clientIP, key) // key 为 `service/zone`
+	span.FinishWithError(err)
+	return result, rev, err
 }
 
 func (ctrl *ServiceCtrl) _query(ctx context.Context, clientIP net.IP, serviceKey string) (*ServiceV1, int64, error) {
-	key := ctrl.serviceEntryPrefix(serviceKey)
-	resp, err := ctrl.etcdClient.Get(ctx, key, clientv3.WithPrefix())
+	kvs, revision, err := ctrl.getKvs(ctx, ctrl.serviceEntryPrefix(serviceKey))
 	if err != nil {
-		return nil, 0, utils.CleanErr(err, "query fail", "Query(%s) fail: %v", key, err)
+		return nil, 0, err
 	}
-
-	if len(resp.Kvs) == 0 {
+	var service *ServiceV1
+	if len(kvs) > 0 {
+		if service, err = ctrl.makeService(clientIP, serviceKey, kvs); err != nil {
+			return nil, 0, err
+		}
+	}
+	if ctrl.config.LegacyKeyPrefix != "" {
+		if service, err = ctrl.queryLegacy(ctx, clientIP, serviceKey, service); err != nil {
+			return nil, 0, err
+		}
+	}
+	if service == nil {
 		return nil, 0, utils.Errorf(utils.EcodeNotFound, "no such service: %s", serviceKey)
 	}
-	service, err := ctrl.makeService(clientIP, serviceKey, resp.Kvs)
-	if err != nil {
-		return nil, 0, err
+	return service, revision, nil
+}
+
+// sharedGetTimeout timeout of etcd gets shared by concurrent queries,
+// detached from the callers' ctx so one canceled caller won't fail the others
+const sharedGetTimeout = 10 * time.Second
+
+type kvsResult struct {
+	kvs      []*mvccpb.KeyValue
+	revision int64
+}
+
+// getKvs kvs of prefix, from the query cache if enabled,
+// concurrent gets of the same prefix are collapsed into one
+func (ctrl *ServiceCtrl) getKvs(ctx context.Context, key string) ([]*mvccpb.KeyValue, int64, error) {
+	if ctrl.cache != nil && !isConsistentQuery(ctx) {
+		if kvs, revision, ok := ctrl.cache.get(key); ok {
+			return kvs, revision, nil
+		}
+	}
+	ch := ctrl.gets.DoChan(key, func() (interface{}, error) {
+		_, span := startEtcdSpan(ctx, "Get", key)
+		etcdCtx, cancel := context.WithTimeout(context.Background(), sharedGetTimeout)
+		defer cancel()
+		resp, err := ctrl.etcdClient.Get(etcdCtx, key, clientv3.WithPrefix())
+		span.FinishWithError(err)
+		if err != nil {
+			return nil, utils.CleanErr(err, "query fail", "Query(%s) fail: %v", key, err)
+		}
+		if ctrl.cache != nil {
+			ctrl.cache.put(key, resp.Kvs, resp.Header.Revision)
+		}
+		return &kvsResult{kvs: resp.Kvs, revision: resp.Header.Revision}, nil
+	})
+	select {
+	case r := <-ch:
+		if r.Shared {
+			metrics.SharedGets.Inc()
+		}
+		if r.Err != nil {
+			return nil, 0, r.Err
+		}
+		result := r.Val.(*kvsResult)
+		return result.kvs, result.revision, nil
+	case <-ctx.Done():
+		return nil, 0, utils.CleanErr(ctx.Err(), "query fail", "Query(%s) fail: %v", key, ctx.Err())
+	}
+}
+
+// coalesceMargin coalescing ends this long before the deadline of a watch
+const coalesceMargin = 100 * time.Millisecond
+
+// coalesceWait wait WatchCoalesce for further changes after a change, ending early before the
+// deadline of ctx; false if coalescing is disabled
+func (ctrl *ServiceCtrl) coalesceWait(ctx context.Context) bool {
+	window := ctrl.config.WatchCoalesce
+	if window <= 0 {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline) - coalesceMargin; left < window {
+			window = left
+		}
+	}
+	if window > 0 {
+		timer := time.NewTimer(window)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
 	}
-	return service, resp.Header.Revision, nil
+	return true
 }
 
-// Watch watch service
+// Watch watch service changes since revision, changes of the alias
+// of an aliased version and under LegacyKeyPrefix count too, changes within WatchCoalesce after the first are returned together,
+// fails with *WatchError on timeout, cancel or compaction
 func (ctrl *ServiceCtrl) Watch(ctx context.Context, clientIP net.IP, serviceKey string, revision int64) (*ServiceV1, int64, error) {
 	if err := checkService(serviceKey); err != nil {
 		return nil, 0, err
 	}
-	key := ctrl.serviceEntryPrefix(serviceKey)
-	watcher := clientv3.NewWatcher(ctrl.etcdClient)
-	defer watcher.Close()
+	defer metrics.WatchStarted("service")()
+	ctx, span := tracing.StartSpan(ctx, "services.Watch")
+	span.SetAttribute("service", serviceKey)
+	defer span.Finish()
 
-	var watchCh clientv3.WatchChan
-	if revision > 0 {
-		watchCh = watcher.Watch(ctx, key, clientv3.WithRev(revision), clientv3.WithPrefix())
-	} else {
-		watchCh = watcher.Watch(ctx, key, clientv3.WithPrefix())
+	resolved, aliasKey, err := ctrl.resolveAlias(ctx, clientIP, serviceKey)
+	if err != nil {
+		span.SetError(err)
+		return nil, 0, err
 	}
-
-	_ = <-watchCh
-	return ctrl._query(ctx, clientIP, serviceKey)
+	key := ctrl.serviceEntryPrefix(resolved)
+	revision, err = ctrl.watchStartRevision(ctx, key, revision)
+	if err != nil {
+		span.SetError(err)
+		return nil, 0, err
+	}
+	_, etcdSpan := startEtcdSpan(ctx, "Watch", key)
+	// maintenance changes are reported too, for the status of results
+	prefixes := []string{key, ctrl.maintenanceKey(resolved)}
+	if aliasKey != "" {
+		prefixes = append(prefixes, aliasKey)
+	}
+	if ctrl.config.LegacyKeyPrefix != "" {
+		prefixes = append(prefixes, ctrl.legacyEntryPrefix(resolved))
+	}
+	resp, ok := ctrl.watchEither(ctx, revision, prefixes...)
+	err = checkWatchResponse(ctx, resp, ok, revision-1)
+	etcdSpan.FinishWithError(err)
+	if err != nil {
+		span.SetError(err)
+		if e, ok := err.(*WatchError); ok {
+			return nil, e.Revision, e
+		}
+		return nil, 0, err
+	}
+	if aliasKey != "" {
+		// the alias cache may not have seen the change yet
+		if resolved, _, err = ctrl.resolveAlias(WithConsistentQuery(ctx), clientIP, serviceKey); err != nil {
+			span.SetError(err)
+			return nil, 0, err
+		}
+	}
+	ctrl.coalesceWait(ctx)
+	// the cache may not have seen the change yet
+	result, rev, err := ctrl._query(WithConsistentQuery(ctx), clientIP, resolved)
+	span.SetError(err)
+	return result, rev, err
 }
 
 // ServiceDescEvent desc event
@@ -343,24 +777,23 @@ type ServiceDescWatchResult struct {
 // WatchServiceDesc watch service desc
 func (ctrl *ServiceCtrl) WatchServiceDesc(ctx context.Context, zone string, revision int64) (*ServiceDescWatchResult, error) {
 	prefix := ctrl.serviceDescNotifyKeyPrefix(zone)
-	watcher := clientv3.NewWatcher(ctrl.etcdClient)
-	defer watcher.Close()
-
-	var watchCh clientv3.WatchChan
-	if revision > 0 {
-		watchCh = watcher.Watch(ctx, prefix, clientv3.WithRev(revision), clientv3.WithPrefix())
-	} else {
-		watchCh = watcher.Watch(ctx, prefix, clientv3.WithPrefix())
+	defer metrics.WatchStarted("service_desc")()
+	ctx, span := tracing.StartSpan(ctx, "services.WatchServiceDesc")
+	span.SetAttribute("zone", zone)
+	defer span.Finish()
+	revision, err := ctrl.watchStartRevision(ctx, prefix, revision)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
 	}
-
+	lastRevision := revision - 1
 	for {
-		resp, ok := <-watchCh
-		if !ok {
-			return nil, nil
-		}
-		if resp.Err() != nil {
-			return nil, utils.CleanErr(resp.Err(), "watch service desc fail", "watch service desc(zone:%s) fail: %v", zone, resp.Err())
+		resp, ok := ctrl.hub.Watch(ctx, prefix, lastRevision+1)
+		if err := checkWatchResponse(ctx, resp, ok, lastRevision); err != nil {
+			span.SetError(err)
+			return nil, err
 		}
+		lastRevision = resp.Header.Revision
 
 		events := make([]ServiceDescEvent, 0, 8)
 		for _, event := range resp.Events {
@@ -370,14 +803,14 @@ func (ctrl *ServiceCtrl) WatchServiceDesc(ctx context.Context, zone string, revi
 			if event.Type == clientv3.EventTypePut {
 				eventType = "put"
 				if err := json.Unmarshal(event.Kv.Value, &serviceDesc); err != nil {
-					glog.Errorf("unmarshal service desc(key: %s) fail: %v", string(event.Kv.Key), err)
+					logging.FromContext(ctx).Errorf("unmarshal service desc(key: %s) fail: %v", string(event.Kv.Key), err)
 					continue
 				}
 			} else if event.Type == clientv3.EventTypeDelete {
 				eventType = "delete"
 				key := ctrl.splitServiceDescNotifyKey(string(event.Kv.Key))
 				if key == nil {
-					glog.Warning("invalid service-desc key: %s", string(event.Kv.Key))
+					logging.FromContext(ctx).Warningf("invalid service-desc key: %s", string(event.Kv.Key))
 					continue
 				}
 				serviceDesc.Service = key.service
@@ -396,6 +829,14 @@ func (ctrl *ServiceCtrl) WatchServiceDesc(ctx context.Context, zone string, revi
 
 // Delete delete service
 func (ctrl *ServiceCtrl) Delete(ctx context.Context, serviceKey string, zone string) error {
+	ctx, span := tracing.StartSpan(ctx, "services.Delete")
+	span.SetAttribute("service", serviceKey)
+	err := ctrl.delete(ctx, serviceKey, zone)
+	span.FinishWithError(err)
+	return err
+}
+
+func (ctrl *ServiceCtrl) delete(ctx context.Context, serviceKey string, zone string) error {
 	entryPrefix := ctrl.serviceEntryPrefix(serviceKey)
 	if zone != "" {
 		entryPrefix += zone + "/"
@@ -403,14 +844,14 @@ func (ctrl *ServiceCtrl) Delete(ctx context.Context, serviceKey string, zone str
 	if resp, err := ctrl.etcdClient.Get(ctx, entryPrefix, clientv3.WithPrefix()); err == nil {
 		for _, kv := range resp.Kvs {
 			if !strings.HasSuffix(string(kv.Key), serviceDescNodeKey) {
-				return utils.NewError("HAS_ENDPOINTS", "has endpoints plugged on")
+				return utils.NewError(utils.EcodeHasEndpoints, "has endpoints plugged on")
 			}
 		}
 		if len(resp.Kvs) > 0 {
-			_, err := ctrl.etcdClient.Txn(ctx).Then([]clientv3.Op{
+			_, err := ctrl.txn(ctx, nil, []clientv3.Op{
 				clientv3.OpDelete(ctrl.serviceDescKey(serviceKey, zone)),
 				clientv3.OpDelete(ctrl.serviceDescNotifyKey(serviceKey, zone)),
-			}...).Commit()
+			})
 			if err != nil {
 				return utils.CleanErr(err, "delete service keys fail", "delete service keys(%s) fail: %v", entryPrefix, err)
 			}
@@ -418,5 +859,12 @@ func (ctrl *ServiceCtrl) Delete(ctx context.Context, serviceKey string, zone str
 	} else {
 		return utils.CleanErr(err, "get service keys fail", "precheck delete(%s) fail: %v", entryPrefix, err)
 	}
+	if dryRun := dryRunOf(ctx); dryRun != nil {
+		dryRun.note("owners and db items of the service are deleted if no zones are left")
+		return nil
+	}
+	if err := ctrl.deleteServiceOwners(ctx, serviceKey); err != nil {
+		return err
+	}
 	return ctrl.deleteServiceDBItems(serviceKey, zone)
 }
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+func TestPlugTimeOf(t *testing.T) {
+	ctrl, etcdClient, stop := newEtcdTestCtrl(t, nil)
+	defer stop()
+	ctx := context.Background()
+	address := "10.0.0.1:80"
+	descs := []ServiceDescV1{
+		{Service: "payments.core:1.0", Zone: "default"},
+		{Service: "payments.core:1.0", Zone: "backup"},
+	}
+	grant, err := etcdClient.Grant(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// plugged into the second desc only
+	if _, err := etcdClient.Put(ctx, ctrl.serviceNodeKey(descs[1].Service, descs[1].Zone, address),
+		`{"address":"10.0.0.1:80","plug_time":"2020-01-02T03:04:05Z"}`, clientv3.WithLease(grant.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	plugTime, err := ctrl.plugTimeOf(ctx, time.Minute, grant.ID, descs, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plugTime.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatalf("plug time not kept: %v", plugTime)
+	}
+	// plugged with another lease
+	plugTime, err = ctrl.plugTimeOf(ctx, time.Minute, grant.ID+1, descs, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(*plugTime) > time.Minute {
+		t.Fatalf("plug time of another lease kept: %v", plugTime)
+	}
+}
+
+func TestPlugWithOrigin(t *testing.T) {
+	ctrl, _, stop := newEtcdTestCtrl(t, nil)
+	defer stop()
+	// only mirrors label endpoints with their origin
+	_, err := ctrl.PlugAll(context.Background(), time.Minute, 0,
+		[]ServiceDescV1{{Service: "payments.core:1.0", Zone: "default"}},
+		&ServiceEndpoint{Address: "10.0.0.1:80", Origin: "dc2"}, InstanceConflictReplace)
+	if errCode(err) != utils.EcodeInvalidEndpoint {
+		t.Fatalf("plug with origin: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/tracing"
+)
+
+// PlugStatic plug permanent endpoint without lease, e.g. external databases or
+// third-party apis, marked static in query results
+func (ctrl *ServiceCtrl) PlugStatic(ctx context.Context, descs []ServiceDescV1, endpoint *ServiceEndpoint) error {
+	ctx, span := tracing.StartSpan(ctx, "services.PlugStatic")
+	span.SetAttribute("address", endpoint.Address)
+	static := *endpoint
+	static.Static = true
+	_, err := ctrl.plugAll(ctx, 0, 0, descs, &static, InstanceConflictReplace)
+	span.FinishWithError(err)
+	metrics.ServicePlugs.WithLabelValues(metrics.Result(err)).Inc()
+	return err
+}
+
+// PlugPermanent plug endpoint without lease like PlugStatic, but not marked static,
+// e.g. plugs of admins with ttl 0
+func (ctrl *ServiceCtrl) PlugPermanent(ctx context.Context, descs []ServiceDescV1,
+	endpoint *ServiceEndpoint, onConflict InstanceConflict) error {
+	ctx, span := tracing.StartSpan(ctx, "services.PlugPermanent")
+	span.SetAttribute("address", endpoint.Address)
+	permanent := *endpoint
+	permanent.Static = false
+	_, err := ctrl.plugAll(ctx, 0, 0, descs, &permanent, onConflict)
+	span.FinishWithError(err)
+	metrics.ServicePlugs.WithLabelValues(metrics.Result(err)).Inc()
+	return err
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+// ZoneEndpoints endpoints count of a service zone
+type ZoneEndpoints struct {
+	Service   string `json:"service"`
+	Zone      string `json:"zone"`
+	Endpoints int    `json:"endpoints"`
+}
+
+// EndpointCounts endpoints counts of all service zones,
+// zones with desc but no endpoints are counted as 0
+func (ctrl *ServiceCtrl) EndpointCounts(ctx context.Context) ([]ZoneEndpoints, error) {
+	prefix := ctrl.config.KeyPrefix + "/"
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "count endpoints fail", "count endpoints fail: %v", err)
+	}
+	var counts []ZoneEndpoints
+	index := make(map[string]int)
+	for _, kv := range resp.Kvs {
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		key := parts[0] + "/" + parts[1]
+		i, ok := index[key]
+		if !ok {
+			i = len(counts)
+			index[key] = i
+			counts = append(counts, ZoneEndpoints{Service: parts[0], Zone: parts[1]})
+		}
+		if strings.HasPrefix(parts[2], serviceKeyNodePrefix) {
+			counts[i].Endpoints++
+		}
+	}
+	return counts, nil
+}
+
+// ZoneEndpointCount endpoints count of service zone at revision, the current one if 0
+func (ctrl *ServiceCtrl) ZoneEndpointCount(ctx context.Context, service, zone string, revision int64) (int, error) {
+	prefix := ctrl.serviceEntryPrefix(service) + zone + "/" + serviceKeyNodePrefix
+	opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithCountOnly()}
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, opts...)
+	span.FinishWithError(err)
+	if err != nil {
+		return 0, utils.CleanErr(err, "count endpoints fail", "count endpoints(%s) fail: %v", prefix, err)
+	}
+	return int(resp.Count), nil
+}
+
+// Count endpoints count of service in zone, or all zones if zone is empty, with the revision;
+// a zone is counted without reading any keys, all zones by their keys only
+func (ctrl *ServiceCtrl) Count(ctx context.Context, service, zone string) (int64, int64, error) {
+	if err := checkService(service); err != nil {
+		return 0, 0, err
+	}
+	if zone != "" && !rValidZone.MatchString(zone) {
+		return 0, 0, utils.NewError(utils.EcodeInvalidZone, "")
+	}
+	prefix := ctrl.serviceEntryPrefix(service)
+	if zone != "" {
+		prefix += zone + "/" + serviceKeyNodePrefix
+		etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+		resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		span.FinishWithError(err)
+		if err != nil {
+			return 0, 0, utils.CleanErr(err, "count endpoints fail", "count endpoints(%s) fail: %v", prefix, err)
+		}
+		return resp.Count, resp.Header.Revision, nil
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return 0, 0, utils.CleanErr(err, "count endpoints fail", "count endpoints(%s) fail: %v", prefix, err)
+	}
+	var count int64
+	for _, kv := range resp.Kvs {
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[1], serviceKeyNodePrefix) {
+			count++
+		}
+	}
+	return count, resp.Header.Revision, nil
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/coreos/etcd/clientv3"
+	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/tracing"
+	"github.com/infrmods/xbus/utils"
+)
+
+// SyncState synced state of a service held by the syncing client,
+// Revision is the revision the state was synced at
+type SyncState struct {
+	Service  string `json:"service"`
+	Revision int64  `json:"revision,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// ZoneDelta changes of a service zone
+type ZoneDelta struct {
+	Zone    string            `json:"zone"`
+	Desc    *ServiceDescV1    `json:"desc,omitempty"`
+	Upserts []ServiceEndpoint `json:"upserts,omitempty"`
+	Removes []string          `json:"removes,omitempty"`
+	Deleted bool              `json:"deleted,omitempty"`
+}
+
+// ServiceDelta changes of a service since the client's state,
+// either Full or Zones is set unless the service is NotFound
+type ServiceDelta struct {
+	Service  string      `json:"service"`
+	Checksum string      `json:"checksum,omitempty"`
+	NotFound bool        `json:"not_found,omitempty"`
+	Full     *ServiceV1  `json:"full,omitempty"`
+	Zones    []ZoneDelta `json:"zones,omitempty"`
+}
+
+// SyncResult sync result, unchanged services are omitted
+type SyncResult struct {
+	Revision int64          `json:"revision"`
+	Deltas   []ServiceDelta `json:"deltas"`
+}
+
+const maxSyncTxnOps = 128
+
+// ServiceChecksum checksum of service, independent of zone and endpoint order
+func ServiceChecksum(service *ServiceV1) string {
+	h := sha256.New()
+	zones := make([]string, 0, len(service.Zones))
+	for zone := range service.Zones {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	for _, zone := range zones {
+		z := service.Zones[zone]
+		h.Write([]byte("zone\x00" + zone + "\x00" + z.Type + "\x00" + z.Proto + "\x00" + z.Description + "\x00"))
+		endpoints := make([]ServiceEndpoint, len(z.Endpoints))
+		copy(endpoints, z.Endpoints)
+		sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Address < endpoints[j].Address })
+		for _, endpoint := range endpoints {
+			h.Write([]byte("endpoint\x00" + endpoint.Address + "\x00" + endpoint.Config + "\x00"))
+			if endpoint.Sealed != nil {
+				data, _ := json.Marshal(endpoint.Sealed)
+				h.Write(data)
+			}
+			h.Write([]byte{0})
+			if endpoint.InstanceID != "" {
+				h.Write([]byte("instance\x00" + endpoint.InstanceID + "\x00"))
+			}
+			if endpoint.Static {
+				h.Write([]byte("static\x00"))
+			}
+			if endpoint.Locality != "" {
+				h.Write([]byte("locality\x00" + endpoint.Locality + "\x00"))
+			}
+			if endpoint.Origin != "" {
+				h.Write([]byte("origin\x00" + endpoint.Origin + "\x00"))
+			}
+			if endpoint.Priority != 0 {
+				h.Write([]byte("priority\x00" + strconv.Itoa(endpoint.Priority) + "\x00"))
+			}
+			if endpoint.PlugTime != nil {
+				h.Write([]byte("plug_time\x00" + strconv.FormatInt(endpoint.PlugTime.Unix(), 10) + "\x00"))
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func endpointEqual(a, b *ServiceEndpoint) bool {
+	if a.Address != b.Address || a.Config != b.Config || a.InstanceID != b.InstanceID ||
+		a.Static != b.Static || a.Locality != b.Locality || a.Origin != b.Origin ||
+		a.Priority != b.Priority {
+		return false
+	}
+	if (a.PlugTime == nil) != (b.PlugTime == nil) || (a.PlugTime != nil && !a.PlugTime.Equal(*b.PlugTime)) {
+		return false
+	}
+	if a.Sealed == nil || b.Sealed == nil {
+		return a.Sealed == b.Sealed
+	}
+	da, _ := json.Marshal(a.Sealed)
+	db, _ := json.Marshal(b.Sealed)
+	return string(da) == string(db)
+}
+
+func diffService(old, cur *ServiceV1) []ZoneDelta {
+	var deltas []ZoneDelta
+	for zone, z := range cur.Zones {
+		delta := ZoneDelta{Zone: zone}
+		oldZone := old.Zones[zone]
+		if oldZone == nil || oldZone.ServiceDescV1 != z.ServiceDescV1 {
+			desc := z.ServiceDescV1
+			delta.Desc = &desc
+		}
+		oldEndpoints := make(map[string]*ServiceEndpoint)
+		if oldZone != nil {
+			for i := range oldZone.Endpoints {
+				oldEndpoints[oldZone.Endpoints[i].Address] = &oldZone.Endpoints[i]
+			}
+		}
+		for i := range z.Endpoints {
+			endpoint := &z.Endpoints[i]
+			if e := oldEndpoints[endpoint.Address]; e == nil || !endpointEqual(e, endpoint) {
+				delta.Upserts = append(delta.Upserts, *endpoint)
+			}
+			delete(oldEndpoints, endpoint.Address)
+		}
+		for addr := range oldEndpoints {
+			delta.Removes = append(delta.Removes, addr)
+		}
+		sort.Strings(delta.Removes)
+		if delta.Desc != nil || len(delta.Upserts) > 0 || len(delta.Removes) > 0 {
+			deltas = append(deltas, delta)
+		}
+	}
+	for zone := range old.Zones {
+		if cur.Zones[zone] == nil {
+			deltas = append(deltas, ZoneDelta{Zone: zone, Deleted: true})
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].Zone < deltas[j].Zone })
+	return deltas
+}
+
+// snapshot kvs of service keys' prefixes at one revision
+func (ctrl *ServiceCtrl) snapshot(ctx context.Context, services []string) (map[string][]*mvccpb.KeyValue, int64, error) {
+	result := make(map[string][]*mvccpb.KeyValue, len(services))
+	var revision int64
+	for start := 0; start < len(services); start += maxSyncTxnOps {
+		end := start + maxSyncTxnOps
+		if end > len(services) {
+			end = len(services)
+		}
+		ops := make([]clientv3.Op, 0, end-start)
+		for _, service := range services[start:end] {
+			opts := []clientv3.OpOption{clientv3.WithPrefix()}
+			if revision > 0 {
+				opts = append(opts, clientv3.WithRev(revision))
+			}
+			ops = append(ops, clientv3.OpGet(ctrl.serviceEntryPrefix(service), opts...))
+		}
+		etcdCtx, span := startEtcdSpan(ctx, "Txn", ctrl.serviceEntryPrefix(services[start]))
+		resp, err := ctrl.etcdClient.Txn(etcdCtx).Then(ops...).Commit()
+		span.FinishWithError(err)
+		if err != nil {
+			return nil, 0, utils.CleanErr(err, "sync fail", "snapshot services fail: %v", err)
+		}
+		if revision == 0 {
+			revision = resp.Header.Revision
+		}
+		for i, r := range resp.Responses {
+			result[services[start+i]] = r.GetResponseRange().Kvs
+		}
+	}
+	return result, revision, nil
+}
+
+// Sync changes of services since the states held by the client
+func (ctrl *ServiceCtrl) Sync(ctx context.Context, clientIP net.IP, states []SyncState) (*SyncResult, error) {
+	ctx, span := tracing.StartSpan(ctx, "services.Sync")
+	defer span.Finish()
+	names := make([]string, 0, len(states))
+	for _, state := range states {
+		if err := checkService(state.Service); err != nil {
+			return nil, err
+		}
+		names = append(names, state.Service)
+	}
+	result := &SyncResult{Deltas: []ServiceDelta{}}
+	if len(names) == 0 {
+		return result, nil
+	}
+	kvsMap, revision, err := ctrl.snapshot(ctx, names)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	result.Revision = revision
+
+	for _, state := range states {
+		kvs := kvsMap[state.Service]
+		if len(kvs) == 0 {
+			if state.Checksum != "" || state.Revision == 0 {
+				result.Deltas = append(result.Deltas, ServiceDelta{Service: state.Service, NotFound: true})
+			}
+			continue
+		}
+		cur, err := ctrl.makeService(clientIP, state.Service, kvs)
+		if err != nil {
+			return nil, err
+		}
+		checksum := ServiceChecksum(cur)
+		if checksum == state.Checksum {
+			continue
+		}
+		delta := ServiceDelta{Service: state.Service, Checksum: checksum}
+		if zones, ok := ctrl.deltaSince(ctx, clientIP, state, cur); ok {
+			delta.Zones = zones
+		} else {
+			delta.Full = cur
+		}
+		result.Deltas = append(result.Deltas, delta)
+	}
+	return result, nil
+}
+
+// deltaSince zone deltas from the client's state, false if the state
+// can't be reproduced, e.g. compacted or mismatched checksum
+func (ctrl *ServiceCtrl) deltaSince(ctx context.Context, clientIP net.IP, state SyncState, cur *ServiceV1) ([]ZoneDelta, bool) {
+	if state.Revision <= 0 || state.Checksum == "" {
+		return nil, false
+	}
+	key := ctrl.serviceEntryPrefix(state.Service)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", key)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, key, clientv3.WithPrefix(), clientv3.WithRev(state.Revision))
+	span.FinishWithError(err)
+	if err != nil {
+		if err != v3rpc.ErrCompacted {
+			utils.CleanErr(err, "", "get %s at revision %d fail: %v", key, state.Revision, err)
+		}
+		return nil, false
+	}
+	old, err := ctrl.makeService(clientIP, state.Service, resp.Kvs)
+	if err != nil || ServiceChecksum(old) != state.Checksum {
+		return nil, false
+	}
+	return diffService(old, cur), true
+}
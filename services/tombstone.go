@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/utils"
+)
+
+// TombstoneConfig endpoints unplugged by api (not by lease expiry) are kept as tombstones
+// for Retention if Enable, and can be undeleted till then; unused leases of them are not
+// revoked either, so undeleted endpoints are bound to their leases again if still alive.
+// tombstones written within Retention/24 share a lease, so they are kept up to that longer
+type TombstoneConfig struct {
+	Enable    bool
+	Retention time.Duration `default:"24h"`
+}
+
+// Tombstone an unplugged endpoint kept for undelete
+type Tombstone struct {
+	Service    string           `json:"service"`
+	Zone       string           `json:"zone"`
+	Address    string           `json:"address"`
+	Endpoint   json.RawMessage  `json:"endpoint"`
+	LeaseID    clientv3.LeaseID `json:"lease_id"`
+	DeleteTime time.Time        `json:"delete_time"`
+	ExpireTime time.Time        `json:"expire_time"`
+}
+
+// UndeleteResult endpoints plugged back and the leases they are bound to
+type UndeleteResult struct {
+	Restored []LeaseNode        `json:"restored"`
+	Leases   []clientv3.LeaseID `json:"leases"`
+}
+
+// tombstoneLease lease shared by tombstones written within a window
+type tombstoneLease struct {
+	mu     sync.Mutex
+	id     clientv3.LeaseID
+	until  time.Time
+	expire time.Time
+}
+
+// get the lease shared until now+window, granted with ttl window+retention if none
+func (lease *tombstoneLease) get(ctx context.Context, etcdClient *clientv3.Client,
+	retention time.Duration) (clientv3.LeaseID, time.Time, error) {
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+	now := time.Now()
+	if lease.id != 0 && now.Before(lease.until) {
+		return lease.id, lease.expire, nil
+	}
+	window := retention / 24
+	if window < time.Second {
+		window = time.Second
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Grant", "")
+	resp, err := etcdClient.Grant(etcdCtx, int64((retention + window).Seconds()))
+	span.FinishWithError(err)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	lease.id, lease.until = resp.ID, now.Add(window)
+	lease.expire = now.Add(time.Duration(resp.TTL) * time.Second)
+	return lease.id, lease.expire, nil
+}
+
+// reset drop the shared lease if it's id, e.g. not found
+func (lease *tombstoneLease) reset(id clientv3.LeaseID) {
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+	if lease.id == id {
+		lease.id = 0
+	}
+}
+
+func (ctrl *ServiceCtrl) tombstoneKeyPrefix() string {
+	return ctrl.config.KeyPrefix + "-tombstones/"
+}
+
+func (ctrl *ServiceCtrl) tombstoneKey(service, zone, addr string) string {
+	return ctrl.tombstoneKeyPrefix() + service + "/" + zone + "/" + addr
+}
+
+// writeTombstones keep deleted endpoint keys as tombstones under the shared lease of the retention
+func (ctrl *ServiceCtrl) writeTombstones(ctx context.Context, kvs []*mvccpb.KeyValue) {
+	if !ctrl.config.Tombstones.Enable || len(kvs) == 0 {
+		return
+	}
+	retention := ctrl.config.Tombstones.Retention
+	if retention < time.Second {
+		retention = 24 * time.Hour
+	}
+	for i := 0; i < 2; i++ {
+		leaseID, expire, err := ctrl.tombstoneLease.get(ctx, ctrl.etcdClient, retention)
+		if err != nil {
+			logging.FromContext(ctx).Warningf("grant lease of tombstones fail: %v", err)
+			return
+		}
+		err = ctrl.putTombstones(ctx, kvs, leaseID, expire)
+		if err == nil {
+			return
+		}
+		if err != v3rpc.ErrLeaseNotFound {
+			logging.FromContext(ctx).Warningf("put tombstones fail: %v", err)
+			return
+		}
+		// revoked, e.g. by admins
+		ctrl.tombstoneLease.reset(leaseID)
+	}
+}
+
+func (ctrl *ServiceCtrl) putTombstones(ctx context.Context, kvs []*mvccpb.KeyValue,
+	leaseID clientv3.LeaseID, expire time.Time) error {
+	prefix := ctrl.config.KeyPrefix + "/"
+	now := time.Now()
+	var keys, values []string
+	for _, kv := range kvs {
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[2], serviceKeyNodePrefix) || !json.Valid(kv.Value) {
+			continue
+		}
+		tombstone := Tombstone{Service: parts[0], Zone: parts[1],
+			Address: strings.TrimPrefix(parts[2], serviceKeyNodePrefix), Endpoint: kv.Value,
+			LeaseID: clientv3.LeaseID(kv.Lease), DeleteTime: now, ExpireTime: expire}
+		data, err := json.Marshal(tombstone)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, ctrl.tombstoneKey(tombstone.Service, tombstone.Zone, tombstone.Address))
+		values = append(values, string(data))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	ops := make([]clientv3.Op, 0, len(keys))
+	for i, key := range keys {
+		ops = append(ops, clientv3.OpPut(key, values[i], clientv3.WithLease(leaseID)))
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Txn", ctrl.tombstoneKeyPrefix())
+	_, err := ctrl.etcdClient.Txn(etcdCtx).Then(ops...).Commit()
+	span.FinishWithError(err)
+	return err
+}
+
+// ListTombstones tombstones of service (all zones if zone is empty), all services if service is empty
+func (ctrl *ServiceCtrl) ListTombstones(ctx context.Context, service, zone string) ([]Tombstone, error) {
+	prefix := ctrl.tombstoneKeyPrefix()
+	if service != "" {
+		if err := checkService(service); err != nil {
+			return nil, err
+		}
+		prefix += service + "/"
+		if zone != "" {
+			prefix += zone + "/"
+		}
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix,
+		clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "list tombstones fail", "get tombstones(%s) fail: %v", prefix, err)
+	}
+	tombstones := make([]Tombstone, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var tombstone Tombstone
+		if err := json.Unmarshal(kv.Value, &tombstone); err != nil {
+			logging.Warningf("invalid tombstone(%s): %v", kv.Key, err)
+			continue
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	return tombstones, nil
+}
+
+// Undelete plug back tombstoned endpoints of service, those of zone if not empty and the one
+// at addr if not empty; endpoints are bound to their leases if still alive, or new ones of ttl,
+// permanent (leaseless) endpoints are plugged back without lease
+func (ctrl *ServiceCtrl) Undelete(ctx context.Context, service, zone, addr string, ttl time.Duration) (*UndeleteResult, error) {
+	if addr != "" && zone == "" {
+		return nil, utils.NewError(utils.EcodeInvalidZone, "zone is required with address")
+	}
+	if zone != "" {
+		if err := checkServiceZone(service, zone); err != nil {
+			return nil, err
+		}
+	}
+	tombstones, err := ctrl.ListTombstones(ctx, service, zone)
+	if err != nil {
+		return nil, err
+	}
+	if addr != "" {
+		found := tombstones[:0]
+		for _, tombstone := range tombstones {
+			if tombstone.Address == addr {
+				found = append(found, tombstone)
+			}
+		}
+		tombstones = found
+	}
+	if len(tombstones) == 0 {
+		return nil, utils.Errorf(utils.EcodeNotFound, "no tombstones of %s", service)
+	}
+
+	result := &UndeleteResult{Restored: []LeaseNode{}, Leases: []clientv3.LeaseID{}}
+	alive := make(map[clientv3.LeaseID]bool)
+	var newLease clientv3.LeaseID
+	for _, tombstone := range tombstones {
+		leaseID := tombstone.LeaseID
+		permanent := leaseID == 0
+		if !permanent {
+			ok, checked := alive[leaseID]
+			if !checked {
+				etcdCtx, span := startEtcdSpan(ctx, "TimeToLive", "")
+				resp, err := ctrl.etcdClient.TimeToLive(etcdCtx, leaseID)
+				span.FinishWithError(err)
+				if err != nil && err != v3rpc.ErrLeaseNotFound {
+					return nil, utils.CleanErr(err, "undelete fail", "get lease(%d) fail: %v", leaseID, err)
+				}
+				ok = err == nil && resp.TTL > 0
+				alive[leaseID] = ok
+			}
+			if !ok {
+				leaseID = 0
+			}
+		}
+		if leaseID == 0 && !permanent {
+			if newLease == 0 {
+				etcdCtx, span := startEtcdSpan(ctx, "Grant", "")
+				resp, err := ctrl.etcdClient.Grant(etcdCtx, int64(ttl.Seconds()))
+				span.FinishWithError(err)
+				if err != nil {
+					return nil, utils.CleanErr(err, "create lease fail", "create lease fail: %v", err)
+				}
+				newLease = clientv3.LeaseID(resp.ID)
+			}
+			leaseID = newLease
+		}
+
+		nodeKey := ctrl.serviceNodeKey(tombstone.Service, tombstone.Zone, tombstone.Address)
+		descKey := ctrl.serviceDescKey(tombstone.Service, tombstone.Zone)
+		var opts []clientv3.OpOption
+		if leaseID != 0 {
+			opts = append(opts, clientv3.WithLease(leaseID))
+		}
+		etcdCtx, span := startEtcdSpan(ctx, "Txn", nodeKey)
+		resp, err := ctrl.etcdClient.Txn(etcdCtx).If(
+			clientv3.Compare(clientv3.CreateRevision(nodeKey), "=", 0),
+			clientv3.Compare(clientv3.CreateRevision(descKey), ">", 0),
+		).Then(
+			clientv3.OpPut(nodeKey, string(tombstone.Endpoint), opts...),
+			clientv3.OpDelete(ctrl.tombstoneKey(tombstone.Service, tombstone.Zone, tombstone.Address)),
+		).Commit()
+		span.FinishWithError(err)
+		if err != nil {
+			return nil, utils.CleanErr(err, "undelete fail", "undelete endpoint(%s) fail: %v", nodeKey, err)
+		}
+		if !resp.Succeeded {
+			logging.FromContext(ctx).Infof("skip undelete of %s, plugged again or zone deleted", nodeKey)
+			continue
+		}
+		result.Restored = append(result.Restored,
+			LeaseNode{Service: tombstone.Service, Zone: tombstone.Zone, Address: tombstone.Address})
+		found := leaseID == 0
+		for _, id := range result.Leases {
+			found = found || id == leaseID
+		}
+		if !found {
+			result.Leases = append(result.Leases, leaseID)
+		}
+	}
+	if newLease != 0 && len(result.Restored) == 0 {
+		ctrl.revokeIfUnused(ctx, newLease)
+	}
+	logging.FromContext(ctx).Infof("undeleted %d endpoints of %s", len(result.Restored), service)
+	return result, nil
+}
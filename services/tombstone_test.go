@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/gocomm/config"
+	"github.com/infrmods/xbus/utils/etcdtest"
+)
+
+func newEtcdTestCtrl(t *testing.T, setup func(*Config)) (*ServiceCtrl, *clientv3.Client, func()) {
+	etcdClient, stop := etcdtest.Start(t)
+	var cfg Config
+	if err := config.DefaultConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if setup != nil {
+		setup(&cfg)
+	}
+	ctrl, err := NewServiceCtrl(&cfg, nil, etcdClient)
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	return ctrl, etcdClient, stop
+}
+
+func TestTombstones(t *testing.T) {
+	ctrl, etcdClient, stop := newEtcdTestCtrl(t, func(cfg *Config) {
+		cfg.Tombstones.Enable = true
+		cfg.Tombstones.Retention = time.Hour
+	})
+	defer stop()
+	ctx := context.Background()
+	service, zone := "payments.core:1.0", "default"
+	if _, err := etcdClient.Put(ctx, ctrl.serviceDescKey(service, zone), `{"type":"http"}`); err != nil {
+		t.Fatal(err)
+	}
+	grant, err := etcdClient.Grant(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a permanent endpoint and one bound to a lease
+	if _, err := etcdClient.Put(ctx, ctrl.serviceNodeKey(service, zone, "10.0.0.1:80"),
+		`{"address":"10.0.0.1:80"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := etcdClient.Put(ctx, ctrl.serviceNodeKey(service, zone, "10.0.0.2:80"),
+		`{"address":"10.0.0.2:80"}`, clientv3.WithLease(grant.ID)); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"10.0.0.1:80", "10.0.0.2:80"} {
+		if err := ctrl.Unplug(ctx, service, zone, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := etcdClient.Get(ctx, ctrl.tombstoneKeyPrefix(), clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) != 2 || resp.Kvs[0].Lease == 0 || resp.Kvs[0].Lease != resp.Kvs[1].Lease {
+		t.Fatalf("tombstones not sharing a lease: %v", resp.Kvs)
+	}
+
+	result, err := ctrl.Undelete(ctx, service, "", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Restored) != 2 || len(result.Leases) != 1 || result.Leases[0] != grant.ID {
+		t.Fatalf("unexpected undelete result: %+v", result)
+	}
+	for addr, lease := range map[string]int64{"10.0.0.1:80": 0, "10.0.0.2:80": int64(grant.ID)} {
+		resp, err := etcdClient.Get(ctx, ctrl.serviceNodeKey(service, zone, addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Kvs) != 1 || resp.Kvs[0].Lease != lease {
+			t.Errorf("endpoint %s not restored with lease %d: %v", addr, lease, resp.Kvs)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package services
+
+import (
+	"context"
+
+	"github.com/infrmods/xbus/tracing"
+)
+
+func startEtcdSpan(ctx context.Context, op, key string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.StartSpan(ctx, "etcd."+op)
+	if key != "" {
+		span.SetAttribute("key", key)
+	}
+	return ctx, span
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+// address uniqueness policies
+const (
+	// DuplicateAddressReject reject plugging an address plugged by another lease
+	DuplicateAddressReject = "reject"
+	// DuplicateAddressReplace replace the address plugged in other zones
+	DuplicateAddressReplace = "replace"
+)
+
+// UniqueAddressPolicy address uniqueness policy of matched services,
+// an address should be plugged once under a service
+type UniqueAddressPolicy struct {
+	Services    string `yaml:"services"`
+	OnDuplicate string `yaml:"on_duplicate"`
+	servicesR   *regexp.Regexp
+}
+
+func prepareUniqueAddressPolicies(policies []UniqueAddressPolicy) error {
+	for i := range policies {
+		policy := &policies[i]
+		r, err := regexp.Compile(policy.Services)
+		if err != nil {
+			return fmt.Errorf("invalid unique address services: %s", policy.Services)
+		}
+		policy.servicesR = r
+		switch policy.OnDuplicate {
+		case "":
+			policy.OnDuplicate = DuplicateAddressReject
+		case DuplicateAddressReject, DuplicateAddressReplace:
+		default:
+			return fmt.Errorf("invalid unique address on_duplicate: %s", policy.OnDuplicate)
+		}
+	}
+	return nil
+}
+
+func (config *Config) uniqueAddressPolicyOf(service string) *UniqueAddressPolicy {
+	for i := range config.UniqueAddress {
+		if config.UniqueAddress[i].servicesR.MatchString(service) {
+			return &config.UniqueAddress[i]
+		}
+	}
+	return nil
+}
+
+// uniqueAddressOps check the address is plugged once under services with a uniqueness policy,
+// deletes of the address in other zones if replacing, guarded by cmps of the checked keys
+func (ctrl *ServiceCtrl) uniqueAddressOps(ctx context.Context, descs []ServiceDescV1,
+	endpoint *ServiceEndpoint, leaseID clientv3.LeaseID) ([]clientv3.Cmp, []clientv3.Op, error) {
+	zonesOf := make(map[string]map[string]bool)
+	for _, desc := range descs {
+		if zonesOf[desc.Service] == nil {
+			zonesOf[desc.Service] = make(map[string]bool)
+		}
+		zonesOf[desc.Service][desc.Zone] = true
+	}
+
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	suffix := "/" + serviceKeyNodePrefix + endpoint.Address
+	for service, zones := range zonesOf {
+		policy := ctrl.policies().uniqueAddressPolicyOf(service)
+		if policy == nil {
+			continue
+		}
+		prefix := ctrl.serviceEntryPrefix(service)
+		etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+		resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+		span.FinishWithError(err)
+		if err != nil {
+			return nil, nil, utils.CleanErr(err, "plug service fail", "get nodes(%s) fail: %v", prefix, err)
+		}
+		plugged := make(map[string]bool)
+		for _, kv := range resp.Kvs {
+			key := string(kv.Key)
+			if !strings.HasSuffix(key, suffix) {
+				continue
+			}
+			zone := strings.TrimSuffix(strings.TrimPrefix(key, prefix), suffix)
+			plugged[zone] = true
+			if policy.OnDuplicate == DuplicateAddressReject {
+				if clientv3.LeaseID(kv.Lease) != leaseID {
+					return nil, nil, utils.Errorf(utils.EcodeDuplicateAddress,
+						"%s already plugged in %s/%s", endpoint.Address, service, zone)
+				}
+				cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision))
+			} else if !zones[zone] {
+				cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision))
+				ops = append(ops, clientv3.OpDelete(key))
+			}
+		}
+		if policy.OnDuplicate == DuplicateAddressReject {
+			// nobody else plugs the address meanwhile
+			for zone := range zones {
+				if !plugged[zone] {
+					key := ctrl.serviceNodeKey(service, zone, endpoint.Address)
+					cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", 0))
+				}
+			}
+		}
+	}
+	return cmps, ops, nil
+}
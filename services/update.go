@@ -0,0 +1,236 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/tracing"
+	"github.com/infrmods/xbus/utils"
+)
+
+// EndpointRevision endpoint with the mod revision of its key (see UpdateIfVersion),
+// TTL is the remaining ttl of its lease in seconds, 0 for static endpoints
+type EndpointRevision struct {
+	Endpoint    ServiceEndpoint  `json:"endpoint"`
+	ModRevision int64            `json:"mod_revision"`
+	LeaseID     clientv3.LeaseID `json:"lease_id"`
+	TTL         int64            `json:"ttl,omitempty"`
+}
+
+// GetEndpoint endpoint of addr in service zone, with its mod revision and lease ttl
+func (ctrl *ServiceCtrl) GetEndpoint(ctx context.Context, service, zone, addr string) (*EndpointRevision, error) {
+	result, err := ctrl.getEndpoint(ctx, service, zone, addr)
+	if err != nil || result.LeaseID == 0 {
+		return result, err
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "TimeToLive", "")
+	resp, err := ctrl.etcdClient.TimeToLive(etcdCtx, result.LeaseID)
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get lease fail", "get lease(%d) ttl fail: %v", result.LeaseID, err)
+	}
+	result.TTL = resp.TTL
+	return result, nil
+}
+
+func (ctrl *ServiceCtrl) getEndpoint(ctx context.Context, service, zone, addr string) (*EndpointRevision, error) {
+	if err := checkServiceZone(service, zone); err != nil {
+		return nil, err
+	}
+	if err := ctrl.checkAddress(addr); err != nil {
+		return nil, err
+	}
+	nodeKey := ctrl.serviceNodeKey(service, zone, addr)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", nodeKey)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, nodeKey)
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "get endpoint fail", "get endpoint(%s) fail: %v", nodeKey, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, utils.Errorf(utils.EcodeNotFound, "endpoint %s not found", addr)
+	}
+	kv := resp.Kvs[0]
+	result := EndpointRevision{ModRevision: kv.ModRevision, LeaseID: clientv3.LeaseID(kv.Lease)}
+	if err := json.Unmarshal(kv.Value, &result.Endpoint); err != nil {
+		return nil, utils.Errorf(utils.EcodeDamagedEndpointValue, "invalid endpoint value of %s", addr)
+	}
+	return &result, nil
+}
+
+// UpdateIfVersion update endpoint of addr in service zone if its key is unchanged since
+// expectedModRevision, keeping its lease, otherwise fails with ENDPOINT_CHANGED so concurrent
+// updaters don't overwrite each other; address, instance id, static, origin and plug time are kept,
+// returns the new mod revision
+func (ctrl *ServiceCtrl) UpdateIfVersion(ctx context.Context, service, zone, addr string,
+	expectedModRevision int64, endpoint *ServiceEndpoint) (int64, error) {
+	ctx, span := tracing.StartSpan(ctx, "services.UpdateIfVersion")
+	span.SetAttribute("service", service)
+	rev, err := ctrl.updateIfVersion(ctx, service, zone, addr, expectedModRevision, endpoint)
+	span.FinishWithError(err)
+	metrics.ServiceUpdates.WithLabelValues(metrics.Result(err)).Inc()
+	return rev, err
+}
+
+func (ctrl *ServiceCtrl) updateIfVersion(ctx context.Context, service, zone, addr string,
+	expectedModRevision int64, endpoint *ServiceEndpoint) (int64, error) {
+	if expectedModRevision <= 0 {
+		return 0, utils.NewError(utils.EcodeInvalidParam, "invalid mod revision")
+	}
+	if endpoint.Address != "" && endpoint.Address != addr {
+		return 0, utils.NewError(utils.EcodeInvalidEndpoint, "address can't be changed")
+	}
+	if endpoint.Locality != "" && !rValidZone.MatchString(endpoint.Locality) {
+		return 0, utils.NewError(utils.EcodeInvalidEndpoint, "invalid locality")
+	}
+	if err := checkPriority(endpoint); err != nil {
+		return 0, err
+	}
+	prev, err := ctrl.getEndpoint(ctx, service, zone, addr)
+	if err != nil {
+		return 0, err
+	}
+	if prev.ModRevision != expectedModRevision {
+		return 0, endpointChanged(addr, prev.ModRevision)
+	}
+	if err := ctrl.checkSealed(ctx, &ServiceDescV1{Service: service, Zone: zone}, endpoint, prev.Endpoint.Sealed); err != nil {
+		return 0, err
+	}
+	if err := ctrl.checkConfigSchema(ctx, service, endpoint); err != nil {
+		return 0, err
+	}
+	if endpoint.InstanceID != "" && endpoint.InstanceID != prev.Endpoint.InstanceID {
+		return 0, utils.NewError(utils.EcodeInvalidEndpoint, "instance id can't be changed")
+	}
+	updated := *endpoint
+	updated.Address = addr
+	updated.InstanceID = prev.Endpoint.InstanceID
+	updated.Static = prev.Endpoint.Static
+	updated.Origin = prev.Endpoint.Origin
+	updated.PlugTime = prev.Endpoint.PlugTime
+	data, err := updated.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	nodeKey := ctrl.serviceNodeKey(service, zone, addr)
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(nodeKey), "=", expectedModRevision)}
+	var opPut clientv3.Op
+	if prev.LeaseID > 0 {
+		opPut = clientv3.OpPut(nodeKey, string(data), clientv3.WithLease(prev.LeaseID))
+	} else {
+		opPut = clientv3.OpPut(nodeKey, string(data))
+	}
+	etcdCtx, span := startEtcdSpan(ctx, "Txn", nodeKey)
+	if dryRunOf(ctx) != nil {
+		succeeded, err := ctrl.txn(etcdCtx, cmps, []clientv3.Op{opPut})
+		span.FinishWithError(err)
+		if err != nil {
+			return 0, utils.CleanErr(err, "update endpoint fail", "dry run update endpoint(%s) fail: %v", nodeKey, err)
+		}
+		if !succeeded {
+			return 0, utils.Errorf(utils.EcodeEndpointChanged, "endpoint %s changed", addr)
+		}
+		return 0, nil
+	}
+	resp, err := ctrl.etcdClient.Txn(etcdCtx).If(cmps...).Then(opPut).Else(clientv3.OpGet(nodeKey)).Commit()
+	span.FinishWithError(err)
+	if err != nil {
+		return 0, utils.CleanErr(err, "update endpoint fail", "update endpoint(%s) fail: %v", nodeKey, err)
+	}
+	if !resp.Succeeded {
+		kvs := resp.Responses[0].GetResponseRange().Kvs
+		if len(kvs) == 0 {
+			return 0, utils.Errorf(utils.EcodeNotFound, "endpoint %s not found", addr)
+		}
+		return 0, endpointChanged(addr, kvs[0].ModRevision)
+	}
+	return resp.Header.Revision, nil
+}
+
+func endpointChanged(addr string, modRevision int64) error {
+	return utils.Errorf(utils.EcodeEndpointChanged, "endpoint %s changed at revision %d", addr, modRevision)
+}
+
+// fields kept by updates, see UpdateIfVersion
+var immutableEndpointFields = []string{"address", "instance_id", "static", "origin", "plug_time"}
+
+// applyEndpointPatch apply patch to top level fields of endpoint, null removes a field
+func applyEndpointPatch(endpoint *ServiceEndpoint, patch map[string]json.RawMessage) (*ServiceEndpoint, error) {
+	data, err := endpoint.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, utils.NewSystemError("unmarshal endpoint fail")
+	}
+	for name, value := range patch {
+		for _, field := range immutableEndpointFields {
+			if name == field {
+				return nil, utils.Errorf(utils.EcodeInvalidParam, "%s can't be patched", name)
+			}
+		}
+		if string(bytes.TrimSpace(value)) == "null" {
+			delete(fields, name)
+		} else {
+			fields[name] = value
+		}
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "invalid patch: %v", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var patched ServiceEndpoint
+	if err := decoder.Decode(&patched); err != nil {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "invalid patch: %v", err)
+	}
+	return &patched, nil
+}
+
+// PatchEndpoint update only the fields in patch of endpoint of addr in service zone, e.g.
+// {"locality": "us-east-1a"}, by read-modify-write transactions; concurrent changes are
+// retried unless expectedModRevision is given (not 0), then fails with ENDPOINT_CHANGED
+func (ctrl *ServiceCtrl) PatchEndpoint(ctx context.Context, service, zone, addr string,
+	expectedModRevision int64, patch map[string]json.RawMessage) (*EndpointRevision, error) {
+	ctx, span := tracing.StartSpan(ctx, "services.PatchEndpoint")
+	span.SetAttribute("service", service)
+	result, err := ctrl.patchEndpoint(ctx, service, zone, addr, expectedModRevision, patch)
+	span.FinishWithError(err)
+	metrics.ServiceUpdates.WithLabelValues(metrics.Result(err)).Inc()
+	return result, err
+}
+
+func (ctrl *ServiceCtrl) patchEndpoint(ctx context.Context, service, zone, addr string,
+	expectedModRevision int64, patch map[string]json.RawMessage) (*EndpointRevision, error) {
+	if len(patch) == 0 {
+		return nil, utils.NewError(utils.EcodeInvalidParam, "empty patch")
+	}
+	for attempt := 1; ; attempt++ {
+		prev, err := ctrl.getEndpoint(ctx, service, zone, addr)
+		if err != nil {
+			return nil, err
+		}
+		if expectedModRevision != 0 && prev.ModRevision != expectedModRevision {
+			return nil, endpointChanged(addr, prev.ModRevision)
+		}
+		patched, err := applyEndpointPatch(&prev.Endpoint, patch)
+		if err != nil {
+			return nil, err
+		}
+		rev, err := ctrl.updateIfVersion(ctx, service, zone, addr, prev.ModRevision, patched)
+		if err == nil {
+			return &EndpointRevision{Endpoint: *patched, ModRevision: rev, LeaseID: prev.LeaseID}, nil
+		}
+		if utils.CodeOf(err) != utils.EcodeEndpointChanged || expectedModRevision != 0 {
+			return nil, err
+		}
+		if attempt >= maxPlugAttempts {
+			return nil, utils.NewError(utils.EcodeTooManyAttempts, "endpoint changed concurrently")
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/utils"
+)
+
+func splitService(service string) (string, string) {
+	if i := strings.Index(service, ":"); i >= 0 {
+		return service[:i], service[i+1:]
+	}
+	return service, ""
+}
+
+// compareVersion compare dot separated versions, numeric parts compare numerically
+func compareVersion(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseInt(as[i], 10, 64)
+		bn, berr := strconv.ParseInt(bs[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aerr == nil:
+			return 1
+		case berr == nil:
+			return -1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// VersionLatest version range matching every version, resolved to the highest
+const VersionLatest = "latest"
+
+type versionConstraint struct {
+	op      string
+	version string
+}
+
+// versionRange space separated constraints, e.g. `>=1.2 <2.0`, or latest
+type versionRange []versionConstraint
+
+var versionOps = []string{">=", "<=", "==", ">", "<", "="}
+
+func parseVersionRange(s string) (versionRange, error) {
+	s = strings.TrimSpace(s)
+	if s == VersionLatest || s == "*" {
+		return versionRange{}, nil
+	}
+	var r versionRange
+	for _, part := range strings.Fields(strings.Replace(s, ",", " ", -1)) {
+		c := versionConstraint{op: "=", version: part}
+		for _, op := range versionOps {
+			if strings.HasPrefix(part, op) {
+				c.op, c.version = op, strings.TrimPrefix(part, op)
+				break
+			}
+		}
+		if c.op == "==" {
+			c.op = "="
+		}
+		if !validNames().version.MatchString(c.version) {
+			return nil, utils.Errorf(utils.EcodeInvalidParam, "invalid version range: %s", s)
+		}
+		r = append(r, c)
+	}
+	if len(r) == 0 {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "invalid version range: %s", s)
+	}
+	return r, nil
+}
+
+func (r versionRange) match(version string) bool {
+	for _, c := range r {
+		n := compareVersion(version, c.version)
+		switch c.op {
+		case "=":
+			if n != 0 {
+				return false
+			}
+		case ">":
+			if n <= 0 {
+				return false
+			}
+		case ">=":
+			if n < 0 {
+				return false
+			}
+		case "<":
+			if n >= 0 {
+				return false
+			}
+		case "<=":
+			if n > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ResolveVersion highest version of service name with endpoints matching versionRange,
+// e.g. `>=1.2 <2.0` or latest, returns the full service name:version
+func (ctrl *ServiceCtrl) ResolveVersion(ctx context.Context, name, versionRange string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	r, err := parseVersionRange(versionRange)
+	if err != nil {
+		return "", err
+	}
+	prefix := ctrl.config.KeyPrefix + "/" + name + ":"
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return "", utils.CleanErr(err, "resolve version fail", "get service keys(%s) fail: %v", prefix, err)
+	}
+	best := ""
+	for _, kv := range resp.Kvs {
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix), "/", 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[2], serviceKeyNodePrefix) {
+			continue
+		}
+		if version := parts[0]; r.match(version) && (best == "" || compareVersion(version, best) > 0) {
+			best = version
+		}
+	}
+	if best == "" {
+		return "", utils.Errorf(utils.EcodeNotFound, "no version of %s matches %s", name, versionRange)
+	}
+	return name + ":" + best, nil
+}
+
+// QueryVersion query the version of service name resolved by ResolveVersion
+func (ctrl *ServiceCtrl) QueryVersion(ctx context.Context, clientIP net.IP, name, versionRange string) (*ServiceV1, int64, error) {
+	service, err := ctrl.ResolveVersion(ctx, name, versionRange)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ctrl.Query(ctx, clientIP, service)
+}
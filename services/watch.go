@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coreos/etcd/clientv3"
+	v3rpc "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/infrmods/xbus/utils"
+)
+
+// WatchError watch fail error
+//
+// Revision is the last revision observed, watch again with Revision+1 to resume;
+// on REVISION_COMPACTED history after Revision is lost, query to re-sync instead
+type WatchError struct {
+	Code     string `json:"code"`
+	Message  string `json:"message,omitempty"`
+	Revision int64  `json:"revision"`
+	// Retryable hint for clients like utils.Error, set by api responses
+	Retryable bool `json:"retryable,omitempty"`
+}
+
+func (e *WatchError) Error() string {
+	return fmt.Sprintf("[%s]: %s (revision: %d)", e.Code, e.Message, e.Revision)
+}
+
+// ErrorCode impl utils.Coder
+func (e *WatchError) ErrorCode() string {
+	return e.Code
+}
+
+// Unwrap *utils.Error of the code and message, so that errors.Is matches them the same
+func (e *WatchError) Unwrap() error {
+	return &utils.Error{Code: e.Code, Message: e.Message}
+}
+
+func ctxWatchError(ctx context.Context, revision int64) *WatchError {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &WatchError{Code: utils.EcodeDeadlineExceeded, Message: "watch timeout", Revision: revision}
+	}
+	return &WatchError{Code: utils.EcodeCanceled, Message: "watch canceled", Revision: revision}
+}
+
+// checkWatchResponse convert a failed watch response to *WatchError,
+// revision is the last revision observed by the watcher
+func checkWatchResponse(ctx context.Context, resp clientv3.WatchResponse, ok bool, revision int64) error {
+	if !ok || ctx.Err() != nil {
+		return ctxWatchError(ctx, revision)
+	}
+	if resp.CompactRevision != 0 || resp.Err() == v3rpc.ErrCompacted {
+		return &WatchError{
+			Code:     utils.EcodeRevisionCompacted,
+			Message:  "revision compacted",
+			Revision: resp.CompactRevision,
+		}
+	}
+	if err := resp.Err(); err != nil {
+		return utils.CleanErr(err, "watch fail", "watch fail: %v", err)
+	}
+	if resp.Canceled {
+		return &WatchError{Code: utils.EcodeEtcdWatchFailed, Message: "watch canceled by etcd", Revision: revision}
+	}
+	return nil
+}
+
+// watchStartRevision revision to start watching prefix from,
+// resolves the current revision if not specified so timeouts can be resumed
+func (ctrl *ServiceCtrl) watchStartRevision(ctx context.Context, prefix string, revision int64) (int64, error) {
+	if revision > 0 {
+		return revision, nil
+	}
+	resp, err := ctrl.etcdClient.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctxWatchError(ctx, 0)
+		}
+		return 0, utils.CleanErr(err, "watch fail", "get revision of %s fail: %v", prefix, err)
+	}
+	return resp.Header.Revision + 1, nil
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/tracing"
+	"github.com/infrmods/xbus/utils"
+)
+
+// MultiWatchResult changed services of a multi watch, tagged by their refs
+type MultiWatchResult struct {
+	Revision int64            `json:"revision"`
+	Services []MultiQueryItem `json:"services"`
+}
+
+var rValidServicePrefix = regexp.MustCompile(`(?i)^[a-z][a-z0-9_.:-]*$`)
+
+// multiWatch services watched by refs or names prefix
+type multiWatch struct {
+	refs    []ServiceRef
+	prefix  string
+	visible func(service string) bool
+}
+
+func (w *multiWatch) keyPrefix(ctrl *ServiceCtrl) string {
+	return ctrl.config.KeyPrefix + "/" + w.prefix
+}
+
+// changed refs of events, in refs order, refs of services themselves with prefix
+func (w *multiWatch) changed(ctrl *ServiceCtrl, events []*clientv3.Event) []ServiceRef {
+	zones := make(map[string]map[string]bool)
+	for _, event := range events {
+		parts := strings.SplitN(strings.TrimPrefix(string(event.Kv.Key), ctrl.config.KeyPrefix+"/"), "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if zones[parts[0]] == nil {
+			zones[parts[0]] = make(map[string]bool)
+		}
+		zones[parts[0]][parts[1]] = true
+	}
+	var refs []ServiceRef
+	if w.prefix == "" {
+		for _, ref := range w.refs {
+			if z, ok := zones[ref.Service]; ok && (ref.Zone == "" || z[ref.Zone]) {
+				refs = append(refs, ref)
+			}
+		}
+		return refs
+	}
+	for service := range zones {
+		if strings.HasPrefix(service, w.prefix) && (w.visible == nil || w.visible(service)) {
+			refs = append(refs, ServiceRef{Service: service})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Service < refs[j].Service })
+	return refs
+}
+
+// all refs of the watch, services with prefix are listed
+func (w *multiWatch) all(ctx context.Context, ctrl *ServiceCtrl) ([]ServiceRef, error) {
+	if w.prefix == "" {
+		return w.refs, nil
+	}
+	prefix := w.keyPrefix(ctrl)
+	etcdCtx, span := startEtcdSpan(ctx, "Get", prefix)
+	resp, err := ctrl.etcdClient.Get(etcdCtx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	span.FinishWithError(err)
+	if err != nil {
+		return nil, utils.CleanErr(err, "watch fail", "list services(%s) fail: %v", prefix, err)
+	}
+	var events []*clientv3.Event
+	for _, kv := range resp.Kvs {
+		events = append(events, &clientv3.Event{Kv: kv})
+	}
+	return w.changed(ctrl, events), nil
+}
+
+// WatchMulti wait for changes of services of refs, or of all services named with prefix,
+// since revision, returning the changed ones at one revision. If revision is 0 all of them
+// are returned at once, watch again from Revision+1. visible filters services of prefix
+func (ctrl *ServiceCtrl) WatchMulti(ctx context.Context, clientIP net.IP,
+	refs []ServiceRef, prefix string, revision int64, visible func(service string) bool) (*MultiWatchResult, error) {
+	if len(refs) == 0 && prefix == "" {
+		return nil, utils.Errorf(utils.EcodeMissingParam, "missing services or prefix")
+	}
+	if len(refs) > 0 && prefix != "" {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "services and prefix are exclusive")
+	}
+	if prefix != "" && !rValidServicePrefix.MatchString(prefix) {
+		return nil, utils.Errorf(utils.EcodeInvalidParam, "invalid prefix: %s", prefix)
+	}
+	for i := range refs {
+		if refs[i].Zone != "" {
+			if err := checkServiceZone(refs[i].Service, refs[i].Zone); err != nil {
+				return nil, err
+			}
+		} else if err := checkService(refs[i].Service); err != nil {
+			return nil, err
+		}
+	}
+	w := &multiWatch{refs: refs, prefix: prefix, visible: visible}
+	defer metrics.WatchStarted("service_multi")()
+	ctx, span := tracing.StartSpan(ctx, "services.WatchMulti")
+	span.SetAttribute("prefix", prefix)
+	defer span.Finish()
+
+	var changed []ServiceRef
+	if revision <= 0 {
+		var err error
+		if changed, err = w.all(ctx, ctrl); err != nil {
+			span.SetError(err)
+			return nil, err
+		}
+	} else {
+		key := w.keyPrefix(ctrl)
+		lastRevision := revision - 1
+		var events []*clientv3.Event
+		for len(changed) == 0 {
+			resp, ok := ctrl.hub.Watch(ctx, key, lastRevision+1)
+			if err := checkWatchResponse(ctx, resp, ok, lastRevision); err != nil {
+				span.SetError(err)
+				return nil, err
+			}
+			lastRevision = resp.Header.Revision
+			changed, events = w.changed(ctrl, resp.Events), resp.Events
+		}
+		if ctrl.coalesceWait(ctx) {
+			if resp, ok := ctrl.hub.pending(key, lastRevision+1); ok {
+				changed = w.changed(ctrl, append(events, resp.Events...))
+			}
+		}
+	}
+
+	result := &MultiWatchResult{Services: make([]MultiQueryItem, 0, len(changed))}
+	if len(changed) == 0 {
+		// nothing with prefix yet
+		rev, err := ctrl.watchStartRevision(ctx, w.keyPrefix(ctrl), 0)
+		if err != nil {
+			span.SetError(err)
+			return nil, err
+		}
+		result.Revision = rev - 1
+		return result, nil
+	}
+	keys := make([]string, 0, len(changed))
+	for i := range changed {
+		keys = append(keys, changed[i].key())
+	}
+	kvsMap, rev, err := ctrl.snapshot(ctx, keys)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	result.Revision = rev
+	for i, ref := range changed {
+		item := MultiQueryItem{Ref: ref}
+		if kvs := kvsMap[keys[i]]; len(kvs) > 0 {
+			if item.Service, err = ctrl.makeService(clientIP, keys[i], kvs); err != nil {
+				return nil, err
+			}
+		} else {
+			item.NotFound = true
+		}
+		result.Services = append(result.Services, item)
+	}
+	return result, nil
+}
@@ -0,0 +1,154 @@
+package snapshots
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/infrmods/xbus/logging"
+)
+
+// BackupConfig scheduled backups, a gzipped snapshot is written every Interval to Dir or the
+// S3 bucket, named Prefix + time; the newest Keep ones, and those within MaxAge (if not 0), are kept
+type BackupConfig struct {
+	Interval time.Duration `default:"1h"`
+	Prefix   string        `default:"xbus-"`
+	Keep     int           `default:"24"`
+	MaxAge   time.Duration `yaml:"max_age"`
+	Dir      string
+	S3       S3Config
+}
+
+const (
+	backupTimeFormat = "20060102T150405Z"
+	backupSuffix     = ".json.gz"
+)
+
+// NewStore store of config, nil if neither Dir nor S3 configured
+func (config *BackupConfig) NewStore() (Store, error) {
+	switch {
+	case config.Dir != "" && config.S3.Bucket != "":
+		return nil, fmt.Errorf("both backup dir and s3 configured")
+	case config.Dir != "":
+		return NewDirStore(config.Dir)
+	case config.S3.Bucket != "":
+		return NewS3Store(&config.S3)
+	}
+	return nil, nil
+}
+
+// backupTime time of a backup by its name
+func (config *BackupConfig) backupTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, config.Prefix) || !strings.HasSuffix(name, backupSuffix) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, config.Prefix), backupSuffix))
+	return t, err == nil
+}
+
+// Backup write a snapshot to store, returns its name
+func (s *Snapshotter) Backup(ctx context.Context, config *BackupConfig, store Store) (string, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	snapshot, err := s.Export(ctx, w)
+	if err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	name := config.Prefix + snapshot.Time.UTC().Format(backupTimeFormat) + backupSuffix
+	if err := store.Put(ctx, name, buf.Bytes()); err != nil {
+		return "", fmt.Errorf("put backup %s to %s fail: %v", name, store.Name(), err)
+	}
+	return name, nil
+}
+
+// Backups names of backups in store, oldest first
+func Backups(ctx context.Context, config *BackupConfig, store Store) ([]string, error) {
+	names, err := store.List(ctx, config.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := config.backupTime(name); ok {
+			backups = append(backups, name)
+		}
+	}
+	return backups, nil
+}
+
+// prune delete backups beyond retention
+func prune(ctx context.Context, config *BackupConfig, store Store) error {
+	backups, err := Backups(ctx, config, store)
+	if err != nil {
+		return err
+	}
+	keep := config.Keep
+	if keep < 1 {
+		keep = 1
+	}
+	for i := 0; i < len(backups)-keep; i++ {
+		if t, _ := config.backupTime(backups[i]); config.MaxAge > 0 && time.Since(t) < config.MaxAge {
+			continue
+		}
+		if err := store.Delete(ctx, backups[i]); err != nil {
+			return fmt.Errorf("delete backup %s fail: %v", backups[i], err)
+		}
+		logging.Infof("deleted expired backup %s", backups[i])
+	}
+	return nil
+}
+
+// ReadBackup read snapshot of backup name, the latest if empty
+func ReadBackup(ctx context.Context, config *BackupConfig, store Store, name string) (*Snapshot, error) {
+	if name == "" {
+		backups, err := Backups(ctx, config, store)
+		if err != nil {
+			return nil, err
+		}
+		if len(backups) == 0 {
+			return nil, fmt.Errorf("no backups in %s", store.Name())
+		}
+		name = backups[len(backups)-1]
+	}
+	body, err := store.Get(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("get backup %s fail: %v", name, err)
+	}
+	defer body.Close()
+	r, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup %s: %v", name, err)
+	}
+	return Read(r)
+}
+
+// RunBackups write backups every interval and prune expired ones until ctx done
+func (s *Snapshotter) RunBackups(ctx context.Context, config *BackupConfig, store Store) {
+	interval := config.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if name, err := s.Backup(ctx, config, store); err != nil {
+			logging.Errorf("backup fail: %v", err)
+		} else {
+			logging.Infof("backup %s written to %s", name, store.Name())
+			if err := prune(ctx, config, store); err != nil {
+				logging.Warningf("prune backups fail: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
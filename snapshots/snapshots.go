@@ -0,0 +1,110 @@
+package snapshots
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/infrmods/xbus/configs"
+	"github.com/infrmods/xbus/services"
+)
+
+// FormatVersion version of the snapshot format
+const FormatVersion = 1
+
+// Snapshot portable dump of all service zones (descs and endpoints) and configs at Revision
+type Snapshot struct {
+	Format   int                      `json:"format"`
+	Time     time.Time                `json:"time"`
+	Revision int64                    `json:"revision"`
+	Services []services.ServiceZoneV1 `json:"services"`
+	Configs  []configs.ConfigItem     `json:"configs"`
+}
+
+// ImportOptions options of imports, dynamic endpoints are bound to a new lease of
+// LeaseTTL, or skipped if 0; configs are skipped if SkipConfigs, otherwise tagged with ConfigTag
+type ImportOptions struct {
+	LeaseTTL    time.Duration
+	SkipConfigs bool
+	ConfigTag   string
+}
+
+// ImportResult counts of an import
+type ImportResult struct {
+	services.ImportStats
+	Configs int `json:"configs"`
+}
+
+// Snapshotter exports and imports snapshots of a registry
+type Snapshotter struct {
+	services *services.ServiceCtrl
+	configs  *configs.ConfigCtrl
+}
+
+// NewSnapshotter new snapshotter
+func NewSnapshotter(servs *services.ServiceCtrl, cfgs *configs.ConfigCtrl) *Snapshotter {
+	return &Snapshotter{services: servs, configs: cfgs}
+}
+
+// Take snapshot of services and configs at the same revision
+func (s *Snapshotter) Take(ctx context.Context) (*Snapshot, error) {
+	zones, rev, err := s.services.ExportZones(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	cfgs, _, err := s.configs.Export(ctx, rev)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Format: FormatVersion, Time: time.Now(), Revision: rev, Services: zones, Configs: cfgs}, nil
+}
+
+// Export write snapshot as json to w
+func (s *Snapshotter) Export(ctx context.Context, w io.Writer) (*Snapshot, error) {
+	snapshot, err := s.Take(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		return nil, fmt.Errorf("write snapshot fail: %v", err)
+	}
+	return snapshot, nil
+}
+
+// Read read snapshot from r
+func Read(r io.Reader) (*Snapshot, error) {
+	var snapshot Snapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %v", err)
+	}
+	if snapshot.Format != FormatVersion {
+		return nil, fmt.Errorf("unsupported snapshot format: %d", snapshot.Format)
+	}
+	return &snapshot, nil
+}
+
+// Restore put services and configs of snapshot, existing ones are overwritten, others kept
+func (s *Snapshotter) Restore(ctx context.Context, snapshot *Snapshot, options *ImportOptions) (*ImportResult, error) {
+	stats, err := s.services.ImportZones(ctx, snapshot.Services, options.LeaseTTL)
+	if err != nil {
+		return nil, err
+	}
+	result := ImportResult{ImportStats: *stats}
+	if !options.SkipConfigs {
+		if result.Configs, err = s.configs.Import(ctx, options.ConfigTag, snapshot.Configs); err != nil {
+			return &result, err
+		}
+	}
+	return &result, nil
+}
+
+// Import read snapshot from r and restore it
+func (s *Snapshotter) Import(ctx context.Context, r io.Reader, options *ImportOptions) (*ImportResult, error) {
+	snapshot, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.Restore(ctx, snapshot, options)
+}
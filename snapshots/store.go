@@ -0,0 +1,94 @@
+package snapshots
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Store storage of backups, names are sorted by time
+type Store interface {
+	Name() string
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// List names with prefix, sorted
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// DirStore backups in a local dir
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore new dir store, dir is created if not exists
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+// Name impl Store
+func (s *DirStore) Name() string {
+	return "dir(" + s.dir + ")"
+}
+
+func (s *DirStore) path(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name[0] == '.' {
+		return "", fmt.Errorf("invalid backup name: %s", name)
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+// Put impl Store, written to a temp file then renamed
+func (s *DirStore) Put(ctx context.Context, name string, data []byte) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Get impl Store
+func (s *DirStore) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// List impl Store
+func (s *DirStore) List(ctx context.Context, prefix string) ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if name := info.Name(); !info.IsDir() && name[0] != '.' && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Delete impl Store
+func (s *DirStore) Delete(ctx context.Context, name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -36,6 +36,26 @@ CREATE TABLE `app_config_states` (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
+--
+-- Table structure for table `app_tokens`
+--
+
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `app_tokens` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `app_id` bigint(20) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `description` varchar(512) NOT NULL,
+  `hash` varchar(64) NOT NULL,
+  `expire_time` datetime DEFAULT NULL,
+  `revoked` tinyint(1) NOT NULL DEFAULT '0',
+  `create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `app_id` (`app_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `apps`
 --
@@ -166,6 +186,66 @@ CREATE TABLE `perms` (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
+--
+-- Table structure for table `service_dependencies`
+--
+
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `service_dependencies` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `app` varchar(64) NOT NULL,
+  `service` varchar(240) NOT NULL,
+  `create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `app_service` (`app`,`service`) USING BTREE,
+  KEY `service` (`service`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `service_metadata`
+--
+
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `service_metadata` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `name` varchar(240) NOT NULL,
+  `owner` varchar(255) NOT NULL DEFAULT '',
+  `description` text NOT NULL,
+  `oncall` varchar(255) NOT NULL DEFAULT '',
+  `links` text NOT NULL,
+  `app_id` bigint(20) NOT NULL,
+  `create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `modify_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `name` (`name`) USING BTREE,
+  KEY `owner` (`owner`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `service_schemas`
+--
+
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `service_schemas` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `service` varchar(240) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `revision` bigint(20) NOT NULL,
+  `content` mediumtext NOT NULL,
+  `checksum` char(64) NOT NULL,
+  `app_id` bigint(20) NOT NULL,
+  `remark` varchar(128) NOT NULL DEFAULT '',
+  `create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `service_kind_revision` (`service`,`kind`,`revision`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `services`
 --
@@ -0,0 +1,43 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func newKafkaPublisher(config *KafkaConfig) *kafkaPublisher {
+	return &kafkaPublisher{writer: kafka.NewWriter(kafka.WriterConfig{
+		Brokers: config.Brokers,
+		Topic:   config.Topic,
+		// changes of a service keep their order in one partition
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: 10 * time.Millisecond,
+	})}
+}
+
+func (publisher *kafkaPublisher) Name() string {
+	return "kafka"
+}
+
+func (publisher *kafkaPublisher) Publish(ctx context.Context, msgs []Message) error {
+	kmsgs := make([]kafka.Message, 0, len(msgs))
+	for i := range msgs {
+		data, err := json.Marshal(&msgs[i])
+		if err != nil {
+			return err
+		}
+		kmsgs = append(kmsgs, kafka.Message{Key: []byte(msgs[i].Service), Value: data})
+	}
+	return publisher.writer.WriteMessages(ctx, kmsgs...)
+}
+
+func (publisher *kafkaPublisher) Close() error {
+	return publisher.writer.Close()
+}
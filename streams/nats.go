@@ -0,0 +1,47 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/infrmods/xbus/logging"
+	"github.com/nats-io/nats.go"
+)
+
+type natsPublisher struct {
+	subject string
+	conn    *nats.Conn
+}
+
+func newNATSPublisher(config *NATSConfig) (*natsPublisher, error) {
+	conn, err := nats.Connect(config.URL, nats.Name("xbus"), nats.MaxReconnects(-1),
+		nats.DisconnectHandler(func(_ *nats.Conn) {
+			logging.Warningf("nats disconnected, reconnecting")
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{subject: config.Subject, conn: conn}, nil
+}
+
+func (publisher *natsPublisher) Name() string {
+	return "nats"
+}
+
+func (publisher *natsPublisher) Publish(ctx context.Context, msgs []Message) error {
+	for i := range msgs {
+		data, err := json.Marshal(&msgs[i])
+		if err != nil {
+			return err
+		}
+		if err := publisher.conn.Publish(publisher.subject, data); err != nil {
+			return err
+		}
+	}
+	return publisher.conn.FlushWithContext(ctx)
+}
+
+func (publisher *natsPublisher) Close() error {
+	publisher.conn.Close()
+	return nil
+}
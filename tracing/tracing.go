@@ -0,0 +1,210 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/trace"
+)
+
+// TraceParentHeader w3c trace context header
+const TraceParentHeader = "traceparent"
+
+// SpanContext w3c compatible span context
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid is valid
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent format as traceparent header
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceParent parse traceparent header
+func ParseTraceParent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	if n, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || n != 16 {
+		return sc, false
+	}
+	if n, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || n != 8 {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// SpanData finished span
+type SpanData struct {
+	Name       string
+	Context    SpanContext
+	ParentID   [8]byte
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Err        error
+}
+
+// Exporter span exporter, e.g. an OpenTelemetry bridge
+type Exporter interface {
+	ExportSpan(span *SpanData)
+}
+
+var (
+	enabled   bool
+	exporters []Exporter
+	lock      sync.RWMutex
+)
+
+// Enable enable tracing
+func Enable() {
+	lock.Lock()
+	defer lock.Unlock()
+	enabled = true
+}
+
+// RegisterExporter register exporter
+func RegisterExporter(exporter Exporter) {
+	lock.Lock()
+	defer lock.Unlock()
+	exporters = append(exporters, exporter)
+}
+
+func isEnabled() bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return enabled
+}
+
+// Span span, nil span is a valid noop span
+type Span struct {
+	data SpanData
+	tr   trace.Trace
+	mu   sync.Mutex
+}
+
+type spanKey struct{}
+
+// FromContext span from context
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func newID(id []byte) {
+	rand.Read(id)
+}
+
+// StartSpan start span as child of the span in ctx
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	var remote SpanContext
+	if parent := FromContext(ctx); parent != nil {
+		remote = parent.data.Context
+	}
+	return StartSpanWithRemote(ctx, name, remote)
+}
+
+// StartSpanWithRemote start span with remote(or invalid) parent
+func StartSpanWithRemote(ctx context.Context, name string, parent SpanContext) (context.Context, *Span) {
+	if !isEnabled() {
+		return ctx, nil
+	}
+	span := &Span{data: SpanData{Name: name, Start: time.Now(), Attributes: make(map[string]string)}}
+	if parent.IsValid() {
+		span.data.Context.TraceID = parent.TraceID
+		span.data.Context.Sampled = parent.Sampled
+		span.data.ParentID = parent.SpanID
+	} else {
+		newID(span.data.Context.TraceID[:])
+		span.data.Context.Sampled = true
+	}
+	newID(span.data.Context.SpanID[:])
+
+	family := name
+	if i := strings.IndexAny(name, " ."); i > 0 {
+		family = name[:i]
+	}
+	span.tr = trace.New(family, name)
+	span.tr.LazyPrintf("trace_id=%x span_id=%x", span.data.Context.TraceID, span.data.Context.SpanID)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Context span context
+func (span *Span) Context() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+	return span.data.Context
+}
+
+// SetAttribute set attribute
+func (span *Span) SetAttribute(key, value string) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.data.Attributes[key] = value
+	span.tr.LazyPrintf("%s=%s", key, value)
+}
+
+// SetError set error
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.data.Err = err
+	span.tr.LazyPrintf("error: %v", err)
+	span.tr.SetError()
+}
+
+// Finish finish span
+func (span *Span) Finish() {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	span.data.End = time.Now()
+	data := span.data
+	span.mu.Unlock()
+	span.tr.Finish()
+
+	if data.Context.Sampled {
+		lock.RLock()
+		defer lock.RUnlock()
+		for _, exporter := range exporters {
+			exporter.ExportSpan(&data)
+		}
+	}
+}
+
+// FinishWithError set error(if any) and finish span
+func (span *Span) FinishWithError(err error) {
+	span.SetError(err)
+	span.Finish()
+}
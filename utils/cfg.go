@@ -4,9 +4,20 @@ import (
 	"time"
 )
 
-// ETCDConfig etcd config
+// ETCDConfig etcd config, with Username and Password the auth token is
+// refreshed every AuthRefresh to keep it valid for watches, the client
+// key pair of CertFile and KeyFile is reloaded on changes; endpoints are
+// resolved from SRV records of DiscoverySRV every DiscoveryInterval if set
 type ETCDConfig struct {
-	Endpoints []string      `default:"[\"127.0.0.1:2379\"]"`
-	Timeout   time.Duration `default:"5s"`
-	CACert    string
+	Endpoints         []string      `default:"[\"127.0.0.1:2379\"]"`
+	DiscoverySRV      string        `yaml:"discovery_srv"`
+	DiscoveryInterval time.Duration `default:"1m" yaml:"discovery_interval"`
+	Timeout           time.Duration `default:"5s"`
+	CACert            string
+	CertFile          string `yaml:"cert_file"`
+	KeyFile           string `yaml:"key_file"`
+	Username          string
+	Password          string
+	AuthRefresh       time.Duration `default:"1m" yaml:"auth_refresh"`
+	Retry             ETCDRetryConfig
 }
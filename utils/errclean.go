@@ -4,7 +4,8 @@ import (
 	"context"
 
 	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
-	"github.com/golang/glog"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 )
@@ -20,29 +21,30 @@ func GetErrCode(err error) codes.Code {
 	return code
 }
 
-// CleanErrWithCode clean err with code
+// CleanErrWithCode clean err with code, the returned *Error wraps err
 func CleanErrWithCode(err error, sysErrRet, sysErrformat string, args ...interface{}) (codes.Code, error) {
 	code := GetErrCode(err)
+	metrics.EtcdErrors.WithLabelValues(code.String()).Inc()
 	if code != codes.Unknown {
 		switch code {
 		case codes.NotFound:
-			return code, NewError(EcodeNotFound, "")
+			return code, Wrap(err, EcodeNotFound, "")
 		case codes.DeadlineExceeded:
-			return code, NewError(EcodeDeadlineExceeded, "")
+			return code, Wrap(err, EcodeDeadlineExceeded, "")
 		case codes.Canceled:
-			return code, NewError(EcodeCanceled, "")
+			return code, Wrap(err, EcodeCanceled, "")
 		}
 	}
 
 	switch err {
 	case context.DeadlineExceeded:
-		return code, NewError(EcodeDeadlineExceeded, "")
+		return code, Wrap(err, EcodeDeadlineExceeded, "")
 	case context.Canceled:
-		return code, NewError(EcodeCanceled, "")
+		return code, Wrap(err, EcodeCanceled, "")
 	}
 
-	glog.Errorf(sysErrformat, args...)
-	return code, NewError(EcodeSystemError, sysErrRet)
+	logging.Errorf(sysErrformat, args...)
+	return code, Wrap(err, EcodeSystemError, sysErrRet)
 }
 
 // CleanErr clean err
@@ -25,6 +25,10 @@ const (
 	EcodeInvalidVersion = "INVALID_VERSION"
 	// EcodeInvalidAddress INVALID_ADDRESS
 	EcodeInvalidAddress = "INVALID_ADDRESS"
+	// EcodeUnresolvableAddress UNRESOLVABLE_ADDRESS
+	EcodeUnresolvableAddress = "UNRESOLVABLE_ADDRESS"
+	// EcodeUnreachableAddress UNREACHABLE_ADDRESS
+	EcodeUnreachableAddress = "UNREACHABLE_ADDRESS"
 	// EcodeInvalidEndpoint INVALID_ENDPOINT
 	EcodeInvalidEndpoint = "INVALID_ENDPOINT"
 	// EcodeDamagedEndpointValue DAMAGED_ENDPOINT_VALUE
@@ -47,23 +51,59 @@ const (
 	EcodeNotPermitted = "NOT_PERMITTED"
 	// EcodeEtcdWatchFailed ETCD_WATCH_FAILED
 	EcodeEtcdWatchFailed = "ETCD_WATCH_FAILED"
+	// EcodeEndpointUnverified ENDPOINT_UNVERIFIED
+	EcodeEndpointUnverified = "ENDPOINT_UNVERIFIED"
+	// EcodeRevisionCompacted REVISION_COMPACTED
+	EcodeRevisionCompacted = "REVISION_COMPACTED"
+	// EcodeInstanceConflict INSTANCE_CONFLICT
+	EcodeInstanceConflict = "INSTANCE_CONFLICT"
+	// EcodeDuplicateAddress DUPLICATE_ADDRESS
+	EcodeDuplicateAddress = "DUPLICATE_ADDRESS"
+	// EcodeQuotaExceeded QUOTA_EXCEEDED
+	EcodeQuotaExceeded = "QUOTA_EXCEEDED"
+	// EcodeRateLimited RATE_LIMITED
+	EcodeRateLimited = "RATE_LIMITED"
+	// EcodeEndpointChanged ENDPOINT_CHANGED
+	EcodeEndpointChanged = "ENDPOINT_CHANGED"
+	// EcodeServerStopping SERVER_STOPPING
+	EcodeServerStopping = "SERVER_STOPPING"
+	// EcodeInMaintenance IN_MAINTENANCE
+	EcodeInMaintenance = "IN_MAINTENANCE"
+	// EcodeInvalidToken INVALID_TOKEN
+	EcodeInvalidToken = "INVALID_TOKEN"
+	// EcodeHasEndpoints HAS_ENDPOINTS
+	EcodeHasEndpoints = "HAS_ENDPOINTS"
+	// EcodeSystemBusy SYSTEM_BUSY
+	EcodeSystemBusy = "SYSTEM_BUSY"
 )
 
-// Error error
+// Error error with a code, can wrap a cause and works with errors.Is/As:
+// errors.Is(err, utils.ErrNotFound) matches by code, see Wrap, CodeOf
 type Error struct {
 	Code    string   `json:"code"`
 	Message string   `json:"message,omitempty"`
 	Keys    []string `json:"keys,omitempty"`
+	// Retryable hint for clients the same request may succeed later, see IsRetryableCode
+	Retryable bool `json:"retryable,omitempty"`
+
+	cause error
 }
 
 // NewError new error
 func NewError(code string, message string) *Error {
-	return &Error{Code: code, Message: message, Keys: nil}
+	return &Error{Code: code, Message: message, Retryable: IsRetryableCode(code)}
 }
 
 // Errorf errorf
 func Errorf(code, format string, args ...interface{}) *Error {
-	return &Error{Code: code, Message: fmt.Sprintf(format, args...), Keys: nil}
+	return NewError(code, fmt.Sprintf(format, args...))
+}
+
+// Wrap new error of code wrapping cause, which is not exposed to clients
+func Wrap(cause error, code, message string) *Error {
+	e := NewError(code, message)
+	e.cause = cause
+	return e
 }
 
 func (e *Error) Error() string {
@@ -73,14 +113,36 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("[%s]: %s", e.Code, e.Message)
 }
 
+// Unwrap the wrapped cause, for errors.Is/As
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is match errors of the same code, and message if target has one; the only Is of coded
+// errors, the others (e.g. services.WatchError) unwrap to an *Error
+func (e *Error) Is(target error) bool {
+	if t, ok := target.(*Error); ok {
+		return t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+	}
+	return false
+}
+
+// ErrorCode code of the error, see CodeOf
+func (e *Error) ErrorCode() string {
+	return e.Code
+}
+
+// ErrNotFound matches errors of NOT_FOUND with errors.Is
+var ErrNotFound = &Error{Code: EcodeNotFound}
+
 // NewSystemError new system error
 func NewSystemError(msg string) *Error {
-	return &Error{Code: EcodeSystemError, Message: msg, Keys: nil}
+	return NewError(EcodeSystemError, msg)
 }
 
 // SystemErrorf system errorf
 func SystemErrorf(format string, args ...interface{}) *Error {
-	return &Error{Code: EcodeSystemError, Message: fmt.Sprintf(format, args...), Keys: nil}
+	return NewError(EcodeSystemError, fmt.Sprintf(format, args...))
 }
 
 // NewNotPermittedError new not permitted error
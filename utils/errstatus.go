@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Coder errors with a code, e.g. *Error, services.WatchError
+type Coder interface {
+	ErrorCode() string
+}
+
+// CodeOf code of err or the first coded error it wraps, SYSTEM_ERROR if none
+func CodeOf(err error) string {
+	var coder Coder
+	if errors.As(err, &coder) {
+		return coder.ErrorCode()
+	}
+	return EcodeSystemError
+}
+
+// IsRetryableCode whether requests failed with code may succeed if retried as is,
+// possibly on another server
+func IsRetryableCode(code string) bool {
+	switch code {
+	case EcodeSystemError, EcodeDeadlineExceeded, EcodeTooManyAttempts, EcodeEtcdWatchFailed,
+		EcodeRateLimited, EcodeServerStopping, EcodeUnreachableAddress, EcodeSystemBusy:
+		return true
+	}
+	return false
+}
+
+// IsRetryable whether err is retryable, by its hint or its code
+func IsRetryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) && e.Retryable {
+		return true
+	}
+	return IsRetryableCode(CodeOf(err))
+}
+
+// HTTPStatusOf http status of code
+func HTTPStatusOf(code string) int {
+	switch code {
+	case EcodeNotFound:
+		return http.StatusNotFound
+	case EcodeNotPermitted, EcodeQuotaExceeded, EcodeEndpointUnverified:
+		return http.StatusForbidden
+	case EcodeInvalidToken:
+		return http.StatusUnauthorized
+	case EcodeNameDuplicated, EcodeInstanceConflict, EcodeDuplicateAddress,
+		EcodeEndpointChanged, EcodeChangedServiceDesc, EcodeTooManyAttempts, EcodeHasEndpoints:
+		return http.StatusConflict
+	case EcodeRevisionCompacted, EcodeDeleted:
+		return http.StatusGone
+	case EcodeRateLimited:
+		return http.StatusTooManyRequests
+	case EcodeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case EcodeCanceled:
+		// client closed request, as of nginx and grpc-gateway
+		return 499
+	case EcodeSystemError, EcodeEtcdWatchFailed, EcodeServerStopping, EcodeInMaintenance, EcodeSystemBusy:
+		return http.StatusServiceUnavailable
+	}
+	if strings.HasPrefix(code, "INVALID_") || strings.HasPrefix(code, "MISSING_") ||
+		code == EcodeUnresolvableAddress || code == EcodeUnreachableAddress || code == EcodeDamagedEndpointValue {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// HTTPStatus http status of the error
+func (e *Error) HTTPStatus() int {
+	return HTTPStatusOf(e.Code)
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorMatching(t *testing.T) {
+	err := fmt.Errorf("lookup: %w", Wrap(context.DeadlineExceeded, EcodeNotFound, "no such lease"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatal("wrapped NOT_FOUND not matched")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("cause not matched")
+	}
+	if errors.Is(err, &Error{Code: EcodeNotFound, Message: "other"}) {
+		t.Fatal("matched by a different message")
+	}
+	if code := CodeOf(err); code != EcodeNotFound {
+		t.Fatalf("code of wrapped error: %s", code)
+	}
+	if code := CodeOf(errors.New("plain")); code != EcodeSystemError {
+		t.Fatalf("code of plain error: %s", code)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	if !IsRetryable(fmt.Errorf("plug: %w", NewError(EcodeRateLimited, ""))) {
+		t.Fatal("RATE_LIMITED not retryable")
+	}
+	if IsRetryable(NewError(EcodeInvalidParam, "")) {
+		t.Fatal("INVALID_PARAM retryable")
+	}
+	if !IsRetryable(&Error{Code: EcodeInvalidParam, Retryable: true}) {
+		t.Fatal("hint ignored")
+	}
+}
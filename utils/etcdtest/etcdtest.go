@@ -0,0 +1,62 @@
+// Package etcdtest embedded etcd server for tests
+package etcdtest
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+)
+
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen fail: %v", err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// Start start a single node etcd in a temp dir, returns a client of it;
+// both are closed and the dir removed on cleanup, call the returned func
+func Start(t *testing.T) (*clientv3.Client, func()) {
+	dir, err := ioutil.TempDir("", "etcdtest")
+	if err != nil {
+		t.Fatalf("create temp dir fail: %v", err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	cfg.LogPkgLevels = "*=C"
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("start etcd fail: %v", err)
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatal("etcd not ready")
+	}
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("new etcd client fail: %v", err)
+	}
+	return client, func() {
+		client.Close()
+		e.Close()
+		os.RemoveAll(dir)
+	}
+}
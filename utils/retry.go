@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ETCDRetryConfig timeouts of each attempt of etcd requests (0 for the caller's ctx only),
+// and retries with exponential backoff on transient errors, like leader elections
+type ETCDRetryConfig struct {
+	ReadTimeout  time.Duration `default:"10s" yaml:"read_timeout"`
+	WriteTimeout time.Duration `default:"10s" yaml:"write_timeout"`
+	MaxAttempts  int           `default:"3" yaml:"max_attempts"`
+	Backoff      time.Duration `default:"100ms"`
+	MaxBackoff   time.Duration `default:"1s" yaml:"max_backoff"`
+}
+
+// isRetryable whether a failed request could be retried, writes are retried
+// only if surely not applied, reads on timeouts and unavailable endpoints too
+func isRetryable(err error, write bool) bool {
+	switch err {
+	case rpctypes.ErrNoLeader, rpctypes.ErrTooManyRequests:
+		return true
+	case rpctypes.ErrTimeout, rpctypes.ErrTimeoutDueToLeaderFail,
+		rpctypes.ErrTimeoutDueToConnectionLost, rpctypes.ErrUnhealthy, context.DeadlineExceeded:
+		return !write
+	}
+	if s, ok := status.FromError(err); ok {
+		return !write && (s.Code() == codes.Unavailable || s.Code() == codes.DeadlineExceeded)
+	}
+	return false
+}
+
+func (config *ETCDRetryConfig) do(ctx context.Context, op string, write bool, f func(context.Context) error) error {
+	timeout := config.ReadTimeout
+	if write {
+		timeout = config.WriteTimeout
+	}
+	backoff := config.Backoff
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		err := f(attemptCtx)
+		cancel()
+		if err == nil || attempt >= config.MaxAttempts || ctx.Err() != nil || !isRetryable(err, write) {
+			return err
+		}
+		sleep := backoff
+		if sleep > 0 {
+			sleep += time.Duration(rand.Int63n(int64(sleep)/2 + 1))
+		}
+		logging.Infof("etcd %s fail, retry in %v (attempt %d): %v", op, sleep, attempt, err)
+		metrics.EtcdRetries.WithLabelValues(op).Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(sleep):
+		}
+		if backoff *= 2; config.MaxBackoff > 0 && backoff > config.MaxBackoff {
+			backoff = config.MaxBackoff
+		}
+	}
+}
+
+// WrapRetry wrap kv and lease requests (except keepalive streams and watches)
+// of client with timeouts and retries of config
+func WrapRetry(client *clientv3.Client, config *ETCDRetryConfig) {
+	client.KV = &retryKV{kv: client.KV, config: config}
+	client.Lease = &retryLease{Lease: client.Lease, config: config}
+}
+
+type retryKV struct {
+	kv     clientv3.KV
+	config *ETCDRetryConfig
+}
+
+func (r *retryKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (resp *clientv3.PutResponse, err error) {
+	err = r.config.do(ctx, "put", true, func(ctx context.Context) error {
+		resp, err = r.kv.Put(ctx, key, val, opts...)
+		return err
+	})
+	return
+}
+
+func (r *retryKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (resp *clientv3.GetResponse, err error) {
+	err = r.config.do(ctx, "get", false, func(ctx context.Context) error {
+		resp, err = r.kv.Get(ctx, key, opts...)
+		return err
+	})
+	return
+}
+
+func (r *retryKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (resp *clientv3.DeleteResponse, err error) {
+	err = r.config.do(ctx, "delete", true, func(ctx context.Context) error {
+		resp, err = r.kv.Delete(ctx, key, opts...)
+		return err
+	})
+	return
+}
+
+func (r *retryKV) Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (resp *clientv3.CompactResponse, err error) {
+	err = r.config.do(ctx, "compact", true, func(ctx context.Context) error {
+		resp, err = r.kv.Compact(ctx, rev, opts...)
+		return err
+	})
+	return
+}
+
+func (r *retryKV) Do(ctx context.Context, op clientv3.Op) (resp clientv3.OpResponse, err error) {
+	err = r.config.do(ctx, "do", !op.IsGet(), func(ctx context.Context) error {
+		resp, err = r.kv.Do(ctx, op)
+		return err
+	})
+	return
+}
+
+func (r *retryKV) Txn(ctx context.Context) clientv3.Txn {
+	return &retryTxn{ctx: ctx, kv: r}
+}
+
+// retryTxn txn built on each attempt of Commit
+type retryTxn struct {
+	ctx   context.Context
+	kv    *retryKV
+	cmps  []clientv3.Cmp
+	thens []clientv3.Op
+	elses []clientv3.Op
+}
+
+func (txn *retryTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	txn.cmps = append(txn.cmps, cs...)
+	return txn
+}
+
+func (txn *retryTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	txn.thens = append(txn.thens, ops...)
+	return txn
+}
+
+func (txn *retryTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	txn.elses = append(txn.elses, ops...)
+	return txn
+}
+
+func (txn *retryTxn) Commit() (resp *clientv3.TxnResponse, err error) {
+	err = txn.kv.config.do(txn.ctx, "txn", true, func(ctx context.Context) error {
+		resp, err = txn.kv.kv.Txn(ctx).If(txn.cmps...).Then(txn.thens...).Else(txn.elses...).Commit()
+		return err
+	})
+	return
+}
+
+type retryLease struct {
+	clientv3.Lease
+	config *ETCDRetryConfig
+}
+
+func (r *retryLease) Grant(ctx context.Context, ttl int64) (resp *clientv3.LeaseGrantResponse, err error) {
+	err = r.config.do(ctx, "lease_grant", true, func(ctx context.Context) error {
+		resp, err = r.Lease.Grant(ctx, ttl)
+		return err
+	})
+	return
+}
+
+func (r *retryLease) Revoke(ctx context.Context, id clientv3.LeaseID) (resp *clientv3.LeaseRevokeResponse, err error) {
+	err = r.config.do(ctx, "lease_revoke", true, func(ctx context.Context) error {
+		resp, err = r.Lease.Revoke(ctx, id)
+		return err
+	})
+	return
+}
+
+func (r *retryLease) TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (resp *clientv3.LeaseTimeToLiveResponse, err error) {
+	err = r.config.do(ctx, "lease_ttl", false, func(ctx context.Context) error {
+		resp, err = r.Lease.TimeToLive(ctx, id, opts...)
+		return err
+	})
+	return
+}
+
+func (r *retryLease) KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (resp *clientv3.LeaseKeepAliveResponse, err error) {
+	err = r.config.do(ctx, "lease_keepalive", false, func(ctx context.Context) error {
+		resp, err = r.Lease.KeepAliveOnce(ctx, id)
+		return err
+	})
+	return
+}
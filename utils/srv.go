@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResolveEndpoints etcd endpoints, from SRV records _etcd-client._tcp.DiscoverySRV
+// (_etcd-client-ssl with tls) if set, otherwise Endpoints, sorted
+func (config *ETCDConfig) ResolveEndpoints() ([]string, error) {
+	if config.DiscoverySRV == "" {
+		return config.Endpoints, nil
+	}
+	service := "etcd-client"
+	if config.CACert != "" || config.CertFile != "" {
+		service = "etcd-client-ssl"
+	}
+	_, addrs, err := net.LookupSRV(service, "tcp", config.DiscoverySRV)
+	if err != nil {
+		return nil, fmt.Errorf("lookup srv of %s fail: %v", config.DiscoverySRV, err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no srv records of %s", config.DiscoverySRV)
+	}
+	endpoints := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		host := strings.TrimSuffix(addr.Target, ".")
+		endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(int(addr.Port))))
+	}
+	sort.Strings(endpoints)
+	return endpoints, nil
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/infrmods/xbus/logging"
+)
+
+// reloadCheckInterval min interval between checks of key pair files
+const reloadCheckInterval = 5 * time.Second
+
+// KeyPairReloader x509 key pair loaded from files, reloaded on handshakes after
+// the files changed, the loaded one is kept if the new one is invalid
+type KeyPairReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewKeyPairReloader new key pair reloader, fails if the key pair is invalid
+func NewKeyPairReloader(certFile, keyFile string) (*KeyPairReloader, error) {
+	r := &KeyPairReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if r.cert, err = loadKeyPair(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	r.modTime, r.checkedAt = modTime, time.Now()
+	return r, nil
+}
+
+// filesModTime latest modification time of cert and key file
+func (r *KeyPairReloader) filesModTime() (time.Time, error) {
+	var modTime time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTime, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+func loadKeyPair(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load key pair(%s, %s) fail: %v", certFile, keyFile, err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("parse cert(%s) fail: %v", certFile, err)
+	}
+	if now := time.Now(); now.After(cert.Leaf.NotAfter) || now.Before(cert.Leaf.NotBefore) {
+		return nil, fmt.Errorf("cert(%s) not valid now, valid from %v to %v", certFile,
+			cert.Leaf.NotBefore, cert.Leaf.NotAfter)
+	}
+	return &cert, nil
+}
+
+// Certificate current key pair, reloaded if the files changed
+func (r *KeyPairReloader) Certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) < reloadCheckInterval {
+		return r.cert
+	}
+	r.checkedAt = time.Now()
+	modTime, err := r.filesModTime()
+	if err != nil {
+		logging.Warningf("check key pair(%s, %s) fail: %v", r.certFile, r.keyFile, err)
+		return r.cert
+	}
+	if modTime.Equal(r.modTime) {
+		return r.cert
+	}
+	cert, err := loadKeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		logging.Errorf("reload key pair fail, keep the loaded one: %v", err)
+		return r.cert
+	}
+	logging.Infof("key pair(%s, %s) reloaded, valid until %v", r.certFile, r.keyFile, cert.Leaf.NotAfter)
+	r.cert, r.modTime = cert, modTime
+	return r.cert
+}
+
+// GetCertificate for tls.Config of servers
+func (r *KeyPairReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// GetClientCertificate for tls.Config of clients
+func (r *KeyPairReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// TLSConfig tls config of etcd clients, nil if neither CACert nor CertFile set
+func (config *ETCDConfig) TLSConfig() (*tls.Config, error) {
+	if config.CACert == "" && config.CertFile == "" && config.KeyFile == "" {
+		return nil, nil
+	}
+	tlsConfig := new(tls.Config)
+	if config.CACert != "" {
+		cert, err := ReadPEMCertificate(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		tlsConfig.RootCAs.AddCert(cert)
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, fmt.Errorf("cert_file and key_file should be both set")
+		}
+		reloader, err := NewKeyPairReloader(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return tlsConfig, nil
+}
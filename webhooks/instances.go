@@ -0,0 +1,154 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/metrics"
+	"github.com/infrmods/xbus/services"
+)
+
+// instanceCheck pending count check of a zone (service/zone) or a service (all zones)
+type instanceCheck struct {
+	service string
+	zone    string
+	min     int
+	address string
+}
+
+func (check *instanceCheck) subject() string {
+	if check.zone == "" {
+		return check.service
+	}
+	return check.service + "/" + check.zone
+}
+
+func (check *instanceCheck) scope() string {
+	if check.zone == "" {
+		return "service"
+	}
+	return "zone"
+}
+
+// checkInstances check zone of change for zero endpoints, and the service for its minimum,
+// on unplugs, or on plugs of the reported ones for recovery
+func (dispatcher *Dispatcher) checkInstances(ctrl *services.ServiceCtrl, change *services.Change) {
+	checks := []*instanceCheck{{service: change.Service, zone: change.Zone, min: 1, address: change.Address}}
+	config, _ := dispatcher.current()
+	if min := config.minimum(change.Service); min > 0 {
+		checks = append(checks, &instanceCheck{service: change.Service, min: min, address: change.Address})
+	}
+	for _, check := range checks {
+		if change.Type == services.ChangePlug && !dispatcher.isLow(check.subject()) {
+			continue
+		}
+		dispatcher.scheduleInstanceCheck(ctrl, check)
+	}
+}
+
+// rebuildInstances rebuild the reported zones and services from current counts on becoming
+// the leader, so the state reported by the previous leader isn't reported again
+// and their recoveries are reported
+func (dispatcher *Dispatcher) rebuildInstances(ctx context.Context, ctrl *services.ServiceCtrl) {
+	ctx, cancel := context.WithTimeout(ctx, countTimeout)
+	defer cancel()
+	counts, err := ctrl.EndpointCounts(ctx)
+	if err != nil {
+		logging.Warningf("count endpoints for instances state fail: %v", err)
+		return
+	}
+	config, _ := dispatcher.current()
+	low := make(map[string]bool)
+	totals := make(map[string]int)
+	zones := 0
+	for _, count := range counts {
+		if count.Endpoints == 0 {
+			low[count.Service+"/"+count.Zone] = true
+			zones++
+		}
+		totals[count.Service] += count.Endpoints
+	}
+	for service, total := range totals {
+		if total < config.minimum(service) {
+			low[service] = true
+		}
+	}
+	dispatcher.instMu.Lock()
+	dispatcher.instLow = low
+	dispatcher.instMu.Unlock()
+	metrics.InstancesLow.WithLabelValues("zone").Set(float64(zones))
+	metrics.InstancesLow.WithLabelValues("service").Set(float64(len(low) - zones))
+}
+
+func (dispatcher *Dispatcher) isLow(subject string) bool {
+	dispatcher.instMu.Lock()
+	defer dispatcher.instMu.Unlock()
+	return dispatcher.instLow[subject]
+}
+
+// scheduleInstanceCheck count after InstancesDebounce, so short drops (e.g. rolling restarts)
+// are not reported; checks of a subject pending are merged
+func (dispatcher *Dispatcher) scheduleInstanceCheck(ctrl *services.ServiceCtrl, check *instanceCheck) {
+	config, _ := dispatcher.current()
+	if config.InstancesDebounce <= 0 {
+		dispatcher.runInstanceCheck(ctrl, check)
+		return
+	}
+	subject := check.subject()
+	dispatcher.instMu.Lock()
+	defer dispatcher.instMu.Unlock()
+	if pending := dispatcher.instCheck[subject]; pending != nil {
+		pending.address = check.address
+		return
+	}
+	dispatcher.instCheck[subject] = check
+	time.AfterFunc(config.InstancesDebounce, func() {
+		dispatcher.instMu.Lock()
+		delete(dispatcher.instCheck, subject)
+		dispatcher.instMu.Unlock()
+		dispatcher.runInstanceCheck(ctrl, check)
+	})
+}
+
+func (dispatcher *Dispatcher) runInstanceCheck(ctrl *services.ServiceCtrl, check *instanceCheck) {
+	ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+	defer cancel()
+	count, revision, err := ctrl.Count(ctx, check.service, check.zone)
+	if err != nil {
+		logging.Warningf("count endpoints of %s fail: %v", check.subject(), err)
+		return
+	}
+	subject := check.subject()
+	low := int(count) < check.min
+	dispatcher.instMu.Lock()
+	changed := dispatcher.instLow[subject] != low
+	if low {
+		dispatcher.instLow[subject] = true
+	} else {
+		delete(dispatcher.instLow, subject)
+	}
+	dispatcher.instMu.Unlock()
+	if !changed {
+		return
+	}
+
+	event := &Event{Subject: subject, Service: check.service, Zone: check.zone,
+		Address: check.address, Revision: revision}
+	switch {
+	case !low:
+		metrics.InstancesLow.WithLabelValues(check.scope()).Dec()
+		event.Type = EventInstancesRecovered
+		event.Message = fmt.Sprintf("%s recovered with %d instances", subject, count)
+	case check.zone != "":
+		metrics.InstancesLow.WithLabelValues(check.scope()).Inc()
+		event.Type = EventInstancesZero
+		event.Message = fmt.Sprintf("no instances of %s after %s unplugged", subject, check.address)
+	default:
+		metrics.InstancesLow.WithLabelValues(check.scope()).Inc()
+		event.Type = EventInstancesLow
+		event.Message = fmt.Sprintf("%d instances of %s, below minimum %d", count, subject, check.min)
+	}
+	dispatcher.Publish(event)
+}
@@ -0,0 +1,83 @@
+package webhooks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/infrmods/xbus/configs"
+	"github.com/infrmods/xbus/services"
+)
+
+const countTimeout = 5 * time.Second
+
+type flapState struct {
+	since time.Time
+	count int
+	fired bool
+}
+
+// WatchServices publish events of service changes while running, called before ctrl.RunChangeLog;
+// changes seen while not running (e.g. not the leader) are not counted for flapping or instances
+func (dispatcher *Dispatcher) WatchServices(ctrl *services.ServiceCtrl) {
+	dispatcher.mu.Lock()
+	dispatcher.serviceCtrl = ctrl
+	dispatcher.mu.Unlock()
+	ctrl.OnChange(func(change services.Change) {
+		if !dispatcher.running() || !dispatcher.Enabled() {
+			return
+		}
+		subject := change.Service + "/" + change.Zone
+		switch change.Type {
+		case services.ChangeCreate:
+			dispatcher.Publish(&Event{Type: EventServiceCreated, Subject: subject,
+				Service: change.Service, Zone: change.Zone, Revision: change.Revision,
+				Message: fmt.Sprintf("service %s created", subject)})
+		case services.ChangePlug, services.ChangeUnplug:
+			dispatcher.checkFlapping(&change)
+			dispatcher.checkInstances(ctrl, &change)
+		}
+	})
+}
+
+// checkFlapping fires once per window if an endpoint is plugged or unplugged FlapThreshold times
+func (dispatcher *Dispatcher) checkFlapping(change *services.Change) {
+	config, _ := dispatcher.current()
+	now := change.Time
+	for key, state := range dispatcher.flaps {
+		if now.Sub(state.since) > config.FlapWindow {
+			delete(dispatcher.flaps, key)
+		}
+	}
+	subject := change.Service + "/" + change.Zone + "/" + change.Address
+	state := dispatcher.flaps[subject]
+	if state == nil {
+		state = &flapState{since: now}
+		dispatcher.flaps[subject] = state
+	}
+	state.count++
+	if state.count >= config.FlapThreshold && !state.fired {
+		state.fired = true
+		dispatcher.Publish(&Event{Type: EventEndpointFlapping, Subject: subject,
+			Service: change.Service, Zone: change.Zone, Address: change.Address, Revision: change.Revision,
+			Message: fmt.Sprintf("endpoint %s plugged/unplugged %d times in %v",
+				subject, state.count, now.Sub(state.since).Truncate(time.Second))})
+	}
+}
+
+// WatchConfigs publish events of config changes of ctrl, which are watched only while running
+// with webhooks of config_changed (including ones added by reloads)
+func (dispatcher *Dispatcher) WatchConfigs(ctrl *configs.ConfigCtrl) {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.configCtrl = ctrl
+	dispatcher.syncConfigWatch()
+}
+
+func (dispatcher *Dispatcher) publishConfigChange(change configs.ConfigChange) {
+	msg := fmt.Sprintf("config %s changed, version %d", change.Name, change.Version)
+	if change.Deleted {
+		msg = fmt.Sprintf("config %s deleted", change.Name)
+	}
+	dispatcher.Publish(&Event{Type: EventConfigChanged, Subject: change.Name,
+		Config: change.Name, Revision: change.Revision, Message: msg})
+}
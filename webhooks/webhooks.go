@@ -0,0 +1,452 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infrmods/xbus/configs"
+	"github.com/infrmods/xbus/logging"
+	"github.com/infrmods/xbus/services"
+)
+
+// event types
+const (
+	EventServiceCreated     = "service_created"
+	EventInstancesZero      = "instances_zero"
+	EventEndpointFlapping   = "endpoint_flapping"
+	EventConfigChanged      = "config_changed"
+	EventInstancesLow       = "instances_low"
+	EventInstancesRecovered = "instances_recovered"
+)
+
+// Webhook events of Events (all if empty) whose subject matches Match are posted to URL,
+// signed with Secret if not empty, failed deliveries are retried MaxRetries times
+type Webhook struct {
+	URL        string
+	Secret     string
+	Events     []string
+	Match      string
+	MaxRetries int           `default:"5" yaml:"max_retries"`
+	Timeout    time.Duration `default:"10s"`
+	matchR     *regexp.Regexp
+}
+
+// key identity of the webhook config, senders of unchanged ones are kept on reload
+func (hook *Webhook) key() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%v", hook.URL, hook.Secret, strings.Join(hook.Events, ","),
+		hook.Match, hook.MaxRetries, hook.Timeout)
+}
+
+func (hook *Webhook) matches(event *Event) bool {
+	if hook.matchR != nil && !hook.matchR.MatchString(event.Subject) {
+		return false
+	}
+	return hook.subscribes(event.Type)
+}
+
+func (hook *Webhook) subscribes(typ string) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, t := range hook.Events {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// InstanceMinimum services matching Match should have at least Min endpoints in all zones
+type InstanceMinimum struct {
+	Match  string
+	Min    int
+	matchR *regexp.Regexp
+}
+
+// Config webhooks config, an endpoint plugged or unplugged FlapThreshold times
+// within FlapWindow is flapping; zero or low instances are reported if still so
+// after InstancesDebounce
+type Config struct {
+	Webhooks          []Webhook
+	FlapThreshold     int               `default:"4" yaml:"flap_threshold"`
+	FlapWindow        time.Duration     `default:"5m" yaml:"flap_window"`
+	InstanceMinimums  []InstanceMinimum `yaml:"instance_minimums"`
+	InstancesDebounce time.Duration     `default:"30s" yaml:"instances_debounce"`
+}
+
+// minimum of service by the first matched InstanceMinimum, 0 if none
+func (config *Config) minimum(service string) int {
+	for _, item := range config.InstanceMinimums {
+		if item.matchR.MatchString(service) {
+			return item.Min
+		}
+	}
+	return 0
+}
+
+func (config *Config) prepare() error {
+	if config.FlapThreshold <= 0 {
+		config.FlapThreshold = 4
+	}
+	if config.FlapWindow <= 0 {
+		config.FlapWindow = 5 * time.Minute
+	}
+	if config.InstancesDebounce < 0 {
+		config.InstancesDebounce = 0
+	}
+	for i := range config.InstanceMinimums {
+		item := &config.InstanceMinimums[i]
+		if item.Min <= 0 {
+			return fmt.Errorf("invalid min of instance minimum %d: %d", i, item.Min)
+		}
+		r, err := regexp.Compile(item.Match)
+		if err != nil {
+			return fmt.Errorf("invalid instance minimum match: %s", item.Match)
+		}
+		item.matchR = r
+	}
+	for i := range config.Webhooks {
+		hook := &config.Webhooks[i]
+		if hook.URL == "" {
+			return fmt.Errorf("missing url of webhook %d", i)
+		}
+		for _, typ := range hook.Events {
+			switch typ {
+			case EventServiceCreated, EventInstancesZero, EventEndpointFlapping, EventConfigChanged,
+				EventInstancesLow, EventInstancesRecovered:
+			default:
+				return fmt.Errorf("invalid event of webhook(%s): %s", hook.URL, typ)
+			}
+		}
+		if hook.Match != "" {
+			r, err := regexp.Compile(hook.Match)
+			if err != nil {
+				return fmt.Errorf("invalid webhook(%s) match: %s", hook.URL, hook.Match)
+			}
+			hook.matchR = r
+		}
+		if hook.Timeout <= 0 {
+			hook.Timeout = 10 * time.Second
+		}
+	}
+	return nil
+}
+
+// Event registry event, Subject is `service/zone`, `service/zone/address`, the service
+// (of instances_low) or the config name
+type Event struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Subject  string    `json:"subject"`
+	Service  string    `json:"service,omitempty"`
+	Zone     string    `json:"zone,omitempty"`
+	Address  string    `json:"address,omitempty"`
+	Config   string    `json:"config,omitempty"`
+	Revision int64     `json:"revision"`
+	Message  string    `json:"message"`
+}
+
+// delivery headers, the signature is `sha256=<hex>` of HMAC-SHA256 of `<timestamp>.<body>`
+const (
+	HeaderEvent     = "X-Xbus-Event"
+	HeaderDelivery  = "X-Xbus-Delivery"
+	HeaderTimestamp = "X-Xbus-Timestamp"
+	HeaderSignature = "X-Xbus-Signature"
+)
+
+// Sign signature of a delivery body at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+const (
+	queueSize       = 1000
+	minRetryBackoff = time.Second
+	maxRetryBackoff = time.Minute
+)
+
+type sender struct {
+	hook   *Webhook
+	queue  chan *Event
+	client http.Client
+	// stop stop the sender running, set by start
+	stop context.CancelFunc
+}
+
+func newSender(hook *Webhook) *sender {
+	return &sender{hook: hook, queue: make(chan *Event, queueSize), client: http.Client{Timeout: hook.Timeout}}
+}
+
+func (s *sender) start(ctx context.Context) {
+	ctx, s.stop = context.WithCancel(ctx)
+	go s.run(ctx)
+}
+
+// Dispatcher delivers events to webhooks, by one queue per webhook
+type Dispatcher struct {
+	flaps map[string]*flapState
+
+	mu      sync.Mutex
+	runCtx  context.Context
+	config  *Config
+	senders []*sender
+	// configCtrl set by WatchConfigs, its changes are watched while running with
+	// webhooks of config_changed, stopWatch stops the watch
+	configCtrl *configs.ConfigCtrl
+	stopWatch  context.CancelFunc
+	// serviceCtrl set by WatchServices, instance states are rebuilt from it by Run
+	serviceCtrl *services.ServiceCtrl
+
+	instMu    sync.Mutex
+	instLow   map[string]bool
+	instCheck map[string]*instanceCheck
+}
+
+// NewDispatcher new webhooks dispatcher
+func NewDispatcher(config *Config) (*Dispatcher, error) {
+	if err := config.prepare(); err != nil {
+		return nil, err
+	}
+	config = copyConfig(config)
+	dispatcher := &Dispatcher{config: config, flaps: make(map[string]*flapState),
+		instLow: make(map[string]bool), instCheck: make(map[string]*instanceCheck)}
+	for i := range config.Webhooks {
+		dispatcher.senders = append(dispatcher.senders, newSender(&config.Webhooks[i]))
+	}
+	return dispatcher, nil
+}
+
+// copyConfig copy of config not sharing webhooks with it
+func copyConfig(config *Config) *Config {
+	copied := *config
+	copied.Webhooks = append([]Webhook(nil), config.Webhooks...)
+	copied.InstanceMinimums = append([]InstanceMinimum(nil), config.InstanceMinimums...)
+	return &copied
+}
+
+func (dispatcher *Dispatcher) current() (*Config, []*sender) {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	return dispatcher.config, dispatcher.senders
+}
+
+// Enabled whether any webhook configured
+func (dispatcher *Dispatcher) Enabled() bool {
+	_, senders := dispatcher.current()
+	return len(senders) > 0
+}
+
+// Run deliver events until ctx done, events published while not running are dropped
+func (dispatcher *Dispatcher) Run(ctx context.Context) {
+	dispatcher.mu.Lock()
+	ctrl := dispatcher.serviceCtrl
+	dispatcher.mu.Unlock()
+	if ctrl != nil && dispatcher.Enabled() {
+		dispatcher.rebuildInstances(ctx, ctrl)
+	}
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.runCtx = ctx
+	for _, s := range dispatcher.senders {
+		s.start(ctx)
+	}
+	if dispatcher.stopWatch != nil {
+		dispatcher.stopWatch()
+		dispatcher.stopWatch = nil
+	}
+	dispatcher.syncConfigWatch()
+}
+
+// syncConfigWatch start or stop watching configs by whether any webhook of config_changed
+// is running, with mu locked
+func (dispatcher *Dispatcher) syncConfigWatch() {
+	wanted := false
+	if dispatcher.configCtrl != nil && dispatcher.runCtx != nil && dispatcher.runCtx.Err() == nil {
+		for _, s := range dispatcher.senders {
+			if s.hook.subscribes(EventConfigChanged) {
+				wanted = true
+				break
+			}
+		}
+	}
+	switch {
+	case wanted && dispatcher.stopWatch == nil:
+		ctx, cancel := context.WithCancel(dispatcher.runCtx)
+		dispatcher.stopWatch = cancel
+		go dispatcher.configCtrl.WatchChanges(ctx, dispatcher.publishConfigChange)
+	case !wanted && dispatcher.stopWatch != nil:
+		dispatcher.stopWatch()
+		dispatcher.stopWatch = nil
+	}
+}
+
+// PrepareReload prepare reloading webhooks and thresholds of config, returns the func
+// applying them, nothing is changed if it fails. Unchanged webhooks keep their queues,
+// events queued to removed ones are dropped
+func (dispatcher *Dispatcher) PrepareReload(config *Config) (func(), error) {
+	config = copyConfig(config)
+	if err := config.prepare(); err != nil {
+		return nil, err
+	}
+	return func() {
+		dispatcher.mu.Lock()
+		defer dispatcher.mu.Unlock()
+		old := make(map[string][]*sender)
+		for _, s := range dispatcher.senders {
+			key := s.hook.key()
+			old[key] = append(old[key], s)
+		}
+		running := dispatcher.runCtx != nil && dispatcher.runCtx.Err() == nil
+		senders := make([]*sender, 0, len(config.Webhooks))
+		for i := range config.Webhooks {
+			key := config.Webhooks[i].key()
+			if kept := old[key]; len(kept) > 0 {
+				old[key] = kept[1:]
+				senders = append(senders, kept[0])
+				continue
+			}
+			s := newSender(&config.Webhooks[i])
+			if running {
+				s.start(dispatcher.runCtx)
+			}
+			senders = append(senders, s)
+		}
+		for _, removed := range old {
+			for _, s := range removed {
+				if s.stop != nil {
+					s.stop()
+				}
+				if n := len(s.queue); n > 0 {
+					logging.Warningf("webhook(%s) removed, drop %d queued events", s.hook.URL, n)
+				}
+			}
+		}
+		dispatcher.config, dispatcher.senders = config, senders
+		dispatcher.syncConfigWatch()
+		logging.Infof("webhooks reloaded, %d webhooks", len(senders))
+	}, nil
+}
+
+func newEventID() string {
+	var data [16]byte
+	if _, err := rand.Read(data[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(data[:])
+}
+
+func (dispatcher *Dispatcher) running() bool {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	return dispatcher.runCtx != nil && dispatcher.runCtx.Err() == nil
+}
+
+// Publish queue event to matched webhooks, dropped if a queue is full
+func (dispatcher *Dispatcher) Publish(event *Event) {
+	if !dispatcher.running() {
+		return
+	}
+	_, senders := dispatcher.current()
+	if len(senders) == 0 {
+		return
+	}
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	logging.Infof("event %s: %s", event.Type, event.Message)
+	for _, s := range senders {
+		if !s.hook.matches(event) {
+			continue
+		}
+		select {
+		case s.queue <- event:
+		default:
+			logging.Warningf("webhook(%s) queue full, drop event %s(%s)", s.hook.URL, event.Type, event.Subject)
+		}
+	}
+}
+
+func (s *sender) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.queue:
+			s.deliver(ctx, event)
+		}
+	}
+}
+
+// deliver post event, retry with exponential backoff on fail
+func (s *sender) deliver(ctx context.Context, event *Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		logging.Errorf("marshal event fail: %v", err)
+		return
+	}
+	backoff := minRetryBackoff
+	for i := 0; ; i++ {
+		err := s.post(ctx, event, body)
+		if err == nil {
+			return
+		}
+		if i >= s.hook.MaxRetries {
+			logging.Warningf("deliver event %s(%s) to %s fail, give up: %v", event.Type, event.ID, s.hook.URL, err)
+			return
+		}
+		logging.Warningf("deliver event %s(%s) to %s fail, retry in %v: %v", event.Type, event.ID, s.hook.URL, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+func (s *sender) post(ctx context.Context, event *Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderDelivery, event.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if s.hook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(s.hook.Secret, timestamp, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}